@@ -7,17 +7,26 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
 	"github.com/ersantana/distributed-systems-learning/apps/api/internal/handlers"
 	"github.com/ersantana/distributed-systems-learning/apps/api/internal/simulation"
+	"github.com/ersantana/distributed-systems-learning/apps/api/internal/soak"
+	"github.com/ersantana/distributed-systems-learning/apps/api/internal/templates"
 	"github.com/ersantana/distributed-systems-learning/packages/protocol"
 )
 
 // Global simulation manager
 var simManager *simulation.Manager
 
+// Global template store
+var templateStore *templates.Store
+
+// Directory of the lesson files load_scenario starts by name
+var lessonsDir string
+
 func main() {
 	// Create hub
 	hub := handlers.NewHub()
@@ -26,6 +35,33 @@ func main() {
 	// Create simulation manager
 	simManager = simulation.NewManager(hub)
 
+	// Create template store
+	templatesDir := os.Getenv("TEMPLATES_DIR")
+	if templatesDir == "" {
+		templatesDir = "data/templates"
+	}
+	var err error
+	templateStore, err = templates.NewStore(templatesDir)
+	if err != nil {
+		log.Fatalf("Failed to open template store: %v", err)
+	}
+
+	// Lessons are read from their directory as they are loaded
+	lessonsDir = os.Getenv("LESSONS_DIR")
+	if lessonsDir == "" {
+		lessonsDir = "lessons"
+	}
+
+	// Stop runs every client has left, after a grace period to reconnect
+	abandonGrace := 5 * time.Minute
+	if grace := os.Getenv("ABANDON_GRACE"); grace != "" {
+		abandonGrace, err = time.ParseDuration(grace)
+		if err != nil || abandonGrace <= 0 {
+			log.Fatalf("Invalid ABANDON_GRACE %q", grace)
+		}
+	}
+	go simManager.CollectAbandoned(context.Background(), hub.ClientCount, abandonGrace)
+
 	// Set up message handler
 	hub.SetMessageHandler(handleMessage(hub))
 
@@ -38,6 +74,20 @@ func main() {
 	// WebSocket endpoint
 	mux.Handle("/ws", wsHandler)
 
+	// Simulation templates
+	handlers.NewTemplateHandler(templateStore).Register(mux)
+
+	// WebSocket trace downloads
+	handlers.NewTraceHandler(hub).Register(mux)
+
+	// Export and import of the current run's exact state
+	handlers.NewStateHandler(simManager).Register(mux)
+
+	// Soak mode: leak and drift detection for all-day runs
+	if interval := os.Getenv("SOAK_INTERVAL"); interval != "" {
+		startSoakMonitor(mux, hub, interval)
+	}
+
 	// Health check
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
@@ -64,6 +114,33 @@ func main() {
 				"two-phase-commit",
 				"consistency",
 				"crdt",
+				"queues",
+				"mistakes",
+				"zab",
+				"epaxos",
+				"locks",
+				"refcount",
+				"mutex",
+				"stabilization",
+				"election",
+				"chord",
+				"consistent-hashing",
+				"chain-replication",
+				"truetime",
+				"clock-sync",
+				"cap",
+				"percolator",
+				"calvin",
+				"hotstuff",
+				"nakamoto",
+				"swim",
+				"phi-accrual",
+				"heartbeat",
+				"session-guarantees",
+				"load-balancing",
+				"mapreduce",
+				"escrow",
+				"two-phase-locking",
 			},
 		})
 	})
@@ -136,6 +213,45 @@ func handleMessage(hub *handlers.Hub) func(clientID string, msgType string, data
 
 			// State is automatically broadcast by the manager
 
+		case protocol.MsgStartTemplate:
+			var msg protocol.StartTemplateRequest
+			if err := json.Unmarshal(data, &msg); err != nil {
+				sendError(hub, clientID, "parse_error", err.Error())
+				return
+			}
+			tpl, err := templateStore.Get(msg.Namespace, msg.Name)
+			if err != nil {
+				sendError(hub, clientID, "template_error", err.Error())
+				return
+			}
+			log.Printf("Starting template: %s/%s (project=%s, scenario=%s)", tpl.Namespace, tpl.Name, tpl.Project, tpl.Scenario)
+
+			if err := simManager.Start(tpl.Project, tpl.Scenario, tpl.StartRequest()); err != nil {
+				sendError(hub, clientID, "start_error", err.Error())
+				return
+			}
+
+		case protocol.MsgLoadScenario:
+			var msg protocol.LoadScenarioRequest
+			if err := json.Unmarshal(data, &msg); err != nil {
+				sendError(hub, clientID, "parse_error", err.Error())
+				return
+			}
+			lesson := msg.Scenario
+			if lesson == nil {
+				var err error
+				if lesson, err = simulation.ReadLesson(lessonsDir, msg.Name); err != nil {
+					sendError(hub, clientID, "scenario_error", err.Error())
+					return
+				}
+			}
+			log.Printf("Loading lesson: %s (project=%s, scenario=%s)", lesson.Name, lesson.Project, lesson.Scenario)
+
+			if err := simManager.LoadScenario(*lesson); err != nil {
+				sendError(hub, clientID, "start_error", err.Error())
+				return
+			}
+
 		case protocol.MsgPauseSimulation:
 			log.Println("Pausing simulation")
 			simManager.Pause()
@@ -159,7 +275,96 @@ func handleMessage(hub *handlers.Hub) func(clientID string, msgType string, data
 
 		case protocol.MsgStepForward:
 			log.Println("Stepping forward")
-			simManager.Step()
+			completed, err := simManager.Step()
+			if err != nil {
+				sendError(hub, clientID, "step_error", err.Error())
+				return
+			}
+			sendToClient(hub, clientID, completed)
+
+		case protocol.MsgStepN:
+			var msg protocol.StepNRequest
+			if err := json.Unmarshal(data, &msg); err != nil {
+				sendError(hub, clientID, "parse_error", err.Error())
+				return
+			}
+			log.Printf("Stepping forward %d times", msg.Count)
+			completed, err := simManager.StepN(msg.Count, func(progress *protocol.StepProgressResponse) {
+				sendToClient(hub, clientID, progress)
+			})
+			if err != nil {
+				sendError(hub, clientID, "step_error", err.Error())
+				return
+			}
+			sendToClient(hub, clientID, completed)
+
+		case protocol.MsgStepEvent:
+			var msg protocol.StepEventRequest
+			if err := json.Unmarshal(data, &msg); err != nil {
+				sendError(hub, clientID, "parse_error", err.Error())
+				return
+			}
+			log.Printf("Stepping through event %d", msg.Seq)
+			if err := simManager.StepEvent(msg.Seq); err != nil {
+				sendError(hub, clientID, "step_error", err.Error())
+			}
+
+		case protocol.MsgFastForward:
+			var msg protocol.FastForwardRequest
+			if err := json.Unmarshal(data, &msg); err != nil {
+				sendError(hub, clientID, "parse_error", err.Error())
+				return
+			}
+			log.Printf("Fast-forwarding %dms", msg.DurationMs)
+			if err := simManager.FastForward(time.Duration(msg.DurationMs) * time.Millisecond); err != nil {
+				sendError(hub, clientID, "fast_forward_error", err.Error())
+			}
+
+		case protocol.MsgSaveCheckpoint:
+			cp, err := simManager.SaveCheckpoint()
+			if err != nil {
+				sendError(hub, clientID, "checkpoint_error", err.Error())
+				return
+			}
+			log.Printf("Saved checkpoint at %s", time.Duration(cp.ElapsedNs))
+			sendToClient(hub, clientID, protocol.CheckpointResponse{
+				Type:       protocol.MsgCheckpoint,
+				Checkpoint: *cp,
+			})
+
+		case protocol.MsgLoadCheckpoint:
+			var msg protocol.LoadCheckpointRequest
+			if err := json.Unmarshal(data, &msg); err != nil {
+				sendError(hub, clientID, "parse_error", err.Error())
+				return
+			}
+			log.Printf("Loading checkpoint of %s at %s", msg.Checkpoint.Request.Project, time.Duration(msg.Checkpoint.ElapsedNs))
+			if err := simManager.LoadCheckpoint(msg.Checkpoint); err != nil {
+				sendError(hub, clientID, "checkpoint_error", err.Error())
+			}
+
+		case protocol.MsgSaveExecutionTrace:
+			tr, err := simManager.SaveExecutionTrace()
+			if err != nil {
+				sendError(hub, clientID, "execution_trace_error", err.Error())
+				return
+			}
+			log.Printf("Saved execution trace of %d events", len(tr.Entries))
+			sendToClient(hub, clientID, protocol.ExecutionTraceResponse{
+				Type:  protocol.MsgExecutionTrace,
+				Trace: *tr,
+			})
+
+		case protocol.MsgReplayExecutionTrace:
+			var msg protocol.ReplayExecutionTraceRequest
+			if err := json.Unmarshal(data, &msg); err != nil {
+				sendError(hub, clientID, "parse_error", err.Error())
+				return
+			}
+			log.Printf("Replaying execution trace of %s, %d events", msg.Trace.Request.Project, len(msg.Trace.Entries))
+			if err := simManager.ReplayExecutionTrace(msg.Trace); err != nil {
+				sendError(hub, clientID, "execution_trace_error", err.Error())
+			}
 
 		case protocol.MsgSetSpeed:
 			msg, err := protocol.ParseSetSpeed(data)
@@ -170,6 +375,72 @@ func handleMessage(hub *handlers.Hub) func(clientID string, msgType string, data
 			log.Printf("Setting speed: %f", msg.Speed)
 			simManager.SetSpeed(msg.Speed)
 
+		case protocol.MsgScheduleControl:
+			var msg protocol.ScheduleControlRequest
+			if err := json.Unmarshal(data, &msg); err != nil {
+				sendError(hub, clientID, "parse_error", err.Error())
+				return
+			}
+			log.Printf("Scheduling %d control changes", len(msg.Controls))
+			if err := simManager.ScheduleControls(msg.Controls, msg.Replace); err != nil {
+				sendError(hub, clientID, "schedule_error", err.Error())
+			}
+
+		case protocol.MsgSetBreakpoints:
+			var msg protocol.SetBreakpointsRequest
+			if err := json.Unmarshal(data, &msg); err != nil {
+				sendError(hub, clientID, "parse_error", err.Error())
+				return
+			}
+			log.Printf("Setting %d breakpoints", len(msg.Breakpoints))
+			if err := simManager.SetBreakpoints(msg.Breakpoints, msg.Replace); err != nil {
+				sendError(hub, clientID, "breakpoint_error", err.Error())
+			}
+
+		case protocol.MsgRunToCompletion:
+			var msg protocol.RunToCompletionRequest
+			if err := json.Unmarshal(data, &msg); err != nil {
+				sendError(hub, clientID, "parse_error", err.Error())
+				return
+			}
+			log.Printf("Running to completion with %d conditions", len(msg.Until))
+			if err := simManager.RunToCompletion(msg.Until, time.Duration(msg.MaxMs)*time.Millisecond); err != nil {
+				sendError(hub, clientID, "completion_error", err.Error())
+			}
+
+		case protocol.MsgStartFuzz:
+			var msg protocol.FuzzRequest
+			if err := json.Unmarshal(data, &msg); err != nil {
+				sendError(hub, clientID, "parse_error", err.Error())
+				return
+			}
+			log.Printf("Fuzzing %s/%s over %d runs", msg.Request.Project, msg.Request.Scenario, msg.Runs)
+			if err := simManager.Fuzz(msg); err != nil {
+				sendError(hub, clientID, "fuzz_error", err.Error())
+			}
+
+		case protocol.MsgExplore:
+			var msg protocol.ExploreRequest
+			if err := json.Unmarshal(data, &msg); err != nil {
+				sendError(hub, clientID, "parse_error", err.Error())
+				return
+			}
+			log.Printf("Exploring %s/%s %d events deep", msg.Request.Project, msg.Request.Scenario, msg.Depth)
+			if err := simManager.Explore(msg); err != nil {
+				sendError(hub, clientID, "explore_error", err.Error())
+			}
+
+		case protocol.MsgInstantReplay:
+			var msg protocol.InstantReplayRequest
+			if err := json.Unmarshal(data, &msg); err != nil {
+				sendError(hub, clientID, "parse_error", err.Error())
+				return
+			}
+			log.Printf("Instant replay: %gs at %gx", msg.Seconds, msg.Speed)
+			if err := simManager.InstantReplay(msg.Seconds, msg.Speed); err != nil {
+				sendError(hub, clientID, "replay_error", err.Error())
+			}
+
 		case protocol.MsgInjectCrash:
 			msg, err := protocol.ParseInjectCrash(data)
 			if err != nil {
@@ -210,6 +481,171 @@ func handleMessage(hub *handlers.Hub) func(clientID string, msgType string, data
 			log.Printf("Healing partition: %s -> %s", msg.From, msg.To)
 			simManager.HealPartition(msg.From, msg.To, msg.Bidirectional)
 
+		case protocol.MsgPartitionGroups:
+			var msg protocol.PartitionGroupsRequest
+			if err := json.Unmarshal(data, &msg); err != nil {
+				sendError(hub, clientID, "parse_error", err.Error())
+				return
+			}
+			log.Printf("Partitioning into %d groups", len(msg.Groups))
+			if err := simManager.PartitionGroups(msg.Groups); err != nil {
+				sendError(hub, clientID, "partition_error", err.Error())
+			}
+
+		case protocol.MsgHealAllPartitions:
+			log.Println("Healing all partitions")
+			if err := simManager.HealAllPartitions(); err != nil {
+				sendError(hub, clientID, "partition_error", err.Error())
+			}
+
+		case protocol.MsgInjectDelay:
+			var msg protocol.InjectDelayRequest
+			if err := json.Unmarshal(data, &msg); err != nil {
+				sendError(hub, clientID, "parse_error", err.Error())
+				return
+			}
+			log.Printf("Delaying node: %s by %dms", msg.NodeID, msg.DelayMs)
+			if err := simManager.InjectDelay(msg.NodeID, time.Duration(msg.DelayMs)*time.Millisecond); err != nil {
+				sendError(hub, clientID, "delay_error", err.Error())
+			}
+
+		case protocol.MsgInjectCorruption:
+			var msg protocol.InjectCorruptionRequest
+			if err := json.Unmarshal(data, &msg); err != nil {
+				sendError(hub, clientID, "parse_error", err.Error())
+				return
+			}
+			log.Printf("Corrupting %.0f%% of messages", msg.Probability*100)
+			if err := simManager.InjectCorruption(msg.Probability, msg.Corruptions); err != nil {
+				sendError(hub, clientID, "corruption_error", err.Error())
+			}
+
+		case protocol.MsgSetLinkLatency:
+			var msg protocol.SetLinkLatencyRequest
+			if err := json.Unmarshal(data, &msg); err != nil {
+				sendError(hub, clientID, "parse_error", err.Error())
+				return
+			}
+			log.Printf("Setting latency of link %s -> %s to %d-%dms", msg.From, msg.To, msg.MinLatencyMs, msg.MaxLatencyMs)
+			if err := simManager.SetLinkLatency(msg.LinkLatencySpec, msg.Reset); err != nil {
+				sendError(hub, clientID, "link_latency_error", err.Error())
+			}
+
+		case protocol.MsgSetLinkPacketLoss:
+			var msg protocol.SetLinkPacketLossRequest
+			if err := json.Unmarshal(data, &msg); err != nil {
+				sendError(hub, clientID, "parse_error", err.Error())
+				return
+			}
+			log.Printf("Setting packet loss of link %s -> %s to %.0f%%", msg.From, msg.To, msg.PacketLoss*100)
+			if err := simManager.SetLinkPacketLoss(msg.LinkPacketLossSpec, msg.Reset); err != nil {
+				sendError(hub, clientID, "link_loss_error", err.Error())
+			}
+
+		case protocol.MsgSetLinkBandwidth:
+			var msg protocol.SetLinkBandwidthRequest
+			if err := json.Unmarshal(data, &msg); err != nil {
+				sendError(hub, clientID, "parse_error", err.Error())
+				return
+			}
+			log.Printf("Setting bandwidth of link %s -> %s to %d B/s", msg.From, msg.To, msg.BytesPerSec)
+			if err := simManager.SetLinkBandwidth(msg.LinkBandwidthSpec, msg.Reset); err != nil {
+				sendError(hub, clientID, "bandwidth_error", err.Error())
+			}
+
+		case protocol.MsgSetFIFO:
+			var msg protocol.SetFIFORequest
+			if err := json.Unmarshal(data, &msg); err != nil {
+				sendError(hub, clientID, "parse_error", err.Error())
+				return
+			}
+			log.Printf("Setting FIFO delivery of link %s -> %s to %v", msg.From, msg.To, msg.FIFO)
+			if err := simManager.SetFIFO(msg.LinkFIFOSpec, msg.Reset); err != nil {
+				sendError(hub, clientID, "fifo_error", err.Error())
+			}
+
+		case protocol.MsgHoldMessages:
+			var msg protocol.HoldMessagesRequest
+			if err := json.Unmarshal(data, &msg); err != nil {
+				sendError(hub, clientID, "parse_error", err.Error())
+				return
+			}
+			log.Printf("Holding messages: %v", msg.Hold)
+			if err := simManager.HoldMessages(msg.Hold); err != nil {
+				sendError(hub, clientID, "hold_error", err.Error())
+			}
+
+		case protocol.MsgDeliverMessage:
+			var msg protocol.DeliverMessageRequest
+			if err := json.Unmarshal(data, &msg); err != nil {
+				sendError(hub, clientID, "parse_error", err.Error())
+				return
+			}
+			if err := simManager.DeliverMessage(msg.MessageID, msg.Drop); err != nil {
+				sendError(hub, clientID, "deliver_error", err.Error())
+			}
+
+		case protocol.MsgDropMessage:
+			var msg protocol.DropMessageRequest
+			if err := json.Unmarshal(data, &msg); err != nil {
+				sendError(hub, clientID, "parse_error", err.Error())
+				return
+			}
+			log.Printf("Dropping message %s", msg.MessageID)
+			if err := simManager.DropMessage(msg.MessageID); err != nil {
+				sendError(hub, clientID, "drop_error", err.Error())
+			}
+
+		case protocol.MsgSetNodeTickRate:
+			var msg protocol.SetNodeTickRateRequest
+			if err := json.Unmarshal(data, &msg); err != nil {
+				sendError(hub, clientID, "parse_error", err.Error())
+				return
+			}
+			log.Printf("Setting tick rate of node: %s to %dms", msg.NodeID, msg.TickMs)
+			if err := simManager.SetNodeTickRate(msg.NodeID, time.Duration(msg.TickMs)*time.Millisecond); err != nil {
+				sendError(hub, clientID, "tick_rate_error", err.Error())
+			}
+
+		case protocol.MsgSetTimeDilation:
+			var msg protocol.SetTimeDilationRequest
+			if err := json.Unmarshal(data, &msg); err != nil {
+				sendError(hub, clientID, "parse_error", err.Error())
+				return
+			}
+			log.Printf("Dilating time of node: %s by %v for %dms", msg.NodeID, msg.Factor, msg.DurationMs)
+			if err := simManager.SetTimeDilation(msg.NodeID, msg.Factor, time.Duration(msg.DurationMs)*time.Millisecond); err != nil {
+				sendError(hub, clientID, "time_dilation_error", err.Error())
+			}
+
+		case protocol.MsgUndoLastFailure:
+			log.Println("Undoing last failure")
+			if err := simManager.UndoLastFailure(); err != nil {
+				sendError(hub, clientID, "undo_error", err.Error())
+			}
+
+		case protocol.MsgSendClientRequest:
+			var msg protocol.ClientRequest
+			if err := json.Unmarshal(data, &msg); err != nil {
+				sendError(hub, clientID, "parse_error", err.Error())
+				return
+			}
+			log.Printf("Client request: %s %v", msg.Command, msg.Payload)
+			if err := simManager.HandleClientRequest(msg.Command, msg.Payload); err != nil {
+				sendError(hub, clientID, "client_request_error", err.Error())
+			}
+
+		case protocol.MsgInvokeNodeAction:
+			var msg protocol.InvokeNodeActionRequest
+			if err := json.Unmarshal(data, &msg); err != nil {
+				sendError(hub, clientID, "parse_error", err.Error())
+				return
+			}
+			log.Printf("Node action: %s %s %v", msg.NodeID, msg.Action, msg.Params)
+			if err := simManager.InvokeNodeAction(msg.NodeID, msg.Action, msg.Params); err != nil {
+				sendError(hub, clientID, "node_action_error", err.Error())
+			}
+
 		case protocol.MsgGetState:
 			log.Println("Getting state")
 			state := simManager.GetState()
@@ -217,6 +653,35 @@ func handleMessage(hub *handlers.Hub) func(clientID string, msgType string, data
 			sendResponse(hub, state)
 			log.Println("State response sent")
 
+		case protocol.MsgStartTrace:
+			var msg protocol.StartTraceRequest
+			if err := json.Unmarshal(data, &msg); err != nil {
+				sendError(hub, clientID, "parse_error", err.Error())
+				return
+			}
+			trace, ok := hub.StartTrace(clientID, msg.MaxBytes)
+			if !ok {
+				sendError(hub, clientID, "trace_error", "unknown client: "+clientID)
+				return
+			}
+			log.Printf("Tracing client %s", clientID)
+			sendToClient(hub, clientID, &protocol.TraceStatusResponse{
+				Type:   protocol.MsgTraceStatus,
+				Status: trace.Status(),
+			})
+
+		case protocol.MsgStopTrace:
+			trace := hub.StopTrace(clientID)
+			if trace == nil {
+				sendError(hub, clientID, "trace_error", "no trace for client: "+clientID)
+				return
+			}
+			log.Printf("Stopped tracing client %s", clientID)
+			sendToClient(hub, clientID, &protocol.TraceStatusResponse{
+				Type:   protocol.MsgTraceStatus,
+				Status: trace.Status(),
+			})
+
 		default:
 			log.Printf("Unknown message type: %s", msgType)
 			sendError(hub, clientID, "unknown_type", "Unknown message type: "+msgType)
@@ -224,12 +689,53 @@ func handleMessage(hub *handlers.Hub) func(clientID string, msgType string, data
 	}
 }
 
+// startSoakMonitor samples the server for leaks on the given interval
+// and serves the samples on /metrics. SOAK_WINDOW sets the samples of
+// growth that count as a leak, SOAK_FAIL_FAST=1 exits on the first one.
+func startSoakMonitor(mux *http.ServeMux, hub *handlers.Hub, interval string) {
+	every, err := time.ParseDuration(interval)
+	if err != nil || every <= 0 {
+		log.Fatalf("Invalid SOAK_INTERVAL %q", interval)
+	}
+	window, _ := strconv.Atoi(os.Getenv("SOAK_WINDOW"))
+
+	monitor := soak.NewMonitor(soak.Config{
+		Interval:     every,
+		Window:       window,
+		FailFast:     os.Getenv("SOAK_FAIL_FAST") == "1",
+		Clients:      hub.ClientCount,
+		TimelineSize: simManager.TimelineSize,
+		Session: func() (soak.Session, bool) {
+			stats, ok := simManager.RunStats()
+			return soak.Session{
+				ID:           stats.ID,
+				Project:      stats.Project,
+				Scenario:     stats.Scenario,
+				Events:       stats.Events,
+				StoredEvents: stats.StoredEvents,
+			}, ok
+		},
+		Broadcast: func(v interface{}) { sendResponse(hub, v) },
+	})
+	monitor.Register(mux)
+	go monitor.Run(context.Background())
+}
+
 func sendResponse(hub *handlers.Hub, v interface{}) {
 	if err := hub.BroadcastJSON(v); err != nil {
 		log.Printf("Error broadcasting response: %v", err)
 	}
 }
 
+func sendToClient(hub *handlers.Hub, clientID string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Error marshaling response: %v", err)
+		return
+	}
+	hub.SendToClient(clientID, data)
+}
+
 func sendError(hub *handlers.Hub, clientID, code, message string) {
 	response := protocol.NewError(code, message)
 	data, _ := json.Marshal(response)
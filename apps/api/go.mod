@@ -4,9 +4,11 @@ go 1.23
 
 require (
 	github.com/ersantana/distributed-systems-learning/packages/core v0.0.0
+	github.com/ersantana/distributed-systems-learning/packages/failure v0.0.0
 	github.com/ersantana/distributed-systems-learning/packages/network v0.0.0
 	github.com/ersantana/distributed-systems-learning/packages/protocol v0.0.0
 	github.com/ersantana/distributed-systems-learning/packages/simulation v0.0.0
+	github.com/ersantana/distributed-systems-learning/packages/visualization v0.0.0
 	github.com/google/uuid v1.6.0
 	github.com/gorilla/websocket v1.5.3
 )
@@ -18,3 +20,7 @@ replace github.com/ersantana/distributed-systems-learning/packages/simulation =>
 replace github.com/ersantana/distributed-systems-learning/packages/network => ../../packages/network
 
 replace github.com/ersantana/distributed-systems-learning/packages/core => ../../packages/core
+
+replace github.com/ersantana/distributed-systems-learning/packages/failure => ../../packages/failure
+
+replace github.com/ersantana/distributed-systems-learning/packages/visualization => ../../packages/visualization
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"log"
 	"sync"
+	"sync/atomic"
 
 	"github.com/gorilla/websocket"
 )
@@ -14,6 +15,9 @@ type Client struct {
 	conn *websocket.Conn
 	send chan []byte
 	id   string
+
+	// trace captures raw traffic while a client has tracing enabled
+	trace atomic.Pointer[TraceRecorder]
 }
 
 // Hub manages WebSocket connections and broadcasts
@@ -26,6 +30,10 @@ type Hub struct {
 
 	// Simulation manager callback
 	onMessage func(clientID string, msgType string, data []byte)
+
+	// Captured traces by client ID, kept after disconnect for download
+	traces     map[string]*TraceRecorder
+	traceOrder []string
 }
 
 // NewHub creates a new WebSocket hub
@@ -35,6 +43,7 @@ func NewHub() *Hub {
 		broadcast:  make(chan []byte, 256),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
+		traces:     make(map[string]*TraceRecorder),
 	}
 }
 
@@ -60,6 +69,9 @@ func (h *Hub) Run() {
 				close(client.send)
 			}
 			h.mu.Unlock()
+			if trace := client.trace.Load(); trace != nil {
+				trace.Stop()
+			}
 			log.Printf("Client disconnected: %s", client.id)
 
 		case message := <-h.broadcast:
@@ -123,6 +135,60 @@ func (h *Hub) SendToClient(clientID string, message []byte) {
 	}
 }
 
+// StartTrace begins capturing a client's traffic, replacing any earlier trace
+func (h *Hub) StartTrace(clientID string, maxBytes int) (*TraceRecorder, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for client := range h.clients {
+		if client.id != clientID {
+			continue
+		}
+		if old := client.trace.Load(); old != nil {
+			old.Stop()
+		}
+		trace := NewTraceRecorder(clientID, maxBytes)
+		client.trace.Store(trace)
+		h.retainTrace(trace)
+		return trace, true
+	}
+	return nil, false
+}
+
+// StopTrace ends a client's capture; the trace stays available for download
+func (h *Hub) StopTrace(clientID string) *TraceRecorder {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	trace := h.traces[clientID]
+	if trace != nil {
+		trace.Stop()
+	}
+	return trace
+}
+
+// Trace returns the latest trace captured for a client
+func (h *Hub) Trace(clientID string) *TraceRecorder {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.traces[clientID]
+}
+
+// retainTrace stores a trace, evicting the oldest beyond the retention limit
+// (must be called with lock held)
+func (h *Hub) retainTrace(trace *TraceRecorder) {
+	if _, exists := h.traces[trace.clientID]; !exists {
+		h.traceOrder = append(h.traceOrder, trace.clientID)
+	}
+	h.traces[trace.clientID] = trace
+
+	for len(h.traceOrder) > maxRetainedTraces {
+		oldest := h.traceOrder[0]
+		h.traceOrder = h.traceOrder[1:]
+		delete(h.traces, oldest)
+	}
+}
+
 // ClientCount returns the number of connected clients
 func (h *Hub) ClientCount() int {
 	h.mu.RLock()
@@ -149,6 +215,10 @@ func (c *Client) readPump() {
 
 		log.Printf("[readPump] Raw message from %s: %s", c.id, string(message))
 
+		if trace := c.trace.Load(); trace != nil {
+			trace.Record("in", message)
+		}
+
 		// Parse message type
 		var baseMsg struct {
 			Type string `json:"type"`
@@ -194,12 +264,20 @@ func (c *Client) writePump() {
 			return
 		}
 		w.Write(message)
+		trace := c.trace.Load()
+		if trace != nil {
+			trace.Record("out", message)
+		}
 
 		// Add queued messages to current websocket message
 		n := len(c.send)
 		for i := 0; i < n; i++ {
+			queued := <-c.send
 			w.Write([]byte("\n"))
-			w.Write(<-c.send)
+			w.Write(queued)
+			if trace != nil {
+				trace.Record("out", queued)
+			}
 		}
 
 		if err := w.Close(); err != nil {
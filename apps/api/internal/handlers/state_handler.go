@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+)
+
+// StateSharer exports the exact situation of the current run and replaces
+// it with an exported one
+type StateSharer interface {
+	ExportState() (*protocol.StateExport, error)
+	ImportState(export protocol.StateExport) (*protocol.StateImportResponse, error)
+}
+
+// StateHandler serves the state of the current run for download, and
+// takes it back, so users can share what they are looking at
+type StateHandler struct {
+	sharer StateSharer
+}
+
+// NewStateHandler creates a new state handler
+func NewStateHandler(sharer StateSharer) *StateHandler {
+	return &StateHandler{sharer: sharer}
+}
+
+// Register mounts the state routes on mux
+func (h *StateHandler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/state", h.exportState)
+	mux.HandleFunc("PUT /api/state", h.importState)
+}
+
+func (h *StateHandler) exportState(w http.ResponseWriter, r *http.Request) {
+	export, err := h.sharer.ExportState()
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="state-`+export.Checkpoint.Request.Project+`.json"`)
+	writeJSON(w, http.StatusOK, export)
+}
+
+func (h *StateHandler) importState(w http.ResponseWriter, r *http.Request) {
+	var export protocol.StateExport
+	if err := json.NewDecoder(r.Body).Decode(&export); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	imported, err := h.sharer.ImportState(export)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, imported)
+}
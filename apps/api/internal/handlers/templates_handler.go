@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/ersantana/distributed-systems-learning/apps/api/internal/templates"
+)
+
+// TemplateHandler serves CRUD endpoints for simulation templates
+type TemplateHandler struct {
+	store *templates.Store
+}
+
+// NewTemplateHandler creates a new template handler
+func NewTemplateHandler(store *templates.Store) *TemplateHandler {
+	return &TemplateHandler{store: store}
+}
+
+// Register mounts the template routes on mux
+func (h *TemplateHandler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/templates/{namespace}", h.list)
+	mux.HandleFunc("POST /api/templates/{namespace}", h.create)
+	mux.HandleFunc("GET /api/templates/{namespace}/{name}", h.get)
+	mux.HandleFunc("PUT /api/templates/{namespace}/{name}", h.put)
+	mux.HandleFunc("DELETE /api/templates/{namespace}/{name}", h.delete)
+}
+
+func (h *TemplateHandler) list(w http.ResponseWriter, r *http.Request) {
+	list, err := h.store.List(r.PathValue("namespace"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (h *TemplateHandler) get(w http.ResponseWriter, r *http.Request) {
+	t, err := h.store.Get(r.PathValue("namespace"), r.PathValue("name"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, t)
+}
+
+func (h *TemplateHandler) create(w http.ResponseWriter, r *http.Request) {
+	var t templates.Template
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	t.Namespace = r.PathValue("namespace")
+
+	if err := h.store.Create(&t); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, &t)
+}
+
+func (h *TemplateHandler) put(w http.ResponseWriter, r *http.Request) {
+	var t templates.Template
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	// The URL is authoritative for the template's identity
+	t.Namespace = r.PathValue("namespace")
+	t.Name = r.PathValue("name")
+
+	if err := h.store.Put(&t); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, &t)
+}
+
+func (h *TemplateHandler) delete(w http.ResponseWriter, r *http.Request) {
+	if err := h.store.Delete(r.PathValue("namespace"), r.PathValue("name")); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeStoreError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, templates.ErrNotFound):
+		writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, templates.ErrExists):
+		writeError(w, http.StatusConflict, err)
+	default:
+		writeError(w, http.StatusBadRequest, err)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error encoding response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
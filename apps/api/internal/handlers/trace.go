@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultTraceBytes is the capture limit when the client does not set one
+	DefaultTraceBytes = 1 << 20
+	// MaxTraceBytes caps how much a single trace may hold
+	MaxTraceBytes = 16 << 20
+	// maxRetainedTraces bounds how many finished traces the hub keeps
+	maxRetainedTraces = 20
+)
+
+// TraceEntry is one captured WebSocket frame
+type TraceEntry struct {
+	Time      time.Time       `json:"time"`
+	Direction string          `json:"direction"` // "in" (client->server) or "out"
+	Size      int             `json:"size"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	Raw       string          `json:"raw,omitempty"` // Frames that are not valid JSON
+}
+
+// TraceRecorder captures raw WebSocket traffic for one client session
+type TraceRecorder struct {
+	mu sync.Mutex
+
+	clientID  string
+	startedAt time.Time
+	stoppedAt time.Time
+	active    bool
+	maxBytes  int
+	bytes     int
+	dropped   int
+	entries   []TraceEntry
+}
+
+// NewTraceRecorder creates an active recorder holding at most maxBytes of frames
+func NewTraceRecorder(clientID string, maxBytes int) *TraceRecorder {
+	if maxBytes <= 0 {
+		maxBytes = DefaultTraceBytes
+	}
+	if maxBytes > MaxTraceBytes {
+		maxBytes = MaxTraceBytes
+	}
+	return &TraceRecorder{
+		clientID:  clientID,
+		startedAt: time.Now(),
+		active:    true,
+		maxBytes:  maxBytes,
+		entries:   make([]TraceEntry, 0),
+	}
+}
+
+// Record captures a frame; frames beyond the size limit are only counted
+func (t *TraceRecorder) Record(direction string, data []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.active {
+		return
+	}
+	if t.bytes+len(data) > t.maxBytes {
+		t.dropped++
+		return
+	}
+
+	entry := TraceEntry{
+		Time:      time.Now(),
+		Direction: direction,
+		Size:      len(data),
+	}
+	if json.Valid(data) {
+		entry.Data = append(json.RawMessage{}, data...)
+	} else {
+		entry.Raw = string(data)
+	}
+	t.entries = append(t.entries, entry)
+	t.bytes += len(data)
+}
+
+// Stop ends the capture, keeping what was recorded
+func (t *TraceRecorder) Stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.active {
+		t.active = false
+		t.stoppedAt = time.Now()
+	}
+}
+
+// Status summarizes the capture for clients
+func (t *TraceRecorder) Status() map[string]interface{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return map[string]interface{}{
+		"clientId":    t.clientID,
+		"active":      t.active,
+		"entries":     len(t.entries),
+		"bytes":       t.bytes,
+		"maxBytes":    t.maxBytes,
+		"dropped":     t.dropped,
+		"truncated":   t.dropped > 0,
+		"startedAt":   t.startedAt.UnixMilli(),
+		"downloadUrl": "/api/traces/" + t.clientID,
+	}
+}
+
+// WriteNDJSON writes the captured frames as newline-delimited JSON
+func (t *TraceRecorder) WriteNDJSON(w io.Writer) error {
+	t.mu.Lock()
+	entries := append([]TraceEntry{}, t.entries...)
+	t.mu.Unlock()
+
+	enc := json.NewEncoder(w)
+	for i := range entries {
+		if err := enc.Encode(&entries[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TraceHandler serves captured traces for download
+type TraceHandler struct {
+	hub *Hub
+}
+
+// NewTraceHandler creates a new trace handler
+func NewTraceHandler(hub *Hub) *TraceHandler {
+	return &TraceHandler{hub: hub}
+}
+
+// Register mounts the trace routes on mux
+func (h *TraceHandler) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/traces/{clientID}", h.download)
+}
+
+func (h *TraceHandler) download(w http.ResponseWriter, r *http.Request) {
+	clientID := r.PathValue("clientID")
+	trace := h.hub.Trace(clientID)
+	if trace == nil {
+		http.Error(w, "trace not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="trace-`+clientID+`.ndjson"`)
+	trace.WriteNDJSON(w)
+}
@@ -0,0 +1,906 @@
+package broadcast
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/core/clock"
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+	"github.com/ersantana/distributed-systems-learning/packages/simulation/engine"
+)
+
+const (
+	MsgBroadcast transport.MessageType = "broadcast"
+	MsgRelay     transport.MessageType = "relay"
+)
+
+// Reliability defines the dissemination guarantee of a broadcast
+type Reliability int
+
+const (
+	BestEffort Reliability = iota
+	Reliable
+	UniformReliable
+)
+
+func (r Reliability) String() string {
+	switch r {
+	case BestEffort:
+		return "best_effort"
+	case Reliable:
+		return "reliable"
+	case UniformReliable:
+		return "uniform_reliable"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseReliability maps a scenario name to a reliability level
+func ParseReliability(name string) Reliability {
+	switch name {
+	case "reliable":
+		return Reliable
+	case "uniform_reliable":
+		return UniformReliable
+	default:
+		return BestEffort
+	}
+}
+
+// Ordering defines the delivery order enforced on top of dissemination
+type Ordering int
+
+const (
+	Unordered Ordering = iota
+	FIFO
+	Causal
+	TotalSequencer
+	TotalConsensus
+)
+
+func (o Ordering) String() string {
+	switch o {
+	case Unordered:
+		return "unordered"
+	case FIFO:
+		return "fifo"
+	case Causal:
+		return "causal"
+	case TotalSequencer:
+		return "total_sequencer"
+	case TotalConsensus:
+		return "total_consensus"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseOrdering maps a scenario name to an ordering, reporting whether the
+// scenario is an ordering demonstration at all
+func ParseOrdering(name string) (Ordering, bool) {
+	switch name {
+	case "unordered", "unordered_burst":
+		return Unordered, true
+	case "fifo":
+		return FIFO, true
+	case "causal":
+		return Causal, true
+	case "total_sequencer":
+		return TotalSequencer, true
+	case "total_consensus":
+		return TotalConsensus, true
+	default:
+		return Unordered, false
+	}
+}
+
+// Payload is the content carried by broadcast and relay messages
+type Payload struct {
+	MsgID  string            `json:"msgId"`
+	Origin string            `json:"origin"`
+	Seq    int               `json:"seq"`
+	Value  string            `json:"value"`
+	Deps   map[string]uint64 `json:"deps,omitempty"` // Sender's delivered vector at broadcast time
+	Slot   int               `json:"slot,omitempty"` // Position in the total order, once assigned
+}
+
+// Simulation implements best-effort, reliable and uniform reliable broadcast,
+// optionally with FIFO, causal or total delivery order
+type Simulation struct {
+	mu sync.RWMutex
+
+	engine    *engine.Engine
+	transport *transport.NetworkTransport
+	broadcast func(interface{})
+
+	nodes       []*BroadcastNode
+	nodeCount   int
+	scenario    string
+	reliability Reliability
+	ordering    Ordering
+	senderID    string
+	sequencerID string // Sequencer or consensus leader for total order
+
+	broadcastInterval int
+	maxBroadcasts     int
+	crashSenderAfter  int
+
+	issued []string // IDs of broadcasts issued so far, in order
+
+	running bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// BroadcastNode represents a process taking part in the broadcast
+type BroadcastNode struct {
+	mu sync.RWMutex
+
+	id        string
+	status    string
+	isSender  bool
+	offset    int    // Tick offset of spontaneous broadcasts
+	repliesTo string // Origin whose messages this node answers with a broadcast
+	seq       int
+	ticks     int
+
+	outbox       []*transport.Envelope
+	seen         map[string]map[string]bool // msgID -> nodes we have received it from (incl. self)
+	delivered    []string
+	deliveredSet map[string]bool
+	messagesSent int
+
+	// vclock counts messages delivered from each node; own component
+	// counts own broadcasts
+	vclock           *clock.VectorClock
+	holdBack         []Payload
+	causalViolations int
+
+	// FIFO: next sequence number expected from each origin
+	nextExpected   map[string]int
+	fifoViolations int
+
+	// Total order: next slot to deliver, and on the sequencer/leader the
+	// last slot assigned and acceptances gathered per uncommitted slot
+	nextSlot int
+	lastSlot int
+	accepts  map[int]map[string]bool
+
+	inbox      chan *transport.Envelope
+	simulation *Simulation
+	nodeIDs    []string
+}
+
+// Config for Broadcast simulation
+type Config struct {
+	NodeCount         int
+	Scenario          string
+	BroadcastInterval int // Ticks between broadcasts issued by the sender
+	MaxBroadcasts     int
+	CrashSenderAfter  int // Crash the sender after this many sends (<0 = never)
+}
+
+// NewSimulation creates a new Broadcast simulation
+func NewSimulation(eng *engine.Engine, trans *transport.NetworkTransport, broadcast func(interface{}), config Config) *Simulation {
+	if config.NodeCount == 0 {
+		config.NodeCount = 5
+	}
+
+	// FIFO scenarios send in quick bursts so a sender's messages overtake
+	// each other in flight
+	burst := config.Scenario == "fifo" || config.Scenario == "unordered_burst"
+	if config.BroadcastInterval == 0 {
+		config.BroadcastInterval = 20
+		if burst {
+			config.BroadcastInterval = 3
+		}
+	}
+	if config.MaxBroadcasts == 0 {
+		config.MaxBroadcasts = 5
+		if burst {
+			config.MaxBroadcasts = 8
+		}
+	}
+
+	// Scenarios are "<reliability>", "<reliability>_sender_crash", or an
+	// ordering ("unordered", "fifo", "causal", ...) exercised by several
+	// broadcasters
+	mode := strings.TrimSuffix(config.Scenario, "_sender_crash")
+	if mode != config.Scenario && config.CrashSenderAfter == 0 {
+		config.CrashSenderAfter = 1
+	} else if config.CrashSenderAfter == 0 {
+		config.CrashSenderAfter = -1
+	}
+	ordering, ordered := ParseOrdering(mode)
+
+	sim := &Simulation{
+		engine:            eng,
+		transport:         trans,
+		broadcast:         broadcast,
+		nodeCount:         config.NodeCount,
+		scenario:          config.Scenario,
+		reliability:       ParseReliability(mode),
+		ordering:          ordering,
+		broadcastInterval: config.BroadcastInterval,
+		maxBroadcasts:     config.MaxBroadcasts,
+		crashSenderAfter:  config.CrashSenderAfter,
+		issued:            make([]string, 0),
+	}
+
+	// Reliable links: latency but no drops, so only crashes break guarantees.
+	// Ordering scenarios use a wider spread so messages overtake each other.
+	if ordered {
+		trans.SetLatency(50*time.Millisecond, 600*time.Millisecond)
+	} else {
+		trans.SetLatency(50*time.Millisecond, 250*time.Millisecond)
+	}
+	trans.SetPacketLoss(0)
+
+	nodeIDs := make([]string, config.NodeCount)
+	for i := 0; i < config.NodeCount; i++ {
+		nodeIDs[i] = fmt.Sprintf("node-%d", i+1)
+	}
+	sim.senderID = nodeIDs[0]
+
+	sim.nodes = make([]*BroadcastNode, config.NodeCount)
+	for i := 0; i < config.NodeCount; i++ {
+		node := sim.newBroadcastNode(nodeIDs[i], nodeIDs, i == 0)
+		sim.nodes[i] = node
+		trans.RegisterHandler(nodeIDs[i], node.handleMessage)
+		eng.AddNode(node)
+	}
+
+	if ordering == TotalSequencer || ordering == TotalConsensus {
+		// Total order: node-1 orders, everyone else broadcasts at
+		// staggered offsets so messages race each other
+		sim.sequencerID = nodeIDs[0]
+		sim.nodes[0].isSender = false
+		for i := 1; i < config.NodeCount; i++ {
+			sim.nodes[i].isSender = true
+			sim.nodes[i].offset = (i - 1) * 2 % config.BroadcastInterval
+		}
+	} else if burst && config.NodeCount >= 2 {
+		// FIFO: two senders broadcast bursts concurrently
+		sim.nodes[1].isSender = true
+		sim.nodes[1].offset = 1
+	} else if ordered && config.NodeCount >= 3 {
+		// Ordering scenarios: node-2 answers every message from node-1, creating
+		// causal chains, while node-3 broadcasts concurrently with both
+		sim.nodes[1].repliesTo = nodeIDs[0]
+		sim.nodes[2].isSender = true
+		sim.nodes[2].offset = config.BroadcastInterval / 2
+	}
+
+	return sim
+}
+
+func (s *Simulation) newBroadcastNode(id string, nodeIDs []string, isSender bool) *BroadcastNode {
+	return &BroadcastNode{
+		id:           id,
+		status:       "running",
+		isSender:     isSender,
+		outbox:       make([]*transport.Envelope, 0),
+		seen:         make(map[string]map[string]bool),
+		delivered:    make([]string, 0),
+		deliveredSet: make(map[string]bool),
+		vclock:       clock.NewVectorClock(id, nodeIDs),
+		holdBack:     make([]Payload, 0),
+		nextSlot:     1,
+		nextExpected: make(map[string]int),
+		accepts:      make(map[int]map[string]bool),
+		inbox:        make(chan *transport.Envelope, 100),
+		simulation:   s,
+		nodeIDs:      nodeIDs,
+	}
+}
+
+// Start starts the simulation
+func (s *Simulation) Start(ctx context.Context) error {
+	s.mu.Lock()
+	s.running = true
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.mu.Unlock()
+
+	return s.engine.Start(ctx)
+}
+
+// Stop stops the simulation
+func (s *Simulation) Stop() error {
+	s.mu.Lock()
+	s.running = false
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Unlock()
+
+	return s.engine.Stop()
+}
+
+// GetState returns the current simulation state
+func (s *Simulation) GetState() *protocol.SimulationStateResponse {
+	// Node locks are taken without holding s.mu: nodes lock the
+	// simulation while ticking, so the reverse order would deadlock.
+	s.mu.RLock()
+	running := s.running
+	issued := append([]string{}, s.issued...)
+	s.mu.RUnlock()
+
+	nodes := make(map[string]protocol.NodeState)
+	orders := make(map[string][]string)
+	for _, node := range s.nodes {
+		nodeState := node.GetState()
+		orders[node.id] = nodeState["delivered"].([]string)
+
+		role := "receiver"
+		if node.id == s.sequencerID {
+			role = "sequencer"
+			if s.ordering == TotalConsensus {
+				role = "leader"
+			}
+		} else if node.isSender {
+			role = "sender"
+		}
+		nodes[node.id] = protocol.NodeState{
+			ID:     node.id,
+			Status: nodeState["status"].(string),
+			Role:   role,
+			Clock:  nodeState["vectorClock"].(map[string]uint64),
+			CustomState: map[string]interface{}{
+				"reliability":      s.reliability.String(),
+				"ordering":         s.ordering.String(),
+				"delivered":        nodeState["delivered"],
+				"deliveredCount":   nodeState["deliveredCount"],
+				"holdBack":         nodeState["holdBack"],
+				"causalViolations": nodeState["causalViolations"],
+				"nextSlot":         nodeState["nextSlot"],
+				"nextExpected":     nodeState["nextExpected"],
+				"fifoViolations":   nodeState["fifoViolations"],
+				"pendingSends":     nodeState["pendingSends"],
+				"messagesSent":     nodeState["messagesSent"],
+			},
+		}
+	}
+
+	mode := "step"
+	if s.engine != nil {
+		mode = s.engine.GetMode().String()
+	}
+
+	return &protocol.SimulationStateResponse{
+		Type:        protocol.MsgSimulationState,
+		VirtualTime: time.Now().UnixMilli(),
+		Mode:        mode,
+		Speed:       1.0,
+		Running:     running,
+		Nodes:       nodes,
+		Metadata: map[string]interface{}{
+			"reliability":     s.reliability.String(),
+			"ordering":        s.ordering.String(),
+			"guarantees":      s.checkGuarantees(issued),
+			"deliveryOrders":  orders,
+			"orderConsistent": orderConsistent(orders),
+		},
+	}
+}
+
+// checkGuarantees reports, per issued broadcast, which nodes delivered it
+// and whether agreement and uniform agreement currently hold
+func (s *Simulation) checkGuarantees(issued []string) []map[string]interface{} {
+	results := make([]map[string]interface{}, 0, len(issued))
+	for _, msgID := range issued {
+		deliveredBy := make([]string, 0)
+		correctDelivered, correctTotal := 0, 0
+		anyDelivered := false
+
+		for _, node := range s.nodes {
+			node.mu.RLock()
+			delivered := node.deliveredSet[msgID]
+			correct := node.status == "running"
+			node.mu.RUnlock()
+
+			if delivered {
+				deliveredBy = append(deliveredBy, node.id)
+				anyDelivered = true
+			}
+			if correct {
+				correctTotal++
+				if delivered {
+					correctDelivered++
+				}
+			}
+		}
+
+		// Agreement: correct nodes deliver all or nothing.
+		// Uniform agreement: if anyone (even a crashed node) delivered,
+		// every correct node delivered too.
+		agreement := correctDelivered == 0 || correctDelivered == correctTotal
+		uniform := !anyDelivered || correctDelivered == correctTotal
+
+		results = append(results, map[string]interface{}{
+			"msgId":            msgID,
+			"deliveredBy":      deliveredBy,
+			"agreement":        agreement,
+			"uniformAgreement": uniform,
+		})
+	}
+	return results
+}
+
+// orderConsistent reports whether every pair of nodes delivered the
+// messages they have in common in the same relative order
+func orderConsistent(orders map[string][]string) bool {
+	for a, orderA := range orders {
+		position := make(map[string]int, len(orderA))
+		for i, msgID := range orderA {
+			position[msgID] = i
+		}
+		for b, orderB := range orders {
+			if a == b {
+				continue
+			}
+			last := -1
+			for _, msgID := range orderB {
+				pos, ok := position[msgID]
+				if !ok {
+					continue
+				}
+				if pos < last {
+					return false
+				}
+				last = pos
+			}
+		}
+	}
+	return true
+}
+
+// GetNodes returns node states
+func (s *Simulation) GetNodes() map[string]protocol.NodeState {
+	state := s.GetState()
+	return state.Nodes
+}
+
+// Layout puts the sequencer or leader in the middle for total order and
+// places the nodes on a ring otherwise
+func (s *Simulation) Layout() *protocol.Layout {
+	order := make([]string, 0, len(s.nodes))
+	for _, node := range s.nodes {
+		if node.id != s.sequencerID {
+			order = append(order, node.id)
+		}
+	}
+	if s.sequencerID == "" {
+		return &protocol.Layout{Kind: protocol.LayoutRing, Order: order}
+	}
+	return &protocol.Layout{
+		Kind:   protocol.LayoutStar,
+		Center: s.sequencerID,
+		Order:  order,
+	}
+}
+
+// CrashNode crashes a node
+func (s *Simulation) CrashNode(nodeID string) error {
+	node := s.findNode(nodeID)
+	if node == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	node.mu.Lock()
+	node.crash()
+	node.mu.Unlock()
+	return nil
+}
+
+// RecoverNode recovers a crashed node
+func (s *Simulation) RecoverNode(nodeID string) error {
+	node := s.findNode(nodeID)
+	if node == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	node.mu.Lock()
+	node.status = "running"
+	node.mu.Unlock()
+	return nil
+}
+
+// findNode looks up a node by ID; the node list is fixed after construction
+func (s *Simulation) findNode(nodeID string) *BroadcastNode {
+	for _, node := range s.nodes {
+		if node.id == nodeID {
+			return node
+		}
+	}
+	return nil
+}
+
+func (s *Simulation) majority() int {
+	return s.nodeCount/2 + 1
+}
+
+// BroadcastNode implements engine.NodeController
+
+func (n *BroadcastNode) ID() string {
+	return n.id
+}
+
+func (n *BroadcastNode) Start(ctx context.Context) error {
+	return nil
+}
+
+func (n *BroadcastNode) Stop() error {
+	return nil
+}
+
+func (n *BroadcastNode) Tick() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.status != "running" {
+		return
+	}
+
+	sim := n.simulation
+	n.ticks++
+
+	// Process any pending messages
+	select {
+	case env := <-n.inbox:
+		n.processMessage(env)
+	default:
+	}
+
+	// Sender issues a new broadcast periodically
+	if n.isSender && n.seq < sim.maxBroadcasts && n.ticks%sim.broadcastInterval == 1+n.offset {
+		n.startBroadcast(fmt.Sprintf("%s-m%d", n.id, n.seq+1))
+	}
+
+	// Send at most one message per tick so a crash can interrupt a broadcast
+	n.flushOne()
+}
+
+func (n *BroadcastNode) GetState() map[string]interface{} {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	holdBack := make([]string, len(n.holdBack))
+	for i, p := range n.holdBack {
+		holdBack[i] = p.MsgID
+	}
+	nextExpected := make(map[string]int, len(n.nodeIDs))
+	for _, nodeID := range n.nodeIDs {
+		nextExpected[nodeID] = n.expected(nodeID)
+	}
+
+	return map[string]interface{}{
+		"id":               n.id,
+		"status":           n.status,
+		"delivered":        append([]string{}, n.delivered...),
+		"deliveredCount":   len(n.delivered),
+		"holdBack":         holdBack,
+		"causalViolations": n.causalViolations,
+		"nextSlot":         n.nextSlot,
+		"nextExpected":     nextExpected,
+		"fifoViolations":   n.fifoViolations,
+		"vectorClock":      n.vclock.Time(),
+		"pendingSends":     len(n.outbox),
+		"messagesSent":     n.messagesSent,
+	}
+}
+
+func (n *BroadcastNode) handleMessage(env *transport.Envelope) {
+	n.mu.RLock()
+	crashed := n.status != "running"
+	n.mu.RUnlock()
+
+	// Crashed processes do not receive anything
+	if crashed {
+		return
+	}
+	select {
+	case n.inbox <- env:
+	default:
+	}
+}
+
+// crash stops the node and discards its unsent messages (must hold n.mu)
+func (n *BroadcastNode) crash() {
+	n.status = "crashed"
+	n.outbox = n.outbox[:0]
+}
+
+func (n *BroadcastNode) startBroadcast(value string) {
+	sim := n.simulation
+
+	n.seq++
+	payload := Payload{
+		MsgID:  fmt.Sprintf("%s:%d", n.id, n.seq),
+		Origin: n.id,
+		Seq:    n.seq,
+		Value:  value,
+		Deps:   n.vclock.Increment(),
+	}
+
+	sim.mu.Lock()
+	sim.issued = append(sim.issued, payload.MsgID)
+	sim.mu.Unlock()
+
+	sim.broadcast(map[string]interface{}{
+		"type":        "broadcast_started",
+		"nodeId":      n.id,
+		"messageId":   payload.MsgID,
+		"reliability": sim.reliability.String(),
+	})
+
+	// Total order hands the message to the sequencer/leader instead
+	if sim.ordering == TotalSequencer || sim.ordering == TotalConsensus {
+		n.submit(payload)
+		return
+	}
+
+	n.seen[payload.MsgID] = map[string]bool{n.id: true}
+	for _, targetID := range n.nodeIDs {
+		if targetID == n.id {
+			continue
+		}
+		n.enqueue(targetID, MsgBroadcast, payload)
+	}
+
+	n.onReceive(payload)
+}
+
+func (n *BroadcastNode) processMessage(env *transport.Envelope) {
+	sim := n.simulation
+
+	sim.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageReceived,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+
+	if n.handleTotalOrder(env) {
+		return
+	}
+
+	payload, ok := env.Payload.(Payload)
+	if !ok {
+		return
+	}
+
+	first := n.seen[payload.MsgID] == nil
+	if first {
+		n.seen[payload.MsgID] = map[string]bool{n.id: true}
+	}
+	n.seen[payload.MsgID][env.From] = true
+
+	if first {
+		switch sim.reliability {
+		case Reliable:
+			// Eager reliable broadcast: relay before delivering
+			for _, targetID := range n.nodeIDs {
+				if targetID != n.id && targetID != env.From {
+					n.enqueue(targetID, MsgRelay, payload)
+				}
+			}
+		case UniformReliable:
+			// Everyone relays to everyone, copies double as acknowledgements
+			for _, targetID := range n.nodeIDs {
+				if targetID != n.id {
+					n.enqueue(targetID, MsgRelay, payload)
+				}
+			}
+		}
+	}
+
+	n.onReceive(payload)
+}
+
+// onReceive delivers a message once the reliability level allows it
+func (n *BroadcastNode) onReceive(payload Payload) {
+	sim := n.simulation
+
+	if sim.reliability == UniformReliable {
+		// Only deliver once a majority has the message, so it
+		// survives any minority of crashes
+		if len(n.seen[payload.MsgID]) < sim.majority() {
+			return
+		}
+	}
+
+	n.order(payload)
+}
+
+// order applies the ordering layer to a message the reliability layer
+// has accepted
+func (n *BroadcastNode) order(payload Payload) {
+	sim := n.simulation
+
+	if n.deliveredSet[payload.MsgID] {
+		return
+	}
+
+	// Own broadcasts are always next in line for their sender
+	if payload.Origin == n.id {
+		n.deliver(payload)
+		return
+	}
+
+	switch sim.ordering {
+	case FIFO:
+		if !n.fifoReady(payload) {
+			n.holdBack = append(n.holdBack, payload)
+			sim.broadcast(map[string]interface{}{
+				"type":      "broadcast_held_back",
+				"nodeId":    n.id,
+				"messageId": payload.MsgID,
+				"seq":       payload.Seq,
+				"expected":  n.expected(payload.Origin),
+			})
+			return
+		}
+		n.deliver(payload)
+		n.releaseHoldBack(n.fifoReady)
+
+	case Causal:
+		if !n.causallyReady(payload) {
+			n.holdBack = append(n.holdBack, payload)
+			sim.broadcast(map[string]interface{}{
+				"type":      "broadcast_held_back",
+				"nodeId":    n.id,
+				"messageId": payload.MsgID,
+				"deps":      payload.Deps,
+				"local":     n.vclock.Time(),
+			})
+			return
+		}
+		n.deliver(payload)
+		n.releaseHoldBack(n.causallyReady)
+
+	default:
+		if !n.fifoReady(payload) {
+			n.fifoViolations++
+			sim.broadcast(map[string]interface{}{
+				"type":      "fifo_violation",
+				"nodeId":    n.id,
+				"messageId": payload.MsgID,
+				"seq":       payload.Seq,
+				"expected":  n.expected(payload.Origin),
+			})
+		}
+		if !n.causallyReady(payload) {
+			n.causalViolations++
+			sim.broadcast(map[string]interface{}{
+				"type":      "causal_violation",
+				"nodeId":    n.id,
+				"messageId": payload.MsgID,
+				"deps":      payload.Deps,
+				"local":     n.vclock.Time(),
+			})
+		}
+		n.deliver(payload)
+	}
+}
+
+// expected returns the next sequence number expected from an origin
+func (n *BroadcastNode) expected(origin string) int {
+	if next, ok := n.nextExpected[origin]; ok {
+		return next
+	}
+	return 1
+}
+
+// fifoReady reports whether the payload is the next message from its origin
+func (n *BroadcastNode) fifoReady(payload Payload) bool {
+	return payload.Seq == n.expected(payload.Origin)
+}
+
+// causallyReady reports whether every message the payload depends on has
+// been delivered: it must be the next message from its origin, and the
+// origin must not have seen more from anyone else than we have
+func (n *BroadcastNode) causallyReady(payload Payload) bool {
+	local := n.vclock.Time()
+	for nodeID, count := range payload.Deps {
+		if nodeID == payload.Origin {
+			if count != local[nodeID]+1 {
+				return false
+			}
+		} else if count > local[nodeID] {
+			return false
+		}
+	}
+	return true
+}
+
+// releaseHoldBack delivers buffered messages until none are ready
+func (n *BroadcastNode) releaseHoldBack(ready func(Payload) bool) {
+	for released := true; released; {
+		released = false
+		for i, payload := range n.holdBack {
+			if !ready(payload) {
+				continue
+			}
+			n.holdBack = append(n.holdBack[:i], n.holdBack[i+1:]...)
+			n.simulation.broadcast(map[string]interface{}{
+				"type":      "broadcast_deliverable",
+				"nodeId":    n.id,
+				"messageId": payload.MsgID,
+				"heldBack":  len(n.holdBack),
+			})
+			n.deliver(payload)
+			released = true
+			break
+		}
+	}
+}
+
+func (n *BroadcastNode) deliver(payload Payload) {
+	if n.deliveredSet[payload.MsgID] {
+		return
+	}
+	n.deliveredSet[payload.MsgID] = true
+	n.delivered = append(n.delivered, payload.MsgID)
+
+	// Own broadcasts were counted in the vector when they were sent
+	if payload.Origin != n.id {
+		n.vclock.Advance(payload.Origin)
+	}
+	if payload.Seq >= n.expected(payload.Origin) {
+		n.nextExpected[payload.Origin] = payload.Seq + 1
+	}
+
+	n.simulation.broadcast(map[string]interface{}{
+		"type":      "broadcast_delivered",
+		"nodeId":    n.id,
+		"messageId": payload.MsgID,
+		"origin":    payload.Origin,
+		"position":  len(n.delivered),
+	})
+
+	// Answer the message, making the reply causally dependent on it
+	if n.repliesTo != "" && payload.Origin == n.repliesTo && n.seq < n.simulation.maxBroadcasts {
+		n.startBroadcast("re:" + payload.Value)
+	}
+}
+
+func (n *BroadcastNode) enqueue(to string, msgType transport.MessageType, payload Payload) {
+	n.outbox = append(n.outbox, transport.NewEnvelope(n.id, to, msgType, payload))
+}
+
+func (n *BroadcastNode) flushOne() {
+	if len(n.outbox) == 0 {
+		return
+	}
+
+	sim := n.simulation
+	env := n.outbox[0]
+	n.outbox = n.outbox[1:]
+	n.messagesSent++
+
+	sim.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageSent,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+
+	sim.transport.Send(sim.ctx, env)
+
+	// Scripted crash partway through the sender's first broadcast
+	if n.isSender && sim.crashSenderAfter >= 0 && n.messagesSent >= sim.crashSenderAfter {
+		n.crash()
+		sim.broadcast(map[string]interface{}{
+			"type":         "sender_crashed",
+			"nodeId":       n.id,
+			"messagesSent": n.messagesSent,
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package broadcast
+
+import (
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+// Total order broadcast messages. With a sequencer, node-1 stamps each
+// submitted message with the next slot and forwards it to everyone. With
+// consensus, node-1 acts as a fixed leader that only commits a slot once a
+// majority has accepted it, the replication half of Raft without elections.
+const (
+	MsgSubmit    transport.MessageType = "submit"
+	MsgSequenced transport.MessageType = "sequenced"
+	MsgPropose   transport.MessageType = "propose"
+	MsgAccept    transport.MessageType = "accept"
+	MsgCommit    transport.MessageType = "commit"
+)
+
+// submit hands a new broadcast to the sequencer or leader
+func (n *BroadcastNode) submit(payload Payload) {
+	sim := n.simulation
+	if n.id == sim.sequencerID {
+		n.assignSlot(payload)
+		return
+	}
+	n.enqueue(sim.sequencerID, MsgSubmit, payload)
+}
+
+// assignSlot gives a message the next position in the total order
+func (n *BroadcastNode) assignSlot(payload Payload) {
+	sim := n.simulation
+
+	n.lastSlot++
+	payload.Slot = n.lastSlot
+
+	switch sim.ordering {
+	case TotalSequencer:
+		for _, targetID := range n.nodeIDs {
+			if targetID != n.id {
+				n.enqueue(targetID, MsgSequenced, payload)
+			}
+		}
+		n.sequenced(payload)
+
+	case TotalConsensus:
+		n.accepts[payload.Slot] = map[string]bool{n.id: true}
+		for _, targetID := range n.nodeIDs {
+			if targetID != n.id {
+				n.enqueue(targetID, MsgPropose, payload)
+			}
+		}
+	}
+}
+
+// handleTotalOrder processes total order protocol messages, reporting
+// whether the envelope was one of them
+func (n *BroadcastNode) handleTotalOrder(env *transport.Envelope) bool {
+	sim := n.simulation
+
+	payload, ok := env.Payload.(Payload)
+	if !ok {
+		return false
+	}
+
+	switch env.Type {
+	case MsgSubmit:
+		n.assignSlot(payload)
+
+	case MsgSequenced:
+		n.sequenced(payload)
+
+	case MsgPropose:
+		// Followers accept proposals from the fixed leader; the commit
+		// carries the payload again, so nothing needs to be stored here
+		n.enqueue(env.From, MsgAccept, payload)
+
+	case MsgAccept:
+		accepted := n.accepts[payload.Slot]
+		if accepted == nil {
+			return true // Already committed
+		}
+		accepted[env.From] = true
+		if len(accepted) < sim.majority() {
+			return true
+		}
+
+		delete(n.accepts, payload.Slot)
+		sim.broadcast(map[string]interface{}{
+			"type":      "slot_committed",
+			"nodeId":    n.id,
+			"slot":      payload.Slot,
+			"messageId": payload.MsgID,
+			"acceptors": len(accepted),
+		})
+		for _, targetID := range n.nodeIDs {
+			if targetID != n.id {
+				n.enqueue(targetID, MsgCommit, payload)
+			}
+		}
+		n.sequenced(payload)
+
+	case MsgCommit:
+		n.sequenced(payload)
+
+	default:
+		return false
+	}
+	return true
+}
+
+// sequenced buffers a slot-stamped message and delivers every
+// contiguous slot from the next expected one
+func (n *BroadcastNode) sequenced(payload Payload) {
+	sim := n.simulation
+
+	if payload.Slot < n.nextSlot {
+		return // Duplicate
+	}
+	for _, held := range n.holdBack {
+		if held.Slot == payload.Slot {
+			return
+		}
+	}
+
+	n.holdBack = append(n.holdBack, payload)
+	if payload.Slot != n.nextSlot {
+		sim.broadcast(map[string]interface{}{
+			"type":      "broadcast_held_back",
+			"nodeId":    n.id,
+			"messageId": payload.MsgID,
+			"slot":      payload.Slot,
+			"expected":  n.nextSlot,
+		})
+		return
+	}
+
+	for released := true; released; {
+		released = false
+		for i, held := range n.holdBack {
+			if held.Slot != n.nextSlot {
+				continue
+			}
+			n.holdBack = append(n.holdBack[:i], n.holdBack[i+1:]...)
+			if held.MsgID != payload.MsgID {
+				sim.broadcast(map[string]interface{}{
+					"type":      "broadcast_deliverable",
+					"nodeId":    n.id,
+					"messageId": held.MsgID,
+					"slot":      held.Slot,
+					"heldBack":  len(n.holdBack),
+				})
+			}
+			n.nextSlot++
+			n.deliver(held)
+			released = true
+			break
+		}
+	}
+}
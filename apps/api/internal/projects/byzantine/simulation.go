@@ -45,6 +45,7 @@ type Simulation struct {
 	mu sync.RWMutex
 
 	engine    *engine.Engine
+	rng       *rand.Rand
 	transport *transport.NetworkTransport
 	broadcast func(interface{})
 
@@ -102,6 +103,7 @@ func NewSimulation(eng *engine.Engine, trans *transport.NetworkTransport, broadc
 
 	sim := &Simulation{
 		engine:       eng,
+		rng:          eng.Rand(),
 		transport:    trans,
 		broadcast:    broadcast,
 		nodeCount:    config.NodeCount,
@@ -123,7 +125,7 @@ func NewSimulation(eng *engine.Engine, trans *transport.NetworkTransport, broadc
 	// Randomly select traitors (but not the commander in default scenario)
 	traitorSet := make(map[int]bool)
 	for len(traitorSet) < config.TraitorCount {
-		idx := rand.Intn(config.NodeCount)
+		idx := sim.rng.Intn(config.NodeCount)
 		// In default scenario, don't make commander (index 0) a traitor
 		if config.Scenario != "commander_traitor" && idx == 0 {
 			continue
@@ -246,6 +248,21 @@ func (s *Simulation) GetNodes() map[string]protocol.NodeState {
 	return state.Nodes
 }
 
+// Layout puts the commander in the middle of its lieutenants
+func (s *Simulation) Layout() *protocol.Layout {
+	order := make([]string, 0, len(s.nodes))
+	for _, node := range s.nodes {
+		if node.id != s.commanderID {
+			order = append(order, node.id)
+		}
+	}
+	return &protocol.Layout{
+		Kind:   protocol.LayoutStar,
+		Center: s.commanderID,
+		Order:  order,
+	}
+}
+
 // CrashNode crashes a node
 func (s *Simulation) CrashNode(nodeID string) error {
 	s.mu.Lock()
@@ -341,7 +358,10 @@ func (n *ByzantineNode) GetState() map[string]interface{} {
 }
 
 func (n *ByzantineNode) handleMessage(env *transport.Envelope) {
-	n.inbox <- env
+	select {
+	case n.inbox <- env:
+	default:
+	}
 }
 
 func (n *ByzantineNode) processMessage(env *transport.Envelope) {
@@ -404,7 +424,7 @@ func (n *ByzantineNode) sendInitialVotes() {
 		// Traitor sends conflicting votes
 		if n.behavior == BehaviorTraitor {
 			// Send different values to different generals
-			if rand.Float64() < 0.5 {
+			if n.simulation.rng.Float64() < 0.5 {
 				vote = "attack"
 			} else {
 				vote = "retreat"
@@ -446,7 +466,7 @@ func (n *ByzantineNode) relayVote(vote string, round int) {
 
 	// If traitor, may alter the vote when relaying
 	if n.behavior == BehaviorTraitor {
-		if rand.Float64() < 0.5 {
+		if n.simulation.rng.Float64() < 0.5 {
 			if vote == "attack" {
 				vote = "retreat"
 			} else {
@@ -567,6 +587,36 @@ func (s *Simulation) GetFinalDecision() string {
 	return s.finalDecision
 }
 
-func init() {
-	rand.Seed(time.Now().UnixNano())
+// Done tells that the run is over once every honest general still up has
+// decided and no vote is left on its way or unread
+func (s *Simulation) Done() (string, bool) {
+	if s.transport.Summary().InFlight > 0 {
+		return "", false
+	}
+	decisions := make(map[string]int)
+	for _, node := range s.nodes {
+		node.mu.RLock()
+		honest, up, decision := node.behavior == BehaviorHonest, node.status != "crashed", node.decision
+		unread := len(node.inbox)
+		node.mu.RUnlock()
+		if unread > 0 {
+			return "", false
+		}
+		if !honest || !up {
+			continue
+		}
+		if decision == "" {
+			return "", false
+		}
+		decisions[decision]++
+	}
+	switch len(decisions) {
+	case 0:
+		return "", false
+	case 1:
+		for decision := range decisions {
+			return "the honest generals agree to " + decision, true
+		}
+	}
+	return fmt.Sprintf("the honest generals disagree: %d attack, %d retreat", decisions["attack"], decisions["retreat"]), true
 }
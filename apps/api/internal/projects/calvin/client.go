@@ -0,0 +1,157 @@
+package calvin
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+const (
+	submitEvery = 3 // Ticks between two transfers of a client
+	maxAmount   = 10
+)
+
+// Client submits transfers to its sequencer without waiting for the
+// previous ones: the order is decided by the sequencers, so nothing a
+// client sends can conflict its way into an abort. The partition storing
+// the source account reports the outcome.
+type Client struct {
+	mu sync.RWMutex
+
+	id        string
+	status    string // "running" or "crashed"
+	ticks     int
+	offset    int
+	sequencer string
+
+	count     int
+	inFlight  map[string]int // Transaction -> tick it was submitted
+	commits   int
+	aborts    int
+	latency   int // Ticks from submission to outcome, summed over the outcomes
+	requested []Txn
+
+	inbox      chan *transport.Envelope
+	simulation *Simulation
+}
+
+func newClient(id, sequencer string, offset int, sim *Simulation) *Client {
+	return &Client{
+		id:         id,
+		status:     "running",
+		offset:     offset,
+		sequencer:  sequencer,
+		inFlight:   make(map[string]int),
+		inbox:      make(chan *transport.Envelope, 500),
+		simulation: sim,
+	}
+}
+
+// Client implements engine.NodeController
+
+func (c *Client) ID() string {
+	return c.id
+}
+
+func (c *Client) Start(ctx context.Context) error {
+	return nil
+}
+
+func (c *Client) Stop() error {
+	return nil
+}
+
+func (c *Client) Tick() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ticks++
+	if c.status == "crashed" {
+		return
+	}
+
+	sim := c.simulation
+	for i := 0; i < 50; i++ {
+		select {
+		case env := <-c.inbox:
+			payload := sim.received(env)
+			if env.Type != MsgResult || payload.Txn == nil {
+				continue
+			}
+			submitted, ok := c.inFlight[payload.Txn.ID]
+			if !ok {
+				continue
+			}
+			delete(c.inFlight, payload.Txn.ID)
+			if payload.OK {
+				c.commits++
+			} else {
+				c.aborts++
+			}
+			c.latency += c.ticks - submitted
+			sim.completed(c.id, *payload.Txn, payload.OK, payload.Reason, c.ticks-submitted)
+			continue
+		default:
+		}
+		break
+	}
+
+	for _, txn := range c.requested {
+		c.submit(txn.From, txn.To, txn.Amount)
+	}
+	c.requested = nil
+	if c.ticks%submitEvery == c.offset {
+		from, to := sim.pickAccounts()
+		c.submit(from, to, c.simulation.rng.Intn(maxAmount)+1)
+	}
+}
+
+func (c *Client) GetState() map[string]interface{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	avgLatency := 0.0
+	if done := c.commits + c.aborts; done > 0 {
+		avgLatency = float64(c.latency) / float64(done)
+	}
+	return map[string]interface{}{
+		"id":         c.id,
+		"status":     c.status,
+		"role":       "client",
+		"sequencer":  c.sequencer,
+		"inFlight":   len(c.inFlight),
+		"commits":    c.commits,
+		"aborts":     c.aborts,
+		"avgLatency": avgLatency, // Ticks
+	}
+}
+
+func (c *Client) handleMessage(env *transport.Envelope) {
+	c.mu.RLock()
+	down := c.status == "crashed"
+	c.mu.RUnlock()
+
+	if down {
+		return
+	}
+	select {
+	case c.inbox <- env:
+	default:
+	}
+}
+
+// submit sends a transfer to the client's sequencer (must hold c.mu)
+func (c *Client) submit(from, to string, amount int) {
+	c.count++
+	txn := Txn{
+		ID:     fmt.Sprintf("%s.%d", c.id, c.count),
+		Client: c.id,
+		From:   from,
+		To:     to,
+		Amount: amount,
+	}
+	c.inFlight[txn.ID] = c.ticks
+	c.simulation.send(c.id, c.sequencer, MsgSubmit, Payload{Txn: &txn})
+}
@@ -0,0 +1,343 @@
+package calvin
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+const (
+	MsgRemoteRead transport.MessageType = "remote_read"
+	MsgResult     transport.MessageType = "result"
+)
+
+// scheduled is a transaction of the global order the partition takes
+// part in
+type scheduled struct {
+	txn   Txn
+	at    Position
+	keys  []string // Local keys, locked in the global order
+	peers []string // Other participants, whose reads it needs
+	sent  bool     // Local reads sent to the peers
+}
+
+// sentRead is a remote read kept to resend to a peer back from a crash
+type sentRead struct {
+	epoch   int
+	to      string
+	payload Payload
+}
+
+// Partition stores some of the accounts and executes, in the global
+// order, the transactions that touch them. It locks a transaction's local
+// keys in that order, so two partitions never disagree on who goes first
+// and nothing deadlocks. Once it holds them it sends the local values to
+// the other participants and waits for theirs; with every value read,
+// each participant runs the same deterministic logic and writes its own
+// keys. Nobody votes: the outcome was fixed when the order was.
+type Partition struct {
+	mu sync.RWMutex
+
+	id     string
+	status string // "running" or "crashed"
+	ticks  int
+
+	balances  map[string]int
+	batches   map[int]map[int][]Txn // Epoch -> sequencer -> transactions
+	next      int                   // Next epoch to schedule
+	queue     []*scheduled          // Scheduled and not executed, in the global order
+	locks     map[string][]string   // Key -> transactions in the global order; the first holds it
+	reads     map[string]map[string]int
+	sentReads []sentRead
+	done      map[string]bool
+
+	executed int
+	stalled  int // Ticks transactions held their locks waiting for remote reads
+
+	inbox      chan *transport.Envelope
+	simulation *Simulation
+}
+
+func newPartition(id string, keys []string, sim *Simulation) *Partition {
+	p := &Partition{
+		id:         id,
+		status:     "running",
+		balances:   make(map[string]int),
+		batches:    make(map[int]map[int][]Txn),
+		next:       1,
+		locks:      make(map[string][]string),
+		reads:      make(map[string]map[string]int),
+		done:       make(map[string]bool),
+		inbox:      make(chan *transport.Envelope, 500),
+		simulation: sim,
+	}
+	for _, key := range keys {
+		p.balances[key] = initialBalance
+	}
+	return p
+}
+
+// Partition implements engine.NodeController
+
+func (p *Partition) ID() string {
+	return p.id
+}
+
+func (p *Partition) Start(ctx context.Context) error {
+	return nil
+}
+
+func (p *Partition) Stop() error {
+	return nil
+}
+
+func (p *Partition) Tick() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.ticks++
+	if p.status == "crashed" {
+		return
+	}
+
+	for i := 0; i < 50; i++ {
+		select {
+		case env := <-p.inbox:
+			p.processMessage(env)
+			continue
+		default:
+		}
+		break
+	}
+
+	p.schedule()
+	p.execute()
+}
+
+func (p *Partition) GetState() map[string]interface{} {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	balances := make(map[string]int, len(p.balances))
+	keys := make([]string, 0, len(p.balances))
+	for key, balance := range p.balances {
+		balances[key] = balance
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	holders := make(map[string]string)
+	for key, queue := range p.locks {
+		if len(queue) > 0 {
+			holders[key] = queue[0]
+		}
+	}
+
+	return map[string]interface{}{
+		"id":       p.id,
+		"status":   p.status,
+		"role":     "partition",
+		"keys":     keys,
+		"balances": balances,
+		"epoch":    p.next - 1, // Last epoch scheduled
+		"queued":   len(p.queue),
+		"locks":    holders,
+		"executed": p.executed,
+		"stalled":  p.stalled,
+	}
+}
+
+func (p *Partition) handleMessage(env *transport.Envelope) {
+	p.mu.RLock()
+	down := p.status == "crashed"
+	p.mu.RUnlock()
+
+	if down {
+		return
+	}
+	select {
+	case p.inbox <- env:
+	default:
+	}
+}
+
+func (p *Partition) processMessage(env *transport.Envelope) {
+	sim := p.simulation
+	payload := sim.received(env)
+
+	switch env.Type {
+	case MsgBatch:
+		batch := payload.Batch
+		if batch == nil || batch.Epoch < p.next {
+			return
+		}
+		if p.batches[batch.Epoch] == nil {
+			p.batches[batch.Epoch] = make(map[int][]Txn)
+		}
+		p.batches[batch.Epoch][batch.Sequencer] = batch.Txns
+
+	case MsgRemoteRead:
+		if payload.Txn == nil || p.done[payload.Txn.ID] {
+			return
+		}
+		if p.reads[payload.Txn.ID] == nil {
+			p.reads[payload.Txn.ID] = make(map[string]int)
+		}
+		for key, value := range payload.Values {
+			p.reads[payload.Txn.ID][key] = value
+		}
+
+	case MsgReplay:
+		for _, read := range p.sentReads {
+			if read.to == env.From && read.epoch >= payload.Epoch {
+				sim.send(p.id, read.to, MsgRemoteRead, read.payload)
+			}
+		}
+	}
+}
+
+// schedule appends the epochs every sequencer's batch arrived for to the
+// queue, in the global order, and queues their transactions for the
+// locks of the local keys (must hold p.mu)
+func (p *Partition) schedule() {
+	sim := p.simulation
+	for len(p.batches[p.next]) == len(sim.sequencers) {
+		for index := 1; index <= len(sim.sequencers); index++ {
+			for i, txn := range p.batches[p.next][index] {
+				sc := &scheduled{txn: txn, at: Position{Epoch: p.next, Sequencer: index, Index: i}}
+				for _, key := range []string{txn.From, txn.To} {
+					owner := sim.ownerOf(key)
+					switch {
+					case owner == p.id:
+						sc.keys = append(sc.keys, key)
+						p.locks[key] = append(p.locks[key], txn.ID)
+					case len(sc.peers) == 0 || sc.peers[0] != owner:
+						sc.peers = append(sc.peers, owner)
+					}
+				}
+				if len(sc.keys) == 0 {
+					continue
+				}
+				p.queue = append(p.queue, sc)
+			}
+		}
+		delete(p.batches, p.next)
+		p.next++
+	}
+}
+
+// execute runs, in the global order, every queued transaction that holds
+// its local locks and has the values the other participants read; a
+// transaction it cannot run keeps its locks and the ones after it that
+// need them wait (must hold p.mu)
+func (p *Partition) execute() {
+	sim := p.simulation
+	waiting := p.queue[:0]
+	for _, sc := range p.queue {
+		if !p.holds(sc) {
+			waiting = append(waiting, sc)
+			continue
+		}
+		if !sc.sent && len(sc.peers) > 0 {
+			sc.sent = true
+			values := make(map[string]int, len(sc.keys))
+			for _, key := range sc.keys {
+				values[key] = p.balances[key]
+			}
+			txn := sc.txn
+			payload := Payload{Txn: &txn, Values: values}
+			for _, peer := range sc.peers {
+				p.sentReads = append(p.sentReads, sentRead{epoch: sc.at.Epoch, to: peer, payload: payload})
+				sim.send(p.id, peer, MsgRemoteRead, payload)
+			}
+		}
+		values, ok := p.readSet(sc)
+		if !ok {
+			p.stalled++
+			waiting = append(waiting, sc)
+			continue
+		}
+
+		txn := sc.txn
+		applied := values[txn.From] >= txn.Amount
+		delta := 0
+		if applied {
+			for _, key := range sc.keys {
+				switch key {
+				case txn.From:
+					p.balances[key] -= txn.Amount
+					delta -= txn.Amount
+				case txn.To:
+					p.balances[key] += txn.Amount
+					delta += txn.Amount
+				}
+			}
+		}
+		for _, key := range sc.keys {
+			p.locks[key] = p.locks[key][1:]
+		}
+		p.done[txn.ID] = true
+		delete(p.reads, txn.ID)
+		p.executed++
+
+		sim.executed(p.id, txn, sc.at, sc.keys, applied, delta)
+		if sim.ownerOf(txn.From) == p.id {
+			reply := Payload{Txn: &txn, OK: applied}
+			if !applied {
+				reply.Reason = "insufficient_funds"
+			}
+			sim.send(p.id, txn.Client, MsgResult, reply)
+		}
+	}
+	p.queue = waiting
+}
+
+// holds reports whether the transaction is first in line for every local
+// key it locks (must hold p.mu)
+func (p *Partition) holds(sc *scheduled) bool {
+	for _, key := range sc.keys {
+		if queue := p.locks[key]; len(queue) == 0 || queue[0] != sc.txn.ID {
+			return false
+		}
+	}
+	return true
+}
+
+// readSet returns the values of every key of the transaction, once the
+// other participants sent theirs (must hold p.mu)
+func (p *Partition) readSet(sc *scheduled) (map[string]int, bool) {
+	values := make(map[string]int, 2)
+	for _, key := range sc.keys {
+		values[key] = p.balances[key]
+	}
+	for _, key := range []string{sc.txn.From, sc.txn.To} {
+		if _, ok := values[key]; ok {
+			continue
+		}
+		value, ok := p.reads[sc.txn.ID][key]
+		if !ok {
+			return nil, false
+		}
+		values[key] = value
+	}
+	return values, true
+}
+
+// recover asks the sequencers and the other partitions for what was sent
+// while the partition was down (must hold p.mu)
+func (p *Partition) recover() {
+	sim := p.simulation
+	oldest := p.next
+	for _, sc := range p.queue {
+		oldest = min(oldest, sc.at.Epoch)
+	}
+	for _, sequencer := range sim.sequencers {
+		sim.send(p.id, sequencer.id, MsgReplay, Payload{Epoch: p.next})
+	}
+	for _, partition := range sim.partitions {
+		if partition.id != p.id {
+			sim.send(p.id, partition.id, MsgReplay, Payload{Epoch: oldest})
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package calvin
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+const (
+	MsgSubmit transport.MessageType = "submit"
+	MsgBatch  transport.MessageType = "batch"
+	MsgReplay transport.MessageType = "replay"
+)
+
+const epochTicks = 10 // Ticks a sequencer collects transactions before sealing a batch
+
+// Txn is a transfer between two accounts. Its logic is deterministic: it
+// applies when the source holds the amount and aborts otherwise, so every
+// partition executing it reaches the same outcome.
+type Txn struct {
+	ID     string `json:"id"`
+	Client string `json:"client"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Amount int    `json:"amount"`
+}
+
+// Batch is what a sequencer collected during an epoch. The global order
+// is the batches of each epoch in sequencer order, each batch in the
+// order its transactions arrived.
+type Batch struct {
+	Epoch     int   `json:"epoch"`
+	Sequencer int   `json:"sequencer"`
+	Txns      []Txn `json:"txns"`
+}
+
+// Position is where a transaction stands in the global order
+type Position struct {
+	Epoch     int `json:"epoch"`
+	Sequencer int `json:"sequencer"`
+	Index     int `json:"index"`
+}
+
+// Before reports whether the position comes earlier in the global order
+func (p Position) Before(o Position) bool {
+	if p.Epoch != o.Epoch {
+		return p.Epoch < o.Epoch
+	}
+	if p.Sequencer != o.Sequencer {
+		return p.Sequencer < o.Sequencer
+	}
+	return p.Index < o.Index
+}
+
+// Sequencer collects the transactions clients submit and, once per epoch,
+// sends the batch to every partition, an empty one if nothing came in:
+// the partitions cannot execute an epoch before every sequencer's batch
+// for it arrived. Calvin replicates the batches with Paxos before sending
+// them; this sequencer keeps its log to itself and replays it to a
+// partition that comes back from a crash.
+type Sequencer struct {
+	mu sync.RWMutex
+
+	id     string
+	index  int
+	status string // "running" or "crashed"
+	ticks  int
+
+	pending []Txn
+	epoch   int     // Last epoch sealed
+	log     []Batch // Every batch sealed, by epoch
+
+	inbox      chan *transport.Envelope
+	simulation *Simulation
+}
+
+func newSequencer(id string, index int, sim *Simulation) *Sequencer {
+	return &Sequencer{
+		id:         id,
+		index:      index,
+		status:     "running",
+		inbox:      make(chan *transport.Envelope, 500),
+		simulation: sim,
+	}
+}
+
+// Sequencer implements engine.NodeController
+
+func (q *Sequencer) ID() string {
+	return q.id
+}
+
+func (q *Sequencer) Start(ctx context.Context) error {
+	return nil
+}
+
+func (q *Sequencer) Stop() error {
+	return nil
+}
+
+func (q *Sequencer) Tick() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.ticks++
+	if q.status == "crashed" {
+		return
+	}
+
+	sim := q.simulation
+	for i := 0; i < 50; i++ {
+		select {
+		case env := <-q.inbox:
+			payload := sim.received(env)
+			switch env.Type {
+			case MsgSubmit:
+				if payload.Txn != nil {
+					q.pending = append(q.pending, *payload.Txn)
+				}
+			case MsgReplay:
+				for _, batch := range q.log[min(max(payload.Epoch-1, 0), len(q.log)):] {
+					sim.send(q.id, env.From, MsgBatch, Payload{Batch: &batch})
+				}
+			}
+			continue
+		default:
+		}
+		break
+	}
+
+	// Seal every epoch that ended, the ones missed while crashed empty
+	for q.epoch < q.ticks/epochTicks {
+		q.epoch++
+		batch := Batch{Epoch: q.epoch, Sequencer: q.index, Txns: []Txn{}}
+		if q.epoch == q.ticks/epochTicks {
+			batch.Txns = q.pending
+			q.pending = nil
+		}
+		q.log = append(q.log, batch)
+		for _, partition := range sim.partitions {
+			sim.send(q.id, partition.id, MsgBatch, Payload{Batch: &batch})
+		}
+		sim.sealed(q.id, batch)
+	}
+}
+
+func (q *Sequencer) GetState() map[string]interface{} {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	return map[string]interface{}{
+		"id":      q.id,
+		"status":  q.status,
+		"role":    "sequencer",
+		"epoch":   q.epoch,
+		"pending": len(q.pending),
+	}
+}
+
+func (q *Sequencer) handleMessage(env *transport.Envelope) {
+	q.mu.RLock()
+	down := q.status == "crashed"
+	q.mu.RUnlock()
+
+	if down {
+		return
+	}
+	select {
+	case q.inbox <- env:
+	default:
+	}
+}
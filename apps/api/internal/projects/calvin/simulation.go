@@ -0,0 +1,532 @@
+package calvin
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+	"github.com/ersantana/distributed-systems-learning/packages/simulation/engine"
+)
+
+const (
+	accountCount   = 6
+	initialBalance = 100
+	sequencerCount = 2
+)
+
+// Payload is the content of all messages exchanged in this project
+type Payload struct {
+	Txn    *Txn           `json:"txn,omitempty"`
+	Batch  *Batch         `json:"batch,omitempty"`
+	Values map[string]int `json:"values,omitempty"` // Remote read: local values of a participant
+	Epoch  int            `json:"epoch,omitempty"`  // Replay: resend from this epoch on
+	OK     bool           `json:"ok,omitempty"`
+	Reason string         `json:"reason,omitempty"`
+}
+
+// Simulation runs Calvin's deterministic transactions over the accounts
+// of the Percolator project, with the same transfers. Clients submit
+// transfers to sequencers, which seal what they got into a batch every
+// epoch; the batches of an epoch, in sequencer order, extend one global
+// order every partition executes. Since the order is settled before
+// anything runs, and the transactions are deterministic, every
+// participant reaches the same outcome on its own: there is no prepare,
+// no vote and no commit round, and a conflict never aborts anything. The
+// price is latency, a transaction waits for its epoch to end, and a
+// transaction waiting for a remote read holds up everything behind it.
+//
+// The metadata compares the messages sent with what classic two-phase
+// commit would need for the same transactions: a request and a reply, a
+// read and its reply per key, and prepare, vote, commit and ack per
+// participant. Scenario "hot_spot" makes every transfer touch acct-1.
+type Simulation struct {
+	mu sync.RWMutex
+
+	engine    *engine.Engine
+	rng       *rand.Rand
+	transport *transport.NetworkTransport
+	broadcast func(interface{})
+
+	sequencers []*Sequencer
+	partitions []*Partition
+	clients    []*Client
+	accounts   []string
+	owners     map[string]string // Account -> partition
+	scenario   string
+
+	lastWrite map[string]Position        // Key -> position of the last transaction run on it
+	keyWrites int                        // Transactions run, counted once per key
+	reordered int                        // Of those, run on a key after a later transaction
+	outcomes  map[string]map[string]bool // Transaction -> partition -> applied
+
+	commits        int
+	aborts         int
+	multiPartition int
+	latency        int
+	messages       int
+	twoPhase       int // Messages two-phase commit would have sent for the same transactions
+
+	running bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// Config for Calvin simulation
+type Config struct {
+	NodeCount int    // Partitions
+	Scenario  string // "transfers", "hot_spot"
+}
+
+// NewSimulation creates a new Calvin simulation
+func NewSimulation(eng *engine.Engine, trans *transport.NetworkTransport, broadcast func(interface{}), config Config) *Simulation {
+	switch config.Scenario {
+	case "transfers", "hot_spot":
+	default:
+		config.Scenario = "transfers"
+	}
+	if config.NodeCount == 0 {
+		config.NodeCount = 3
+	}
+	config.NodeCount = max(config.NodeCount, 2)
+
+	sim := &Simulation{
+		engine:    eng,
+		rng:       eng.Rand(),
+		transport: trans,
+		broadcast: broadcast,
+		owners:    make(map[string]string),
+		scenario:  config.Scenario,
+		lastWrite: make(map[string]Position),
+		outcomes:  make(map[string]map[string]bool),
+	}
+
+	trans.SetLatency(20*time.Millisecond, 80*time.Millisecond)
+	trans.SetPacketLoss(0)
+
+	for i := 0; i < sequencerCount; i++ {
+		sequencer := newSequencer(fmt.Sprintf("sequencer-%d", i+1), i+1, sim)
+		sim.sequencers = append(sim.sequencers, sequencer)
+		trans.RegisterHandler(sequencer.id, sequencer.handleMessage)
+		eng.AddNode(sequencer)
+	}
+
+	owned := make(map[string][]string)
+	for i := 0; i < accountCount; i++ {
+		account := fmt.Sprintf("acct-%d", i+1)
+		partition := fmt.Sprintf("partition-%d", i%config.NodeCount+1)
+		sim.accounts = append(sim.accounts, account)
+		sim.owners[account] = partition
+		owned[partition] = append(owned[partition], account)
+	}
+	for i := 0; i < config.NodeCount; i++ {
+		id := fmt.Sprintf("partition-%d", i+1)
+		partition := newPartition(id, owned[id], sim)
+		sim.partitions = append(sim.partitions, partition)
+		trans.RegisterHandler(id, partition.handleMessage)
+		eng.AddNode(partition)
+	}
+
+	for i := 0; i < 2; i++ {
+		sequencer := sim.sequencers[i%sequencerCount].id
+		client := newClient(fmt.Sprintf("client-%d", i+1), sequencer, i+1, sim)
+		sim.clients = append(sim.clients, client)
+		trans.RegisterHandler(client.id, client.handleMessage)
+		eng.AddNode(client)
+	}
+
+	return sim
+}
+
+// Start starts the simulation
+func (s *Simulation) Start(ctx context.Context) error {
+	s.mu.Lock()
+	s.running = true
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.mu.Unlock()
+
+	return s.engine.Start(ctx)
+}
+
+// Stop stops the simulation
+func (s *Simulation) Stop() error {
+	s.mu.Lock()
+	s.running = false
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Unlock()
+
+	return s.engine.Stop()
+}
+
+// GetState returns the current simulation state
+func (s *Simulation) GetState() *protocol.SimulationStateResponse {
+	nodes := make(map[string]protocol.NodeState)
+
+	epoch := 0
+	for _, sequencer := range s.sequencers {
+		state := sequencer.GetState()
+		nodes[sequencer.id] = protocol.NodeState{
+			ID:          sequencer.id,
+			Status:      state["status"].(string),
+			Role:        "sequencer",
+			CustomState: state,
+		}
+		epoch = max(epoch, state["epoch"].(int))
+	}
+
+	balances := make(map[string]int)
+	total, stalled := 0, 0
+	for _, partition := range s.partitions {
+		state := partition.GetState()
+		nodes[partition.id] = protocol.NodeState{
+			ID:          partition.id,
+			Status:      state["status"].(string),
+			Role:        "partition",
+			CustomState: state,
+		}
+		for key, balance := range state["balances"].(map[string]int) {
+			balances[key] = balance
+			total += balance
+		}
+		stalled += state["stalled"].(int)
+	}
+
+	for _, client := range s.clients {
+		state := client.GetState()
+		nodes[client.id] = protocol.NodeState{
+			ID:          client.id,
+			Status:      state["status"].(string),
+			Role:        "client",
+			CustomState: state,
+		}
+	}
+
+	s.mu.RLock()
+	running := s.running
+	done := s.commits + s.aborts
+	perTxn, twoPhasePerTxn, avgLatency := 0.0, 0.0, 0.0
+	if done > 0 {
+		perTxn = float64(s.messages) / float64(done)
+		twoPhasePerTxn = float64(s.twoPhase) / float64(done)
+		avgLatency = float64(s.latency) / float64(done)
+	}
+	metadata := map[string]interface{}{
+		"scenario":         s.scenario,
+		"epoch":            epoch,
+		"owners":           s.owners,
+		"balances":         balances,
+		"totalBalance":     total, // Off while partitions are at different points of the order
+		"commits":          s.commits,
+		"aborts":           s.aborts, // Transfers the source could not cover
+		"multiPartition":   s.multiPartition,
+		"stalled":          stalled,
+		"avgLatency":       avgLatency, // Ticks from submission to outcome
+		"messages":         s.messages,
+		"messagesPerTxn":   perTxn,
+		"twoPhaseMessages": s.twoPhase,
+		"twoPhasePerTxn":   twoPhasePerTxn,
+	}
+	s.mu.RUnlock()
+
+	mode := "step"
+	if s.engine != nil {
+		mode = s.engine.GetMode().String()
+	}
+
+	return &protocol.SimulationStateResponse{
+		Type:        protocol.MsgSimulationState,
+		VirtualTime: time.Now().UnixMilli(),
+		Mode:        mode,
+		Speed:       1.0,
+		Running:     running,
+		Nodes:       nodes,
+		Metadata:    metadata,
+	}
+}
+
+// GetNodes returns node states
+func (s *Simulation) GetNodes() map[string]protocol.NodeState {
+	state := s.GetState()
+	return state.Nodes
+}
+
+// Layout draws the clients, the sequencers and the partitions as three
+// columns, the way a transaction flows through them
+func (s *Simulation) Layout() *protocol.Layout {
+	clients := make([]string, len(s.clients))
+	for i, client := range s.clients {
+		clients[i] = client.id
+	}
+	sequencers := make([]string, len(s.sequencers))
+	for i, sequencer := range s.sequencers {
+		sequencers[i] = sequencer.id
+	}
+	partitions := make([]string, len(s.partitions))
+	for i, partition := range s.partitions {
+		partitions[i] = partition.id
+	}
+	return &protocol.Layout{
+		Kind: protocol.LayoutGroups,
+		Groups: []protocol.LayoutGroup{
+			{Name: "clients", Nodes: clients},
+			{Name: "sequencers", Nodes: sequencers},
+			{Name: "partitions", Nodes: partitions},
+		},
+	}
+}
+
+// CrashNode crashes a node. Every partition waits for every sequencer's
+// batch, so a crashed sequencer stops them all; a crashed partition
+// stops the transactions waiting for its reads. Both catch up on what
+// they missed once they recover.
+func (s *Simulation) CrashNode(nodeID string) error {
+	return s.setStatus(nodeID, "crashed")
+}
+
+// RecoverNode recovers a crashed node; a partition asks for the batches
+// and the remote reads it missed
+func (s *Simulation) RecoverNode(nodeID string) error {
+	return s.setStatus(nodeID, "running")
+}
+
+// setStatus crashes or recovers a node
+func (s *Simulation) setStatus(nodeID, status string) error {
+	for _, sequencer := range s.sequencers {
+		if sequencer.id == nodeID {
+			sequencer.mu.Lock()
+			sequencer.status = status
+			sequencer.mu.Unlock()
+			return nil
+		}
+	}
+	for _, partition := range s.partitions {
+		if partition.id == nodeID {
+			partition.mu.Lock()
+			defer partition.mu.Unlock()
+			recovering := status == "running" && partition.status == "crashed"
+			partition.status = status
+			if recovering {
+				partition.recover()
+			}
+			return nil
+		}
+	}
+	if client := s.findClient(nodeID); client != nil {
+		client.mu.Lock()
+		client.status = status
+		client.mu.Unlock()
+		return nil
+	}
+	return fmt.Errorf("unknown node: %s", nodeID)
+}
+
+// HandleClientRequest runs a client command: "transfer" submits a
+// transfer, {nodeId, from, to, amount}, on the client's next tick
+func (s *Simulation) HandleClientRequest(command string, payload map[string]interface{}) error {
+	if command != "transfer" {
+		return fmt.Errorf("unknown command: %s", command)
+	}
+	nodeID, _ := payload["nodeId"].(string)
+	from, _ := payload["from"].(string)
+	to, _ := payload["to"].(string)
+	amount, _ := payload["amount"].(float64)
+	return s.transfer(nodeID, from, to, int(amount))
+}
+
+// NodeActions lists the actions of a node: a "transfer" between two
+// random accounts on a client
+func (s *Simulation) NodeActions(nodeID string) []string {
+	client := s.findClient(nodeID)
+	if client == nil {
+		return nil
+	}
+	client.mu.RLock()
+	defer client.mu.RUnlock()
+
+	if client.status != "running" {
+		return nil
+	}
+	return []string{"transfer"}
+}
+
+// InvokeNodeAction invokes an action listed by NodeActions
+func (s *Simulation) InvokeNodeAction(nodeID, action string, params map[string]interface{}) error {
+	if action != "transfer" {
+		return fmt.Errorf("unknown action: %s", action)
+	}
+	from, to := s.pickAccounts()
+	return s.transfer(nodeID, from, to, s.rng.Intn(maxAmount)+1)
+}
+
+// transfer queues a transfer on a client
+func (s *Simulation) transfer(nodeID, from, to string, amount int) error {
+	client := s.findClient(nodeID)
+	if client == nil {
+		return fmt.Errorf("not a client: %s", nodeID)
+	}
+	if _, ok := s.owners[from]; !ok {
+		return fmt.Errorf("unknown account: %s", from)
+	}
+	if _, ok := s.owners[to]; !ok || to == from {
+		return fmt.Errorf("invalid destination account: %s", to)
+	}
+	if amount <= 0 {
+		return fmt.Errorf("amount must be positive")
+	}
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if client.status != "running" {
+		return fmt.Errorf("client %s is down", nodeID)
+	}
+	client.requested = append(client.requested, Txn{From: from, To: to, Amount: amount})
+	return nil
+}
+
+// findClient looks up a client by ID
+func (s *Simulation) findClient(nodeID string) *Client {
+	for _, client := range s.clients {
+		if client.id == nodeID {
+			return client
+		}
+	}
+	return nil
+}
+
+// ownerOf returns the partition storing an account
+func (s *Simulation) ownerOf(key string) string {
+	return s.owners[key]
+}
+
+// pickAccounts returns the two accounts of a random transfer; in
+// "hot_spot" one of them is always acct-1
+func (s *Simulation) pickAccounts() (string, string) {
+	from := s.rng.Intn(len(s.accounts))
+	to := (from + 1 + s.rng.Intn(len(s.accounts)-1)) % len(s.accounts)
+	if s.scenario == "hot_spot" {
+		from, to = 0, 1+s.rng.Intn(len(s.accounts)-1)
+		if s.rng.Intn(2) == 0 {
+			from, to = to, from
+		}
+	}
+	return s.accounts[from], s.accounts[to]
+}
+
+// sealed records a batch a sequencer sent to the partitions
+func (s *Simulation) sealed(sequencerID string, batch Batch) {
+	if len(batch.Txns) == 0 {
+		return
+	}
+	ids := make([]string, len(batch.Txns))
+	for i, txn := range batch.Txns {
+		ids[i] = txn.ID
+	}
+	s.broadcast(map[string]interface{}{
+		"type":   "batch_sealed",
+		"nodeId": sequencerID,
+		"epoch":  batch.Epoch,
+		"txns":   ids,
+	})
+}
+
+// executed records a partition running a transaction of the global order
+// on its keys
+func (s *Simulation) executed(partitionID string, txn Txn, at Position, keys []string, applied bool, delta int) {
+	s.mu.Lock()
+	for _, key := range keys {
+		s.keyWrites++
+		if last, ok := s.lastWrite[key]; ok && at.Before(last) {
+			s.reordered++
+		}
+		s.lastWrite[key] = at
+	}
+	if s.outcomes[txn.ID] == nil {
+		s.outcomes[txn.ID] = make(map[string]bool)
+	}
+	s.outcomes[txn.ID][partitionID] = applied
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":    "txn_executed",
+		"nodeId":  partitionID,
+		"txn":     txn.ID,
+		"epoch":   at.Epoch,
+		"applied": applied,
+		"delta":   delta, // Change to the partition's total balance
+	})
+}
+
+// completed records the outcome of a transfer reaching its client
+func (s *Simulation) completed(clientID string, txn Txn, ok bool, reason string, latency int) {
+	participants := 1
+	if s.ownerOf(txn.From) != s.ownerOf(txn.To) {
+		participants = 2
+	}
+
+	s.mu.Lock()
+	if ok {
+		s.commits++
+	} else {
+		s.aborts++
+	}
+	if participants > 1 {
+		s.multiPartition++
+	}
+	s.latency += latency
+	s.twoPhase += 2 + 2*2 + 4*participants
+	s.mu.Unlock()
+
+	kind := "txn_committed"
+	if !ok {
+		kind = "txn_aborted"
+	}
+	s.broadcast(map[string]interface{}{
+		"type":    kind,
+		"nodeId":  clientID,
+		"txn":     txn.ID,
+		"from":    txn.From,
+		"to":      txn.To,
+		"amount":  txn.Amount,
+		"reason":  reason,
+		"latency": latency,
+	})
+}
+
+// send transmits a message and reports it to the frontend
+func (s *Simulation) send(from, to string, msgType transport.MessageType, payload Payload) {
+	env := transport.NewEnvelope(from, to, msgType, payload)
+
+	s.mu.Lock()
+	s.messages++
+	s.mu.Unlock()
+
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageSent,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+
+	s.transport.Send(s.ctx, env)
+}
+
+// received reports a delivered message and returns its payload
+func (s *Simulation) received(env *transport.Envelope) Payload {
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageReceived,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+	payload, _ := env.Payload.(Payload)
+	return payload
+}
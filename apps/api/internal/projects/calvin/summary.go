@@ -0,0 +1,62 @@
+package calvin
+
+import (
+	"fmt"
+
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+)
+
+// Invariants judges the run on what replaces two-phase commit: the
+// transactions touching a key run in the global order, wherever the key
+// lives, the
+// participants of a transaction reach the same outcome without voting,
+// and that agreement costs fewer messages than the votes would
+func (s *Simulation) Invariants() []protocol.InvariantResult {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	split := 0
+	for _, outcomes := range s.outcomes {
+		seen := make(map[bool]bool)
+		for _, applied := range outcomes {
+			seen[applied] = true
+		}
+		if len(seen) > 1 {
+			split++
+		}
+	}
+
+	return []protocol.InvariantResult{
+		{
+			Name:   "conflicting transactions run in the global order",
+			Holds:  s.reordered == 0,
+			Detail: fmt.Sprintf("%d of %d writes to a key came after a write that follows them in the order", s.reordered, s.keyWrites),
+		},
+		{
+			Name:   "participants reach the same outcome",
+			Holds:  split == 0,
+			Detail: fmt.Sprintf("%d of %d transactions applied on one partition and aborted on another; %d transfers aborted, none on a conflict", split, len(s.outcomes), s.aborts),
+		},
+		{
+			Name:   "fewer messages than two-phase commit",
+			Holds:  s.messages <= s.twoPhase,
+			Detail: fmt.Sprintf("%d messages for %d transactions (%d spanning partitions); two-phase commit would send %d", s.messages, s.commits+s.aborts, s.multiPartition, s.twoPhase),
+		},
+	}
+}
+
+// FollowUps suggests the scenarios that contrast with this one
+func (s *Simulation) FollowUps() []protocol.FollowUp {
+	followUps := []protocol.FollowUp{
+		{Project: "percolator", Scenario: "transfers", Reason: "Run the same transfers with two-phase commit and compare the messages per transaction"},
+		{Project: "state-machine", Reason: "Order every command through one log instead of per-epoch batches"},
+	}
+	if s.scenario == "hot_spot" {
+		return append([]protocol.FollowUp{
+			{Project: "calvin", Scenario: "transfers", Reason: "Spread the transfers and let non-conflicting ones run side by side"},
+		}, followUps...)
+	}
+	return append([]protocol.FollowUp{
+		{Project: "calvin", Scenario: "hot_spot", Reason: "Make every transfer touch one account: it serializes but nothing aborts"},
+	}, followUps...)
+}
@@ -0,0 +1,317 @@
+package cap
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+const (
+	MsgSync         transport.MessageType = "sync"
+	MsgForward      transport.MessageType = "forward"
+	MsgForwardReply transport.MessageType = "forward_reply"
+)
+
+const (
+	syncEvery   = 5  // Ticks between two syncs of a replica's register to the other
+	peerTimeout = 12 // Ticks without hearing from the primary before the backup gives up on it
+	opTimeout   = 10 // Ticks a forwarded operation waits for the primary
+)
+
+// Register is the replicated value with the version vector of the writes
+// it reflects. Concurrent registers are resolved by last writer wins on
+// (Lamport, Writer).
+type Register struct {
+	Value   string         `json:"value"`
+	Lamport int            `json:"lamport"`
+	Writer  string         `json:"writer"` // Replica that wrote the value, "" before the first write
+	Clock   map[string]int `json:"clock"`
+}
+
+// covers reports whether the register reflects a replica's first n
+// writes
+func (r Register) covers(replica string, n int) bool {
+	return r.Clock[replica] >= n
+}
+
+// compare returns 1 if r descends from o, -1 if o descends from r, 0 if
+// they are equal and 2 if they are concurrent
+func (r Register) compare(o Register) int {
+	greater, less := false, false
+	for id := range union(r.Clock, o.Clock) {
+		switch {
+		case r.Clock[id] > o.Clock[id]:
+			greater = true
+		case r.Clock[id] < o.Clock[id]:
+			less = true
+		}
+	}
+	switch {
+	case greater && less:
+		return 2
+	case greater:
+		return 1
+	case less:
+		return -1
+	}
+	return 0
+}
+
+func (r Register) copy() Register {
+	clock := make(map[string]int, len(r.Clock))
+	for id, n := range r.Clock {
+		clock[id] = n
+	}
+	r.Clock = clock
+	return r
+}
+
+func union(a, b map[string]int) map[string]bool {
+	ids := make(map[string]bool, len(a)+len(b))
+	for id := range a {
+		ids[id] = true
+	}
+	for id := range b {
+		ids[id] = true
+	}
+	return ids
+}
+
+// op is a client operation a backup forwarded to the primary
+type op struct {
+	id     int
+	kind   string // "read", "write"
+	value  string
+	issued int
+	seen   map[string]int // Writes acknowledged when a read was issued
+}
+
+// Replica holds a copy of the register. When available it serves reads
+// and writes from its own copy and syncs with the other replica in the
+// background; when consistent the backup forwards everything to the
+// primary and turns clients away when it cannot reach it.
+type Replica struct {
+	mu sync.RWMutex
+
+	id       string
+	status   string // "running" or "crashed"
+	primary  bool
+	register Register
+	writes   int // Writes this replica accepted
+	heardAt  int // Tick the other replica was last heard from
+	waiting  map[int]op
+	nextOp   int
+	ticks    int
+
+	inbox      chan *transport.Envelope
+	simulation *Simulation
+}
+
+func newReplica(id string, primary bool, sim *Simulation) *Replica {
+	return &Replica{
+		id:         id,
+		status:     "running",
+		primary:    primary,
+		register:   Register{Clock: make(map[string]int)},
+		waiting:    make(map[int]op),
+		inbox:      make(chan *transport.Envelope, 500),
+		simulation: sim,
+	}
+}
+
+// Replica implements engine.NodeController
+
+func (r *Replica) ID() string {
+	return r.id
+}
+
+func (r *Replica) Start(ctx context.Context) error {
+	return nil
+}
+
+func (r *Replica) Stop() error {
+	return nil
+}
+
+func (r *Replica) Tick() {
+	r.simulation.advanceSchedule(r.tick())
+}
+
+func (r *Replica) tick() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.ticks++
+	if r.status == "crashed" {
+		return r.ticks
+	}
+
+	for i := 0; i < 50; i++ {
+		select {
+		case env := <-r.inbox:
+			r.processMessage(env)
+			continue
+		default:
+		}
+		break
+	}
+
+	if r.ticks%syncEvery == 0 {
+		r.sync()
+	}
+	for id, o := range r.waiting {
+		if r.simulation.tickOf()-o.issued >= opTimeout {
+			delete(r.waiting, id)
+			r.simulation.rejected(r.id, o.kind, "primary did not answer")
+		}
+	}
+	return r.ticks
+}
+
+func (r *Replica) GetState() map[string]interface{} {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return map[string]interface{}{
+		"id":       r.id,
+		"status":   r.status,
+		"primary":  r.primary,
+		"register": r.register.copy(),
+		"writes":   r.writes,
+		"waiting":  len(r.waiting),
+	}
+}
+
+func (r *Replica) handleMessage(env *transport.Envelope) {
+	r.mu.RLock()
+	down := r.status == "crashed"
+	r.mu.RUnlock()
+
+	if down {
+		return
+	}
+	select {
+	case r.inbox <- env:
+	default:
+	}
+}
+
+func (r *Replica) processMessage(env *transport.Envelope) {
+	sim := r.simulation
+	payload := sim.received(env)
+	r.heardAt = r.ticks
+
+	switch env.Type {
+	case MsgSync:
+		if payload.Register != nil {
+			r.merge(*payload.Register)
+		}
+
+	case MsgForward:
+		// The primary runs an operation for the backup's client
+		reply := Payload{OpID: payload.OpID, Kind: payload.Kind}
+		if payload.Kind == "write" {
+			r.write(payload.Value)
+			reply.Written = r.writes
+		}
+		register := r.register.copy()
+		reply.Register = &register
+		sim.send(r.id, env.From, MsgForwardReply, reply)
+
+	case MsgForwardReply:
+		o, ok := r.waiting[payload.OpID]
+		if !ok || payload.Register == nil {
+			return
+		}
+		delete(r.waiting, payload.OpID)
+		r.merge(*payload.Register)
+		if o.kind == "write" {
+			sim.writeAcked(r.id, env.From, payload.Written)
+		} else {
+			sim.readDone(r.id, o.seen, *payload.Register)
+		}
+	}
+}
+
+// other returns the other replica's ID
+func (r *Replica) other() string {
+	for _, replica := range r.simulation.replicas {
+		if replica.id != r.id {
+			return replica.id
+		}
+	}
+	return ""
+}
+
+// sync sends the register to the other replica, which also tells it this
+// one is reachable (must hold r.mu)
+func (r *Replica) sync() {
+	register := r.register.copy()
+	r.simulation.send(r.id, r.other(), MsgSync, Payload{Register: &register})
+}
+
+// write sets the register, bumping this replica's entry of the version
+// vector (must hold r.mu)
+func (r *Replica) write(value string) {
+	r.writes++
+	r.register.Value = value
+	r.register.Lamport++
+	r.register.Writer = r.id
+	r.register.Clock[r.id] = r.writes
+}
+
+// merge folds the other replica's register into this one. A register
+// that descends from ours replaces it; a concurrent one means both sides
+// accepted writes the other did not see, and last writer wins keeps one
+// value and silently drops the other. (must hold r.mu)
+func (r *Replica) merge(other Register) {
+	switch r.register.compare(other) {
+	case -1:
+		r.register = other.copy()
+		return
+	case 2:
+		winner, loser := r.register, other
+		if other.Lamport > winner.Lamport || (other.Lamport == winner.Lamport && other.Writer > winner.Writer) {
+			winner, loser = other, r.register
+		}
+		r.simulation.conflict(r.id, winner, loser)
+		merged := winner.copy()
+		merged.Lamport = max(r.register.Lamport, other.Lamport)
+		for id := range union(r.register.Clock, other.Clock) {
+			merged.Clock[id] = max(r.register.Clock[id], other.Clock[id])
+		}
+		r.register = merged
+	}
+}
+
+// clientOp runs a client's operation. When available the replica answers
+// from its own copy; when consistent the primary does, and the backup
+// forwards to it, or rejects the operation when the primary has not been
+// heard from lately. (must hold r.mu)
+func (r *Replica) clientOp(kind, value string, seen map[string]int) {
+	sim := r.simulation
+	if r.status != "running" {
+		sim.rejected(r.id, kind, "replica is down")
+		return
+	}
+
+	if sim.consistent() && !r.primary {
+		if r.ticks-r.heardAt > peerTimeout {
+			sim.rejected(r.id, kind, "cut off from the primary")
+			return
+		}
+		r.nextOp++
+		r.waiting[r.nextOp] = op{id: r.nextOp, kind: kind, value: value, issued: sim.tickOf(), seen: seen}
+		sim.send(r.id, r.other(), MsgForward, Payload{OpID: r.nextOp, Kind: kind, Value: value})
+		return
+	}
+
+	if kind == "write" {
+		r.write(value)
+		sim.writeAcked(r.id, r.id, r.writes)
+		r.sync()
+		return
+	}
+	sim.readDone(r.id, seen, r.register.copy())
+}
@@ -0,0 +1,471 @@
+package cap
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+	"github.com/ersantana/distributed-systems-learning/packages/simulation/engine"
+)
+
+const (
+	partitionEvery = 150 // Ticks in a cycle of the scheduled partition
+	partitionAt    = 50  // Tick of the cycle the partition starts
+	partitionFor   = 60  // Ticks the partition lasts
+)
+
+// Payload is the content of all messages exchanged in this project
+type Payload struct {
+	Register *Register `json:"register,omitempty"`
+	OpID     int       `json:"opId,omitempty"`
+	Kind     string    `json:"kind,omitempty"`
+	Value    string    `json:"value,omitempty"`
+	Written  int       `json:"written,omitempty"` // Primary's write count after a forwarded write
+}
+
+// Simulation is the CAP theorem on a register kept by two replicas, each
+// with a client of its own. A partition between them comes and goes on a
+// schedule, or when the user toggles it, and the replicas must give up
+// either consistency or availability while it lasts.
+//
+// When they "remain available" (scenario "available") each replica
+// serves its client from its own copy and syncs with the other in the
+// background. Every request is answered, but reads miss writes made on
+// the other side, and once the partition heals the two sides' writes
+// conflict and last writer wins silently drops one. When they "remain
+// consistent" (scenario "consistent") replica-a is the primary: the
+// backup forwards its client's requests to it and, cut off from it,
+// turns them away. No read is stale and no write lost, but the backup's
+// client is down for as long as the partition lasts.
+type Simulation struct {
+	mu sync.RWMutex
+
+	engine    *engine.Engine
+	transport *transport.NetworkTransport
+	broadcast func(interface{})
+
+	replicas    []*Replica
+	scenario    string
+	mode        string // "available" or "consistent"
+	partitioned bool
+	manual      bool // The user toggled the partition, which ends the schedule
+
+	acked map[string]int  // Writes of each replica acknowledged to a client
+	lost  map[string]bool // Acknowledged writes dropped by last writer wins
+
+	writes         int
+	ackedWrites    int
+	rejectedWrites int
+	reads          int
+	servedReads    int
+	rejectedReads  int
+	staleReads     int
+
+	lastTick int
+
+	running bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// Config for CAP simulation
+type Config struct {
+	Scenario string // "available", "consistent"
+}
+
+// NewSimulation creates a new CAP simulation
+func NewSimulation(eng *engine.Engine, trans *transport.NetworkTransport, broadcast func(interface{}), config Config) *Simulation {
+	switch config.Scenario {
+	case "available", "consistent":
+	default:
+		config.Scenario = "available"
+	}
+
+	sim := &Simulation{
+		engine:    eng,
+		transport: trans,
+		broadcast: broadcast,
+		scenario:  config.Scenario,
+		mode:      config.Scenario,
+		acked:     make(map[string]int),
+		lost:      make(map[string]bool),
+	}
+
+	trans.SetLatency(20*time.Millisecond, 60*time.Millisecond)
+	trans.SetPacketLoss(0)
+
+	for i, id := range []string{"replica-a", "replica-b"} {
+		replica := newReplica(id, i == 0, sim)
+		sim.replicas = append(sim.replicas, replica)
+		trans.RegisterHandler(id, replica.handleMessage)
+		eng.AddNode(replica)
+	}
+
+	return sim
+}
+
+// Start starts the simulation
+func (s *Simulation) Start(ctx context.Context) error {
+	s.mu.Lock()
+	s.running = true
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.mu.Unlock()
+
+	return s.engine.Start(ctx)
+}
+
+// Stop stops the simulation
+func (s *Simulation) Stop() error {
+	s.mu.Lock()
+	s.running = false
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Unlock()
+
+	return s.engine.Stop()
+}
+
+// GetState returns the current simulation state
+func (s *Simulation) GetState() *protocol.SimulationStateResponse {
+	nodes := make(map[string]protocol.NodeState)
+	values := make(map[string]string)
+	for _, replica := range s.replicas {
+		state := replica.GetState()
+		role := "backup"
+		if state["primary"].(bool) {
+			role = "primary"
+		}
+		nodes[replica.id] = protocol.NodeState{
+			ID:          replica.id,
+			Status:      state["status"].(string),
+			Role:        role,
+			CustomState: state,
+		}
+		values[replica.id] = state["register"].(Register).Value
+	}
+
+	s.mu.RLock()
+	running := s.running
+	answered, issued := s.ackedWrites+s.servedReads, s.writes+s.reads
+	availability := 1.0
+	if issued > 0 {
+		availability = float64(answered) / float64(issued)
+	}
+	metadata := map[string]interface{}{
+		"scenario":       s.scenario,
+		"mode":           s.mode,
+		"partitioned":    s.partitioned,
+		"scheduled":      !s.manual,
+		"values":         values,
+		"diverged":       values["replica-a"] != values["replica-b"],
+		"writes":         s.writes,
+		"ackedWrites":    s.ackedWrites,
+		"rejectedWrites": s.rejectedWrites,
+		"reads":          s.reads,
+		"servedReads":    s.servedReads,
+		"rejectedReads":  s.rejectedReads,
+		"staleReads":     s.staleReads,
+		"lostWrites":     len(s.lost),
+		"availability":   availability, // Requests answered, of those issued
+	}
+	s.mu.RUnlock()
+
+	mode := "step"
+	if s.engine != nil {
+		mode = s.engine.GetMode().String()
+	}
+
+	return &protocol.SimulationStateResponse{
+		Type:        protocol.MsgSimulationState,
+		VirtualTime: time.Now().UnixMilli(),
+		Mode:        mode,
+		Speed:       1.0,
+		Running:     running,
+		Nodes:       nodes,
+		Metadata:    metadata,
+	}
+}
+
+// GetNodes returns node states
+func (s *Simulation) GetNodes() map[string]protocol.NodeState {
+	state := s.GetState()
+	return state.Nodes
+}
+
+// CrashNode crashes a replica; it keeps its register
+func (s *Simulation) CrashNode(nodeID string) error {
+	replica := s.findReplica(nodeID)
+	if replica == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	replica.mu.Lock()
+	replica.status = "crashed"
+	replica.waiting = make(map[int]op)
+	replica.mu.Unlock()
+	return nil
+}
+
+// RecoverNode recovers a crashed replica
+func (s *Simulation) RecoverNode(nodeID string) error {
+	replica := s.findReplica(nodeID)
+	if replica == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	replica.mu.Lock()
+	replica.status = "running"
+	replica.heardAt = replica.ticks
+	replica.mu.Unlock()
+	return nil
+}
+
+// HandleClientRequest runs a client command. Commands are "write"
+// (payload: nodeId, value) and "read" (payload: nodeId) on a replica,
+// "partition" and "heal" which toggle the partition and end the
+// schedule, and "set_mode" (payload: mode, "available" or "consistent").
+func (s *Simulation) HandleClientRequest(command string, payload map[string]interface{}) error {
+	switch command {
+	case "write", "read":
+		nodeID, _ := payload["nodeId"].(string)
+		value, _ := payload["value"].(string)
+		if command == "write" && value == "" {
+			return fmt.Errorf("missing value")
+		}
+		return s.request(nodeID, command, value)
+	case "partition", "heal":
+		s.mu.Lock()
+		s.manual = true
+		s.mu.Unlock()
+		s.setPartition(command == "partition")
+		return nil
+	case "set_mode":
+		mode, _ := payload["mode"].(string)
+		if mode != "available" && mode != "consistent" {
+			return fmt.Errorf("mode must be available or consistent, got %q", mode)
+		}
+		s.mu.Lock()
+		s.mode = mode
+		s.mu.Unlock()
+		s.broadcast(map[string]interface{}{
+			"type": "mode_changed",
+			"mode": mode,
+		})
+		return nil
+	}
+	return fmt.Errorf("unknown command: %s", command)
+}
+
+// findReplica looks up a replica by ID; the replica list is fixed after
+// construction
+func (s *Simulation) findReplica(nodeID string) *Replica {
+	for _, replica := range s.replicas {
+		if replica.id == nodeID {
+			return replica
+		}
+	}
+	return nil
+}
+
+// consistent reports whether the replicas remain consistent rather than
+// available
+func (s *Simulation) consistent() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.mode == "consistent"
+}
+
+// tickOf returns the current tick of the simulation
+func (s *Simulation) tickOf() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lastTick
+}
+
+// advanceSchedule starts and heals the scheduled partition, and has each
+// replica's client write every fourth tick, the two clients taking
+// turns, and read every other tick
+func (s *Simulation) advanceSchedule(ticks int) {
+	s.mu.Lock()
+	if ticks <= s.lastTick {
+		s.mu.Unlock()
+		return
+	}
+	s.lastTick = ticks
+	toggle := false
+	if !s.manual {
+		cycle := ticks % partitionEvery
+		toggle = (cycle == partitionAt && !s.partitioned) || (cycle == partitionAt+partitionFor && s.partitioned)
+	}
+	partitioned := s.partitioned
+	s.mu.Unlock()
+
+	if toggle {
+		s.setPartition(!partitioned)
+	}
+
+	for i, replica := range s.replicas {
+		switch {
+		case ticks%4 == 2*i:
+			s.request(replica.id, "write", fmt.Sprintf("%s%d", replica.id[len(replica.id)-1:], ticks))
+		case ticks%2 == 1:
+			s.request(replica.id, "read", "")
+		}
+	}
+}
+
+// setPartition cuts the link between the replicas or restores it
+func (s *Simulation) setPartition(partitioned bool) {
+	s.mu.Lock()
+	s.partitioned = partitioned
+	manual := s.manual
+	s.mu.Unlock()
+
+	a, b := s.replicas[0].id, s.replicas[1].id
+	event := "partition_healed"
+	if partitioned {
+		s.transport.CreateBidirectionalPartition(a, b)
+		event = "partition_started"
+	} else {
+		s.transport.ClearBidirectionalPartition(a, b)
+	}
+	s.broadcast(map[string]interface{}{
+		"type":   event,
+		"nodes":  []string{a, b},
+		"manual": manual,
+	})
+}
+
+// request has a replica's client issue a read or a write. A read notes
+// which writes were acknowledged when it was issued: its answer is stale
+// if it misses one of them.
+func (s *Simulation) request(nodeID, kind, value string) error {
+	replica := s.findReplica(nodeID)
+	if replica == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+
+	s.mu.Lock()
+	var seen map[string]int
+	if kind == "write" {
+		s.writes++
+	} else {
+		s.reads++
+		seen = make(map[string]int, len(s.acked))
+		for id, n := range s.acked {
+			seen[id] = n
+		}
+	}
+	s.mu.Unlock()
+
+	replica.mu.Lock()
+	replica.clientOp(kind, value, seen)
+	replica.mu.Unlock()
+	return nil
+}
+
+// writeAcked records a write acknowledged to a replica's client; writer
+// wrote it as its nth write
+func (s *Simulation) writeAcked(nodeID, writer string, n int) {
+	s.mu.Lock()
+	s.ackedWrites++
+	s.acked[writer] = max(s.acked[writer], n)
+	s.mu.Unlock()
+}
+
+// readDone records a read answered with a register
+func (s *Simulation) readDone(nodeID string, seen map[string]int, register Register) {
+	missed := make([]string, 0)
+	for id, n := range seen {
+		if !register.covers(id, n) {
+			missed = append(missed, fmt.Sprintf("%s:%d", id, n))
+		}
+	}
+
+	s.mu.Lock()
+	s.servedReads++
+	if len(missed) > 0 {
+		s.staleReads++
+	}
+	s.mu.Unlock()
+
+	if len(missed) > 0 {
+		s.broadcast(map[string]interface{}{
+			"type":   "stale_read",
+			"nodeId": nodeID,
+			"value":  register.Value,
+			"missed": missed, // Acknowledged writes the read did not see
+		})
+	}
+}
+
+// rejected records a request a replica turned away
+func (s *Simulation) rejected(nodeID, kind, reason string) {
+	s.mu.Lock()
+	if kind == "write" {
+		s.rejectedWrites++
+	} else {
+		s.rejectedReads++
+	}
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":   "request_rejected",
+		"nodeId": nodeID,
+		"kind":   kind,
+		"reason": reason,
+	})
+}
+
+// conflict records two concurrent registers meeting on a replica. The
+// loser's last write was acknowledged to a client and is now gone; both
+// replicas resolve the same conflict, so each write is lost once.
+func (s *Simulation) conflict(nodeID string, winner, loser Register) {
+	lost := fmt.Sprintf("%s:%d", loser.Writer, loser.Clock[loser.Writer])
+
+	s.mu.Lock()
+	first := !s.lost[lost]
+	s.lost[lost] = true
+	s.mu.Unlock()
+
+	if first {
+		s.broadcast(map[string]interface{}{
+			"type":   "write_lost",
+			"nodeId": nodeID,
+			"kept":   winner.Value,
+			"lost":   loser.Value,
+		})
+	}
+}
+
+// send transmits a message and reports it to the frontend
+func (s *Simulation) send(from, to string, msgType transport.MessageType, payload Payload) {
+	env := transport.NewEnvelope(from, to, msgType, payload)
+
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageSent,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+
+	s.transport.Send(s.ctx, env)
+}
+
+// received reports a delivered message and returns its payload
+func (s *Simulation) received(env *transport.Envelope) Payload {
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageReceived,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+	payload, _ := env.Payload.(Payload)
+	return payload
+}
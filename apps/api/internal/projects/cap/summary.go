@@ -0,0 +1,50 @@
+package cap
+
+import (
+	"fmt"
+
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+)
+
+// Invariants judges the run on the three properties a partition forces
+// the replicas to choose between: every request answered, every read
+// seeing the writes acknowledged before it, and no acknowledged write
+// dropped when the sides meet again
+func (s *Simulation) Invariants() []protocol.InvariantResult {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rejected := s.rejectedWrites + s.rejectedReads
+	return []protocol.InvariantResult{
+		{
+			Name:   "every request is answered",
+			Holds:  rejected == 0,
+			Detail: fmt.Sprintf("%d of %d writes and %d of %d reads turned away (mode: %s)", s.rejectedWrites, s.writes, s.rejectedReads, s.reads, s.mode),
+		},
+		{
+			Name:   "reads see the acknowledged writes",
+			Holds:  s.staleReads == 0,
+			Detail: fmt.Sprintf("%d of %d reads missed a write acknowledged before them", s.staleReads, s.servedReads),
+		},
+		{
+			Name:   "acknowledged writes are not lost",
+			Holds:  len(s.lost) == 0,
+			Detail: fmt.Sprintf("%d of %d acknowledged writes dropped by last writer wins", len(s.lost), s.ackedWrites),
+		},
+	}
+}
+
+// FollowUps suggests the scenarios that contrast with this one
+func (s *Simulation) FollowUps() []protocol.FollowUp {
+	if s.scenario == "consistent" {
+		return []protocol.FollowUp{
+			{Project: "cap", Scenario: "available", Reason: "Keep answering through the partition and see what it costs"},
+			{Project: "quorum", Scenario: "strict", Reason: "Stay consistent on the majority side of a larger cluster"},
+		}
+	}
+	return []protocol.FollowUp{
+		{Project: "cap", Scenario: "consistent", Reason: "Turn requests away instead of letting the sides diverge"},
+		{Project: "quorum", Scenario: "hinted_handoff", Reason: "Stay available with a sloppy quorum and hinted handoff"},
+		{Project: "crdt", Reason: "Merge divergent writes without losing any"},
+	}
+}
@@ -0,0 +1,403 @@
+package chain
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+const (
+	MsgWrite        transport.MessageType = "write"
+	MsgAck          transport.MessageType = "ack"
+	MsgVersionQuery transport.MessageType = "version_query"
+	MsgVersionReply transport.MessageType = "version_reply"
+)
+
+const (
+	readCapacity    = 3  // Reads and version queries a node serves per tick
+	maxQueue        = 30 // Reads a node holds before turning more away
+	retransmitTicks = 10 // Ticks before a write not yet acknowledged is forwarded again
+	queryTimeout    = 20 // Ticks a read waits for the tail's version
+)
+
+// Version is one version of a key on a node. It is dirty from the moment
+// the node forwards it down the chain until the tail's acknowledgment
+// comes back up: only then is it known to be committed.
+type Version struct {
+	Version int64  `json:"version"`
+	Value   string `json:"value"`
+	Clean   bool   `json:"clean"`
+}
+
+// read is a read, or on the tail a version query, waiting to be served
+type read struct {
+	id     string
+	key    string
+	issued int   // Simulation tick the client issued the read
+	expect int64 // Version of the last write committed when it was issued
+	query  bool  // Version query from another node
+	from   string
+}
+
+// Node is a replica in the chain. Writes enter at the head and travel to
+// the tail, which commits them; acknowledgments travel back. A node
+// serves at most readCapacity reads a tick and queues the rest.
+type Node struct {
+	mu sync.RWMutex
+
+	id       string
+	status   string               // "running" or "crashed"
+	versions map[string][]Version // Oldest first; at most one clean version, the oldest
+	sentAt   map[int64]int        // Dirty version, tick it was last forwarded
+	queue    []read
+	waiting  map[string]read // Reads waiting for the tail's version, by query ID
+	nextID   int
+	served   int // Reads this node answered
+	queries  int // Version queries this node sent the tail
+	ticks    int
+
+	inbox      chan *transport.Envelope
+	simulation *Simulation
+}
+
+func newNode(id string, sim *Simulation) *Node {
+	return &Node{
+		id:         id,
+		status:     "running",
+		versions:   make(map[string][]Version),
+		sentAt:     make(map[int64]int),
+		queue:      make([]read, 0),
+		waiting:    make(map[string]read),
+		inbox:      make(chan *transport.Envelope, 500),
+		simulation: sim,
+	}
+}
+
+// Node implements engine.NodeController
+
+func (n *Node) ID() string {
+	return n.id
+}
+
+func (n *Node) Start(ctx context.Context) error {
+	return nil
+}
+
+func (n *Node) Stop() error {
+	return nil
+}
+
+func (n *Node) Tick() {
+	n.simulation.advanceSchedule(n.tick())
+}
+
+func (n *Node) tick() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.ticks++
+	if n.status == "crashed" {
+		return n.ticks
+	}
+
+	for i := 0; i < 50; i++ {
+		select {
+		case env := <-n.inbox:
+			n.processMessage(env)
+			continue
+		default:
+		}
+		break
+	}
+
+	n.serve()
+	n.retransmit()
+	n.expire()
+	return n.ticks
+}
+
+func (n *Node) GetState() map[string]interface{} {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	versions := make(map[string][]Version, len(n.versions))
+	latest := make(map[string]int64, len(n.versions))
+	dirty := make([]string, 0)
+	for key, held := range n.versions {
+		versions[key] = append([]Version{}, held...)
+		latest[key] = held[len(held)-1].Version
+		if !held[len(held)-1].Clean {
+			dirty = append(dirty, key)
+		}
+	}
+
+	return map[string]interface{}{
+		"id":          n.id,
+		"status":      n.status,
+		"position":    n.simulation.positionOf(n.id),
+		"versions":    versions,
+		"latest":      latest,
+		"dirtyKeys":   dirty,
+		"queue":       len(n.queue),
+		"waiting":     len(n.waiting),
+		"served":      n.served,
+		"tailQueries": n.queries,
+	}
+}
+
+func (n *Node) handleMessage(env *transport.Envelope) {
+	n.mu.RLock()
+	down := n.status == "crashed"
+	n.mu.RUnlock()
+
+	if down {
+		return
+	}
+	select {
+	case n.inbox <- env:
+	default:
+	}
+}
+
+func (n *Node) processMessage(env *transport.Envelope) {
+	payload := n.simulation.received(env)
+
+	switch env.Type {
+	case MsgWrite:
+		n.handleWrite(payload)
+	case MsgAck:
+		n.handleAck(payload)
+	case MsgVersionQuery:
+		n.enqueue(read{id: payload.QueryID, key: payload.Key, query: true, from: env.From})
+	case MsgVersionReply:
+		n.handleVersionReply(payload)
+	}
+}
+
+// latest returns the newest version of a key the node holds (must hold
+// n.mu)
+func (n *Node) latest(key string) (Version, bool) {
+	held := n.versions[key]
+	if len(held) == 0 {
+		return Version{}, false
+	}
+	return held[len(held)-1], true
+}
+
+// clean returns the committed version of a key the node knows of (must
+// hold n.mu)
+func (n *Node) clean(key string) Version {
+	for _, v := range n.versions[key] {
+		if v.Clean {
+			return v
+		}
+	}
+	return Version{}
+}
+
+// startWrite takes a client write on the head
+func (n *Node) startWrite(key, value string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.status != "running" {
+		return fmt.Errorf("node %s is down", n.id)
+	}
+	n.handleWrite(Payload{Key: key, Value: value, Version: n.simulation.nextVersion()})
+	return nil
+}
+
+// handleWrite adds a dirty version and forwards it, or on the tail
+// commits it and acknowledges it up the chain. A write seen before is
+// forwarded or acknowledged again, as retransmits repair lost messages.
+// (must hold n.mu)
+func (n *Node) handleWrite(payload Payload) {
+	if n.clean(payload.Key).Version >= payload.Version {
+		n.acknowledge(payload.Key, payload.Version)
+		return
+	}
+	known := false
+	for _, v := range n.versions[payload.Key] {
+		known = known || v.Version == payload.Version
+	}
+	if !known {
+		n.insert(payload.Key, Version{Version: payload.Version, Value: payload.Value})
+	}
+
+	next := n.simulation.successor(n.id)
+	if next == "" {
+		n.markClean(payload.Key, payload.Version)
+		n.simulation.committed(payload.Key, payload.Version)
+		n.acknowledge(payload.Key, payload.Version)
+		return
+	}
+	n.sentAt[payload.Version] = n.ticks
+	n.simulation.send(n.id, next, MsgWrite, payload)
+}
+
+// insert adds a version in order (must hold n.mu)
+func (n *Node) insert(key string, v Version) {
+	held := n.versions[key]
+	i := len(held)
+	for i > 0 && held[i-1].Version > v.Version {
+		i--
+	}
+	held = append(held, Version{})
+	copy(held[i+1:], held[i:])
+	held[i] = v
+	n.versions[key] = held
+}
+
+// handleAck marks a version clean and passes the acknowledgment on
+// (must hold n.mu)
+func (n *Node) handleAck(payload Payload) {
+	n.markClean(payload.Key, payload.Version)
+	n.acknowledge(payload.Key, payload.Version)
+}
+
+// acknowledge tells the predecessor a version is committed (must hold
+// n.mu)
+func (n *Node) acknowledge(key string, version int64) {
+	if prev := n.simulation.predecessor(n.id); prev != "" {
+		n.simulation.send(n.id, prev, MsgAck, Payload{Key: key, Version: version})
+	}
+}
+
+// markClean makes a version clean and drops the versions it replaces,
+// unless a newer one is clean already (must hold n.mu)
+func (n *Node) markClean(key string, version int64) {
+	if n.clean(key).Version >= version {
+		return
+	}
+	held := n.versions[key]
+	kept := make([]Version, 0, len(held))
+	for _, v := range held {
+		switch {
+		case v.Version < version:
+			delete(n.sentAt, v.Version)
+		case v.Version == version:
+			v.Clean = true
+			delete(n.sentAt, v.Version)
+			kept = append(kept, v)
+		default:
+			kept = append(kept, v)
+		}
+	}
+	n.versions[key] = kept
+}
+
+// enqueue queues a read or a version query, turning it away when the
+// queue is full (must hold n.mu)
+func (n *Node) enqueue(r read) bool {
+	if len(n.queue) >= maxQueue {
+		if !r.query {
+			n.simulation.readRejected()
+		}
+		return false
+	}
+	n.queue = append(n.queue, r)
+	return true
+}
+
+// serve answers up to readCapacity queued reads and version queries.
+// With CRAQ a read of a key whose newest version is dirty asks the tail
+// which version is committed; otherwise the newest version is the
+// answer. (must hold n.mu)
+func (n *Node) serve() {
+	count := min(readCapacity, len(n.queue))
+	for _, r := range n.queue[:count] {
+		if r.query {
+			n.simulation.send(n.id, r.from, MsgVersionReply, Payload{
+				QueryID: r.id,
+				Key:     r.key,
+				Version: n.clean(r.key).Version,
+			})
+			continue
+		}
+		latest, _ := n.latest(r.key)
+		if latest.Version == 0 || latest.Clean {
+			n.served++
+			n.simulation.readDone(n.id, r, latest, false)
+			continue
+		}
+		n.nextID++
+		queryID := fmt.Sprintf("%s-%d", n.id, n.nextID)
+		n.waiting[queryID] = r
+		n.queries++
+		n.simulation.send(n.id, n.simulation.tail(), MsgVersionQuery, Payload{QueryID: queryID, Key: r.key})
+	}
+	n.queue = n.queue[count:]
+}
+
+// handleVersionReply answers a dirty read with the version the tail
+// committed, which this node holds since every write passed through it
+// (must hold n.mu)
+func (n *Node) handleVersionReply(payload Payload) {
+	r, ok := n.waiting[payload.QueryID]
+	if !ok {
+		return
+	}
+	delete(n.waiting, payload.QueryID)
+	answer := Version{Version: payload.Version, Clean: true}
+	for _, v := range n.versions[payload.Key] {
+		if v.Version == payload.Version {
+			answer.Value = v.Value
+		}
+	}
+	n.served++
+	n.simulation.readDone(n.id, r, answer, true)
+}
+
+// retransmit forwards again the dirty versions not acknowledged in time,
+// in case a crash lost them (must hold n.mu)
+func (n *Node) retransmit() {
+	next := n.simulation.successor(n.id)
+	if next == "" {
+		return
+	}
+	for key, held := range n.versions {
+		for _, v := range held {
+			if v.Clean || n.ticks-n.sentAt[v.Version] < retransmitTicks {
+				continue
+			}
+			n.sentAt[v.Version] = n.ticks
+			n.simulation.send(n.id, next, MsgWrite, Payload{Key: key, Value: v.Value, Version: v.Version})
+		}
+	}
+}
+
+// expire fails the reads the tail did not answer in time (must hold n.mu)
+func (n *Node) expire() {
+	for queryID, r := range n.waiting {
+		if n.simulation.tickOf()-r.issued >= queryTimeout {
+			delete(n.waiting, queryID)
+			n.simulation.readFailed(n.id, r.key)
+		}
+	}
+}
+
+// crash loses the queued reads and the messages in flight; the versions
+// are on disk
+func (n *Node) crash() {
+	n.status = "crashed"
+	for _, r := range n.queue {
+		if !r.query {
+			n.simulation.readFailed(n.id, r.key)
+		}
+	}
+	for _, r := range n.waiting {
+		n.simulation.readFailed(n.id, r.key)
+	}
+	n.queue = make([]read, 0)
+	n.waiting = make(map[string]read)
+	for {
+		select {
+		case <-n.inbox:
+			continue
+		default:
+		}
+		break
+	}
+}
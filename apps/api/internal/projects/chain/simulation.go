@@ -0,0 +1,533 @@
+package chain
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+	"github.com/ersantana/distributed-systems-learning/packages/simulation/engine"
+)
+
+const (
+	keyCount         = 8  // Keys the clients read and write
+	hotKeys          = 2  // Keys read and written in "craq_write_heavy"
+	readsPerTick     = 6  // Reads the clients issue every tick
+	writeEvery       = 3  // Ticks between writes, but in "craq_write_heavy"
+	throughputWindow = 20 // Ticks the recent read throughput is averaged over
+)
+
+// Payload is the content of all messages exchanged in this project
+type Payload struct {
+	Key     string `json:"key,omitempty"`
+	Value   string `json:"value,omitempty"`
+	Version int64  `json:"version,omitempty"`
+	QueryID string `json:"queryId,omitempty"`
+}
+
+// Simulation is chain replication: the nodes form a chain, writes enter
+// at the head and are committed once they reach the tail, and a write's
+// acknowledgment comes back from the tail. Every node on the way holds
+// the write, so a node holds every committed version.
+//
+// In the "chain" scenario only the tail serves reads, as only it knows
+// what is committed: reads are strongly consistent, but the tail's
+// capacity bounds the read throughput however long the chain.
+//
+// The "craq" scenario uses Chain Replication with Apportioned Queries
+// instead: any node serves reads. A node's newest version of a key is
+// clean once the tail's acknowledgment passed by, and dirty until then.
+// A clean version is committed and answered right away; for a dirty one
+// the node asks the tail which version it committed, and answers that
+// one. With few writes most keys are clean and the read throughput grows
+// with the length of the chain; "craq_write_heavy" keeps a few hot keys
+// dirty, so their reads all end up asking the tail again.
+type Simulation struct {
+	mu sync.RWMutex
+
+	engine    *engine.Engine
+	rng       *rand.Rand
+	transport *transport.NetworkTransport
+	broadcast func(interface{})
+
+	nodes    []*Node // In chain order, head first
+	scenario string
+	craq     bool // Any node serves reads
+
+	version  int64
+	commits  map[string]int64 // Version of the last write the tail committed for each key
+	issuedAt map[int64]int    // Version, tick the client wrote it
+
+	writes         int
+	committedCount int
+	commitTicks    int // Ticks from write to commit, summed
+	readsIssued    int
+	readsServed    int
+	rejectedReads  int // Turned away by a full queue
+	failedReads    int
+	dirtyReads     int // Answered after asking the tail
+	staleReads     int
+	readTicks      int // Ticks from read to answer, summed
+	servedBy       map[string]int
+	recent         [throughputWindow]int // Reads answered in each of the last ticks
+	messages       map[string]int
+
+	lastTick int
+
+	running bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// Config for chain replication simulation
+type Config struct {
+	NodeCount int
+	Scenario  string // "chain", "craq", "craq_write_heavy"
+}
+
+// NewSimulation creates a new chain replication simulation
+func NewSimulation(eng *engine.Engine, trans *transport.NetworkTransport, broadcast func(interface{}), config Config) *Simulation {
+	switch config.Scenario {
+	case "chain", "craq", "craq_write_heavy":
+	default:
+		config.Scenario = "chain"
+	}
+	if config.NodeCount < 3 {
+		config.NodeCount = 4
+	}
+
+	sim := &Simulation{
+		engine:    eng,
+		rng:       eng.Rand(),
+		transport: trans,
+		broadcast: broadcast,
+		scenario:  config.Scenario,
+		craq:      config.Scenario != "chain",
+		commits:   make(map[string]int64),
+		issuedAt:  make(map[int64]int),
+		servedBy:  make(map[string]int),
+		messages:  make(map[string]int),
+	}
+
+	trans.SetLatency(20*time.Millisecond, 60*time.Millisecond)
+	trans.SetPacketLoss(0)
+
+	for i := 0; i < config.NodeCount; i++ {
+		node := newNode(fmt.Sprintf("node-%d", i+1), sim)
+		sim.nodes = append(sim.nodes, node)
+		trans.RegisterHandler(node.id, node.handleMessage)
+		eng.AddNode(node)
+	}
+
+	return sim
+}
+
+// Start starts the simulation
+func (s *Simulation) Start(ctx context.Context) error {
+	s.mu.Lock()
+	s.running = true
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.mu.Unlock()
+
+	return s.engine.Start(ctx)
+}
+
+// Stop stops the simulation
+func (s *Simulation) Stop() error {
+	s.mu.Lock()
+	s.running = false
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Unlock()
+
+	return s.engine.Stop()
+}
+
+// GetState returns the current simulation state
+func (s *Simulation) GetState() *protocol.SimulationStateResponse {
+	nodes := make(map[string]protocol.NodeState)
+	queues := make(map[string]int)
+	dirtyKeys := make(map[string]int)
+	for i, node := range s.nodes {
+		state := node.GetState()
+		role := "middle"
+		switch i {
+		case 0:
+			role = "head"
+		case len(s.nodes) - 1:
+			role = "tail"
+		}
+		nodes[node.id] = protocol.NodeState{
+			ID:          node.id,
+			Status:      state["status"].(string),
+			Role:        role,
+			CustomState: state,
+		}
+		queues[node.id] = state["queue"].(int)
+		dirtyKeys[node.id] = len(state["dirtyKeys"].([]string))
+	}
+
+	s.mu.RLock()
+	running := s.running
+	commits := make(map[string]int64, len(s.commits))
+	for key, version := range s.commits {
+		commits[key] = version
+	}
+	servedBy := make(map[string]int, len(s.servedBy))
+	for nodeID, count := range s.servedBy {
+		servedBy[nodeID] = count
+	}
+	messages := make(map[string]int, len(s.messages))
+	for msgType, count := range s.messages {
+		messages[msgType] = count
+	}
+	recent := 0
+	for _, count := range s.recent {
+		recent += count
+	}
+	avgRead, avgCommit := 0.0, 0.0
+	if s.readsServed > 0 {
+		avgRead = float64(s.readTicks) / float64(s.readsServed)
+	}
+	if s.committedCount > 0 {
+		avgCommit = float64(s.commitTicks) / float64(s.committedCount)
+	}
+	metadata := map[string]interface{}{
+		"scenario":       s.scenario,
+		"craq":           s.craq,
+		"chain":          s.order(),
+		"head":           s.nodes[0].id,
+		"tail":           s.tail(),
+		"readCapacity":   readCapacity, // Per node and tick
+		"committed":      commits,
+		"writes":         s.writes,
+		"commits":        s.committedCount,
+		"avgCommitTicks": avgCommit,
+		"readsIssued":    s.readsIssued,
+		"readsServed":    s.readsServed,
+		"rejectedReads":  s.rejectedReads,
+		"failedReads":    s.failedReads,
+		"dirtyReads":     s.dirtyReads,
+		"staleReads":     s.staleReads,
+		"avgReadTicks":   avgRead,
+		"readThroughput": float64(recent) / throughputWindow, // Reads answered per tick, recently
+		"servedBy":       servedBy,
+		"queues":         queues,
+		"dirtyKeys":      dirtyKeys,
+		"messages":       messages,
+	}
+	s.mu.RUnlock()
+
+	mode := "step"
+	if s.engine != nil {
+		mode = s.engine.GetMode().String()
+	}
+
+	return &protocol.SimulationStateResponse{
+		Type:        protocol.MsgSimulationState,
+		VirtualTime: time.Now().UnixMilli(),
+		Mode:        mode,
+		Speed:       1.0,
+		Running:     running,
+		Nodes:       nodes,
+		Metadata:    metadata,
+	}
+}
+
+// GetNodes returns node states
+func (s *Simulation) GetNodes() map[string]protocol.NodeState {
+	state := s.GetState()
+	return state.Nodes
+}
+
+// Layout draws the chain from head to tail
+func (s *Simulation) Layout() *protocol.Layout {
+	return &protocol.Layout{Kind: protocol.LayoutChain, Order: s.order()}
+}
+
+// CrashNode crashes a node; it keeps its versions
+func (s *Simulation) CrashNode(nodeID string) error {
+	node := s.findNode(nodeID)
+	if node == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	node.mu.Lock()
+	node.crash()
+	node.mu.Unlock()
+	return nil
+}
+
+// RecoverNode recovers a crashed node
+func (s *Simulation) RecoverNode(nodeID string) error {
+	node := s.findNode(nodeID)
+	if node == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	node.mu.Lock()
+	node.status = "running"
+	node.mu.Unlock()
+	return nil
+}
+
+// HandleClientRequest sends a client request to the chain. Commands are
+// "put" (payload: key, value), taken by the head, and "get" (payload:
+// key, optional nodeId), served by the tail or with CRAQ by any node.
+func (s *Simulation) HandleClientRequest(command string, payload map[string]interface{}) error {
+	key, _ := payload["key"].(string)
+	if key == "" {
+		return fmt.Errorf("missing key")
+	}
+
+	switch command {
+	case "put":
+		value, _ := payload["value"].(string)
+		return s.write(key, value)
+	case "get":
+		nodeID, _ := payload["nodeId"].(string)
+		if nodeID == "" {
+			nodeID = s.tail()
+		}
+		if !s.craq && nodeID != s.tail() {
+			return fmt.Errorf("only the tail serves reads without CRAQ")
+		}
+		return s.read(nodeID, key)
+	}
+	return fmt.Errorf("unknown command: %s", command)
+}
+
+// findNode looks up a node by ID; the node list is fixed after
+// construction
+func (s *Simulation) findNode(nodeID string) *Node {
+	for _, node := range s.nodes {
+		if node.id == nodeID {
+			return node
+		}
+	}
+	return nil
+}
+
+// order returns the node IDs from head to tail
+func (s *Simulation) order() []string {
+	ids := make([]string, 0, len(s.nodes))
+	for _, node := range s.nodes {
+		ids = append(ids, node.id)
+	}
+	return ids
+}
+
+// positionOf returns a node's index in the chain
+func (s *Simulation) positionOf(nodeID string) int {
+	for i, node := range s.nodes {
+		if node.id == nodeID {
+			return i
+		}
+	}
+	return -1
+}
+
+// successor returns the next node towards the tail, "" for the tail
+func (s *Simulation) successor(nodeID string) string {
+	if i := s.positionOf(nodeID); i >= 0 && i < len(s.nodes)-1 {
+		return s.nodes[i+1].id
+	}
+	return ""
+}
+
+// predecessor returns the next node towards the head, "" for the head
+func (s *Simulation) predecessor(nodeID string) string {
+	if i := s.positionOf(nodeID); i > 0 {
+		return s.nodes[i-1].id
+	}
+	return ""
+}
+
+// tail returns the tail's ID
+func (s *Simulation) tail() string {
+	return s.nodes[len(s.nodes)-1].id
+}
+
+// tickOf returns the current tick of the simulation
+func (s *Simulation) tickOf() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lastTick
+}
+
+// advanceSchedule has the clients write every writeEvery ticks, or every
+// tick in "craq_write_heavy", and issue readsPerTick reads: to the tail,
+// or with CRAQ to random nodes
+func (s *Simulation) advanceSchedule(ticks int) {
+	s.mu.Lock()
+	if ticks <= s.lastTick {
+		s.mu.Unlock()
+		return
+	}
+	s.lastTick = ticks
+	s.recent[ticks%throughputWindow] = 0
+	s.mu.Unlock()
+
+	keys := keyCount
+	if s.scenario == "craq_write_heavy" {
+		keys = hotKeys
+	}
+	if keys == hotKeys || ticks%writeEvery == 0 {
+		s.write(fmt.Sprintf("key-%d", s.rng.Intn(keys)+1), fmt.Sprintf("v%d", ticks))
+	}
+
+	for i := 0; i < readsPerTick; i++ {
+		nodeID := s.tail()
+		if s.craq {
+			nodeID = s.nodes[s.rng.Intn(len(s.nodes))].id
+		}
+		s.read(nodeID, fmt.Sprintf("key-%d", s.rng.Intn(keys)+1))
+	}
+}
+
+// write hands a client write to the head
+func (s *Simulation) write(key, value string) error {
+	s.mu.Lock()
+	s.writes++
+	s.mu.Unlock()
+
+	return s.nodes[0].startWrite(key, value)
+}
+
+// read queues a client read on a node
+func (s *Simulation) read(nodeID, key string) error {
+	node := s.findNode(nodeID)
+	if node == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+
+	s.mu.Lock()
+	s.readsIssued++
+	r := read{key: key, issued: s.lastTick, expect: s.commits[key]}
+	s.mu.Unlock()
+
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	if node.status != "running" {
+		s.readFailed(nodeID, key)
+		return fmt.Errorf("node %s is down", nodeID)
+	}
+	node.enqueue(r)
+	return nil
+}
+
+// nextVersion returns the version of a new write
+func (s *Simulation) nextVersion() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+	s.issuedAt[s.version] = s.lastTick
+	return s.version
+}
+
+// committed records the tail committing a write
+func (s *Simulation) committed(key string, version int64) {
+	s.mu.Lock()
+	s.commits[key] = max(s.commits[key], version)
+	s.committedCount++
+	waited := s.lastTick - s.issuedAt[version]
+	s.commitTicks += waited
+	delete(s.issuedAt, version)
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":    "write_committed",
+		"nodeId":  s.tail(),
+		"key":     key,
+		"version": version,
+		"ticks":   waited,
+	})
+}
+
+// readDone records a read a node answered; it is stale if it missed a
+// write committed before it was issued
+func (s *Simulation) readDone(nodeID string, r read, answer Version, dirty bool) {
+	stale := answer.Version < r.expect
+
+	s.mu.Lock()
+	s.readsServed++
+	s.servedBy[nodeID]++
+	s.recent[s.lastTick%throughputWindow]++
+	s.readTicks += s.lastTick - r.issued
+	if dirty {
+		s.dirtyReads++
+	}
+	if stale {
+		s.staleReads++
+	}
+	s.mu.Unlock()
+
+	// Clean reads are too many to report one by one
+	if dirty || stale {
+		s.broadcast(map[string]interface{}{
+			"type":     "read_completed",
+			"nodeId":   nodeID,
+			"key":      r.key,
+			"version":  answer.Version,
+			"expected": r.expect,
+			"dirty":    dirty,
+			"stale":    stale,
+		})
+	}
+}
+
+// readRejected records a read a node's full queue turned away
+func (s *Simulation) readRejected() {
+	s.mu.Lock()
+	s.rejectedReads++
+	s.mu.Unlock()
+}
+
+// readFailed records a read lost to a crash or a silent tail
+func (s *Simulation) readFailed(nodeID, key string) {
+	s.mu.Lock()
+	s.failedReads++
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":   "read_failed",
+		"nodeId": nodeID,
+		"key":    key,
+	})
+}
+
+// send transmits a message and reports it to the frontend
+func (s *Simulation) send(from, to string, msgType transport.MessageType, payload Payload) {
+	env := transport.NewEnvelope(from, to, msgType, payload)
+
+	s.mu.Lock()
+	s.messages[string(msgType)]++
+	s.mu.Unlock()
+
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageSent,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+
+	s.transport.Send(s.ctx, env)
+}
+
+// received reports a delivered message and returns its payload
+func (s *Simulation) received(env *transport.Envelope) Payload {
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageReceived,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+	payload, _ := env.Payload.(Payload)
+	return payload
+}
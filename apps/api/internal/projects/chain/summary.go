@@ -0,0 +1,68 @@
+package chain
+
+import (
+	"fmt"
+
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+)
+
+// Invariants judges the run: every read, wherever it was served, returned
+// the last write committed before it was issued, and every node holds the
+// versions the tail committed, as writes reach the tail through them all
+func (s *Simulation) Invariants() []protocol.InvariantResult {
+	newest := make(map[string]map[string]int64, len(s.nodes)) // Node, key, newest version held
+	for _, node := range s.nodes {
+		node.mu.RLock()
+		newest[node.id] = make(map[string]int64, len(node.versions))
+		for key := range node.versions {
+			latest, _ := node.latest(key)
+			newest[node.id][key] = latest.Version
+		}
+		node.mu.RUnlock()
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	missing := 0
+	for key, version := range s.commits {
+		for _, node := range s.nodes {
+			if newest[node.id][key] < version {
+				missing++
+			}
+		}
+	}
+
+	return []protocol.InvariantResult{
+		{
+			Name:   "reads see the last committed write",
+			Holds:  s.staleReads == 0,
+			Detail: fmt.Sprintf("%d of %d reads stale, %d of them checked with the tail (CRAQ: %v)", s.staleReads, s.readsServed, s.dirtyReads, s.craq),
+		},
+		{
+			Name:   "every node holds the committed writes",
+			Holds:  missing == 0,
+			Detail: fmt.Sprintf("%d node and key pairs miss the last of %d committed writes", missing, s.committedCount),
+		},
+	}
+}
+
+// FollowUps suggests the scenarios that contrast with this one
+func (s *Simulation) FollowUps() []protocol.FollowUp {
+	switch s.scenario {
+	case "craq":
+		return []protocol.FollowUp{
+			{Project: "chain-replication", Scenario: "chain", Reason: "Compare the read throughput of the tail alone"},
+			{Project: "chain-replication", Scenario: "craq_write_heavy", Reason: "See dirty reads send the load back to the tail"},
+		}
+	case "craq_write_heavy":
+		return []protocol.FollowUp{
+			{Project: "chain-replication", Scenario: "craq", Reason: "Compare with keys that are mostly clean"},
+			{Project: "quorum", Scenario: "strict", Reason: "Read from overlapping quorums instead of a chain"},
+		}
+	}
+	return []protocol.FollowUp{
+		{Project: "chain-replication", Scenario: "craq", Reason: "Serve reads from every node of the chain"},
+		{Project: "quorum", Scenario: "strict", Reason: "Read from overlapping quorums instead of a chain"},
+	}
+}
@@ -0,0 +1,421 @@
+package chord
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+const (
+	MsgFindSuccessor  transport.MessageType = "find_successor"
+	MsgFound          transport.MessageType = "found"
+	MsgGetPredecessor transport.MessageType = "get_predecessor"
+	MsgPredecessor    transport.MessageType = "predecessor"
+	MsgNotify         transport.MessageType = "notify"
+	MsgPing           transport.MessageType = "ping"
+	MsgPong           transport.MessageType = "pong"
+	MsgLeave          transport.MessageType = "leave"
+)
+
+const (
+	stabilizeTicks = 5  // Ticks between stabilizations and predecessor checks
+	fixFingerTicks = 2  // Ticks between finger fixes, one finger at a time
+	replyTimeout   = 8  // Ticks before a silent successor or predecessor is taken for dead
+	lookupTimeout  = 40 // Ticks before a lookup is given up
+	successorList  = 3  // Successors kept to survive crashes
+)
+
+// request is a find_successor this node started and awaits the answer to
+type request struct {
+	purpose string // "lookup", "finger", "join"
+	finger  int
+	key     int
+	name    string // Looked up key
+	sentAt  int
+}
+
+// Node is a Chord node. It knows its successors, its predecessor and a
+// finger table whose entry i points to the successor of key + 2^i; the
+// fingers let a lookup halve its distance to the key at every hop.
+type Node struct {
+	mu sync.RWMutex
+
+	id     string
+	key    int
+	status string // "running", "crashed", "left" or "outside" before joining
+	ticks  int
+
+	joined      bool
+	joining     bool
+	joinAfter   int    // Tick to ask again after the ring answered with ourselves
+	bootstrap   string // Member asked to find our successor when joining
+	successors  []Peer
+	predecessor Peer
+	fingers     []Peer
+	nextFinger  int
+
+	pending      map[int]*request
+	nextReq      int
+	awaitingPred int // Tick we asked the successor for its predecessor, 0 if answered
+	pingSentAt   int // Tick we pinged the predecessor, 0 if answered
+
+	inbox      chan *transport.Envelope
+	simulation *Simulation
+}
+
+func newNode(id string, key int, sim *Simulation) *Node {
+	return &Node{
+		id:         id,
+		key:        key,
+		status:     "outside",
+		fingers:    make([]Peer, bits),
+		pending:    make(map[int]*request),
+		inbox:      make(chan *transport.Envelope, 500),
+		simulation: sim,
+	}
+}
+
+// Node implements engine.NodeController
+
+func (n *Node) ID() string {
+	return n.id
+}
+
+func (n *Node) Start(ctx context.Context) error {
+	return nil
+}
+
+func (n *Node) Stop() error {
+	return nil
+}
+
+func (n *Node) Tick() {
+	n.simulation.advanceSchedule(n.tick())
+}
+
+func (n *Node) tick() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.ticks++
+	if n.status != "running" {
+		return n.ticks
+	}
+
+	for i := 0; i < 50; i++ {
+		select {
+		case env := <-n.inbox:
+			n.processMessage(env)
+			continue
+		default:
+		}
+		break
+	}
+
+	if !n.joined {
+		switch {
+		case n.bootstrap == "":
+			// Nobody to join: start a ring of our own
+			n.joined = true
+			n.simulation.joined(n.id)
+		case !n.joining && n.ticks >= n.joinAfter:
+			n.joining = true
+			n.ask(n.bootstrap, &request{purpose: "join", key: n.key})
+		}
+		n.expireRequests()
+		return n.ticks
+	}
+
+	if n.ticks%stabilizeTicks == 0 {
+		n.stabilize()
+		n.checkPredecessor()
+	}
+	if n.ticks%fixFingerTicks == 0 {
+		n.fixFinger()
+	}
+	n.expireRequests()
+	return n.ticks
+}
+
+func (n *Node) GetState() map[string]interface{} {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	fingers := make([]map[string]interface{}, bits)
+	for i, f := range n.fingers {
+		fingers[i] = map[string]interface{}{
+			"start": fingerStart(n.key, i),
+			"node":  f.ID,
+			"key":   f.Key,
+		}
+	}
+
+	return map[string]interface{}{
+		"id":            n.id,
+		"status":        n.status,
+		"key":           n.key,
+		"joined":        n.joined,
+		"successor":     n.successor(),
+		"successorList": append([]Peer{}, n.successors...),
+		"predecessor":   n.predecessor,
+		"fingers":       fingers,
+		"pending":       len(n.pending),
+	}
+}
+
+func (n *Node) handleMessage(env *transport.Envelope) {
+	n.mu.RLock()
+	down := n.status != "running"
+	n.mu.RUnlock()
+
+	// Crashed and departed nodes do not receive anything
+	if down {
+		return
+	}
+	select {
+	case n.inbox <- env:
+	default:
+	}
+}
+
+func (n *Node) processMessage(env *transport.Envelope) {
+	payload := n.simulation.received(env)
+
+	// Outside the ring, a node only awaits the answer to its join
+	if !n.joined && env.Type != MsgFound {
+		return
+	}
+
+	switch env.Type {
+	case MsgFindSuccessor:
+		n.route(payload)
+
+	case MsgFound:
+		n.found(payload)
+
+	case MsgGetPredecessor:
+		n.simulation.send(n.id, env.From, MsgPredecessor, Payload{Node: n.predecessor, Successors: append([]Peer{}, n.successors...)})
+
+	case MsgPredecessor:
+		n.updateSuccessor(env.From, payload)
+
+	case MsgNotify:
+		n.notified(payload.Node)
+
+	case MsgPing:
+		n.simulation.send(n.id, env.From, MsgPong, Payload{})
+
+	case MsgPong:
+		if env.From == n.predecessor.ID {
+			n.pingSentAt = 0
+		}
+
+	case MsgLeave:
+		// A departing neighbour hands over its own neighbour
+		if env.From == n.successor().ID {
+			n.setSuccessors(append([]Peer{payload.Node}, n.successors[1:]...))
+			n.awaitingPred = 0
+		}
+		if env.From == n.predecessor.ID {
+			n.predecessor = payload.Node
+			if payload.Node.ID == n.id {
+				n.predecessor = Peer{} // It was the only other node
+			}
+			n.pingSentAt = 0
+		}
+	}
+}
+
+// self returns the reference to this node
+func (n *Node) self() Peer {
+	return Peer{ID: n.id, Key: n.key}
+}
+
+// successor returns the first live successor we know of
+func (n *Node) successor() Peer {
+	if len(n.successors) == 0 {
+		return n.self()
+	}
+	return n.successors[0]
+}
+
+// stabilize asks the successor for its predecessor, which may be a node
+// that joined between us, and drops a successor that did not answer the
+// last time (must hold n.mu)
+func (n *Node) stabilize() {
+	if n.awaitingPred > 0 && len(n.successors) > 0 {
+		if n.ticks-n.awaitingPred <= replyTimeout {
+			return // Still waiting for the answer
+		}
+		failed := n.successor()
+		n.successors = n.successors[1:]
+		n.forget(failed.ID)
+		n.simulation.broadcast(map[string]interface{}{
+			"type":      "successor_failed",
+			"nodeId":    n.id,
+			"failed":    failed.ID,
+			"successor": n.successor().ID,
+		})
+	}
+	n.awaitingPred = 0
+
+	succ := n.successor()
+	if succ.ID == n.id {
+		// Alone on the ring: a predecessor is also our successor
+		if n.predecessor.ID != "" {
+			n.setSuccessor(n.predecessor)
+		}
+		return
+	}
+	n.awaitingPred = n.ticks
+	n.simulation.send(n.id, succ.ID, MsgGetPredecessor, Payload{})
+}
+
+// updateSuccessor handles the successor's answer to stabilize (must hold
+// n.mu)
+func (n *Node) updateSuccessor(from string, payload Payload) {
+	succ := n.successor()
+	if from != succ.ID {
+		return // A successor we gave up on
+	}
+	n.awaitingPred = 0
+
+	// Our successor's successors follow it on the ring
+	list := append([]Peer{succ}, payload.Successors...)
+	if x := payload.Node; x.ID != "" && x.ID != n.id && between(x.Key, n.key, succ.Key, false) {
+		list = append([]Peer{x}, list...)
+	}
+	n.setSuccessors(list)
+	n.simulation.send(n.id, n.successor().ID, MsgNotify, Payload{Node: n.self()})
+}
+
+// setSuccessor makes a node our successor (must hold n.mu)
+func (n *Node) setSuccessor(p Peer) {
+	n.setSuccessors(append([]Peer{p}, n.successors...))
+}
+
+// setSuccessors replaces the successor list, broadcasting a new successor
+// (must hold n.mu)
+func (n *Node) setSuccessors(list []Peer) {
+	old := n.successor()
+	n.successors = make([]Peer, 0, successorList)
+	seen := map[string]bool{n.id: true}
+	for _, p := range list {
+		if len(n.successors) == successorList {
+			break
+		}
+		if !seen[p.ID] {
+			seen[p.ID] = true
+			n.successors = append(n.successors, p)
+		}
+	}
+	if succ := n.successor(); succ.ID != old.ID {
+		n.simulation.broadcast(map[string]interface{}{
+			"type":      "successor_changed",
+			"nodeId":    n.id,
+			"from":      old.ID,
+			"successor": succ.ID,
+		})
+	}
+}
+
+// notified considers a node that thinks it is our predecessor (must hold
+// n.mu)
+func (n *Node) notified(p Peer) {
+	if p.ID == n.id {
+		return
+	}
+	if n.predecessor.ID == "" || between(p.Key, n.predecessor.Key, n.key, false) {
+		n.predecessor = p
+		n.pingSentAt = 0
+	}
+}
+
+// checkPredecessor forgets a predecessor that stopped answering pings, so
+// that the next node to notify us can take its place (must hold n.mu)
+func (n *Node) checkPredecessor() {
+	if n.predecessor.ID == "" || n.predecessor.ID == n.id {
+		return
+	}
+	if n.pingSentAt > 0 && n.ticks-n.pingSentAt > replyTimeout {
+		n.simulation.broadcast(map[string]interface{}{
+			"type":   "predecessor_failed",
+			"nodeId": n.id,
+			"failed": n.predecessor.ID,
+		})
+		n.forget(n.predecessor.ID)
+		n.predecessor = Peer{}
+		n.pingSentAt = 0
+		return
+	}
+	if n.pingSentAt == 0 {
+		n.pingSentAt = n.ticks
+		n.simulation.send(n.id, n.predecessor.ID, MsgPing, Payload{})
+	}
+}
+
+// forget clears the fingers pointing to a failed node, so that lookups
+// fall back on closer fingers and the successors (must hold n.mu)
+func (n *Node) forget(nodeID string) {
+	for i, f := range n.fingers {
+		if f.ID == nodeID {
+			n.fingers[i] = Peer{}
+		}
+	}
+}
+
+// fixFinger refreshes the next finger (must hold n.mu)
+func (n *Node) fixFinger() {
+	i := n.nextFinger
+	n.nextFinger = (n.nextFinger + 1) % bits
+	n.ask(n.id, &request{purpose: "finger", finger: i, key: fingerStart(n.key, i)})
+}
+
+// lookup starts a client lookup of a key (must hold n.mu)
+func (n *Node) lookup(name string) {
+	n.ask(n.id, &request{purpose: "lookup", key: hash(name), name: name})
+}
+
+// ask starts a find_successor for a request at the given node, usually
+// ourselves (must hold n.mu)
+func (n *Node) ask(at string, req *request) {
+	n.nextReq++
+	req.sentAt = n.ticks
+	n.pending[n.nextReq] = req
+
+	payload := Payload{Key: req.key, Origin: n.id, Req: n.nextReq}
+	if at == n.id {
+		n.route(payload)
+		return
+	}
+	payload.Hops = 1
+	n.simulation.send(n.id, at, MsgFindSuccessor, payload)
+}
+
+// expireRequests gives up on requests lost with a crashed node (must hold
+// n.mu)
+func (n *Node) expireRequests() {
+	for id, req := range n.pending {
+		if n.ticks-req.sentAt < lookupTimeout {
+			continue
+		}
+		delete(n.pending, id)
+		switch req.purpose {
+		case "join":
+			n.joining = false // Ask again, perhaps another member
+			n.bootstrap = n.simulation.bootstrapFor(n.id)
+		case "finger":
+			// Lost on the way, perhaps at the very node the finger points to
+			n.fingers[req.finger] = Peer{}
+		case "lookup":
+			n.simulation.lookupDone(Lookup{
+				Name:   req.name,
+				Key:    req.key,
+				Origin: n.id,
+				Failed: true,
+				Tick:   n.ticks,
+			})
+		}
+	}
+}
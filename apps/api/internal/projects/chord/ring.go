@@ -0,0 +1,59 @@
+package chord
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	bits  = 6         // Identifier bits: a ring of 64 positions stays readable
+	space = 1 << bits // Positions on the ring
+)
+
+// Peer is a reference to a node: its name and its position on the ring.
+// The zero Peer means no node.
+type Peer struct {
+	ID  string `json:"id"`
+	Key int    `json:"key"`
+}
+
+// hash places a name on the ring
+func hash(name string) int {
+	sum := sha1.Sum([]byte(name))
+	return int(binary.BigEndian.Uint64(sum[:8]) % space)
+}
+
+// nodeKeys hashes node names, rehashing on collisions since two nodes
+// cannot share a position
+func nodeKeys(names []string) map[string]int {
+	keys := make(map[string]int, len(names))
+	taken := make(map[int]bool)
+	for _, name := range names {
+		key := hash(name)
+		for i := 1; taken[key]; i++ {
+			key = hash(fmt.Sprintf("%s#%d", name, i))
+		}
+		taken[key] = true
+		keys[name] = key
+	}
+	return keys
+}
+
+// between reports whether x lies in the ring interval (a, b), or (a, b]
+// when inclusive. An interval from a node to itself is the whole ring.
+func between(x, a, b int, inclusive bool) bool {
+	if inclusive && x == b {
+		return true
+	}
+	if a < b {
+		return a < x && x < b
+	}
+	// Wraps around zero, or is the whole ring when a == b
+	return x > a || x < b
+}
+
+// fingerStart returns the start of finger i of the node at key
+func fingerStart(key, i int) int {
+	return (key + 1<<i) % space
+}
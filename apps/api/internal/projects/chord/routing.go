@@ -0,0 +1,95 @@
+package chord
+
+// route answers a find_successor or forwards it to the closest finger
+// preceding the key (must hold n.mu)
+func (n *Node) route(payload Payload) {
+	succ := n.successor()
+	if succ.ID == n.id || between(payload.Key, n.key, succ.Key, true) {
+		found := Payload{Key: payload.Key, Req: payload.Req, Hops: payload.Hops, Node: succ}
+		if payload.Origin == n.id {
+			n.found(found)
+		} else {
+			n.simulation.send(n.id, payload.Origin, MsgFound, found)
+		}
+		return
+	}
+
+	next := n.closestPreceding(payload.Key)
+	if next.ID == n.id {
+		next = succ
+	}
+	payload.Hops++
+	n.simulation.send(n.id, next.ID, MsgFindSuccessor, payload)
+}
+
+// closestPreceding returns the known node closest before the key,
+// scanning the fingers from the farthest one (must hold n.mu)
+func (n *Node) closestPreceding(key int) Peer {
+	best := n.self()
+	for i := bits - 1; i >= 0; i-- {
+		if f := n.fingers[i]; f.ID != "" && f.ID != n.id && between(f.Key, n.key, key, false) {
+			best = f
+			break
+		}
+	}
+	// The successor list may know a closer node than a stale finger
+	for _, p := range n.successors {
+		if between(p.Key, best.Key, key, false) {
+			best = p
+		}
+	}
+	return best
+}
+
+// found completes one of our requests (must hold n.mu)
+func (n *Node) found(payload Payload) {
+	req, ok := n.pending[payload.Req]
+	if !ok {
+		return // Given up already
+	}
+	delete(n.pending, payload.Req)
+
+	switch req.purpose {
+	case "join":
+		if payload.Node.ID == n.id {
+			// Our predecessor has not noticed yet that we crashed and
+			// still takes us for its successor: wait for it to stabilize
+			n.joining = false
+			n.joinAfter = n.ticks + 2*stabilizeTicks
+			return
+		}
+		n.joined = true
+		n.joining = false
+		n.setSuccessors([]Peer{payload.Node})
+		n.simulation.joined(n.id)
+		n.simulation.broadcast(map[string]interface{}{
+			"type":      "node_joined",
+			"nodeId":    n.id,
+			"key":       n.key,
+			"successor": payload.Node.ID,
+			"hops":      payload.Hops,
+		})
+
+	case "finger":
+		if n.fingers[req.finger] != payload.Node {
+			n.fingers[req.finger] = payload.Node
+			n.simulation.broadcast(map[string]interface{}{
+				"type":   "finger_updated",
+				"nodeId": n.id,
+				"finger": req.finger,
+				"start":  req.key,
+				"node":   payload.Node.ID,
+			})
+		}
+
+	case "lookup":
+		n.simulation.lookupDone(Lookup{
+			Name:   req.name,
+			Key:    req.key,
+			Origin: n.id,
+			Result: payload.Node.ID,
+			Hops:   payload.Hops,
+			Tick:   n.ticks,
+		})
+	}
+}
@@ -0,0 +1,673 @@
+package chord
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+	"github.com/ersantana/distributed-systems-learning/packages/simulation/engine"
+)
+
+const (
+	initialMembers = 3  // Ring members at the start of "joins"
+	joinEvery      = 40 // Ticks between joins in "joins"
+	churnEvery     = 60 // Ticks between departures and rejoins in "churn"
+	lookupEvery    = 4  // Ticks between random lookups
+	recentLookups  = 20 // Lookups kept for the frontend
+)
+
+// Payload is the content of all messages exchanged in this project
+type Payload struct {
+	Key    int    `json:"key,omitempty"`    // Find successor: the key looked up
+	Origin string `json:"origin,omitempty"` // Find successor: the node awaiting the answer
+	Req    int    `json:"req,omitempty"`    // Matches an answer to the origin's request
+	Hops   int    `json:"hops,omitempty"`   // Find successor and found: messages the lookup took so far
+
+	Node       Peer   `json:"node,omitempty"`       // Found: the successor; predecessor, notify and leave: a neighbour
+	Successors []Peer `json:"successors,omitempty"` // Predecessor: the successor list of the answering node
+}
+
+// Lookup is a completed or failed client lookup
+type Lookup struct {
+	Name     string `json:"name"`
+	Key      int    `json:"key"`
+	Origin   string `json:"origin"`
+	Result   string `json:"result,omitempty"`
+	Expected string `json:"expected,omitempty"` // Successor of the key among the ring members
+	Hops     int    `json:"hops"`
+	Correct  bool   `json:"correct"`
+	Failed   bool   `json:"failed,omitempty"`
+	Tick     int    `json:"tick"`
+}
+
+// Simulation runs the Chord distributed hash table. Nodes and keys hash
+// to positions on a ring of 2^bits; a key belongs to its successor, the
+// first node at or after it. A lookup travels from node to node, each hop
+// jumping to the farthest finger that does not overshoot the key, so it
+// takes O(log N) hops once the finger tables are right.
+//
+// Nothing keeps them right but periodic work: every node stabilizes,
+// asking its successor for its predecessor and notifying it, and fixes
+// one finger at a time. A joining node only learns its successor; the
+// rest of the ring finds out through stabilization, and lookups may miss
+// the new node until it does. A crashed successor is replaced by the next
+// one in the successor list.
+type Simulation struct {
+	mu sync.RWMutex
+
+	engine    *engine.Engine
+	rng       *rand.Rand
+	transport *transport.NetworkTransport
+	broadcast func(interface{})
+
+	nodes    []*Node
+	scenario string
+	keys     map[string]int  // Position of every node, member or not
+	members  map[string]bool // Nodes that joined the ring and did not leave it
+
+	lookups  []Lookup // Most recent last
+	total    int
+	correct  int
+	failed   int
+	hops     map[int]int // Completed lookups by hop count
+	messages map[string]int
+
+	lastTick  int
+	nextJoin  int // Tick of the next join in "joins", 0 when done
+	joiner    int // Index of the next node to join
+	nextChurn int // Tick of the next departure or rejoin in "churn"
+	churnStep int
+
+	running bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// Config for Chord simulation
+type Config struct {
+	NodeCount int
+	Scenario  string // "joins", "stable", "churn"
+}
+
+// NewSimulation creates a new Chord simulation
+func NewSimulation(eng *engine.Engine, trans *transport.NetworkTransport, broadcast func(interface{}), config Config) *Simulation {
+	switch config.Scenario {
+	case "joins", "stable", "churn":
+	default:
+		config.Scenario = "joins"
+	}
+	if config.NodeCount == 0 {
+		config.NodeCount = 8
+	}
+	if config.NodeCount > space/2 {
+		config.NodeCount = space / 2
+	}
+
+	sim := &Simulation{
+		engine:    eng,
+		rng:       eng.Rand(),
+		transport: trans,
+		broadcast: broadcast,
+		scenario:  config.Scenario,
+		members:   make(map[string]bool),
+		lookups:   make([]Lookup, 0),
+		hops:      make(map[int]int),
+		messages:  make(map[string]int),
+	}
+
+	trans.SetLatency(20*time.Millisecond, 80*time.Millisecond)
+	trans.SetPacketLoss(0)
+
+	nodeIDs := make([]string, config.NodeCount)
+	for i := range nodeIDs {
+		nodeIDs[i] = fmt.Sprintf("node-%d", i+1)
+	}
+	sim.keys = nodeKeys(nodeIDs)
+
+	for _, id := range nodeIDs {
+		node := newNode(id, sim.keys[id], sim)
+		sim.nodes = append(sim.nodes, node)
+		trans.RegisterHandler(id, node.handleMessage)
+		eng.AddNode(node)
+	}
+
+	members := len(sim.nodes)
+	if config.Scenario == "joins" && members > initialMembers {
+		members = initialMembers
+		sim.nextJoin = joinEvery
+		sim.joiner = members
+	}
+	if config.Scenario == "churn" {
+		sim.nextChurn = churnEvery
+	}
+
+	// The first members start from a correct ring; their fingers are
+	// left for fixFinger to fill in
+	for _, node := range sim.nodes[:members] {
+		sim.members[node.id] = true
+	}
+	ring := sim.ring()
+	for i, p := range ring {
+		node := sim.findNode(p.ID)
+		node.status = "running"
+		node.joined = true
+		node.predecessor = ring[(i+len(ring)-1)%len(ring)]
+		for j := 1; j <= successorList && j < len(ring); j++ {
+			node.successors = append(node.successors, ring[(i+j)%len(ring)])
+		}
+	}
+
+	return sim
+}
+
+// Start starts the simulation
+func (s *Simulation) Start(ctx context.Context) error {
+	s.mu.Lock()
+	s.running = true
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.mu.Unlock()
+
+	return s.engine.Start(ctx)
+}
+
+// Stop stops the simulation
+func (s *Simulation) Stop() error {
+	s.mu.Lock()
+	s.running = false
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Unlock()
+
+	return s.engine.Stop()
+}
+
+// GetState returns the current simulation state
+func (s *Simulation) GetState() *protocol.SimulationStateResponse {
+	nodes := make(map[string]protocol.NodeState)
+	states := make(map[string]map[string]interface{})
+	for _, node := range s.nodes {
+		state := node.GetState()
+		role := "outside"
+		if state["joined"].(bool) {
+			role = "member"
+		}
+		nodes[node.id] = protocol.NodeState{
+			ID:          node.id,
+			Status:      state["status"].(string),
+			Role:        role,
+			CustomState: state,
+		}
+		states[node.id] = state
+	}
+
+	s.mu.RLock()
+	running := s.running
+	ring := s.ring()
+
+	// Compare what the members believe with the ring they actually form
+	successorsRight := 0
+	fingers, fingersRight := 0, 0
+	for i, p := range ring {
+		state := states[p.ID]
+		if state["successor"].(Peer).ID == ring[(i+1)%len(ring)].ID {
+			successorsRight++
+		}
+		for _, f := range state["fingers"].([]map[string]interface{}) {
+			fingers++
+			if f["node"].(string) == s.successorOf(f["start"].(int)) {
+				fingersRight++
+			}
+		}
+	}
+	fingersCorrect := 0.0
+	if fingers > 0 {
+		fingersCorrect = float64(fingersRight) / float64(fingers)
+	}
+
+	completed := s.total - s.failed
+	totalHops := 0
+	hops := make(map[int]int, len(s.hops))
+	for h, count := range s.hops {
+		hops[h] = count
+		totalHops += h * count
+	}
+	avgHops := 0.0
+	if completed > 0 {
+		avgHops = float64(totalHops) / float64(completed)
+	}
+	expectedHops := 0.0
+	if len(ring) > 1 {
+		expectedHops = 0.5 * math.Log2(float64(len(ring)))
+	}
+	messages := make(map[string]int, len(s.messages))
+	for msgType, count := range s.messages {
+		messages[msgType] = count
+	}
+
+	metadata := map[string]interface{}{
+		"scenario":          s.scenario,
+		"bits":              bits,
+		"space":             space,
+		"ring":              ring,
+		"members":           len(ring),
+		"lookups":           append([]Lookup{}, s.lookups...),
+		"lookupCount":       s.total,
+		"lookupsCorrect":    s.correct,
+		"lookupsFailed":     s.failed,
+		"avgHops":           avgHops,
+		"expectedHops":      expectedHops, // ½ log₂ N with correct fingers
+		"hopHistogram":      hops,
+		"successorsCorrect": successorsRight == len(ring),
+		"fingersCorrect":    fingersCorrect,
+		"messages":          messages,
+	}
+	s.mu.RUnlock()
+
+	mode := "step"
+	if s.engine != nil {
+		mode = s.engine.GetMode().String()
+	}
+
+	return &protocol.SimulationStateResponse{
+		Type:        protocol.MsgSimulationState,
+		VirtualTime: time.Now().UnixMilli(),
+		Mode:        mode,
+		Speed:       1.0,
+		Running:     running,
+		Nodes:       nodes,
+		Metadata:    metadata,
+	}
+}
+
+// GetNodes returns node states
+func (s *Simulation) GetNodes() map[string]protocol.NodeState {
+	state := s.GetState()
+	return state.Nodes
+}
+
+// Layout places every node on the ring at its hashed position, members
+// or not
+func (s *Simulation) Layout() *protocol.Layout {
+	positions := make(map[string]float64, len(s.keys))
+	for id, key := range s.keys {
+		positions[id] = float64(key) / space
+	}
+	return &protocol.Layout{Kind: protocol.LayoutHashRing, Positions: positions}
+}
+
+// CrashNode crashes a node; its neighbours find out by stabilizing
+func (s *Simulation) CrashNode(nodeID string) error {
+	node := s.findNode(nodeID)
+	if node == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	node.mu.Lock()
+	node.status = "crashed"
+	node.mu.Unlock()
+
+	s.mu.Lock()
+	delete(s.members, nodeID)
+	s.mu.Unlock()
+	return nil
+}
+
+// RecoverNode recovers a crashed node, which lost its routing state and
+// joins the ring again
+func (s *Simulation) RecoverNode(nodeID string) error {
+	node := s.findNode(nodeID)
+	if node == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	node.mu.Lock()
+	defer node.mu.Unlock()
+
+	if node.status != "crashed" {
+		return nil
+	}
+	s.admit(node)
+	return nil
+}
+
+// HandleClientRequest drives the ring. Commands are "lookup" (payload:
+// key, optional nodeId to start from), "join" (payload: nodeId of a node
+// outside the ring) and "leave" (payload: nodeId).
+func (s *Simulation) HandleClientRequest(command string, payload map[string]interface{}) error {
+	nodeID, _ := payload["nodeId"].(string)
+
+	switch command {
+	case "lookup":
+		name, _ := payload["key"].(string)
+		if name == "" {
+			return fmt.Errorf("missing key")
+		}
+		if nodeID == "" {
+			if nodeID = s.randomMember(""); nodeID == "" {
+				return fmt.Errorf("the ring is empty")
+			}
+		}
+		node := s.findNode(nodeID)
+		if node == nil {
+			return fmt.Errorf("unknown node: %s", nodeID)
+		}
+		node.mu.Lock()
+		defer node.mu.Unlock()
+		if node.status != "running" || !node.joined {
+			return fmt.Errorf("node %s is not on the ring", nodeID)
+		}
+		node.lookup(name)
+
+	case "join":
+		node := s.findNode(nodeID)
+		if node == nil {
+			return fmt.Errorf("unknown node: %s", nodeID)
+		}
+		node.mu.Lock()
+		defer node.mu.Unlock()
+		if node.status == "running" {
+			return fmt.Errorf("node %s is already on the ring", nodeID)
+		}
+		s.admit(node)
+
+	case "leave":
+		node := s.findNode(nodeID)
+		if node == nil {
+			return fmt.Errorf("unknown node: %s", nodeID)
+		}
+		node.mu.Lock()
+		defer node.mu.Unlock()
+		if node.status != "running" || !node.joined {
+			return fmt.Errorf("node %s is not on the ring", nodeID)
+		}
+		s.leave(node)
+
+	default:
+		return fmt.Errorf("unknown command: %s", command)
+	}
+	return nil
+}
+
+// findNode looks up a node by ID; the node list is fixed after construction
+func (s *Simulation) findNode(nodeID string) *Node {
+	for _, node := range s.nodes {
+		if node.id == nodeID {
+			return node
+		}
+	}
+	return nil
+}
+
+// advanceSchedule runs the scenario once per tick: the joins, the churn
+// and a random lookup every few ticks
+func (s *Simulation) advanceSchedule(ticks int) {
+	s.mu.Lock()
+	if ticks <= s.lastTick {
+		s.mu.Unlock()
+		return
+	}
+	s.lastTick = ticks
+
+	var join *Node
+	if s.nextJoin > 0 && ticks >= s.nextJoin {
+		join = s.nodes[s.joiner]
+		s.joiner++
+		s.nextJoin = ticks + joinEvery
+		if s.joiner == len(s.nodes) {
+			s.nextJoin = 0
+		}
+	}
+
+	churn := ""
+	if s.nextChurn > 0 && ticks >= s.nextChurn {
+		s.nextChurn = ticks + churnEvery
+		churn = []string{"leave", "rejoin", "crash", "rejoin"}[s.churnStep%4]
+		s.churnStep++
+	}
+	s.mu.Unlock()
+
+	if join != nil {
+		join.mu.Lock()
+		if join.status == "outside" {
+			s.admit(join)
+		}
+		join.mu.Unlock()
+	}
+
+	switch churn {
+	case "leave", "crash":
+		// Keep a ring worth looking things up in
+		if s.memberCount() <= initialMembers {
+			break
+		}
+		if nodeID := s.randomMember(""); nodeID != "" {
+			if churn == "crash" {
+				s.CrashNode(nodeID)
+				s.broadcast(map[string]interface{}{
+					"type":   "node_crashed",
+					"nodeId": nodeID,
+				})
+			} else {
+				node := s.findNode(nodeID)
+				node.mu.Lock()
+				if node.status == "running" && node.joined {
+					s.leave(node)
+				}
+				node.mu.Unlock()
+			}
+		}
+	case "rejoin":
+		for _, node := range s.nodes {
+			node.mu.Lock()
+			down := node.status == "crashed" || node.status == "left"
+			if down {
+				if node.status == "crashed" {
+					s.broadcast(map[string]interface{}{
+						"type":   "node_recovered",
+						"nodeId": node.id,
+					})
+				}
+				s.admit(node)
+			}
+			node.mu.Unlock()
+			if down {
+				break
+			}
+		}
+	}
+
+	if ticks%lookupEvery == 0 {
+		if nodeID := s.randomMember(""); nodeID != "" {
+			node := s.findNode(nodeID)
+			node.mu.Lock()
+			if node.status == "running" && node.joined {
+				node.lookup(fmt.Sprintf("key-%d", s.rng.Intn(1000)))
+			}
+			node.mu.Unlock()
+		}
+	}
+}
+
+// admit has a node outside the ring join it through a member, or start a
+// new ring when there is none (must hold node.mu)
+func (s *Simulation) admit(node *Node) {
+	node.status = "running"
+	node.joined = false
+	node.joining = false
+	node.successors = nil
+	node.predecessor = Peer{}
+	node.fingers = make([]Peer, bits)
+	node.pending = make(map[int]*request)
+	node.awaitingPred = 0
+	node.pingSentAt = 0
+	node.bootstrap = s.bootstrapFor(node.id)
+
+	s.broadcast(map[string]interface{}{
+		"type":      "node_joining",
+		"nodeId":    node.id,
+		"key":       node.key,
+		"bootstrap": node.bootstrap,
+	})
+}
+
+// leave departs gracefully: the node hands its successor to its
+// predecessor and its predecessor to its successor (must hold node.mu)
+func (s *Simulation) leave(node *Node) {
+	succ := node.successor()
+	if succ.ID != node.id {
+		s.send(node.id, succ.ID, MsgLeave, Payload{Node: node.predecessor})
+	}
+	if pred := node.predecessor; pred.ID != "" && pred.ID != node.id {
+		s.send(node.id, pred.ID, MsgLeave, Payload{Node: succ})
+	}
+	node.status = "left"
+	node.joined = false
+
+	s.mu.Lock()
+	delete(s.members, node.id)
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":   "node_left",
+		"nodeId": node.id,
+	})
+}
+
+// joined records a node that found its place on the ring
+func (s *Simulation) joined(nodeID string) {
+	s.mu.Lock()
+	s.members[nodeID] = true
+	s.mu.Unlock()
+}
+
+// bootstrapFor picks the member a node joins through, "" if the ring is
+// empty
+func (s *Simulation) bootstrapFor(nodeID string) string {
+	return s.randomMember(nodeID)
+}
+
+// randomMember returns a ring member other than except, "" if there is
+// none
+func (s *Simulation) randomMember(except string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := make([]string, 0, len(s.members))
+	for id := range s.members {
+		if id != except {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return ""
+	}
+	sort.Strings(ids)
+	return ids[s.rng.Intn(len(ids))]
+}
+
+// memberCount returns the number of ring members
+func (s *Simulation) memberCount() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.members)
+}
+
+// ring returns the members in key order (must hold s.mu)
+func (s *Simulation) ring() []Peer {
+	ring := make([]Peer, 0, len(s.members))
+	for id := range s.members {
+		ring = append(ring, Peer{ID: id, Key: s.keys[id]})
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].Key < ring[j].Key })
+	return ring
+}
+
+// successorOf returns the member a key belongs to, "" if the ring is
+// empty (must hold s.mu)
+func (s *Simulation) successorOf(key int) string {
+	ring := s.ring()
+	if len(ring) == 0 {
+		return ""
+	}
+	for _, p := range ring {
+		if p.Key >= key {
+			return p.ID
+		}
+	}
+	return ring[0].ID
+}
+
+// lookupDone records a lookup and checks its result against the ring
+func (s *Simulation) lookupDone(lookup Lookup) {
+	s.mu.Lock()
+	lookup.Expected = s.successorOf(lookup.Key)
+	lookup.Correct = !lookup.Failed && lookup.Result == lookup.Expected
+	s.total++
+	if lookup.Failed {
+		s.failed++
+	} else {
+		s.hops[lookup.Hops]++
+	}
+	if lookup.Correct {
+		s.correct++
+	}
+	s.lookups = append(s.lookups, lookup)
+	if len(s.lookups) > recentLookups {
+		s.lookups = s.lookups[1:]
+	}
+	s.mu.Unlock()
+
+	eventType := "lookup_completed"
+	if lookup.Failed {
+		eventType = "lookup_failed"
+	}
+	s.broadcast(map[string]interface{}{
+		"type":     eventType,
+		"nodeId":   lookup.Origin,
+		"name":     lookup.Name,
+		"key":      lookup.Key,
+		"result":   lookup.Result,
+		"expected": lookup.Expected,
+		"hops":     lookup.Hops,
+		"correct":  lookup.Correct,
+	})
+}
+
+// send transmits a message and reports it to the frontend
+func (s *Simulation) send(from, to string, msgType transport.MessageType, payload Payload) {
+	env := transport.NewEnvelope(from, to, msgType, payload)
+
+	s.mu.Lock()
+	s.messages[string(msgType)]++
+	s.mu.Unlock()
+
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageSent,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+
+	s.transport.Send(s.ctx, env)
+}
+
+// received reports a delivered message and returns its payload
+func (s *Simulation) received(env *transport.Envelope) Payload {
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageReceived,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+	payload, _ := env.Payload.(Payload)
+	return payload
+}
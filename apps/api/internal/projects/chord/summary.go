@@ -0,0 +1,50 @@
+package chord
+
+import (
+	"fmt"
+
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+)
+
+// Invariants judges the run: stabilization left every member pointing at
+// the next one, and lookups found the node a key belongs to
+func (s *Simulation) Invariants() []protocol.InvariantResult {
+	metadata := s.GetState().Metadata
+	total := metadata["lookupCount"].(int)
+	correct := metadata["lookupsCorrect"].(int)
+
+	return []protocol.InvariantResult{
+		{
+			Name:   "successors correct",
+			Holds:  metadata["successorsCorrect"].(bool),
+			Detail: fmt.Sprintf("%d members, %.0f%% of fingers correct", metadata["members"].(int), 100*metadata["fingersCorrect"].(float64)),
+		},
+		{
+			// Lookups racing a join or a crash may miss; most must not
+			Name:   "lookups correct",
+			Holds:  total == 0 || float64(correct) >= 0.9*float64(total),
+			Detail: fmt.Sprintf("%d of %d lookups found the right node, %.1f hops on average", correct, total, metadata["avgHops"].(float64)),
+		},
+	}
+}
+
+// FollowUps suggests the scenarios that contrast with this one
+func (s *Simulation) FollowUps() []protocol.FollowUp {
+	switch s.scenario {
+	case "stable":
+		return []protocol.FollowUp{
+			{Project: "chord", Scenario: "joins", Reason: "Grow the ring and watch stabilization find the new nodes"},
+		}
+	case "joins":
+		return []protocol.FollowUp{
+			{Project: "chord", Scenario: "churn", Reason: "Have nodes leave and crash while lookups go on"},
+		}
+	case "churn":
+		return []protocol.FollowUp{
+			{Project: "chord", Scenario: "stable", Reason: "Compare the hops per lookup on a ring that does not change"},
+		}
+	}
+	return []protocol.FollowUp{
+		{Project: "chord", Scenario: "joins", Reason: "Grow the ring one node at a time"},
+	}
+}
@@ -24,6 +24,7 @@ type Simulation struct {
 	mu sync.RWMutex
 
 	engine    *engine.Engine
+	rng       *rand.Rand
 	transport *transport.NetworkTransport
 	broadcast func(interface{})
 
@@ -86,6 +87,7 @@ func NewSimulation(eng *engine.Engine, trans *transport.NetworkTransport, broadc
 
 	sim := &Simulation{
 		engine:    eng,
+		rng:       eng.Rand(),
 		transport: trans,
 		broadcast: broadcast,
 		nodeCount: config.NodeCount,
@@ -205,6 +207,15 @@ func (s *Simulation) GetNodes() map[string]protocol.NodeState {
 	return state.Nodes
 }
 
+// Layout places the nodes on a ring
+func (s *Simulation) Layout() *protocol.Layout {
+	order := make([]string, len(s.nodes))
+	for i, node := range s.nodes {
+		order[i] = node.id
+	}
+	return &protocol.Layout{Kind: protocol.LayoutRing, Order: order}
+}
+
 // CrashNode crashes a node
 func (s *Simulation) CrashNode(nodeID string) error {
 	s.mu.Lock()
@@ -261,8 +272,8 @@ func (n *ClockNode) Tick() {
 		n.processMessage(env)
 	default:
 		// Randomly perform local events or send messages
-		if rand.Float64() < 0.3 { // 30% chance per tick
-			if rand.Float64() < 0.5 {
+		if n.simulation.rng.Float64() < 0.3 { // 30% chance per tick
+			if n.simulation.rng.Float64() < 0.5 {
 				n.performLocalEvent()
 			} else {
 				n.sendRandomMessage()
@@ -285,7 +296,10 @@ func (n *ClockNode) GetState() map[string]interface{} {
 }
 
 func (n *ClockNode) handleMessage(env *transport.Envelope) {
-	n.inbox <- env
+	select {
+	case n.inbox <- env:
+	default:
+	}
 }
 
 func (n *ClockNode) processMessage(env *transport.Envelope) {
@@ -376,7 +390,7 @@ func (n *ClockNode) sendRandomMessage() {
 	// Pick random target
 	var targetID string
 	for {
-		targetID = n.nodeIDs[rand.Intn(len(n.nodeIDs))]
+		targetID = n.nodeIDs[n.simulation.rng.Intn(len(n.nodeIDs))]
 		if targetID != n.id {
 			break
 		}
@@ -472,7 +486,3 @@ func (s *Simulation) CompareEvents(eventA, eventB string) string {
 		return "unknown"
 	}
 }
-
-func init() {
-	rand.Seed(time.Now().UnixNano())
-}
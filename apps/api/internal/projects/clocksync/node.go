@@ -0,0 +1,316 @@
+package clocksync
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+const (
+	MsgTimeRequest transport.MessageType = "time_request"
+	MsgTimeReply   transport.MessageType = "time_reply"
+	MsgPoll        transport.MessageType = "poll"
+	MsgPollReply   transport.MessageType = "poll_reply"
+	MsgAdjust      transport.MessageType = "adjust"
+)
+
+const (
+	syncEvery      = 20  // Ticks between two synchronizations of a node
+	sampleEvery    = 5   // Ticks between two reports of a clock's offset
+	pollTimeout    = 5   // Ticks the Berkeley master waits for the replies of a round
+	maxDeviationMs = 150 // Berkeley ignores clocks further than this from the median
+)
+
+// Node has a physical clock that runs at its own rate and that a
+// synchronization algorithm adjusts. Its clock reads
+// offsetMs + (1+rate) * true time.
+type Node struct {
+	mu sync.RWMutex
+
+	id     string
+	status string // "running" or "crashed"
+	role   string // "time_server", "client", "master", "slave"
+	ticks  int
+
+	rate     float64 // Fraction the clock runs fast, negative when slow
+	offsetMs float64
+	nextSync int
+
+	syncs          int
+	lastAdjustMs   float64
+	lastRttMs      float64
+	backwardJumps  int // Adjustments that turned the clock back
+	requestSentMs  float64
+	requestPending bool
+
+	// Berkeley master
+	round      int
+	roundStart int                // Tick the round's polls went out
+	diffs      map[string]float64 // Estimated clock minus own clock, per replying slave
+
+	inbox      chan *transport.Envelope
+	simulation *Simulation
+}
+
+func newNode(id, role string, rate, offsetMs float64, nextSync int, sim *Simulation) *Node {
+	return &Node{
+		id:         id,
+		status:     "running",
+		role:       role,
+		rate:       rate,
+		offsetMs:   offsetMs,
+		nextSync:   nextSync,
+		inbox:      make(chan *transport.Envelope, 500),
+		simulation: sim,
+	}
+}
+
+// Node implements engine.NodeController
+
+func (n *Node) ID() string {
+	return n.id
+}
+
+func (n *Node) Start(ctx context.Context) error {
+	return nil
+}
+
+func (n *Node) Stop() error {
+	return nil
+}
+
+func (n *Node) Tick() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.ticks++
+	if n.status == "crashed" {
+		return
+	}
+
+	for i := 0; i < 50; i++ {
+		select {
+		case env := <-n.inbox:
+			n.processMessage(env)
+			continue
+		default:
+		}
+		break
+	}
+
+	if n.ticks%sampleEvery == 0 {
+		n.report("sample", 0)
+	}
+
+	sim := n.simulation
+	switch n.role {
+	case "client":
+		if sim.algorithm == "cristian" && n.ticks >= n.nextSync {
+			n.nextSync = n.ticks + syncEvery
+			n.requestSentMs = n.clockAt(n.simulation.engine.GetVirtualTime())
+			n.requestPending = true
+			sim.send(n.id, sim.nodes[0].id, MsgTimeRequest, Payload{Sent: n.requestSentMs})
+		}
+	case "master":
+		if n.diffs != nil && (len(n.diffs) == len(sim.nodes)-1 || n.ticks-n.roundStart >= pollTimeout) {
+			n.average()
+		}
+		if n.diffs == nil && n.ticks >= n.nextSync {
+			n.poll()
+		}
+	}
+}
+
+func (n *Node) GetState() map[string]interface{} {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	now := n.simulation.engine.GetVirtualTime()
+	return map[string]interface{}{
+		"id":            n.id,
+		"status":        n.status,
+		"role":          n.role,
+		"clockMs":       math.Round(n.clockAt(now)),
+		"offsetMs":      round1(n.offsetAt(now)),
+		"driftPercent":  round1(n.rate * 100),
+		"syncs":         n.syncs,
+		"lastAdjustMs":  round1(n.lastAdjustMs),
+		"lastRttMs":     round1(n.lastRttMs),
+		"backwardJumps": n.backwardJumps,
+	}
+}
+
+func (n *Node) handleMessage(env *transport.Envelope) {
+	n.mu.RLock()
+	down := n.status == "crashed"
+	n.mu.RUnlock()
+
+	if down {
+		return
+	}
+	select {
+	case n.inbox <- env:
+	default:
+	}
+}
+
+func (n *Node) processMessage(env *transport.Envelope) {
+	sim := n.simulation
+	payload := sim.received(env)
+	receivedMs := n.clockAt(env.ReceivedAt)
+
+	switch env.Type {
+	case MsgTimeRequest, MsgPoll:
+		// Both carry the time the request arrived and the time the reply
+		// leaves, so the requester can take out the time spent here
+		reply := MsgTimeReply
+		if env.Type == MsgPoll {
+			reply = MsgPollReply
+		}
+		sim.send(n.id, env.From, reply, Payload{
+			Round:    payload.Round,
+			Sent:     payload.Sent,
+			Received: receivedMs,
+			Reply:    n.clockAt(n.simulation.engine.GetVirtualTime()),
+		})
+
+	case MsgTimeReply:
+		// Cristian: the server's time was Reply half a round trip ago
+		if !n.requestPending || payload.Sent != n.requestSentMs {
+			return
+		}
+		n.requestPending = false
+		rtt := (receivedMs - payload.Sent) - (payload.Reply - payload.Received)
+		n.lastRttMs = rtt
+		n.adjust(payload.Reply+rtt/2-receivedMs, "cristian")
+
+	case MsgPollReply:
+		// Berkeley: estimate how far the slave's clock is ahead of ours
+		if n.diffs == nil || payload.Round != n.round {
+			return
+		}
+		rtt := (receivedMs - payload.Sent) - (payload.Reply - payload.Received)
+		n.lastRttMs = rtt
+		n.diffs[env.From] = payload.Reply + rtt/2 - receivedMs
+
+	case MsgAdjust:
+		n.adjust(payload.AdjustMs, "berkeley")
+	}
+}
+
+// clockAt reads the clock as it was at a given moment (must hold n.mu)
+func (n *Node) clockAt(at time.Time) float64 {
+	return n.offsetMs + (1+n.rate)*n.simulation.trueMsAt(at)
+}
+
+// offsetAt returns how far the clock is ahead of the true time (must
+// hold n.mu)
+func (n *Node) offsetAt(at time.Time) float64 {
+	return n.clockAt(at) - n.simulation.trueMsAt(at)
+}
+
+// adjust moves the clock by delta at once; a negative delta turns it
+// back, which a real system avoids by slowing the clock down instead
+// (must hold n.mu)
+func (n *Node) adjust(delta float64, cause string) {
+	n.offsetMs += delta
+	n.syncs++
+	n.lastAdjustMs = delta
+	if delta < 0 {
+		n.backwardJumps++
+	}
+	n.simulation.synced(delta)
+	n.report(cause, delta)
+}
+
+// report broadcasts the clock's offset from the true time (must hold
+// n.mu)
+func (n *Node) report(cause string, adjustMs float64) {
+	now := n.simulation.engine.GetVirtualTime()
+	event := map[string]interface{}{
+		"type":     "clock_update",
+		"nodeId":   n.id,
+		"cause":    cause, // "sample", "cristian", "berkeley"
+		"clockMs":  math.Round(n.clockAt(now)),
+		"offsetMs": round1(n.offsetAt(now)),
+	}
+	if cause != "sample" {
+		event["adjustMs"] = round1(adjustMs)
+		event["rttMs"] = round1(n.lastRttMs)
+	}
+	n.simulation.broadcast(event)
+}
+
+// poll starts a Berkeley round, asking every slave for its time (must
+// hold n.mu)
+func (n *Node) poll() {
+	sim := n.simulation
+	n.round++
+	n.roundStart = n.ticks
+	n.nextSync = n.ticks + syncEvery
+	n.diffs = make(map[string]float64)
+	sent := n.clockAt(n.simulation.engine.GetVirtualTime())
+	for _, peer := range sim.nodes {
+		if peer.id != n.id {
+			sim.send(n.id, peer.id, MsgPoll, Payload{Round: n.round, Sent: sent})
+		}
+	}
+}
+
+// average ends a Berkeley round: the master averages the clocks that
+// answered, its own included, leaving out those too far from the median
+// to be trusted, and tells every clock how far to move to reach the
+// average (must hold n.mu)
+func (n *Node) average() {
+	sim := n.simulation
+
+	diffs := map[string]float64{n.id: 0}
+	for id, diff := range n.diffs {
+		diffs[id] = diff
+	}
+	n.diffs = nil
+
+	sorted := make([]float64, 0, len(diffs))
+	for _, diff := range diffs {
+		sorted = append(sorted, diff)
+	}
+	sort.Float64s(sorted)
+	median := sorted[len(sorted)/2]
+
+	sum, counted := 0.0, 0
+	ignored := make([]string, 0)
+	for id, diff := range diffs {
+		if math.Abs(diff-median) > maxDeviationMs {
+			ignored = append(ignored, id)
+			continue
+		}
+		sum += diff
+		counted++
+	}
+	avg := sum / float64(counted)
+	sort.Strings(ignored)
+	sim.averaged(ignored)
+
+	sim.broadcast(map[string]interface{}{
+		"type":      "berkeley_round",
+		"nodeId":    n.id,
+		"round":     n.round,
+		"replies":   len(diffs) - 1,
+		"averageMs": round1(avg),
+		"ignored":   ignored,
+	})
+	for id, diff := range diffs {
+		if id != n.id {
+			sim.send(n.id, id, MsgAdjust, Payload{Round: n.round, AdjustMs: avg - diff})
+		}
+	}
+	n.adjust(avg, "berkeley")
+}
+
+func round1(v float64) float64 {
+	return math.Round(v*10) / 10
+}
@@ -0,0 +1,359 @@
+package clocksync
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+	"github.com/ersantana/distributed-systems-learning/packages/simulation/engine"
+)
+
+const (
+	maxDrift        = 0.01 // Fastest a healthy clock runs, 1% here where real clocks drift by ppm
+	faultyDrift     = 0.1  // Rate of the faulty clock in "berkeley"
+	maxStartOffset  = 300  // Ms a clock is off by when the run starts
+	syncToleranceMs = 100  // Offset the synchronized clocks should stay within
+)
+
+// Payload is the content of all messages exchanged in this project. The
+// times are readings of the clocks involved, in ms.
+type Payload struct {
+	Round    int     `json:"round,omitempty"`
+	Sent     float64 `json:"sent,omitempty"`     // Requester's clock when the request left
+	Received float64 `json:"received,omitempty"` // Responder's clock when the request arrived
+	Reply    float64 `json:"reply,omitempty"`    // Responder's clock when the reply left
+	AdjustMs float64 `json:"adjustMs,omitempty"`
+}
+
+// Simulation synchronizes drifting physical clocks over the network.
+// Every clock runs at its own rate and starts off by up to
+// maxStartOffset; the true time is the wall time since the run started.
+//
+// With Cristian's algorithm ("cristian") the clients ask a time server
+// with an accurate clock for the time and set their clock to it plus
+// half the round trip, the time the reply probably spent on the way. In
+// "cristian_asymmetric" replies take much longer than requests, so half
+// the round trip underestimates their delay and the clients settle
+// behind the server. With the Berkeley algorithm ("berkeley") there is no
+// accurate clock: a master polls every clock, averages them, leaving out
+// a faulty one that runs fast, and tells each how far to move. The
+// clocks then agree with each other, though not with the true time.
+// "no_sync" lets the clocks drift apart.
+type Simulation struct {
+	mu sync.RWMutex
+
+	engine    *engine.Engine
+	rng       *rand.Rand
+	transport *transport.NetworkTransport
+	broadcast func(interface{})
+
+	nodes     []*Node
+	scenario  string
+	algorithm string    // "cristian", "berkeley" or "none"
+	start     time.Time // True time zero
+
+	syncs    int
+	adjusted float64         // Size of the adjustments, summed
+	ignored  map[string]bool // Clocks the last Berkeley round left out of the average
+	messages map[string]int
+
+	running bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// Config for clock synchronization simulation
+type Config struct {
+	NodeCount int
+	Scenario  string // "cristian", "cristian_asymmetric", "berkeley", "no_sync"
+}
+
+// NewSimulation creates a new clock synchronization simulation
+func NewSimulation(eng *engine.Engine, trans *transport.NetworkTransport, broadcast func(interface{}), config Config) *Simulation {
+	algorithm := "cristian"
+	switch config.Scenario {
+	case "cristian", "cristian_asymmetric":
+	case "berkeley":
+		algorithm = "berkeley"
+	case "no_sync":
+		algorithm = "none"
+	default:
+		config.Scenario = "cristian"
+	}
+	if config.NodeCount < 3 {
+		config.NodeCount = 5
+	}
+
+	sim := &Simulation{
+		engine:    eng,
+		rng:       eng.Rand(),
+		transport: trans,
+		broadcast: broadcast,
+		scenario:  config.Scenario,
+		algorithm: algorithm,
+		start:     eng.GetVirtualTime(),
+		ignored:   make(map[string]bool),
+		messages:  make(map[string]int),
+	}
+
+	trans.SetLatency(10*time.Millisecond, 40*time.Millisecond)
+	trans.SetPacketLoss(0)
+
+	for i := 0; i < config.NodeCount; i++ {
+		id := fmt.Sprintf("node-%d", i+1)
+		role := "standalone"
+		rate := (sim.rng.Float64()*2 - 1) * maxDrift
+		offset := (sim.rng.Float64()*2 - 1) * maxStartOffset
+		switch {
+		case algorithm == "cristian" && i == 0:
+			role, rate, offset = "time_server", 0, 0
+		case algorithm == "cristian":
+			role = "client"
+		case algorithm == "berkeley" && i == 0:
+			role = "master"
+		case algorithm == "berkeley":
+			role = "slave"
+			if i == config.NodeCount-1 {
+				rate = faultyDrift
+			}
+		}
+		node := newNode(id, role, rate, offset, 5+3*i, sim)
+		sim.nodes = append(sim.nodes, node)
+		trans.RegisterHandler(id, node.handleMessage)
+		eng.AddNode(node)
+	}
+
+	if config.Scenario == "cristian_asymmetric" {
+		server := sim.nodes[0].id
+		for _, node := range sim.nodes[1:] {
+			trans.SetLinkLatency(server, node.id, 250*time.Millisecond, 300*time.Millisecond)
+		}
+	}
+
+	return sim
+}
+
+// Start starts the simulation
+func (s *Simulation) Start(ctx context.Context) error {
+	s.mu.Lock()
+	s.running = true
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.mu.Unlock()
+
+	return s.engine.Start(ctx)
+}
+
+// Stop stops the simulation
+func (s *Simulation) Stop() error {
+	s.mu.Lock()
+	s.running = false
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Unlock()
+
+	return s.engine.Stop()
+}
+
+// GetState returns the current simulation state
+func (s *Simulation) GetState() *protocol.SimulationStateResponse {
+	nodes := make(map[string]protocol.NodeState)
+	offsets := make(map[string]float64)
+	backward := 0
+	for _, node := range s.nodes {
+		state := node.GetState()
+		nodes[node.id] = protocol.NodeState{
+			ID:          node.id,
+			Status:      state["status"].(string),
+			Role:        state["role"].(string),
+			CustomState: state,
+		}
+		offsets[node.id] = state["offsetMs"].(float64)
+		backward += state["backwardJumps"].(int)
+	}
+
+	s.mu.RLock()
+	running := s.running
+	maxOffset, spread := s.skew(offsets)
+	ignored := make([]string, 0, len(s.ignored))
+	for _, node := range s.nodes {
+		if s.ignored[node.id] {
+			ignored = append(ignored, node.id)
+		}
+	}
+	avgAdjust := 0.0
+	if s.syncs > 0 {
+		avgAdjust = s.adjusted / float64(s.syncs)
+	}
+	messages := make(map[string]int, len(s.messages))
+	for msgType, count := range s.messages {
+		messages[msgType] = count
+	}
+	metadata := map[string]interface{}{
+		"scenario":      s.scenario,
+		"algorithm":     s.algorithm,
+		"trueTimeMs":    math.Round(s.trueMsAt(s.engine.GetVirtualTime())),
+		"offsetMs":      offsets,
+		"maxOffsetMs":   maxOffset, // Largest offset from the true time
+		"spreadMs":      spread,    // Fastest clock minus slowest, leaving out the ignored ones
+		"ignored":       ignored,
+		"toleranceMs":   syncToleranceMs,
+		"syncs":         s.syncs,
+		"avgAdjustMs":   round1(avgAdjust),
+		"backwardJumps": backward,
+		"messages":      messages,
+	}
+	s.mu.RUnlock()
+
+	mode := "step"
+	if s.engine != nil {
+		mode = s.engine.GetMode().String()
+	}
+
+	return &protocol.SimulationStateResponse{
+		Type:        protocol.MsgSimulationState,
+		VirtualTime: time.Now().UnixMilli(),
+		Mode:        mode,
+		Speed:       1.0,
+		Running:     running,
+		Nodes:       nodes,
+		Metadata:    metadata,
+	}
+}
+
+// GetNodes returns node states
+func (s *Simulation) GetNodes() map[string]protocol.NodeState {
+	state := s.GetState()
+	return state.Nodes
+}
+
+// Layout puts the time server or the Berkeley master in the middle
+func (s *Simulation) Layout() *protocol.Layout {
+	order := make([]string, 0, len(s.nodes))
+	for _, node := range s.nodes {
+		order = append(order, node.id)
+	}
+	if s.algorithm == "none" {
+		return &protocol.Layout{Kind: protocol.LayoutRing, Order: order}
+	}
+	return &protocol.Layout{Kind: protocol.LayoutStar, Center: order[0], Order: order[1:]}
+}
+
+// CrashNode crashes a node; its clock keeps running but it no longer
+// synchronizes
+func (s *Simulation) CrashNode(nodeID string) error {
+	node := s.findNode(nodeID)
+	if node == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	node.mu.Lock()
+	node.status = "crashed"
+	node.requestPending = false
+	node.diffs = nil
+	node.mu.Unlock()
+	return nil
+}
+
+// RecoverNode recovers a crashed node
+func (s *Simulation) RecoverNode(nodeID string) error {
+	node := s.findNode(nodeID)
+	if node == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	node.mu.Lock()
+	node.status = "running"
+	node.mu.Unlock()
+	return nil
+}
+
+// findNode looks up a node by ID; the node list is fixed after
+// construction
+func (s *Simulation) findNode(nodeID string) *Node {
+	for _, node := range s.nodes {
+		if node.id == nodeID {
+			return node
+		}
+	}
+	return nil
+}
+
+// trueMsAt returns the true time at a given moment, which no node can
+// read
+func (s *Simulation) trueMsAt(at time.Time) float64 {
+	return float64(at.Sub(s.start).Microseconds()) / 1000
+}
+
+// skew returns the largest offset from the true time, which is the time
+// server's, and the spread between the fastest and the slowest clock,
+// all Berkeley promises to keep small. The clocks Berkeley left out of
+// its last average are faulty and do not count in the spread. (must
+// hold s.mu)
+func (s *Simulation) skew(offsets map[string]float64) (float64, float64) {
+	maxOffset := 0.0
+	low, high := math.Inf(1), math.Inf(-1)
+	for _, node := range s.nodes {
+		offset := offsets[node.id]
+		maxOffset = max(maxOffset, math.Abs(offset))
+		if !s.ignored[node.id] {
+			low, high = min(low, offset), max(high, offset)
+		}
+	}
+	return round1(maxOffset), round1(high - low)
+}
+
+// averaged records the clocks a Berkeley round left out of its average
+func (s *Simulation) averaged(ignored []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ignored = make(map[string]bool, len(ignored))
+	for _, id := range ignored {
+		s.ignored[id] = true
+	}
+}
+
+// synced records a clock adjustment
+func (s *Simulation) synced(delta float64) {
+	s.mu.Lock()
+	s.syncs++
+	s.adjusted += math.Abs(delta)
+	s.mu.Unlock()
+}
+
+// send transmits a message and reports it to the frontend
+func (s *Simulation) send(from, to string, msgType transport.MessageType, payload Payload) {
+	env := transport.NewEnvelope(from, to, msgType, payload)
+
+	s.mu.Lock()
+	s.messages[string(msgType)]++
+	s.mu.Unlock()
+
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageSent,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+
+	s.transport.Send(s.ctx, env)
+}
+
+// received reports a delivered message and returns its payload
+func (s *Simulation) received(env *transport.Envelope) Payload {
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageReceived,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+	payload, _ := env.Payload.(Payload)
+	return payload
+}
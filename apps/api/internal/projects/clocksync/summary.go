@@ -0,0 +1,57 @@
+package clocksync
+
+import (
+	"fmt"
+
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+)
+
+// Invariants judges the run: Cristian's clients end within
+// syncToleranceMs of the time server, and the Berkeley clocks but the
+// faulty ones within syncToleranceMs of each other
+func (s *Simulation) Invariants() []protocol.InvariantResult {
+	state := s.GetState()
+	maxOffset := state.Metadata["maxOffsetMs"].(float64)
+	spread := state.Metadata["spreadMs"].(float64)
+
+	if s.algorithm == "berkeley" {
+		return []protocol.InvariantResult{
+			{
+				Name:   "clocks agree with each other",
+				Holds:  spread <= syncToleranceMs,
+				Detail: fmt.Sprintf("fastest and slowest clock %.1fms apart, %.1fms at most from the true time, %v left out as faulty", spread, maxOffset, state.Metadata["ignored"]),
+			},
+		}
+	}
+	return []protocol.InvariantResult{
+		{
+			Name:   "clocks agree with the true time",
+			Holds:  maxOffset <= syncToleranceMs,
+			Detail: fmt.Sprintf("clocks up to %.1fms off the true time, %.1fms apart (algorithm: %s)", maxOffset, spread, s.algorithm),
+		},
+	}
+}
+
+// FollowUps suggests the scenarios that contrast with this one
+func (s *Simulation) FollowUps() []protocol.FollowUp {
+	switch s.scenario {
+	case "cristian":
+		return []protocol.FollowUp{
+			{Project: "clock-sync", Scenario: "cristian_asymmetric", Reason: "Make replies slower than requests and see the clients settle behind"},
+			{Project: "clock-sync", Scenario: "berkeley", Reason: "Synchronize without an accurate clock"},
+		}
+	case "cristian_asymmetric":
+		return []protocol.FollowUp{
+			{Project: "clock-sync", Scenario: "cristian", Reason: "Compare with a network as fast both ways"},
+		}
+	case "berkeley":
+		return []protocol.FollowUp{
+			{Project: "truetime", Scenario: "commit_wait", Reason: "Live with clock uncertainty instead of hiding it"},
+			{Project: "clocks", Reason: "Order events with logical clocks instead"},
+		}
+	}
+	return []protocol.FollowUp{
+		{Project: "clock-sync", Scenario: "cristian", Reason: "Synchronize the clocks against a time server"},
+		{Project: "clock-sync", Scenario: "berkeley", Reason: "Synchronize the clocks by averaging them"},
+	}
+}
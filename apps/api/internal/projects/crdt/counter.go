@@ -0,0 +1,152 @@
+package crdt
+
+// Replica is a state-based CRDT held by one node. Replicas gossip
+// snapshots of their state and merge what they receive; merge must be
+// commutative, associative and idempotent so all replicas converge.
+type Replica interface {
+	// Type names the CRDT, e.g. "g_counter"
+	Type() string
+	// Snapshot returns a deep copy of the state to send to peers
+	Snapshot() interface{}
+	// Merge folds a peer's snapshot into this replica, reporting whether
+	// anything changed
+	Merge(snapshot interface{}) bool
+	// Value returns the user-visible value
+	Value() interface{}
+	// State exposes the internal state for visualization
+	State() map[string]interface{}
+}
+
+// GCounter is a grow-only counter: one monotonically increasing
+// component per replica, merged by taking the pointwise maximum
+type GCounter struct {
+	counts map[string]uint64
+}
+
+// NewGCounter creates an empty G-Counter
+func NewGCounter() *GCounter {
+	return &GCounter{counts: make(map[string]uint64)}
+}
+
+// Increment adds n to the component owned by nodeID
+func (c *GCounter) Increment(nodeID string, n uint64) {
+	c.counts[nodeID] += n
+}
+
+// Total returns the sum of all components
+func (c *GCounter) Total() uint64 {
+	var total uint64
+	for _, v := range c.counts {
+		total += v
+	}
+	return total
+}
+
+// Components returns a copy of the per-replica components
+func (c *GCounter) Components() map[string]uint64 {
+	counts := make(map[string]uint64, len(c.counts))
+	for k, v := range c.counts {
+		counts[k] = v
+	}
+	return counts
+}
+
+// mergeCounts takes the pointwise maximum with other
+func (c *GCounter) mergeCounts(other map[string]uint64) bool {
+	changed := false
+	for k, v := range other {
+		if v > c.counts[k] {
+			c.counts[k] = v
+			changed = true
+		}
+	}
+	return changed
+}
+
+func (c *GCounter) Type() string {
+	return "g_counter"
+}
+
+func (c *GCounter) Snapshot() interface{} {
+	return c.Components()
+}
+
+func (c *GCounter) Merge(snapshot interface{}) bool {
+	other, ok := snapshot.(map[string]uint64)
+	if !ok {
+		return false
+	}
+	return c.mergeCounts(other)
+}
+
+func (c *GCounter) Value() interface{} {
+	return c.Total()
+}
+
+func (c *GCounter) State() map[string]interface{} {
+	return map[string]interface{}{
+		"components": c.Components(),
+	}
+}
+
+// PNCounter supports decrements by pairing two G-Counters: one for
+// increments (P) and one for decrements (N); the value is P - N
+type PNCounter struct {
+	p *GCounter
+	n *GCounter
+}
+
+// PNSnapshot is the gossiped state of a PN-Counter
+type PNSnapshot struct {
+	P map[string]uint64 `json:"p"`
+	N map[string]uint64 `json:"n"`
+}
+
+// NewPNCounter creates an empty PN-Counter
+func NewPNCounter() *PNCounter {
+	return &PNCounter{p: NewGCounter(), n: NewGCounter()}
+}
+
+// Increment adds n to the counter on behalf of nodeID
+func (c *PNCounter) Increment(nodeID string, n uint64) {
+	c.p.Increment(nodeID, n)
+}
+
+// Decrement subtracts n from the counter on behalf of nodeID
+func (c *PNCounter) Decrement(nodeID string, n uint64) {
+	c.n.Increment(nodeID, n)
+}
+
+// Total returns P - N
+func (c *PNCounter) Total() int64 {
+	return int64(c.p.Total()) - int64(c.n.Total())
+}
+
+func (c *PNCounter) Type() string {
+	return "pn_counter"
+}
+
+func (c *PNCounter) Snapshot() interface{} {
+	return PNSnapshot{P: c.p.Components(), N: c.n.Components()}
+}
+
+func (c *PNCounter) Merge(snapshot interface{}) bool {
+	other, ok := snapshot.(PNSnapshot)
+	if !ok {
+		return false
+	}
+	changedP := c.p.mergeCounts(other.P)
+	changedN := c.n.mergeCounts(other.N)
+	return changedP || changedN
+}
+
+func (c *PNCounter) Value() interface{} {
+	return c.Total()
+}
+
+func (c *PNCounter) State() map[string]interface{} {
+	return map[string]interface{}{
+		"p": c.p.Components(),
+		"n": c.n.Components(),
+	}
+}
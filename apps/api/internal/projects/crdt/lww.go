@@ -0,0 +1,214 @@
+package crdt
+
+import (
+	"sort"
+)
+
+// LWWEntry is one timestamped write. Merges keep the entry with the
+// highest (Timestamp, Node) pair, where Timestamp comes from the writing
+// node's own wall clock; a clock that runs behind makes its writes lose.
+type LWWEntry struct {
+	Key       string `json:"key,omitempty"`
+	Value     string `json:"value"`
+	Deleted   bool   `json:"deleted,omitempty"`
+	Timestamp int64  `json:"timestamp"` // Writer's clock, unix millis
+	Node      string `json:"node"`
+
+	// WrittenAt is the true (virtual) time of the write. Merges never
+	// look at it; it only lets the simulation notice lost updates.
+	WrittenAt int64 `json:"writtenAt"`
+}
+
+// wins reports whether e beats other under last-writer-wins
+func (e LWWEntry) wins(other LWWEntry) bool {
+	if e.Timestamp != other.Timestamp {
+		return e.Timestamp > other.Timestamp
+	}
+	return e.Node > other.Node
+}
+
+// lwwMerge folds in into cur. It reports whether cur changed and, when
+// the losing write actually happened later in true time, returns it as a
+// lost update.
+func lwwMerge(cur *LWWEntry, in LWWEntry) (bool, *LWWEntry) {
+	if cur.Node == "" {
+		*cur = in
+		return true, nil
+	}
+	if *cur == in {
+		return false, nil
+	}
+
+	winner, loser := *cur, in
+	if in.wins(*cur) {
+		winner, loser = in, *cur
+		*cur = in
+	}
+	if loser.WrittenAt > winner.WrittenAt {
+		return winner == in, &loser
+	}
+	return winner == in, nil
+}
+
+// lossReporter is implemented by replicas that can silently drop writes
+type lossReporter interface {
+	// takeLost returns the writes discarded since the last call
+	takeLost() []LWWEntry
+}
+
+// LWWRegister holds a single value; concurrent writes are resolved by
+// keeping the one with the latest timestamp
+type LWWRegister struct {
+	entry LWWEntry
+	lost  []LWWEntry
+}
+
+// NewLWWRegister creates an unset register
+func NewLWWRegister() *LWWRegister {
+	return &LWWRegister{}
+}
+
+// Set writes value stamped with the writer's clock. It reports whether
+// the write took effect: a stamp older than the current one loses even
+// on the replica that issued it.
+func (r *LWWRegister) Set(value, node string, timestamp, writtenAt int64) bool {
+	return r.merge(LWWEntry{Value: value, Timestamp: timestamp, Node: node, WrittenAt: writtenAt})
+}
+
+func (r *LWWRegister) merge(in LWWEntry) bool {
+	changed, lost := lwwMerge(&r.entry, in)
+	if lost != nil {
+		r.lost = append(r.lost, *lost)
+	}
+	return changed
+}
+
+func (r *LWWRegister) takeLost() []LWWEntry {
+	lost := r.lost
+	r.lost = nil
+	return lost
+}
+
+func (r *LWWRegister) Type() string {
+	return "lww_register"
+}
+
+func (r *LWWRegister) Snapshot() interface{} {
+	return r.entry
+}
+
+func (r *LWWRegister) Merge(snapshot interface{}) bool {
+	other, ok := snapshot.(LWWEntry)
+	if !ok || other.Node == "" {
+		return false
+	}
+	return r.merge(other)
+}
+
+func (r *LWWRegister) Value() interface{} {
+	if r.entry.Node == "" {
+		return nil
+	}
+	return r.entry.Value
+}
+
+func (r *LWWRegister) State() map[string]interface{} {
+	return map[string]interface{}{
+		"entry": r.entry,
+	}
+}
+
+// LWWMap is a map whose keys are independent LWW registers. Deletes are
+// kept as timestamped tombstones so an older write cannot resurrect a key.
+type LWWMap struct {
+	entries map[string]LWWEntry
+	lost    []LWWEntry
+}
+
+// NewLWWMap creates an empty map
+func NewLWWMap() *LWWMap {
+	return &LWWMap{entries: make(map[string]LWWEntry)}
+}
+
+// Set writes key=value; see LWWRegister.Set for the result
+func (m *LWWMap) Set(key, value, node string, timestamp, writtenAt int64) bool {
+	return m.merge(LWWEntry{Key: key, Value: value, Timestamp: timestamp, Node: node, WrittenAt: writtenAt})
+}
+
+// Delete tombstones key; see LWWRegister.Set for the result
+func (m *LWWMap) Delete(key, node string, timestamp, writtenAt int64) bool {
+	return m.merge(LWWEntry{Key: key, Deleted: true, Timestamp: timestamp, Node: node, WrittenAt: writtenAt})
+}
+
+func (m *LWWMap) merge(in LWWEntry) bool {
+	cur := m.entries[in.Key]
+	changed, lost := lwwMerge(&cur, in)
+	m.entries[in.Key] = cur
+	if lost != nil {
+		m.lost = append(m.lost, *lost)
+	}
+	return changed
+}
+
+func (m *LWWMap) takeLost() []LWWEntry {
+	lost := m.lost
+	m.lost = nil
+	return lost
+}
+
+func (m *LWWMap) Type() string {
+	return "lww_map"
+}
+
+func (m *LWWMap) Snapshot() interface{} {
+	entries := make(map[string]LWWEntry, len(m.entries))
+	for k, v := range m.entries {
+		entries[k] = v
+	}
+	return entries
+}
+
+func (m *LWWMap) Merge(snapshot interface{}) bool {
+	other, ok := snapshot.(map[string]LWWEntry)
+	if !ok {
+		return false
+	}
+
+	// Apply in key order so lost updates are reported deterministically
+	keys := make([]string, 0, len(other))
+	for k := range other {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	changed := false
+	for _, k := range keys {
+		if m.merge(other[k]) {
+			changed = true
+		}
+	}
+	return changed
+}
+
+func (m *LWWMap) Value() interface{} {
+	values := make(map[string]string)
+	for k, e := range m.entries {
+		if !e.Deleted {
+			values[k] = e.Value
+		}
+	}
+	return values
+}
+
+func (m *LWWMap) State() map[string]interface{} {
+	tombstones := 0
+	for _, e := range m.entries {
+		if e.Deleted {
+			tombstones++
+		}
+	}
+	return map[string]interface{}{
+		"entries":    m.Snapshot(),
+		"tombstones": tombstones,
+	}
+}
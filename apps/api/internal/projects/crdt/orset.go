@@ -0,0 +1,152 @@
+package crdt
+
+import (
+	"fmt"
+	"sort"
+)
+
+// ORSet is an observed-remove set. Every add tags the element with a
+// unique tag; a remove tombstones only the tags the replica has observed.
+// An add concurrent with a remove carries a tag the remover never saw,
+// so the element survives the merge ("add wins").
+type ORSet struct {
+	replicaID string
+	nextTag   int
+
+	adds       map[string]map[string]bool // element -> tags
+	tombstones map[string]bool            // removed tags
+}
+
+// ORSetSnapshot is the gossiped state of an OR-Set
+type ORSetSnapshot struct {
+	Adds       map[string][]string `json:"adds"`
+	Tombstones []string            `json:"tombstones"`
+}
+
+// NewORSet creates an empty OR-Set replica
+func NewORSet(replicaID string) *ORSet {
+	return &ORSet{
+		replicaID:  replicaID,
+		adds:       make(map[string]map[string]bool),
+		tombstones: make(map[string]bool),
+	}
+}
+
+// Add inserts element under a fresh tag and returns the tag
+func (s *ORSet) Add(element string) string {
+	s.nextTag++
+	tag := fmt.Sprintf("%s:%d", s.replicaID, s.nextTag)
+	if s.adds[element] == nil {
+		s.adds[element] = make(map[string]bool)
+	}
+	s.adds[element][tag] = true
+	return tag
+}
+
+// Remove tombstones every live tag of element observed so far and
+// returns them; removing an absent element is a no-op
+func (s *ORSet) Remove(element string) []string {
+	removed := s.liveTags(element)
+	for _, tag := range removed {
+		s.tombstones[tag] = true
+	}
+	return removed
+}
+
+// Contains reports whether element has a live tag
+func (s *ORSet) Contains(element string) bool {
+	return len(s.liveTags(element)) > 0
+}
+
+// Elements returns the members of the set, sorted
+func (s *ORSet) Elements() []string {
+	elements := make([]string, 0, len(s.adds))
+	for element := range s.adds {
+		if s.Contains(element) {
+			elements = append(elements, element)
+		}
+	}
+	sort.Strings(elements)
+	return elements
+}
+
+// liveTags returns the sorted tags of element that are not tombstoned
+func (s *ORSet) liveTags(element string) []string {
+	tags := make([]string, 0, len(s.adds[element]))
+	for tag := range s.adds[element] {
+		if !s.tombstones[tag] {
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+func (s *ORSet) Type() string {
+	return "or_set"
+}
+
+func (s *ORSet) Snapshot() interface{} {
+	snapshot := ORSetSnapshot{
+		Adds:       make(map[string][]string, len(s.adds)),
+		Tombstones: make([]string, 0, len(s.tombstones)),
+	}
+	for element, tags := range s.adds {
+		list := make([]string, 0, len(tags))
+		for tag := range tags {
+			list = append(list, tag)
+		}
+		sort.Strings(list)
+		snapshot.Adds[element] = list
+	}
+	for tag := range s.tombstones {
+		snapshot.Tombstones = append(snapshot.Tombstones, tag)
+	}
+	sort.Strings(snapshot.Tombstones)
+	return snapshot
+}
+
+func (s *ORSet) Merge(snapshot interface{}) bool {
+	other, ok := snapshot.(ORSetSnapshot)
+	if !ok {
+		return false
+	}
+
+	changed := false
+	for element, tags := range other.Adds {
+		if s.adds[element] == nil {
+			s.adds[element] = make(map[string]bool)
+		}
+		for _, tag := range tags {
+			if !s.adds[element][tag] {
+				s.adds[element][tag] = true
+				changed = true
+			}
+		}
+	}
+	for _, tag := range other.Tombstones {
+		if !s.tombstones[tag] {
+			s.tombstones[tag] = true
+			changed = true
+		}
+	}
+	return changed
+}
+
+func (s *ORSet) Value() interface{} {
+	return s.Elements()
+}
+
+func (s *ORSet) State() map[string]interface{} {
+	tags := make(map[string][]string, len(s.adds))
+	for element := range s.adds {
+		if live := s.liveTags(element); len(live) > 0 {
+			tags[element] = live
+		}
+	}
+	return map[string]interface{}{
+		"elements":   s.Elements(),
+		"tags":       tags,
+		"tombstones": len(s.tombstones),
+	}
+}
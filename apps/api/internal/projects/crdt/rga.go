@@ -0,0 +1,220 @@
+package crdt
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// RGAID uniquely identifies a character: a Lamport counter plus the
+// replica that inserted it. Higher IDs sort first among siblings.
+type RGAID struct {
+	Counter int    `json:"counter"`
+	Node    string `json:"node"`
+}
+
+// after reports whether id orders before other among siblings
+func (id RGAID) after(other RGAID) bool {
+	if id.Counter != other.Counter {
+		return id.Counter > other.Counter
+	}
+	return id.Node > other.Node
+}
+
+func (id RGAID) String() string {
+	if id.Node == "" {
+		return "root"
+	}
+	return fmt.Sprintf("%s:%d", id.Node, id.Counter)
+}
+
+// RGAElement is one inserted character. It is anchored to the element
+// it was typed after, and deleting it only sets a tombstone so that
+// concurrent inserts anchored to it still have a place to go.
+type RGAElement struct {
+	ID      RGAID  `json:"id"`
+	After   RGAID  `json:"after"` // Zero value anchors at the start
+	Char    string `json:"char"`
+	Deleted bool   `json:"deleted,omitempty"`
+}
+
+// RGA is a Replicated Growable Array holding a text document. Elements
+// form a tree by their anchors; reading it depth-first with newer
+// siblings first gives the same document on every replica.
+type RGA struct {
+	replicaID string
+	counter   int
+
+	elements map[RGAID]*RGAElement
+	children map[RGAID][]RGAID // Anchor -> elements, newest first
+}
+
+// RGASnapshot is the gossiped state of an RGA, ordered by ID
+type RGASnapshot struct {
+	Elements []RGAElement `json:"elements"`
+}
+
+// NewRGA creates an empty document replica
+func NewRGA(replicaID string) *RGA {
+	return &RGA{
+		replicaID: replicaID,
+		elements:  make(map[RGAID]*RGAElement),
+		children:  make(map[RGAID][]RGAID),
+	}
+}
+
+// Insert types text at visible position pos (clamped to the document)
+// and returns the IDs of the new characters
+func (r *RGA) Insert(pos int, text string) []RGAID {
+	visible := r.visible()
+	if pos < 0 {
+		pos = 0
+	}
+	if pos > len(visible) {
+		pos = len(visible)
+	}
+
+	var anchor RGAID
+	if pos > 0 {
+		anchor = visible[pos-1].ID
+	}
+
+	ids := make([]RGAID, 0, len(text))
+	for _, ch := range text {
+		r.counter++
+		id := RGAID{Counter: r.counter, Node: r.replicaID}
+		r.add(RGAElement{ID: id, After: anchor, Char: string(ch)})
+		ids = append(ids, id)
+		anchor = id
+	}
+	return ids
+}
+
+// Delete tombstones count visible characters starting at pos and
+// returns their IDs
+func (r *RGA) Delete(pos, count int) ([]RGAID, error) {
+	visible := r.visible()
+	if pos < 0 || count < 1 || pos+count > len(visible) {
+		return nil, fmt.Errorf("cannot delete %d characters at %d from a document of length %d", count, pos, len(visible))
+	}
+
+	ids := make([]RGAID, 0, count)
+	for _, e := range visible[pos : pos+count] {
+		e.Deleted = true
+		ids = append(ids, e.ID)
+	}
+	return ids, nil
+}
+
+// Text returns the visible document
+func (r *RGA) Text() string {
+	var b strings.Builder
+	for _, e := range r.visible() {
+		b.WriteString(e.Char)
+	}
+	return b.String()
+}
+
+// add inserts an element into the tree, keeping siblings newest first
+func (r *RGA) add(e RGAElement) {
+	elem := e
+	r.elements[e.ID] = &elem
+
+	siblings := append(r.children[e.After], e.ID)
+	sort.Slice(siblings, func(i, j int) bool {
+		return siblings[i].after(siblings[j])
+	})
+	r.children[e.After] = siblings
+
+	if e.ID.Counter > r.counter {
+		r.counter = e.ID.Counter
+	}
+}
+
+// ordered returns all elements, tombstones included, in document order
+func (r *RGA) ordered() []*RGAElement {
+	out := make([]*RGAElement, 0, len(r.elements))
+	var walk func(anchor RGAID)
+	walk = func(anchor RGAID) {
+		for _, id := range r.children[anchor] {
+			out = append(out, r.elements[id])
+			walk(id)
+		}
+	}
+	walk(RGAID{})
+	return out
+}
+
+// visible returns the elements that are not tombstoned, in order
+func (r *RGA) visible() []*RGAElement {
+	visible := make([]*RGAElement, 0, len(r.elements))
+	for _, e := range r.ordered() {
+		if !e.Deleted {
+			visible = append(visible, e)
+		}
+	}
+	return visible
+}
+
+func (r *RGA) Type() string {
+	return "rga"
+}
+
+func (r *RGA) Snapshot() interface{} {
+	elements := make([]RGAElement, 0, len(r.elements))
+	for _, e := range r.elements {
+		elements = append(elements, *e)
+	}
+	sort.Slice(elements, func(i, j int) bool {
+		return elements[j].ID.after(elements[i].ID)
+	})
+	return RGASnapshot{Elements: elements}
+}
+
+func (r *RGA) Merge(snapshot interface{}) bool {
+	other, ok := snapshot.(RGASnapshot)
+	if !ok {
+		return false
+	}
+
+	// Snapshots are ordered by ID and an anchor always has a lower
+	// counter than its dependents, so anchors are added first
+	changed := false
+	for _, e := range other.Elements {
+		existing, ok := r.elements[e.ID]
+		if !ok {
+			r.add(e)
+			changed = true
+		} else if e.Deleted && !existing.Deleted {
+			existing.Deleted = true
+			changed = true
+		}
+	}
+	return changed
+}
+
+func (r *RGA) Value() interface{} {
+	return r.Text()
+}
+
+func (r *RGA) State() map[string]interface{} {
+	ordered := r.ordered()
+	elements := make([]map[string]interface{}, 0, len(ordered))
+	tombstones := 0
+	for _, e := range ordered {
+		if e.Deleted {
+			tombstones++
+		}
+		elements = append(elements, map[string]interface{}{
+			"id":      e.ID.String(),
+			"after":   e.After.String(),
+			"char":    e.Char,
+			"deleted": e.Deleted,
+		})
+	}
+	return map[string]interface{}{
+		"document":   r.Text(),
+		"elements":   elements,
+		"tombstones": tombstones,
+	}
+}
@@ -0,0 +1,889 @@
+package crdt
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/core/clock"
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+	"github.com/ersantana/distributed-systems-learning/packages/simulation/engine"
+)
+
+const (
+	MsgGossip transport.MessageType = "gossip"
+)
+
+// Simulation implements state-based CRDT replicas that accept local
+// updates and converge by gossiping their full state
+type Simulation struct {
+	mu sync.RWMutex
+
+	engine    *engine.Engine
+	rng       *rand.Rand
+	transport *transport.NetworkTransport
+	broadcast func(interface{})
+
+	nodes     []*ReplicaNode
+	nodeCount int
+	scenario  string
+	crdtType  string
+
+	gossipInterval int
+	opsPerNode     int
+	opsFrom        int // Ticks during which replicas issue local updates
+	opsUntil       int
+
+	// Scripted partition splitting the replicas into two halves
+	partitionAt int // <0 = never
+	healAt      int
+	partitioned bool
+	healed      bool
+
+	script []scriptedOp // Updates replayed at fixed ticks
+
+	expected int64 // Sum of all counter updates issued so far
+
+	skewedNode int           // Index of the node whose clock is off, <0 = none
+	skew       time.Duration // How far that clock is off
+	lost       []LWWEntry    // LWW writes overwritten by truly older ones
+	lostSeen   map[string]bool
+
+	running bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// update is one operation on a replica, scripted or requested by a user
+type update struct {
+	op      string
+	key     string // LWW-Map key
+	element string // Set element, LWW value or RGA text
+	pos     int    // RGA position
+	amount  uint64 // Counter delta or RGA characters to delete
+}
+
+// scriptedOp is an update a scenario applies on a node at a given tick
+type scriptedOp struct {
+	tick int
+	node int // Index into nodes
+	update
+}
+
+// ReplicaNode represents a node holding one CRDT replica
+type ReplicaNode struct {
+	mu sync.RWMutex
+
+	id      string
+	index   int
+	status  string
+	replica Replica
+	clock   *clock.PhysicalClock // Wall clock used for LWW timestamps
+	offset  int                  // Tick offset of gossip rounds
+	ticks   int
+
+	opsApplied int
+	merges     int
+	gossipSent int
+
+	inbox      chan *transport.Envelope
+	simulation *Simulation
+	nodeIDs    []string
+}
+
+// Config for CRDT simulation
+type Config struct {
+	NodeCount      int
+	Scenario       string
+	GossipInterval int // Ticks between gossip rounds of each replica
+	OpsPerNode     int // Local updates issued by each replica
+}
+
+// NewSimulation creates a new CRDT simulation
+func NewSimulation(eng *engine.Engine, trans *transport.NetworkTransport, broadcast func(interface{}), config Config) *Simulation {
+	if config.NodeCount == 0 {
+		config.NodeCount = 3
+	}
+	if config.GossipInterval == 0 {
+		config.GossipInterval = 5
+	}
+	if config.OpsPerNode == 0 {
+		config.OpsPerNode = 4
+	}
+
+	sim := &Simulation{
+		engine:         eng,
+		rng:            eng.Rand(),
+		transport:      trans,
+		broadcast:      broadcast,
+		nodeCount:      config.NodeCount,
+		scenario:       config.Scenario,
+		crdtType:       "g_counter",
+		gossipInterval: config.GossipInterval,
+		opsPerNode:     config.OpsPerNode,
+		opsFrom:        1,
+		opsUntil:       60,
+		partitionAt:    -1,
+		skewedNode:     -1,
+		lostSeen:       make(map[string]bool),
+	}
+
+	// Scenarios are "g_counter", "pn_counter", "partition_heal" where
+	// PN-Counter replicas keep updating on both sides of a partition,
+	// and "or_set"/"or_set_partition" which are driven by client requests
+	// on top of a short script. The LWW scenarios have "_skew" variants
+	// where the last node's clock runs behind. "rga_text" and
+	// "rga_partition" edit a shared text document concurrently.
+	last := config.NodeCount - 1
+	switch config.Scenario {
+	case "pn_counter":
+		sim.crdtType = "pn_counter"
+	case "partition_heal":
+		sim.crdtType = "pn_counter"
+		sim.partitionAt = 20
+		sim.healAt = 120
+		sim.opsFrom = 25
+		sim.opsUntil = 100
+	case "or_set":
+		sim.crdtType = "or_set"
+		sim.script = []scriptedOp{
+			{tick: 5, node: 0, update: update{op: "add", element: "apple"}},
+			{tick: 10, node: last, update: update{op: "add", element: "banana"}},
+			{tick: 40, node: last, update: update{op: "remove", element: "apple"}},
+		}
+	case "or_set_partition":
+		// The same element is removed on one side of the partition while
+		// it is re-added on the other; observed-remove keeps the new add
+		sim.crdtType = "or_set"
+		sim.partitionAt = 30
+		sim.healAt = 120
+		sim.script = []scriptedOp{
+			{tick: 5, node: 0, update: update{op: "add", element: "apple"}},
+			{tick: 10, node: last, update: update{op: "add", element: "banana"}},
+			{tick: 50, node: 0, update: update{op: "remove", element: "apple"}},
+			{tick: 55, node: last, update: update{op: "add", element: "apple"}},
+			{tick: 60, node: last, update: update{op: "remove", element: "banana"}},
+		}
+	case "lww_register", "lww_register_skew":
+		// The last write in true time is node-last's "blue"
+		sim.crdtType = "lww_register"
+		sim.script = []scriptedOp{
+			{tick: 10, node: 0, update: update{op: "set", element: "red"}},
+			{tick: 30, node: 1, update: update{op: "set", element: "green"}},
+			{tick: 60, node: last, update: update{op: "set", element: "blue"}},
+		}
+	case "lww_map", "lww_map_skew":
+		// node-last deletes "x" after everyone has seen x=2
+		sim.crdtType = "lww_map"
+		sim.script = []scriptedOp{
+			{tick: 10, node: 0, update: update{op: "set", key: "x", element: "1"}},
+			{tick: 20, node: last, update: update{op: "set", key: "y", element: "1"}},
+			{tick: 40, node: 1, update: update{op: "set", key: "x", element: "2"}},
+			{tick: 60, node: last, update: update{op: "delete", key: "x"}},
+		}
+	case "rga_text":
+		// Three replicas type at the same spot at the same time
+		sim.crdtType = "rga"
+		sim.script = []scriptedOp{
+			{tick: 5, node: 0, update: update{op: "insert", element: "hello"}},
+			{tick: 40, node: 0, update: update{op: "delete", pos: 0}},
+			{tick: 41, node: 0, update: update{op: "insert", element: "H"}},
+			{tick: 40, node: 1, update: update{op: "insert", pos: 5, element: " world"}},
+			{tick: 40, node: last, update: update{op: "insert", pos: 5, element: "!"}},
+		}
+	case "rga_partition":
+		// One side deletes a word the other side keeps editing around
+		sim.crdtType = "rga"
+		sim.partitionAt = 30
+		sim.healAt = 120
+		sim.script = []scriptedOp{
+			{tick: 5, node: 0, update: update{op: "insert", element: "hello world"}},
+			{tick: 50, node: 0, update: update{op: "insert", pos: 5, element: ","}},
+			{tick: 55, node: 0, update: update{op: "insert", pos: 12, element: "!"}},
+			{tick: 50, node: last, update: update{op: "delete", pos: 0, amount: 6}},
+			{tick: 55, node: last, update: update{op: "insert", pos: 0, element: "goodbye "}},
+		}
+	}
+	if strings.HasSuffix(config.Scenario, "_skew") {
+		// 5s behind: node-last's writes are stamped before ones it has
+		// already seen, so they silently lose
+		sim.skewedNode = last
+		sim.skew = -5 * time.Second
+	}
+
+	// Gossip tolerates latency and loss; convergence only needs
+	// eventual delivery
+	trans.SetLatency(50*time.Millisecond, 250*time.Millisecond)
+	trans.SetPacketLoss(0)
+
+	nodeIDs := make([]string, config.NodeCount)
+	for i := 0; i < config.NodeCount; i++ {
+		nodeIDs[i] = fmt.Sprintf("node-%d", i+1)
+	}
+
+	sim.nodes = make([]*ReplicaNode, config.NodeCount)
+	for i := 0; i < config.NodeCount; i++ {
+		node := sim.newReplicaNode(nodeIDs[i], nodeIDs)
+		node.index = i
+		node.offset = i % sim.gossipInterval
+		if i == sim.skewedNode {
+			node.clock.SetSkew(sim.skew)
+		}
+		sim.nodes[i] = node
+		trans.RegisterHandler(nodeIDs[i], node.handleMessage)
+		eng.AddNode(node)
+	}
+
+	return sim
+}
+
+func (s *Simulation) newReplicaNode(id string, nodeIDs []string) *ReplicaNode {
+	return &ReplicaNode{
+		id:         id,
+		status:     "running",
+		replica:    s.newReplica(id),
+		clock:      clock.NewPhysicalClock(s.engine.GetVirtualTime),
+		inbox:      make(chan *transport.Envelope, 100),
+		simulation: s,
+		nodeIDs:    nodeIDs,
+	}
+}
+
+// newReplica creates an empty replica of the simulated CRDT
+func (s *Simulation) newReplica(id string) Replica {
+	switch s.crdtType {
+	case "pn_counter":
+		return NewPNCounter()
+	case "or_set":
+		return NewORSet(id)
+	case "lww_register":
+		return NewLWWRegister()
+	case "lww_map":
+		return NewLWWMap()
+	case "rga":
+		return NewRGA(id)
+	default:
+		return NewGCounter()
+	}
+}
+
+// Start starts the simulation
+func (s *Simulation) Start(ctx context.Context) error {
+	s.mu.Lock()
+	s.running = true
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.mu.Unlock()
+
+	return s.engine.Start(ctx)
+}
+
+// Stop stops the simulation
+func (s *Simulation) Stop() error {
+	s.mu.Lock()
+	s.running = false
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Unlock()
+
+	return s.engine.Stop()
+}
+
+// GetState returns the current simulation state
+func (s *Simulation) GetState() *protocol.SimulationStateResponse {
+	// Node locks are taken without holding s.mu: nodes lock the
+	// simulation while ticking, so the reverse order would deadlock.
+	s.mu.RLock()
+	running := s.running
+	expected := s.expected
+	partitioned := s.partitioned
+	lost := append([]LWWEntry{}, s.lost...)
+	s.mu.RUnlock()
+
+	nodes := make(map[string]protocol.NodeState)
+	values := make(map[string]interface{})
+	snapshots := make([]interface{}, 0, len(s.nodes))
+	for i, node := range s.nodes {
+		nodeState := node.GetState()
+		values[node.id] = nodeState["value"]
+		if nodeState["status"] == "running" {
+			snapshots = append(snapshots, nodeState["snapshot"])
+		}
+
+		customState := map[string]interface{}{
+			"crdt":       s.crdtType,
+			"value":      nodeState["value"],
+			"opsApplied": nodeState["opsApplied"],
+			"merges":     nodeState["merges"],
+			"gossipSent": nodeState["gossipSent"],
+			"skewMs":     nodeState["skewMs"],
+		}
+		for k, v := range nodeState["state"].(map[string]interface{}) {
+			customState[k] = v
+		}
+		if partitioned {
+			customState["group"] = s.group(i)
+		}
+
+		nodes[node.id] = protocol.NodeState{
+			ID:          node.id,
+			Status:      nodeState["status"].(string),
+			Role:        "replica",
+			CustomState: customState,
+		}
+	}
+
+	mode := "step"
+	if s.engine != nil {
+		mode = s.engine.GetMode().String()
+	}
+
+	metadata := map[string]interface{}{
+		"crdt":        s.crdtType,
+		"values":      values,
+		"converged":   converged(snapshots),
+		"partitioned": partitioned,
+	}
+	switch s.crdtType {
+	case "g_counter", "pn_counter":
+		metadata["expectedValue"] = expected
+	case "lww_register", "lww_map":
+		metadata["lostUpdates"] = lost
+	}
+
+	return &protocol.SimulationStateResponse{
+		Type:        protocol.MsgSimulationState,
+		VirtualTime: time.Now().UnixMilli(),
+		Mode:        mode,
+		Speed:       1.0,
+		Running:     running,
+		Nodes:       nodes,
+		Metadata:    metadata,
+	}
+}
+
+// converged reports whether all live replicas hold identical state
+func converged(snapshots []interface{}) bool {
+	for i := 1; i < len(snapshots); i++ {
+		if !reflect.DeepEqual(snapshots[0], snapshots[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// GetNodes returns node states
+func (s *Simulation) GetNodes() map[string]protocol.NodeState {
+	state := s.GetState()
+	return state.Nodes
+}
+
+// Layout places the nodes on a ring; every replica gossips with every other one
+func (s *Simulation) Layout() *protocol.Layout {
+	order := make([]string, len(s.nodes))
+	for i, node := range s.nodes {
+		order[i] = node.id
+	}
+	return &protocol.Layout{Kind: protocol.LayoutRing, Order: order}
+}
+
+// CrashNode crashes a node
+func (s *Simulation) CrashNode(nodeID string) error {
+	node := s.findNode(nodeID)
+	if node == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	node.mu.Lock()
+	node.status = "crashed"
+	node.mu.Unlock()
+	return nil
+}
+
+// RecoverNode recovers a crashed node; its replica state survives the crash
+func (s *Simulation) RecoverNode(nodeID string) error {
+	node := s.findNode(nodeID)
+	if node == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	node.mu.Lock()
+	node.status = "running"
+	node.mu.Unlock()
+	return nil
+}
+
+// HandleClientRequest applies an update requested by a user on one node.
+// Commands are "increment"/"decrement" (payload: nodeId, amount) for
+// counters, "add"/"remove" (payload: nodeId, element) for sets and
+// "set"/"delete" (payload: nodeId, key, value) for LWW types. "skew"
+// (payload: nodeId, skewMs) moves a node's wall clock.
+func (s *Simulation) HandleClientRequest(command string, payload map[string]interface{}) error {
+	nodeID, _ := payload["nodeId"].(string)
+	node := s.findNode(nodeID)
+	if node == nil {
+		return fmt.Errorf("unknown node: %q", nodeID)
+	}
+
+	if command == "skew" {
+		ms, _ := payload["skewMs"].(float64)
+		node.clock.SetSkew(time.Duration(ms) * time.Millisecond)
+		s.broadcast(map[string]interface{}{
+			"type":   "clock_skew",
+			"nodeId": nodeID,
+			"skewMs": int64(ms),
+		})
+		return nil
+	}
+
+	u := update{op: command, amount: 1}
+	u.key, _ = payload["key"].(string)
+	u.element, _ = payload["element"].(string)
+	if value, ok := payload["value"].(string); ok {
+		u.element = value
+	}
+	if text, ok := payload["text"].(string); ok {
+		u.element = text
+	}
+	if v, ok := payload["pos"].(float64); ok {
+		u.pos = int(v)
+	}
+	if v, ok := payload["amount"].(float64); ok && v >= 1 {
+		u.amount = uint64(v)
+	}
+
+	node.mu.Lock()
+	defer node.mu.Unlock()
+
+	if node.status != "running" {
+		return fmt.Errorf("node %s is crashed", nodeID)
+	}
+	return node.apply(u)
+}
+
+// NodeActions lists the actions of a replica: "force_sync" gossips its
+// state right away, to the peer in params "to" or else to every peer
+func (s *Simulation) NodeActions(nodeID string) []string {
+	node := s.findNode(nodeID)
+	if node == nil {
+		return nil
+	}
+	node.mu.RLock()
+	defer node.mu.RUnlock()
+	if node.status != "running" {
+		return nil
+	}
+	return []string{"force_sync"}
+}
+
+// InvokeNodeAction invokes an action listed by NodeActions
+func (s *Simulation) InvokeNodeAction(nodeID, action string, params map[string]interface{}) error {
+	node := s.findNode(nodeID)
+	if node == nil {
+		return fmt.Errorf("unknown node: %q", nodeID)
+	}
+	if action != "force_sync" {
+		return fmt.Errorf("unknown action: %s", action)
+	}
+	to, _ := params["to"].(string)
+	if to != "" && (to == nodeID || s.findNode(to) == nil) {
+		return fmt.Errorf("cannot sync %s with %q", nodeID, to)
+	}
+
+	node.mu.Lock()
+	defer node.mu.Unlock()
+
+	if node.status != "running" {
+		return fmt.Errorf("node %s is crashed", nodeID)
+	}
+	if to != "" {
+		node.gossipTo(to)
+		return nil
+	}
+	for _, peer := range node.nodeIDs {
+		if peer != nodeID {
+			node.gossipTo(peer)
+		}
+	}
+	return nil
+}
+
+// findNode looks up a node by ID; the node list is fixed after construction
+func (s *Simulation) findNode(nodeID string) *ReplicaNode {
+	for _, node := range s.nodes {
+		if node.id == nodeID {
+			return node
+		}
+	}
+	return nil
+}
+
+// counting reports whether the simulated CRDT is a counter
+func (s *Simulation) counting() bool {
+	return s.crdtType == "g_counter" || s.crdtType == "pn_counter"
+}
+
+// group names the side of the scripted partition node i is on
+func (s *Simulation) group(i int) string {
+	if i < s.nodeCount/2 {
+		return "A"
+	}
+	return "B"
+}
+
+// advanceSchedule creates and heals the scripted partition; any node's
+// tick count drives it so a crashed node cannot stall the scenario
+func (s *Simulation) advanceSchedule(ticks int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.partitionAt < 0 || s.healed {
+		return
+	}
+
+	half := s.nodeCount / 2
+	if !s.partitioned && ticks >= s.partitionAt {
+		s.partitioned = true
+		for _, a := range s.nodes[:half] {
+			for _, b := range s.nodes[half:] {
+				s.transport.CreateBidirectionalPartition(a.id, b.id)
+			}
+		}
+		s.broadcast(map[string]interface{}{
+			"type":   "partition_created",
+			"groups": s.groups(),
+		})
+	} else if s.partitioned && ticks >= s.healAt {
+		s.partitioned = false
+		s.healed = true
+		for _, a := range s.nodes[:half] {
+			for _, b := range s.nodes[half:] {
+				s.transport.ClearBidirectionalPartition(a.id, b.id)
+			}
+		}
+		s.broadcast(map[string]interface{}{
+			"type":   "partition_healed",
+			"groups": s.groups(),
+		})
+	}
+}
+
+func (s *Simulation) groups() map[string][]string {
+	groups := make(map[string][]string)
+	for i, node := range s.nodes {
+		groups[s.group(i)] = append(groups[s.group(i)], node.id)
+	}
+	return groups
+}
+
+// ReplicaNode implements engine.NodeController
+
+func (n *ReplicaNode) ID() string {
+	return n.id
+}
+
+func (n *ReplicaNode) Start(ctx context.Context) error {
+	return nil
+}
+
+func (n *ReplicaNode) Stop() error {
+	return nil
+}
+
+func (n *ReplicaNode) Tick() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.status != "running" {
+		return
+	}
+
+	sim := n.simulation
+	n.ticks++
+	sim.advanceSchedule(n.ticks)
+
+	// Process any pending messages
+	select {
+	case env := <-n.inbox:
+		n.processMessage(env)
+	default:
+	}
+
+	// Replicas accept local updates without coordinating
+	for _, op := range sim.script {
+		if op.node == n.index && op.tick == n.ticks {
+			u := op.update
+			if u.amount == 0 {
+				u.amount = 1
+			}
+			n.apply(u)
+		}
+	}
+	if sim.counting() && n.opsApplied < sim.opsPerNode && n.ticks >= sim.opsFrom && n.ticks < sim.opsUntil && n.simulation.rng.Float64() < 0.15 {
+		n.localUpdate()
+	}
+
+	if n.ticks%sim.gossipInterval == n.offset {
+		n.gossip()
+	}
+}
+
+func (n *ReplicaNode) GetState() map[string]interface{} {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	return map[string]interface{}{
+		"id":         n.id,
+		"status":     n.status,
+		"value":      n.replica.Value(),
+		"state":      n.replica.State(),
+		"snapshot":   n.replica.Snapshot(),
+		"opsApplied": n.opsApplied,
+		"merges":     n.merges,
+		"gossipSent": n.gossipSent,
+		"skewMs":     n.clock.Skew().Milliseconds(),
+	}
+}
+
+func (n *ReplicaNode) handleMessage(env *transport.Envelope) {
+	n.mu.RLock()
+	crashed := n.status != "running"
+	n.mu.RUnlock()
+
+	// Crashed replicas do not receive anything
+	if crashed {
+		return
+	}
+	select {
+	case n.inbox <- env:
+	default:
+	}
+}
+
+// localUpdate applies a random update to the local counter
+func (n *ReplicaNode) localUpdate() {
+	op := "increment"
+	if _, ok := n.replica.(*PNCounter); ok && n.simulation.rng.Float64() < 0.4 {
+		op = "decrement"
+	}
+	n.apply(update{op: op, amount: uint64(n.simulation.rng.Intn(3) + 1)})
+}
+
+// apply performs an update on the local replica (must hold n.mu)
+func (n *ReplicaNode) apply(u update) error {
+	sim := n.simulation
+	op, element, amount := u.op, u.element, u.amount
+	event := map[string]interface{}{
+		"type":   "crdt_update",
+		"nodeId": n.id,
+		"op":     op,
+	}
+
+	var delta int64
+	switch r := n.replica.(type) {
+	case *GCounter:
+		if op != "increment" {
+			return fmt.Errorf("g_counter does not support %q", op)
+		}
+		r.Increment(n.id, amount)
+		delta = int64(amount)
+		event["amount"] = amount
+	case *PNCounter:
+		switch op {
+		case "increment":
+			r.Increment(n.id, amount)
+			delta = int64(amount)
+		case "decrement":
+			r.Decrement(n.id, amount)
+			delta = -int64(amount)
+		default:
+			return fmt.Errorf("pn_counter does not support %q", op)
+		}
+		event["amount"] = amount
+	case *ORSet:
+		if op != "add" && op != "remove" {
+			return fmt.Errorf("or_set does not support %q", op)
+		}
+		if element == "" {
+			return fmt.Errorf("%s requires an element", op)
+		}
+		switch op {
+		case "add":
+			event["tag"] = r.Add(element)
+		case "remove":
+			event["removedTags"] = r.Remove(element)
+		}
+		event["element"] = element
+	case *LWWRegister, *LWWMap:
+		if err := n.applyLWW(op, u.key, element, event); err != nil {
+			return err
+		}
+	case *RGA:
+		switch op {
+		case "insert":
+			if element == "" {
+				return fmt.Errorf("insert requires text")
+			}
+			event["ids"] = r.Insert(u.pos, element)
+			event["text"] = element
+		case "delete":
+			ids, err := r.Delete(u.pos, int(amount))
+			if err != nil {
+				return err
+			}
+			event["ids"] = ids
+			event["count"] = amount
+		default:
+			return fmt.Errorf("rga does not support %q", op)
+		}
+		event["pos"] = u.pos
+	}
+	n.opsApplied++
+
+	if delta != 0 {
+		sim.mu.Lock()
+		sim.expected += delta
+		sim.mu.Unlock()
+	}
+
+	event["value"] = n.replica.Value()
+	sim.broadcast(event)
+	n.reportLost()
+	return nil
+}
+
+// applyLWW stamps a write with the node's own clock and applies it
+func (n *ReplicaNode) applyLWW(op, key, element string, event map[string]interface{}) error {
+	timestamp := n.clock.Now().UnixMilli()
+	writtenAt := n.simulation.engine.GetVirtualTime().UnixMilli()
+
+	var applied bool
+	switch r := n.replica.(type) {
+	case *LWWRegister:
+		if op != "set" {
+			return fmt.Errorf("lww_register does not support %q", op)
+		}
+		applied = r.Set(element, n.id, timestamp, writtenAt)
+	case *LWWMap:
+		if op != "set" && op != "delete" {
+			return fmt.Errorf("lww_map does not support %q", op)
+		}
+		if key == "" {
+			return fmt.Errorf("%s requires a key", op)
+		}
+		if op == "set" {
+			applied = r.Set(key, element, n.id, timestamp, writtenAt)
+		} else {
+			applied = r.Delete(key, n.id, timestamp, writtenAt)
+		}
+		event["key"] = key
+	}
+
+	event["element"] = element
+	event["timestamp"] = timestamp
+	event["applied"] = applied
+	return nil
+}
+
+// reportLost announces LWW writes this replica discarded even though
+// they happened after the write that beat them (must hold n.mu)
+func (n *ReplicaNode) reportLost() {
+	reporter, ok := n.replica.(lossReporter)
+	if !ok {
+		return
+	}
+	sim := n.simulation
+
+	for _, entry := range reporter.takeLost() {
+		id := fmt.Sprintf("%s/%s/%d", entry.Node, entry.Key, entry.WrittenAt)
+		sim.mu.Lock()
+		seen := sim.lostSeen[id]
+		if !seen {
+			sim.lostSeen[id] = true
+			sim.lost = append(sim.lost, entry)
+		}
+		sim.mu.Unlock()
+		if seen {
+			continue
+		}
+
+		sim.broadcast(map[string]interface{}{
+			"type":   "lww_lost_update",
+			"nodeId": n.id,
+			"write":  entry,
+			"value":  n.replica.Value(),
+		})
+	}
+}
+
+// gossip sends the full replica state to a random peer
+func (n *ReplicaNode) gossip() {
+	var targetID string
+	for {
+		targetID = n.nodeIDs[n.simulation.rng.Intn(len(n.nodeIDs))]
+		if targetID != n.id || len(n.nodeIDs) == 1 {
+			break
+		}
+	}
+	if targetID == n.id {
+		return
+	}
+	n.gossipTo(targetID)
+}
+
+// gossipTo sends the full replica state to one peer
+func (n *ReplicaNode) gossipTo(targetID string) {
+	sim := n.simulation
+
+	env := transport.NewEnvelope(n.id, targetID, MsgGossip, n.replica.Snapshot())
+	n.gossipSent++
+
+	sim.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageSent,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+
+	sim.transport.Send(sim.ctx, env)
+}
+
+func (n *ReplicaNode) processMessage(env *transport.Envelope) {
+	sim := n.simulation
+
+	sim.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageReceived,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+
+	if env.Type != MsgGossip {
+		return
+	}
+
+	before := n.replica.Value()
+	if !n.replica.Merge(env.Payload) {
+		// A losing write changes nothing but may still be a lost update
+		n.reportLost()
+		return
+	}
+	n.merges++
+
+	sim.broadcast(map[string]interface{}{
+		"type":   "crdt_merge",
+		"nodeId": n.id,
+		"from":   env.From,
+		"before": before,
+		"value":  n.replica.Value(),
+		"state":  n.replica.State(),
+	})
+	n.reportLost()
+}
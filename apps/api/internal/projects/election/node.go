@@ -0,0 +1,302 @@
+package election
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+const (
+	MsgElection    transport.MessageType = "election"
+	MsgAnswer      transport.MessageType = "answer"
+	MsgCoordinator transport.MessageType = "coordinator"
+	MsgHeartbeat   transport.MessageType = "heartbeat"
+)
+
+const (
+	heartbeatTicks  = 4  // Ticks between heartbeats of the leader
+	leaderTimeout   = 12 // Ticks without a heartbeat before suspecting the leader, plus jitter
+	answerTimeout   = 6  // Ticks to wait for an answer from a higher node
+	announceTimeout = 15 // Ticks to wait for the coordinator after an answer
+	suspicionJitter = 8
+)
+
+// Node is a participant of the Bully algorithm. Its rank is its position
+// in the node list: the highest live node wins every election.
+type Node struct {
+	mu sync.RWMutex
+
+	id      string
+	rank    int
+	status  string
+	ticks   int
+	nodeIDs []string
+
+	state     string // "follower", "electing", "waiting", "leader"
+	leader    string
+	term      int
+	since     int // Tick the current state was entered
+	lastHeard int // Tick the leader was last heard from
+	patience  int // Ticks without the leader before starting an election
+	elections int // Elections started by this node
+
+	// Ring election
+	pending   map[int]pendingMessage // Ring messages not yet acknowledged, by ID
+	nextMsgID int
+	dead      map[string]bool // Successors that did not acknowledge
+
+	inbox      chan *transport.Envelope
+	simulation *Simulation
+}
+
+func newNode(rank int, nodeIDs []string, sim *Simulation) *Node {
+	n := &Node{
+		id:         nodeIDs[rank],
+		rank:       rank,
+		status:     "running",
+		nodeIDs:    nodeIDs,
+		state:      "follower",
+		pending:    make(map[int]pendingMessage),
+		dead:       make(map[string]bool),
+		inbox:      make(chan *transport.Envelope, 500),
+		simulation: sim,
+	}
+	n.patience = n.suspicionTime()
+	return n
+}
+
+// Node implements engine.NodeController
+
+func (n *Node) ID() string {
+	return n.id
+}
+
+func (n *Node) Start(ctx context.Context) error {
+	return nil
+}
+
+func (n *Node) Stop() error {
+	return nil
+}
+
+func (n *Node) Tick() {
+	n.simulation.advanceSchedule(n.tick())
+}
+
+func (n *Node) tick() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.ticks++
+	if n.status != "running" {
+		return n.ticks
+	}
+
+	for i := 0; i < 50; i++ {
+		select {
+		case env := <-n.inbox:
+			n.processMessage(env)
+			continue
+		default:
+		}
+		break
+	}
+
+	if n.simulation.ring {
+		n.ringTick()
+	}
+
+	switch n.state {
+	case "follower":
+		if n.ticks-n.lastHeard > n.patience {
+			n.simulation.broadcast(map[string]interface{}{
+				"type":   "leader_suspected",
+				"nodeId": n.id,
+				"leader": n.leader,
+			})
+			n.startElection()
+		}
+
+	case "electing":
+		if n.simulation.ring {
+			// The election got lost on its way around the ring
+			if n.ticks-n.since >= n.ringTimeout() {
+				n.startElection()
+			}
+		} else if n.ticks-n.since >= answerTimeout {
+			// Nobody above answered: they are all down
+			n.becomeLeader()
+		}
+
+	case "waiting":
+		// A higher node answered but never announced itself; it crashed
+		// in the middle of the election
+		if n.ticks-n.since >= announceTimeout {
+			n.startElection()
+		}
+
+	case "leader":
+		if (n.ticks-n.since)%heartbeatTicks == 0 {
+			for _, peer := range n.nodeIDs {
+				if peer != n.id {
+					n.simulation.send(n.id, peer, MsgHeartbeat, Payload{Term: n.term})
+				}
+			}
+		}
+	}
+	return n.ticks
+}
+
+func (n *Node) GetState() map[string]interface{} {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	return map[string]interface{}{
+		"id":        n.id,
+		"status":    n.status,
+		"rank":      n.rank,
+		"state":     n.state,
+		"leader":    n.leader,
+		"term":      n.term,
+		"elections": n.elections,
+	}
+}
+
+func (n *Node) handleMessage(env *transport.Envelope) {
+	n.mu.RLock()
+	crashed := n.status != "running"
+	n.mu.RUnlock()
+
+	// Crashed nodes do not receive anything
+	if crashed {
+		return
+	}
+	select {
+	case n.inbox <- env:
+	default:
+	}
+}
+
+func (n *Node) processMessage(env *transport.Envelope) {
+	payload := n.simulation.received(env)
+	if n.simulation.ring && n.processRingMessage(env, payload) {
+		return
+	}
+
+	switch env.Type {
+	case MsgElection:
+		// A lower node is holding an election: bully it out and take
+		// over, unless we already lead and only need to say so again
+		n.simulation.send(n.id, env.From, MsgAnswer, Payload{})
+		switch n.state {
+		case "leader":
+			n.simulation.send(n.id, env.From, MsgCoordinator, Payload{Term: n.term})
+		case "follower":
+			n.startElection()
+		}
+
+	case MsgAnswer:
+		if n.state == "electing" {
+			n.setState("waiting")
+		}
+
+	case MsgCoordinator:
+		if n.rankOf(env.From) < n.rank {
+			// A lower node claims to lead while we are alive
+			if n.state != "electing" && n.state != "waiting" {
+				n.startElection()
+			}
+			return
+		}
+		n.follow(env.From, payload.Term)
+
+	case MsgHeartbeat:
+		if env.From == n.leader {
+			n.lastHeard = n.ticks
+		} else if n.rankOf(env.From) > n.rank && payload.Term > n.term {
+			// Missed the announcement; the heartbeat tells us just as well
+			n.follow(env.From, payload.Term)
+		}
+	}
+}
+
+// startElection sends an election message to every higher node, or wins
+// at once if there is none; on the ring it sends our ID to the successor
+// (must hold n.mu)
+func (n *Node) startElection() {
+	n.setState("electing")
+	n.elections++
+	n.simulation.electionStarted(n.id)
+
+	if n.simulation.ring {
+		n.ringSend(MsgElection, Payload{Candidate: n.id})
+		return
+	}
+
+	higher := n.nodeIDs[n.rank+1:]
+	if len(higher) == 0 {
+		n.becomeLeader()
+		return
+	}
+	for _, peer := range higher {
+		n.simulation.send(n.id, peer, MsgElection, Payload{})
+	}
+	n.simulation.electing(n)
+}
+
+// becomeLeader announces this node as coordinator to every lower node
+// (must hold n.mu)
+func (n *Node) becomeLeader() {
+	n.setState("leader")
+	n.leader = n.id
+	n.term = n.simulation.elected(n.id)
+
+	for _, peer := range n.nodeIDs[:n.rank] {
+		n.simulation.send(n.id, peer, MsgCoordinator, Payload{Term: n.term})
+	}
+}
+
+// follow accepts a coordinator (must hold n.mu)
+func (n *Node) follow(leader string, term int) {
+	n.setState("follower")
+	n.leader = leader
+	if term > n.term {
+		n.term = term
+	}
+	n.lastHeard = n.ticks
+	n.patience = n.suspicionTime()
+}
+
+// setState moves to an election state and broadcasts the change (must
+// hold n.mu)
+func (n *Node) setState(state string) {
+	if n.state == state {
+		n.since = n.ticks
+		return
+	}
+	n.simulation.broadcast(map[string]interface{}{
+		"type":   "election_state",
+		"nodeId": n.id,
+		"from":   n.state,
+		"to":     state,
+	})
+	n.state = state
+	n.since = n.ticks
+}
+
+// rankOf returns the rank of a node
+func (n *Node) rankOf(id string) int {
+	for i, peer := range n.nodeIDs {
+		if peer == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// suspicionTime returns the ticks without the leader before starting an
+// election; the jitter keeps all nodes from starting one at once
+func (n *Node) suspicionTime() int {
+	return leaderTimeout + n.simulation.rng.Intn(suspicionJitter)
+}
@@ -0,0 +1,149 @@
+package election
+
+import (
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+// Chang-Roberts ring election: a node that suspects the leader sends its
+// own ID to its successor. A node forwards a higher ID as it is, and
+// replaces a lower one with its own unless it already takes part in an
+// election, in which case the lower ID is swallowed. The ID that makes it
+// all the way around belongs to the highest node, which then sends an
+// "elected" message around the ring.
+//
+// Messages between neighbours are acknowledged: a successor that does
+// not acknowledge is taken for crashed and skipped, until it is heard
+// from again. Acks are counted with the rest, so the comparison with
+// Bully is fair.
+const (
+	MsgElected transport.MessageType = "elected"
+	MsgAck     transport.MessageType = "ack"
+)
+
+const ackTimeout = 4 // Ticks before skipping a successor that did not acknowledge
+
+// pendingMessage is a ring message awaiting its acknowledgement
+type pendingMessage struct {
+	to      string
+	msgType transport.MessageType
+	payload Payload
+	sentAt  int
+}
+
+// ringTick resends the ring messages that were not acknowledged to the
+// node after the silent successor (must hold n.mu)
+func (n *Node) ringTick() {
+	for id, msg := range n.pending {
+		if n.ticks-msg.sentAt < ackTimeout {
+			continue
+		}
+		delete(n.pending, id)
+		n.dead[msg.to] = true
+		n.simulation.broadcast(map[string]interface{}{
+			"type":   "successor_skipped",
+			"nodeId": n.id,
+			"skip":   msg.to,
+		})
+		n.ringSend(msg.msgType, msg.payload)
+	}
+}
+
+// processRingMessage handles the messages of the ring election and
+// reports whether the message was one (must hold n.mu)
+func (n *Node) processRingMessage(env *transport.Envelope, payload Payload) bool {
+	// Whoever sends anything is alive, and so is a candidate, which may
+	// be a recovered successor we skip
+	delete(n.dead, env.From)
+	delete(n.dead, payload.Candidate)
+
+	switch env.Type {
+	case MsgAck:
+		delete(n.pending, payload.ID)
+		return true
+
+	case MsgElection:
+		n.simulation.send(n.id, env.From, MsgAck, Payload{ID: payload.ID})
+		candidate := payload.Candidate
+		switch {
+		case candidate == n.id:
+			// Our ID came all the way round: nobody alive is higher
+			n.ringElected()
+		case n.rankOf(candidate) > n.rank:
+			n.setState("electing")
+			n.ringSend(MsgElection, Payload{Candidate: candidate})
+		case n.state == "leader":
+			// A lower node missed us; tell the ring again
+			n.ringSend(MsgElected, Payload{Candidate: n.id, Term: n.term})
+		case n.state != "electing":
+			n.setState("electing")
+			n.ringSend(MsgElection, Payload{Candidate: n.id})
+		default:
+			// Our own, higher ID is already on its way
+			n.simulation.broadcast(map[string]interface{}{
+				"type":      "candidate_swallowed",
+				"nodeId":    n.id,
+				"candidate": candidate,
+			})
+		}
+		return true
+
+	case MsgElected:
+		n.simulation.send(n.id, env.From, MsgAck, Payload{ID: payload.ID})
+		if payload.Candidate != n.id {
+			n.follow(payload.Candidate, payload.Term)
+			n.ringSend(MsgElected, Payload{Candidate: payload.Candidate, Term: payload.Term})
+		}
+		return true
+	}
+	return false
+}
+
+// ringElected makes this node the leader and sends the news around the
+// ring (must hold n.mu)
+func (n *Node) ringElected() {
+	n.setState("leader")
+	n.leader = n.id
+	n.term = n.simulation.elected(n.id)
+	n.ringSend(MsgElected, Payload{Candidate: n.id, Term: n.term})
+}
+
+// ringSend sends a message to the first successor not known to be down
+// and waits for its acknowledgement (must hold n.mu)
+func (n *Node) ringSend(msgType transport.MessageType, payload Payload) {
+	to := n.successor()
+	if to == "" {
+		// Everyone else is down: the message would only come back
+		if msgType == MsgElection {
+			n.ringElected()
+		}
+		return
+	}
+
+	n.nextMsgID++
+	payload.ID = n.nextMsgID
+	n.pending[payload.ID] = pendingMessage{to: to, msgType: msgType, payload: payload, sentAt: n.ticks}
+	n.simulation.send(n.id, to, msgType, payload)
+}
+
+// successor returns the next node on the ring not known to be down, or ""
+// if there is none
+func (n *Node) successor() string {
+	ring := n.simulation.ringOrder
+	for i, id := range ring {
+		if id != n.id {
+			continue
+		}
+		for j := 1; j < len(ring); j++ {
+			if next := ring[(i+j)%len(ring)]; !n.dead[next] {
+				return next
+			}
+		}
+	}
+	return ""
+}
+
+// ringTimeout returns the ticks an election may take to go around the
+// ring twice, with every successor skipped once
+func (n *Node) ringTimeout() int {
+	return 2*len(n.nodeIDs)*(ackTimeout+1) + 10
+}
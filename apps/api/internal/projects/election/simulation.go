@@ -0,0 +1,405 @@
+package election
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+	"github.com/ersantana/distributed-systems-learning/packages/simulation/engine"
+	"github.com/ersantana/distributed-systems-learning/packages/visualization/events"
+)
+
+const (
+	crashLeaderAt = 40  // Tick the leader crashes in "leader_crash" and "cascading_crash"
+	recoverAfter  = 100 // Ticks the old leader stays down in "leader_crash"
+)
+
+// Payload is the content of all messages exchanged in this project
+type Payload struct {
+	Term int `json:"term,omitempty"` // Coordinator, elected and heartbeat: the leader's term
+
+	// Ring election
+	ID        int    `json:"id,omitempty"`        // Matches an ack to its message
+	Candidate string `json:"candidate,omitempty"` // Highest ID seen, or the leader once elected
+}
+
+// Simulation runs the Bully leader election algorithm. A node that
+// suspects the leader sends an election message to every higher node; any
+// of them that is alive answers and holds its own election, so elections
+// cascade upwards until the highest live node hears no answer, declares
+// itself coordinator and tells everyone below. A recovered node holds an
+// election too, and bullies its way back to leadership if it outranks the
+// current leader.
+//
+// The "ring_" scenarios run the Chang-Roberts ring election instead, so
+// that the messages an election costs can be compared. Bully needs O(n²)
+// messages when the lowest node starts, Chang-Roberts O(n log n) on
+// average and O(n²) when the IDs decrease along the ring, as in
+// "ring_worst_case".
+//
+// Neither algorithm has terms: the simulation numbers the leaders it
+// elects so that every announcement can be told apart.
+type Simulation struct {
+	mu sync.RWMutex
+
+	engine    *engine.Engine
+	rng       *rand.Rand
+	transport *transport.NetworkTransport
+	broadcast func(interface{})
+
+	nodes     []*Node
+	scenario  string
+	ring      bool
+	ringOrder []string // Successor of each node is the next one
+
+	leader    string // Last leader announced
+	term      int
+	elections int
+	leaders   int            // Leaders elected during the run
+	messages  map[string]int // Messages sent by type
+
+	crashAt      int    // Tick the leader is crashed at, 0 when done
+	crashed      string // Leader crashed by the schedule
+	recoverAt    int
+	crashOnElect string // "cascading_crash": crashes once it starts an election
+
+	running bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// Config for leader election simulation
+type Config struct {
+	NodeCount int
+	Scenario  string // "leader_crash", "startup", "cascading_crash", "ring_leader_crash", "ring_startup", "ring_worst_case"
+}
+
+// NewSimulation creates a new leader election simulation
+func NewSimulation(eng *engine.Engine, trans *transport.NetworkTransport, broadcast func(interface{}), config Config) *Simulation {
+	switch config.Scenario {
+	case "leader_crash", "startup", "cascading_crash", "ring_leader_crash", "ring_startup", "ring_worst_case":
+	default:
+		config.Scenario = "leader_crash"
+	}
+	if config.NodeCount == 0 {
+		config.NodeCount = 5
+	}
+
+	sim := &Simulation{
+		engine:    eng,
+		rng:       eng.Rand(),
+		transport: trans,
+		broadcast: broadcast,
+		scenario:  config.Scenario,
+		ring:      strings.HasPrefix(config.Scenario, "ring_"),
+		messages:  make(map[string]int),
+	}
+
+	trans.SetLatency(20*time.Millisecond, 80*time.Millisecond)
+	trans.SetPacketLoss(0)
+
+	nodeIDs := make([]string, config.NodeCount)
+	for i := range nodeIDs {
+		nodeIDs[i] = fmt.Sprintf("node-%d", i+1)
+	}
+	sim.ringOrder = append([]string{}, nodeIDs...)
+	if config.Scenario == "ring_worst_case" {
+		// Every ID travels until it meets a higher node, which is as far
+		// as possible when the IDs decrease along the ring
+		for i, id := range nodeIDs {
+			sim.ringOrder[len(nodeIDs)-1-i] = id
+		}
+	}
+
+	for i, id := range nodeIDs {
+		node := newNode(i, nodeIDs, sim)
+		if config.Scenario == "ring_worst_case" {
+			node.patience = leaderTimeout // Everyone starts an election at once
+		}
+		sim.nodes = append(sim.nodes, node)
+		trans.RegisterHandler(id, node.handleMessage)
+		eng.AddNode(node)
+	}
+
+	switch config.Scenario {
+	case "leader_crash", "cascading_crash", "ring_leader_crash":
+		// Start from a settled cluster led by the highest node
+		top := nodeIDs[len(nodeIDs)-1]
+		sim.leader = top
+		sim.term = 1
+		for _, node := range sim.nodes {
+			node.leader = top
+			node.term = 1
+		}
+		sim.nodes[len(sim.nodes)-1].state = "leader"
+		sim.crashAt = crashLeaderAt
+	}
+	if config.Scenario == "cascading_crash" && len(nodeIDs) > 2 {
+		sim.crashOnElect = nodeIDs[len(nodeIDs)-2]
+	}
+
+	return sim
+}
+
+// Start starts the simulation
+func (s *Simulation) Start(ctx context.Context) error {
+	s.mu.Lock()
+	s.running = true
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.mu.Unlock()
+
+	return s.engine.Start(ctx)
+}
+
+// Stop stops the simulation
+func (s *Simulation) Stop() error {
+	s.mu.Lock()
+	s.running = false
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Unlock()
+
+	return s.engine.Stop()
+}
+
+// GetState returns the current simulation state
+func (s *Simulation) GetState() *protocol.SimulationStateResponse {
+	nodes := make(map[string]protocol.NodeState)
+	leaders := make(map[string]bool) // Leaders followed by live nodes
+	live := make(map[string]bool)
+	for _, node := range s.nodes {
+		state := node.GetState()
+		nodes[node.id] = protocol.NodeState{
+			ID:          node.id,
+			Status:      state["status"].(string),
+			Role:        state["state"].(string),
+			CustomState: state,
+		}
+		if state["status"].(string) == "running" {
+			live[node.id] = true
+			leaders[state["leader"].(string)] = true
+		}
+	}
+
+	s.mu.RLock()
+	running := s.running
+	leader := s.leader
+	messages := make(map[string]int, len(s.messages))
+	electionMessages := 0 // Everything but the heartbeats
+	for msgType, count := range s.messages {
+		messages[msgType] = count
+		if msgType != string(MsgHeartbeat) {
+			electionMessages += count
+		}
+	}
+	perElection := 0.0
+	if s.leaders > 0 {
+		perElection = float64(electionMessages) / float64(s.leaders)
+	}
+	algorithm := "bully"
+	if s.ring {
+		algorithm = "chang_roberts"
+	}
+	metadata := map[string]interface{}{
+		"scenario":            s.scenario,
+		"algorithm":           algorithm,
+		"leader":              leader,
+		"term":                s.term,
+		"elections":           s.elections,
+		"leadersElected":      s.leaders,
+		"messages":            messages,
+		"electionMessages":    electionMessages,
+		"messagesPerElection": perElection,
+		// Every live node follows the same, live leader
+		"agreed": len(leaders) == 1 && leaders[leader] && live[leader],
+	}
+	s.mu.RUnlock()
+
+	mode := "step"
+	if s.engine != nil {
+		mode = s.engine.GetMode().String()
+	}
+
+	return &protocol.SimulationStateResponse{
+		Type:        protocol.MsgSimulationState,
+		VirtualTime: time.Now().UnixMilli(),
+		Mode:        mode,
+		Speed:       1.0,
+		Running:     running,
+		Nodes:       nodes,
+		Metadata:    metadata,
+	}
+}
+
+// GetNodes returns node states
+func (s *Simulation) GetNodes() map[string]protocol.NodeState {
+	state := s.GetState()
+	return state.Nodes
+}
+
+// Layout places the nodes on a ring, in the order of the ring election
+func (s *Simulation) Layout() *protocol.Layout {
+	return &protocol.Layout{Kind: protocol.LayoutRing, Order: append([]string{}, s.ringOrder...)}
+}
+
+// CrashNode crashes a node
+func (s *Simulation) CrashNode(nodeID string) error {
+	node := s.findNode(nodeID)
+	if node == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	node.mu.Lock()
+	node.status = "crashed"
+	node.mu.Unlock()
+	return nil
+}
+
+// RecoverNode recovers a crashed node, which forgets the leader and holds
+// an election
+func (s *Simulation) RecoverNode(nodeID string) error {
+	node := s.findNode(nodeID)
+	if node == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	node.mu.Lock()
+	defer node.mu.Unlock()
+
+	node.status = "running"
+	node.leader = ""
+	node.pending = make(map[int]pendingMessage)
+	node.dead = make(map[string]bool)
+	node.startElection()
+	return nil
+}
+
+// findNode looks up a node by ID; the node list is fixed after construction
+func (s *Simulation) findNode(nodeID string) *Node {
+	for _, node := range s.nodes {
+		if node.id == nodeID {
+			return node
+		}
+	}
+	return nil
+}
+
+// advanceSchedule crashes the leader and recovers it in the scripted
+// scenarios
+func (s *Simulation) advanceSchedule(ticks int) {
+	s.mu.Lock()
+	crash := s.crashAt > 0 && ticks >= s.crashAt
+	if crash {
+		s.crashAt = 0
+		s.crashed = s.leader
+		if s.scenario == "leader_crash" || s.scenario == "ring_leader_crash" {
+			s.recoverAt = ticks + recoverAfter
+		}
+	}
+	revive := s.recoverAt > 0 && ticks >= s.recoverAt
+	if revive {
+		s.recoverAt = 0
+	}
+	target := s.crashed
+	s.mu.Unlock()
+
+	if crash {
+		s.CrashNode(target)
+		s.broadcast(map[string]interface{}{
+			"type":   "node_crashed",
+			"nodeId": target,
+			"leader": true,
+		})
+	}
+	if revive {
+		s.broadcast(map[string]interface{}{
+			"type":   "node_recovered",
+			"nodeId": target,
+		})
+		s.RecoverNode(target)
+	}
+}
+
+// electionStarted records a node starting an election
+func (s *Simulation) electionStarted(nodeID string) {
+	s.mu.Lock()
+	s.elections++
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":   "election_started",
+		"nodeId": nodeID,
+	})
+}
+
+// electing crashes the node of "cascading_crash" right after it sent its
+// election messages, and so after answering the node below (must hold
+// node.mu)
+func (s *Simulation) electing(node *Node) {
+	s.mu.Lock()
+	crash := node.id == s.crashOnElect
+	if crash {
+		s.crashOnElect = ""
+	}
+	s.mu.Unlock()
+
+	if crash {
+		node.status = "crashed"
+		s.broadcast(map[string]interface{}{
+			"type":     "node_crashed",
+			"nodeId":   node.id,
+			"electing": true,
+		})
+	}
+}
+
+// elected records a new leader and returns its term
+func (s *Simulation) elected(nodeID string) int {
+	s.mu.Lock()
+	s.term++
+	s.leaders++
+	s.leader = nodeID
+	term := s.term
+	s.mu.Unlock()
+
+	s.broadcast(events.NewLeaderElectedEvent(nodeID, term))
+	return term
+}
+
+// send transmits a message and reports it to the frontend
+func (s *Simulation) send(from, to string, msgType transport.MessageType, payload Payload) {
+	env := transport.NewEnvelope(from, to, msgType, payload)
+
+	s.mu.Lock()
+	s.messages[string(msgType)]++
+	s.mu.Unlock()
+
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageSent,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+
+	s.transport.Send(s.ctx, env)
+}
+
+// received reports a delivered message and returns its payload
+func (s *Simulation) received(env *transport.Envelope) Payload {
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageReceived,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+	payload, _ := env.Payload.(Payload)
+	return payload
+}
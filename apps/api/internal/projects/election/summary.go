@@ -0,0 +1,71 @@
+package election
+
+import (
+	"fmt"
+
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+)
+
+// Invariants judges the run: the live nodes end up agreeing on a live
+// leader, and it is the highest of them
+func (s *Simulation) Invariants() []protocol.InvariantResult {
+	metadata := s.GetState().Metadata
+	leader := metadata["leader"].(string)
+
+	highest := ""
+	for _, node := range s.nodes {
+		node.mu.RLock()
+		if node.status == "running" {
+			highest = node.id
+		}
+		node.mu.RUnlock()
+	}
+
+	return []protocol.InvariantResult{
+		{
+			Name:   "agreement",
+			Holds:  metadata["agreed"].(bool),
+			Detail: fmt.Sprintf("last leader announced: %s, term %d", leader, metadata["term"].(int)),
+		},
+		{
+			Name:   "highest live node leads",
+			Holds:  leader == highest,
+			Detail: fmt.Sprintf("highest live node: %s", highest),
+		},
+	}
+}
+
+// FollowUps suggests the scenarios that contrast with this one, first the
+// same situation under the other algorithm to compare their messages
+func (s *Simulation) FollowUps() []protocol.FollowUp {
+	switch s.scenario {
+	case "leader_crash":
+		return []protocol.FollowUp{
+			{Project: "election", Scenario: "ring_leader_crash", Reason: "Compare the messages per election with the Chang-Roberts ring"},
+			{Project: "election", Scenario: "cascading_crash", Reason: "Crash the next leader in the middle of the election"},
+		}
+	case "cascading_crash":
+		return []protocol.FollowUp{
+			{Project: "election", Scenario: "startup", Reason: "Watch every node start an election at once"},
+		}
+	case "startup":
+		return []protocol.FollowUp{
+			{Project: "election", Scenario: "ring_startup", Reason: "Compare the messages per election with the Chang-Roberts ring"},
+		}
+	case "ring_leader_crash":
+		return []protocol.FollowUp{
+			{Project: "election", Scenario: "leader_crash", Reason: "Compare the messages per election with Bully"},
+		}
+	case "ring_startup":
+		return []protocol.FollowUp{
+			{Project: "election", Scenario: "ring_worst_case", Reason: "Decreasing IDs along the ring make every ID travel as far as it can"},
+		}
+	case "ring_worst_case":
+		return []protocol.FollowUp{
+			{Project: "election", Scenario: "startup", Reason: "Compare the messages per election with Bully"},
+		}
+	}
+	return []protocol.FollowUp{
+		{Project: "election", Scenario: "leader_crash", Reason: "Crash the leader and watch the election cascade upwards"},
+	}
+}
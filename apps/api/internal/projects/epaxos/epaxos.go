@@ -0,0 +1,417 @@
+package epaxos
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+const (
+	MsgPreAccept   transport.MessageType = "pre_accept"
+	MsgPreAcceptOK transport.MessageType = "pre_accept_ok"
+	MsgAccept      transport.MessageType = "accept"
+	MsgAcceptOK    transport.MessageType = "accept_ok"
+	MsgCommit      transport.MessageType = "commit"
+)
+
+// Instance statuses
+const (
+	StatusPreAccepted = "preaccepted"
+	StatusAccepted    = "accepted"
+	StatusCommitted   = "committed"
+	StatusExecuted    = "executed"
+)
+
+// InstanceID names a slot in the instance space of the replica that
+// leads it. Every replica owns its own row, so no leader hands out slots.
+type InstanceID struct {
+	Replica string `json:"replica"`
+	Slot    int    `json:"slot"`
+}
+
+func (id InstanceID) String() string {
+	return fmt.Sprintf("%s.%d", id.Replica, id.Slot)
+}
+
+// less orders instance ids by replica, then slot
+func (id InstanceID) less(other InstanceID) bool {
+	if id.Replica != other.Replica {
+		return id.Replica < other.Replica
+	}
+	return id.Slot < other.Slot
+}
+
+// Instance is a command together with its ordering attributes: the
+// interfering instances it depends on and a sequence number used to
+// break dependency cycles
+type Instance struct {
+	ID      InstanceID   `json:"id"`
+	Command Command      `json:"command"`
+	Seq     int          `json:"seq"`
+	Deps    []InstanceID `json:"deps"`
+	Status  string       `json:"status"`
+}
+
+// copyInstance returns a deep copy to put in a message
+func copyInstance(inst *Instance) *Instance {
+	c := *inst
+	c.Deps = append([]InstanceID{}, inst.Deps...)
+	return &c
+}
+
+// attributes returns the seq and deps a command on key gets from what
+// this replica has seen, leaving out the instance itself (must hold r.mu)
+func (r *Replica) attributes(key string, self InstanceID) (int, []InstanceID) {
+	seq := 1
+	deps := make([]InstanceID, 0)
+	for _, id := range r.latest[key] {
+		if id == self {
+			continue
+		}
+		deps = append(deps, id)
+		if inst := r.instances[id]; inst.Seq+1 > seq {
+			seq = inst.Seq + 1
+		}
+	}
+	return seq, sortDeps(deps)
+}
+
+// record stores an instance and remembers it as the latest of its leader
+// on its key (must hold r.mu)
+func (r *Replica) record(inst *Instance) {
+	r.instances[inst.ID] = inst
+
+	key := inst.Command.Key
+	if r.latest[key] == nil {
+		r.latest[key] = make(map[string]InstanceID)
+	}
+	if latest, ok := r.latest[key][inst.ID.Replica]; !ok || latest.Slot < inst.ID.Slot {
+		r.latest[key][inst.ID.Replica] = inst.ID
+	}
+}
+
+// propose makes this replica the command leader of cmd and starts the
+// PreAccept phase (must hold r.mu)
+func (r *Replica) propose(cmd Command, now int64) {
+	r.slot++
+	id := InstanceID{Replica: r.id, Slot: r.slot}
+	seq, deps := r.attributes(cmd.Key, id)
+
+	inst := &Instance{ID: id, Command: cmd, Seq: seq, Deps: deps, Status: StatusPreAccepted}
+	r.record(inst)
+	r.preAccept(inst, now)
+}
+
+// preAccept sends the instance to the other replicas (must hold r.mu)
+func (r *Replica) preAccept(inst *Instance, now int64) {
+	r.preReplies[inst.ID] = nil
+	for _, peer := range r.regions {
+		if peer != r.id {
+			r.simulation.send(r.id, peer, MsgPreAccept, Payload{At: now, Instance: copyInstance(inst)})
+		}
+	}
+}
+
+// handlePreAccept adds the interfering instances this replica knows of
+// to the proposal and reports whether that changed anything
+func (r *Replica) handlePreAccept(from string, payload Payload, now int64) {
+	if payload.Instance == nil {
+		return
+	}
+	if existing, ok := r.instances[payload.Instance.ID]; ok && existing.Status != StatusPreAccepted {
+		return
+	}
+
+	inst := copyInstance(payload.Instance)
+	seq, deps := r.attributes(inst.Command.Key, inst.ID)
+	merged := unionDeps(inst.Deps, deps)
+	changed := len(merged) != len(inst.Deps) || seq > inst.Seq
+	if seq > inst.Seq {
+		inst.Seq = seq
+	}
+	inst.Deps = merged
+	inst.Status = StatusPreAccepted
+	r.record(inst)
+
+	r.simulation.send(r.id, from, MsgPreAcceptOK, Payload{At: now, Instance: copyInstance(inst), Changed: changed})
+}
+
+// handlePreAcceptOK commits on the fast path once a fast quorum agreed
+// with the leader's attributes, and otherwise runs an Accept round with
+// the union of what the replies reported
+func (r *Replica) handlePreAcceptOK(payload Payload, now int64) {
+	if payload.Instance == nil {
+		return
+	}
+	inst, ok := r.instances[payload.Instance.ID]
+	if !ok || inst.ID.Replica != r.id || inst.Status != StatusPreAccepted {
+		return
+	}
+
+	replies := append(r.preReplies[inst.ID], payload)
+	r.preReplies[inst.ID] = replies
+	if len(replies) < fastQuorum(len(r.regions))-1 {
+		return
+	}
+	delete(r.preReplies, inst.ID)
+
+	changed := false
+	for _, reply := range replies {
+		if reply.Changed {
+			changed = true
+		}
+	}
+	if !changed {
+		r.fastPath++
+		r.commit(inst, now, "fast")
+		return
+	}
+
+	// Some replica saw an interfering command the leader did not: agree
+	// on the union of all dependencies with a majority first
+	for _, reply := range replies {
+		inst.Deps = unionDeps(inst.Deps, reply.Instance.Deps)
+		if reply.Instance.Seq > inst.Seq {
+			inst.Seq = reply.Instance.Seq
+		}
+	}
+	r.slowPath++
+	r.simulation.broadcast(map[string]interface{}{
+		"type":     "conflict_detected",
+		"nodeId":   r.id,
+		"instance": inst.ID.String(),
+		"seq":      inst.Seq,
+		"deps":     depStrings(inst.Deps),
+	})
+	r.accept(inst, now)
+}
+
+// accept sends the final attributes to the other replicas (must hold
+// r.mu)
+func (r *Replica) accept(inst *Instance, now int64) {
+	inst.Status = StatusAccepted
+	r.acceptOKs[inst.ID] = 0
+	for _, peer := range r.regions {
+		if peer != r.id {
+			r.simulation.send(r.id, peer, MsgAccept, Payload{At: now, Instance: copyInstance(inst)})
+		}
+	}
+}
+
+func (r *Replica) handleAccept(from string, payload Payload, now int64) {
+	if payload.Instance == nil {
+		return
+	}
+	if existing, ok := r.instances[payload.Instance.ID]; ok && (existing.Status == StatusCommitted || existing.Status == StatusExecuted) {
+		return
+	}
+
+	inst := copyInstance(payload.Instance)
+	inst.Status = StatusAccepted
+	r.record(inst)
+
+	r.simulation.send(r.id, from, MsgAcceptOK, Payload{At: now, Instance: &Instance{ID: inst.ID}})
+}
+
+func (r *Replica) handleAcceptOK(payload Payload, now int64) {
+	if payload.Instance == nil {
+		return
+	}
+	inst, ok := r.instances[payload.Instance.ID]
+	if !ok || inst.ID.Replica != r.id || inst.Status != StatusAccepted {
+		return
+	}
+
+	r.acceptOKs[inst.ID]++
+	if r.acceptOKs[inst.ID] == r.quorum()-1 {
+		delete(r.acceptOKs, inst.ID)
+		r.commit(inst, now, "slow")
+	}
+}
+
+// commit marks an instance we lead as committed, tells the other
+// replicas and answers our client (must hold r.mu)
+func (r *Replica) commit(inst *Instance, now int64, path string) {
+	inst.Status = StatusCommitted
+	for _, peer := range r.regions {
+		if peer != r.id {
+			r.simulation.send(r.id, peer, MsgCommit, Payload{At: now, Instance: copyInstance(inst)})
+		}
+	}
+	r.committed(inst.Command, now, path)
+}
+
+func (r *Replica) handleCommit(payload Payload) {
+	if payload.Instance == nil {
+		return
+	}
+	if existing, ok := r.instances[payload.Instance.ID]; ok && existing.Status == StatusExecuted {
+		return
+	}
+
+	inst := copyInstance(payload.Instance)
+	inst.Status = StatusCommitted
+	r.record(inst)
+}
+
+// restartInstances drives the unfinished instances this replica leads
+// after a crash; replies to the earlier round may have been lost (must
+// hold r.mu)
+func (r *Replica) restartInstances(now int64) {
+	for _, inst := range r.instances {
+		if inst.ID.Replica != r.id {
+			continue
+		}
+		switch inst.Status {
+		case StatusPreAccepted:
+			r.preAccept(inst, now)
+		case StatusAccepted:
+			r.accept(inst, now)
+		}
+	}
+}
+
+// execute runs every committed instance whose dependency graph is fully
+// committed. Strongly connected components of the graph are executed
+// dependencies first, and the instances inside one in seq order, so all
+// replicas apply interfering commands in the same order (must hold r.mu).
+func (r *Replica) execute() {
+	ids := make([]InstanceID, 0)
+	for id, inst := range r.instances {
+		if inst.Status == StatusCommitted {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].less(ids[j]) })
+
+	for _, id := range ids {
+		if r.instances[id].Status == StatusCommitted {
+			r.executeFrom(id)
+		}
+	}
+}
+
+// executeFrom runs Tarjan's algorithm from root and executes each
+// component as it is found. It stops at the first dependency that is not
+// committed here yet; components found before that are complete.
+func (r *Replica) executeFrom(root InstanceID) {
+	index := make(map[InstanceID]int)
+	low := make(map[InstanceID]int)
+	onStack := make(map[InstanceID]bool)
+	stack := make([]InstanceID, 0)
+	blocked := false
+
+	var visit func(v InstanceID)
+	visit = func(v InstanceID) {
+		index[v] = len(index)
+		low[v] = index[v]
+		stack = append(stack, v)
+		onStack[v] = true
+
+		for _, w := range r.instances[v].Deps {
+			dep, ok := r.instances[w]
+			if !ok || dep.Status == StatusPreAccepted || dep.Status == StatusAccepted {
+				blocked = true
+				return
+			}
+			if dep.Status == StatusExecuted {
+				continue
+			}
+			if _, seen := index[w]; !seen {
+				visit(w)
+				if blocked {
+					return
+				}
+				if low[w] < low[v] {
+					low[v] = low[w]
+				}
+			} else if onStack[w] && index[w] < low[v] {
+				low[v] = index[w]
+			}
+		}
+
+		if low[v] != index[v] {
+			return
+		}
+		component := make([]*Instance, 0)
+		for {
+			w := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[w] = false
+			component = append(component, r.instances[w])
+			if w == v {
+				break
+			}
+		}
+		sort.Slice(component, func(i, j int) bool {
+			if component[i].Seq != component[j].Seq {
+				return component[i].Seq < component[j].Seq
+			}
+			return component[i].ID.less(component[j].ID)
+		})
+		for _, inst := range component {
+			inst.Status = StatusExecuted
+			r.applyCommand(inst.Command)
+		}
+	}
+	visit(root)
+}
+
+// recentInstances returns the last n instances in seq order
+func (r *Replica) recentInstances(n int) []map[string]interface{} {
+	all := make([]*Instance, 0, len(r.instances))
+	for _, inst := range r.instances {
+		all = append(all, inst)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Seq != all[j].Seq {
+			return all[i].Seq < all[j].Seq
+		}
+		return all[i].ID.less(all[j].ID)
+	})
+	if len(all) > n {
+		all = all[len(all)-n:]
+	}
+
+	recent := make([]map[string]interface{}, 0, len(all))
+	for _, inst := range all {
+		recent = append(recent, map[string]interface{}{
+			"id":      inst.ID.String(),
+			"command": inst.Command.ID,
+			"key":     inst.Command.Key,
+			"seq":     inst.Seq,
+			"deps":    depStrings(inst.Deps),
+			"status":  inst.Status,
+		})
+	}
+	return recent
+}
+
+// unionDeps merges two dependency lists
+func unionDeps(a, b []InstanceID) []InstanceID {
+	seen := make(map[InstanceID]bool)
+	union := make([]InstanceID, 0, len(a)+len(b))
+	for _, list := range [][]InstanceID{a, b} {
+		for _, id := range list {
+			if !seen[id] {
+				seen[id] = true
+				union = append(union, id)
+			}
+		}
+	}
+	return sortDeps(union)
+}
+
+func sortDeps(deps []InstanceID) []InstanceID {
+	sort.Slice(deps, func(i, j int) bool { return deps[i].less(deps[j]) })
+	return deps
+}
+
+func depStrings(deps []InstanceID) []string {
+	out := make([]string, 0, len(deps))
+	for _, id := range deps {
+		out = append(out, id.String())
+	}
+	return out
+}
@@ -0,0 +1,110 @@
+package epaxos
+
+import (
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+// Leader-based replication for comparison: the normal case of Raft or
+// Multi-Paxos with a fixed leader and no elections. Every write travels
+// to the leader, which commits it once a majority stored it and tells the
+// replica of the client that issued it.
+const (
+	MsgForward     transport.MessageType = "forward"
+	MsgAppend      transport.MessageType = "append"
+	MsgAppendOK    transport.MessageType = "append_ok"
+	MsgCommitIndex transport.MessageType = "commit_index"
+)
+
+// submitToLeader hands a command of our client to the leader (must hold
+// r.mu)
+func (r *Replica) submitToLeader(cmd Command, now int64) {
+	if r.id == r.simulation.leader {
+		r.appendEntry(cmd, now)
+		return
+	}
+	r.simulation.send(r.id, r.simulation.leader, MsgForward, Payload{At: now, Command: &cmd})
+}
+
+func (r *Replica) handleForward(payload Payload, now int64) {
+	if payload.Command != nil && r.id == r.simulation.leader {
+		r.appendEntry(*payload.Command, now)
+	}
+}
+
+// appendEntry adds a command to the leader's log and replicates it
+// (must hold r.mu)
+func (r *Replica) appendEntry(cmd Command, now int64) {
+	index := len(r.entries) + 1
+	r.entries[index] = cmd
+	r.acks[index] = 1
+
+	for _, peer := range r.regions {
+		if peer != r.id {
+			r.simulation.send(r.id, peer, MsgAppend, Payload{At: now, Index: index, Command: &cmd})
+		}
+	}
+}
+
+func (r *Replica) handleAppend(from string, payload Payload, now int64) {
+	if payload.Command == nil {
+		return
+	}
+	r.entries[payload.Index] = *payload.Command
+	r.simulation.send(r.id, from, MsgAppendOK, Payload{At: now, Index: payload.Index})
+	r.applyEntries(now)
+}
+
+// handleAppendOK advances the commit index over every entry stored by a
+// majority. Entries commit in log order, so an entry that reached its
+// majority early still waits for the ones before it.
+func (r *Replica) handleAppendOK(payload Payload, now int64) {
+	if _, ok := r.acks[payload.Index]; !ok {
+		return
+	}
+	r.acks[payload.Index]++
+	if r.acks[payload.Index] == r.quorum() {
+		r.storedAt[payload.Index] = now
+	}
+
+	for r.acks[r.commitIndex+1] >= r.quorum() {
+		index := r.commitIndex + 1
+		at := r.storedAt[index]
+		if at < r.committedAt {
+			at = r.committedAt
+		}
+		delete(r.acks, index)
+		delete(r.storedAt, index)
+		r.commitIndex = index
+		r.committedAt = at
+
+		for _, peer := range r.regions {
+			if peer != r.id {
+				r.simulation.send(r.id, peer, MsgCommitIndex, Payload{At: at, Index: index})
+			}
+		}
+		r.applyEntries(at)
+	}
+}
+
+func (r *Replica) handleCommitIndex(payload Payload, now int64) {
+	if payload.Index > r.commitIndex {
+		r.commitIndex = payload.Index
+	}
+	r.applyEntries(now)
+}
+
+// applyEntries executes committed entries in log order and answers our
+// client when one of them is its command (must hold r.mu)
+func (r *Replica) applyEntries(now int64) {
+	for r.applied < r.commitIndex {
+		cmd, ok := r.entries[r.applied+1]
+		if !ok {
+			return
+		}
+		r.applied++
+		r.applyCommand(cmd)
+		if cmd.Origin == r.id {
+			r.committed(cmd, now, "leader")
+		}
+	}
+}
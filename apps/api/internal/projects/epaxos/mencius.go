@@ -0,0 +1,205 @@
+package epaxos
+
+import (
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+// Mencius: the slots of one log are handed out round robin, slot i
+// belonging to replica i mod n. Every replica coordinates the commands of
+// its own clients in its own slots, so the leader's load is spread over
+// all of them. Slots execute in log order, which makes every replica wait
+// for every other one: an owner that has nothing to propose must skip its
+// slots, and a slow owner stalls the log for everybody. Revoking the
+// slots of a slow or crashed owner is not modeled.
+const (
+	MsgSuggest    transport.MessageType = "suggest"
+	MsgSuggestAck transport.MessageType = "suggest_ack"
+	MsgLearn      transport.MessageType = "learn"
+	MsgSkip       transport.MessageType = "skip"
+)
+
+// menciusSlot is one log position. A committed slot without a command
+// is a no-op.
+type menciusSlot struct {
+	Command   *Command
+	Committed bool
+	KnownAt   int64 // Modeled time this replica learned the outcome
+}
+
+// owner returns the replica a slot belongs to
+func (r *Replica) owner(slot int) string {
+	return r.regions[slot%len(r.regions)]
+}
+
+// logSlot returns the log position, creating it if needed (must hold r.mu)
+func (r *Replica) logSlot(index int) *menciusSlot {
+	sl, ok := r.slots[index]
+	if !ok {
+		sl = &menciusSlot{}
+		r.slots[index] = sl
+	}
+	return sl
+}
+
+// suggest proposes a command of our client in our next free slot (must
+// hold r.mu)
+func (r *Replica) suggest(cmd Command, now int64) {
+	index := r.nextOwned
+	r.nextOwned += len(r.regions)
+	r.logSlot(index).Command = &cmd
+	r.suggestAcks[index] = 1
+
+	for _, peer := range r.regions {
+		if peer != r.id {
+			r.simulation.send(r.id, peer, MsgSuggest, Payload{At: now, Index: index, Command: &cmd})
+		}
+	}
+}
+
+// handleSuggest accepts another owner's command and skips our own slots
+// before it, since we have nothing to put there
+func (r *Replica) handleSuggest(from string, payload Payload, now int64) {
+	if payload.Command == nil || payload.Index < r.executedTo {
+		return
+	}
+	sl := r.logSlot(payload.Index)
+	if !sl.Committed {
+		sl.Command = payload.Command
+	}
+	r.simulation.send(r.id, from, MsgSuggestAck, Payload{At: now, Index: payload.Index})
+	r.skipBelow(payload.Index, now)
+}
+
+// skipBelow turns our unused slots below limit into no-ops. Only the
+// owner may propose a command in a slot, so its skip needs no quorum
+// (must hold r.mu).
+func (r *Replica) skipBelow(limit int, now int64) {
+	from := r.nextOwned
+	for r.nextOwned < limit {
+		sl := r.logSlot(r.nextOwned)
+		sl.Committed = true
+		sl.KnownAt = now
+		r.nextOwned += len(r.regions)
+	}
+	if r.nextOwned == from {
+		return
+	}
+
+	for _, peer := range r.regions {
+		if peer != r.id {
+			r.simulation.send(r.id, peer, MsgSkip, Payload{At: now, Index: from, Until: r.nextOwned})
+		}
+	}
+}
+
+func (r *Replica) handleSuggestAck(payload Payload, now int64) {
+	if _, ok := r.suggestAcks[payload.Index]; !ok {
+		return
+	}
+	r.suggestAcks[payload.Index]++
+	if r.suggestAcks[payload.Index] < r.quorum() {
+		return
+	}
+	delete(r.suggestAcks, payload.Index)
+
+	sl := r.logSlot(payload.Index)
+	sl.Committed = true
+	sl.KnownAt = now
+	for _, peer := range r.regions {
+		if peer != r.id {
+			r.simulation.send(r.id, peer, MsgLearn, Payload{At: now, Index: payload.Index, Command: sl.Command})
+		}
+	}
+}
+
+func (r *Replica) handleLearn(payload Payload, now int64) {
+	if payload.Index < r.executedTo {
+		return
+	}
+	sl := r.logSlot(payload.Index)
+	if !sl.Committed {
+		sl.Command = payload.Command
+		sl.Committed = true
+		sl.KnownAt = now
+	}
+}
+
+func (r *Replica) handleSkip(from string, payload Payload, now int64) {
+	for index := payload.Index; index < payload.Until; index += len(r.regions) {
+		if index < r.executedTo || r.owner(index) != from {
+			continue
+		}
+		sl := r.logSlot(index)
+		if !sl.Committed {
+			sl.Command = nil
+			sl.Committed = true
+			sl.KnownAt = now
+		}
+	}
+}
+
+// executeSlots runs the committed prefix of the log. A command executes
+// once every slot before it is decided, which is when its client gets
+// the answer (must hold r.mu).
+func (r *Replica) executeSlots() {
+	for {
+		sl, ok := r.slots[r.executedTo]
+		if !ok || !sl.Committed {
+			return
+		}
+		if sl.KnownAt > r.executedAt {
+			r.executedAt = sl.KnownAt
+		}
+		if sl.Command != nil {
+			r.applyCommand(*sl.Command)
+			if sl.Command.Origin == r.id {
+				r.committed(*sl.Command, r.executedAt, "mencius")
+			}
+		}
+		delete(r.slots, r.executedTo-slotWindow)
+		r.executedTo++
+	}
+}
+
+// blockedOn returns the owner of the first undecided slot when later
+// slots are already decided, i.e. the replica the log is waiting for
+func (r *Replica) blockedOn() string {
+	if sl, ok := r.slots[r.executedTo]; ok && sl.Committed {
+		return ""
+	}
+	for index, sl := range r.slots {
+		if index > r.executedTo && sl.Committed {
+			return r.owner(r.executedTo)
+		}
+	}
+	return ""
+}
+
+// recentSlots describes the log around the execution point
+func (r *Replica) recentSlots() []map[string]interface{} {
+	recent := make([]map[string]interface{}, 0)
+	for index := r.executedTo - 4; index < r.executedTo+slotWindow; index++ {
+		sl, ok := r.slots[index]
+		if index < 0 || !ok {
+			continue
+		}
+		status := "suggested"
+		switch {
+		case index < r.executedTo:
+			status = "executed"
+		case sl.Committed:
+			status = "committed"
+		}
+		command := "no-op"
+		if sl.Command != nil {
+			command = sl.Command.ID
+		}
+		recent = append(recent, map[string]interface{}{
+			"slot":    index,
+			"owner":   r.owner(index),
+			"command": command,
+			"status":  status,
+		})
+	}
+	return recent
+}
@@ -0,0 +1,92 @@
+package epaxos
+
+import (
+	"sort"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+// Regions replicas are placed in, in placement order. Replica IDs are the
+// region names.
+var Regions = []string{"us-east", "us-west", "eu-west", "ap-northeast", "sa-east"}
+
+// oneWay holds one-way delays in milliseconds between regions, roughly
+// half of the round trip times measured between cloud regions
+var oneWay = map[string]map[string]int64{
+	"us-east":      {"us-west": 31, "eu-west": 34, "ap-northeast": 73, "sa-east": 58},
+	"us-west":      {"us-east": 31, "eu-west": 68, "ap-northeast": 53, "sa-east": 88},
+	"eu-west":      {"us-east": 34, "us-west": 68, "ap-northeast": 105, "sa-east": 90},
+	"ap-northeast": {"us-east": 73, "us-west": 53, "eu-west": 105, "sa-east": 128},
+	"sa-east":      {"us-east": 58, "us-west": 88, "eu-west": 90, "ap-northeast": 128},
+}
+
+// delay returns the modeled one-way delay between two regions
+func delay(from, to string) int64 {
+	if from == to {
+		return 0
+	}
+	return oneWay[from][to]
+}
+
+// applyRegions configures the transport so that every link takes its
+// region-to-region delay plus up to 10% jitter
+func applyRegions(trans *transport.NetworkTransport, regions []string) {
+	trans.SetLatency(time.Millisecond, 5*time.Millisecond)
+	for _, from := range regions {
+		for _, to := range regions {
+			if from == to {
+				continue
+			}
+			d := time.Duration(delay(from, to)) * time.Millisecond
+			trans.SetLinkLatency(from, to, d, d+d/10)
+		}
+	}
+}
+
+// nearest returns the k-th smallest delay from region to the others in
+// regions (k starts at 1)
+func nearest(region string, regions []string, k int) int64 {
+	delays := make([]int64, 0, len(regions))
+	for _, other := range regions {
+		if other != region {
+			delays = append(delays, delay(region, other))
+		}
+	}
+	if k < 1 || k > len(delays) {
+		return 0
+	}
+	sort.Slice(delays, func(i, j int) bool { return delays[i] < delays[j] })
+	return delays[k-1]
+}
+
+// expectedLatency returns, per region, the commit latency a client sees
+// when no messages queue up: one round trip to the closest fast quorum for
+// EPaxos (plus one to a majority on conflicts); for Mencius up to a round
+// trip to the farthest replica, when the log waits for its skip; and for a
+// leader-based protocol the trip to the leader, one round trip from the
+// leader to a majority and the trip back
+func expectedLatency(regions []string, leader string) map[string]map[string]int64 {
+	fast := fastQuorum(len(regions)) - 1
+	majority := len(regions) / 2
+
+	expected := make(map[string]map[string]int64)
+	for _, r := range regions {
+		fastPath := 2 * nearest(r, regions, fast)
+		expected[r] = map[string]int64{
+			"epaxosFast": fastPath,
+			"epaxosSlow": fastPath + 2*nearest(r, regions, majority),
+			"mencius":    2 * nearest(r, regions, len(regions)-1),
+			"leader":     delay(r, leader) + 2*nearest(leader, regions, majority) + delay(leader, r),
+		}
+	}
+	return expected
+}
+
+// fastQuorum returns the EPaxos fast quorum size for n replicas,
+// F + floor((F+1)/2) where F = floor((n-1)/2) failures are tolerated.
+// The command leader counts as one of them.
+func fastQuorum(n int) int {
+	f := (n - 1) / 2
+	return f + (f+1)/2
+}
@@ -0,0 +1,358 @@
+package epaxos
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+const (
+	thinkTicks     = 5  // Ticks a client waits between commands
+	latencyHistory = 20 // Latencies kept per replica
+	slotWindow     = 8  // Executed Mencius slots kept for display
+)
+
+// Replica is a server in one region together with the client of that
+// region. The client keeps one command outstanding and records how long
+// the replica took to report it committed.
+type Replica struct {
+	mu sync.RWMutex
+
+	id      string
+	status  string
+	ticks   int
+	regions []string
+
+	// Client
+	waiting    *Command
+	nextSubmit int
+	issued     int
+	latencies  []int64
+
+	// EPaxos
+	slot       int
+	instances  map[InstanceID]*Instance
+	latest     map[string]map[string]InstanceID // Key -> replica -> highest instance
+	preReplies map[InstanceID][]Payload
+	acceptOKs  map[InstanceID]int
+	fastPath   int
+	slowPath   int
+
+	// Leader-based
+	entries     map[int]Command
+	acks        map[int]int   // Index -> replicas holding the entry
+	storedAt    map[int]int64 // Index -> modeled time a majority held it
+	commitIndex int
+	committedAt int64
+	applied     int
+
+	// Mencius
+	slots       map[int]*menciusSlot
+	nextOwned   int         // Lowest own slot not yet used or skipped
+	suggestAcks map[int]int // Own slot -> replicas that accepted it
+	executedTo  int         // Slots below are executed
+	executedAt  int64       // Modeled time the last slot executed
+
+	// State machine
+	store   map[string]int
+	execLog map[string][]string // Key -> command IDs in execution order
+
+	inbox      chan *transport.Envelope
+	simulation *Simulation
+}
+
+func newReplica(id string, index int, regions []string, sim *Simulation) *Replica {
+	return &Replica{
+		id:          id,
+		status:      "running",
+		regions:     regions,
+		nextSubmit:  5,
+		instances:   make(map[InstanceID]*Instance),
+		latest:      make(map[string]map[string]InstanceID),
+		preReplies:  make(map[InstanceID][]Payload),
+		acceptOKs:   make(map[InstanceID]int),
+		entries:     make(map[int]Command),
+		acks:        make(map[int]int),
+		storedAt:    make(map[int]int64),
+		slots:       make(map[int]*menciusSlot),
+		nextOwned:   index,
+		suggestAcks: make(map[int]int),
+		store:       make(map[string]int),
+		execLog:     make(map[string][]string),
+		inbox:       make(chan *transport.Envelope, 500),
+		simulation:  sim,
+	}
+}
+
+func (r *Replica) crash() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status = "crashed"
+}
+
+// recover restarts the replica with its persistent state. The client's
+// outstanding command is abandoned; instances it led are driven again.
+func (r *Replica) recover() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.status = "running"
+	for len(r.inbox) > 0 {
+		<-r.inbox
+	}
+	r.waiting = nil
+	r.nextSubmit = r.ticks + thinkTicks
+	if r.simulation.protocol == "epaxos" {
+		r.restartInstances(r.simulation.now())
+	}
+}
+
+// Replica implements engine.NodeController
+
+func (r *Replica) ID() string {
+	return r.id
+}
+
+func (r *Replica) Start(ctx context.Context) error {
+	return nil
+}
+
+func (r *Replica) Stop() error {
+	return nil
+}
+
+func (r *Replica) Tick() {
+	ticks := r.tick()
+	r.simulation.advanceSchedule(ticks)
+}
+
+func (r *Replica) tick() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.ticks++
+	if r.status != "running" {
+		return r.ticks
+	}
+
+	for i := 0; i < 50; i++ {
+		select {
+		case env := <-r.inbox:
+			r.processMessage(env)
+			continue
+		default:
+		}
+		break
+	}
+
+	switch r.simulation.protocol {
+	case "epaxos":
+		r.execute()
+	case "mencius":
+		r.executeSlots()
+	}
+
+	if r.waiting == nil && r.ticks >= r.nextSubmit {
+		r.submit()
+	}
+	return r.ticks
+}
+
+func (r *Replica) GetState() map[string]interface{} {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var total, last int64
+	for _, l := range r.latencies {
+		total += l
+		last = l
+	}
+	latency := map[string]interface{}{
+		"last":    last,
+		"average": int64(0),
+		"samples": len(r.latencies),
+	}
+	if len(r.latencies) > 0 {
+		latency["average"] = total / int64(len(r.latencies))
+	}
+
+	waiting := ""
+	if r.waiting != nil {
+		waiting = r.waiting.ID
+	}
+
+	store := make(map[string]int, len(r.store))
+	for k, v := range r.store {
+		store[k] = v
+	}
+
+	state := map[string]interface{}{
+		"id":       r.id,
+		"status":   r.status,
+		"region":   r.id,
+		"issued":   r.issued,
+		"waiting":  waiting,
+		"latency":  latency,
+		"store":    store,
+		"fastPath": r.fastPath,
+		"slowPath": r.slowPath,
+	}
+	switch r.simulation.protocol {
+	case "epaxos":
+		committed, executed, blocked := 0, 0, 0
+		for _, inst := range r.instances {
+			switch inst.Status {
+			case StatusCommitted:
+				committed++
+				blocked++
+			case StatusExecuted:
+				committed++
+				executed++
+			}
+		}
+		state["instances"] = len(r.instances)
+		state["committed"] = committed
+		state["executed"] = executed
+		state["blocked"] = blocked // Committed, waiting on dependencies
+		state["recent"] = r.recentInstances(8)
+	case "mencius":
+		state["nextSlot"] = r.nextOwned
+		state["executed"] = r.executedTo
+		state["blockedOn"] = r.blockedOn()
+		state["slots"] = r.recentSlots()
+	default:
+		state["entries"] = len(r.entries)
+		state["commitIndex"] = r.commitIndex
+	}
+	return state
+}
+
+func (r *Replica) handleMessage(env *transport.Envelope) {
+	r.mu.RLock()
+	crashed := r.status != "running"
+	r.mu.RUnlock()
+
+	// Crashed replicas do not receive anything
+	if crashed {
+		return
+	}
+	select {
+	case r.inbox <- env:
+	default:
+	}
+}
+
+func (r *Replica) processMessage(env *transport.Envelope) {
+	payload, now := r.simulation.received(env)
+
+	switch env.Type {
+	case MsgPreAccept:
+		r.handlePreAccept(env.From, payload, now)
+	case MsgPreAcceptOK:
+		r.handlePreAcceptOK(payload, now)
+	case MsgAccept:
+		r.handleAccept(env.From, payload, now)
+	case MsgAcceptOK:
+		r.handleAcceptOK(payload, now)
+	case MsgCommit:
+		r.handleCommit(payload)
+
+	case MsgForward:
+		r.handleForward(payload, now)
+	case MsgAppend:
+		r.handleAppend(env.From, payload, now)
+	case MsgAppendOK:
+		r.handleAppendOK(payload, now)
+	case MsgCommitIndex:
+		r.handleCommitIndex(payload, now)
+
+	case MsgSuggest:
+		r.handleSuggest(env.From, payload, now)
+	case MsgSuggestAck:
+		r.handleSuggestAck(payload, now)
+	case MsgLearn:
+		r.handleLearn(payload, now)
+	case MsgSkip:
+		r.handleSkip(env.From, payload, now)
+	}
+}
+
+// submit issues the client's next command through this replica (must
+// hold r.mu)
+func (r *Replica) submit() {
+	sim := r.simulation
+
+	r.issued++
+	key := "k-" + r.id
+	if sim.conflict {
+		key = "x"
+	}
+	cmd := Command{
+		ID:          fmt.Sprintf("%s-%d", r.id, r.issued),
+		Key:         key,
+		Value:       r.issued,
+		Origin:      r.id,
+		SubmittedAt: sim.now(),
+	}
+	r.waiting = &cmd
+
+	switch sim.protocol {
+	case "epaxos":
+		r.propose(cmd, cmd.SubmittedAt)
+	case "mencius":
+		r.suggest(cmd, cmd.SubmittedAt)
+	default:
+		r.submitToLeader(cmd, cmd.SubmittedAt)
+	}
+}
+
+// committed reports a command of our client as committed at modeled time
+// at (must hold r.mu)
+func (r *Replica) committed(cmd Command, at int64, path string) {
+	if r.waiting == nil || r.waiting.ID != cmd.ID {
+		return
+	}
+
+	latency := at - cmd.SubmittedAt
+	r.latencies = append(r.latencies, latency)
+	if len(r.latencies) > latencyHistory {
+		r.latencies = r.latencies[1:]
+	}
+	r.waiting = nil
+	r.nextSubmit = r.ticks + thinkTicks
+
+	r.simulation.broadcast(map[string]interface{}{
+		"type":      "command_committed",
+		"nodeId":    r.id,
+		"command":   cmd.ID,
+		"key":       cmd.Key,
+		"path":      path,
+		"latencyMs": latency,
+	})
+}
+
+// applyCommand executes a committed write on the local store (must hold
+// r.mu)
+func (r *Replica) applyCommand(cmd Command) {
+	r.store[cmd.Key] = cmd.Value
+	r.execLog[cmd.Key] = append(r.execLog[cmd.Key], cmd.ID)
+}
+
+// executionLog returns a copy of the per-key execution order
+func (r *Replica) executionLog() map[string][]string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	log := make(map[string][]string, len(r.execLog))
+	for key, ids := range r.execLog {
+		log[key] = append([]string{}, ids...)
+	}
+	return log
+}
+
+// quorum returns the number of replicas forming a majority
+func (r *Replica) quorum() int {
+	return len(r.regions)/2 + 1
+}
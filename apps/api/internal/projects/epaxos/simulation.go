@@ -0,0 +1,371 @@
+package epaxos
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+	"github.com/ersantana/distributed-systems-learning/packages/simulation/engine"
+)
+
+// Command is a write issued by the client co-located with a replica
+type Command struct {
+	ID          string `json:"id"`
+	Key         string `json:"key"`
+	Value       int    `json:"value"`
+	Origin      string `json:"origin"`      // Replica whose client issued it
+	SubmittedAt int64  `json:"submittedAt"` // Modeled time in ms
+}
+
+// Payload is the content of all messages exchanged in this project. At
+// is the modeled time the message was sent; it arrives one region delay
+// later, so latencies do not depend on tick granularity.
+type Payload struct {
+	At       int64     `json:"at"`
+	Instance *Instance `json:"instance,omitempty"`
+	Changed  bool      `json:"changed,omitempty"` // PreAccept reply added deps or raised seq
+	Index    int       `json:"index,omitempty"`
+	Until    int       `json:"until,omitempty"` // End of a Mencius skip
+	Command  *Command  `json:"command,omitempty"`
+}
+
+// scriptedFault makes a replica slow or normal again at a given tick,
+// using the transport's slow-node delay like a scheduled "delay" fault
+type scriptedFault struct {
+	tick   int
+	action string // "slow", "normal"
+	target string
+	delay  time.Duration
+}
+
+// Simulation places one replica in each region, each with a local client
+// writing through it. In "epaxos" mode every replica leads the commands
+// of its own client; in "mencius" mode replicas take turns owning the
+// slots of one log; in "leader" mode all writes go through the replica in
+// the first region, as in Raft or Multi-Paxos.
+type Simulation struct {
+	mu sync.RWMutex
+
+	engine    *engine.Engine
+	transport *transport.NetworkTransport
+	broadcast func(interface{})
+
+	replicas []*Replica
+	regions  []string
+	protocol string // "epaxos", "mencius", "leader"
+	leader   string // Fixed leader in "leader" mode
+	conflict bool   // All clients write the same key
+	scenario string
+
+	script []scriptedFault
+	sent   map[string]int // Messages sent per replica
+
+	started time.Time
+
+	running bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// Config for EPaxos simulation
+type Config struct {
+	NodeCount int // 3 or 5 replicas
+	Scenario  string
+}
+
+// NewSimulation creates a new EPaxos simulation
+func NewSimulation(eng *engine.Engine, trans *transport.NetworkTransport, broadcast func(interface{}), config Config) *Simulation {
+	// Fast quorums only work out for 2F+1 replicas
+	if config.NodeCount != 3 {
+		config.NodeCount = len(Regions)
+	}
+
+	sim := &Simulation{
+		engine:    eng,
+		transport: trans,
+		broadcast: broadcast,
+		regions:   Regions[:config.NodeCount],
+		protocol:  "epaxos",
+		scenario:  config.Scenario,
+		sent:      make(map[string]int),
+	}
+
+	// Scenarios: "epaxos" where each client writes its own key so every
+	// command commits on the fast path, "epaxos_conflicts" where all
+	// clients write one key and concurrent commands need a second round,
+	// "mencius" and "leader" running the same workload with rotating
+	// slot owners or a single leader, and "mencius_slow" where one owner
+	// turns slow for a while and holds up the whole log
+	switch config.Scenario {
+	case "epaxos_conflicts":
+		sim.conflict = true
+	case "mencius":
+		sim.protocol = "mencius"
+	case "mencius_slow":
+		sim.protocol = "mencius"
+		slow := sim.regions[len(sim.regions)/2]
+		sim.script = []scriptedFault{
+			{tick: 60, action: "slow", target: slow, delay: 500 * time.Millisecond},
+			{tick: 160, action: "normal", target: slow},
+		}
+	case "leader":
+		sim.protocol = "leader"
+	}
+	sim.leader = sim.regions[0]
+
+	applyRegions(trans, sim.regions)
+	trans.SetPacketLoss(0)
+
+	for i, region := range sim.regions {
+		replica := newReplica(region, i, sim.regions, sim)
+		sim.replicas = append(sim.replicas, replica)
+		trans.RegisterHandler(region, replica.handleMessage)
+		eng.AddNode(replica)
+	}
+
+	return sim
+}
+
+// Start starts the simulation
+func (s *Simulation) Start(ctx context.Context) error {
+	s.mu.Lock()
+	s.running = true
+	s.started = s.engine.GetVirtualTime()
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.mu.Unlock()
+
+	return s.engine.Start(ctx)
+}
+
+// Stop stops the simulation
+func (s *Simulation) Stop() error {
+	s.mu.Lock()
+	s.running = false
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Unlock()
+
+	return s.engine.Stop()
+}
+
+// GetState returns the current simulation state
+func (s *Simulation) GetState() *protocol.SimulationStateResponse {
+	nodes := make(map[string]protocol.NodeState)
+	latency := make(map[string]interface{})
+	logs := make([]map[string][]string, 0, len(s.replicas))
+	fast, slow := 0, 0
+	for _, replica := range s.replicas {
+		state := replica.GetState()
+		role := "replica"
+		if s.protocol == "leader" {
+			role = "follower"
+			if replica.id == s.leader {
+				role = "leader"
+			}
+		}
+		nodes[replica.id] = protocol.NodeState{
+			ID:          replica.id,
+			Status:      state["status"].(string),
+			Role:        role,
+			CustomState: state,
+		}
+		latency[replica.id] = state["latency"]
+		fast += state["fastPath"].(int)
+		slow += state["slowPath"].(int)
+		logs = append(logs, replica.executionLog())
+	}
+
+	s.mu.RLock()
+	running := s.running
+	load := make(map[string]int, len(s.sent))
+	for id, sent := range s.sent {
+		load[id] = sent
+	}
+	s.mu.RUnlock()
+
+	mode := "step"
+	if s.engine != nil {
+		mode = s.engine.GetMode().String()
+	}
+
+	return &protocol.SimulationStateResponse{
+		Type:        protocol.MsgSimulationState,
+		VirtualTime: time.Now().UnixMilli(),
+		Mode:        mode,
+		Speed:       1.0,
+		Running:     running,
+		Nodes:       nodes,
+		Metadata: map[string]interface{}{
+			"protocol":        s.protocol,
+			"leader":          s.leader,
+			"regions":         s.regions,
+			"fastPath":        fast,
+			"slowPath":        slow,
+			"latency":         latency,
+			"load":            load,
+			"expectedLatency": expectedLatency(s.regions, s.leader),
+			"consistent":      consistent(logs),
+		},
+	}
+}
+
+// GetNodes returns node states
+func (s *Simulation) GetNodes() map[string]protocol.NodeState {
+	state := s.GetState()
+	return state.Nodes
+}
+
+// Layout places the replicas on a ring in region order, around the
+// leader in "leader" mode
+func (s *Simulation) Layout() *protocol.Layout {
+	if s.protocol != "leader" {
+		return &protocol.Layout{Kind: protocol.LayoutRing, Order: append([]string{}, s.regions...)}
+	}
+	order := make([]string, 0, len(s.regions))
+	for _, region := range s.regions {
+		if region != s.leader {
+			order = append(order, region)
+		}
+	}
+	return &protocol.Layout{
+		Kind:   protocol.LayoutStar,
+		Center: s.leader,
+		Order:  order,
+	}
+}
+
+// CrashNode crashes a replica and its client
+func (s *Simulation) CrashNode(nodeID string) error {
+	replica := s.findReplica(nodeID)
+	if replica == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	replica.crash()
+	return nil
+}
+
+// RecoverNode recovers a crashed replica
+func (s *Simulation) RecoverNode(nodeID string) error {
+	replica := s.findReplica(nodeID)
+	if replica == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	replica.recover()
+	return nil
+}
+
+// findReplica looks up a replica; the list is fixed after construction
+func (s *Simulation) findReplica(nodeID string) *Replica {
+	for _, replica := range s.replicas {
+		if replica.id == nodeID {
+			return replica
+		}
+	}
+	return nil
+}
+
+// now returns the modeled time for a client submitting a command
+func (s *Simulation) now() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.engine.GetVirtualTime().Sub(s.started).Milliseconds()
+}
+
+// advanceSchedule applies scripted faults
+func (s *Simulation) advanceSchedule(ticks int) {
+	s.mu.Lock()
+	due := make([]scriptedFault, 0)
+	remaining := s.script[:0]
+	for _, fault := range s.script {
+		if fault.tick <= ticks {
+			due = append(due, fault)
+		} else {
+			remaining = append(remaining, fault)
+		}
+	}
+	s.script = remaining
+	s.mu.Unlock()
+
+	for _, fault := range due {
+		if fault.action == "slow" {
+			s.transport.SetNodeDelay(fault.target, fault.delay)
+		} else {
+			s.transport.ClearNodeDelay(fault.target)
+		}
+		s.broadcast(map[string]interface{}{
+			"type":    "scripted_fault",
+			"action":  fault.action,
+			"nodeId":  fault.target,
+			"delayMs": fault.delay.Milliseconds(),
+		})
+	}
+}
+
+// send transmits a message and reports it to the frontend. A slow
+// replica's messages leave late in modeled time as well.
+func (s *Simulation) send(from, to string, msgType transport.MessageType, payload Payload) {
+	payload.At += s.transport.NodeDelay(from).Milliseconds()
+	env := transport.NewEnvelope(from, to, msgType, payload)
+
+	s.mu.Lock()
+	s.sent[from]++
+	s.mu.Unlock()
+
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageSent,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+
+	s.transport.Send(s.ctx, env)
+}
+
+// received reports a delivered message and returns its payload together
+// with the modeled time it arrived
+func (s *Simulation) received(env *transport.Envelope) (Payload, int64) {
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageReceived,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+	payload, _ := env.Payload.(Payload)
+	return payload, payload.At + delay(env.From, env.To)
+}
+
+// consistent reports whether the replicas executed the writes to every
+// key in the same order, i.e. each log is a prefix of the longest one
+func consistent(logs []map[string][]string) bool {
+	keys := make(map[string]bool)
+	for _, log := range logs {
+		for key := range log {
+			keys[key] = true
+		}
+	}
+
+	for key := range keys {
+		var longest []string
+		for _, log := range logs {
+			if len(log[key]) > len(longest) {
+				longest = log[key]
+			}
+		}
+		for _, log := range logs {
+			for i, id := range log[key] {
+				if longest[i] != id {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
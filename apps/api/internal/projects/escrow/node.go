@@ -0,0 +1,296 @@
+package escrow
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+const (
+	MsgDecrement    transport.MessageType = "decrement"
+	MsgDecrementAck transport.MessageType = "decrement_ack"
+	MsgShareRequest transport.MessageType = "share_request"
+	MsgShareGrant   transport.MessageType = "share_grant"
+)
+
+const (
+	maxOrder     = 3  // Largest decrement an order asks for
+	replyTicks   = 10 // Ticks a node waits for a reply before giving up on it
+	soldOutTicks = 20 // Ticks a node that found every share empty waits before asking again
+)
+
+// order is a client's request to take some units off the counter
+type order struct {
+	seq     int
+	amount  int
+	arrived int
+}
+
+// Node takes orders from its clients. With escrow, it owns a share of the
+// counter and serves an order from it without asking anyone; only when
+// the share cannot cover its orders does it ask its peers, one at a time,
+// to hand over part of theirs, and once all of them have answered empty
+// the counter is sold out. With a single leader, it forwards every order
+// to the leader, which holds the whole counter.
+type Node struct {
+	mu sync.RWMutex
+
+	id     string
+	status string // "running" or "crashed"
+	ticks  int
+
+	share   int     // Units this node may hand out on its own
+	pending []order // Orders waiting for units
+	seq     int
+
+	// Share request in flight, and how many peers in a row had nothing
+	asking  string
+	askedAt int
+	empty   int
+	soldOut int // Tick the node last found every share empty, 0 if it has not
+	next    int
+
+	forwarded map[int]order // Orders sent to the leader, by sequence
+
+	sold     int
+	rejected int
+
+	inbox      chan *transport.Envelope
+	simulation *Simulation
+}
+
+func newNode(id string, share int, sim *Simulation) *Node {
+	return &Node{
+		id:         id,
+		status:     "running",
+		share:      share,
+		forwarded:  make(map[int]order),
+		inbox:      make(chan *transport.Envelope, 500),
+		simulation: sim,
+	}
+}
+
+// Node implements engine.NodeController
+
+func (n *Node) ID() string {
+	return n.id
+}
+
+func (n *Node) Start(ctx context.Context) error {
+	return nil
+}
+
+func (n *Node) Stop() error {
+	return nil
+}
+
+func (n *Node) Tick() {
+	n.simulation.advanceSchedule(n.tick())
+}
+
+func (n *Node) tick() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.ticks++
+	if n.status == "crashed" {
+		return n.ticks
+	}
+
+	for i := 0; i < 50; i++ {
+		select {
+		case env := <-n.inbox:
+			n.processMessage(env)
+			continue
+		default:
+		}
+		break
+	}
+
+	sim := n.simulation
+	for i := sim.arrivals(n.id); i > 0; i-- {
+		n.seq++
+		n.pending = append(n.pending, order{seq: n.seq, amount: 1 + n.simulation.rng.Intn(maxOrder), arrived: n.ticks})
+	}
+
+	if sim.leaderID() == "" {
+		n.serveFromShare()
+	} else {
+		n.forward()
+	}
+	return n.ticks
+}
+
+func (n *Node) GetState() map[string]interface{} {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	role := "replica"
+	if n.id == n.simulation.leaderID() {
+		role = "leader"
+	}
+	return map[string]interface{}{
+		"id":        n.id,
+		"status":    n.status,
+		"role":      role,
+		"share":     n.share,
+		"pending":   len(n.pending),
+		"forwarded": len(n.forwarded),
+		"asking":    n.asking, // Peer asked for part of its share
+		"soldOut":   n.soldOut > 0,
+		"sold":      n.sold,
+		"rejected":  n.rejected,
+	}
+}
+
+func (n *Node) handleMessage(env *transport.Envelope) {
+	n.mu.Lock()
+	down := n.status == "crashed"
+	if down && env.Type == MsgShareGrant {
+		// A granted share is written down before the node went down, and
+		// is there when it comes back
+		amount := n.simulation.received(env).Amount
+		n.share += amount
+		n.simulation.landed(amount)
+	}
+	n.mu.Unlock()
+
+	if down {
+		return
+	}
+	select {
+	case n.inbox <- env:
+	default:
+	}
+}
+
+func (n *Node) processMessage(env *transport.Envelope) {
+	sim := n.simulation
+	payload := sim.received(env)
+
+	switch env.Type {
+	case MsgDecrement:
+		// Only the leader gets these: it decides alone
+		ok := n.share >= payload.Amount
+		if ok {
+			n.share -= payload.Amount
+			sim.decremented(payload.Amount)
+		}
+		sim.send(n.id, env.From, MsgDecrementAck, Payload{Seq: payload.Seq, Amount: payload.Amount, OK: ok})
+	case MsgDecrementAck:
+		o, waiting := n.forwarded[payload.Seq]
+		if !waiting {
+			return
+		}
+		delete(n.forwarded, payload.Seq)
+		n.finish(o, payload.OK)
+	case MsgShareRequest:
+		give := min(payload.Amount, (n.share+1)/2)
+		n.share -= give
+		sim.granted(n.id, env.From, give)
+		sim.send(n.id, env.From, MsgShareGrant, Payload{Amount: give})
+	case MsgShareGrant:
+		n.share += payload.Amount
+		sim.landed(payload.Amount)
+		if env.From != n.asking {
+			return
+		}
+		n.asking = ""
+		if payload.Amount > 0 {
+			n.empty, n.soldOut = 0, 0
+		} else {
+			n.answeredEmpty()
+		}
+	}
+}
+
+// serveFromShare serves the pending orders in arrival order while the
+// share covers them, and asks a peer for more when it does not (must
+// hold n.mu)
+func (n *Node) serveFromShare() {
+	for len(n.pending) > 0 && n.share >= n.pending[0].amount {
+		n.share -= n.pending[0].amount
+		n.simulation.decremented(n.pending[0].amount)
+		n.finish(n.pending[0], true)
+		n.pending = n.pending[1:]
+	}
+	if n.asking != "" && n.ticks-n.askedAt > replyTicks {
+		// A crashed peer does not answer, and its share is out of reach
+		n.asking = ""
+		n.answeredEmpty()
+	}
+	if len(n.pending) == 0 || n.asking != "" {
+		return
+	}
+	if n.soldOut > 0 {
+		for _, o := range n.pending {
+			n.finish(o, false)
+		}
+		n.pending = nil
+		if n.ticks-n.soldOut < soldOutTicks {
+			return
+		}
+		n.soldOut, n.empty = 0, 0
+		return
+	}
+
+	peers := n.simulation.peersOf(n.id)
+	want := -n.share
+	for _, o := range n.pending {
+		want += o.amount
+	}
+	n.asking = peers[n.next%len(peers)]
+	n.next++
+	n.askedAt = n.ticks
+	n.simulation.send(n.id, n.asking, MsgShareRequest, Payload{Amount: max(want, n.simulation.refill())})
+}
+
+// answeredEmpty counts a peer with nothing to give; once every peer in a
+// row had nothing, the counter is sold out (must hold n.mu)
+func (n *Node) answeredEmpty() {
+	n.empty++
+	if n.empty >= len(n.simulation.peersOf(n.id)) {
+		n.soldOut = n.ticks
+		n.simulation.soldOut(n.id)
+	}
+}
+
+// forward sends every pending order to the leader, or serves it when
+// this node is the leader, and gives up on orders the leader never
+// answered (must hold n.mu)
+func (n *Node) forward() {
+	sim := n.simulation
+	leader := sim.leaderID()
+	for _, o := range n.pending {
+		if n.id == leader {
+			ok := n.share >= o.amount
+			if ok {
+				n.share -= o.amount
+				sim.decremented(o.amount)
+			}
+			n.finish(o, ok)
+			continue
+		}
+		n.forwarded[o.seq] = o
+		sim.send(n.id, leader, MsgDecrement, Payload{Seq: o.seq, Amount: o.amount})
+	}
+	n.pending = nil
+
+	for seq, o := range n.forwarded {
+		if n.ticks-o.arrived > replyTicks {
+			delete(n.forwarded, seq)
+			n.finish(o, false)
+		}
+	}
+}
+
+// finish completes an order, served or rejected (must hold n.mu)
+func (n *Node) finish(o order, served bool) {
+	if served {
+		n.sold += o.amount
+	} else {
+		n.rejected++
+	}
+	n.simulation.orderFinished(served, n.ticks-o.arrived)
+}
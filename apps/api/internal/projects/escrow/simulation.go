@@ -0,0 +1,473 @@
+package escrow
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+	"github.com/ersantana/distributed-systems-learning/packages/simulation/engine"
+)
+
+// Payload is the content of all messages exchanged in this project
+type Payload struct {
+	Seq    int  `json:"seq,omitempty"`
+	Amount int  `json:"amount"`
+	OK     bool `json:"ok,omitempty"`
+}
+
+const (
+	initialStock = 1000 // Units on the counter at the start
+	demand       = 0.5  // Orders a node takes per tick, on average
+	hotFactor    = 4.0  // Demand on node-1 in the "hotspot" scenario, as a multiple of the rate
+	coldFactor   = 0.5  // Demand on every other node in the "hotspot" scenario
+)
+
+// Simulation runs a counter that must never go below zero, like the
+// stock of a flash sale, decremented by orders arriving at every node.
+// In "escrow" and "hotspot" the stock is split into a share per node:
+// an order its node's share covers is served on the spot, with no
+// message at all, and nodes only talk to move units to where the orders
+// are, once a share runs out. In "hotspot" most orders hit one node,
+// which keeps draining its peers' shares. In "leader" one node holds the
+// whole counter and every other node's order costs a round trip to it.
+type Simulation struct {
+	mu sync.RWMutex
+
+	engine    *engine.Engine
+	rng       *rand.Rand
+	transport *transport.NetworkTransport
+	broadcast func(interface{})
+
+	nodes    []*Node
+	scenario string
+	leader   string // Node holding the whole counter, none with escrow
+	demand   float64
+
+	stock     int // Units ever put on the counter
+	sold      int
+	inFlight  int // Units granted and not yet received
+	transfers int
+
+	orders    int
+	served    int
+	rejected  int
+	waitTicks int // Summed over the served orders
+	messages  int
+	lastTick  int
+
+	running bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// Config for escrow counter simulation
+type Config struct {
+	NodeCount int
+	Scenario  string // "escrow", "hotspot", "leader"
+}
+
+// NewSimulation creates a new escrow counter simulation
+func NewSimulation(eng *engine.Engine, trans *transport.NetworkTransport, broadcast func(interface{}), config Config) *Simulation {
+	switch config.Scenario {
+	case "escrow", "hotspot", "leader":
+	default:
+		config.Scenario = "escrow"
+	}
+	if config.NodeCount == 0 {
+		config.NodeCount = 5
+	}
+	config.NodeCount = max(config.NodeCount, 2)
+
+	sim := &Simulation{
+		engine:    eng,
+		rng:       eng.Rand(),
+		transport: trans,
+		broadcast: broadcast,
+		scenario:  config.Scenario,
+		demand:    demand,
+		stock:     initialStock,
+	}
+	if config.Scenario == "leader" {
+		sim.leader = "node-1"
+	}
+
+	trans.SetLatency(20*time.Millisecond, 80*time.Millisecond)
+	trans.SetPacketLoss(0)
+
+	for i := 0; i < config.NodeCount; i++ {
+		share := initialStock / config.NodeCount
+		if i == 0 {
+			share += initialStock % config.NodeCount
+		}
+		if sim.leader != "" {
+			share = 0
+			if i == 0 {
+				share = initialStock
+			}
+		}
+		node := newNode(fmt.Sprintf("node-%d", i+1), share, sim)
+		node.next = i // Start asking different peers
+		sim.nodes = append(sim.nodes, node)
+		trans.RegisterHandler(node.id, node.handleMessage)
+		eng.AddNode(node)
+	}
+
+	return sim
+}
+
+// Start starts the simulation
+func (s *Simulation) Start(ctx context.Context) error {
+	s.mu.Lock()
+	s.running = true
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.mu.Unlock()
+
+	return s.engine.Start(ctx)
+}
+
+// Stop stops the simulation
+func (s *Simulation) Stop() error {
+	s.mu.Lock()
+	s.running = false
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Unlock()
+
+	return s.engine.Stop()
+}
+
+// GetState returns the current simulation state
+func (s *Simulation) GetState() *protocol.SimulationStateResponse {
+	nodes := make(map[string]protocol.NodeState)
+	shares := make(map[string]int, len(s.nodes))
+	remaining := 0
+	for _, node := range s.nodes {
+		state := node.GetState()
+		nodes[node.id] = protocol.NodeState{
+			ID:          node.id,
+			Status:      state["status"].(string),
+			Role:        state["role"].(string),
+			CustomState: state,
+		}
+		shares[node.id] = state["share"].(int)
+		remaining += shares[node.id]
+	}
+
+	s.mu.RLock()
+	running := s.running
+	metadata := map[string]interface{}{
+		"scenario":         s.scenario,
+		"demand":           s.demand,
+		"stock":            s.stock,
+		"sold":             s.sold,
+		"remaining":        remaining,
+		"inFlight":         s.inFlight,
+		"shares":           shares,
+		"transfers":        s.transfers,
+		"orders":           s.orders,
+		"served":           s.served,
+		"rejected":         s.rejected,
+		"meanWaitTicks":    s.meanWait(),
+		"messages":         s.messages, // Every message here is coordination
+		"messagesPerOrder": s.messagesPerOrder(),
+	}
+	s.mu.RUnlock()
+
+	mode := "step"
+	if s.engine != nil {
+		mode = s.engine.GetMode().String()
+	}
+
+	return &protocol.SimulationStateResponse{
+		Type:        protocol.MsgSimulationState,
+		VirtualTime: time.Now().UnixMilli(),
+		Mode:        mode,
+		Speed:       1.0,
+		Running:     running,
+		Nodes:       nodes,
+		Metadata:    metadata,
+	}
+}
+
+// GetNodes returns node states
+func (s *Simulation) GetNodes() map[string]protocol.NodeState {
+	state := s.GetState()
+	return state.Nodes
+}
+
+// CrashNode crashes a node: its clients' orders are lost, its share stays
+// out of reach until it recovers
+func (s *Simulation) CrashNode(nodeID string) error {
+	node := s.findNode(nodeID)
+	if node == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	node.mu.Lock()
+	node.status = "crashed"
+	node.pending = nil
+	node.forwarded = make(map[int]order)
+	node.asking = ""
+	node.mu.Unlock()
+	return nil
+}
+
+// RecoverNode recovers a crashed node, with the share it had
+func (s *Simulation) RecoverNode(nodeID string) error {
+	node := s.findNode(nodeID)
+	if node == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	node.mu.Lock()
+	node.status = "running"
+	node.mu.Unlock()
+	return nil
+}
+
+// HandleClientRequest runs a client command: "restock" adds units to the
+// counter, split over the running nodes' shares or given to the leader,
+// {amount}; "set_demand" sets the orders each node takes per tick,
+// {rate}
+func (s *Simulation) HandleClientRequest(command string, payload map[string]interface{}) error {
+	switch command {
+	case "restock":
+		amount, _ := payload["amount"].(float64)
+		if amount < 1 {
+			return fmt.Errorf("amount must be at least 1, got %v", amount)
+		}
+		s.restock(int(amount))
+		return nil
+	case "set_demand":
+		rate, _ := payload["rate"].(float64)
+		if rate <= 0 || rate > 5 {
+			return fmt.Errorf("rate must be in (0, 5], got %v", rate)
+		}
+		s.mu.Lock()
+		s.demand = rate
+		s.mu.Unlock()
+		s.broadcast(map[string]interface{}{
+			"type": "demand_changed",
+			"rate": rate,
+		})
+		return nil
+	}
+	return fmt.Errorf("unknown command: %s", command)
+}
+
+// restock adds units to the counter
+func (s *Simulation) restock(amount int) {
+	receivers := make([]*Node, 0, len(s.nodes))
+	for _, node := range s.nodes {
+		node.mu.RLock()
+		up := node.status == "running"
+		node.mu.RUnlock()
+		if up && (s.leader == "" || node.id == s.leader) {
+			receivers = append(receivers, node)
+		}
+	}
+	if len(receivers) == 0 {
+		return
+	}
+	for i, node := range receivers {
+		share := amount / len(receivers)
+		if i == 0 {
+			share += amount % len(receivers)
+		}
+		node.mu.Lock()
+		node.share += share
+		node.soldOut, node.empty = 0, 0
+		node.mu.Unlock()
+	}
+
+	s.mu.Lock()
+	s.stock += amount
+	s.mu.Unlock()
+	s.broadcast(map[string]interface{}{
+		"type":   "restocked",
+		"amount": amount,
+	})
+}
+
+// findNode looks up a node by ID
+func (s *Simulation) findNode(nodeID string) *Node {
+	for _, node := range s.nodes {
+		if node.id == nodeID {
+			return node
+		}
+	}
+	return nil
+}
+
+// leaderID returns the node holding the whole counter, or "" with escrow
+func (s *Simulation) leaderID() string {
+	return s.leader
+}
+
+// peersOf returns every node but one
+func (s *Simulation) peersOf(nodeID string) []string {
+	peers := make([]string, 0, len(s.nodes)-1)
+	for _, node := range s.nodes {
+		if node.id != nodeID {
+			peers = append(peers, node.id)
+		}
+	}
+	return peers
+}
+
+// refill returns the least a node asks a peer for, so that a transfer
+// covers more than the order that ran the share out
+func (s *Simulation) refill() int {
+	return initialStock / len(s.nodes) / 4
+}
+
+// arrivals returns how many orders reach a node in a tick
+func (s *Simulation) arrivals(nodeID string) int {
+	s.mu.RLock()
+	rate := s.demand
+	s.mu.RUnlock()
+
+	if s.scenario == "hotspot" {
+		factor := coldFactor
+		if nodeID == s.nodes[0].id {
+			factor = hotFactor
+		}
+		rate *= factor
+	}
+	n := int(rate)
+	if s.rng.Float64() < rate-float64(n) {
+		n++
+	}
+	return n
+}
+
+// advanceSchedule streams the shares, once per tick
+func (s *Simulation) advanceSchedule(ticks int) {
+	s.mu.Lock()
+	if ticks <= s.lastTick {
+		s.mu.Unlock()
+		return
+	}
+	s.lastTick = ticks
+	s.mu.Unlock()
+
+	shares := make(map[string]int, len(s.nodes))
+	for _, node := range s.nodes {
+		node.mu.RLock()
+		shares[node.id] = node.share
+		node.mu.RUnlock()
+	}
+	s.broadcast(map[string]interface{}{
+		"type":   "shares",
+		"shares": shares,
+	})
+}
+
+// decremented records units taken off the counter
+func (s *Simulation) decremented(amount int) {
+	s.mu.Lock()
+	s.sold += amount
+	s.mu.Unlock()
+}
+
+// orderFinished records an order served or rejected, and how long it
+// waited
+func (s *Simulation) orderFinished(served bool, wait int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.orders++
+	if served {
+		s.served++
+		s.waitTicks += wait
+	} else {
+		s.rejected++
+	}
+}
+
+// granted reports part of a share leaving a node for another
+func (s *Simulation) granted(from, to string, amount int) {
+	s.mu.Lock()
+	s.inFlight += amount
+	if amount > 0 {
+		s.transfers++
+	}
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":   "share_transfer",
+		"nodeId": from,
+		"to":     to,
+		"amount": amount,
+	})
+}
+
+// landed records granted units reaching their node
+func (s *Simulation) landed(amount int) {
+	s.mu.Lock()
+	s.inFlight -= amount
+	s.mu.Unlock()
+}
+
+// soldOut reports a node finding every peer's share empty
+func (s *Simulation) soldOut(nodeID string) {
+	s.broadcast(map[string]interface{}{
+		"type":   "sold_out",
+		"nodeId": nodeID,
+	})
+}
+
+// meanWait returns the ticks a served order waited on average (must hold
+// s.mu)
+func (s *Simulation) meanWait() float64 {
+	if s.served == 0 {
+		return 0
+	}
+	return float64(s.waitTicks) / float64(s.served)
+}
+
+// messagesPerOrder returns the coordination messages per order (must
+// hold s.mu)
+func (s *Simulation) messagesPerOrder() float64 {
+	if s.orders == 0 {
+		return 0
+	}
+	return float64(s.messages) / float64(s.orders)
+}
+
+// send transmits a message and reports it to the frontend
+func (s *Simulation) send(from, to string, msgType transport.MessageType, payload Payload) {
+	env := transport.NewEnvelope(from, to, msgType, payload)
+
+	s.mu.Lock()
+	s.messages++
+	s.mu.Unlock()
+
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageSent,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+
+	s.transport.Send(s.ctx, env)
+}
+
+// received reports a delivered message and returns its payload
+func (s *Simulation) received(env *transport.Envelope) Payload {
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageReceived,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+	payload, _ := env.Payload.(Payload)
+	return payload
+}
@@ -0,0 +1,57 @@
+package escrow
+
+import (
+	"fmt"
+
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+)
+
+const maxMessagesPerOrder = 0.5 // Coordination an order may cost on average
+
+// Invariants checks that the counter never goes below zero nor loses
+// units in transfers, and counts what the orders cost in coordination
+func (s *Simulation) Invariants() []protocol.InvariantResult {
+	// Lock every node, then the simulation, for a consistent ledger
+	remaining := 0
+	for _, node := range s.nodes {
+		node.mu.RLock()
+		defer node.mu.RUnlock()
+		remaining += node.share
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	balanced := s.sold+remaining+s.inFlight == s.stock
+	perOrder := s.messagesPerOrder()
+	return []protocol.InvariantResult{
+		{
+			Name:   "counter never goes below zero",
+			Holds:  s.sold <= s.stock && remaining >= 0,
+			Detail: fmt.Sprintf("%d of %d units sold, %d orders rejected", s.sold, s.stock, s.rejected),
+		},
+		{
+			Name:   "transfers conserve units",
+			Holds:  balanced,
+			Detail: fmt.Sprintf("%d sold + %d in shares + %d in flight = %d (stock %d)", s.sold, remaining, s.inFlight, s.sold+remaining+s.inFlight, s.stock),
+		},
+		{
+			Name:   "orders rarely need coordination",
+			Holds:  perOrder <= maxMessagesPerOrder,
+			Detail: fmt.Sprintf("%.2f messages per order (%d for %d orders, limit %.1f), %d share transfers", perOrder, s.messages, s.orders, maxMessagesPerOrder, s.transfers),
+		},
+	}
+}
+
+// FollowUps suggests the scenarios that contrast with this one
+func (s *Simulation) FollowUps() []protocol.FollowUp {
+	if s.scenario == "leader" {
+		return []protocol.FollowUp{
+			{Project: "escrow", Scenario: "escrow", Reason: "Split the counter into shares and serve orders locally"},
+			{Project: "crdt", Reason: "Count without any coordination, when going below zero is allowed"},
+		}
+	}
+	return []protocol.FollowUp{
+		{Project: "escrow", Scenario: "leader", Reason: "Send every order to one node holding the whole counter"},
+		{Project: "crdt", Reason: "Count without any coordination, when going below zero is allowed"},
+	}
+}
@@ -0,0 +1,125 @@
+package hashring
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// point is one position a server takes on the ring. With virtual nodes a
+// server takes several, each owning the arc of keys that ends at it.
+type point struct {
+	Position uint32 `json:"position"`
+	Server   string `json:"server"`
+	Vnode    int    `json:"vnode"`
+}
+
+// ring maps keys to servers, either by consistent hashing or, for
+// comparison, by the hash modulo the number of servers
+type ring struct {
+	strategy string // "consistent" or "modulo"
+	vnodes   int
+	servers  []string // Sorted
+	points   []point  // Sorted by position
+}
+
+func newRing(strategy string, vnodes int) *ring {
+	return &ring{strategy: strategy, vnodes: vnodes}
+}
+
+// hash places a key or a virtual node on the ring. Names differing only
+// in a suffix, as virtual nodes do, must land far apart: a fast hash like
+// FNV would cluster them.
+func hash(name string) uint32 {
+	sum := sha1.Sum([]byte(name))
+	return binary.BigEndian.Uint32(sum[:4])
+}
+
+// fraction returns a ring position as a fraction of the ring
+func fraction(position uint32) float64 {
+	return float64(position) / (1 << 32)
+}
+
+// add puts a server on the ring
+func (r *ring) add(server string) {
+	r.servers = append(r.servers, server)
+	sort.Strings(r.servers)
+	for i := 0; i < r.vnodes; i++ {
+		r.points = append(r.points, point{Position: hash(fmt.Sprintf("%s#%d", server, i)), Server: server, Vnode: i})
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i].Position < r.points[j].Position })
+}
+
+// remove takes a server off the ring
+func (r *ring) remove(server string) {
+	servers := r.servers[:0]
+	for _, s := range r.servers {
+		if s != server {
+			servers = append(servers, s)
+		}
+	}
+	r.servers = servers
+
+	points := r.points[:0]
+	for _, p := range r.points {
+		if p.Server != server {
+			points = append(points, p)
+		}
+	}
+	r.points = points
+}
+
+// setVnodes changes the virtual nodes per server, placing every server
+// again
+func (r *ring) setVnodes(vnodes int) {
+	servers := r.servers
+	r.vnodes = vnodes
+	r.servers = nil
+	r.points = nil
+	for _, server := range servers {
+		r.add(server)
+	}
+}
+
+// owner returns the server a key belongs to, "" with no server
+func (r *ring) owner(key string) string {
+	if len(r.servers) == 0 {
+		return ""
+	}
+	h := hash(key)
+	if r.strategy == "modulo" {
+		return r.servers[h%uint32(len(r.servers))]
+	}
+	// The first point at or after the key, wrapping around
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i].Position >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.points[i].Server
+}
+
+// shares returns the fraction of the ring each server owns, which is the
+// share of keys it can expect
+func (r *ring) shares() map[string]float64 {
+	shares := make(map[string]float64, len(r.servers))
+	for _, server := range r.servers {
+		shares[server] = 0
+	}
+	if r.strategy == "modulo" {
+		for _, server := range r.servers {
+			shares[server] = 1 / float64(len(r.servers))
+		}
+		return shares
+	}
+	for i, p := range r.points {
+		prev := r.points[(i+len(r.points)-1)%len(r.points)].Position
+		arc := p.Position - prev // Wraps around for the first point
+		if len(r.points) == 1 {
+			shares[p.Server] = 1
+			continue
+		}
+		shares[p.Server] += fraction(arc)
+	}
+	return shares
+}
@@ -0,0 +1,137 @@
+package hashring
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+const MsgTransfer transport.MessageType = "transfer"
+
+// Server stores the keys the ring assigns to it. When the ring changes it
+// hands the keys it no longer owns to their new owner.
+type Server struct {
+	mu sync.RWMutex
+
+	id     string
+	status string // "running", "crashed" or "outside" while off the ring
+	keys   map[string]bool
+	ticks  int
+
+	inbox      chan *transport.Envelope
+	simulation *Simulation
+}
+
+func newServer(id string, sim *Simulation) *Server {
+	return &Server{
+		id:         id,
+		status:     "outside",
+		keys:       make(map[string]bool),
+		inbox:      make(chan *transport.Envelope, 500),
+		simulation: sim,
+	}
+}
+
+// Server implements engine.NodeController
+
+func (n *Server) ID() string {
+	return n.id
+}
+
+func (n *Server) Start(ctx context.Context) error {
+	return nil
+}
+
+func (n *Server) Stop() error {
+	return nil
+}
+
+func (n *Server) Tick() {
+	n.simulation.advanceSchedule(n.tick())
+}
+
+func (n *Server) tick() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.ticks++
+	// Off the ring, a server still forwards the keys sent to it late
+	if n.status == "crashed" {
+		return n.ticks
+	}
+
+	for i := 0; i < 50; i++ {
+		select {
+		case env := <-n.inbox:
+			n.processMessage(env)
+			continue
+		default:
+		}
+		break
+	}
+	return n.ticks
+}
+
+func (n *Server) GetState() map[string]interface{} {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	return map[string]interface{}{
+		"id":     n.id,
+		"status": n.status,
+		"keys":   len(n.keys),
+	}
+}
+
+func (n *Server) handleMessage(env *transport.Envelope) {
+	n.mu.RLock()
+	down := n.status == "crashed"
+	n.mu.RUnlock()
+
+	if down {
+		n.simulation.landed(env)
+		return
+	}
+	select {
+	case n.inbox <- env:
+	default:
+		n.simulation.landed(env)
+	}
+}
+
+func (n *Server) processMessage(env *transport.Envelope) {
+	payload := n.simulation.received(env)
+
+	if env.Type != MsgTransfer {
+		return
+	}
+	// The ring may have changed again while the keys were on their way
+	forward := make(map[string][]string)
+	for _, key := range payload.Keys {
+		owner := n.simulation.ownerOf(key)
+		switch owner {
+		case n.id:
+			n.keys[key] = true
+		case "":
+			// Lost with the server it was meant for
+		default:
+			forward[owner] = append(forward[owner], key)
+		}
+	}
+	for _, to := range sortedKeys(forward) {
+		n.simulation.send(n.id, to, MsgTransfer, Payload{Keys: forward[to]})
+	}
+}
+
+// handOver removes the keys that now belong to other servers and sends
+// them there, one transfer per destination (must hold n.mu)
+func (n *Server) handOver(moves map[string][]string) {
+	for _, to := range sortedKeys(moves) {
+		keys := moves[to]
+		for _, key := range keys {
+			delete(n.keys, key)
+		}
+		n.simulation.send(n.id, to, MsgTransfer, Payload{Keys: keys})
+	}
+}
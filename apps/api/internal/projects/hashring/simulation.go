@@ -0,0 +1,682 @@
+package hashring
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+	"github.com/ersantana/distributed-systems-learning/packages/simulation/engine"
+)
+
+const (
+	initialKeys   = 400  // Keys stored before the first tick
+	maxKeys       = 2000 // Keys stored before the writes stop
+	changeEvery   = 60   // Ticks between servers joining and leaving
+	spareServers  = 2    // Servers off the ring at the start, to add
+	minServers    = 2    // Servers the schedule never goes below
+	virtualNodes  = 32   // Virtual nodes per server in "virtual_nodes"
+	maxVnodes     = 256
+	keptMovements = 20
+)
+
+// Payload is the content of all messages exchanged in this project
+type Payload struct {
+	Keys []string `json:"keys"`
+}
+
+// Movement describes the keys moved by one change of the ring
+type Movement struct {
+	Change   string         `json:"change"` // "add", "remove", "crash" or "vnodes"
+	Server   string         `json:"server,omitempty"`
+	Moved    int            `json:"moved"`
+	Lost     int            `json:"lost,omitempty"` // Keys of a crashed server
+	Total    int            `json:"total"`
+	Fraction float64        `json:"fraction"`
+	Ideal    float64        `json:"ideal"`  // Fraction a perfect scheme moves: the changed server's share
+	Pairs    map[string]int `json:"pairs"`  // Keys moved by "from->to"
+	Others   int            `json:"others"` // Keys moved between servers the change did not involve
+	Tick     int            `json:"tick"`
+}
+
+// Simulation places keys on servers by consistent hashing. Servers and
+// keys hash onto the same ring and a key belongs to the first server
+// after it, so adding a server only takes keys from its successor and
+// removing one only gives its keys to its successor: about 1/N of the
+// keys move, where hashing modulo N moves almost all of them, as the
+// "modulo" scenario shows.
+//
+// With one position per server the arcs, and so the loads, are very
+// uneven. Virtual nodes give every server many positions; the load of a
+// server is then the sum of many small arcs and evens out, and the keys
+// of a leaving server spread over all the others instead of one.
+type Simulation struct {
+	mu sync.RWMutex
+
+	engine    *engine.Engine
+	rng       *rand.Rand
+	transport *transport.NetworkTransport
+	broadcast func(interface{})
+
+	nodes    []*Server
+	scenario string
+	ring     *ring
+	keys     []string          // Every key stored, in the order written
+	assigned map[string]string // Owner of every key not lost
+	moving   map[string]int    // Transfers on their way with each key
+	nextKey  int
+	lost     int
+
+	movements  []Movement // Most recent last
+	totalMoved int
+	messages   map[string]int
+
+	lastTick   int
+	nextChange int
+	adding     bool // The next scheduled change adds a server
+
+	running bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// Config for consistent hashing simulation
+type Config struct {
+	NodeCount    int
+	VirtualNodes int    // Per server, overrides the scenario's
+	Scenario     string // "add_remove", "virtual_nodes", "modulo"
+}
+
+// NewSimulation creates a new consistent hashing simulation
+func NewSimulation(eng *engine.Engine, trans *transport.NetworkTransport, broadcast func(interface{}), config Config) *Simulation {
+	switch config.Scenario {
+	case "add_remove", "virtual_nodes", "modulo":
+	default:
+		config.Scenario = "add_remove"
+	}
+	if config.NodeCount == 0 {
+		config.NodeCount = 4
+	}
+	if config.VirtualNodes == 0 {
+		config.VirtualNodes = 1
+		if config.Scenario == "virtual_nodes" {
+			config.VirtualNodes = virtualNodes
+		}
+	}
+	strategy := "consistent"
+	if config.Scenario == "modulo" {
+		strategy = "modulo"
+	}
+
+	sim := &Simulation{
+		engine:     eng,
+		rng:        eng.Rand(),
+		transport:  trans,
+		broadcast:  broadcast,
+		scenario:   config.Scenario,
+		ring:       newRing(strategy, min(config.VirtualNodes, maxVnodes)),
+		keys:       make([]string, 0),
+		assigned:   make(map[string]string),
+		moving:     make(map[string]int),
+		movements:  make([]Movement, 0),
+		messages:   make(map[string]int),
+		nextChange: changeEvery,
+		adding:     true,
+	}
+
+	trans.SetLatency(20*time.Millisecond, 80*time.Millisecond)
+	trans.SetPacketLoss(0)
+
+	for i := 0; i < config.NodeCount+spareServers; i++ {
+		server := newServer(fmt.Sprintf("server-%d", i+1), sim)
+		sim.nodes = append(sim.nodes, server)
+		trans.RegisterHandler(server.id, server.handleMessage)
+		eng.AddNode(server)
+	}
+	for _, server := range sim.nodes[:config.NodeCount] {
+		server.status = "running"
+		sim.ring.add(server.id)
+	}
+	for i := 0; i < initialKeys; i++ {
+		sim.putLocked(sim.newKey())
+	}
+
+	return sim
+}
+
+// Start starts the simulation
+func (s *Simulation) Start(ctx context.Context) error {
+	s.mu.Lock()
+	s.running = true
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.mu.Unlock()
+
+	return s.engine.Start(ctx)
+}
+
+// Stop stops the simulation
+func (s *Simulation) Stop() error {
+	s.mu.Lock()
+	s.running = false
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Unlock()
+
+	return s.engine.Stop()
+}
+
+// GetState returns the current simulation state
+func (s *Simulation) GetState() *protocol.SimulationStateResponse {
+	nodes := make(map[string]protocol.NodeState)
+	stored := make(map[string]int)
+	for _, server := range s.nodes {
+		state := server.GetState()
+		role := "server"
+		if state["status"].(string) == "outside" {
+			role = "spare"
+		}
+		nodes[server.id] = protocol.NodeState{
+			ID:          server.id,
+			Status:      state["status"].(string),
+			Role:        role,
+			CustomState: state,
+		}
+		stored[server.id] = state["keys"].(int)
+	}
+
+	s.mu.RLock()
+	running := s.running
+	counts := s.keyCounts()
+	spread, maxOverMean := loadStats(counts)
+	messages := make(map[string]int, len(s.messages))
+	for msgType, count := range s.messages {
+		messages[msgType] = count
+	}
+	metadata := map[string]interface{}{
+		"scenario":    s.scenario,
+		"strategy":    s.ring.strategy,
+		"vnodes":      s.ring.vnodes,
+		"servers":     append([]string{}, s.ring.servers...),
+		"points":      append([]point{}, s.ring.points...),
+		"shares":      s.ring.shares(),
+		"keys":        len(s.assigned),
+		"keysLost":    s.lost,
+		"keyCounts":   counts,
+		"keysStored":  stored, // Lags keyCounts while transfers are in flight
+		"loadSpread":  spread, // Standard deviation of the loads over their mean
+		"maxOverMean": maxOverMean,
+		"movements":   append([]Movement{}, s.movements...),
+		"totalMoved":  s.totalMoved,
+		"messages":    messages,
+	}
+	s.mu.RUnlock()
+
+	mode := "step"
+	if s.engine != nil {
+		mode = s.engine.GetMode().String()
+	}
+
+	return &protocol.SimulationStateResponse{
+		Type:        protocol.MsgSimulationState,
+		VirtualTime: time.Now().UnixMilli(),
+		Mode:        mode,
+		Speed:       1.0,
+		Running:     running,
+		Nodes:       nodes,
+		Metadata:    metadata,
+	}
+}
+
+// GetNodes returns node states
+func (s *Simulation) GetNodes() map[string]protocol.NodeState {
+	state := s.GetState()
+	return state.Nodes
+}
+
+// Layout places every server on the ring at its first position; the
+// metadata has all of them
+func (s *Simulation) Layout() *protocol.Layout {
+	positions := make(map[string]float64, len(s.nodes))
+	for _, server := range s.nodes {
+		positions[server.id] = fraction(hash(server.id + "#0"))
+	}
+	return &protocol.Layout{Kind: protocol.LayoutHashRing, Positions: positions}
+}
+
+// CrashNode crashes a server: it leaves the ring and its keys are lost
+func (s *Simulation) CrashNode(nodeID string) error {
+	server := s.findNode(nodeID)
+	if server == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	server.mu.Lock()
+	wasRunning := server.status == "running"
+	server.status = "crashed"
+	server.keys = make(map[string]bool)
+	server.mu.Unlock()
+
+	if wasRunning {
+		s.change("crash", nodeID)
+	}
+	return nil
+}
+
+// RecoverNode brings a crashed server back on the ring, empty
+func (s *Simulation) RecoverNode(nodeID string) error {
+	server := s.findNode(nodeID)
+	if server == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	server.mu.Lock()
+	crashed := server.status == "crashed"
+	if crashed {
+		server.status = "running"
+	}
+	server.mu.Unlock()
+
+	if crashed {
+		s.change("add", nodeID)
+	}
+	return nil
+}
+
+// HandleClientRequest changes the ring. Commands are "put" (payload:
+// key), "add_node" (payload: optional nodeId of a spare server),
+// "remove_node" (payload: nodeId) and "set_vnodes" (payload: count).
+func (s *Simulation) HandleClientRequest(command string, payload map[string]interface{}) error {
+	nodeID, _ := payload["nodeId"].(string)
+
+	switch command {
+	case "put":
+		key, _ := payload["key"].(string)
+		if key == "" {
+			return fmt.Errorf("missing key")
+		}
+		s.put(key)
+
+	case "add_node":
+		if nodeID == "" {
+			nodeID = s.spare()
+		}
+		return s.addServer(nodeID)
+
+	case "remove_node":
+		return s.removeServer(nodeID)
+
+	case "set_vnodes":
+		count, _ := payload["count"].(float64)
+		if count < 1 || count > maxVnodes {
+			return fmt.Errorf("count must be between 1 and %d", maxVnodes)
+		}
+		s.mu.Lock()
+		if s.ring.strategy == "modulo" {
+			s.mu.Unlock()
+			return fmt.Errorf("hashing modulo the servers has no virtual nodes")
+		}
+		s.ring.setVnodes(int(count))
+		s.mu.Unlock()
+		s.change("vnodes", "")
+
+	default:
+		return fmt.Errorf("unknown command: %s", command)
+	}
+	return nil
+}
+
+// findNode looks up a server by ID; the node list is fixed after
+// construction
+func (s *Simulation) findNode(nodeID string) *Server {
+	for _, server := range s.nodes {
+		if server.id == nodeID {
+			return server
+		}
+	}
+	return nil
+}
+
+// advanceSchedule writes a key every tick until maxKeys and alternately
+// adds and removes a server every changeEvery ticks
+func (s *Simulation) advanceSchedule(ticks int) {
+	s.mu.Lock()
+	if ticks <= s.lastTick {
+		s.mu.Unlock()
+		return
+	}
+	first := s.lastTick == 0
+	s.lastTick = ticks
+	write := len(s.keys) < maxKeys
+	key := ""
+	if write {
+		key = s.newKey()
+	}
+	change := ticks >= s.nextChange
+	adding := s.adding
+	if change {
+		s.nextChange = ticks + changeEvery
+		s.adding = !s.adding
+	}
+	servers := append([]string{}, s.ring.servers...)
+	s.mu.Unlock()
+
+	if first {
+		s.reportDistribution()
+	}
+	if write {
+		s.put(key)
+	}
+	if !change {
+		return
+	}
+	if adding {
+		if nodeID := s.spare(); nodeID != "" {
+			s.addServer(nodeID)
+		}
+	} else if len(servers) > minServers {
+		s.removeServer(servers[s.rng.Intn(len(servers))])
+	}
+}
+
+// addServer puts a spare server on the ring
+func (s *Simulation) addServer(nodeID string) error {
+	server := s.findNode(nodeID)
+	if server == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	server.mu.Lock()
+	if server.status != "outside" {
+		server.mu.Unlock()
+		return fmt.Errorf("server %s is not a spare", nodeID)
+	}
+	server.status = "running"
+	server.mu.Unlock()
+
+	s.change("add", nodeID)
+	return nil
+}
+
+// removeServer takes a server off the ring; it hands its keys over first
+func (s *Simulation) removeServer(nodeID string) error {
+	server := s.findNode(nodeID)
+	if server == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	server.mu.Lock()
+	if server.status != "running" {
+		server.mu.Unlock()
+		return fmt.Errorf("server %s is not on the ring", nodeID)
+	}
+	server.status = "outside"
+	server.mu.Unlock()
+
+	s.change("remove", nodeID)
+	return nil
+}
+
+// spare returns a server off the ring, "" if there is none
+func (s *Simulation) spare() string {
+	for _, server := range s.nodes {
+		server.mu.RLock()
+		outside := server.status == "outside"
+		server.mu.RUnlock()
+		if outside {
+			return server.id
+		}
+	}
+	return ""
+}
+
+// change applies a change of the ring: it reassigns every key, has the
+// old owners send the moved keys to the new ones and reports how many
+// moved compared to the ideal
+func (s *Simulation) change(kind, nodeID string) {
+	s.mu.Lock()
+	before := len(s.ring.servers)
+	share := s.ring.shares()[nodeID]
+	switch kind {
+	case "add":
+		s.ring.add(nodeID)
+		share = s.ring.shares()[nodeID]
+	case "remove", "crash":
+		s.ring.remove(nodeID)
+	}
+
+	movement := Movement{Change: kind, Server: nodeID, Total: len(s.assigned), Pairs: make(map[string]int), Tick: s.lastTick}
+	switch {
+	case kind == "vnodes":
+		movement.Ideal = 0
+	case s.ring.strategy == "modulo" && kind == "add":
+		movement.Ideal = 1 / float64(len(s.ring.servers))
+	case s.ring.strategy == "modulo":
+		movement.Ideal = 1 / float64(before)
+	default:
+		movement.Ideal = share
+	}
+
+	moves := make(map[string]map[string][]string) // From, to, keys
+	for _, key := range s.keys {
+		from, ok := s.assigned[key]
+		if !ok {
+			continue
+		}
+		if kind == "crash" && from == nodeID {
+			delete(s.assigned, key)
+			movement.Lost++
+			continue
+		}
+		to := s.ring.owner(key)
+		if to == from {
+			continue
+		}
+		s.assigned[key] = to
+		if moves[from] == nil {
+			moves[from] = make(map[string][]string)
+		}
+		moves[from][to] = append(moves[from][to], key)
+		movement.Moved++
+		movement.Pairs[from+"->"+to]++
+		if from != nodeID && to != nodeID {
+			movement.Others++
+		}
+	}
+	if movement.Total > 0 {
+		movement.Fraction = float64(movement.Moved) / float64(movement.Total)
+	}
+	s.lost += movement.Lost
+	s.totalMoved += movement.Moved
+	s.movements = append(s.movements, movement)
+	if len(s.movements) > keptMovements {
+		s.movements = s.movements[1:]
+	}
+	s.mu.Unlock()
+
+	// The servers move their keys themselves, in the same order every run
+	for _, from := range sortedKeys(moves) {
+		destinations := moves[from]
+		if server := s.findNode(from); server != nil {
+			server.mu.Lock()
+			server.handOver(destinations)
+			server.mu.Unlock()
+		}
+	}
+
+	s.broadcast(map[string]interface{}{
+		"type":     "keys_moved",
+		"change":   kind,
+		"nodeId":   nodeID,
+		"moved":    movement.Moved,
+		"lost":     movement.Lost,
+		"total":    movement.Total,
+		"fraction": movement.Fraction,
+		"ideal":    movement.Ideal,
+		"pairs":    movement.Pairs,
+		"others":   movement.Others,
+	})
+	s.reportDistribution()
+}
+
+// reportDistribution broadcasts the keys each server owns
+func (s *Simulation) reportDistribution() {
+	s.mu.RLock()
+	counts := s.keyCounts()
+	s.mu.RUnlock()
+
+	spread, maxOverMean := loadStats(counts)
+	s.broadcast(map[string]interface{}{
+		"type":        "key_distribution",
+		"keyCounts":   counts,
+		"loadSpread":  spread,
+		"maxOverMean": maxOverMean,
+	})
+}
+
+// newKey names the next key to write (must hold s.mu)
+func (s *Simulation) newKey() string {
+	s.nextKey++
+	return fmt.Sprintf("key-%d", s.nextKey)
+}
+
+// put stores a key on its owner
+func (s *Simulation) put(key string) {
+	s.mu.Lock()
+	owner := s.putLocked(key)
+	s.mu.Unlock()
+
+	if server := s.findNode(owner); server != nil {
+		server.mu.Lock()
+		server.keys[key] = true
+		server.mu.Unlock()
+	}
+}
+
+// putLocked assigns a key to its owner and returns it; before the start
+// it also stores it there (must hold s.mu)
+func (s *Simulation) putLocked(key string) string {
+	owner := s.ring.owner(key)
+	if owner == "" {
+		return ""
+	}
+	if _, exists := s.assigned[key]; !exists {
+		s.keys = append(s.keys, key)
+	}
+	s.assigned[key] = owner
+	if !s.running {
+		s.findNode(owner).keys[key] = true
+	}
+	return owner
+}
+
+// ownerOf returns the server a key is assigned to, "" if it was lost
+func (s *Simulation) ownerOf(key string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.assigned[key]
+}
+
+// keyCounts returns the keys assigned to each server on the ring (must
+// hold s.mu)
+func (s *Simulation) keyCounts() map[string]int {
+	counts := make(map[string]int, len(s.ring.servers))
+	for _, server := range s.ring.servers {
+		counts[server] = 0
+	}
+	for _, owner := range s.assigned {
+		counts[owner]++
+	}
+	return counts
+}
+
+// loadStats returns the standard deviation of the loads relative to
+// their mean, and the highest load relative to the mean
+func loadStats(counts map[string]int) (spread, maxOverMean float64) {
+	if len(counts) == 0 {
+		return 0, 0
+	}
+	total, highest := 0, 0
+	for _, count := range counts {
+		total += count
+		highest = max(highest, count)
+	}
+	mean := float64(total) / float64(len(counts))
+	if mean == 0 {
+		return 0, 0
+	}
+	variance := 0.0
+	for _, count := range counts {
+		variance += (float64(count) - mean) * (float64(count) - mean)
+	}
+	variance /= float64(len(counts))
+	return math.Sqrt(variance) / mean, float64(highest) / mean
+}
+
+// send transmits a message and reports it to the frontend
+func (s *Simulation) send(from, to string, msgType transport.MessageType, payload Payload) {
+	env := transport.NewEnvelope(from, to, msgType, payload)
+
+	s.mu.Lock()
+	s.messages[string(msgType)]++
+	if msgType == MsgTransfer {
+		for _, key := range payload.Keys {
+			s.moving[key]++
+		}
+	}
+	s.mu.Unlock()
+
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageSent,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+
+	s.transport.Send(s.ctx, env)
+}
+
+// received reports a delivered message and returns its payload
+func (s *Simulation) received(env *transport.Envelope) Payload {
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageReceived,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+	payload, _ := env.Payload.(Payload)
+	s.landed(env)
+	return payload
+}
+
+// landed counts the keys of a transfer as no longer on their way, once
+// it is received or dropped
+func (s *Simulation) landed(env *transport.Envelope) {
+	if env.Type != MsgTransfer {
+		return
+	}
+	payload, _ := env.Payload.(Payload)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range payload.Keys {
+		if s.moving[key]--; s.moving[key] <= 0 {
+			delete(s.moving, key)
+		}
+	}
+}
+
+// sortedKeys returns the keys of a map in order, to range over it the
+// same way every run
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,80 @@
+package hashring
+
+import (
+	"fmt"
+
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+)
+
+// Invariants judges the run: every key ended up on its owner, unless a
+// transfer is still taking it there, and the changes of the ring only
+// moved keys to or from the server that joined or left, which hashing
+// modulo the servers cannot promise
+func (s *Simulation) Invariants() []protocol.InvariantResult {
+	stored := make(map[string]map[string]bool)
+	for _, server := range s.nodes {
+		server.mu.RLock()
+		keys := make(map[string]bool, len(server.keys))
+		for key := range server.keys {
+			keys[key] = true
+		}
+		server.mu.RUnlock()
+		stored[server.id] = keys
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	misplaced, moving := 0, 0
+	for key, owner := range s.assigned {
+		switch {
+		case stored[owner][key]:
+		case s.moving[key] > 0:
+			moving++
+		default:
+			misplaced++
+		}
+	}
+	others, changes := 0, 0
+	for _, movement := range s.movements {
+		if movement.Change != "vnodes" {
+			others += movement.Others
+			changes++
+		}
+	}
+
+	return []protocol.InvariantResult{
+		{
+			Name:   "keys stored at their owner",
+			Holds:  misplaced == 0,
+			Detail: fmt.Sprintf("%d of %d keys not at their owner, %d more on their way", misplaced, len(s.assigned), moving),
+		},
+		{
+			Name:   "only the changed server's keys move",
+			Holds:  others == 0,
+			Detail: fmt.Sprintf("%d keys moved between unchanged servers over %d changes", others, changes),
+		},
+	}
+}
+
+// FollowUps suggests the scenarios that contrast with this one
+func (s *Simulation) FollowUps() []protocol.FollowUp {
+	switch s.scenario {
+	case "add_remove":
+		return []protocol.FollowUp{
+			{Project: "consistent-hashing", Scenario: "virtual_nodes", Reason: "Compare the load spread with 32 virtual nodes per server"},
+			{Project: "consistent-hashing", Scenario: "modulo", Reason: "Compare the keys moved when hashing modulo the servers"},
+		}
+	case "virtual_nodes":
+		return []protocol.FollowUp{
+			{Project: "consistent-hashing", Scenario: "add_remove", Reason: "Compare the load spread with a single position per server"},
+		}
+	case "modulo":
+		return []protocol.FollowUp{
+			{Project: "consistent-hashing", Scenario: "add_remove", Reason: "See how few keys move with consistent hashing"},
+		}
+	}
+	return []protocol.FollowUp{
+		{Project: "consistent-hashing", Scenario: "add_remove", Reason: "Add and remove servers and count the keys that move"},
+	}
+}
@@ -0,0 +1,170 @@
+package heartbeat
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+const MsgHeartbeat transport.MessageType = "heartbeat"
+
+// Node heartbeats every other node at a fixed interval and marks a peer
+// dead when nothing came from it within the timeout. A heartbeat from a
+// peer marked dead brings it back: the detector can only ever suspect,
+// since a crashed node and a slow one look the same from outside.
+type Node struct {
+	mu sync.RWMutex
+
+	id     string
+	status string // "running" or "crashed"
+	ticks  int
+	sent   int
+
+	lastHeard map[string]int // Peer -> tick its last heartbeat arrived
+	suspected map[string]bool
+
+	inbox      chan *transport.Envelope
+	simulation *Simulation
+}
+
+func newNode(id string, sim *Simulation) *Node {
+	return &Node{
+		id:         id,
+		status:     "running",
+		lastHeard:  make(map[string]int),
+		suspected:  make(map[string]bool),
+		inbox:      make(chan *transport.Envelope, 500),
+		simulation: sim,
+	}
+}
+
+// Node implements engine.NodeController
+
+func (n *Node) ID() string {
+	return n.id
+}
+
+func (n *Node) Start(ctx context.Context) error {
+	return nil
+}
+
+func (n *Node) Stop() error {
+	return nil
+}
+
+func (n *Node) Tick() {
+	n.simulation.advanceSchedule(n.tick())
+}
+
+func (n *Node) tick() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.ticks++
+	if n.status == "crashed" {
+		return n.ticks
+	}
+
+	for i := 0; i < 50; i++ {
+		select {
+		case env := <-n.inbox:
+			n.processMessage(env)
+			continue
+		default:
+		}
+		break
+	}
+
+	sim := n.simulation
+	interval, timeout := sim.timing()
+	if n.ticks%interval == 0 {
+		n.sent++
+		for _, peer := range sim.nodes {
+			if peer.id != n.id {
+				sim.send(n.id, peer.id, MsgHeartbeat, Payload{Seq: n.sent})
+			}
+		}
+	}
+
+	for peer, heard := range n.lastHeard {
+		if !n.suspected[peer] && n.ticks-heard > timeout {
+			n.suspected[peer] = true
+			sim.suspected(n.id, peer, n.ticks-heard)
+		}
+	}
+	return n.ticks
+}
+
+func (n *Node) GetState() map[string]interface{} {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	silence := make(map[string]int, len(n.lastHeard))
+	for peer, heard := range n.lastHeard {
+		silence[peer] = n.ticks - heard
+	}
+
+	return map[string]interface{}{
+		"id":         n.id,
+		"status":     n.status,
+		"role":       "node",
+		"suspected":  n.suspectedSet(),
+		"silence":    silence, // Peer -> ticks since its last heartbeat
+		"heartbeats": n.sent,
+	}
+}
+
+func (n *Node) handleMessage(env *transport.Envelope) {
+	n.mu.RLock()
+	down := n.status == "crashed"
+	n.mu.RUnlock()
+
+	if down {
+		return
+	}
+	select {
+	case n.inbox <- env:
+	default:
+	}
+}
+
+func (n *Node) processMessage(env *transport.Envelope) {
+	n.simulation.received(env)
+	if env.Type != MsgHeartbeat {
+		return
+	}
+	n.lastHeard[env.From] = n.ticks
+	if n.suspected[env.From] {
+		delete(n.suspected, env.From)
+		n.simulation.trusted(n.id, env.From)
+	}
+}
+
+// join starts the timers of every peer, as if each had just been heard
+func (n *Node) join(peers []*Node) {
+	for _, peer := range peers {
+		if peer.id != n.id {
+			n.lastHeard[peer.id] = 0
+		}
+	}
+}
+
+// suspectedSet returns the peers marked dead, sorted (must hold n.mu)
+func (n *Node) suspectedSet() []string {
+	suspected := make([]string, 0, len(n.suspected))
+	for peer := range n.suspected {
+		suspected = append(suspected, peer)
+	}
+	sort.Strings(suspected)
+	return suspected
+}
+
+// suspects reports whether the node marks a peer dead, for the invariants
+func (n *Node) suspects(peer string) bool {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	return n.suspected[peer]
+}
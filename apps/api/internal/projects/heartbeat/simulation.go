@@ -0,0 +1,355 @@
+package heartbeat
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+	"github.com/ersantana/distributed-systems-learning/packages/simulation/engine"
+)
+
+const (
+	crashAt   = 80  // Tick every scenario crashes node-4
+	recoverAt = 200 // Tick it recovers node-4
+)
+
+// Payload is the content of all messages exchanged in this project
+type Payload struct {
+	Seq int `json:"seq"`
+}
+
+// Simulation runs all-to-all heartbeats with a fixed timeout, on a
+// network whose jitter the timeout has to absorb. No timeout is right:
+// in an asynchronous system a crashed node cannot be told from a slow
+// one, so a short timeout detects crashes fast and suspects running
+// nodes whenever a heartbeat is late, and a long one is accurate and
+// slow. Scenario "tight_timeout" and "loose_timeout" put the two ends of
+// that trade-off on the same jittery network; "steady" a middle timeout
+// on a calm one. Every scenario crashes node-4 for a while.
+type Simulation struct {
+	mu sync.RWMutex
+
+	engine    *engine.Engine
+	transport *transport.NetworkTransport
+	broadcast func(interface{})
+
+	nodes    []*Node
+	scenario string
+
+	interval int // Ticks between heartbeats
+	timeout  int // Ticks of silence before a peer is marked dead
+
+	crashedAt  map[string]int
+	detections []int // Ticks from a crash to each running node suspecting it
+
+	suspicions      int
+	falseSuspicions int // Running peers marked dead
+	messages        int
+	lastTick        int
+
+	running bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// Config for heartbeat failure detector simulation
+type Config struct {
+	NodeCount int
+	Scenario  string // "steady", "tight_timeout", "loose_timeout"
+}
+
+// NewSimulation creates a new heartbeat failure detector simulation
+func NewSimulation(eng *engine.Engine, trans *transport.NetworkTransport, broadcast func(interface{}), config Config) *Simulation {
+	switch config.Scenario {
+	case "steady", "tight_timeout", "loose_timeout":
+	default:
+		config.Scenario = "steady"
+	}
+	if config.NodeCount == 0 {
+		config.NodeCount = 5
+	}
+	config.NodeCount = max(config.NodeCount, 4)
+
+	sim := &Simulation{
+		engine:    eng,
+		transport: trans,
+		broadcast: broadcast,
+		scenario:  config.Scenario,
+		interval:  2,
+		timeout:   6,
+		crashedAt: make(map[string]int),
+	}
+
+	trans.SetLatency(20*time.Millisecond, 80*time.Millisecond)
+	switch config.Scenario {
+	case "tight_timeout":
+		trans.SetLatency(20*time.Millisecond, 500*time.Millisecond)
+		sim.timeout = 3
+	case "loose_timeout":
+		trans.SetLatency(20*time.Millisecond, 500*time.Millisecond)
+		sim.timeout = 15
+	}
+	trans.SetPacketLoss(0)
+
+	for i := 0; i < config.NodeCount; i++ {
+		node := newNode(fmt.Sprintf("node-%d", i+1), sim)
+		sim.nodes = append(sim.nodes, node)
+		trans.RegisterHandler(node.id, node.handleMessage)
+		eng.AddNode(node)
+	}
+	for _, node := range sim.nodes {
+		node.join(sim.nodes)
+	}
+
+	return sim
+}
+
+// Start starts the simulation
+func (s *Simulation) Start(ctx context.Context) error {
+	s.mu.Lock()
+	s.running = true
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.mu.Unlock()
+
+	return s.engine.Start(ctx)
+}
+
+// Stop stops the simulation
+func (s *Simulation) Stop() error {
+	s.mu.Lock()
+	s.running = false
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Unlock()
+
+	return s.engine.Stop()
+}
+
+// GetState returns the current simulation state
+func (s *Simulation) GetState() *protocol.SimulationStateResponse {
+	nodes := make(map[string]protocol.NodeState)
+	for _, node := range s.nodes {
+		state := node.GetState()
+		nodes[node.id] = protocol.NodeState{
+			ID:          node.id,
+			Status:      state["status"].(string),
+			Role:        "node",
+			CustomState: state,
+		}
+	}
+
+	s.mu.RLock()
+	running := s.running
+	metadata := map[string]interface{}{
+		"scenario":        s.scenario,
+		"heartbeatTicks":  s.interval,
+		"timeoutTicks":    s.timeout,
+		"suspicions":      s.suspicions,
+		"falseSuspicions": s.falseSuspicions, // Running peers marked dead
+		"detectionTicks":  append([]int{}, s.detections...),
+		"messages":        s.messages,
+	}
+	s.mu.RUnlock()
+
+	mode := "step"
+	if s.engine != nil {
+		mode = s.engine.GetMode().String()
+	}
+
+	return &protocol.SimulationStateResponse{
+		Type:        protocol.MsgSimulationState,
+		VirtualTime: time.Now().UnixMilli(),
+		Mode:        mode,
+		Speed:       1.0,
+		Running:     running,
+		Nodes:       nodes,
+		Metadata:    metadata,
+	}
+}
+
+// GetNodes returns node states
+func (s *Simulation) GetNodes() map[string]protocol.NodeState {
+	state := s.GetState()
+	return state.Nodes
+}
+
+// CrashNode crashes a node; its heartbeats stop
+func (s *Simulation) CrashNode(nodeID string) error {
+	node := s.findNode(nodeID)
+	if node == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	node.mu.Lock()
+	node.status = "crashed"
+	node.mu.Unlock()
+
+	s.mu.Lock()
+	s.crashedAt[nodeID] = s.lastTick
+	s.mu.Unlock()
+	return nil
+}
+
+// RecoverNode recovers a crashed node; it restarts its peers' timers, and
+// its heartbeats clear their suspicion of it
+func (s *Simulation) RecoverNode(nodeID string) error {
+	node := s.findNode(nodeID)
+	if node == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	node.mu.Lock()
+	node.status = "running"
+	for peer := range node.lastHeard {
+		node.lastHeard[peer] = node.ticks
+	}
+	node.suspected = make(map[string]bool)
+	node.mu.Unlock()
+
+	s.mu.Lock()
+	delete(s.crashedAt, nodeID)
+	s.mu.Unlock()
+	return nil
+}
+
+// HandleClientRequest runs a client command: "configure" sets the
+// heartbeat interval and the timeout, {heartbeatTicks, timeoutTicks},
+// each optional
+func (s *Simulation) HandleClientRequest(command string, payload map[string]interface{}) error {
+	if command != "configure" {
+		return fmt.Errorf("unknown command: %s", command)
+	}
+
+	s.mu.Lock()
+	interval, timeout := s.interval, s.timeout
+	if v, ok := payload["heartbeatTicks"].(float64); ok {
+		interval = int(v)
+	}
+	if v, ok := payload["timeoutTicks"].(float64); ok {
+		timeout = int(v)
+	}
+	if interval < 1 || timeout < interval {
+		s.mu.Unlock()
+		return fmt.Errorf("need 1 <= heartbeatTicks <= timeoutTicks, got %d and %d", interval, timeout)
+	}
+	s.interval, s.timeout = interval, timeout
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":           "timing_changed",
+		"heartbeatTicks": interval,
+		"timeoutTicks":   timeout,
+	})
+	return nil
+}
+
+// findNode looks up a node by ID
+func (s *Simulation) findNode(nodeID string) *Node {
+	for _, node := range s.nodes {
+		if node.id == nodeID {
+			return node
+		}
+	}
+	return nil
+}
+
+// timing returns the heartbeat interval and the timeout
+func (s *Simulation) timing() (int, int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.interval, s.timeout
+}
+
+// advanceSchedule crashes and recovers node-4, once per tick
+func (s *Simulation) advanceSchedule(ticks int) {
+	s.mu.Lock()
+	if ticks <= s.lastTick {
+		s.mu.Unlock()
+		return
+	}
+	s.lastTick = ticks
+	s.mu.Unlock()
+
+	victim := s.nodes[3].id
+	switch ticks {
+	case crashAt:
+		s.CrashNode(victim)
+		s.broadcast(map[string]interface{}{
+			"type":   "node_crashed",
+			"nodeId": victim,
+		})
+	case recoverAt:
+		s.RecoverNode(victim)
+		s.broadcast(map[string]interface{}{
+			"type":   "node_recovered",
+			"nodeId": victim,
+		})
+	}
+}
+
+// suspected records a node marking a silent peer dead
+func (s *Simulation) suspected(nodeID, peer string, silence int) {
+	s.mu.Lock()
+	s.suspicions++
+	crashed, down := s.crashedAt[peer]
+	if down {
+		s.detections = append(s.detections, s.lastTick-crashed)
+	} else {
+		s.falseSuspicions++
+	}
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":     "peer_suspected",
+		"nodeId":   nodeID,
+		"peer":     peer,
+		"silence":  silence,
+		"mistaken": !down, // The peer is actually running
+	})
+}
+
+// trusted records a node hearing again from a peer it marked dead
+func (s *Simulation) trusted(nodeID, peer string) {
+	s.broadcast(map[string]interface{}{
+		"type":   "peer_trusted",
+		"nodeId": nodeID,
+		"peer":   peer,
+	})
+}
+
+// send transmits a message and reports it to the frontend
+func (s *Simulation) send(from, to string, msgType transport.MessageType, payload Payload) {
+	env := transport.NewEnvelope(from, to, msgType, payload)
+
+	s.mu.Lock()
+	s.messages++
+	s.mu.Unlock()
+
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageSent,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+
+	s.transport.Send(s.ctx, env)
+}
+
+// received reports a delivered message and returns its payload
+func (s *Simulation) received(env *transport.Envelope) Payload {
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageReceived,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+	payload, _ := env.Payload.(Payload)
+	return payload
+}
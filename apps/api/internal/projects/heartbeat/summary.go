@@ -0,0 +1,78 @@
+package heartbeat
+
+import (
+	"fmt"
+
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+)
+
+// Invariants judges the timeout on both sides of the trade-off: it
+// should suspect every crashed node, and no running one. Under jitter no
+// timeout guarantees both, only trades one for the other.
+func (s *Simulation) Invariants() []protocol.InvariantResult {
+	s.mu.RLock()
+	lastTick, timeout := s.lastTick, s.timeout
+	crashed := make([]string, 0)
+	for id, at := range s.crashedAt {
+		if lastTick-at > 2*timeout {
+			crashed = append(crashed, id)
+		}
+	}
+	falseSuspicions, suspicions := s.falseSuspicions, s.suspicions
+	detections := append([]int{}, s.detections...)
+	s.mu.RUnlock()
+
+	// Running nodes still trusting a node crashed for a while
+	missed := 0
+	for _, id := range crashed {
+		for _, node := range s.nodes {
+			node.mu.RLock()
+			up := node.status == "running"
+			node.mu.RUnlock()
+			if up && !node.suspects(id) {
+				missed++
+			}
+		}
+	}
+
+	speed := "no crash detected yet"
+	if len(detections) > 0 {
+		total := 0
+		for _, ticks := range detections {
+			total += ticks
+		}
+		speed = fmt.Sprintf("crashes detected after %.1f ticks on average", float64(total)/float64(len(detections)))
+	}
+	return []protocol.InvariantResult{
+		{
+			Name:   "crashed nodes are suspected",
+			Holds:  missed == 0,
+			Detail: fmt.Sprintf("%d views still trust a node crashed for over %d ticks; %s", missed, 2*timeout, speed),
+		},
+		{
+			Name:   "running nodes are never suspected",
+			Holds:  falseSuspicions == 0,
+			Detail: fmt.Sprintf("%d of %d suspicions were of a running node, with a %d-tick timeout", falseSuspicions, suspicions, timeout),
+		},
+	}
+}
+
+// FollowUps suggests the scenarios that contrast with this one
+func (s *Simulation) FollowUps() []protocol.FollowUp {
+	switch s.scenario {
+	case "tight_timeout":
+		return []protocol.FollowUp{
+			{Project: "heartbeat", Scenario: "loose_timeout", Reason: "Stretch the timeout on the same network and count the suspicions left"},
+			{Project: "phi-accrual", Reason: "Replace the fixed timeout with a suspicion level that adapts to the jitter"},
+		}
+	case "loose_timeout":
+		return []protocol.FollowUp{
+			{Project: "heartbeat", Scenario: "tight_timeout", Reason: "Shorten the timeout and detect crashes faster, at a price"},
+			{Project: "swim", Reason: "Cut the all-to-all heartbeats to one probe per period"},
+		}
+	}
+	return []protocol.FollowUp{
+		{Project: "heartbeat", Scenario: "tight_timeout", Reason: "Make the network jittery and the timeout short"},
+		{Project: "heartbeat", Scenario: "loose_timeout", Reason: "Make the network jittery and the timeout long"},
+	}
+}
@@ -0,0 +1,95 @@
+package hotstuff
+
+import (
+	"fmt"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+const (
+	MsgPrePrepare transport.MessageType = "pre_prepare"
+	MsgPrepare    transport.MessageType = "prepare"
+	MsgCommit     transport.MessageType = "commit"
+)
+
+// The "pbft" scenario runs PBFT's normal case on the same replicas, as the
+// baseline HotStuff improves on. The first replica leads for good and
+// numbers the blocks; each backup broadcasts a prepare for the leader's
+// pre-prepare, and each replica prepared by 2f of them broadcasts a
+// commit, deciding on 2f+1 commits. Both rounds are all-to-all, so a
+// block costs about 2n² messages where HotStuff needs about 2n. View
+// changes are left out: a crashed leader stalls the baseline for good.
+
+// pbftTick lets the leader number the next block once it committed the
+// previous one (must hold r.mu)
+func (r *Replica) pbftTick() {
+	sim := r.simulation
+	if r.index != 0 || (r.sequence > 0 && !r.isDone[blockID(r.sequence)]) || r.ticks-r.decidedAt < minViewTicks {
+		return
+	}
+	r.sequence++
+	parent := genesis.ID
+	if r.sequence > 1 {
+		parent = blockID(r.sequence - 1)
+	}
+	block := Block{ID: blockID(r.sequence), View: r.sequence, Height: r.sequence, Parent: parent}
+	sim.proposed(r.id, block)
+	for _, replica := range sim.replicas {
+		r.deliver(replica.id, MsgPrePrepare, Payload{View: r.sequence, Block: &block})
+	}
+}
+
+// pbftHandle runs the three phases (must hold r.mu)
+func (r *Replica) pbftHandle(from string, msgType transport.MessageType, payload Payload) {
+	sim := r.simulation
+	seq := payload.View
+	switch msgType {
+	case MsgPrePrepare:
+		if r.prePrepared[seq] {
+			return
+		}
+		r.prePrepared[seq] = true
+		if r.index != 0 {
+			// A backup's own prepare counts toward its 2f
+			if r.prepares[seq] == nil {
+				r.prepares[seq] = make(map[string]bool)
+			}
+			r.prepares[seq][r.id] = true
+			for _, replica := range sim.replicas {
+				if replica.id != r.id {
+					r.deliver(replica.id, MsgPrepare, Payload{View: seq})
+				}
+			}
+		}
+	case MsgPrepare:
+		if r.prepares[seq] == nil {
+			r.prepares[seq] = make(map[string]bool)
+		}
+		r.prepares[seq][from] = true
+	case MsgCommit:
+		if r.commits[seq] == nil {
+			r.commits[seq] = make(map[string]bool)
+		}
+		r.commits[seq][from] = true
+	}
+
+	// Prepared: the pre-prepare and 2f matching prepares
+	if r.prePrepared[seq] && !r.prepared[seq] && len(r.prepares[seq]) >= 2*sim.faults {
+		r.prepared[seq] = true
+		for _, replica := range sim.replicas {
+			r.deliver(replica.id, MsgCommit, Payload{View: seq})
+		}
+	}
+	// Committed locally: prepared and 2f+1 commits, in sequence order
+	for next := len(r.committed) + 1; r.prepared[next] && len(r.commits[next]) >= 2*sim.faults+1; next++ {
+		r.commit(sim.block(blockID(next)))
+		if r.index == 0 {
+			r.decidedAt = r.ticks
+		}
+	}
+}
+
+// blockID names the block of a view, or of a sequence number
+func blockID(view int) string {
+	return fmt.Sprintf("b%d", view)
+}
@@ -0,0 +1,366 @@
+package hotstuff
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+const (
+	MsgProposal transport.MessageType = "proposal"
+	MsgVote     transport.MessageType = "vote"
+	MsgNewView  transport.MessageType = "new_view"
+)
+
+const (
+	viewTimeout  = 15 // Ticks without progress before the pacemaker gives up on a view
+	minViewTicks = 3  // Ticks a leader waits in its view before proposing
+	keptBlocks   = 5  // Committed blocks shown in a replica's state
+)
+
+// QC is a quorum certificate: n-f replicas voted for the block in the
+// view. HotStuff aggregates the signatures into one; here it is the view
+// and the block.
+type QC struct {
+	View  int    `json:"view"`
+	Block string `json:"block"`
+}
+
+// Block is a proposal of a view. It extends the block its justify QC
+// certifies, the highest the leader knew of, and carries that QC with it:
+// a QC for a block is a vote round on its parent's QC, and three
+// certified blocks in consecutive views commit the first.
+type Block struct {
+	ID      string `json:"id"`
+	View    int    `json:"view"`
+	Height  int    `json:"height"`
+	Parent  string `json:"parent"`
+	Justify QC     `json:"justify"`
+}
+
+// genesis is the block every chain starts from, certified from the start
+var genesis = Block{ID: "genesis"}
+
+// Replica runs chained HotStuff. The leader of a view, rotating every
+// view, proposes a block to everyone; replicas vote for it to the next
+// view's leader only, which turns n-f votes into a QC and carries it in
+// its own proposal. Each view costs a proposal and a vote per replica:
+// linear in the number of replicas. When a view makes no progress, the
+// pacemaker moves every replica to the next one and they send their
+// highest QC to its leader instead.
+type Replica struct {
+	mu sync.RWMutex
+
+	id     string
+	index  int
+	status string // "running" or "crashed"
+	ticks  int
+
+	view      int // Current view
+	viewStart int // Tick the view began
+	highQC    QC  // Highest QC known
+	lockedQC  QC  // A replica only votes for blocks extending it, or with a higher justify
+	lastVoted int // View of the last vote
+
+	votes    map[int]map[string]bool // View -> replicas that voted for its block, at the next leader
+	newViews map[int]map[string]bool // View -> replicas that timed out into it, at its leader
+	proposed map[int]bool
+
+	committed []string // Block IDs in commit order
+	isDone    map[string]bool
+	timeouts  int
+
+	// PBFT baseline
+	sequence    int // Last sequence number the leader assigned
+	decidedAt   int // Tick the leader committed its last block
+	prePrepared map[int]bool
+	prepares    map[int]map[string]bool
+	prepared    map[int]bool // Commit sent
+	commits     map[int]map[string]bool
+
+	inbox      chan *transport.Envelope
+	simulation *Simulation
+}
+
+func newReplica(id string, index int, sim *Simulation) *Replica {
+	genesisQC := QC{View: 0, Block: genesis.ID}
+	return &Replica{
+		id:          id,
+		index:       index,
+		status:      "running",
+		view:        1,
+		highQC:      genesisQC,
+		lockedQC:    genesisQC,
+		votes:       make(map[int]map[string]bool),
+		newViews:    make(map[int]map[string]bool),
+		proposed:    make(map[int]bool),
+		isDone:      map[string]bool{genesis.ID: true},
+		prePrepared: make(map[int]bool),
+		prepares:    make(map[int]map[string]bool),
+		prepared:    make(map[int]bool),
+		commits:     make(map[int]map[string]bool),
+		inbox:       make(chan *transport.Envelope, 500),
+		simulation:  sim,
+	}
+}
+
+// Replica implements engine.NodeController
+
+func (r *Replica) ID() string {
+	return r.id
+}
+
+func (r *Replica) Start(ctx context.Context) error {
+	return nil
+}
+
+func (r *Replica) Stop() error {
+	return nil
+}
+
+func (r *Replica) Tick() {
+	r.simulation.advanceSchedule(r.tick())
+}
+
+func (r *Replica) tick() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.ticks++
+	if r.status == "crashed" {
+		return r.ticks
+	}
+
+	for i := 0; i < 50; i++ {
+		select {
+		case env := <-r.inbox:
+			r.processMessage(env)
+			continue
+		default:
+		}
+		break
+	}
+
+	if r.simulation.pbft {
+		r.pbftTick()
+		return r.ticks
+	}
+
+	sim := r.simulation
+	switch {
+	case sim.leader(r.view) == r.id && !r.proposed[r.view] && r.ready() && r.ticks-r.viewStart >= minViewTicks:
+		r.propose()
+	case r.ticks-r.viewStart >= viewTimeout:
+		// Pacemaker: give up on the view and tell the next leader what
+		// this replica knows
+		r.timeouts++
+		r.enterView(r.view + 1)
+		sim.viewChange(r.id, r.view, sim.leader(r.view))
+		r.deliver(sim.leader(r.view), MsgNewView, Payload{View: r.view, QC: r.highQC})
+	}
+	return r.ticks
+}
+
+func (r *Replica) GetState() map[string]interface{} {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	recent := r.committed[max(0, len(r.committed)-keptBlocks):]
+	state := map[string]interface{}{
+		"id":        r.id,
+		"status":    r.status,
+		"role":      "replica",
+		"height":    len(r.committed),
+		"committed": append([]string{}, recent...), // Most recent committed blocks
+	}
+	if r.simulation.pbft {
+		state["leader"] = r.index == 0
+		state["sequence"] = r.sequence
+		return state
+	}
+	state["view"] = r.view
+	state["leader"] = r.simulation.leader(r.view) == r.id
+	state["highQC"] = r.highQC
+	state["lockedQC"] = r.lockedQC
+	state["lastVoted"] = r.lastVoted
+	state["timeouts"] = r.timeouts
+	return state
+}
+
+func (r *Replica) handleMessage(env *transport.Envelope) {
+	r.mu.RLock()
+	down := r.status == "crashed"
+	r.mu.RUnlock()
+
+	if down {
+		return
+	}
+	select {
+	case r.inbox <- env:
+	default:
+	}
+}
+
+func (r *Replica) processMessage(env *transport.Envelope) {
+	payload := r.simulation.received(env)
+	r.handle(env.From, env.Type, payload)
+}
+
+// handle runs a message, sent by another replica or by this one to
+// itself (must hold r.mu)
+func (r *Replica) handle(from string, msgType transport.MessageType, payload Payload) {
+	switch msgType {
+	case MsgProposal:
+		if payload.Block != nil {
+			r.onProposal(*payload.Block)
+		}
+	case MsgVote:
+		r.onVote(from, payload.View, payload.QC.Block)
+	case MsgNewView:
+		r.onNewView(from, payload.View, payload.QC)
+	case MsgPrePrepare, MsgPrepare, MsgCommit:
+		r.pbftHandle(from, msgType, payload)
+	}
+}
+
+// deliver sends a message, running it right away when it is addressed to
+// this replica (must hold r.mu)
+func (r *Replica) deliver(to string, msgType transport.MessageType, payload Payload) {
+	if to == r.id {
+		r.handle(r.id, msgType, payload)
+		return
+	}
+	r.simulation.send(r.id, to, msgType, payload)
+}
+
+// ready reports whether the leader may propose in its view: it holds the
+// QC of the previous view, or n-f replicas timed out into this one and
+// sent their highest QC (must hold r.mu)
+func (r *Replica) ready() bool {
+	return r.view == 1 || r.highQC.View == r.view-1 || len(r.newViews[r.view]) >= r.simulation.quorum
+}
+
+// propose extends the highest certified block (must hold r.mu)
+func (r *Replica) propose() {
+	sim := r.simulation
+	r.proposed[r.view] = true
+	parent := sim.block(r.highQC.Block)
+	block := Block{
+		ID:      blockID(r.view),
+		View:    r.view,
+		Height:  parent.Height + 1,
+		Parent:  parent.ID,
+		Justify: r.highQC,
+	}
+	sim.proposed(r.id, block)
+	for _, replica := range sim.replicas {
+		r.deliver(replica.id, MsgProposal, Payload{Block: &block})
+	}
+}
+
+// onProposal learns the QC a block carries and votes for the block if it
+// is safe, then moves on to the next view (must hold r.mu)
+func (r *Replica) onProposal(block Block) {
+	sim := r.simulation
+	if block.View < r.view && block.View <= r.lastVoted {
+		return
+	}
+	r.learn(block.Justify)
+
+	// Safety: never vote against the lock unless the block carries a
+	// QC newer than the lock, proof that n-f replicas moved on from it
+	safe := sim.extends(block.ID, r.lockedQC.Block) || block.Justify.View > r.lockedQC.View
+	if safe && block.View > r.lastVoted {
+		r.lastVoted = block.View
+		r.deliver(sim.leader(block.View+1), MsgVote, Payload{View: block.View, QC: QC{View: block.View, Block: block.ID}})
+	}
+	if block.View >= r.view {
+		r.enterView(block.View + 1)
+	}
+}
+
+// onVote collects the votes for the block of a view at the next leader;
+// n-f of them form its QC (must hold r.mu)
+func (r *Replica) onVote(from string, view int, blockID string) {
+	sim := r.simulation
+	if r.votes[view] == nil {
+		r.votes[view] = make(map[string]bool)
+	}
+	r.votes[view][from] = true
+	if len(r.votes[view]) != sim.quorum {
+		return
+	}
+	qc := QC{View: view, Block: blockID}
+	sim.qcFormed(r.id, qc, len(r.votes[view]))
+	r.learn(qc)
+	if r.view <= view {
+		r.enterView(view + 1)
+	}
+}
+
+// onNewView collects the replicas that timed out into a view this
+// replica leads, keeping the highest QC they sent (must hold r.mu)
+func (r *Replica) onNewView(from string, view int, qc QC) {
+	if r.newViews[view] == nil {
+		r.newViews[view] = make(map[string]bool)
+	}
+	r.newViews[view][from] = true
+	r.learn(qc)
+	if len(r.newViews[view]) >= r.simulation.quorum && r.view < view {
+		r.enterView(view)
+	}
+}
+
+// learn updates the high QC, the lock and the committed chain from a QC.
+// A QC on b2 whose parent b1 was certified in the view just before locks
+// b1; if b1's parent b0 was also certified in the view before that, the
+// three-chain commits b0 and its ancestors (must hold r.mu)
+func (r *Replica) learn(qc QC) {
+	sim := r.simulation
+	if qc.View > r.highQC.View {
+		r.highQC = qc
+	}
+	b2 := sim.block(qc.Block)
+	b1 := sim.block(b2.Justify.Block)
+	b0 := sim.block(b1.Justify.Block)
+	if b2.ID == genesis.ID || b1.ID == genesis.ID {
+		return
+	}
+	if b2.Justify.View > r.lockedQC.View {
+		r.lockedQC = b2.Justify
+	}
+	if b2.View == b1.View+1 && b1.View == b0.View+1 && b0.ID != genesis.ID {
+		r.commit(b0)
+	}
+}
+
+// commit commits a block and the ancestors not committed yet, oldest
+// first (must hold r.mu)
+func (r *Replica) commit(block Block) {
+	sim := r.simulation
+	chain := make([]Block, 0)
+	for b := block; !r.isDone[b.ID]; b = sim.block(b.Parent) {
+		chain = append(chain, b)
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		r.isDone[chain[i].ID] = true
+		r.committed = append(r.committed, chain[i].ID)
+		sim.committed(r.id, chain[i], len(r.committed))
+	}
+}
+
+// enterView moves the replica to a view and restarts the pacemaker's
+// timer (must hold r.mu)
+func (r *Replica) enterView(view int) {
+	r.view = view
+	r.viewStart = r.ticks
+}
+
+// chain returns the blocks the replica committed, in order
+func (r *Replica) chain() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return append([]string{}, r.committed...)
+}
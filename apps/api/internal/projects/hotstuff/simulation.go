@@ -0,0 +1,401 @@
+package hotstuff
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+	"github.com/ersantana/distributed-systems-learning/packages/simulation/engine"
+)
+
+const (
+	crashAt      = 40  // Tick the "leader_crash" scenario crashes replica-2
+	crashedTicks = 150 // Ticks replica-2 stays down
+)
+
+// Payload is the content of all messages exchanged in this project
+type Payload struct {
+	View  int    `json:"view,omitempty"` // Also PBFT's sequence number
+	Block *Block `json:"block,omitempty"`
+	QC    QC     `json:"qc,omitempty"`
+}
+
+// Simulation runs chained HotStuff on n = 3f+1 replicas, with the leader
+// rotating every view. Scenario "leader_crash" crashes replica-2 for a
+// while: every view it leads times out, and the pacemaker moves the
+// replicas on to the next leader. Scenario "pbft" runs PBFT's all-to-all
+// rounds on the same replicas instead, to compare the messages a
+// committed block costs.
+type Simulation struct {
+	mu sync.RWMutex
+
+	engine    *engine.Engine
+	transport *transport.NetworkTransport
+	broadcast func(interface{})
+
+	replicas []*Replica
+	scenario string
+	pbft     bool
+	faults   int // f, the faulty replicas tolerated
+	quorum   int // n-f
+
+	// Blocks are immutable and named by their view, so a replica missing
+	// one could fetch it from any peer; that fetch is not simulated
+	blocks map[string]Block
+
+	qcs         int
+	viewChanges map[int]bool // Views entered by a pacemaker timeout
+	messages    int
+	lastTick    int
+
+	running bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// Config for HotStuff simulation
+type Config struct {
+	NodeCount int
+	Scenario  string // "steady", "leader_crash", "pbft"
+}
+
+// NewSimulation creates a new HotStuff simulation
+func NewSimulation(eng *engine.Engine, trans *transport.NetworkTransport, broadcast func(interface{}), config Config) *Simulation {
+	switch config.Scenario {
+	case "steady", "leader_crash", "pbft":
+	default:
+		config.Scenario = "steady"
+	}
+	if config.NodeCount < 4 {
+		config.NodeCount = 4
+	}
+	faults := (config.NodeCount - 1) / 3
+
+	sim := &Simulation{
+		engine:      eng,
+		transport:   trans,
+		broadcast:   broadcast,
+		scenario:    config.Scenario,
+		pbft:        config.Scenario == "pbft",
+		faults:      faults,
+		quorum:      config.NodeCount - faults,
+		blocks:      map[string]Block{genesis.ID: genesis},
+		viewChanges: make(map[int]bool),
+	}
+
+	trans.SetLatency(20*time.Millisecond, 80*time.Millisecond)
+	trans.SetPacketLoss(0)
+
+	for i := 0; i < config.NodeCount; i++ {
+		replica := newReplica(fmt.Sprintf("replica-%d", i+1), i, sim)
+		sim.replicas = append(sim.replicas, replica)
+		trans.RegisterHandler(replica.id, replica.handleMessage)
+		eng.AddNode(replica)
+	}
+
+	return sim
+}
+
+// Start starts the simulation
+func (s *Simulation) Start(ctx context.Context) error {
+	s.mu.Lock()
+	s.running = true
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.mu.Unlock()
+
+	return s.engine.Start(ctx)
+}
+
+// Stop stops the simulation
+func (s *Simulation) Stop() error {
+	s.mu.Lock()
+	s.running = false
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Unlock()
+
+	return s.engine.Stop()
+}
+
+// GetState returns the current simulation state
+func (s *Simulation) GetState() *protocol.SimulationStateResponse {
+	nodes := make(map[string]protocol.NodeState)
+	view, height, timeouts := 0, 0, 0
+	for _, replica := range s.replicas {
+		state := replica.GetState()
+		nodes[replica.id] = protocol.NodeState{
+			ID:          replica.id,
+			Status:      state["status"].(string),
+			Role:        "replica",
+			CustomState: state,
+		}
+		height = max(height, state["height"].(int))
+		if !s.pbft && state["status"] == "running" {
+			view = max(view, state["view"].(int))
+			timeouts += state["timeouts"].(int)
+		}
+	}
+
+	protocolName := "hotstuff"
+	if s.pbft {
+		protocolName = "pbft"
+	}
+
+	s.mu.RLock()
+	running := s.running
+	perBlock := 0.0
+	if height > 0 {
+		perBlock = float64(s.messages) / float64(height)
+	}
+	metadata := map[string]interface{}{
+		"scenario":         s.scenario,
+		"protocol":         protocolName,
+		"replicas":         len(s.replicas),
+		"faults":           s.faults,
+		"quorum":           s.quorum,
+		"height":           height, // Blocks committed by the furthest replica
+		"qcs":              s.qcs,
+		"viewChanges":      len(s.viewChanges),
+		"timeouts":         timeouts,
+		"messages":         s.messages,
+		"messagesPerBlock": perBlock,
+	}
+	if !s.pbft {
+		metadata["view"] = view
+		metadata["leader"] = s.leader(view)
+	}
+	s.mu.RUnlock()
+
+	mode := "step"
+	if s.engine != nil {
+		mode = s.engine.GetMode().String()
+	}
+
+	return &protocol.SimulationStateResponse{
+		Type:        protocol.MsgSimulationState,
+		VirtualTime: time.Now().UnixMilli(),
+		Mode:        mode,
+		Speed:       1.0,
+		Running:     running,
+		Nodes:       nodes,
+		Metadata:    metadata,
+	}
+}
+
+// GetNodes returns node states
+func (s *Simulation) GetNodes() map[string]protocol.NodeState {
+	state := s.GetState()
+	return state.Nodes
+}
+
+// Layout puts the replicas on a ring in the order leadership rotates
+func (s *Simulation) Layout() *protocol.Layout {
+	order := make([]string, len(s.replicas))
+	for i, replica := range s.replicas {
+		order[i] = replica.id
+	}
+	return &protocol.Layout{
+		Kind:  protocol.LayoutRing,
+		Order: order,
+	}
+}
+
+// CrashNode crashes a replica; up to f crashed replicas only cost the
+// views they lead
+func (s *Simulation) CrashNode(nodeID string) error {
+	replica := s.findReplica(nodeID)
+	if replica == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	replica.mu.Lock()
+	replica.status = "crashed"
+	replica.mu.Unlock()
+	return nil
+}
+
+// RecoverNode recovers a crashed replica; it catches up with the view of
+// the next proposal it receives
+func (s *Simulation) RecoverNode(nodeID string) error {
+	replica := s.findReplica(nodeID)
+	if replica == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	replica.mu.Lock()
+	replica.status = "running"
+	replica.viewStart = replica.ticks
+	replica.mu.Unlock()
+	return nil
+}
+
+// findReplica looks up a replica by ID
+func (s *Simulation) findReplica(nodeID string) *Replica {
+	for _, replica := range s.replicas {
+		if replica.id == nodeID {
+			return replica
+		}
+	}
+	return nil
+}
+
+// leader returns the leader of a view: leadership rotates every view
+func (s *Simulation) leader(view int) string {
+	if view < 1 {
+		return ""
+	}
+	return s.replicas[(view-1)%len(s.replicas)].id
+}
+
+// block looks up a block proposed so far
+func (s *Simulation) block(id string) Block {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if block, ok := s.blocks[id]; ok {
+		return block
+	}
+	return genesis
+}
+
+// extends reports whether a block descends from another, or is it
+func (s *Simulation) extends(id, ancestor string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for {
+		if id == ancestor {
+			return true
+		}
+		block, ok := s.blocks[id]
+		if !ok || block.ID == genesis.ID {
+			return false
+		}
+		id = block.Parent
+	}
+}
+
+// advanceSchedule crashes and recovers replica-2 in the "leader_crash"
+// scenario, once per tick
+func (s *Simulation) advanceSchedule(ticks int) {
+	s.mu.Lock()
+	if ticks <= s.lastTick {
+		s.mu.Unlock()
+		return
+	}
+	s.lastTick = ticks
+	s.mu.Unlock()
+
+	if s.scenario != "leader_crash" {
+		return
+	}
+	victim := s.replicas[1].id
+	switch ticks {
+	case crashAt:
+		s.CrashNode(victim)
+		s.broadcast(map[string]interface{}{
+			"type":   "node_crashed",
+			"nodeId": victim,
+		})
+	case crashAt + crashedTicks:
+		s.RecoverNode(victim)
+		s.broadcast(map[string]interface{}{
+			"type":   "node_recovered",
+			"nodeId": victim,
+		})
+	}
+}
+
+// proposed records a block a leader proposed
+func (s *Simulation) proposed(leaderID string, block Block) {
+	s.mu.Lock()
+	s.blocks[block.ID] = block
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":    "block_proposed",
+		"nodeId":  leaderID,
+		"block":   block.ID,
+		"view":    block.View,
+		"parent":  block.Parent,
+		"justify": block.Justify,
+	})
+}
+
+// qcFormed records a leader aggregating n-f votes into a QC
+func (s *Simulation) qcFormed(leaderID string, qc QC, votes int) {
+	s.mu.Lock()
+	s.qcs++
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":   "qc_formed",
+		"nodeId": leaderID,
+		"view":   qc.View,
+		"block":  qc.Block,
+		"votes":  votes,
+	})
+}
+
+// viewChange records the pacemaker moving a replica to a view because the
+// previous one made no progress
+func (s *Simulation) viewChange(replicaID string, view int, leader string) {
+	s.mu.Lock()
+	s.viewChanges[view] = true
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":   "view_change",
+		"nodeId": replicaID,
+		"view":   view,
+		"leader": leader,
+		"reason": "timeout",
+	})
+}
+
+// committed records a replica committing a block at a height of its chain
+func (s *Simulation) committed(replicaID string, block Block, height int) {
+	s.broadcast(map[string]interface{}{
+		"type":   "block_committed",
+		"nodeId": replicaID,
+		"block":  block.ID,
+		"view":   block.View,
+		"height": height,
+	})
+}
+
+// send transmits a message and reports it to the frontend
+func (s *Simulation) send(from, to string, msgType transport.MessageType, payload Payload) {
+	env := transport.NewEnvelope(from, to, msgType, payload)
+
+	s.mu.Lock()
+	s.messages++
+	s.mu.Unlock()
+
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageSent,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+
+	s.transport.Send(s.ctx, env)
+}
+
+// received reports a delivered message and returns its payload
+func (s *Simulation) received(env *transport.Envelope) Payload {
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageReceived,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+	payload, _ := env.Payload.(Payload)
+	return payload
+}
@@ -0,0 +1,86 @@
+package hotstuff
+
+import (
+	"fmt"
+
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+)
+
+// Invariants judges the run on safety, liveness through the pacemaker,
+// and the message cost of a block: HotStuff's proposal and votes grow
+// linearly with the replicas, PBFT's broadcasts with their square
+func (s *Simulation) Invariants() []protocol.InvariantResult {
+	chains := make([][]string, len(s.replicas))
+	height := 0
+	for i, replica := range s.replicas {
+		chains[i] = replica.chain()
+		height = max(height, len(chains[i]))
+	}
+
+	// Every chain must be a prefix of the longest
+	forks := 0
+	longest := chains[0]
+	for _, chain := range chains {
+		if len(chain) > len(longest) {
+			longest = chain
+		}
+	}
+	for _, chain := range chains {
+		for i, id := range chain {
+			if longest[i] != id {
+				forks++
+				break
+			}
+		}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	n := len(s.replicas)
+	perBlock := 0.0
+	if height > 0 {
+		perBlock = float64(s.messages) / float64(height)
+	}
+	progress := fmt.Sprintf("%d blocks committed", height)
+	if !s.pbft {
+		progress += fmt.Sprintf(", %d view changes by the pacemaker", len(s.viewChanges))
+	}
+	return []protocol.InvariantResult{
+		{
+			Name:   "replicas commit the same chain",
+			Holds:  forks == 0,
+			Detail: fmt.Sprintf("%d of %d replicas committed a block another replica did not commit at the same height", forks, n),
+		},
+		{
+			Name:   "blocks keep committing",
+			Holds:  height > 0,
+			Detail: progress,
+		},
+		{
+			Name:   "messages per block grow linearly with the replicas",
+			Holds:  height > 0 && perBlock <= float64(3*n),
+			Detail: fmt.Sprintf("%.1f messages per committed block with %d replicas (3n = %d, 2n² = %d)", perBlock, n, 3*n, 2*n*n),
+		},
+	}
+}
+
+// FollowUps suggests the scenarios that contrast with this one
+func (s *Simulation) FollowUps() []protocol.FollowUp {
+	switch s.scenario {
+	case "pbft":
+		return []protocol.FollowUp{
+			{Project: "hotstuff", Scenario: "steady", Reason: "Commit the same blocks with votes sent to the next leader only"},
+			{Project: "byzantine", Reason: "Go back to the oral messages algorithm PBFT made practical"},
+		}
+	case "leader_crash":
+		return []protocol.FollowUp{
+			{Project: "hotstuff", Scenario: "steady", Reason: "See the rotation with every leader up"},
+			{Project: "zab", Reason: "Compare with a crash-tolerant protocol whose leader stays until it fails"},
+		}
+	}
+	return []protocol.FollowUp{
+		{Project: "hotstuff", Scenario: "pbft", Reason: "Commit blocks with PBFT's all-to-all rounds and count the messages"},
+		{Project: "hotstuff", Scenario: "leader_crash", Reason: "Crash a replica and watch the pacemaker skip the views it leads"},
+	}
+}
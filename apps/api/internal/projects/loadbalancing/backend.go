@@ -0,0 +1,148 @@
+package loadbalancing
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+const (
+	MsgRequest  transport.MessageType = "request"
+	MsgResponse transport.MessageType = "response"
+)
+
+// serviceTicks are the ticks each backend takes per request, cycled over
+// the backends: the last ones are four times slower than the first
+var serviceTicks = []int{2, 2, 3, 4, 8}
+
+// Backend serves the requests it is sent one at a time, in arrival order,
+// each taking its service time; the rest wait in its queue
+type Backend struct {
+	mu sync.RWMutex
+
+	id      string
+	status  string // "running" or "crashed"
+	ticks   int
+	service int // Ticks per request
+
+	queue     []int // Request IDs waiting
+	current   int   // Request being served, 0 if idle
+	remaining int   // Ticks left on the current request
+	served    int
+
+	inbox      chan *transport.Envelope
+	simulation *Simulation
+}
+
+func newBackend(id string, service int, sim *Simulation) *Backend {
+	return &Backend{
+		id:         id,
+		status:     "running",
+		service:    service,
+		inbox:      make(chan *transport.Envelope, 500),
+		simulation: sim,
+	}
+}
+
+// Backend implements engine.NodeController
+
+func (b *Backend) ID() string {
+	return b.id
+}
+
+func (b *Backend) Start(ctx context.Context) error {
+	return nil
+}
+
+func (b *Backend) Stop() error {
+	return nil
+}
+
+func (b *Backend) Tick() {
+	b.simulation.advanceSchedule(b.tick())
+}
+
+func (b *Backend) tick() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.ticks++
+	if b.status == "crashed" {
+		return b.ticks
+	}
+
+	for i := 0; i < 50; i++ {
+		select {
+		case env := <-b.inbox:
+			payload := b.simulation.received(env)
+			if env.Type == MsgRequest {
+				b.queue = append(b.queue, payload.ID)
+			}
+			continue
+		default:
+		}
+		break
+	}
+
+	if b.current != 0 {
+		b.remaining--
+		if b.remaining == 0 {
+			b.served++
+			b.simulation.send(b.id, b.simulation.balancer.id, MsgResponse, Payload{ID: b.current})
+			b.current = 0
+		}
+	}
+	if b.current == 0 && len(b.queue) > 0 {
+		b.current = b.queue[0]
+		b.queue = b.queue[1:]
+		b.remaining = b.service
+	}
+	return b.ticks
+}
+
+func (b *Backend) GetState() map[string]interface{} {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return map[string]interface{}{
+		"id":           b.id,
+		"status":       b.status,
+		"role":         "backend",
+		"serviceTicks": b.service,
+		"queue":        b.depth(),
+		"busy":         b.current != 0,
+		"served":       b.served,
+	}
+}
+
+func (b *Backend) handleMessage(env *transport.Envelope) {
+	b.mu.RLock()
+	down := b.status == "crashed"
+	b.mu.RUnlock()
+
+	if down {
+		return
+	}
+	select {
+	case b.inbox <- env:
+	default:
+	}
+}
+
+// depth returns the requests waiting or in service (must hold b.mu)
+func (b *Backend) depth() int {
+	depth := len(b.queue)
+	if b.current != 0 {
+		depth++
+	}
+	return depth
+}
+
+// queueDepth returns the requests waiting or in service
+func (b *Backend) queueDepth() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return b.depth()
+}
@@ -0,0 +1,171 @@
+package loadbalancing
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+const keptLatencies = 500 // Most recent response times kept for the percentiles
+
+// Balancer receives the clients' requests and sends each to a backend,
+// picked by the strategy: at random, in turn, or the less loaded of two
+// picked at random. It knows a backend's load only as the requests it
+// sent there that have not come back yet.
+type Balancer struct {
+	mu sync.RWMutex
+
+	id     string
+	status string // "running" or "crashed"
+	ticks  int
+
+	next        int            // Round-robin position
+	outstanding map[string]int // Backend -> requests sent and not answered
+	arrived     map[int]int    // Request -> tick it arrived
+	lastID      int
+	latencies   []int
+	completed   int
+
+	inbox      chan *transport.Envelope
+	simulation *Simulation
+}
+
+func newBalancer(id string, sim *Simulation) *Balancer {
+	return &Balancer{
+		id:          id,
+		status:      "running",
+		outstanding: make(map[string]int),
+		arrived:     make(map[int]int),
+		inbox:       make(chan *transport.Envelope, 500),
+		simulation:  sim,
+	}
+}
+
+// Balancer implements engine.NodeController
+
+func (b *Balancer) ID() string {
+	return b.id
+}
+
+func (b *Balancer) Start(ctx context.Context) error {
+	return nil
+}
+
+func (b *Balancer) Stop() error {
+	return nil
+}
+
+func (b *Balancer) Tick() {
+	b.simulation.advanceSchedule(b.tick())
+}
+
+func (b *Balancer) tick() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.ticks++
+	if b.status == "crashed" {
+		return b.ticks
+	}
+
+	for i := 0; i < 50; i++ {
+		select {
+		case env := <-b.inbox:
+			b.processMessage(env)
+			continue
+		default:
+		}
+		break
+	}
+
+	sim := b.simulation
+	strategy := sim.currentStrategy()
+	for i := sim.arrivals(); i > 0; i-- {
+		backend := b.pick(strategy)
+		b.lastID++
+		b.arrived[b.lastID] = b.ticks
+		b.outstanding[backend]++
+		sim.send(b.id, backend, MsgRequest, Payload{ID: b.lastID})
+	}
+	return b.ticks
+}
+
+func (b *Balancer) GetState() map[string]interface{} {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	outstanding := make(map[string]int, len(b.outstanding))
+	for backend, n := range b.outstanding {
+		outstanding[backend] = n
+	}
+
+	return map[string]interface{}{
+		"id":          b.id,
+		"status":      b.status,
+		"role":        "balancer",
+		"strategy":    b.simulation.currentStrategy(),
+		"outstanding": outstanding, // What the balancer believes each backend's load is
+		"inFlight":    len(b.arrived),
+		"completed":   b.completed,
+	}
+}
+
+func (b *Balancer) handleMessage(env *transport.Envelope) {
+	b.mu.RLock()
+	down := b.status == "crashed"
+	b.mu.RUnlock()
+
+	if down {
+		return
+	}
+	select {
+	case b.inbox <- env:
+	default:
+	}
+}
+
+func (b *Balancer) processMessage(env *transport.Envelope) {
+	payload := b.simulation.received(env)
+	if env.Type != MsgResponse {
+		return
+	}
+	arrived, ok := b.arrived[payload.ID]
+	if !ok {
+		return
+	}
+	delete(b.arrived, payload.ID)
+	b.outstanding[env.From]--
+	b.completed++
+	b.latencies = append(b.latencies, b.ticks-arrived)
+	if len(b.latencies) > keptLatencies {
+		b.latencies = b.latencies[len(b.latencies)-keptLatencies:]
+	}
+}
+
+// pick chooses the backend of the next request (must hold b.mu)
+func (b *Balancer) pick(strategy string) string {
+	backends := b.simulation.backends
+	switch strategy {
+	case "round_robin":
+		b.next = (b.next + 1) % len(backends)
+		return backends[b.next].id
+	case "power_of_two":
+		i := b.simulation.rng.Intn(len(backends))
+		j := (i + 1 + b.simulation.rng.Intn(len(backends)-1)) % len(backends)
+		first, second := backends[i].id, backends[j].id
+		if b.outstanding[second] < b.outstanding[first] {
+			return second
+		}
+		return first
+	}
+	return backends[b.simulation.rng.Intn(len(backends))].id
+}
+
+// responseTimes returns the most recent response times, in ticks
+func (b *Balancer) responseTimes() []int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return append([]int{}, b.latencies...)
+}
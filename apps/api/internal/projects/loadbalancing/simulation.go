@@ -0,0 +1,385 @@
+package loadbalancing
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+	"github.com/ersantana/distributed-systems-learning/packages/simulation/engine"
+)
+
+// Payload is the content of all messages exchanged in this project
+type Payload struct {
+	ID int `json:"id"` // Request
+}
+
+// Simulation runs a load balancer in front of backends of different
+// speeds, with requests arriving at a fixed share of their total
+// capacity. Each scenario is a strategy. "random" and "round_robin" send
+// every backend the same share of the requests, more than the slowest
+// can serve, so its queue grows without bound while the fast ones idle.
+// "power_of_two" compares the load of two backends picked at random and
+// sends the request to the lighter one: that little information is
+// enough to keep every queue short.
+type Simulation struct {
+	mu sync.RWMutex
+
+	engine    *engine.Engine
+	rng       *rand.Rand
+	transport *transport.NetworkTransport
+	broadcast func(interface{})
+
+	balancer *Balancer
+	backends []*Backend
+	scenario string
+
+	strategy    string
+	utilization float64 // Arrival rate as a share of the backends' total capacity
+	capacity    float64 // Requests the backends serve per tick, together
+
+	maxDepth map[string]int
+	messages int
+	lastTick int
+
+	running bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// Config for load balancing simulation
+type Config struct {
+	NodeCount int
+	Scenario  string // "random", "round_robin", "power_of_two"
+}
+
+// NewSimulation creates a new load balancing simulation
+func NewSimulation(eng *engine.Engine, trans *transport.NetworkTransport, broadcast func(interface{}), config Config) *Simulation {
+	switch config.Scenario {
+	case "random", "round_robin", "power_of_two":
+	default:
+		config.Scenario = "random"
+	}
+	if config.NodeCount == 0 {
+		config.NodeCount = 6
+	}
+	config.NodeCount = max(config.NodeCount, 3)
+
+	sim := &Simulation{
+		engine:      eng,
+		rng:         eng.Rand(),
+		transport:   trans,
+		broadcast:   broadcast,
+		scenario:    config.Scenario,
+		strategy:    config.Scenario,
+		utilization: 0.8,
+		maxDepth:    make(map[string]int),
+	}
+
+	trans.SetLatency(20*time.Millisecond, 80*time.Millisecond)
+	trans.SetPacketLoss(0)
+
+	sim.balancer = newBalancer("balancer", sim)
+	trans.RegisterHandler(sim.balancer.id, sim.balancer.handleMessage)
+	eng.AddNode(sim.balancer)
+	for i := 1; i < config.NodeCount; i++ {
+		service := serviceTicks[(i-1)%len(serviceTicks)]
+		backend := newBackend(fmt.Sprintf("backend-%d", i), service, sim)
+		sim.backends = append(sim.backends, backend)
+		sim.capacity += 1 / float64(service)
+		trans.RegisterHandler(backend.id, backend.handleMessage)
+		eng.AddNode(backend)
+	}
+
+	return sim
+}
+
+// Start starts the simulation
+func (s *Simulation) Start(ctx context.Context) error {
+	s.mu.Lock()
+	s.running = true
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.mu.Unlock()
+
+	return s.engine.Start(ctx)
+}
+
+// Stop stops the simulation
+func (s *Simulation) Stop() error {
+	s.mu.Lock()
+	s.running = false
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Unlock()
+
+	return s.engine.Stop()
+}
+
+// GetState returns the current simulation state
+func (s *Simulation) GetState() *protocol.SimulationStateResponse {
+	nodes := make(map[string]protocol.NodeState)
+	state := s.balancer.GetState()
+	nodes[s.balancer.id] = protocol.NodeState{
+		ID:          s.balancer.id,
+		Status:      state["status"].(string),
+		Role:        "balancer",
+		CustomState: state,
+	}
+	depths := make(map[string]int, len(s.backends))
+	for _, backend := range s.backends {
+		state := backend.GetState()
+		nodes[backend.id] = protocol.NodeState{
+			ID:          backend.id,
+			Status:      state["status"].(string),
+			Role:        "backend",
+			CustomState: state,
+		}
+		depths[backend.id] = state["queue"].(int)
+	}
+	p50, p99 := percentiles(s.balancer.responseTimes())
+
+	s.mu.RLock()
+	running := s.running
+	maxDepth := make(map[string]int, len(s.maxDepth))
+	for id, depth := range s.maxDepth {
+		maxDepth[id] = depth
+	}
+	metadata := map[string]interface{}{
+		"scenario":    s.scenario,
+		"strategy":    s.strategy,
+		"utilization": s.utilization,
+		"arrivalRate": s.utilization * s.capacity, // Requests per tick
+		"capacity":    s.capacity,
+		"queueDepths": depths,
+		"maxDepths":   maxDepth,
+		"imbalance":   imbalance(depths),
+		"p50Ticks":    p50,
+		"p99Ticks":    p99,
+		"messages":    s.messages,
+	}
+	s.mu.RUnlock()
+
+	mode := "step"
+	if s.engine != nil {
+		mode = s.engine.GetMode().String()
+	}
+
+	return &protocol.SimulationStateResponse{
+		Type:        protocol.MsgSimulationState,
+		VirtualTime: time.Now().UnixMilli(),
+		Mode:        mode,
+		Speed:       1.0,
+		Running:     running,
+		Nodes:       nodes,
+		Metadata:    metadata,
+	}
+}
+
+// GetNodes returns node states
+func (s *Simulation) GetNodes() map[string]protocol.NodeState {
+	state := s.GetState()
+	return state.Nodes
+}
+
+// CrashNode crashes a backend, which drops its queue and the requests
+// sent to it, or the balancer
+func (s *Simulation) CrashNode(nodeID string) error {
+	if nodeID == s.balancer.id {
+		s.balancer.mu.Lock()
+		s.balancer.status = "crashed"
+		s.balancer.mu.Unlock()
+		return nil
+	}
+	backend := s.findBackend(nodeID)
+	if backend == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	backend.mu.Lock()
+	backend.status = "crashed"
+	backend.queue = nil
+	backend.current = 0
+	backend.mu.Unlock()
+	return nil
+}
+
+// RecoverNode recovers a crashed backend, with an empty queue, or the
+// balancer
+func (s *Simulation) RecoverNode(nodeID string) error {
+	if nodeID == s.balancer.id {
+		s.balancer.mu.Lock()
+		s.balancer.status = "running"
+		s.balancer.mu.Unlock()
+		return nil
+	}
+	backend := s.findBackend(nodeID)
+	if backend == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	backend.mu.Lock()
+	backend.status = "running"
+	backend.mu.Unlock()
+	return nil
+}
+
+// HandleClientRequest runs a client command: "set_strategy" switches the
+// balancer's strategy, {strategy}; "set_load" sets the arrival rate as a
+// share of the total capacity, {utilization}
+func (s *Simulation) HandleClientRequest(command string, payload map[string]interface{}) error {
+	switch command {
+	case "set_strategy":
+		strategy, _ := payload["strategy"].(string)
+		switch strategy {
+		case "random", "round_robin", "power_of_two":
+		default:
+			return fmt.Errorf("unknown strategy: %q", strategy)
+		}
+		s.mu.Lock()
+		s.strategy = strategy
+		s.mu.Unlock()
+		s.broadcast(map[string]interface{}{
+			"type":     "strategy_changed",
+			"strategy": strategy,
+		})
+		return nil
+	case "set_load":
+		utilization, _ := payload["utilization"].(float64)
+		if utilization <= 0 || utilization > 2 {
+			return fmt.Errorf("utilization must be in (0, 2], got %v", utilization)
+		}
+		s.mu.Lock()
+		s.utilization = utilization
+		s.mu.Unlock()
+		s.broadcast(map[string]interface{}{
+			"type":        "load_changed",
+			"utilization": utilization,
+		})
+		return nil
+	}
+	return fmt.Errorf("unknown command: %s", command)
+}
+
+// findBackend looks up a backend by ID
+func (s *Simulation) findBackend(nodeID string) *Backend {
+	for _, backend := range s.backends {
+		if backend.id == nodeID {
+			return backend
+		}
+	}
+	return nil
+}
+
+// currentStrategy returns the balancer's strategy
+func (s *Simulation) currentStrategy() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.strategy
+}
+
+// arrivals returns how many requests arrive in a tick, on average the
+// utilization times the capacity
+func (s *Simulation) arrivals() int {
+	s.mu.RLock()
+	rate := s.utilization * s.capacity
+	s.mu.RUnlock()
+
+	n := int(rate)
+	if s.rng.Float64() < rate-float64(n) {
+		n++
+	}
+	return n
+}
+
+// advanceSchedule streams the backends' queue depths, once per tick
+func (s *Simulation) advanceSchedule(ticks int) {
+	s.mu.Lock()
+	if ticks <= s.lastTick {
+		s.mu.Unlock()
+		return
+	}
+	s.lastTick = ticks
+	s.mu.Unlock()
+
+	depths := make(map[string]int, len(s.backends))
+	for _, backend := range s.backends {
+		depths[backend.id] = backend.queueDepth()
+	}
+
+	s.mu.Lock()
+	for id, depth := range depths {
+		s.maxDepth[id] = max(s.maxDepth[id], depth)
+	}
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":      "queue_depths",
+		"depths":    depths,
+		"imbalance": imbalance(depths),
+	})
+}
+
+// imbalance returns the deepest queue over the mean depth: 1 when the
+// load is even
+func imbalance(depths map[string]int) float64 {
+	total, deepest := 0, 0
+	for _, depth := range depths {
+		total += depth
+		deepest = max(deepest, depth)
+	}
+	if total == 0 {
+		return 1
+	}
+	return float64(deepest) * float64(len(depths)) / float64(total)
+}
+
+// percentiles returns the median and 99th percentile of response times
+func percentiles(times []int) (int, int) {
+	if len(times) == 0 {
+		return 0, 0
+	}
+	sort.Ints(times)
+	at := func(p float64) int {
+		return times[int(math.Ceil(p*float64(len(times))))-1]
+	}
+	return at(0.5), at(0.99)
+}
+
+// send transmits a message and reports it to the frontend
+func (s *Simulation) send(from, to string, msgType transport.MessageType, payload Payload) {
+	env := transport.NewEnvelope(from, to, msgType, payload)
+
+	s.mu.Lock()
+	s.messages++
+	s.mu.Unlock()
+
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageSent,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+
+	s.transport.Send(s.ctx, env)
+}
+
+// received reports a delivered message and returns its payload
+func (s *Simulation) received(env *transport.Envelope) Payload {
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageReceived,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+	payload, _ := env.Payload.(Payload)
+	return payload
+}
@@ -0,0 +1,59 @@
+package loadbalancing
+
+import (
+	"fmt"
+
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+)
+
+const (
+	boundedDepth = 20 // Requests a backend may hold before its queue counts as runaway
+	tailTicks    = 40 // Response time the 99th percentile should stay under
+)
+
+// Invariants judges the strategy on the queues it leaves behind and the
+// response times clients see
+func (s *Simulation) Invariants() []protocol.InvariantResult {
+	deepest, at := 0, ""
+	for _, backend := range s.backends {
+		if depth := backend.queueDepth(); depth > deepest {
+			deepest, at = depth, backend.id
+		}
+	}
+	p50, p99 := percentiles(s.balancer.responseTimes())
+
+	s.mu.RLock()
+	strategy, utilization := s.strategy, s.utilization
+	s.mu.RUnlock()
+
+	queues := fmt.Sprintf("every queue holds at most %d requests", deepest)
+	if at != "" {
+		queues = fmt.Sprintf("%s holds %d requests", at, deepest)
+	}
+	return []protocol.InvariantResult{
+		{
+			Name:   "queues stay bounded",
+			Holds:  deepest <= boundedDepth,
+			Detail: fmt.Sprintf("%s with %s at %.0f%% of capacity", queues, strategy, 100*utilization),
+		},
+		{
+			Name:   "tail latency stays low",
+			Holds:  p99 <= tailTicks,
+			Detail: fmt.Sprintf("p50 %d ticks, p99 %d ticks (limit %d)", p50, p99, tailTicks),
+		},
+	}
+}
+
+// FollowUps suggests the scenarios that contrast with this one
+func (s *Simulation) FollowUps() []protocol.FollowUp {
+	if s.scenario == "power_of_two" {
+		return []protocol.FollowUp{
+			{Project: "load-balancing", Scenario: "round_robin", Reason: "Share requests evenly and watch the slowest backend fall behind"},
+			{Project: "queues", Reason: "Follow what happens inside a queue that fills up"},
+		}
+	}
+	return []protocol.FollowUp{
+		{Project: "load-balancing", Scenario: "power_of_two", Reason: "Compare two backends' load before each request"},
+		{Project: "consistent-hashing", Reason: "Spread keys rather than requests over nodes"},
+	}
+}
@@ -0,0 +1,123 @@
+package locks
+
+import (
+	"fmt"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+// Edge chasing (Chandy-Misra-Haas): a transaction blocked for a while
+// sends a probe along its outgoing wait-for edges. The lock server of the
+// resource it waits on knows who blocks it and passes the probe on to
+// them; a transaction that is itself blocked forwards it the same way,
+// once per probe. If the probe comes back to its initiator, the initiator
+// is on a cycle and the path the probe took is the cycle. The youngest
+// transaction of the cycle is aborted so the older ones make progress and
+// the victim, keeping its age, eventually becomes the oldest.
+//
+// Edges are collected over time, so a cycle may already be broken when it
+// is found; the victim ignores the abort if it is no longer in the wait
+// the cycle was found in.
+const (
+	MsgProbe transport.MessageType = "probe"
+	MsgAbort transport.MessageType = "abort"
+)
+
+const (
+	probeDelay    = 5  // Ticks blocked before looking for a deadlock
+	probeInterval = 20 // Ticks between probes of the same wait
+)
+
+// PathEntry is a transaction a probe went through
+type PathEntry struct {
+	Txn     string `json:"txn"`
+	Attempt int    `json:"attempt"`
+	Started int    `json:"started"`
+}
+
+// entry returns the transaction as seen by probes (must hold t.mu)
+func (t *Transaction) entry() PathEntry {
+	return PathEntry{Txn: t.id, Attempt: t.attempt, Started: t.started}
+}
+
+// initiateProbe starts looking for a cycle through this transaction
+// (must hold t.mu)
+func (t *Transaction) initiateProbe() {
+	t.probes++
+	t.lastProbe = t.ticks
+	t.simulation.send(t.id, t.simulation.owners[t.blockedOn], MsgProbe, Payload{
+		Initiator: t.id,
+		ProbeID:   t.probes,
+		Waiter:    t.id,
+		Resource:  t.blockedOn,
+		Path:      []PathEntry{t.entry()},
+	})
+}
+
+// handleProbe detects a returning probe or passes a foreign one on along
+// our own wait (must hold t.mu)
+func (t *Transaction) handleProbe(payload Payload) {
+	if t.phase != "waiting" {
+		return // Not blocked, so no cycle goes through us
+	}
+
+	if payload.Initiator == t.id {
+		if payload.ProbeID >= t.waitProbes {
+			t.deadlockFound(payload.Path)
+		}
+		return
+	}
+
+	key := fmt.Sprintf("%s/%d", payload.Initiator, payload.ProbeID)
+	if t.forwarded[key] {
+		return
+	}
+	t.forwarded[key] = true
+
+	t.simulation.send(t.id, t.simulation.owners[t.blockedOn], MsgProbe, Payload{
+		Initiator: payload.Initiator,
+		ProbeID:   payload.ProbeID,
+		Waiter:    t.id,
+		Resource:  t.blockedOn,
+		Path:      append(append([]PathEntry{}, payload.Path...), t.entry()),
+	})
+}
+
+// forwardProbe passes a probe from a waiting transaction on to the
+// transactions it waits for (must hold s.mu)
+func (s *LockServer) forwardProbe(payload Payload) {
+	if _, ok := s.resources[payload.Resource]; !ok {
+		return
+	}
+	for _, blocker := range s.blockers(payload.Resource, payload.Waiter) {
+		s.simulation.send(s.id, blocker, MsgProbe, payload)
+	}
+}
+
+// deadlockFound breaks the cycle a probe went around by aborting its
+// youngest member (must hold t.mu)
+func (t *Transaction) deadlockFound(cycle []PathEntry) {
+	t.detected++
+
+	victim := cycle[0]
+	members := make([]string, len(cycle))
+	for i, e := range cycle {
+		members[i] = e.Txn
+		if e.Started > victim.Started || (e.Started == victim.Started && e.Txn > victim.Txn) {
+			victim = e
+		}
+	}
+
+	t.simulation.broadcast(map[string]interface{}{
+		"type":   "deadlock_detected",
+		"nodeId": t.id,
+		"cycle":  members,
+		"victim": victim.Txn,
+	})
+
+	if victim.Txn == t.id {
+		t.abort()
+		return
+	}
+	t.simulation.send(t.id, victim.Txn, MsgAbort, Payload{Txn: victim.Txn, Attempt: victim.Attempt})
+}
@@ -0,0 +1,303 @@
+package locks
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+const (
+	MsgLock    transport.MessageType = "lock"
+	MsgRelease transport.MessageType = "release"
+	MsgGranted transport.MessageType = "granted"
+	MsgBlocked transport.MessageType = "blocked"
+)
+
+// Edge is an edge of the wait-for graph: From waits for To to release
+// Resource
+type Edge struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Resource string `json:"resource"`
+}
+
+// lockRequest is a transaction's request for a resource, or its grant
+type lockRequest struct {
+	txn     string
+	mode    string // "read", "write"
+	attempt int
+}
+
+// resourceLock is the lock table entry of one resource. Requests are
+// granted in FIFO order; readers share the lock, writers hold it alone.
+type resourceLock struct {
+	holders []lockRequest
+	queue   []lockRequest
+}
+
+// conflicts reports whether two lock modes exclude each other
+func conflicts(a, b string) bool {
+	return a == "write" || b == "write"
+}
+
+// LockServer manages the locks of the resources it owns
+type LockServer struct {
+	mu sync.RWMutex
+
+	id     string
+	status string
+	ticks  int
+
+	resources map[string]*resourceLock
+	released  map[string]int // Txn -> last attempt released; older requests are stale
+	lastEdges string         // Signature of the last wait-for edges streamed
+
+	inbox      chan *transport.Envelope
+	simulation *Simulation
+}
+
+func newLockServer(id string, resources []string, sim *Simulation) *LockServer {
+	s := &LockServer{
+		id:         id,
+		status:     "running",
+		resources:  make(map[string]*resourceLock),
+		released:   make(map[string]int),
+		inbox:      make(chan *transport.Envelope, 500),
+		simulation: sim,
+	}
+	for _, r := range resources {
+		s.resources[r] = &resourceLock{}
+	}
+	return s
+}
+
+// LockServer implements engine.NodeController
+
+func (s *LockServer) ID() string {
+	return s.id
+}
+
+func (s *LockServer) Start(ctx context.Context) error {
+	return nil
+}
+
+func (s *LockServer) Stop() error {
+	return nil
+}
+
+func (s *LockServer) Tick() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ticks++
+	if s.status != "running" {
+		return
+	}
+
+	for i := 0; i < 50; i++ {
+		select {
+		case env := <-s.inbox:
+			s.processMessage(env)
+			continue
+		default:
+		}
+		break
+	}
+
+	s.streamEdges()
+}
+
+func (s *LockServer) GetState() map[string]interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	table := make(map[string]interface{}, len(s.resources))
+	for name, res := range s.resources {
+		holders := make([]map[string]string, 0, len(res.holders))
+		for _, h := range res.holders {
+			holders = append(holders, map[string]string{"txn": h.txn, "mode": h.mode})
+		}
+		queue := make([]map[string]string, 0, len(res.queue))
+		for _, q := range res.queue {
+			queue = append(queue, map[string]string{"txn": q.txn, "mode": q.mode})
+		}
+		table[name] = map[string]interface{}{
+			"holders": holders,
+			"queue":   queue,
+		}
+	}
+
+	return map[string]interface{}{
+		"id":        s.id,
+		"status":    s.status,
+		"resources": table,
+		"edges":     s.edges(),
+	}
+}
+
+func (s *LockServer) handleMessage(env *transport.Envelope) {
+	s.mu.RLock()
+	crashed := s.status != "running"
+	s.mu.RUnlock()
+
+	// Crashed servers do not receive anything
+	if crashed {
+		return
+	}
+	select {
+	case s.inbox <- env:
+	default:
+	}
+}
+
+func (s *LockServer) processMessage(env *transport.Envelope) {
+	sim := s.simulation
+	payload := sim.received(env)
+
+	switch env.Type {
+	case MsgLock:
+		s.handleLock(payload)
+
+	case MsgRelease:
+		// Drops every lock and request of the attempt, whether it
+		// committed or was aborted
+		if payload.Attempt > s.released[payload.Txn] {
+			s.released[payload.Txn] = payload.Attempt
+		}
+		for name, res := range s.resources {
+			res.holders = dropTxn(res.holders, payload.Txn, payload.Attempt)
+			res.queue = dropTxn(res.queue, payload.Txn, payload.Attempt)
+			s.grant(name)
+		}
+
+	case MsgProbe:
+		s.forwardProbe(payload)
+	}
+}
+
+func (s *LockServer) handleLock(payload Payload) {
+	sim := s.simulation
+
+	res, ok := s.resources[payload.Resource]
+	if !ok || payload.Attempt <= s.released[payload.Txn] {
+		return // Not ours, or the attempt already ended
+	}
+
+	res.queue = append(res.queue, lockRequest{txn: payload.Txn, mode: payload.Mode, attempt: payload.Attempt})
+	s.grant(payload.Resource)
+
+	if blockers := s.blockers(payload.Resource, payload.Txn); len(blockers) > 0 {
+		sim.send(s.id, payload.Txn, MsgBlocked, Payload{
+			Txn:      payload.Txn,
+			Attempt:  payload.Attempt,
+			Resource: payload.Resource,
+			Blockers: blockers,
+		})
+	}
+}
+
+// grant hands the lock to queued requests in FIFO order while they are
+// compatible with the current holders
+func (s *LockServer) grant(name string) {
+	sim := s.simulation
+	res := s.resources[name]
+
+	for len(res.queue) > 0 {
+		head := res.queue[0]
+		for _, h := range res.holders {
+			if conflicts(h.mode, head.mode) {
+				return
+			}
+		}
+		res.queue = res.queue[1:]
+		res.holders = append(res.holders, head)
+		sim.send(s.id, head.txn, MsgGranted, Payload{
+			Txn:      head.txn,
+			Attempt:  head.attempt,
+			Resource: name,
+			Mode:     head.mode,
+		})
+	}
+}
+
+// blockers returns the transactions a queued request of txn waits for:
+// conflicting holders and conflicting requests queued ahead of it
+func (s *LockServer) blockers(name, txn string) []string {
+	res := s.resources[name]
+
+	pos := -1
+	for i, q := range res.queue {
+		if q.txn == txn {
+			pos = i
+			break
+		}
+	}
+	if pos < 0 {
+		return nil
+	}
+	mode := res.queue[pos].mode
+
+	seen := make(map[string]bool)
+	blockers := make([]string, 0)
+	for _, other := range append(append([]lockRequest{}, res.holders...), res.queue[:pos]...) {
+		if other.txn != txn && conflicts(other.mode, mode) && !seen[other.txn] {
+			seen[other.txn] = true
+			blockers = append(blockers, other.txn)
+		}
+	}
+	sort.Strings(blockers)
+	return blockers
+}
+
+// edges returns this server's part of the wait-for graph
+func (s *LockServer) edges() []Edge {
+	names := make([]string, 0, len(s.resources))
+	for name := range s.resources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	edges := make([]Edge, 0)
+	for _, name := range names {
+		for _, q := range s.resources[name].queue {
+			for _, b := range s.blockers(name, q.txn) {
+				edges = append(edges, Edge{From: q.txn, To: b, Resource: name})
+			}
+		}
+	}
+	return edges
+}
+
+// streamEdges reports the wait-for edges whenever they change
+func (s *LockServer) streamEdges() {
+	edges := s.edges()
+	parts := make([]string, 0, len(edges))
+	for _, e := range edges {
+		parts = append(parts, e.From+">"+e.To+"@"+e.Resource)
+	}
+	signature := strings.Join(parts, ",")
+	if signature == s.lastEdges {
+		return
+	}
+	s.lastEdges = signature
+
+	s.simulation.broadcast(map[string]interface{}{
+		"type":   "wait_for_edges",
+		"nodeId": s.id,
+		"edges":  edges,
+	})
+}
+
+// dropTxn removes the entries of txn up to attempt
+func dropTxn(list []lockRequest, txn string, attempt int) []lockRequest {
+	kept := list[:0]
+	for _, r := range list {
+		if r.txn != txn || r.attempt > attempt {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,337 @@
+package locks
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+	"github.com/ersantana/distributed-systems-learning/packages/simulation/engine"
+)
+
+const serverCount = 3
+
+// resources protected by the lock service; resource i lives on server
+// i mod serverCount
+var resources = []string{"A", "B", "C", "D", "E", "F"}
+
+// Payload is the content of all messages exchanged in this project
+type Payload struct {
+	Txn      string   `json:"txn,omitempty"`
+	Attempt  int      `json:"attempt,omitempty"`
+	Resource string   `json:"resource,omitempty"`
+	Mode     string   `json:"mode,omitempty"`
+	Blockers []string `json:"blockers,omitempty"`
+
+	// Probes
+	Initiator string      `json:"initiator,omitempty"`
+	ProbeID   int         `json:"probeId,omitempty"`
+	Waiter    string      `json:"waiter,omitempty"`
+	Path      []PathEntry `json:"path,omitempty"`
+}
+
+// Simulation runs a lock service: lock servers own the resources and
+// transactions lock several of them with two-phase locking, keeping what
+// they hold while they wait for the rest. Transactions that wait on each
+// other in a cycle are deadlocked; blocked transactions find such cycles
+// by edge chasing and abort one of their members.
+type Simulation struct {
+	mu sync.RWMutex
+
+	engine    *engine.Engine
+	rng       *rand.Rand
+	transport *transport.NetworkTransport
+	broadcast func(interface{})
+
+	servers      []*LockServer
+	transactions []*Transaction
+	owners       map[string]string // Resource -> server
+	scenario     string
+	detection    bool
+
+	running bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// Config for lock service simulation
+type Config struct {
+	NodeCount int    // Transactions
+	Scenario  string // "deadlock", "random", "readers", "ordered", "no_detection"
+}
+
+// NewSimulation creates a new lock service simulation
+func NewSimulation(eng *engine.Engine, trans *transport.NetworkTransport, broadcast func(interface{}), config Config) *Simulation {
+	switch config.Scenario {
+	case "deadlock", "random", "readers", "ordered", "no_detection":
+	default:
+		config.Scenario = "deadlock"
+	}
+	if config.NodeCount == 0 {
+		config.NodeCount = 4
+	}
+	if config.Scenario == "deadlock" || config.Scenario == "no_detection" {
+		config.NodeCount = 3 // One per edge of the scripted cycle
+	}
+
+	sim := &Simulation{
+		engine:    eng,
+		rng:       eng.Rand(),
+		transport: trans,
+		broadcast: broadcast,
+		owners:    make(map[string]string),
+		scenario:  config.Scenario,
+		detection: config.Scenario != "no_detection",
+	}
+
+	trans.SetLatency(20*time.Millisecond, 80*time.Millisecond)
+	trans.SetPacketLoss(0)
+
+	owned := make(map[string][]string)
+	for i, r := range resources {
+		server := fmt.Sprintf("server-%d", i%serverCount+1)
+		sim.owners[r] = server
+		owned[server] = append(owned[server], r)
+	}
+	for i := 0; i < serverCount; i++ {
+		id := fmt.Sprintf("server-%d", i+1)
+		server := newLockServer(id, owned[id], sim)
+		sim.servers = append(sim.servers, server)
+		trans.RegisterHandler(id, server.handleMessage)
+		eng.AddNode(server)
+	}
+	for i := 0; i < config.NodeCount; i++ {
+		txn := newTransaction(fmt.Sprintf("txn-%d", i+1), i, sim)
+		sim.transactions = append(sim.transactions, txn)
+		trans.RegisterHandler(txn.id, txn.handleMessage)
+		eng.AddNode(txn)
+	}
+
+	return sim
+}
+
+// Start starts the simulation
+func (s *Simulation) Start(ctx context.Context) error {
+	s.mu.Lock()
+	s.running = true
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.mu.Unlock()
+
+	return s.engine.Start(ctx)
+}
+
+// Stop stops the simulation
+func (s *Simulation) Stop() error {
+	s.mu.Lock()
+	s.running = false
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Unlock()
+
+	return s.engine.Stop()
+}
+
+// GetState returns the current simulation state
+func (s *Simulation) GetState() *protocol.SimulationStateResponse {
+	nodes := make(map[string]protocol.NodeState)
+	waitFor := make([]Edge, 0)
+	for _, server := range s.servers {
+		state := server.GetState()
+		nodes[server.id] = protocol.NodeState{
+			ID:          server.id,
+			Status:      state["status"].(string),
+			Role:        "lock-server",
+			CustomState: state,
+		}
+		waitFor = append(waitFor, state["edges"].([]Edge)...)
+	}
+
+	commits, aborts, detected := 0, 0, 0
+	for _, txn := range s.transactions {
+		state := txn.GetState()
+		nodes[txn.id] = protocol.NodeState{
+			ID:          txn.id,
+			Status:      state["status"].(string),
+			Role:        "transaction",
+			CustomState: state,
+		}
+		commits += state["commits"].(int)
+		aborts += state["aborts"].(int)
+		detected += state["deadlocksDetected"].(int)
+	}
+
+	s.mu.RLock()
+	running := s.running
+	s.mu.RUnlock()
+
+	mode := "step"
+	if s.engine != nil {
+		mode = s.engine.GetMode().String()
+	}
+
+	return &protocol.SimulationStateResponse{
+		Type:        protocol.MsgSimulationState,
+		VirtualTime: time.Now().UnixMilli(),
+		Mode:        mode,
+		Speed:       1.0,
+		Running:     running,
+		Nodes:       nodes,
+		Metadata: map[string]interface{}{
+			"scenario":          s.scenario,
+			"detection":         s.detection,
+			"owners":            s.owners,
+			"waitFor":           waitFor,
+			"deadlocked":        deadlocked(waitFor),
+			"commits":           commits,
+			"aborts":            aborts,
+			"deadlocksDetected": detected,
+		},
+	}
+}
+
+// GetNodes returns node states
+func (s *Simulation) GetNodes() map[string]protocol.NodeState {
+	state := s.GetState()
+	return state.Nodes
+}
+
+// Layout draws the lock servers and the transactions as two columns, the
+// wait-for edges running between them
+func (s *Simulation) Layout() *protocol.Layout {
+	servers := make([]string, len(s.servers))
+	for i, server := range s.servers {
+		servers[i] = server.id
+	}
+	transactions := make([]string, len(s.transactions))
+	for i, txn := range s.transactions {
+		transactions[i] = txn.id
+	}
+	return &protocol.Layout{
+		Kind: protocol.LayoutGroups,
+		Groups: []protocol.LayoutGroup{
+			{Name: "servers", Nodes: servers},
+			{Name: "transactions", Nodes: transactions},
+		},
+	}
+}
+
+// CrashNode crashes a lock server or a transaction. A crashed server
+// keeps its lock table; a crashed transaction keeps its locks, blocking
+// everyone waiting for them.
+func (s *Simulation) CrashNode(nodeID string) error {
+	if server := s.findServer(nodeID); server != nil {
+		server.mu.Lock()
+		server.status = "crashed"
+		server.mu.Unlock()
+		return nil
+	}
+	if txn := s.findTransaction(nodeID); txn != nil {
+		txn.mu.Lock()
+		txn.status = "crashed"
+		txn.mu.Unlock()
+		return nil
+	}
+	return fmt.Errorf("unknown node: %s", nodeID)
+}
+
+// RecoverNode recovers a crashed node
+func (s *Simulation) RecoverNode(nodeID string) error {
+	if server := s.findServer(nodeID); server != nil {
+		server.mu.Lock()
+		server.status = "running"
+		server.mu.Unlock()
+		return nil
+	}
+	if txn := s.findTransaction(nodeID); txn != nil {
+		txn.mu.Lock()
+		txn.status = "running"
+		txn.mu.Unlock()
+		return nil
+	}
+	return fmt.Errorf("unknown node: %s", nodeID)
+}
+
+// findServer looks up a lock server by ID
+func (s *Simulation) findServer(nodeID string) *LockServer {
+	for _, server := range s.servers {
+		if server.id == nodeID {
+			return server
+		}
+	}
+	return nil
+}
+
+// findTransaction looks up a transaction by ID
+func (s *Simulation) findTransaction(nodeID string) *Transaction {
+	for _, txn := range s.transactions {
+		if txn.id == nodeID {
+			return txn
+		}
+	}
+	return nil
+}
+
+// send transmits a message and reports it to the frontend
+func (s *Simulation) send(from, to string, msgType transport.MessageType, payload Payload) {
+	env := transport.NewEnvelope(from, to, msgType, payload)
+
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageSent,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+
+	s.transport.Send(s.ctx, env)
+}
+
+// received reports a delivered message and returns its payload
+func (s *Simulation) received(env *transport.Envelope) Payload {
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageReceived,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+	payload, _ := env.Payload.(Payload)
+	return payload
+}
+
+// deadlocked returns the transactions on a cycle of the wait-for graph,
+// the ground truth the detection algorithm is trying to find
+func deadlocked(edges []Edge) []string {
+	out := make(map[string][]string)
+	for _, e := range edges {
+		out[e.From] = append(out[e.From], e.To)
+	}
+
+	// A transaction is deadlocked if it can reach itself
+	onCycle := make([]string, 0)
+	for start := range out {
+		visited := make(map[string]bool)
+		stack := append([]string{}, out[start]...)
+		for len(stack) > 0 {
+			next := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if next == start {
+				onCycle = append(onCycle, start)
+				break
+			}
+			if !visited[next] {
+				visited[next] = true
+				stack = append(stack, out[next]...)
+			}
+		}
+	}
+	sort.Strings(onCycle)
+	return onCycle
+}
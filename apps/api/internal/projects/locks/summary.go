@@ -0,0 +1,51 @@
+package locks
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+)
+
+// Invariants judges the run: no transaction is left in a deadlock, and the
+// transactions got work done
+func (s *Simulation) Invariants() []protocol.InvariantResult {
+	metadata := s.GetState().Metadata
+	stuck := metadata["deadlocked"].([]string)
+	commits := metadata["commits"].(int)
+
+	return []protocol.InvariantResult{
+		{
+			Name:   "no deadlock",
+			Holds:  len(stuck) == 0,
+			Detail: fmt.Sprintf("waiting in a cycle at the end: %s", strings.Join(stuck, ", ")),
+		},
+		{
+			Name:   "progress",
+			Holds:  commits > 0,
+			Detail: fmt.Sprintf("%d commits, %d aborts", commits, metadata["aborts"].(int)),
+		},
+	}
+}
+
+// FollowUps suggests the scenarios that contrast with this one
+func (s *Simulation) FollowUps() []protocol.FollowUp {
+	switch s.scenario {
+	case "deadlock":
+		return []protocol.FollowUp{
+			{Project: "locks", Scenario: "no_detection", Reason: "The same crossing transactions without probes stay deadlocked forever"},
+			{Project: "locks", Scenario: "ordered", Reason: "Acquiring locks in a global order prevents the deadlock instead of breaking it"},
+		}
+	case "no_detection":
+		return []protocol.FollowUp{
+			{Project: "locks", Scenario: "deadlock", Reason: "Edge-chasing probes find the cycle and abort the youngest transaction"},
+		}
+	case "ordered":
+		return []protocol.FollowUp{
+			{Project: "locks", Scenario: "random", Reason: "Without the global order, random lock plans run into deadlocks"},
+		}
+	}
+	return []protocol.FollowUp{
+		{Project: "locks", Scenario: "deadlock", Reason: "Watch a deadlock being built on purpose and detected"},
+	}
+}
@@ -0,0 +1,337 @@
+package locks
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+const (
+	holdTicks    = 8  // Ticks a transaction works once it has all its locks
+	thinkTicks   = 6  // Ticks between transactions
+	restartTicks = 10 // Ticks an aborted transaction waits before retrying
+)
+
+// Step is one lock a transaction needs
+type Step struct {
+	Resource string `json:"resource"`
+	Mode     string `json:"mode"` // "read", "write"
+}
+
+// Transaction is a client running transactions with two-phase locking: it
+// locks the resources of its plan one after the other, works while holding
+// all of them, then releases everything at once.
+type Transaction struct {
+	mu sync.RWMutex
+
+	id     string
+	index  int
+	status string // "running", "crashed"
+	phase  string // "thinking", "acquiring", "waiting", "holding", "aborted"
+	ticks  int
+
+	started int // Age used to pick victims; kept across restarts
+	attempt int
+	plan    []Step
+	next    int // Step waiting for its lock
+	held    map[string]string
+	wakeAt  int
+
+	// Waiting
+	blockedOn    string
+	blockers     []string
+	blockedSince int
+	probes       int             // Probes initiated, used as probe IDs
+	waitProbes   int             // First probe ID of the current wait
+	lastProbe    int             // Tick the last probe was initiated
+	forwarded    map[string]bool // Probes already passed on during this wait
+
+	commits  int
+	aborts   int
+	detected int
+
+	inbox      chan *transport.Envelope
+	simulation *Simulation
+}
+
+func newTransaction(id string, index int, sim *Simulation) *Transaction {
+	return &Transaction{
+		id:         id,
+		index:      index,
+		status:     "running",
+		phase:      "thinking",
+		held:       make(map[string]string),
+		forwarded:  make(map[string]bool),
+		wakeAt:     5,
+		inbox:      make(chan *transport.Envelope, 500),
+		simulation: sim,
+	}
+}
+
+// Transaction implements engine.NodeController
+
+func (t *Transaction) ID() string {
+	return t.id
+}
+
+func (t *Transaction) Start(ctx context.Context) error {
+	return nil
+}
+
+func (t *Transaction) Stop() error {
+	return nil
+}
+
+func (t *Transaction) Tick() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.ticks++
+	if t.status != "running" {
+		return
+	}
+
+	for i := 0; i < 50; i++ {
+		select {
+		case env := <-t.inbox:
+			t.processMessage(env)
+			continue
+		default:
+		}
+		break
+	}
+
+	switch t.phase {
+	case "thinking", "aborted":
+		if t.ticks >= t.wakeAt {
+			t.begin()
+		}
+
+	case "waiting":
+		if !t.simulation.detection || t.ticks-t.blockedSince < probeDelay {
+			break
+		}
+		if t.probes < t.waitProbes || t.ticks-t.lastProbe >= probeInterval {
+			t.initiateProbe()
+		}
+
+	case "holding":
+		if t.ticks >= t.wakeAt {
+			t.finish()
+		}
+	}
+}
+
+func (t *Transaction) GetState() map[string]interface{} {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	plan := make([]string, len(t.plan))
+	for i, step := range t.plan {
+		plan[i] = step.Resource + ":" + step.Mode
+	}
+	held := make(map[string]string, len(t.held))
+	for r, mode := range t.held {
+		held[r] = mode
+	}
+
+	return map[string]interface{}{
+		"id":                t.id,
+		"status":            t.status,
+		"phase":             t.phase,
+		"started":           t.started,
+		"attempt":           t.attempt,
+		"plan":              plan,
+		"held":              held,
+		"blockedOn":         t.blockedOn,
+		"blockers":          append([]string{}, t.blockers...),
+		"commits":           t.commits,
+		"aborts":            t.aborts,
+		"deadlocksDetected": t.detected,
+	}
+}
+
+func (t *Transaction) handleMessage(env *transport.Envelope) {
+	t.mu.RLock()
+	crashed := t.status != "running"
+	t.mu.RUnlock()
+
+	// Crashed transactions do not receive anything
+	if crashed {
+		return
+	}
+	select {
+	case t.inbox <- env:
+	default:
+	}
+}
+
+func (t *Transaction) processMessage(env *transport.Envelope) {
+	payload := t.simulation.received(env)
+
+	switch env.Type {
+	case MsgGranted:
+		if payload.Attempt != t.attempt || !t.expecting(payload.Resource) {
+			return // The server drops locks of ended attempts itself
+		}
+		t.held[payload.Resource] = payload.Mode
+		t.next++
+		t.stopWaiting()
+		if t.next == len(t.plan) {
+			t.phase = "holding"
+			t.wakeAt = t.ticks + holdTicks
+			return
+		}
+		t.request()
+
+	case MsgBlocked:
+		if payload.Attempt != t.attempt || !t.expecting(payload.Resource) || t.phase != "acquiring" {
+			return // Stale, or granted meanwhile
+		}
+		t.phase = "waiting"
+		t.blockedOn = payload.Resource
+		t.blockers = payload.Blockers
+		t.blockedSince = t.ticks
+		t.waitProbes = t.probes + 1
+
+	case MsgProbe:
+		t.handleProbe(payload)
+
+	case MsgAbort:
+		// Only the wait the cycle was found in is aborted; if it ended,
+		// the cycle is gone already
+		if payload.Attempt == t.attempt && t.phase == "waiting" {
+			t.abort()
+		}
+	}
+}
+
+// expecting reports whether resource is the one the transaction is
+// trying to lock
+func (t *Transaction) expecting(resource string) bool {
+	return t.next < len(t.plan) && t.plan[t.next].Resource == resource
+}
+
+// begin starts a new attempt. An aborted transaction retries the same
+// plan; a committed one moves on to a new one (must hold t.mu).
+func (t *Transaction) begin() {
+	if t.phase == "thinking" {
+		t.plan = t.newPlan()
+		t.started = t.ticks
+	}
+	t.attempt++
+	t.next = 0
+	t.held = make(map[string]string)
+	t.request()
+}
+
+// request asks for the next lock of the plan (must hold t.mu)
+func (t *Transaction) request() {
+	step := t.plan[t.next]
+	t.phase = "acquiring"
+	t.simulation.send(t.id, t.simulation.owners[step.Resource], MsgLock, Payload{
+		Txn:      t.id,
+		Attempt:  t.attempt,
+		Resource: step.Resource,
+		Mode:     step.Mode,
+	})
+}
+
+// finish commits and releases every lock (must hold t.mu)
+func (t *Transaction) finish() {
+	t.releaseAll()
+	t.commits++
+	t.phase = "thinking"
+	t.wakeAt = t.ticks + thinkTicks + t.simulation.rng.Intn(thinkTicks)
+
+	t.simulation.broadcast(map[string]interface{}{
+		"type":    "transaction_committed",
+		"nodeId":  t.id,
+		"attempt": t.attempt,
+	})
+}
+
+// abort gives up the attempt, releasing the locks it holds and the
+// request it waits on (must hold t.mu)
+func (t *Transaction) abort() {
+	held := make([]string, 0, len(t.held))
+	for r := range t.held {
+		held = append(held, r)
+	}
+	sort.Strings(held)
+
+	t.releaseAll()
+	t.stopWaiting()
+	t.aborts++
+	t.phase = "aborted"
+	t.wakeAt = t.ticks + restartTicks + t.simulation.rng.Intn(restartTicks)
+
+	t.simulation.broadcast(map[string]interface{}{
+		"type":    "transaction_aborted",
+		"nodeId":  t.id,
+		"attempt": t.attempt,
+		"held":    held,
+	})
+}
+
+// releaseAll tells every server the attempt touched to drop its locks
+// and requests (must hold t.mu)
+func (t *Transaction) releaseAll() {
+	servers := make(map[string]bool)
+	for i := 0; i <= t.next && i < len(t.plan); i++ {
+		servers[t.simulation.owners[t.plan[i].Resource]] = true
+	}
+	ids := make([]string, 0, len(servers))
+	for server := range servers {
+		ids = append(ids, server)
+	}
+	sort.Strings(ids)
+	for _, server := range ids {
+		t.simulation.send(t.id, server, MsgRelease, Payload{Txn: t.id, Attempt: t.attempt})
+	}
+	t.held = make(map[string]string)
+}
+
+// stopWaiting clears the wait state (must hold t.mu)
+func (t *Transaction) stopWaiting() {
+	t.blockedOn = ""
+	t.blockers = nil
+	t.forwarded = make(map[string]bool)
+}
+
+// newPlan picks the locks of the next transaction. The first transaction
+// of the scripted scenarios locks its own resource and then its
+// neighbour's, closing a cycle across all servers.
+func (t *Transaction) newPlan() []Step {
+	sim := t.simulation
+	n := len(sim.transactions)
+
+	if (sim.scenario == "deadlock" || sim.scenario == "no_detection") && t.commits == 0 {
+		return []Step{
+			{Resource: resources[t.index], Mode: "write"},
+			{Resource: resources[(t.index+1)%n], Mode: "write"},
+		}
+	}
+
+	writes := 0.7
+	if sim.scenario == "readers" {
+		writes = 0.2
+	}
+	picked := t.simulation.rng.Perm(len(resources))[:2+t.simulation.rng.Intn(2)]
+	if sim.scenario == "ordered" {
+		// Locking in a global order makes cycles impossible
+		sort.Ints(picked)
+	}
+
+	plan := make([]Step, len(picked))
+	for i, r := range picked {
+		mode := "read"
+		if t.simulation.rng.Float64() < writes {
+			mode = "write"
+		}
+		plan[i] = Step{Resource: resources[r], Mode: mode}
+	}
+	return plan
+}
@@ -0,0 +1,368 @@
+package mapreduce
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+const (
+	deadTicks       = 10  // Silence after which the coordinator declares a worker failed
+	restartTicks    = 20  // Ticks between a job completing and the next one starting
+	stragglerFactor = 1.5 // A task running this many times the median run time gets a backup
+)
+
+// Coordinator keeps every task's state and hands an idle task to each
+// worker that asks, map tasks first and reduce tasks once every map task
+// has completed. A worker silent for too long is declared failed and its
+// tasks go back to idle. Near the end of a phase, with no idle task left,
+// a worker that asks gets a backup attempt of the slowest running task
+// instead; whichever attempt reports first completes the task, and the
+// other's output is discarded.
+type Coordinator struct {
+	mu sync.RWMutex
+
+	id     string
+	status string // "running" or "crashed"
+	ticks  int
+
+	job      int
+	jobStart int
+	doneAt   int
+	maps     []*Task
+	reduces  []*Task
+
+	mapOutputs map[int][]map[string]int // Map task -> its partitions, on the disk of its worker
+	output     map[string]int           // Reduce outputs so far, on the shared file system
+
+	lastSeen map[string]int // Worker -> tick of its last message
+	failed   map[string]bool
+	runTimes map[string][]int // Kind -> ticks the completed attempts ran
+
+	inbox      chan *transport.Envelope
+	simulation *Simulation
+}
+
+func newCoordinator(id string, sim *Simulation) *Coordinator {
+	c := &Coordinator{
+		id:         id,
+		status:     "running",
+		lastSeen:   make(map[string]int),
+		failed:     make(map[string]bool),
+		runTimes:   make(map[string][]int),
+		inbox:      make(chan *transport.Envelope, 500),
+		simulation: sim,
+	}
+	c.startJob()
+	return c
+}
+
+// Coordinator implements engine.NodeController
+
+func (c *Coordinator) ID() string {
+	return c.id
+}
+
+func (c *Coordinator) Start(ctx context.Context) error {
+	return nil
+}
+
+func (c *Coordinator) Stop() error {
+	return nil
+}
+
+func (c *Coordinator) Tick() {
+	c.simulation.advanceSchedule(c.tick())
+}
+
+func (c *Coordinator) tick() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ticks++
+	if c.status == "crashed" {
+		return c.ticks
+	}
+
+	for i := 0; i < 50; i++ {
+		select {
+		case env := <-c.inbox:
+			c.processMessage(env)
+			continue
+		default:
+		}
+		break
+	}
+
+	for worker, seen := range c.lastSeen {
+		if !c.failed[worker] && c.ticks-seen > deadTicks {
+			c.failWorker(worker)
+		}
+	}
+	if c.phase() == "done" && c.ticks-c.doneAt >= restartTicks {
+		c.startJob()
+	}
+	return c.ticks
+}
+
+func (c *Coordinator) GetState() map[string]interface{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	tasks := make([]Task, 0, len(c.maps)+len(c.reduces))
+	counts := map[string]int{"idle": 0, "in_progress": 0, "completed": 0}
+	for _, task := range append(append([]*Task{}, c.maps...), c.reduces...) {
+		t := *task
+		t.Attempts = append([]Attempt{}, task.Attempts...)
+		tasks = append(tasks, t)
+		counts[task.State]++
+	}
+	failed := make([]string, 0, len(c.failed))
+	for worker, down := range c.failed {
+		if down {
+			failed = append(failed, worker)
+		}
+	}
+	sort.Strings(failed)
+
+	return map[string]interface{}{
+		"id":            c.id,
+		"status":        c.status,
+		"role":          "coordinator",
+		"job":           c.job,
+		"phase":         c.phase(), // "map", "reduce" or "done"
+		"tasks":         tasks,
+		"taskStates":    counts,
+		"failedWorkers": failed,
+	}
+}
+
+func (c *Coordinator) handleMessage(env *transport.Envelope) {
+	c.mu.RLock()
+	down := c.status == "crashed"
+	c.mu.RUnlock()
+
+	if down {
+		return
+	}
+	select {
+	case c.inbox <- env:
+	default:
+	}
+}
+
+func (c *Coordinator) processMessage(env *transport.Envelope) {
+	payload := c.simulation.received(env)
+	worker := env.From
+	c.lastSeen[worker] = c.ticks
+	if c.failed[worker] {
+		delete(c.failed, worker)
+		c.simulation.workerRejoined(c.id, worker)
+	}
+
+	switch env.Type {
+	case MsgGetTask:
+		c.assign(worker)
+	case MsgTaskDone:
+		if payload.Job == c.job {
+			c.complete(worker, payload)
+		}
+	}
+}
+
+// phase returns "map" while a map task is not completed, then "reduce"
+// until every reduce task is, then "done" (must hold c.mu)
+func (c *Coordinator) phase() string {
+	for _, task := range c.maps {
+		if task.State != "completed" {
+			return "map"
+		}
+	}
+	for _, task := range c.reduces {
+		if task.State != "completed" {
+			return "reduce"
+		}
+	}
+	return "done"
+}
+
+// startJob resets every task to idle for a new run of the job (must hold
+// c.mu)
+func (c *Coordinator) startJob() {
+	c.job++
+	c.jobStart = c.ticks
+	c.maps = make([]*Task, len(splits))
+	for i := range c.maps {
+		c.maps[i] = &Task{Kind: "map", ID: i, State: "idle"}
+	}
+	c.reduces = make([]*Task, reduceTasks)
+	for i := range c.reduces {
+		c.reduces[i] = &Task{Kind: "reduce", ID: i, State: "idle"}
+	}
+	c.mapOutputs = make(map[int][]map[string]int)
+	c.output = make(map[string]int)
+	if c.job > 1 {
+		c.simulation.jobStarted(c.id, c.job)
+	}
+}
+
+// assign hands a worker an idle task of the current phase, or else a
+// backup attempt of a straggling one (must hold c.mu)
+func (c *Coordinator) assign(worker string) {
+	tasks := c.maps
+	switch c.phase() {
+	case "reduce":
+		tasks = c.reduces
+	case "done":
+		return
+	}
+	for _, task := range tasks {
+		if task.State == "idle" {
+			c.launch(task, worker, false)
+			return
+		}
+	}
+	if !c.simulation.speculating() {
+		return
+	}
+	if task := c.straggler(tasks, worker); task != nil {
+		c.launch(task, worker, true)
+	}
+}
+
+// straggler returns the running task that has run the longest past the
+// straggler threshold, with no backup yet and not on the asking worker
+// (must hold c.mu)
+func (c *Coordinator) straggler(tasks []*Task, worker string) *Task {
+	threshold := c.stragglerThreshold(tasks[0].Kind)
+	if threshold == 0 {
+		return nil
+	}
+	var slowest *Task
+	longest := 0
+	for _, task := range tasks {
+		if task.State != "in_progress" || len(task.Attempts) != 1 || task.Attempts[0].Worker == worker {
+			continue
+		}
+		if elapsed := c.ticks - task.Attempts[0].Start; elapsed > threshold && elapsed > longest {
+			slowest, longest = task, elapsed
+		}
+	}
+	return slowest
+}
+
+// stragglerThreshold returns the run time past which a task of a kind is
+// straggling, or 0 before any has completed (must hold c.mu)
+func (c *Coordinator) stragglerThreshold(kind string) int {
+	times := append([]int{}, c.runTimes[kind]...)
+	if len(times) == 0 {
+		return 0
+	}
+	sort.Ints(times)
+	return int(stragglerFactor * float64(times[len(times)/2]))
+}
+
+// launch starts an attempt of a task on a worker (must hold c.mu)
+func (c *Coordinator) launch(task *Task, worker string, backup bool) {
+	task.Tries++
+	attempt := Attempt{Number: task.Tries, Worker: worker, Start: c.ticks, Backup: backup}
+	task.Attempts = append(task.Attempts, attempt)
+
+	assignment := Payload{Kind: task.Kind, ID: task.ID, Attempt: attempt.Number, Job: c.job}
+	if task.Kind == "map" {
+		assignment.Split = splits[task.ID]
+	} else {
+		for i := range c.maps {
+			assignment.Partitions = append(assignment.Partitions, c.mapOutputs[i][task.ID])
+		}
+	}
+
+	sim := c.simulation
+	if backup {
+		sim.backupLaunched(c.id, c.job, task, attempt, c.ticks-task.Attempts[0].Start)
+	} else {
+		c.transition(task, "in_progress", worker, attempt.Number, "assigned")
+	}
+	sim.send(c.id, worker, MsgTask, assignment)
+}
+
+// complete records the first attempt of a task to report; a later one is
+// discarded, its output identical and no longer needed (must hold c.mu)
+func (c *Coordinator) complete(worker string, payload Payload) {
+	task := c.maps[0]
+	if payload.Kind == "map" {
+		task = c.maps[payload.ID]
+	} else {
+		task = c.reduces[payload.ID]
+	}
+	sim := c.simulation
+	if task.State == "completed" {
+		sim.attemptDiscarded(c.id, c.job, task, worker, payload.Attempt)
+		return
+	}
+
+	won := false
+	for _, attempt := range task.Attempts {
+		if attempt.Number == payload.Attempt {
+			c.runTimes[task.Kind] = append(c.runTimes[task.Kind], c.ticks-attempt.Start)
+			won = attempt.Backup
+		}
+	}
+	if task.Kind == "map" {
+		c.mapOutputs[task.ID] = payload.Partitions
+	} else {
+		for word, n := range payload.Counts {
+			c.output[word] = n
+		}
+	}
+	task.Worker = worker
+	task.Attempts = nil
+	c.transition(task, "completed", worker, payload.Attempt, "reported")
+	if won {
+		sim.backupWon(c.id, c.job, task, worker)
+	}
+
+	if c.phase() == "done" {
+		c.doneAt = c.ticks
+		sim.jobCompleted(c.id, c.job, c.ticks-c.jobStart, c.output)
+	}
+}
+
+// failWorker declares a silent worker failed: its running attempts are
+// lost, and so is the output of the map tasks it completed while reduce
+// tasks may still need it; each task left without an attempt goes back
+// to idle (must hold c.mu)
+func (c *Coordinator) failWorker(worker string) {
+	c.failed[worker] = true
+	c.simulation.workerFailed(c.id, worker, c.ticks-c.lastSeen[worker])
+
+	reducing := c.phase() != "done"
+	for _, task := range append(append([]*Task{}, c.maps...), c.reduces...) {
+		switch {
+		case task.State == "in_progress":
+			kept := task.Attempts[:0]
+			for _, attempt := range task.Attempts {
+				if attempt.Worker != worker {
+					kept = append(kept, attempt)
+				}
+			}
+			task.Attempts = kept
+			if len(kept) == 0 {
+				c.transition(task, "idle", worker, 0, "worker_failed")
+			}
+		case task.State == "completed" && task.Kind == "map" && task.Worker == worker && reducing:
+			delete(c.mapOutputs, task.ID)
+			task.Worker = ""
+			c.transition(task, "idle", worker, 0, "output_lost")
+		}
+	}
+}
+
+// transition moves a task to a state and reports it (must hold c.mu)
+func (c *Coordinator) transition(task *Task, to, worker string, attempt int, reason string) {
+	from := task.State
+	task.State = to
+	c.simulation.taskChanged(c.id, c.job, task, from, worker, attempt, reason)
+}
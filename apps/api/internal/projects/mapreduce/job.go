@@ -0,0 +1,90 @@
+package mapreduce
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+)
+
+const reduceTasks = 3 // R, the partitions of the intermediate keys
+
+// splits are the job's input, one map task each: a word count over them
+var splits = []string{
+	"the quick brown fox jumps over the lazy dog",
+	"a lazy worker is not a dead worker",
+	"the coordinator reschedules the tasks of a dead worker",
+	"a backup task races the straggler and the first to finish wins",
+	"map tasks write their output to local disk",
+	"a dead worker takes its map output with it",
+	"reduce tasks write their output to the shared file system",
+	"the job is done when every reduce task is done",
+}
+
+// Task is a map task, over a split, or a reduce task, over a partition
+// of every map task's output. Its state machine: idle, in_progress once
+// assigned, completed when an attempt reports first; a failed worker
+// sends its running tasks back to idle, and its completed map tasks too,
+// since their output lived on its disk.
+type Task struct {
+	Kind     string    `json:"kind"` // "map" or "reduce"
+	ID       int       `json:"id"`
+	State    string    `json:"state"`    // "idle", "in_progress", "completed"
+	Attempts []Attempt `json:"attempts"` // Running
+	Tries    int       `json:"tries"`
+	Worker   string    `json:"worker,omitempty"` // Worker whose attempt completed it
+}
+
+// Attempt is one execution of a task on a worker; a backup attempt runs
+// alongside a slow one
+type Attempt struct {
+	Number int    `json:"number"`
+	Worker string `json:"worker"`
+	Start  int    `json:"start"` // Coordinator tick it was assigned
+	Backup bool   `json:"backup,omitempty"`
+}
+
+// name returns the task's label in the timeline, like "map-3"
+func (t *Task) name() string {
+	return fmt.Sprintf("%s-%d", t.Kind, t.ID)
+}
+
+// mapSplit counts the words of a split, bucketed by reduce partition
+func mapSplit(split string) []map[string]int {
+	partitions := make([]map[string]int, reduceTasks)
+	for i := range partitions {
+		partitions[i] = make(map[string]int)
+	}
+	for _, word := range strings.Fields(split) {
+		partitions[partition(word)][word]++
+	}
+	return partitions
+}
+
+// reducePartition sums the counts every map task produced for a partition
+func reducePartition(inputs []map[string]int) map[string]int {
+	counts := make(map[string]int)
+	for _, input := range inputs {
+		for word, n := range input {
+			counts[word] += n
+		}
+	}
+	return counts
+}
+
+// partition hashes a word to its reduce task
+func partition(word string) int {
+	h := fnv.New32a()
+	h.Write([]byte(word))
+	return int(h.Sum32() % reduceTasks)
+}
+
+// sequentialCount is the output the job must produce
+func sequentialCount() map[string]int {
+	counts := make(map[string]int)
+	for _, split := range splits {
+		for _, word := range strings.Fields(split) {
+			counts[word]++
+		}
+	}
+	return counts
+}
@@ -0,0 +1,529 @@
+package mapreduce
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+	"github.com/ersantana/distributed-systems-learning/packages/simulation/engine"
+)
+
+// Payload is the content of all messages exchanged in this project
+type Payload struct {
+	Job        int              `json:"job,omitempty"`
+	Kind       string           `json:"kind,omitempty"` // "map" or "reduce"
+	ID         int              `json:"id"`
+	Attempt    int              `json:"attempt,omitempty"`
+	Split      string           `json:"split,omitempty"`      // Input of a map task
+	Partitions []map[string]int `json:"partitions,omitempty"` // Output of a map task, or input of a reduce task
+	Counts     map[string]int   `json:"counts,omitempty"`     // Output of a reduce task
+}
+
+const (
+	slowdown      = 5  // How many times longer a straggler takes on every task
+	crashAt       = 10 // Tick a worker crashes mid-map in the "worker_crash" scenario
+	secondCrashAt = 30 // Tick a second one crashes, its map output still needed
+	recoverAt     = 150
+)
+
+// jobResult is a completed run of the job
+type jobResult struct {
+	Job     int  `json:"job"`
+	Ticks   int  `json:"ticks"`
+	Correct bool `json:"correct"` // Output matches a sequential word count
+}
+
+// Simulation runs a MapReduce word count, again and again: a coordinator
+// hands map and reduce tasks to workers that ask for them. In
+// "worker_crash" workers crash mid-task and after completing map tasks,
+// and the coordinator re-executes what they took down with them. In
+// "straggler" one worker is five times slower than the others; near the
+// end of each phase the coordinator launches backup attempts of its tasks
+// on idle workers, and the first attempt to finish wins. In
+// "straggler_no_backup" it waits for the straggler, and every job takes
+// as long as its slowest task.
+type Simulation struct {
+	mu sync.RWMutex
+
+	engine    *engine.Engine
+	transport *transport.NetworkTransport
+	broadcast func(interface{})
+
+	coordinator *Coordinator
+	workers     []*Worker
+	scenario    string
+	speculation bool
+
+	expected map[string]int
+	jobs     []jobResult
+
+	backups      int
+	backupsWon   int
+	discarded    int // Duplicate outputs of attempts that lost the race
+	reexecutions int // Tasks sent back to idle by a failed worker
+	failures     int
+	messages     int
+	lastTick     int
+
+	running bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// Config for MapReduce simulation
+type Config struct {
+	NodeCount int
+	Scenario  string // "steady", "worker_crash", "straggler", "straggler_no_backup"
+}
+
+// NewSimulation creates a new MapReduce simulation
+func NewSimulation(eng *engine.Engine, trans *transport.NetworkTransport, broadcast func(interface{}), config Config) *Simulation {
+	switch config.Scenario {
+	case "steady", "worker_crash", "straggler", "straggler_no_backup":
+	default:
+		config.Scenario = "steady"
+	}
+	if config.NodeCount == 0 {
+		config.NodeCount = 6
+	}
+	config.NodeCount = max(config.NodeCount, 4)
+
+	sim := &Simulation{
+		engine:      eng,
+		transport:   trans,
+		broadcast:   broadcast,
+		scenario:    config.Scenario,
+		speculation: config.Scenario != "straggler_no_backup",
+		expected:    sequentialCount(),
+	}
+
+	trans.SetLatency(20*time.Millisecond, 80*time.Millisecond)
+	trans.SetPacketLoss(0)
+
+	sim.coordinator = newCoordinator("coordinator", sim)
+	trans.RegisterHandler(sim.coordinator.id, sim.coordinator.handleMessage)
+	eng.AddNode(sim.coordinator)
+	for i := 1; i < config.NodeCount; i++ {
+		worker := newWorker(fmt.Sprintf("worker-%d", i), sim)
+		sim.workers = append(sim.workers, worker)
+		trans.RegisterHandler(worker.id, worker.handleMessage)
+		eng.AddNode(worker)
+	}
+	if config.Scenario == "straggler" || config.Scenario == "straggler_no_backup" {
+		sim.workers[2].slowdown = slowdown
+	}
+
+	return sim
+}
+
+// Start starts the simulation
+func (s *Simulation) Start(ctx context.Context) error {
+	s.mu.Lock()
+	s.running = true
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.mu.Unlock()
+
+	return s.engine.Start(ctx)
+}
+
+// Stop stops the simulation
+func (s *Simulation) Stop() error {
+	s.mu.Lock()
+	s.running = false
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Unlock()
+
+	return s.engine.Stop()
+}
+
+// GetState returns the current simulation state
+func (s *Simulation) GetState() *protocol.SimulationStateResponse {
+	nodes := make(map[string]protocol.NodeState)
+	state := s.coordinator.GetState()
+	nodes[s.coordinator.id] = protocol.NodeState{
+		ID:          s.coordinator.id,
+		Status:      state["status"].(string),
+		Role:        "coordinator",
+		CustomState: state,
+	}
+	for _, worker := range s.workers {
+		state := worker.GetState()
+		nodes[worker.id] = protocol.NodeState{
+			ID:          worker.id,
+			Status:      state["status"].(string),
+			Role:        "worker",
+			CustomState: state,
+		}
+	}
+
+	s.mu.RLock()
+	running := s.running
+	metadata := map[string]interface{}{
+		"scenario":     s.scenario,
+		"speculation":  s.speculation,
+		"mapTasks":     len(splits),
+		"reduceTasks":  reduceTasks,
+		"jobs":         append([]jobResult{}, s.jobs...),
+		"meanJobTicks": s.meanJobTicks(),
+		"backups":      s.backups,
+		"backupsWon":   s.backupsWon,
+		"discarded":    s.discarded,
+		"reexecutions": s.reexecutions,
+		"failures":     s.failures,
+		"messages":     s.messages,
+	}
+	s.mu.RUnlock()
+
+	mode := "step"
+	if s.engine != nil {
+		mode = s.engine.GetMode().String()
+	}
+
+	return &protocol.SimulationStateResponse{
+		Type:        protocol.MsgSimulationState,
+		VirtualTime: time.Now().UnixMilli(),
+		Mode:        mode,
+		Speed:       1.0,
+		Running:     running,
+		Nodes:       nodes,
+		Metadata:    metadata,
+	}
+}
+
+// GetNodes returns node states
+func (s *Simulation) GetNodes() map[string]protocol.NodeState {
+	state := s.GetState()
+	return state.Nodes
+}
+
+// CrashNode crashes a worker, losing the task it was running, or the
+// coordinator
+func (s *Simulation) CrashNode(nodeID string) error {
+	if nodeID == s.coordinator.id {
+		s.coordinator.mu.Lock()
+		s.coordinator.status = "crashed"
+		s.coordinator.mu.Unlock()
+		return nil
+	}
+	worker := s.findWorker(nodeID)
+	if worker == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	worker.mu.Lock()
+	worker.status = "crashed"
+	worker.task = nil
+	worker.mu.Unlock()
+	return nil
+}
+
+// RecoverNode recovers a crashed worker, which asks for a task again, or
+// the coordinator
+func (s *Simulation) RecoverNode(nodeID string) error {
+	if nodeID == s.coordinator.id {
+		s.coordinator.mu.Lock()
+		s.coordinator.status = "running"
+		s.coordinator.mu.Unlock()
+		return nil
+	}
+	worker := s.findWorker(nodeID)
+	if worker == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	worker.mu.Lock()
+	worker.status = "running"
+	worker.mu.Unlock()
+	return nil
+}
+
+// HandleClientRequest runs a client command: "set_speculation" turns
+// backup attempts on or off, {enabled}
+func (s *Simulation) HandleClientRequest(command string, payload map[string]interface{}) error {
+	if command != "set_speculation" {
+		return fmt.Errorf("unknown command: %s", command)
+	}
+	enabled, ok := payload["enabled"].(bool)
+	if !ok {
+		return fmt.Errorf("set_speculation needs a boolean enabled")
+	}
+	s.mu.Lock()
+	s.speculation = enabled
+	s.mu.Unlock()
+	s.broadcast(map[string]interface{}{
+		"type":    "speculation_changed",
+		"enabled": enabled,
+	})
+	return nil
+}
+
+// NodeActions lists the actions of a worker: "slow_down" makes it a
+// straggler, "restore" brings it back to normal speed
+func (s *Simulation) NodeActions(nodeID string) []string {
+	worker := s.findWorker(nodeID)
+	if worker == nil {
+		return nil
+	}
+	worker.mu.RLock()
+	defer worker.mu.RUnlock()
+
+	if worker.slowdown > 1 {
+		return []string{"restore"}
+	}
+	return []string{"slow_down"}
+}
+
+// InvokeNodeAction invokes an action listed by NodeActions
+func (s *Simulation) InvokeNodeAction(nodeID, action string, params map[string]interface{}) error {
+	worker := s.findWorker(nodeID)
+	if worker == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	factor := 1
+	switch action {
+	case "slow_down":
+		factor = slowdown
+	case "restore":
+	default:
+		return fmt.Errorf("unknown action: %s", action)
+	}
+	worker.mu.Lock()
+	worker.slowdown = factor
+	worker.mu.Unlock()
+	s.broadcast(map[string]interface{}{
+		"type":     "worker_speed_changed",
+		"nodeId":   nodeID,
+		"slowdown": factor,
+	})
+	return nil
+}
+
+// findWorker looks up a worker by ID
+func (s *Simulation) findWorker(nodeID string) *Worker {
+	for _, worker := range s.workers {
+		if worker.id == nodeID {
+			return worker
+		}
+	}
+	return nil
+}
+
+// speculating reports whether the coordinator launches backup attempts
+func (s *Simulation) speculating() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.speculation
+}
+
+// advanceSchedule applies the faults of the scenario, once per tick
+func (s *Simulation) advanceSchedule(ticks int) {
+	s.mu.Lock()
+	if ticks <= s.lastTick {
+		s.mu.Unlock()
+		return
+	}
+	s.lastTick = ticks
+	s.mu.Unlock()
+
+	if s.scenario != "worker_crash" {
+		return
+	}
+	switch ticks {
+	case crashAt, secondCrashAt:
+		victim := s.workers[1].id
+		if ticks == secondCrashAt {
+			victim = s.workers[3].id
+		}
+		s.CrashNode(victim)
+		s.broadcast(map[string]interface{}{
+			"type":   "node_crashed",
+			"nodeId": victim,
+		})
+	case recoverAt:
+		for _, worker := range []*Worker{s.workers[1], s.workers[3]} {
+			s.RecoverNode(worker.id)
+			s.broadcast(map[string]interface{}{
+				"type":   "node_recovered",
+				"nodeId": worker.id,
+			})
+		}
+	}
+}
+
+// taskChanged reports a step of a task's state machine
+func (s *Simulation) taskChanged(nodeID string, job int, task *Task, from, worker string, attempt int, reason string) {
+	if task.State == "idle" {
+		s.mu.Lock()
+		s.reexecutions++
+		s.mu.Unlock()
+	}
+	s.broadcast(map[string]interface{}{
+		"type":    "task_state",
+		"nodeId":  nodeID,
+		"job":     job,
+		"task":    task.name(),
+		"from":    from,
+		"to":      task.State,
+		"worker":  worker,
+		"attempt": attempt,
+		"reason":  reason, // "assigned", "reported", "worker_failed" or "output_lost"
+	})
+}
+
+// backupLaunched reports a backup attempt of a straggling task
+func (s *Simulation) backupLaunched(nodeID string, job int, task *Task, backup Attempt, elapsed int) {
+	s.mu.Lock()
+	s.backups++
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":      "backup_launched",
+		"nodeId":    nodeID,
+		"job":       job,
+		"task":      task.name(),
+		"worker":    backup.Worker,
+		"attempt":   backup.Number,
+		"straggler": task.Attempts[0].Worker,
+		"elapsed":   elapsed, // Ticks the original attempt had run
+	})
+}
+
+// backupWon reports a backup attempt completing a task before the
+// original one
+func (s *Simulation) backupWon(nodeID string, job int, task *Task, worker string) {
+	s.mu.Lock()
+	s.backupsWon++
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":   "backup_won",
+		"nodeId": nodeID,
+		"job":    job,
+		"task":   task.name(),
+		"worker": worker,
+	})
+}
+
+// attemptDiscarded reports the output of an attempt that finished after
+// its task completed
+func (s *Simulation) attemptDiscarded(nodeID string, job int, task *Task, worker string, attempt int) {
+	s.mu.Lock()
+	s.discarded++
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":    "attempt_discarded",
+		"nodeId":  nodeID,
+		"job":     job,
+		"task":    task.name(),
+		"worker":  worker,
+		"attempt": attempt,
+		"winner":  task.Worker,
+	})
+}
+
+// workerFailed reports the coordinator declaring a silent worker failed
+func (s *Simulation) workerFailed(nodeID, worker string, silence int) {
+	s.mu.Lock()
+	s.failures++
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":    "worker_failed",
+		"nodeId":  nodeID,
+		"worker":  worker,
+		"silence": silence,
+	})
+}
+
+// workerRejoined reports a failed worker being heard from again
+func (s *Simulation) workerRejoined(nodeID, worker string) {
+	s.broadcast(map[string]interface{}{
+		"type":   "worker_rejoined",
+		"nodeId": nodeID,
+		"worker": worker,
+	})
+}
+
+// jobStarted reports a new run of the job
+func (s *Simulation) jobStarted(nodeID string, job int) {
+	s.broadcast(map[string]interface{}{
+		"type":   "job_started",
+		"nodeId": nodeID,
+		"job":    job,
+	})
+}
+
+// jobCompleted records a completed job and checks its output against the
+// sequential word count
+func (s *Simulation) jobCompleted(nodeID string, job, ticks int, output map[string]int) {
+	correct := len(output) == len(s.expected)
+	for word, n := range s.expected {
+		if output[word] != n {
+			correct = false
+		}
+	}
+
+	s.mu.Lock()
+	s.jobs = append(s.jobs, jobResult{Job: job, Ticks: ticks, Correct: correct})
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":    "job_completed",
+		"nodeId":  nodeID,
+		"job":     job,
+		"ticks":   ticks,
+		"correct": correct,
+	})
+}
+
+// meanJobTicks returns how long the completed jobs took on average (must
+// hold s.mu)
+func (s *Simulation) meanJobTicks() float64 {
+	if len(s.jobs) == 0 {
+		return 0
+	}
+	total := 0
+	for _, job := range s.jobs {
+		total += job.Ticks
+	}
+	return float64(total) / float64(len(s.jobs))
+}
+
+// send transmits a message and reports it to the frontend
+func (s *Simulation) send(from, to string, msgType transport.MessageType, payload Payload) {
+	env := transport.NewEnvelope(from, to, msgType, payload)
+
+	s.mu.Lock()
+	s.messages++
+	s.mu.Unlock()
+
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageSent,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+
+	s.transport.Send(s.ctx, env)
+}
+
+// received reports a delivered message and returns its payload
+func (s *Simulation) received(env *transport.Envelope) Payload {
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageReceived,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+	payload, _ := env.Payload.(Payload)
+	return payload
+}
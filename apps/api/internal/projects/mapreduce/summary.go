@@ -0,0 +1,65 @@
+package mapreduce
+
+import (
+	"fmt"
+
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+)
+
+const slowJobTicks = 45 // Mean job time past which a straggler is holding the jobs back
+
+// Invariants checks the jobs' output against a sequential word count, and
+// that failures and stragglers neither stop nor stall them
+func (s *Simulation) Invariants() []protocol.InvariantResult {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	wrong := 0
+	for _, job := range s.jobs {
+		if !job.Correct {
+			wrong++
+		}
+	}
+	mean := s.meanJobTicks()
+	speculation := "without backup attempts"
+	if s.speculation {
+		speculation = fmt.Sprintf("with backup attempts (%d launched, %d finished first)", s.backups, s.backupsWon)
+	}
+
+	return []protocol.InvariantResult{
+		{
+			Name:   "output matches a sequential run",
+			Holds:  wrong == 0,
+			Detail: fmt.Sprintf("%d of %d jobs produced the sequential word count, %d duplicate outputs discarded", len(s.jobs)-wrong, len(s.jobs), s.discarded),
+		},
+		{
+			Name:   "jobs complete despite failures",
+			Holds:  len(s.jobs) > 0,
+			Detail: fmt.Sprintf("%d jobs completed, %d workers declared failed, %d tasks re-executed", len(s.jobs), s.failures, s.reexecutions),
+		},
+		{
+			Name:   "stragglers do not hold jobs back",
+			Holds:  len(s.jobs) > 0 && mean <= slowJobTicks,
+			Detail: fmt.Sprintf("jobs took %.1f ticks on average (limit %d) %s", mean, slowJobTicks, speculation),
+		},
+	}
+}
+
+// FollowUps suggests the scenarios that contrast with this one
+func (s *Simulation) FollowUps() []protocol.FollowUp {
+	switch s.scenario {
+	case "straggler":
+		return []protocol.FollowUp{
+			{Project: "mapreduce", Scenario: "straggler_no_backup", Reason: "Wait for the straggler instead of racing it"},
+			{Project: "load-balancing", Scenario: "power_of_two", Reason: "Route around a slow server request by request"},
+		}
+	case "straggler_no_backup":
+		return []protocol.FollowUp{
+			{Project: "mapreduce", Scenario: "straggler", Reason: "Launch backup attempts near the end of each phase"},
+		}
+	}
+	return []protocol.FollowUp{
+		{Project: "mapreduce", Scenario: "straggler", Reason: "Slow one worker down instead of crashing it"},
+		{Project: "heartbeat", Scenario: "tight_timeout", Reason: "See what a too-short timeout does to failure detection"},
+	}
+}
@@ -0,0 +1,165 @@
+package mapreduce
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+const (
+	MsgGetTask  transport.MessageType = "get_task"
+	MsgTask     transport.MessageType = "task"
+	MsgProgress transport.MessageType = "progress"
+	MsgTaskDone transport.MessageType = "task_done"
+)
+
+const (
+	mapTicks      = 6 // Ticks a map task takes on a normal worker
+	reduceTicks   = 8 // Ticks a reduce task takes on a normal worker
+	askTicks      = 2 // Ticks between an idle worker's requests for a task
+	progressTicks = 3 // Ticks between a busy worker's progress reports
+)
+
+// Worker asks the coordinator for a task whenever it is idle, runs it,
+// reporting progress as it goes, and sends back the output. A slow worker
+// takes several times longer on every task.
+type Worker struct {
+	mu sync.RWMutex
+
+	id       string
+	status   string // "running" or "crashed"
+	ticks    int
+	slowdown int
+
+	task      *Payload // Assignment being run
+	startedAt int
+	lastSent  int
+	completed int
+
+	inbox      chan *transport.Envelope
+	simulation *Simulation
+}
+
+func newWorker(id string, sim *Simulation) *Worker {
+	return &Worker{
+		id:         id,
+		status:     "running",
+		slowdown:   1,
+		inbox:      make(chan *transport.Envelope, 500),
+		simulation: sim,
+	}
+}
+
+// Worker implements engine.NodeController
+
+func (w *Worker) ID() string {
+	return w.id
+}
+
+func (w *Worker) Start(ctx context.Context) error {
+	return nil
+}
+
+func (w *Worker) Stop() error {
+	return nil
+}
+
+func (w *Worker) Tick() {
+	w.simulation.advanceSchedule(w.tick())
+}
+
+func (w *Worker) tick() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.ticks++
+	if w.status == "crashed" {
+		return w.ticks
+	}
+
+	for i := 0; i < 50; i++ {
+		select {
+		case env := <-w.inbox:
+			payload := w.simulation.received(env)
+			if env.Type == MsgTask && w.task == nil {
+				w.task = &payload
+				w.startedAt = w.ticks
+			}
+			continue
+		default:
+		}
+		break
+	}
+
+	sim := w.simulation
+	coordinator := sim.coordinator.id
+	switch {
+	case w.task == nil:
+		if w.ticks-w.lastSent >= askTicks {
+			w.lastSent = w.ticks
+			sim.send(w.id, coordinator, MsgGetTask, Payload{})
+		}
+	case w.ticks-w.startedAt >= w.duration():
+		task := w.task
+		done := Payload{Kind: task.Kind, ID: task.ID, Attempt: task.Attempt, Job: task.Job}
+		if task.Kind == "map" {
+			done.Partitions = mapSplit(task.Split)
+		} else {
+			done.Counts = reducePartition(task.Partitions)
+		}
+		w.task = nil
+		w.completed++
+		w.lastSent = w.ticks
+		sim.send(w.id, coordinator, MsgTaskDone, done)
+	case w.ticks-w.lastSent >= progressTicks:
+		w.lastSent = w.ticks
+		sim.send(w.id, coordinator, MsgProgress, Payload{Kind: w.task.Kind, ID: w.task.ID, Attempt: w.task.Attempt, Job: w.task.Job})
+	}
+	return w.ticks
+}
+
+func (w *Worker) GetState() map[string]interface{} {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	state := map[string]interface{}{
+		"id":        w.id,
+		"status":    w.status,
+		"role":      "worker",
+		"slowdown":  w.slowdown,
+		"completed": w.completed,
+	}
+	if w.task != nil {
+		state["task"] = map[string]interface{}{
+			"kind":     w.task.Kind,
+			"id":       w.task.ID,
+			"attempt":  w.task.Attempt,
+			"progress": float64(w.ticks-w.startedAt) / float64(w.duration()),
+		}
+	}
+	return state
+}
+
+func (w *Worker) handleMessage(env *transport.Envelope) {
+	w.mu.RLock()
+	down := w.status == "crashed"
+	w.mu.RUnlock()
+
+	if down {
+		return
+	}
+	select {
+	case w.inbox <- env:
+	default:
+	}
+}
+
+// duration returns the ticks the current task takes on this worker (must
+// hold w.mu)
+func (w *Worker) duration() int {
+	if w.task != nil && w.task.Kind == "reduce" {
+		return reduceTicks * w.slowdown
+	}
+	return mapTicks * w.slowdown
+}
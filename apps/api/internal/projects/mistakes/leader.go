@@ -0,0 +1,121 @@
+package mistakes
+
+import (
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+const (
+	MsgHeartbeat     transport.MessageType = "heartbeat"
+	MsgWrite         transport.MessageType = "write"
+	MsgWriteAck      transport.MessageType = "write_ack"
+	MsgWriteRejected transport.MessageType = "write_rejected"
+)
+
+const (
+	heartbeatInterval = 3
+	writeInterval     = 5
+)
+
+// leaderTick runs one step of the leader/follower/storage trio. The
+// election is reduced to its essence: the follower takes over with a
+// higher epoch once heartbeats stop. (must hold n.mu)
+func (n *Node) leaderTick() {
+	sim := n.simulation
+
+	// A leader resuming from a pause finishes the write it was doing
+	// before it looks at anything that arrived meanwhile
+	if n.pendingWrite {
+		n.pendingWrite = false
+		n.write()
+	}
+
+	if env, payload, ok := n.receive(); ok {
+		n.handleLeader(env, payload)
+	}
+
+	if n.role == "storage" {
+		return
+	}
+
+	if n.leader {
+		if n.ticks%heartbeatInterval == 0 {
+			n.send(n.peers[0], MsgHeartbeat, Payload{Epoch: n.epoch, Leader: n.id})
+		}
+		if n.ticks%writeInterval == 0 {
+			n.write()
+		}
+		return
+	}
+
+	if n.ticks-n.lastHeartbeat > sim.heartbeatTimeout {
+		// The mistake common to both variants: silence is read as death.
+		// Only the fixed variant makes that survivable.
+		n.leader = true
+		n.epoch++
+		sim.broadcast(map[string]interface{}{
+			"type":    "leader_suspected",
+			"nodeId":  n.id,
+			"suspect": n.peers[0],
+			"epoch":   n.epoch,
+		})
+	}
+}
+
+func (n *Node) handleLeader(env *transport.Envelope, payload Payload) {
+	sim := n.simulation
+
+	switch env.Type {
+	case MsgHeartbeat:
+		if payload.Epoch >= n.epoch {
+			if n.leader && payload.Epoch > n.epoch {
+				n.stepDown(payload.Epoch, "heartbeat")
+			}
+			n.epoch = payload.Epoch
+			n.lastHeartbeat = n.ticks
+		}
+
+	case MsgWrite:
+		if payload.Epoch < n.maxEpoch {
+			if sim.corrected {
+				n.rejected++
+				n.send(env.From, MsgWriteRejected, Payload{Epoch: n.maxEpoch})
+				return
+			}
+			sim.violation("split brain: %s wrote with epoch %d after a leader with epoch %d",
+				payload.Leader, payload.Epoch, n.maxEpoch)
+		}
+		if payload.Epoch > n.maxEpoch {
+			n.maxEpoch = payload.Epoch
+		}
+		n.log = append(n.log, payload)
+		n.send(env.From, MsgWriteAck, Payload{Epoch: payload.Epoch})
+
+	case MsgWriteRejected:
+		if n.leader {
+			n.stepDown(payload.Epoch, "fenced")
+		}
+	}
+}
+
+// write sends the next value to storage tagged with the leader's epoch
+func (n *Node) write() {
+	n.writes++
+	n.send("storage", MsgWrite, Payload{
+		Epoch:  n.epoch,
+		Leader: n.id,
+		Value:  n.writes,
+	})
+}
+
+// stepDown demotes a leader that learned of a newer epoch
+func (n *Node) stepDown(epoch int, reason string) {
+	n.leader = false
+	n.epoch = epoch
+	n.lastHeartbeat = n.ticks
+	n.simulation.broadcast(map[string]interface{}{
+		"type":   "stepped_down",
+		"nodeId": n.id,
+		"epoch":  epoch,
+		"reason": reason,
+	})
+}
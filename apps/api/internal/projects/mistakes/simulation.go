@@ -0,0 +1,501 @@
+package mistakes
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+	"github.com/ersantana/distributed-systems-learning/packages/simulation/engine"
+)
+
+// Payload is the content of all messages exchanged in this project
+type Payload struct {
+	Decision string `json:"decision,omitempty"` // 2PC: "commit" or "abort"
+	Epoch    int    `json:"epoch,omitempty"`    // Leadership epoch / fencing token
+	Leader   string `json:"leader,omitempty"`
+	Value    int    `json:"value,omitempty"`
+}
+
+// Mistake describes one entry of the "common mistakes" series
+type Mistake struct {
+	Name    string `json:"name"`
+	Mistake string `json:"mistake"`
+	Fix     string `json:"fix"`
+}
+
+// Catalog lists the mistakes; each scenario has a "_fixed" counterpart
+// running the corrected design
+var Catalog = []Mistake{
+	{
+		Name:    "2pc_timeout_abort",
+		Mistake: "A prepared 2PC participant treats a missing decision as coordinator failure and aborts on its own",
+		Fix:     "A prepared participant is uncertain and must not decide alone; it asks its peers for the outcome (cooperative termination)",
+	},
+	{
+		Name:    "false_suspicion",
+		Mistake: "A paused leader is presumed dead; once it resumes both the old and the new leader write to storage",
+		Fix:     "Storage fences writes with the leader's epoch and rejects tokens older than the newest it has seen",
+	},
+}
+
+// scriptedFault pauses, resumes, partitions or heals at a given tick
+type scriptedFault struct {
+	tick   int
+	action string // "pause", "resume", "partition", "heal"
+	from   string
+	to     string
+}
+
+// Simulation implements the "timeouts are not failure proof" mistakes,
+// each either as the broken design or the corrected one
+type Simulation struct {
+	mu sync.RWMutex
+
+	engine    *engine.Engine
+	transport *transport.NetworkTransport
+	broadcast func(interface{})
+
+	nodes     []*Node
+	scenario  string
+	mistake   Mistake
+	corrected bool
+
+	decisionTimeout  int // 2PC: ticks a prepared participant waits
+	heartbeatTimeout int // Leader: ticks before the follower suspects
+
+	script     []scriptedFault
+	violations []string
+
+	running bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// Node is a coordinator, participant, leader candidate or storage node
+type Node struct {
+	mu sync.RWMutex
+
+	id     string
+	role   string
+	status string
+	ticks  int
+
+	// 2PC
+	state        string // "init", "prepared", "uncertain", "committed", "aborted"
+	votedAt      int
+	votes        map[string]bool
+	decision     string
+	acks         map[string]bool
+	lastDecision int // Tick of the last decision (re)transmission
+
+	// Leader / storage
+	leader        bool
+	epoch         int
+	lastHeartbeat int
+	pendingWrite  bool
+	writes        int
+	log           []Payload // Storage: accepted writes
+	maxEpoch      int
+	rejected      int
+
+	inbox      chan *transport.Envelope
+	simulation *Simulation
+	peers      []string
+}
+
+// Config for Mistakes simulation
+type Config struct {
+	Scenario string
+}
+
+// NewSimulation creates a new Mistakes simulation
+func NewSimulation(eng *engine.Engine, trans *transport.NetworkTransport, broadcast func(interface{}), config Config) *Simulation {
+	sim := &Simulation{
+		engine:           eng,
+		transport:        trans,
+		broadcast:        broadcast,
+		scenario:         config.Scenario,
+		corrected:        strings.HasSuffix(config.Scenario, "_fixed"),
+		decisionTimeout:  15,
+		heartbeatTimeout: 12,
+		violations:       make([]string, 0),
+	}
+
+	name := strings.TrimSuffix(config.Scenario, "_fixed")
+	sim.mistake = Catalog[0]
+	for _, m := range Catalog {
+		if m.Name == name {
+			sim.mistake = m
+		}
+	}
+
+	trans.SetLatency(50*time.Millisecond, 100*time.Millisecond)
+	trans.SetPacketLoss(0)
+
+	var ids, roles []string
+	switch sim.mistake.Name {
+	case "false_suspicion":
+		ids = []string{"node-1", "node-2", "storage"}
+		roles = []string{"leader", "follower", "storage"}
+		// node-1 stalls (think GC pause) long enough to be suspected
+		sim.script = []scriptedFault{
+			{tick: 20, action: "pause", from: "node-1"},
+			{tick: 50, action: "resume", from: "node-1"},
+		}
+	default:
+		ids = []string{"coordinator", "participant-1", "participant-2"}
+		roles = []string{"coordinator", "participant", "participant"}
+		// The decision to participant-2 is delayed, not lost
+		sim.script = []scriptedFault{
+			{tick: 5, action: "partition", from: "coordinator", to: "participant-2"},
+			{tick: 45, action: "heal", from: "coordinator", to: "participant-2"},
+		}
+	}
+
+	for i, id := range ids {
+		node := &Node{
+			id:         id,
+			role:       roles[i],
+			status:     "running",
+			state:      "init",
+			votes:      make(map[string]bool),
+			acks:       make(map[string]bool),
+			log:        make([]Payload, 0),
+			inbox:      make(chan *transport.Envelope, 100),
+			simulation: sim,
+		}
+		for _, peer := range ids {
+			if peer != id {
+				node.peers = append(node.peers, peer)
+			}
+		}
+		if node.role == "leader" {
+			node.leader = true
+			node.epoch = 1
+		}
+		sim.nodes = append(sim.nodes, node)
+		trans.RegisterHandler(id, node.handleMessage)
+		eng.AddNode(node)
+	}
+	if sim.mistake.Name != "false_suspicion" {
+		eng.RegisterInvariant("2PC atomicity", sim.atomicity)
+	}
+
+	return sim
+}
+
+// Start starts the simulation
+func (s *Simulation) Start(ctx context.Context) error {
+	s.mu.Lock()
+	s.running = true
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.mu.Unlock()
+
+	return s.engine.Start(ctx)
+}
+
+// Stop stops the simulation
+func (s *Simulation) Stop() error {
+	s.mu.Lock()
+	s.running = false
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Unlock()
+
+	return s.engine.Stop()
+}
+
+// GetState returns the current simulation state
+func (s *Simulation) GetState() *protocol.SimulationStateResponse {
+	// Node locks are taken without holding s.mu: nodes lock the
+	// simulation while ticking, so the reverse order would deadlock.
+	nodes := make(map[string]protocol.NodeState)
+	for _, node := range s.nodes {
+		nodeState := node.GetState()
+		nodes[node.id] = protocol.NodeState{
+			ID:          node.id,
+			Status:      nodeState["status"].(string),
+			Role:        nodeState["role"].(string),
+			Term:        node.currentEpoch(),
+			CustomState: nodeState,
+		}
+	}
+
+	s.mu.RLock()
+	running := s.running
+	violations := append([]string{}, s.violations...)
+	s.mu.RUnlock()
+
+	mode := "step"
+	if s.engine != nil {
+		mode = s.engine.GetMode().String()
+	}
+
+	return &protocol.SimulationStateResponse{
+		Type:        protocol.MsgSimulationState,
+		VirtualTime: time.Now().UnixMilli(),
+		Mode:        mode,
+		Speed:       1.0,
+		Running:     running,
+		Nodes:       nodes,
+		Metadata: map[string]interface{}{
+			"mistake":    s.mistake,
+			"corrected":  s.corrected,
+			"violations": violations,
+			"catalog":    Catalog,
+		},
+	}
+}
+
+// GetNodes returns node states
+func (s *Simulation) GetNodes() map[string]protocol.NodeState {
+	state := s.GetState()
+	return state.Nodes
+}
+
+// Layout puts the node everyone talks to in the middle: the coordinator
+// of two-phase commit or the shared storage
+func (s *Simulation) Layout() *protocol.Layout {
+	center := s.nodes[0].id
+	order := make([]string, 0, len(s.nodes))
+	for _, node := range s.nodes {
+		if node.role == "storage" {
+			center = node.id
+		}
+	}
+	for _, node := range s.nodes {
+		if node.id != center {
+			order = append(order, node.id)
+		}
+	}
+	return &protocol.Layout{
+		Kind:   protocol.LayoutStar,
+		Center: center,
+		Order:  order,
+	}
+}
+
+// CrashNode crashes a node
+func (s *Simulation) CrashNode(nodeID string) error {
+	node := s.findNode(nodeID)
+	if node == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	node.mu.Lock()
+	node.status = "crashed"
+	node.mu.Unlock()
+	return nil
+}
+
+// RecoverNode recovers a crashed node
+func (s *Simulation) RecoverNode(nodeID string) error {
+	node := s.findNode(nodeID)
+	if node == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	node.mu.Lock()
+	node.status = "running"
+	node.mu.Unlock()
+	return nil
+}
+
+// findNode looks up a node by ID; the node list is fixed after construction
+func (s *Simulation) findNode(nodeID string) *Node {
+	for _, node := range s.nodes {
+		if node.id == nodeID {
+			return node
+		}
+	}
+	return nil
+}
+
+// violation records a broken safety property
+func (s *Simulation) violation(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	s.mu.Lock()
+	s.violations = append(s.violations, msg)
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":      "safety_violation",
+		"message":   msg,
+		"corrected": s.corrected,
+	})
+}
+
+// advanceSchedule applies scripted faults. It runs after the ticking
+// node has released its lock since it may lock another node.
+func (s *Simulation) advanceSchedule(ticks int) {
+	s.mu.Lock()
+	due := make([]scriptedFault, 0)
+	remaining := s.script[:0]
+	for _, fault := range s.script {
+		if fault.tick <= ticks {
+			due = append(due, fault)
+		} else {
+			remaining = append(remaining, fault)
+		}
+	}
+	s.script = remaining
+	s.mu.Unlock()
+
+	for _, fault := range due {
+		switch fault.action {
+		case "partition":
+			s.transport.SetPartition(fault.from, fault.to, true)
+		case "heal":
+			s.transport.ClearPartition(fault.from, fault.to)
+		case "pause", "resume":
+			if node := s.findNode(fault.from); node != nil {
+				node.mu.Lock()
+				if fault.action == "pause" {
+					node.status = "paused"
+					// The pause hits in the middle of a write
+					node.pendingWrite = node.leader
+				} else {
+					node.status = "running"
+				}
+				node.mu.Unlock()
+			}
+		}
+		s.broadcast(map[string]interface{}{
+			"type":   "scripted_fault",
+			"action": fault.action,
+			"from":   fault.from,
+			"to":     fault.to,
+		})
+	}
+}
+
+// Node implements engine.NodeController
+
+func (n *Node) ID() string {
+	return n.id
+}
+
+func (n *Node) Start(ctx context.Context) error {
+	return nil
+}
+
+func (n *Node) Stop() error {
+	return nil
+}
+
+func (n *Node) Tick() {
+	ticks := n.tick()
+
+	// The last node (a participant or storage) is never faulted by the
+	// scripts, so its clock drives them
+	sim := n.simulation
+	if n == sim.nodes[len(sim.nodes)-1] {
+		sim.advanceSchedule(ticks)
+	}
+}
+
+func (n *Node) tick() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.ticks++
+	if n.status != "running" {
+		return n.ticks
+	}
+
+	switch n.role {
+	case "coordinator", "participant":
+		n.twoPhaseTick()
+	default:
+		n.leaderTick()
+	}
+	return n.ticks
+}
+
+func (n *Node) GetState() map[string]interface{} {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	state := map[string]interface{}{
+		"id":     n.id,
+		"status": n.status,
+		"role":   n.role,
+	}
+	switch n.role {
+	case "coordinator", "participant":
+		state["state"] = n.state
+		state["decision"] = n.decision
+	case "storage":
+		state["writes"] = append([]Payload{}, n.log...)
+		state["maxEpoch"] = n.maxEpoch
+		state["rejected"] = n.rejected
+	default:
+		if n.leader {
+			state["role"] = "leader"
+		} else {
+			state["role"] = "follower"
+		}
+		state["epoch"] = n.epoch
+		state["writes"] = n.writes
+	}
+	return state
+}
+
+func (n *Node) currentEpoch() int {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.epoch
+}
+
+func (n *Node) handleMessage(env *transport.Envelope) {
+	n.mu.RLock()
+	crashed := n.status == "crashed"
+	n.mu.RUnlock()
+
+	// Crashed processes do not receive anything; paused ones queue it
+	if crashed {
+		return
+	}
+	select {
+	case n.inbox <- env:
+	default:
+	}
+}
+
+func (n *Node) send(to string, msgType transport.MessageType, payload Payload) {
+	sim := n.simulation
+	env := transport.NewEnvelope(n.id, to, msgType, payload)
+
+	sim.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageSent,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+
+	sim.transport.Send(sim.ctx, env)
+}
+
+// receive takes one message from the inbox, if any
+func (n *Node) receive() (*transport.Envelope, Payload, bool) {
+	select {
+	case env := <-n.inbox:
+		n.simulation.broadcast(&protocol.MessageEventResponse{
+			Type:        protocol.MsgMessageReceived,
+			MessageID:   env.ID,
+			From:        env.From,
+			To:          env.To,
+			MessageType: string(env.Type),
+			Payload:     env.Payload,
+		})
+		payload, _ := env.Payload.(Payload)
+		return env, payload, true
+	default:
+		return nil, Payload{}, false
+	}
+}
@@ -0,0 +1,182 @@
+package mistakes
+
+import (
+	"fmt"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+const (
+	MsgPrepare         transport.MessageType = "prepare"
+	MsgVote            transport.MessageType = "vote"
+	MsgDecision        transport.MessageType = "decision"
+	MsgDecisionAck     transport.MessageType = "decision_ack"
+	MsgDecisionRequest transport.MessageType = "decision_request"
+)
+
+// twoPhaseTick runs one step of two-phase commit. Every participant votes
+// yes; the coordinator's decision to participant-2 is delayed past that
+// participant's timeout. (must hold n.mu)
+func (n *Node) twoPhaseTick() {
+	sim := n.simulation
+
+	if env, payload, ok := n.receive(); ok {
+		n.handleTwoPhase(env, payload)
+	}
+
+	if n.role == "coordinator" {
+		if n.ticks == 3 {
+			n.state = "preparing"
+			for _, peer := range n.peers {
+				n.send(peer, MsgPrepare, Payload{})
+			}
+		}
+		// Retransmit the decision until every participant acknowledged
+		if n.decision != "" && n.ticks-n.lastDecision >= 8 {
+			n.sendDecision()
+		}
+		return
+	}
+
+	if n.state != "prepared" || n.ticks-n.votedAt < sim.decisionTimeout {
+		return
+	}
+
+	if !sim.corrected {
+		// The mistake: silence is taken as proof the coordinator died
+		n.state = "aborted"
+		n.decision = "abort"
+		sim.broadcast(map[string]interface{}{
+			"type":   "timeout_abort",
+			"nodeId": n.id,
+		})
+		return
+	}
+
+	// A prepared participant may not decide alone; ask the others
+	n.state = "uncertain"
+	sim.broadcast(map[string]interface{}{
+		"type":   "decision_unknown",
+		"nodeId": n.id,
+	})
+	for _, peer := range n.peers {
+		n.send(peer, MsgDecisionRequest, Payload{})
+	}
+}
+
+func (n *Node) handleTwoPhase(env *transport.Envelope, payload Payload) {
+	switch env.Type {
+	case MsgPrepare:
+		n.state = "prepared"
+		n.votedAt = n.ticks
+		n.send(env.From, MsgVote, Payload{Decision: "commit"})
+
+	case MsgVote:
+		n.votes[env.From] = payload.Decision == "commit"
+		if n.decision == "" && len(n.votes) == len(n.peers) {
+			n.decision = "commit"
+			for _, yes := range n.votes {
+				if !yes {
+					n.decision = "abort"
+				}
+			}
+			n.state = decidedState(n.decision)
+			n.sendDecision()
+		}
+
+	case MsgDecision:
+		n.send(env.From, MsgDecisionAck, Payload{})
+		n.learn(payload.Decision, env.From)
+
+	case MsgDecisionAck:
+		n.acks[env.From] = true
+
+	case MsgDecisionRequest:
+		// Only a node that knows the outcome can answer
+		if n.decision != "" {
+			n.send(env.From, MsgDecision, Payload{Decision: n.decision})
+		}
+	}
+}
+
+// sendDecision (re)sends the decision to participants that have not
+// acknowledged it yet
+func (n *Node) sendDecision() {
+	n.lastDecision = n.ticks
+	for _, peer := range n.peers {
+		if !n.acks[peer] {
+			n.send(peer, MsgDecision, Payload{Decision: n.decision})
+		}
+	}
+}
+
+// learn applies a decision received from the coordinator or a peer
+func (n *Node) learn(decision, from string) {
+	sim := n.simulation
+
+	if n.decision != "" && n.decision != decision {
+		sim.violation("atomicity: %s already %s on its own, but %s says %s",
+			n.id, n.state, from, decision)
+		return
+	}
+	if n.decision == decision {
+		return
+	}
+
+	n.decision = decision
+	n.state = decidedState(decision)
+	sim.broadcast(map[string]interface{}{
+		"type":     "decision_learned",
+		"nodeId":   n.id,
+		"decision": decision,
+		"from":     from,
+	})
+}
+
+// atomicity is the safety property of two-phase commit, checked after
+// every tick: no node commits the transaction while another aborts it
+func (s *Simulation) atomicity() error {
+	decided := make(map[string]string) // State -> first node in it
+	for _, n := range s.nodes {
+		n.mu.RLock()
+		state := n.state
+		n.mu.RUnlock()
+		if (state == "committed" || state == "aborted") && decided[state] == "" {
+			decided[state] = n.id
+		}
+	}
+	if decided["committed"] != "" && decided["aborted"] != "" {
+		return fmt.Errorf("%s committed but %s aborted", decided["committed"], decided["aborted"])
+	}
+	return nil
+}
+
+// Done tells that a two-phase commit run is over once every node decided
+// and no message is left on its way or unread
+func (s *Simulation) Done() (string, bool) {
+	if s.mistake.Name != "2pc_timeout_abort" || s.transport.Summary().InFlight > 0 {
+		return "", false
+	}
+	var state string
+	for _, n := range s.nodes {
+		n.mu.RLock()
+		unread := len(n.inbox)
+		state = n.state
+		n.mu.RUnlock()
+		if unread > 0 || (state != "committed" && state != "aborted") {
+			return "", false
+		}
+	}
+	if err := s.atomicity(); err != nil {
+		return "atomicity broken: " + err.Error(), true
+	}
+	return "every node " + state + " the transaction", true
+}
+
+// decidedState maps a decision to the resulting transaction state
+func decidedState(decision string) string {
+	if decision == "abort" {
+		return "aborted"
+	}
+	return "committed"
+}
@@ -0,0 +1,319 @@
+package mutex
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/ersantana/distributed-systems-learning/packages/core/clock"
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+const (
+	MsgRequest transport.MessageType = "request"
+	MsgReply   transport.MessageType = "reply"
+)
+
+const (
+	csTicks     = 6  // Ticks spent in the critical section
+	resendTicks = 15 // Ticks before asking a silent node again
+)
+
+// Node is a participant of Ricart-Agrawala
+type Node struct {
+	mu sync.RWMutex
+
+	id      string
+	status  string
+	ticks   int
+	nodeIDs []string
+
+	clock     *clock.LamportClock
+	state     string // "released", "wanted", "held"
+	requestTs uint64 // Lamport time of our current request
+	replies   map[string]bool
+	deferred  map[string]uint64 // Node -> timestamp of the request we deferred
+	askedAt   int
+	nextWant  int
+	leaveAt   int
+	entries   int
+	waited    int // Ticks spent waiting for the critical section
+
+	// Token ring
+	hasToken   bool
+	generation int // Newest token generation seen
+	lastSeen   int // Tick the token was last here
+	passAt     int
+
+	inbox      chan *transport.Envelope
+	simulation *Simulation
+}
+
+func newNode(id string, nodeIDs []string, sim *Simulation) *Node {
+	n := &Node{
+		id:         id,
+		status:     "running",
+		nodeIDs:    nodeIDs,
+		clock:      clock.NewLamportClock(),
+		state:      "released",
+		replies:    make(map[string]bool),
+		deferred:   make(map[string]uint64),
+		inbox:      make(chan *transport.Envelope, 500),
+		simulation: sim,
+	}
+	n.nextWant = n.thinkTime()
+	if sim.tokenRing && n.monitor() {
+		n.hasToken = true
+		n.generation = 1
+	}
+	if sim.crashTarget == id {
+		n.nextWant = 3 // Be the first one inside
+	}
+	return n
+}
+
+// Node implements engine.NodeController
+
+func (n *Node) ID() string {
+	return n.id
+}
+
+func (n *Node) Start(ctx context.Context) error {
+	return nil
+}
+
+func (n *Node) Stop() error {
+	return nil
+}
+
+func (n *Node) Tick() {
+	n.simulation.advanceSchedule(n.tick())
+}
+
+func (n *Node) tick() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.ticks++
+	if n.status != "running" {
+		return n.ticks
+	}
+
+	for i := 0; i < 50; i++ {
+		select {
+		case env := <-n.inbox:
+			n.processMessage(env)
+			continue
+		default:
+		}
+		break
+	}
+
+	if n.simulation.tokenRing {
+		n.tokenTick()
+		return n.ticks
+	}
+
+	switch n.state {
+	case "released":
+		if n.ticks >= n.nextWant {
+			n.request()
+		}
+
+	case "wanted":
+		n.waited++
+		// Requests to a crashed node are lost; ask again so it answers
+		// once it is back
+		if n.ticks-n.askedAt >= resendTicks {
+			n.askedAt = n.ticks
+			for _, peer := range n.nodeIDs {
+				if peer != n.id && !n.replies[peer] {
+					n.simulation.send(n.id, peer, MsgRequest, Payload{Timestamp: n.requestTs})
+				}
+			}
+		}
+
+	case "held":
+		if n.ticks >= n.leaveAt {
+			n.release()
+		}
+	}
+	return n.ticks
+}
+
+func (n *Node) GetState() map[string]interface{} {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	replies := make([]string, 0, len(n.replies))
+	for peer := range n.replies {
+		replies = append(replies, peer)
+	}
+	sort.Strings(replies)
+	missing := make([]string, 0)
+	if n.state == "wanted" {
+		for _, peer := range n.nodeIDs {
+			if peer != n.id && !n.replies[peer] {
+				missing = append(missing, peer)
+			}
+		}
+	}
+	deferred := make(map[string]uint64, len(n.deferred))
+	for peer, ts := range n.deferred {
+		deferred[peer] = ts
+	}
+
+	return map[string]interface{}{
+		"id":         n.id,
+		"status":     n.status,
+		"state":      n.state,
+		"lamport":    n.clock.Time(),
+		"requestTs":  n.requestTs,
+		"replies":    replies,
+		"missing":    missing,
+		"deferred":   deferred,
+		"entries":    n.entries,
+		"waited":     n.waited,
+		"hasToken":   n.hasToken,
+		"generation": n.generation,
+	}
+}
+
+func (n *Node) handleMessage(env *transport.Envelope) {
+	n.mu.RLock()
+	crashed := n.status != "running"
+	n.mu.RUnlock()
+
+	// Crashed nodes do not receive anything
+	if crashed {
+		return
+	}
+	select {
+	case n.inbox <- env:
+	default:
+	}
+}
+
+func (n *Node) processMessage(env *transport.Envelope) {
+	payload := n.simulation.received(env)
+
+	switch env.Type {
+	case MsgRequest:
+		n.clock.Update(payload.Timestamp)
+		if n.defers(env.From, payload.Timestamp) {
+			n.deferred[env.From] = payload.Timestamp
+			n.simulation.broadcast(map[string]interface{}{
+				"type":    "reply_deferred",
+				"nodeId":  n.id,
+				"to":      env.From,
+				"request": payload.Timestamp,
+				"own":     n.requestTs,
+			})
+			return
+		}
+		n.clock.Increment()
+		n.simulation.send(n.id, env.From, MsgReply, Payload{Timestamp: n.clock.Time(), Request: payload.Timestamp})
+
+	case MsgReply:
+		n.clock.Update(payload.Timestamp)
+		if n.state != "wanted" || payload.Request != n.requestTs {
+			return // Answer to an earlier request
+		}
+		n.replies[env.From] = true
+		if len(n.replies) == len(n.nodeIDs)-1 {
+			n.enter()
+		}
+
+	case MsgToken:
+		n.receiveToken(payload)
+	}
+}
+
+// defers reports whether a request must wait until we leave: we are
+// inside, or we asked first, ties broken by node ID
+func (n *Node) defers(from string, ts uint64) bool {
+	switch n.state {
+	case "held":
+		return true
+	case "wanted":
+		return n.requestTs < ts || (n.requestTs == ts && n.id < from)
+	}
+	return false
+}
+
+// request asks every other node for the critical section (must hold n.mu)
+func (n *Node) request() {
+	n.state = "wanted"
+	n.requestTs = n.clock.Increment()
+	n.replies = make(map[string]bool)
+	n.askedAt = n.ticks
+
+	for _, peer := range n.nodeIDs {
+		if peer != n.id {
+			n.simulation.send(n.id, peer, MsgRequest, Payload{Timestamp: n.requestTs})
+		}
+	}
+	if len(n.nodeIDs) == 1 {
+		n.enter()
+	}
+}
+
+// enter takes the critical section once everyone replied (must hold n.mu)
+func (n *Node) enter() {
+	n.state = "held"
+	n.leaveAt = n.ticks + csTicks
+	n.entries++
+
+	n.simulation.broadcast(map[string]interface{}{
+		"type":      "cs_enter",
+		"nodeId":    n.id,
+		"timestamp": n.requestTs,
+	})
+
+	if n.simulation.entered(n.id, n.ticks) {
+		n.status = "crashed"
+		n.simulation.broadcast(map[string]interface{}{
+			"type":              "node_crashed",
+			"nodeId":            n.id,
+			"inCriticalSection": true,
+		})
+	}
+}
+
+// release leaves the critical section and answers the deferred requests,
+// or passes the token on (must hold n.mu)
+func (n *Node) release() {
+	n.state = "released"
+	n.nextWant = n.ticks + n.thinkTime()
+	n.simulation.exited(n.id, n.ticks)
+
+	n.simulation.broadcast(map[string]interface{}{
+		"type":     "cs_exit",
+		"nodeId":   n.id,
+		"deferred": len(n.deferred),
+	})
+
+	peers := make([]string, 0, len(n.deferred))
+	for peer := range n.deferred {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers)
+	for _, peer := range peers {
+		ts := n.deferred[peer]
+		n.clock.Increment()
+		n.simulation.send(n.id, peer, MsgReply, Payload{Timestamp: n.clock.Time(), Request: ts})
+	}
+	n.deferred = make(map[string]uint64)
+
+	if n.hasToken {
+		n.passToken()
+	}
+}
+
+// thinkTime returns the ticks until the next request
+func (n *Node) thinkTime() int {
+	if n.simulation.scenario == "light" {
+		return 30 + n.simulation.rng.Intn(30)
+	}
+	return 3 + n.simulation.rng.Intn(10)
+}
@@ -0,0 +1,386 @@
+package mutex
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+	"github.com/ersantana/distributed-systems-learning/packages/simulation/engine"
+)
+
+const (
+	timelineLength = 20  // Critical section occupancies kept
+	recoverAfter   = 100 // Ticks the holder stays crashed in "crash_holder"
+)
+
+// Payload is the content of all messages exchanged in this project
+type Payload struct {
+	Timestamp uint64 `json:"timestamp"`         // Request: Lamport time of the request
+	Request   uint64 `json:"request,omitempty"` // Reply: timestamp of the request answered
+
+	Generation int `json:"generation,omitempty"` // Token: incremented on every regeneration
+}
+
+// Occupancy is a stay of a node in the critical section
+type Occupancy struct {
+	Node  string `json:"node"`
+	Enter int    `json:"enter"`
+	Exit  int    `json:"exit,omitempty"` // 0 while inside
+}
+
+// Simulation runs Ricart-Agrawala mutual exclusion: a node that wants the
+// critical section asks everyone and enters once all of them replied. A
+// node defers its reply while it is inside, or while it wants to enter
+// itself with an older (timestamp, id) request, and sends the deferred
+// replies when it leaves. Every entry costs 2(n-1) messages, and a
+// single crashed node blocks everybody. The "token_" scenarios run the
+// token ring algorithm instead for comparison.
+type Simulation struct {
+	mu sync.RWMutex
+
+	engine    *engine.Engine
+	rng       *rand.Rand
+	transport *transport.NetworkTransport
+	broadcast func(interface{})
+
+	nodes     []*Node
+	scenario  string
+	tokenRing bool
+
+	inside     map[string]bool // Nodes in the critical section
+	timeline   []Occupancy
+	entries    int
+	violations int // Entries while another node was inside
+	messages   int
+
+	crashTarget string // "crash_holder": crashes on its first entry
+	crashed     bool
+	recoverAt   int
+	tokenLossAt int // "token_loss": tick the token is lost in transit
+
+	running bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// Config for mutual exclusion simulation
+type Config struct {
+	NodeCount int
+	Scenario  string // "contention", "light", "crash_holder", "token_ring", "token_loss", "token_crash_holder"
+}
+
+// NewSimulation creates a new mutual exclusion simulation
+func NewSimulation(eng *engine.Engine, trans *transport.NetworkTransport, broadcast func(interface{}), config Config) *Simulation {
+	switch config.Scenario {
+	case "contention", "light", "crash_holder", "token_ring", "token_loss", "token_crash_holder":
+	default:
+		config.Scenario = "contention"
+	}
+	if config.NodeCount == 0 {
+		config.NodeCount = 4
+	}
+
+	sim := &Simulation{
+		engine:    eng,
+		rng:       eng.Rand(),
+		transport: trans,
+		broadcast: broadcast,
+		scenario:  config.Scenario,
+		tokenRing: strings.HasPrefix(config.Scenario, "token_"),
+		inside:    make(map[string]bool),
+	}
+	switch config.Scenario {
+	case "crash_holder":
+		sim.crashTarget = "node-1"
+	case "token_crash_holder":
+		sim.crashTarget = "node-2" // node-1 is the monitor
+	case "token_loss":
+		sim.tokenLossAt = lostTokenAt
+	}
+
+	trans.SetLatency(20*time.Millisecond, 100*time.Millisecond)
+	trans.SetPacketLoss(0)
+
+	nodeIDs := make([]string, config.NodeCount)
+	for i := range nodeIDs {
+		nodeIDs[i] = fmt.Sprintf("node-%d", i+1)
+	}
+	for _, id := range nodeIDs {
+		node := newNode(id, nodeIDs, sim)
+		sim.nodes = append(sim.nodes, node)
+		trans.RegisterHandler(id, node.handleMessage)
+		eng.AddNode(node)
+	}
+
+	return sim
+}
+
+// Start starts the simulation
+func (s *Simulation) Start(ctx context.Context) error {
+	s.mu.Lock()
+	s.running = true
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.mu.Unlock()
+
+	return s.engine.Start(ctx)
+}
+
+// Stop stops the simulation
+func (s *Simulation) Stop() error {
+	s.mu.Lock()
+	s.running = false
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Unlock()
+
+	return s.engine.Stop()
+}
+
+// GetState returns the current simulation state
+func (s *Simulation) GetState() *protocol.SimulationStateResponse {
+	nodes := make(map[string]protocol.NodeState)
+	queue := make([]map[string]interface{}, 0)
+	for _, node := range s.nodes {
+		state := node.GetState()
+		nodes[node.id] = protocol.NodeState{
+			ID:          node.id,
+			Status:      state["status"].(string),
+			Role:        state["state"].(string),
+			CustomState: state,
+		}
+		if state["state"].(string) != "released" {
+			queue = append(queue, map[string]interface{}{
+				"node":      node.id,
+				"timestamp": state["requestTs"].(uint64),
+				"state":     state["state"],
+			})
+		}
+	}
+
+	// Requests are served in (timestamp, id) order
+	sort.Slice(queue, func(i, j int) bool {
+		a, b := queue[i]["timestamp"].(uint64), queue[j]["timestamp"].(uint64)
+		if a != b {
+			return a < b
+		}
+		return queue[i]["node"].(string) < queue[j]["node"].(string)
+	})
+
+	s.mu.RLock()
+	running := s.running
+	inside := make([]string, 0, len(s.inside))
+	for id := range s.inside {
+		inside = append(inside, id)
+	}
+	sort.Strings(inside)
+	timeline := append([]Occupancy{}, s.timeline...)
+	entries, violations, messages := s.entries, s.violations, s.messages
+	s.mu.RUnlock()
+
+	algorithm := "ricart_agrawala"
+	if s.tokenRing {
+		algorithm = "token_ring"
+	}
+
+	perEntry := 0.0
+	if entries > 0 {
+		perEntry = float64(messages) / float64(entries)
+	}
+
+	mode := "step"
+	if s.engine != nil {
+		mode = s.engine.GetMode().String()
+	}
+
+	return &protocol.SimulationStateResponse{
+		Type:        protocol.MsgSimulationState,
+		VirtualTime: time.Now().UnixMilli(),
+		Mode:        mode,
+		Speed:       1.0,
+		Running:     running,
+		Nodes:       nodes,
+		Metadata: map[string]interface{}{
+			"scenario":          s.scenario,
+			"algorithm":         algorithm,
+			"queue":             queue,
+			"inCriticalSection": inside,
+			"timeline":          timeline,
+			"entries":           entries,
+			"violations":        violations,
+			"messagesPerEntry":  perEntry,
+		},
+	}
+}
+
+// GetNodes returns node states
+func (s *Simulation) GetNodes() map[string]protocol.NodeState {
+	state := s.GetState()
+	return state.Nodes
+}
+
+// Layout places the nodes on a ring
+func (s *Simulation) Layout() *protocol.Layout {
+	order := make([]string, len(s.nodes))
+	for i, node := range s.nodes {
+		order[i] = node.id
+	}
+	return &protocol.Layout{Kind: protocol.LayoutRing, Order: order}
+}
+
+// CrashNode crashes a node. It keeps its state, so a node crashed inside
+// the critical section is still inside when it recovers.
+func (s *Simulation) CrashNode(nodeID string) error {
+	node := s.findNode(nodeID)
+	if node == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	node.mu.Lock()
+	node.status = "crashed"
+	node.mu.Unlock()
+	return nil
+}
+
+// RecoverNode recovers a crashed node. On the token ring it comes back
+// without the token, which was regenerated meanwhile, and outside the
+// critical section.
+func (s *Simulation) RecoverNode(nodeID string) error {
+	node := s.findNode(nodeID)
+	if node == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	node.mu.Lock()
+	defer node.mu.Unlock()
+
+	node.status = "running"
+	if s.tokenRing {
+		node.hasToken = false
+		node.lastSeen = node.ticks
+		if node.state == "held" {
+			node.state = "released"
+			node.nextWant = node.ticks + node.thinkTime()
+			s.exited(node.id, node.ticks)
+		}
+	}
+	return nil
+}
+
+// findNode looks up a node by ID; the node list is fixed after construction
+func (s *Simulation) findNode(nodeID string) *Node {
+	for _, node := range s.nodes {
+		if node.id == nodeID {
+			return node
+		}
+	}
+	return nil
+}
+
+// advanceSchedule recovers the crashed holder of "crash_holder"
+func (s *Simulation) advanceSchedule(ticks int) {
+	s.mu.Lock()
+	due := s.recoverAt > 0 && ticks >= s.recoverAt
+	if due {
+		s.recoverAt = 0
+	}
+	target := s.crashTarget
+	s.mu.Unlock()
+
+	if due {
+		s.RecoverNode(target)
+		s.broadcast(map[string]interface{}{
+			"type":   "node_recovered",
+			"nodeId": target,
+		})
+	}
+}
+
+// entered records a node entering the critical section and reports
+// whether it is the scripted crash
+func (s *Simulation) entered(nodeID string, ticks int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.inside) > 0 {
+		s.violations++
+	}
+	s.inside[nodeID] = true
+	s.entries++
+	s.timeline = append(s.timeline, Occupancy{Node: nodeID, Enter: ticks})
+	if len(s.timeline) > timelineLength {
+		s.timeline = s.timeline[1:]
+	}
+
+	if nodeID == s.crashTarget && !s.crashed {
+		s.crashed = true
+		s.recoverAt = ticks + recoverAfter
+		return true
+	}
+	return false
+}
+
+// exited records a node leaving the critical section
+func (s *Simulation) exited(nodeID string, ticks int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.inside, nodeID)
+	for i := len(s.timeline) - 1; i >= 0; i-- {
+		if s.timeline[i].Node == nodeID && s.timeline[i].Exit == 0 {
+			s.timeline[i].Exit = ticks
+			break
+		}
+	}
+}
+
+// loseToken reports whether the token passed at this tick is lost in
+// transit, which happens once in "token_loss"
+func (s *Simulation) loseToken(ticks int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.tokenLossAt == 0 || ticks < s.tokenLossAt {
+		return false
+	}
+	s.tokenLossAt = 0
+	return true
+}
+
+// send transmits a message and reports it to the frontend
+func (s *Simulation) send(from, to string, msgType transport.MessageType, payload Payload) {
+	env := transport.NewEnvelope(from, to, msgType, payload)
+
+	s.mu.Lock()
+	s.messages++
+	s.mu.Unlock()
+
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageSent,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+
+	s.transport.Send(s.ctx, env)
+}
+
+// received reports a delivered message and returns its payload
+func (s *Simulation) received(env *transport.Envelope) Payload {
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageReceived,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+	payload, _ := env.Payload.(Payload)
+	return payload
+}
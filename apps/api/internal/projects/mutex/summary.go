@@ -0,0 +1,49 @@
+package mutex
+
+import (
+	"fmt"
+
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+)
+
+// Invariants judges the run: never two nodes in the critical section,
+// and nodes got in at all
+func (s *Simulation) Invariants() []protocol.InvariantResult {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return []protocol.InvariantResult{
+		{
+			Name:   "mutual exclusion",
+			Holds:  s.violations == 0,
+			Detail: fmt.Sprintf("%d of %d entries while another node was inside", s.violations, s.entries),
+		},
+		{
+			Name:   "progress",
+			Holds:  s.entries > 0,
+			Detail: fmt.Sprintf("%d entries", s.entries),
+		},
+	}
+}
+
+// FollowUps suggests the scenarios that contrast with this one
+func (s *Simulation) FollowUps() []protocol.FollowUp {
+	switch s.scenario {
+	case "contention", "light":
+		return []protocol.FollowUp{
+			{Project: "mutex", Scenario: "token_ring", Reason: "Compare the messages per entry with a circulating token"},
+			{Project: "mutex", Scenario: "crash_holder", Reason: "One crashed node blocks everybody in Ricart-Agrawala"},
+		}
+	case "crash_holder":
+		return []protocol.FollowUp{
+			{Project: "mutex", Scenario: "token_crash_holder", Reason: "The token ring regenerates the token instead of waiting, at the price of safety"},
+		}
+	case "token_ring":
+		return []protocol.FollowUp{
+			{Project: "mutex", Scenario: "token_loss", Reason: "Lose the token in transit and watch the monitor regenerate it"},
+		}
+	}
+	return []protocol.FollowUp{
+		{Project: "mutex", Scenario: "contention", Reason: "Compare with permission-based Ricart-Agrawala"},
+	}
+}
@@ -0,0 +1,117 @@
+package mutex
+
+import (
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+// Token ring mutual exclusion: a single token travels around the ring and
+// only its holder may enter the critical section. A node that wants in
+// waits for the token; one that does not passes it on. Entering needs no
+// messages beyond the circulation itself, but the token keeps moving when
+// nobody wants it, and waiting time grows with the ring.
+//
+// The token is lost with a crashed holder or in transit. Like the active
+// monitor of an IEEE 802.5 ring, the first node regenerates it when it
+// has not come by for longer than a full circulation can take. Tokens
+// carry a generation so that a token thought lost but only delayed is
+// discarded once a newer one was seen. The monitor cannot tell a crashed
+// holder from a slow one: if the holder was only slow, two nodes end up
+// in the critical section, so entries while a crashed holder is still
+// inside count as violations. A crashed node also keeps swallowing the
+// token until it recovers, since the ring is not reconfigured around it.
+const MsgToken transport.MessageType = "token"
+
+const lostTokenAt = 60 // Tick the token is lost in transit in "token_loss"
+
+// monitor is the node that regenerates lost tokens
+func (n *Node) monitor() bool {
+	return n.id == n.nodeIDs[0]
+}
+
+// regenTimeout returns the ticks without the token after which the
+// monitor considers it lost: every node entering once, plus the hops
+func (n *Node) regenTimeout() int {
+	return len(n.nodeIDs)*(csTicks+3) + 10
+}
+
+// tokenTick runs the token ring side of a tick (must hold n.mu)
+func (n *Node) tokenTick() {
+	switch n.state {
+	case "released":
+		if n.ticks >= n.nextWant {
+			n.state = "wanted"
+		}
+	case "wanted":
+		n.waited++
+	case "held":
+		if n.ticks >= n.leaveAt {
+			n.release()
+		}
+	}
+
+	if n.hasToken {
+		n.lastSeen = n.ticks
+		switch n.state {
+		case "wanted":
+			n.enter()
+		case "released":
+			if n.ticks >= n.passAt {
+				n.passToken()
+			}
+		}
+	}
+
+	if n.monitor() && !n.hasToken && n.ticks-n.lastSeen > n.regenTimeout() {
+		n.generation++
+		n.hasToken = true
+		n.lastSeen = n.ticks
+		n.passAt = n.ticks + 1
+		n.simulation.broadcast(map[string]interface{}{
+			"type":       "token_regenerated",
+			"nodeId":     n.id,
+			"generation": n.generation,
+		})
+	}
+}
+
+// receiveToken takes the token from the predecessor (must hold n.mu)
+func (n *Node) receiveToken(payload Payload) {
+	if payload.Generation < n.generation {
+		n.simulation.broadcast(map[string]interface{}{
+			"type":       "stale_token_discarded",
+			"nodeId":     n.id,
+			"generation": payload.Generation,
+			"current":    n.generation,
+		})
+		return
+	}
+	n.generation = payload.Generation
+	n.hasToken = true
+	n.lastSeen = n.ticks
+	n.passAt = n.ticks + 1 // Hold an idle token for a tick, or it races around
+}
+
+// passToken hands the token to the successor (must hold n.mu)
+func (n *Node) passToken() {
+	n.hasToken = false
+	if n.simulation.loseToken(n.ticks) {
+		n.simulation.broadcast(map[string]interface{}{
+			"type":       "token_lost",
+			"nodeId":     n.id,
+			"to":         n.successor(),
+			"generation": n.generation,
+		})
+		return
+	}
+	n.simulation.send(n.id, n.successor(), MsgToken, Payload{Generation: n.generation})
+}
+
+// successor returns the next node on the ring
+func (n *Node) successor() string {
+	for i, id := range n.nodeIDs {
+		if id == n.id {
+			return n.nodeIDs[(i+1)%len(n.nodeIDs)]
+		}
+	}
+	return n.id
+}
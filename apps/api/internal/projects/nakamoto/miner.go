@@ -0,0 +1,275 @@
+package nakamoto
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+const (
+	MsgBlock    transport.MessageType = "block"
+	MsgGetBlock transport.MessageType = "get_block"
+)
+
+const shownBlocks = 8 // Blocks of the chain and orphaned blocks shown in a miner's state
+
+// Block is a mined block; its height is its distance from genesis
+type Block struct {
+	ID     string `json:"id"`
+	Miner  string `json:"miner"`
+	Parent string `json:"parent"`
+	Height int    `json:"height"`
+}
+
+// genesis is the block every chain starts from
+var genesis = Block{ID: "genesis"}
+
+// Miner mines on the tip of the longest chain it knows and sends every
+// block it mines to all its peers. A block extending a chain longer than
+// its own makes it switch: the blocks of its old chain past the fork are
+// orphaned, their transactions undone. A block whose parent it has not
+// seen, the tip of a chain mined across a partition, makes it fetch the
+// ancestors back to the fork.
+type Miner struct {
+	mu sync.RWMutex
+
+	id     string
+	status string // "running" or "crashed"
+	ticks  int
+
+	blocks  map[string]Block   // Blocks connected to genesis
+	waiting map[string][]Block // Parent -> blocks received before it
+	tip     string
+
+	mined   int
+	reorgs  int
+	deepest int // Blocks undone by the deepest reorg
+
+	inbox      chan *transport.Envelope
+	simulation *Simulation
+}
+
+func newMiner(id string, sim *Simulation) *Miner {
+	return &Miner{
+		id:         id,
+		status:     "running",
+		blocks:     map[string]Block{genesis.ID: genesis},
+		waiting:    make(map[string][]Block),
+		tip:        genesis.ID,
+		inbox:      make(chan *transport.Envelope, 500),
+		simulation: sim,
+	}
+}
+
+// Miner implements engine.NodeController
+
+func (m *Miner) ID() string {
+	return m.id
+}
+
+func (m *Miner) Start(ctx context.Context) error {
+	return nil
+}
+
+func (m *Miner) Stop() error {
+	return nil
+}
+
+func (m *Miner) Tick() {
+	m.simulation.advanceSchedule(m.tick())
+}
+
+func (m *Miner) tick() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.ticks++
+	if m.status == "crashed" {
+		return m.ticks
+	}
+
+	for i := 0; i < 50; i++ {
+		select {
+		case env := <-m.inbox:
+			m.processMessage(env)
+			continue
+		default:
+		}
+		break
+	}
+
+	// Each miner has an equal share of the hash power
+	if m.simulation.rng.Float64() < m.simulation.miningChance() {
+		m.mine()
+	}
+	return m.ticks
+}
+
+func (m *Miner) GetState() map[string]interface{} {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	chain := m.mainChain()
+	onChain := make(map[string]bool, len(chain))
+	for _, id := range chain {
+		onChain[id] = true
+	}
+	orphaned := make([]Block, 0)
+	for id, block := range m.blocks {
+		if !onChain[id] {
+			orphaned = append(orphaned, block)
+		}
+	}
+	sort.Slice(orphaned, func(i, j int) bool {
+		if orphaned[i].Height != orphaned[j].Height {
+			return orphaned[i].Height < orphaned[j].Height
+		}
+		return orphaned[i].ID < orphaned[j].ID
+	})
+	orphanedIDs := make([]string, 0, shownBlocks)
+	for _, block := range orphaned[max(0, len(orphaned)-shownBlocks):] {
+		orphanedIDs = append(orphanedIDs, block.ID)
+	}
+
+	return map[string]interface{}{
+		"id":           m.id,
+		"status":       m.status,
+		"role":         "miner",
+		"tip":          m.tip,
+		"height":       m.blocks[m.tip].Height,
+		"chain":        chain[max(0, len(chain)-shownBlocks):], // Most recent blocks of the main chain
+		"orphaned":     orphanedIDs,                            // Highest blocks known off the main chain
+		"orphanCount":  len(orphaned),
+		"mined":        m.mined,
+		"reorgs":       m.reorgs,
+		"deepestReorg": m.deepest,
+	}
+}
+
+func (m *Miner) handleMessage(env *transport.Envelope) {
+	m.mu.RLock()
+	down := m.status == "crashed"
+	m.mu.RUnlock()
+
+	if down {
+		return
+	}
+	select {
+	case m.inbox <- env:
+	default:
+	}
+}
+
+func (m *Miner) processMessage(env *transport.Envelope) {
+	sim := m.simulation
+	payload := sim.received(env)
+
+	switch env.Type {
+	case MsgBlock:
+		if payload.Block != nil {
+			m.receive(*payload.Block, env.From)
+		}
+	case MsgGetBlock:
+		if block, ok := m.blocks[payload.ID]; ok && block.ID != genesis.ID {
+			sim.send(m.id, env.From, MsgBlock, Payload{Block: &block})
+		}
+	}
+}
+
+// mine appends a block to the tip and sends it to every peer (must hold
+// m.mu)
+func (m *Miner) mine() {
+	block := m.simulation.mined(m.id, m.blocks[m.tip])
+	m.mined++
+	m.blocks[block.ID] = block
+	m.tip = block.ID
+	m.announce()
+}
+
+// announce sends the tip to every peer (must hold m.mu)
+func (m *Miner) announce() {
+	sim := m.simulation
+	block, ok := m.blocks[m.tip]
+	if !ok || block.ID == genesis.ID {
+		return
+	}
+	for _, peer := range sim.miners {
+		if peer.id != m.id {
+			sim.send(m.id, peer.id, MsgBlock, Payload{Block: &block})
+		}
+	}
+}
+
+// receive connects a block to the tree, asking the sender for its parent
+// if it is missing, and switches to the longest chain (must hold m.mu)
+func (m *Miner) receive(block Block, from string) {
+	if _, ok := m.blocks[block.ID]; ok {
+		return
+	}
+	if _, ok := m.blocks[block.Parent]; !ok {
+		m.waiting[block.Parent] = append(m.waiting[block.Parent], block)
+		m.simulation.send(m.id, from, MsgGetBlock, Payload{ID: block.Parent})
+		return
+	}
+
+	// Connect the block and every block that was waiting for it
+	best := m.tip
+	pending := []Block{block}
+	for len(pending) > 0 {
+		b := pending[0]
+		pending = pending[1:]
+		m.blocks[b.ID] = b
+		if b.Height > m.blocks[best].Height {
+			best = b.ID
+		}
+		pending = append(pending, m.waiting[b.ID]...)
+		delete(m.waiting, b.ID)
+	}
+	if best != m.tip {
+		m.switchTo(best)
+	}
+}
+
+// switchTo makes a longer chain the main chain, reporting the blocks of
+// the old one it orphans (must hold m.mu)
+func (m *Miner) switchTo(tip string) {
+	oldTip := m.tip
+	a, b := m.blocks[oldTip], m.blocks[tip]
+	for b.Height > a.Height {
+		b = m.blocks[b.Parent]
+	}
+	orphaned := make([]string, 0)
+	for a.ID != b.ID {
+		orphaned = append(orphaned, a.ID)
+		a, b = m.blocks[a.Parent], m.blocks[b.Parent]
+	}
+	m.tip = tip
+	if len(orphaned) > 0 {
+		m.reorgs++
+		m.deepest = max(m.deepest, len(orphaned))
+		m.simulation.reorg(m.id, oldTip, tip, a.ID, orphaned)
+	}
+}
+
+// mainChain returns the block IDs from genesis, excluded, to the tip
+// (must hold m.mu)
+func (m *Miner) mainChain() []string {
+	chain := make([]string, 0, m.blocks[m.tip].Height)
+	for b := m.blocks[m.tip]; b.ID != genesis.ID; b = m.blocks[b.Parent] {
+		chain = append(chain, b.ID)
+	}
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+	return chain
+}
+
+// chain returns the main chain, for the invariants
+func (m *Miner) chain() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.mainChain()
+}
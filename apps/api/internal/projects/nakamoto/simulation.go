@@ -0,0 +1,443 @@
+package nakamoto
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+	"github.com/ersantana/distributed-systems-learning/packages/simulation/engine"
+)
+
+const (
+	confirmations = 6   // Blocks on top of a block before it counts as settled
+	splitAt       = 60  // Tick the "partition" scenario splits the miners
+	healAt        = 240 // Tick it heals the split
+	shownTree     = 40  // Most recent blocks of the block tree in the metadata
+)
+
+// Payload is the content of all messages exchanged in this project
+type Payload struct {
+	Block *Block `json:"block,omitempty"`
+	ID    string `json:"id,omitempty"` // Block asked for
+}
+
+// Simulation runs Nakamoto consensus: miners find blocks at random, at a
+// rate that sets the expected ticks between two blocks across the
+// network, and follow the longest chain. Two blocks found before either
+// reached the other miners fork the chain until one branch grows longer.
+// Scenario "fast_mining" makes blocks come faster than they spread, so
+// forks are frequent; scenario "partition" splits the miners in two for a
+// while, each side growing its own chain, and on healing the smaller side
+// reorganizes onto the longer chain, orphaning everything it mined.
+type Simulation struct {
+	mu sync.RWMutex
+
+	engine    *engine.Engine
+	rng       *rand.Rand
+	transport *transport.NetworkTransport
+	broadcast func(interface{})
+
+	miners      []*Miner
+	scenario    string
+	interval    float64 // Expected ticks between blocks, network-wide
+	partitioned bool
+	manual      bool // The user split or healed, which ends the schedule
+
+	tree    []Block // Every block mined, in order
+	reorgs  int
+	deepest int
+
+	lastTick int
+
+	running bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// Config for Nakamoto consensus simulation
+type Config struct {
+	NodeCount int
+	Scenario  string // "steady", "fast_mining", "partition"
+}
+
+// NewSimulation creates a new Nakamoto consensus simulation
+func NewSimulation(eng *engine.Engine, trans *transport.NetworkTransport, broadcast func(interface{}), config Config) *Simulation {
+	switch config.Scenario {
+	case "steady", "fast_mining", "partition":
+	default:
+		config.Scenario = "steady"
+	}
+	if config.NodeCount == 0 {
+		config.NodeCount = 5
+	}
+	config.NodeCount = max(config.NodeCount, 2)
+
+	sim := &Simulation{
+		engine:    eng,
+		rng:       eng.Rand(),
+		transport: trans,
+		broadcast: broadcast,
+		scenario:  config.Scenario,
+		interval:  10,
+	}
+	if config.Scenario == "fast_mining" {
+		sim.interval = 1.5
+	}
+
+	trans.SetLatency(20*time.Millisecond, 150*time.Millisecond)
+	trans.SetPacketLoss(0)
+
+	for i := 0; i < config.NodeCount; i++ {
+		miner := newMiner(fmt.Sprintf("miner-%d", i+1), sim)
+		sim.miners = append(sim.miners, miner)
+		trans.RegisterHandler(miner.id, miner.handleMessage)
+		eng.AddNode(miner)
+	}
+
+	return sim
+}
+
+// Start starts the simulation
+func (s *Simulation) Start(ctx context.Context) error {
+	s.mu.Lock()
+	s.running = true
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.mu.Unlock()
+
+	return s.engine.Start(ctx)
+}
+
+// Stop stops the simulation
+func (s *Simulation) Stop() error {
+	s.mu.Lock()
+	s.running = false
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Unlock()
+
+	return s.engine.Stop()
+}
+
+// GetState returns the current simulation state
+func (s *Simulation) GetState() *protocol.SimulationStateResponse {
+	nodes := make(map[string]protocol.NodeState)
+	height := 0
+	tips := make(map[string]bool)
+	for _, miner := range s.miners {
+		state := miner.GetState()
+		nodes[miner.id] = protocol.NodeState{
+			ID:          miner.id,
+			Status:      state["status"].(string),
+			Role:        "miner",
+			CustomState: state,
+		}
+		height = max(height, state["height"].(int))
+		if state["status"] == "running" {
+			tips[state["tip"].(string)] = true
+		}
+	}
+
+	s.mu.RLock()
+	running := s.running
+	tree := append([]Block{}, s.tree[max(0, len(s.tree)-shownTree):]...)
+	metadata := map[string]interface{}{
+		"scenario":      s.scenario,
+		"blockInterval": s.interval,
+		"partitioned":   s.partitioned,
+		"height":        height,
+		"tips":          len(tips), // More than one while the miners disagree
+		"mined":         len(s.tree),
+		"stale":         s.stale(), // Blocks off the tallest branch
+		"reorgs":        s.reorgs,
+		"deepestReorg":  s.deepest,
+		"confirmations": confirmations,
+		"blocks":        tree, // Most recent blocks of the tree, every branch
+	}
+	s.mu.RUnlock()
+
+	mode := "step"
+	if s.engine != nil {
+		mode = s.engine.GetMode().String()
+	}
+
+	return &protocol.SimulationStateResponse{
+		Type:        protocol.MsgSimulationState,
+		VirtualTime: time.Now().UnixMilli(),
+		Mode:        mode,
+		Speed:       1.0,
+		Running:     running,
+		Nodes:       nodes,
+		Metadata:    metadata,
+	}
+}
+
+// GetNodes returns node states
+func (s *Simulation) GetNodes() map[string]protocol.NodeState {
+	state := s.GetState()
+	return state.Nodes
+}
+
+// Layout draws the two sides of the partition as two columns
+func (s *Simulation) Layout() *protocol.Layout {
+	a, b := s.sides()
+	return &protocol.Layout{
+		Kind: protocol.LayoutGroups,
+		Groups: []protocol.LayoutGroup{
+			{Name: "minority", Nodes: a},
+			{Name: "majority", Nodes: b},
+		},
+	}
+}
+
+// CrashNode crashes a miner; it keeps its blocks and stops mining
+func (s *Simulation) CrashNode(nodeID string) error {
+	miner := s.findMiner(nodeID)
+	if miner == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	miner.mu.Lock()
+	miner.status = "crashed"
+	miner.mu.Unlock()
+	return nil
+}
+
+// RecoverNode recovers a crashed miner; it announces its tip, and the
+// replies to the blocks its peers are missing bring it up to date
+func (s *Simulation) RecoverNode(nodeID string) error {
+	miner := s.findMiner(nodeID)
+	if miner == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	miner.mu.Lock()
+	miner.status = "running"
+	miner.mu.Unlock()
+
+	s.announceAll()
+	return nil
+}
+
+// HandleClientRequest runs a client command: "set_interval" sets the
+// expected ticks between blocks, {ticks}; "partition" and "heal" split
+// the miners in two and join them back
+func (s *Simulation) HandleClientRequest(command string, payload map[string]interface{}) error {
+	switch command {
+	case "set_interval":
+		ticks, _ := payload["ticks"].(float64)
+		if ticks < 0.5 {
+			return fmt.Errorf("ticks must be at least 0.5, got %v", ticks)
+		}
+		s.mu.Lock()
+		s.interval = ticks
+		s.mu.Unlock()
+		s.broadcast(map[string]interface{}{
+			"type":          "interval_changed",
+			"blockInterval": ticks,
+		})
+		return nil
+	case "partition", "heal":
+		s.mu.Lock()
+		s.manual = true
+		s.mu.Unlock()
+		s.split(command == "partition")
+		return nil
+	}
+	return fmt.Errorf("unknown command: %s", command)
+}
+
+// NodeActions lists the actions of a miner: "mine" finds a block at once
+func (s *Simulation) NodeActions(nodeID string) []string {
+	miner := s.findMiner(nodeID)
+	if miner == nil {
+		return nil
+	}
+	miner.mu.RLock()
+	defer miner.mu.RUnlock()
+
+	if miner.status != "running" {
+		return nil
+	}
+	return []string{"mine"}
+}
+
+// InvokeNodeAction invokes an action listed by NodeActions
+func (s *Simulation) InvokeNodeAction(nodeID, action string, params map[string]interface{}) error {
+	miner := s.findMiner(nodeID)
+	if miner == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	if action != "mine" {
+		return fmt.Errorf("unknown action: %s", action)
+	}
+	miner.mu.Lock()
+	defer miner.mu.Unlock()
+	if miner.status != "running" {
+		return fmt.Errorf("miner %s is down", nodeID)
+	}
+	miner.mine()
+	return nil
+}
+
+// findMiner looks up a miner by ID
+func (s *Simulation) findMiner(nodeID string) *Miner {
+	for _, miner := range s.miners {
+		if miner.id == nodeID {
+			return miner
+		}
+	}
+	return nil
+}
+
+// sides returns the two groups a partition separates, the smaller first
+func (s *Simulation) sides() ([]string, []string) {
+	a, b := make([]string, 0), make([]string, 0)
+	for i, miner := range s.miners {
+		if i < len(s.miners)/2 {
+			a = append(a, miner.id)
+		} else {
+			b = append(b, miner.id)
+		}
+	}
+	return a, b
+}
+
+// split cuts or heals the links between the two sides; on healing every
+// miner announces its tip so the sides learn each other's chain
+func (s *Simulation) split(cut bool) {
+	s.mu.Lock()
+	s.partitioned = cut
+	s.mu.Unlock()
+
+	a, b := s.sides()
+	for _, x := range a {
+		for _, y := range b {
+			if cut {
+				s.transport.CreateBidirectionalPartition(x, y)
+			} else {
+				s.transport.ClearBidirectionalPartition(x, y)
+			}
+		}
+	}
+	eventType := "partition_created"
+	if !cut {
+		eventType = "partition_healed"
+	}
+	s.broadcast(map[string]interface{}{
+		"type":   eventType,
+		"groups": [][]string{a, b},
+	})
+	if !cut {
+		s.announceAll()
+	}
+}
+
+// announceAll makes every running miner send its tip to its peers
+func (s *Simulation) announceAll() {
+	for _, miner := range s.miners {
+		miner.mu.Lock()
+		if miner.status == "running" {
+			miner.announce()
+		}
+		miner.mu.Unlock()
+	}
+}
+
+// miningChance returns the chance a miner finds a block in a tick
+func (s *Simulation) miningChance() float64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return 1 / (s.interval * float64(len(s.miners)))
+}
+
+// advanceSchedule splits and heals the miners in the "partition"
+// scenario, once per tick
+func (s *Simulation) advanceSchedule(ticks int) {
+	s.mu.Lock()
+	if ticks <= s.lastTick {
+		s.mu.Unlock()
+		return
+	}
+	s.lastTick = ticks
+	scripted := s.scenario == "partition" && !s.manual
+	s.mu.Unlock()
+
+	if scripted && (ticks == splitAt || ticks == healAt) {
+		s.split(ticks == splitAt)
+	}
+}
+
+// mined names and records a block a miner found on top of a parent
+func (s *Simulation) mined(minerID string, parent Block) Block {
+	s.mu.Lock()
+	block := Block{
+		ID:     fmt.Sprintf("b%d", len(s.tree)+1),
+		Miner:  minerID,
+		Parent: parent.ID,
+		Height: parent.Height + 1,
+	}
+	s.tree = append(s.tree, block)
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":   "block_mined",
+		"nodeId": minerID,
+		"block":  block.ID,
+		"parent": block.Parent,
+		"height": block.Height,
+	})
+	return block
+}
+
+// reorg records a miner switching to a longer chain, orphaning the blocks
+// of its old chain past the fork
+func (s *Simulation) reorg(minerID, oldTip, newTip, fork string, orphaned []string) {
+	s.mu.Lock()
+	s.reorgs++
+	s.deepest = max(s.deepest, len(orphaned))
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":     "reorg",
+		"nodeId":   minerID,
+		"oldTip":   oldTip,
+		"newTip":   newTip,
+		"fork":     fork,
+		"depth":    len(orphaned),
+		"orphaned": orphaned,
+	})
+}
+
+// send transmits a message and reports it to the frontend
+func (s *Simulation) send(from, to string, msgType transport.MessageType, payload Payload) {
+	env := transport.NewEnvelope(from, to, msgType, payload)
+
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageSent,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+
+	s.transport.Send(s.ctx, env)
+}
+
+// received reports a delivered message and returns its payload
+func (s *Simulation) received(env *transport.Envelope) Payload {
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageReceived,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+	payload, _ := env.Payload.(Payload)
+	return payload
+}
@@ -0,0 +1,83 @@
+package nakamoto
+
+import (
+	"fmt"
+
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+)
+
+// Invariants judges the run on Nakamoto consensus' probabilistic
+// finality: miners may disagree about the last few blocks, but a block
+// buried under enough confirmations should never be undone. A long
+// partition breaks the second: the smaller side's chain is reorged away,
+// however deep.
+func (s *Simulation) Invariants() []protocol.InvariantResult {
+	chains := make([][]string, 0, len(s.miners))
+	for _, miner := range s.miners {
+		miner.mu.RLock()
+		up := miner.status == "running"
+		miner.mu.RUnlock()
+		if up {
+			chains = append(chains, miner.chain())
+		}
+	}
+
+	// Every pair of chains must share all but their last blocks
+	disagreements := 0
+	for i := range chains {
+		for j := i + 1; j < len(chains); j++ {
+			settled := min(len(chains[i]), len(chains[j])) - confirmations
+			for k := 0; k < settled; k++ {
+				if chains[i][k] != chains[j][k] {
+					disagreements++
+					break
+				}
+			}
+		}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return []protocol.InvariantResult{
+		{
+			Name:   fmt.Sprintf("chains agree below %d confirmations", confirmations),
+			Holds:  disagreements == 0,
+			Detail: fmt.Sprintf("%d pairs of running miners disagree on a block with %d blocks on top", disagreements, confirmations),
+		},
+		{
+			Name:   "confirmed blocks are never reorged",
+			Holds:  s.deepest < confirmations,
+			Detail: fmt.Sprintf("%d reorgs, the deepest undid %d blocks; %d of %d blocks mined were orphaned", s.reorgs, s.deepest, s.stale(), len(s.tree)),
+		},
+	}
+}
+
+// stale counts the blocks mined off the tallest branch (must hold s.mu)
+func (s *Simulation) stale() int {
+	height := 0
+	for _, block := range s.tree {
+		height = max(height, block.Height)
+	}
+	return len(s.tree) - height
+}
+
+// FollowUps suggests the scenarios that contrast with this one
+func (s *Simulation) FollowUps() []protocol.FollowUp {
+	switch s.scenario {
+	case "partition":
+		return []protocol.FollowUp{
+			{Project: "cap", Reason: "See the choice the partition forced: both sides stayed available and diverged"},
+			{Project: "hotstuff", Scenario: "steady", Reason: "Commit blocks with quorum certificates, final as soon as they commit"},
+		}
+	case "fast_mining":
+		return []protocol.FollowUp{
+			{Project: "nakamoto", Scenario: "steady", Reason: "Mine blocks slower than they spread and watch the forks disappear"},
+			{Project: "nakamoto", Scenario: "partition", Reason: "Split the miners and watch a whole side's chain get orphaned"},
+		}
+	}
+	return []protocol.FollowUp{
+		{Project: "nakamoto", Scenario: "fast_mining", Reason: "Mine blocks faster than they spread and count the stale blocks"},
+		{Project: "nakamoto", Scenario: "partition", Reason: "Split the miners and watch a whole side's chain get orphaned"},
+	}
+}
@@ -0,0 +1,386 @@
+package percolator
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+const (
+	thinkTicks = 4  // Ticks between two transactions of a client
+	retryTicks = 3  // Ticks a reader waits on a live lock before reading again
+	txnTimeout = 60 // Ticks before a client gives up on a transaction whose replies stopped
+	maxAmount  = 10
+)
+
+// txn is the transaction a client is running
+type txn struct {
+	id       string
+	kind     string // "transfer" or "audit"
+	phase    string // "start_ts", "reading", "prewriting", "commit_ts", "committing"
+	startTs  int64
+	commitTs int64
+	began    int
+	keys     []string // The primary first
+	amount   int
+
+	values     map[string]int
+	blockedBy  map[string]Lock // Key read, lock found on it that is being resolved
+	retryAt    map[string]int  // Key, tick to read it again
+	prewritten map[string]bool
+	replies    int
+	failed     string // Why a prewrite failed
+
+	crashAt string // Scripted crash: "before_commit" or "after_primary"
+}
+
+// Client runs transactions the way a Percolator worker does. A transfer
+// reads two accounts at its start timestamp, prewrites both, locking
+// them, with the first as the primary, then commits the primary at its
+// commit timestamp: that single write is the commit point. The
+// secondaries are committed afterwards. A read that finds a lock asks the
+// primary's server what became of the transaction, then rolls the lock
+// forward or back: cleanup is lazy, done by whoever runs into a lock. The
+// auditor reads every account in one snapshot.
+type Client struct {
+	mu sync.RWMutex
+
+	id     string
+	role   string // "client" or "auditor"
+	status string // "running" or "crashed"
+	ticks  int
+
+	txn     *txn
+	next    int // Tick the next transaction begins
+	count   int
+	commits int
+	aborts  int
+	manual  *txn // Transfer a user asked for, begun when the client is free
+
+	inbox      chan *transport.Envelope
+	simulation *Simulation
+}
+
+func newClient(id, role string, start int, sim *Simulation) *Client {
+	return &Client{
+		id:         id,
+		role:       role,
+		status:     "running",
+		next:       start,
+		inbox:      make(chan *transport.Envelope, 500),
+		simulation: sim,
+	}
+}
+
+// Client implements engine.NodeController
+
+func (c *Client) ID() string {
+	return c.id
+}
+
+func (c *Client) Start(ctx context.Context) error {
+	return nil
+}
+
+func (c *Client) Stop() error {
+	return nil
+}
+
+func (c *Client) Tick() {
+	c.simulation.advanceSchedule(c.tick())
+}
+
+func (c *Client) tick() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ticks++
+	if c.status == "crashed" {
+		return c.ticks
+	}
+
+	for i := 0; i < 50; i++ {
+		select {
+		case env := <-c.inbox:
+			c.processMessage(env)
+			continue
+		default:
+		}
+		break
+	}
+
+	if t := c.txn; t != nil {
+		for _, key := range sortedKeys(t.retryAt) {
+			if at := t.retryAt[key]; c.ticks >= at {
+				delete(t.retryAt, key)
+				c.get(key)
+			}
+		}
+		if c.ticks-t.began >= txnTimeout {
+			c.abort("timeout")
+		}
+	}
+	if c.txn == nil && (c.manual != nil || c.ticks >= c.next) {
+		c.begin()
+	}
+	return c.ticks
+}
+
+func (c *Client) GetState() map[string]interface{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	state := map[string]interface{}{
+		"id":      c.id,
+		"status":  c.status,
+		"role":    c.role,
+		"commits": c.commits,
+		"aborts":  c.aborts,
+	}
+	if t := c.txn; t != nil {
+		state["txn"] = map[string]interface{}{
+			"id":      t.id,
+			"kind":    t.kind,
+			"phase":   t.phase,
+			"startTs": t.startTs,
+			"keys":    t.keys,
+			"blocked": len(t.blockedBy) + len(t.retryAt),
+		}
+	}
+	return state
+}
+
+func (c *Client) handleMessage(env *transport.Envelope) {
+	c.mu.RLock()
+	down := c.status == "crashed"
+	c.mu.RUnlock()
+
+	if down {
+		return
+	}
+	select {
+	case c.inbox <- env:
+	default:
+	}
+}
+
+func (c *Client) processMessage(env *transport.Envelope) {
+	sim := c.simulation
+	payload := sim.received(env)
+	t := c.txn
+	if t == nil || payload.Txn != t.id {
+		return
+	}
+
+	switch env.Type {
+	case MsgTsReply:
+		switch t.phase {
+		case "start_ts":
+			t.startTs = payload.Timestamp
+			t.phase = "reading"
+			for _, key := range t.keys {
+				c.get(key)
+			}
+		case "commit_ts":
+			t.commitTs = payload.Timestamp
+			t.phase = "committing"
+			sim.send(c.id, sim.serverOf(t.keys[0]), MsgCommit, Payload{Txn: t.id, Key: t.keys[0], StartTs: t.startTs, CommitTs: t.commitTs})
+		}
+
+	case MsgGetReply:
+		if t.phase != "reading" {
+			return
+		}
+		if payload.Lock != nil {
+			t.blockedBy[payload.Key] = *payload.Lock
+			sim.lockFound(c.id, payload.Key, *payload.Lock)
+			sim.send(c.id, sim.serverOf(payload.Lock.Primary), MsgCheckTxnStatus, Payload{Txn: t.id, Key: payload.Lock.Primary, StartTs: payload.Lock.StartTs})
+			return
+		}
+		t.values[payload.Key] = payload.Value
+		if len(t.values) == len(t.keys) {
+			c.read()
+		}
+
+	case MsgTxnStatus:
+		for _, key := range sortedKeys(t.blockedBy) {
+			lock := t.blockedBy[key]
+			if lock.StartTs != payload.StartTs {
+				continue
+			}
+			if payload.Status == "locked" {
+				// The transaction is alive: wait for it rather than kill it
+				delete(t.blockedBy, key)
+				t.retryAt[key] = c.ticks + retryTicks
+				continue
+			}
+			sim.send(c.id, sim.serverOf(key), MsgResolveLock, Payload{Txn: t.id, Key: key, StartTs: lock.StartTs, CommitTs: payload.CommitTs})
+		}
+
+	case MsgLockResolved:
+		if _, ok := t.blockedBy[payload.Key]; ok {
+			delete(t.blockedBy, payload.Key)
+			c.get(payload.Key)
+		}
+
+	case MsgPrewriteReply:
+		if t.phase != "prewriting" {
+			return
+		}
+		t.replies++
+		if payload.OK {
+			t.prewritten[payload.Key] = true
+		} else if t.failed == "" {
+			t.failed = payload.Reason
+		}
+		if t.replies < len(t.keys) {
+			return
+		}
+		switch {
+		case t.failed != "":
+			c.abort(t.failed)
+		case t.crashAt == "before_commit":
+			c.scriptedCrash()
+		default:
+			t.phase = "commit_ts"
+			sim.send(c.id, sim.tso.id, MsgTsRequest, Payload{Txn: t.id})
+		}
+
+	case MsgCommitReply:
+		if t.phase != "committing" || payload.Key != t.keys[0] {
+			return
+		}
+		if !payload.OK {
+			// A reader found the primary lock expired and rolled it back
+			c.abort("primary_rolled_back")
+			return
+		}
+		c.commits++
+		sim.committed(c.id, t.id, t.startTs, t.commitTs, t.keys, t.amount)
+		if t.crashAt == "after_primary" {
+			c.scriptedCrash()
+			return
+		}
+		for _, key := range t.keys[1:] {
+			sim.send(c.id, sim.serverOf(key), MsgCommit, Payload{Txn: t.id, Key: key, StartTs: t.startTs, CommitTs: t.commitTs})
+		}
+		c.finish()
+	}
+}
+
+// begin starts the next transaction: the one a user asked for, an audit
+// of every account, or a transfer between two random accounts (must hold
+// c.mu)
+func (c *Client) begin() {
+	sim := c.simulation
+	c.count++
+	t := &txn{
+		id:         fmt.Sprintf("%s.%d", c.id, c.count),
+		phase:      "start_ts",
+		began:      c.ticks,
+		values:     make(map[string]int),
+		blockedBy:  make(map[string]Lock),
+		retryAt:    make(map[string]int),
+		prewritten: make(map[string]bool),
+	}
+	switch {
+	case c.manual != nil:
+		t.kind, t.keys, t.amount = "transfer", c.manual.keys, c.manual.amount
+		c.manual = nil
+	case c.role == "auditor":
+		t.kind, t.keys = "audit", append([]string{}, sim.accounts...)
+	default:
+		from := c.simulation.rng.Intn(len(sim.accounts))
+		to := (from + 1 + c.simulation.rng.Intn(len(sim.accounts)-1)) % len(sim.accounts)
+		t.kind, t.keys, t.amount = "transfer", []string{sim.accounts[from], sim.accounts[to]}, c.simulation.rng.Intn(maxAmount)+1
+		t.crashAt = sim.crashPoint(c.id)
+	}
+	c.txn = t
+	sim.send(c.id, sim.tso.id, MsgTsRequest, Payload{Txn: t.id})
+}
+
+// get reads a key at the transaction's start timestamp (must hold c.mu)
+func (c *Client) get(key string) {
+	t := c.txn
+	c.simulation.send(c.id, c.simulation.serverOf(key), MsgGet, Payload{Txn: t.id, Key: key, StartTs: t.startTs})
+}
+
+// read goes on once every key was read: an audit checks the total, a
+// transfer prewrites the new balances, the primary first (must hold c.mu)
+func (c *Client) read() {
+	sim := c.simulation
+	t := c.txn
+	if t.kind == "audit" {
+		sim.audited(c.id, t.id, t.startTs, t.values)
+		c.finish()
+		return
+	}
+
+	from, to := t.keys[0], t.keys[1]
+	t.amount = min(t.amount, t.values[from])
+	balances := map[string]int{from: t.values[from] - t.amount, to: t.values[to] + t.amount}
+	t.phase = "prewriting"
+	sim.prewriting(t.id, t.startTs, t.keys)
+	for _, key := range t.keys {
+		sim.send(c.id, sim.serverOf(key), MsgPrewrite, Payload{Txn: t.id, Key: key, Value: balances[key], StartTs: t.startTs, Primary: t.keys[0]})
+	}
+}
+
+// abort rolls back the keys the transaction locked (must hold c.mu)
+func (c *Client) abort(reason string) {
+	sim := c.simulation
+	t := c.txn
+	if t.phase == "prewriting" || t.phase == "commit_ts" || t.phase == "committing" {
+		for _, key := range t.keys {
+			sim.send(c.id, sim.serverOf(key), MsgRollback, Payload{Txn: t.id, Key: key, StartTs: t.startTs})
+		}
+	}
+	c.aborts++
+	sim.aborted(c.id, t.id, t.startTs, reason)
+	c.finish()
+	c.next += c.simulation.rng.Intn(thinkTicks)
+}
+
+// finish ends the transaction (must hold c.mu)
+func (c *Client) finish() {
+	c.txn = nil
+	c.next = c.ticks + thinkTicks
+}
+
+// scriptedCrash crashes the client in the middle of a transaction,
+// leaving its locks behind (must hold c.mu)
+func (c *Client) scriptedCrash() {
+	t := c.txn
+	c.crash()
+	c.simulation.clientCrashed(c.id, t.id, t.crashAt)
+}
+
+// crash loses the transaction in progress; its locks stay on the servers
+// (must hold c.mu)
+func (c *Client) crash() {
+	c.status = "crashed"
+	c.txn = nil
+	c.manual = nil
+	for {
+		select {
+		case <-c.inbox:
+			continue
+		default:
+		}
+		break
+	}
+}
+
+// sortedKeys returns the keys of a map in order, so that runs with the
+// same seed send the same messages in the same order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,382 @@
+package percolator
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+const (
+	MsgGet            transport.MessageType = "get"
+	MsgGetReply       transport.MessageType = "get_reply"
+	MsgPrewrite       transport.MessageType = "prewrite"
+	MsgPrewriteReply  transport.MessageType = "prewrite_reply"
+	MsgCommit         transport.MessageType = "commit"
+	MsgCommitReply    transport.MessageType = "commit_reply"
+	MsgRollback       transport.MessageType = "rollback"
+	MsgCheckTxnStatus transport.MessageType = "check_txn_status"
+	MsgTxnStatus      transport.MessageType = "txn_status"
+	MsgResolveLock    transport.MessageType = "resolve_lock"
+	MsgLockResolved   transport.MessageType = "lock_resolved"
+)
+
+const (
+	lockTTL    = 30 // Ticks before the lock of a transaction whose primary is still locked may be rolled back
+	keptWrites = 5  // Write records of each key shown in the state
+)
+
+// Lock marks a key a transaction prewrote and has not committed yet. It
+// names the transaction's primary key, whose lock decides its fate.
+type Lock struct {
+	Txn     string `json:"txn"`
+	StartTs int64  `json:"startTs"`
+	Primary string `json:"primary"`
+	At      int    `json:"at"` // Server tick the lock was written
+}
+
+// Write is a record of the write column: the data written at StartTs is
+// visible from CommitTs on. A rollback record keeps a late prewrite of a
+// rolled back transaction out.
+type Write struct {
+	CommitTs int64 `json:"commitTs"`
+	StartTs  int64 `json:"startTs"`
+	Rollback bool  `json:"rollback,omitempty"`
+}
+
+// row holds a key's three columns: the values by start timestamp, the
+// lock, and the write records
+type row struct {
+	data   map[int64]int
+	lock   *Lock
+	writes []Write
+}
+
+// committed returns the write record of the transaction that started at
+// startTs, if it committed
+func (r *row) committed(startTs int64) (Write, bool) {
+	for _, w := range r.writes {
+		if w.StartTs == startTs && !w.Rollback {
+			return w, true
+		}
+	}
+	return Write{}, false
+}
+
+// rolledBack reports whether the transaction that started at startTs was
+// rolled back
+func (r *row) rolledBack(startTs int64) bool {
+	for _, w := range r.writes {
+		if w.StartTs == startTs && w.Rollback {
+			return true
+		}
+	}
+	return false
+}
+
+// latest returns the newest write record committed at or before ts
+func (r *row) latest(ts int64) (Write, bool) {
+	var best Write
+	found := false
+	for _, w := range r.writes {
+		if !w.Rollback && w.CommitTs <= ts && (!found || w.CommitTs > best.CommitTs) {
+			best, found = w, true
+		}
+	}
+	return best, found
+}
+
+// Server is a tablet server storing some of the keys. It keeps no
+// transaction state of its own: everything a transaction leaves behind is
+// in the rows, and the clients drive commits and cleanup.
+type Server struct {
+	mu sync.RWMutex
+
+	id     string
+	status string // "running" or "crashed"
+	ticks  int
+	rows   map[string]*row
+
+	inbox      chan *transport.Envelope
+	simulation *Simulation
+}
+
+func newServer(id string, keys []string, sim *Simulation) *Server {
+	s := &Server{
+		id:         id,
+		status:     "running",
+		rows:       make(map[string]*row),
+		inbox:      make(chan *transport.Envelope, 500),
+		simulation: sim,
+	}
+	for _, key := range keys {
+		// Every account starts with a balance committed at timestamp 0
+		s.rows[key] = &row{
+			data:   map[int64]int{0: initialBalance},
+			writes: []Write{{}},
+		}
+	}
+	return s
+}
+
+// Server implements engine.NodeController
+
+func (s *Server) ID() string {
+	return s.id
+}
+
+func (s *Server) Start(ctx context.Context) error {
+	return nil
+}
+
+func (s *Server) Stop() error {
+	return nil
+}
+
+func (s *Server) Tick() {
+	s.simulation.advanceSchedule(s.tick())
+}
+
+func (s *Server) tick() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.ticks++
+	if s.status == "crashed" {
+		return s.ticks
+	}
+
+	for i := 0; i < 50; i++ {
+		select {
+		case env := <-s.inbox:
+			s.processMessage(env)
+			continue
+		default:
+		}
+		break
+	}
+	return s.ticks
+}
+
+func (s *Server) GetState() map[string]interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]string, 0, len(s.rows))
+	for key := range s.rows {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	balances := make(map[string]int, len(s.rows))
+	locks := make(map[string]Lock)
+	writes := make(map[string][]Write, len(s.rows))
+	orphans := 0
+	for _, key := range keys {
+		r := s.rows[key]
+		if w, ok := r.latest(math.MaxInt64); ok {
+			balances[key] = r.data[w.StartTs]
+		}
+		if r.lock != nil {
+			locks[key] = *r.lock
+			if s.ticks-r.lock.At >= lockTTL {
+				orphans++
+			}
+		}
+		recent := r.writes[max(0, len(r.writes)-keptWrites):]
+		writes[key] = append([]Write{}, recent...)
+	}
+
+	return map[string]interface{}{
+		"id":          s.id,
+		"status":      s.status,
+		"role":        "server",
+		"keys":        keys,
+		"balances":    balances, // Last committed value of each key
+		"locks":       locks,
+		"writes":      writes,  // Most recent records of each key
+		"orphanLocks": orphans, // Locks held past the TTL
+	}
+}
+
+func (s *Server) handleMessage(env *transport.Envelope) {
+	s.mu.RLock()
+	down := s.status == "crashed"
+	s.mu.RUnlock()
+
+	if down {
+		return
+	}
+	select {
+	case s.inbox <- env:
+	default:
+	}
+}
+
+func (s *Server) processMessage(env *transport.Envelope) {
+	sim := s.simulation
+	payload := sim.received(env)
+	r := s.rows[payload.Key]
+	if r == nil {
+		return
+	}
+
+	switch env.Type {
+	case MsgGet:
+		// A lock older than the read may belong to a transaction that
+		// committed before the read started; the reader has to find out
+		reply := Payload{Txn: payload.Txn, Key: payload.Key}
+		if r.lock != nil && r.lock.StartTs <= payload.StartTs {
+			lock := *r.lock
+			reply.Lock = &lock
+		} else if w, ok := r.latest(payload.StartTs); ok {
+			reply.Value = r.data[w.StartTs]
+			reply.OK = true
+		}
+		sim.send(s.id, env.From, MsgGetReply, reply)
+
+	case MsgPrewrite:
+		reply := Payload{Txn: payload.Txn, Key: payload.Key}
+		switch {
+		case r.rolledBack(payload.StartTs):
+			reply.Reason = "rolled_back"
+		case r.lock != nil && r.lock.StartTs != payload.StartTs:
+			reply.Reason = "locked"
+		case s.newerWrite(r, payload.StartTs):
+			reply.Reason = "write_conflict"
+		default:
+			r.data[payload.StartTs] = payload.Value
+			r.lock = &Lock{Txn: payload.Txn, StartTs: payload.StartTs, Primary: payload.Primary, At: s.ticks}
+			reply.OK = true
+		}
+		sim.send(s.id, env.From, MsgPrewriteReply, reply)
+
+	case MsgCommit:
+		ok := s.commit(r, payload.StartTs, payload.CommitTs)
+		sim.send(s.id, env.From, MsgCommitReply, Payload{Txn: payload.Txn, Key: payload.Key, OK: ok})
+
+	case MsgRollback:
+		s.rollback(r, payload.StartTs)
+
+	case MsgCheckTxnStatus:
+		// The primary's row decides: committed if it has the write record,
+		// still running while its lock is fresh, otherwise rolled back
+		reply := Payload{Txn: payload.Txn, Key: payload.Key, StartTs: payload.StartTs}
+		switch {
+		case r.lock != nil && r.lock.StartTs == payload.StartTs && s.ticks-r.lock.At < lockTTL:
+			reply.Status = "locked"
+		case r.lock != nil && r.lock.StartTs == payload.StartTs:
+			lock := *r.lock
+			s.rollback(r, payload.StartTs)
+			sim.lockResolved(s.id, env.From, payload.Key, lock, 0)
+			reply.Status = "rolled_back"
+		default:
+			if w, ok := r.committed(payload.StartTs); ok {
+				reply.Status = "committed"
+				reply.CommitTs = w.CommitTs
+			} else {
+				s.rollback(r, payload.StartTs)
+				reply.Status = "rolled_back"
+			}
+		}
+		sim.send(s.id, env.From, MsgTxnStatus, reply)
+
+	case MsgResolveLock:
+		// Roll the lock forward to the primary's commit, or back
+		if r.lock != nil && r.lock.StartTs == payload.StartTs {
+			lock := *r.lock
+			if payload.CommitTs > 0 {
+				s.commit(r, payload.StartTs, payload.CommitTs)
+			} else {
+				s.rollback(r, payload.StartTs)
+			}
+			sim.lockResolved(s.id, env.From, payload.Key, lock, payload.CommitTs)
+		}
+		sim.send(s.id, env.From, MsgLockResolved, Payload{Txn: payload.Txn, Key: payload.Key, StartTs: payload.StartTs})
+	}
+}
+
+// newerWrite reports whether a transaction committed the key at or after
+// startTs, which a transaction that started at startTs did not see (must
+// hold s.mu)
+func (s *Server) newerWrite(r *row, startTs int64) bool {
+	for _, w := range r.writes {
+		if !w.Rollback && w.CommitTs >= startTs {
+			return true
+		}
+	}
+	return false
+}
+
+// commit turns the lock of the transaction that started at startTs into
+// a write record; it fails if the lock is gone and the transaction did
+// not commit (must hold s.mu)
+func (s *Server) commit(r *row, startTs, commitTs int64) bool {
+	if _, ok := r.committed(startTs); ok {
+		return true
+	}
+	if r.lock == nil || r.lock.StartTs != startTs {
+		return false
+	}
+	r.lock = nil
+	r.writes = append(r.writes, Write{CommitTs: commitTs, StartTs: startTs})
+	return true
+}
+
+// rollback erases the lock and the data of the transaction that started
+// at startTs, and records the rollback unless it committed (must hold
+// s.mu)
+func (s *Server) rollback(r *row, startTs int64) {
+	if _, ok := r.committed(startTs); ok {
+		return
+	}
+	if r.lock != nil && r.lock.StartTs == startTs {
+		r.lock = nil
+	}
+	delete(r.data, startTs)
+	if !r.rolledBack(startTs) {
+		r.writes = append(r.writes, Write{CommitTs: startTs, StartTs: startTs, Rollback: true})
+	}
+}
+
+// outcome returns what became of the transaction that started at startTs
+// on a key: "committed", "rolled_back", "locked", or "" if it left no
+// trace there
+func (s *Server) outcome(key string, startTs int64) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r := s.rows[key]
+	switch {
+	case r == nil:
+		return ""
+	case r.lock != nil && r.lock.StartTs == startTs:
+		return "locked"
+	case r.rolledBack(startTs):
+		return "rolled_back"
+	}
+	if _, ok := r.committed(startTs); ok {
+		return "committed"
+	}
+	return ""
+}
+
+// staleLocks counts the locks held for more than ticks while the server
+// is running; readers should have cleaned them up by then
+func (s *Server) staleLocks(ticks int) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.status != "running" {
+		return 0
+	}
+	n := 0
+	for _, r := range s.rows {
+		if r.lock != nil && s.ticks-r.lock.At > ticks {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,690 @@
+package percolator
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+	"github.com/ersantana/distributed-systems-learning/packages/simulation/engine"
+)
+
+const (
+	accountCount   = 6
+	initialBalance = 100
+	crashEvery     = 60 // Ticks between the scripted crashes of client-1
+	downTicks      = 20 // Ticks a crashed client stays down
+)
+
+// Payload is the content of all messages exchanged in this project
+type Payload struct {
+	Txn       string `json:"txn,omitempty"`
+	Key       string `json:"key,omitempty"`
+	Value     int    `json:"value,omitempty"`
+	StartTs   int64  `json:"startTs,omitempty"`
+	CommitTs  int64  `json:"commitTs,omitempty"`
+	Timestamp int64  `json:"timestamp,omitempty"`
+	Primary   string `json:"primary,omitempty"`
+	OK        bool   `json:"ok,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	Status    string `json:"status,omitempty"` // "locked", "committed" or "rolled_back"
+	Lock      *Lock  `json:"lock,omitempty"`
+}
+
+// record is what the simulation remembers of a transaction that
+// prewrote, to check afterwards that it committed all or nothing
+type record struct {
+	txn     string
+	startTs int64
+	keys    []string // The primary first
+}
+
+// Simulation runs Percolator's transactions over accounts spread across
+// tablet servers. A timestamp oracle hands out the start and commit
+// timestamps; two clients move money between accounts with two-phase
+// commit, and an auditor reads every account in one snapshot, checking
+// the total never changes.
+//
+// No coordinator keeps the state of a transaction: its locks do. Every
+// lock names the transaction's primary key, and committing the primary is
+// the commit point. A client that crashes leaves its locks behind, and
+// whoever reads a locked key cleans up: it asks the primary's server
+// whether the transaction committed and rolls the lock forward, or rolls
+// it back once the primary's lock outlived its TTL. Scenario
+// "crash_before_commit" crashes client-1 after its prewrites, leaving
+// locks to roll back; "crash_after_primary" crashes it after committing
+// the primary, leaving secondaries to roll forward.
+type Simulation struct {
+	mu sync.RWMutex
+
+	engine    *engine.Engine
+	rng       *rand.Rand
+	transport *transport.NetworkTransport
+	broadcast func(interface{})
+
+	tso      *TSO
+	servers  []*Server
+	clients  []*Client
+	accounts []string
+	owners   map[string]string // Account -> server
+	scenario string
+
+	crashAt   string // Scripted crash point of client-1
+	nextCrash int
+	recoverAt map[string]int // Crashed client -> tick it recovers
+
+	records    []record
+	crashedTxn map[string]bool // Transactions lost with a crashed client
+
+	commits       int
+	aborts        map[string]int // By reason
+	locksFound    int
+	rolledBack    int
+	rolledForward int
+	orphansFixed  int // Locks of crashed transactions resolved by readers
+	crashes       int
+	audits        int
+	badAudits     int
+	messages      int
+
+	lastTick int
+
+	running bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// Config for Percolator simulation
+type Config struct {
+	NodeCount int    // Tablet servers
+	Scenario  string // "transfers", "crash_before_commit", "crash_after_primary"
+}
+
+// NewSimulation creates a new Percolator simulation
+func NewSimulation(eng *engine.Engine, trans *transport.NetworkTransport, broadcast func(interface{}), config Config) *Simulation {
+	switch config.Scenario {
+	case "transfers", "crash_before_commit", "crash_after_primary":
+	default:
+		config.Scenario = "transfers"
+	}
+	if config.NodeCount == 0 {
+		config.NodeCount = 3
+	}
+	config.NodeCount = max(config.NodeCount, 2)
+
+	sim := &Simulation{
+		engine:     eng,
+		rng:        eng.Rand(),
+		transport:  trans,
+		broadcast:  broadcast,
+		owners:     make(map[string]string),
+		scenario:   config.Scenario,
+		nextCrash:  crashEvery / 2,
+		recoverAt:  make(map[string]int),
+		crashedTxn: make(map[string]bool),
+		aborts:     make(map[string]int),
+	}
+	switch config.Scenario {
+	case "crash_before_commit":
+		sim.crashAt = "before_commit"
+	case "crash_after_primary":
+		sim.crashAt = "after_primary"
+	}
+
+	trans.SetLatency(20*time.Millisecond, 80*time.Millisecond)
+	trans.SetPacketLoss(0)
+
+	sim.tso = newTSO("tso", sim)
+	trans.RegisterHandler(sim.tso.id, sim.tso.handleMessage)
+	eng.AddNode(sim.tso)
+
+	owned := make(map[string][]string)
+	for i := 0; i < accountCount; i++ {
+		account := fmt.Sprintf("acct-%d", i+1)
+		server := fmt.Sprintf("server-%d", i%config.NodeCount+1)
+		sim.accounts = append(sim.accounts, account)
+		sim.owners[account] = server
+		owned[server] = append(owned[server], account)
+	}
+	for i := 0; i < config.NodeCount; i++ {
+		id := fmt.Sprintf("server-%d", i+1)
+		server := newServer(id, owned[id], sim)
+		sim.servers = append(sim.servers, server)
+		trans.RegisterHandler(id, server.handleMessage)
+		eng.AddNode(server)
+	}
+
+	for i, id := range []string{"client-1", "client-2", "auditor"} {
+		role := "client"
+		if id == "auditor" {
+			role = "auditor"
+		}
+		client := newClient(id, role, 2+i, sim)
+		sim.clients = append(sim.clients, client)
+		trans.RegisterHandler(id, client.handleMessage)
+		eng.AddNode(client)
+	}
+
+	return sim
+}
+
+// Start starts the simulation
+func (s *Simulation) Start(ctx context.Context) error {
+	s.mu.Lock()
+	s.running = true
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.mu.Unlock()
+
+	return s.engine.Start(ctx)
+}
+
+// Stop stops the simulation
+func (s *Simulation) Stop() error {
+	s.mu.Lock()
+	s.running = false
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Unlock()
+
+	return s.engine.Stop()
+}
+
+// GetState returns the current simulation state
+func (s *Simulation) GetState() *protocol.SimulationStateResponse {
+	nodes := make(map[string]protocol.NodeState)
+
+	state := s.tso.GetState()
+	nodes[s.tso.id] = protocol.NodeState{
+		ID:          s.tso.id,
+		Status:      state["status"].(string),
+		Role:        "tso",
+		CustomState: state,
+	}
+	timestamp := state["timestamp"].(int64)
+
+	balances := make(map[string]int)
+	total, locks, orphans := 0, 0, 0
+	for _, server := range s.servers {
+		state := server.GetState()
+		nodes[server.id] = protocol.NodeState{
+			ID:          server.id,
+			Status:      state["status"].(string),
+			Role:        "server",
+			CustomState: state,
+		}
+		for key, balance := range state["balances"].(map[string]int) {
+			balances[key] = balance
+			total += balance
+		}
+		locks += len(state["locks"].(map[string]Lock))
+		orphans += state["orphanLocks"].(int)
+	}
+
+	for _, client := range s.clients {
+		state := client.GetState()
+		nodes[client.id] = protocol.NodeState{
+			ID:          client.id,
+			Status:      state["status"].(string),
+			Role:        state["role"].(string),
+			CustomState: state,
+		}
+	}
+
+	s.mu.RLock()
+	running := s.running
+	aborts := make(map[string]int, len(s.aborts))
+	for reason, n := range s.aborts {
+		aborts[reason] = n
+	}
+	perCommit := 0.0
+	if s.commits > 0 {
+		perCommit = float64(s.messages) / float64(s.commits)
+	}
+	metadata := map[string]interface{}{
+		"scenario":          s.scenario,
+		"timestamp":         timestamp,
+		"owners":            s.owners,
+		"balances":          balances, // Last committed balance of each account
+		"totalBalance":      total,    // Off while a transfer's secondary is not committed yet
+		"locks":             locks,
+		"orphanLocks":       orphans, // Locks held past the TTL
+		"commits":           s.commits,
+		"aborts":            aborts,
+		"locksFound":        s.locksFound,
+		"rolledBack":        s.rolledBack,
+		"rolledForward":     s.rolledForward,
+		"orphansFixed":      s.orphansFixed,
+		"crashes":           s.crashes,
+		"audits":            s.audits,
+		"badAudits":         s.badAudits,
+		"messages":          s.messages,
+		"messagesPerCommit": perCommit, // Audits and aborted attempts included
+	}
+	s.mu.RUnlock()
+
+	mode := "step"
+	if s.engine != nil {
+		mode = s.engine.GetMode().String()
+	}
+
+	return &protocol.SimulationStateResponse{
+		Type:        protocol.MsgSimulationState,
+		VirtualTime: time.Now().UnixMilli(),
+		Mode:        mode,
+		Speed:       1.0,
+		Running:     running,
+		Nodes:       nodes,
+		Metadata:    metadata,
+	}
+}
+
+// GetNodes returns node states
+func (s *Simulation) GetNodes() map[string]protocol.NodeState {
+	state := s.GetState()
+	return state.Nodes
+}
+
+// Layout draws the timestamp oracle, the clients and the tablet servers
+// as three columns
+func (s *Simulation) Layout() *protocol.Layout {
+	clients := make([]string, len(s.clients))
+	for i, client := range s.clients {
+		clients[i] = client.id
+	}
+	servers := make([]string, len(s.servers))
+	for i, server := range s.servers {
+		servers[i] = server.id
+	}
+	return &protocol.Layout{
+		Kind: protocol.LayoutGroups,
+		Groups: []protocol.LayoutGroup{
+			{Name: "timestamp oracle", Nodes: []string{s.tso.id}},
+			{Name: "clients", Nodes: clients},
+			{Name: "servers", Nodes: servers},
+		},
+	}
+}
+
+// CrashNode crashes a node. A crashed server keeps its rows, locks
+// included; a crashed client loses its transaction and leaves its locks
+// to whoever reads the keys next.
+func (s *Simulation) CrashNode(nodeID string) error {
+	if nodeID == s.tso.id {
+		s.tso.mu.Lock()
+		s.tso.status = "crashed"
+		s.tso.mu.Unlock()
+		return nil
+	}
+	if server := s.findServer(nodeID); server != nil {
+		server.mu.Lock()
+		server.status = "crashed"
+		server.mu.Unlock()
+		return nil
+	}
+	if client := s.findClient(nodeID); client != nil {
+		client.mu.Lock()
+		if client.txn != nil {
+			s.mu.Lock()
+			s.crashedTxn[client.txn.id] = true
+			s.mu.Unlock()
+		}
+		client.crash()
+		client.mu.Unlock()
+		return nil
+	}
+	return fmt.Errorf("unknown node: %s", nodeID)
+}
+
+// RecoverNode recovers a crashed node
+func (s *Simulation) RecoverNode(nodeID string) error {
+	if nodeID == s.tso.id {
+		s.tso.mu.Lock()
+		s.tso.status = "running"
+		s.tso.mu.Unlock()
+		return nil
+	}
+	if server := s.findServer(nodeID); server != nil {
+		server.mu.Lock()
+		server.status = "running"
+		server.mu.Unlock()
+		return nil
+	}
+	if client := s.findClient(nodeID); client != nil {
+		client.mu.Lock()
+		client.status = "running"
+		client.next = client.ticks + thinkTicks
+		client.mu.Unlock()
+
+		s.mu.Lock()
+		delete(s.recoverAt, nodeID)
+		s.mu.Unlock()
+		return nil
+	}
+	return fmt.Errorf("unknown node: %s", nodeID)
+}
+
+// HandleClientRequest runs a client command: "transfer" moves an amount
+// from an account to another, {nodeId, from, to, amount}, on a client
+// once its current transaction is over, and "audit" makes the auditor
+// read every account
+func (s *Simulation) HandleClientRequest(command string, payload map[string]interface{}) error {
+	switch command {
+	case "transfer":
+		nodeID, _ := payload["nodeId"].(string)
+		from, _ := payload["from"].(string)
+		to, _ := payload["to"].(string)
+		amount, _ := payload["amount"].(float64)
+		return s.transfer(nodeID, from, to, int(amount))
+	case "audit":
+		return s.audit()
+	}
+	return fmt.Errorf("unknown command: %s", command)
+}
+
+// NodeActions lists the actions of a node: a "transfer" between two
+// random accounts on a client, an "audit" on the auditor
+func (s *Simulation) NodeActions(nodeID string) []string {
+	client := s.findClient(nodeID)
+	if client == nil {
+		return nil
+	}
+	client.mu.RLock()
+	defer client.mu.RUnlock()
+
+	if client.status != "running" {
+		return nil
+	}
+	if client.role == "auditor" {
+		return []string{"audit"}
+	}
+	return []string{"transfer"}
+}
+
+// InvokeNodeAction invokes an action listed by NodeActions
+func (s *Simulation) InvokeNodeAction(nodeID, action string, params map[string]interface{}) error {
+	switch action {
+	case "transfer":
+		return s.transfer(nodeID, "", "", 0)
+	case "audit":
+		return s.audit()
+	}
+	return fmt.Errorf("unknown action: %s", action)
+}
+
+// transfer queues a transfer on a client; without accounts the client
+// starts a random transfer as soon as it is free, without an amount it
+// moves the most it may
+func (s *Simulation) transfer(nodeID, from, to string, amount int) error {
+	client := s.findClient(nodeID)
+	if client == nil || client.role != "client" {
+		return fmt.Errorf("not a client: %s", nodeID)
+	}
+	if from == "" && to == "" {
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		if client.status != "running" {
+			return fmt.Errorf("client %s is down", nodeID)
+		}
+		client.next = client.ticks
+		return nil
+	}
+	if _, ok := s.owners[from]; !ok {
+		return fmt.Errorf("unknown account: %s", from)
+	}
+	if _, ok := s.owners[to]; !ok || to == from {
+		return fmt.Errorf("invalid destination account: %s", to)
+	}
+	if amount <= 0 {
+		amount = maxAmount
+	}
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if client.status != "running" {
+		return fmt.Errorf("client %s is down", nodeID)
+	}
+	client.manual = &txn{keys: []string{from, to}, amount: amount}
+	return nil
+}
+
+// audit makes the auditor start a snapshot read as soon as it is free
+func (s *Simulation) audit() error {
+	auditor := s.clients[len(s.clients)-1]
+	auditor.mu.Lock()
+	defer auditor.mu.Unlock()
+
+	if auditor.status != "running" {
+		return fmt.Errorf("auditor is down")
+	}
+	auditor.next = auditor.ticks
+	return nil
+}
+
+// findServer looks up a server by ID
+func (s *Simulation) findServer(nodeID string) *Server {
+	for _, server := range s.servers {
+		if server.id == nodeID {
+			return server
+		}
+	}
+	return nil
+}
+
+// findClient looks up a client or the auditor by ID
+func (s *Simulation) findClient(nodeID string) *Client {
+	for _, client := range s.clients {
+		if client.id == nodeID {
+			return client
+		}
+	}
+	return nil
+}
+
+// serverOf returns the server storing an account
+func (s *Simulation) serverOf(key string) string {
+	return s.owners[key]
+}
+
+// advanceSchedule recovers the clients whose scripted downtime is over,
+// once per tick
+func (s *Simulation) advanceSchedule(ticks int) {
+	s.mu.Lock()
+	if ticks <= s.lastTick {
+		s.mu.Unlock()
+		return
+	}
+	s.lastTick = ticks
+	due := make([]string, 0)
+	for _, id := range sortedKeys(s.recoverAt) {
+		if ticks >= s.recoverAt[id] {
+			due = append(due, id)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, id := range due {
+		s.RecoverNode(id)
+		s.broadcast(map[string]interface{}{
+			"type":   "node_recovered",
+			"nodeId": id,
+		})
+	}
+}
+
+// crashPoint returns where the client should crash during the
+// transaction it is beginning, if the scenario scripts a crash now
+func (s *Simulation) crashPoint(clientID string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.crashAt == "" || clientID != s.clients[0].id || s.lastTick < s.nextCrash {
+		return ""
+	}
+	s.nextCrash = s.lastTick + crashEvery
+	return s.crashAt
+}
+
+// clientCrashed records a scripted crash in the middle of a transaction
+func (s *Simulation) clientCrashed(clientID, txnID, point string) {
+	s.mu.Lock()
+	s.crashes++
+	s.crashedTxn[txnID] = true
+	s.recoverAt[clientID] = s.lastTick + downTicks
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":   "node_crashed",
+		"nodeId": clientID,
+		"txn":    txnID,
+		"reason": point,
+	})
+}
+
+// prewriting records a transaction about to lock its keys
+func (s *Simulation) prewriting(txnID string, startTs int64, keys []string) {
+	s.mu.Lock()
+	s.records = append(s.records, record{txn: txnID, startTs: startTs, keys: append([]string{}, keys...)})
+	s.mu.Unlock()
+}
+
+// committed records a transaction whose primary committed
+func (s *Simulation) committed(clientID, txnID string, startTs, commitTs int64, keys []string, amount int) {
+	s.mu.Lock()
+	s.commits++
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":     "txn_committed",
+		"nodeId":   clientID,
+		"txn":      txnID,
+		"startTs":  startTs,
+		"commitTs": commitTs,
+		"from":     keys[0],
+		"to":       keys[1],
+		"amount":   amount,
+	})
+}
+
+// aborted records a transaction given up
+func (s *Simulation) aborted(clientID, txnID string, startTs int64, reason string) {
+	s.mu.Lock()
+	s.aborts[reason]++
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":    "txn_aborted",
+		"nodeId":  clientID,
+		"txn":     txnID,
+		"startTs": startTs,
+		"reason":  reason,
+	})
+}
+
+// lockFound records a read that ran into a lock
+func (s *Simulation) lockFound(clientID, key string, lock Lock) {
+	s.mu.Lock()
+	s.locksFound++
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":    "lock_found",
+		"nodeId":  clientID,
+		"key":     key,
+		"txn":     lock.Txn,
+		"primary": lock.Primary,
+	})
+}
+
+// lockResolved records a lock a reader rolled forward to commitTs, or
+// back when commitTs is 0
+func (s *Simulation) lockResolved(serverID, clientID, key string, lock Lock, commitTs int64) {
+	s.mu.Lock()
+	if commitTs > 0 {
+		s.rolledForward++
+	} else {
+		s.rolledBack++
+	}
+	orphan := s.crashedTxn[lock.Txn]
+	if orphan {
+		s.orphansFixed++
+	}
+	s.mu.Unlock()
+
+	kind := "lock_rolled_back"
+	if commitTs > 0 {
+		kind = "lock_rolled_forward"
+	}
+	s.broadcast(map[string]interface{}{
+		"type":     kind,
+		"nodeId":   serverID,
+		"by":       clientID,
+		"key":      key,
+		"txn":      lock.Txn,
+		"commitTs": commitTs,
+		"orphan":   orphan, // Left by a crashed client
+	})
+}
+
+// audited records a snapshot read of every account
+func (s *Simulation) audited(clientID, txnID string, startTs int64, values map[string]int) {
+	sum := 0
+	for _, v := range values {
+		sum += v
+	}
+	expected := initialBalance * len(s.accounts)
+
+	s.mu.Lock()
+	s.audits++
+	if sum != expected {
+		s.badAudits++
+	}
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":     "audit",
+		"nodeId":   clientID,
+		"txn":      txnID,
+		"startTs":  startTs,
+		"balances": values,
+		"total":    sum,
+		"ok":       sum == expected,
+	})
+}
+
+// send transmits a message and reports it to the frontend
+func (s *Simulation) send(from, to string, msgType transport.MessageType, payload Payload) {
+	env := transport.NewEnvelope(from, to, msgType, payload)
+
+	s.mu.Lock()
+	s.messages++
+	s.mu.Unlock()
+
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageSent,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+
+	s.transport.Send(s.ctx, env)
+}
+
+// received reports a delivered message and returns its payload
+func (s *Simulation) received(env *transport.Envelope) Payload {
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageReceived,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+	payload, _ := env.Payload.(Payload)
+	return payload
+}
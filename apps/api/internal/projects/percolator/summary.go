@@ -0,0 +1,78 @@
+package percolator
+
+import (
+	"fmt"
+
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+)
+
+// Invariants judges the run on snapshot isolation and atomicity: every
+// audit sees the money the accounts started with, every transaction
+// commits on all its keys or none, and the locks of crashed clients do
+// not stay behind once their TTL is over
+func (s *Simulation) Invariants() []protocol.InvariantResult {
+	s.mu.RLock()
+	records := append([]record{}, s.records...)
+	s.mu.RUnlock()
+
+	// The servers are asked without s.mu: a server holding its own lock
+	// takes s.mu to send
+	torn, pending := 0, 0
+	for _, rec := range records {
+		primary := s.findServer(s.serverOf(rec.keys[0])).outcome(rec.keys[0], rec.startTs)
+		for _, key := range rec.keys[1:] {
+			switch secondary := s.findServer(s.serverOf(key)).outcome(key, rec.startTs); {
+			case primary == "committed" && secondary == "rolled_back",
+				primary != "committed" && secondary == "committed":
+				torn++
+			case primary == "committed" && secondary == "locked":
+				pending++
+			}
+		}
+	}
+	stale := 0
+	for _, server := range s.servers {
+		stale += server.staleLocks(2 * lockTTL)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return []protocol.InvariantResult{
+		{
+			Name:   "snapshot reads see the total balance",
+			Holds:  s.badAudits == 0,
+			Detail: fmt.Sprintf("%d of %d audits saw a total other than %d", s.badAudits, s.audits, initialBalance*len(s.accounts)),
+		},
+		{
+			Name:   "transactions commit atomically",
+			Holds:  torn == 0,
+			Detail: fmt.Sprintf("%d keys disagree with their primary over %d transactions; %d committed secondaries not rolled forward yet", torn, len(records), pending),
+		},
+		{
+			Name:   "orphan locks are cleaned up",
+			Holds:  stale == 0,
+			Detail: fmt.Sprintf("%d locks held past twice the TTL; readers rolled %d orphan locks back or forward after %d crashes", stale, s.orphansFixed, s.crashes),
+		},
+	}
+}
+
+// FollowUps suggests the scenarios that contrast with this one
+func (s *Simulation) FollowUps() []protocol.FollowUp {
+	switch s.scenario {
+	case "crash_before_commit":
+		return []protocol.FollowUp{
+			{Project: "percolator", Scenario: "crash_after_primary", Reason: "Crash after the commit point, leaving locks to roll forward"},
+			{Project: "mistakes", Scenario: "2pc_timeout_abort", Reason: "See a coordinator-based two-phase commit go wrong without a commit point in the data"},
+		}
+	case "crash_after_primary":
+		return []protocol.FollowUp{
+			{Project: "percolator", Scenario: "crash_before_commit", Reason: "Crash before the commit point, leaving locks to roll back"},
+			{Project: "truetime", Scenario: "commit_wait", Reason: "Order commits with clock uncertainty instead of a timestamp oracle"},
+		}
+	}
+	return []protocol.FollowUp{
+		{Project: "percolator", Scenario: "crash_before_commit", Reason: "Crash a client mid-transaction and watch readers clean up its locks"},
+		{Project: "locks", Scenario: "deadlock", Reason: "Lock with two-phase locking, where waiting transactions can deadlock"},
+	}
+}
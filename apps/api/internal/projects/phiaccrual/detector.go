@@ -0,0 +1,83 @@
+package phiaccrual
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	windowSize = 100   // Inter-arrival times kept per peer
+	minStdDev  = 100.0 // Milliseconds; keeps a steady peer's phi from spiking on small jitter
+	maxPhi     = 50.0  // Phi past this is as good as certain, and is reported as this
+)
+
+// window keeps the latest inter-arrival times of a peer's heartbeats, in
+// milliseconds, and the arrival time of the last one
+type window struct {
+	intervals []float64
+	last      time.Time
+}
+
+// arrived records a heartbeat arriving at a time
+func (w *window) arrived(at time.Time) {
+	if !w.last.IsZero() {
+		if !at.After(w.last) {
+			return
+		}
+		w.intervals = append(w.intervals, float64(at.Sub(w.last).Microseconds())/1000)
+		if len(w.intervals) > windowSize {
+			w.intervals = w.intervals[len(w.intervals)-windowSize:]
+		}
+	}
+	w.last = at
+}
+
+// stats returns the mean and standard deviation of the intervals
+func (w *window) stats() (float64, float64) {
+	if len(w.intervals) == 0 {
+		return 0, 0
+	}
+	sum := 0.0
+	for _, interval := range w.intervals {
+		sum += interval
+	}
+	mean := sum / float64(len(w.intervals))
+	variance := 0.0
+	for _, interval := range w.intervals {
+		variance += (interval - mean) * (interval - mean)
+	}
+	return mean, math.Sqrt(variance / float64(len(w.intervals)))
+}
+
+// silence returns the milliseconds since the last heartbeat
+func (w *window) silence(now time.Time) float64 {
+	if w.last.IsZero() {
+		return 0
+	}
+	return float64(now.Sub(w.last).Microseconds()) / 1000
+}
+
+// phi returns the suspicion level of the peer: -log10 of the probability
+// that a heartbeat comes this late or later, with the intervals modeled as
+// a normal distribution. Phi 1 means a 10% chance the peer is only late,
+// phi 2 a 1% chance, and so on; the suspicion grows continuously with the
+// silence, faster when the heartbeats have been regular.
+func (w *window) phi(now time.Time) float64 {
+	if len(w.intervals) == 0 {
+		return 0
+	}
+	mean, stdDev := w.stats()
+	stdDev = math.Max(stdDev, minStdDev)
+
+	// Logistic approximation of the normal distribution's tail
+	y := (w.silence(now) - mean) / stdDev
+	e := math.Exp(-y * (1.5976 + 0.070566*y*y))
+	later := 1 - 1/(1+e)
+	if y > 0 {
+		later = e / (1 + e)
+	}
+	if later <= 0 {
+		return maxPhi
+	}
+	return math.Min(-math.Log10(later), maxPhi)
+}
@@ -0,0 +1,243 @@
+package phiaccrual
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+const MsgHeartbeat transport.MessageType = "heartbeat"
+
+const heartbeatTicks = 2 // Ticks between two heartbeats of a peer
+
+// Peer sends a heartbeat to the monitor every few ticks, and nothing else
+type Peer struct {
+	mu sync.RWMutex
+
+	id     string
+	status string // "running" or "crashed"
+	ticks  int
+	sent   int
+
+	simulation *Simulation
+}
+
+func newPeer(id string, sim *Simulation) *Peer {
+	return &Peer{
+		id:         id,
+		status:     "running",
+		simulation: sim,
+	}
+}
+
+// Peer implements engine.NodeController
+
+func (p *Peer) ID() string {
+	return p.id
+}
+
+func (p *Peer) Start(ctx context.Context) error {
+	return nil
+}
+
+func (p *Peer) Stop() error {
+	return nil
+}
+
+func (p *Peer) Tick() {
+	p.simulation.advanceSchedule(p.tick())
+}
+
+func (p *Peer) tick() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.ticks++
+	if p.status == "running" && p.ticks%heartbeatTicks == 0 {
+		p.sent++
+		p.simulation.send(p.id, p.simulation.monitor.id, MsgHeartbeat, Payload{Seq: p.sent})
+	}
+	return p.ticks
+}
+
+func (p *Peer) GetState() map[string]interface{} {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return map[string]interface{}{
+		"id":         p.id,
+		"status":     p.status,
+		"role":       "peer",
+		"heartbeats": p.sent,
+	}
+}
+
+// Monitor records when each peer's heartbeats arrive and computes, every
+// tick, how suspicious the silence since the last one is. Alongside, it
+// runs the detector phi replaces: a fixed timeout on the silence.
+type Monitor struct {
+	mu sync.RWMutex
+
+	id     string
+	status string // "running" or "crashed"
+	ticks  int
+
+	windows map[string]*window
+	phi     map[string]float64
+	maxPhi  map[string]float64
+
+	// Peers suspected by each detector
+	phiSuspects     map[string]bool
+	timeoutSuspects map[string]bool
+
+	inbox      chan *transport.Envelope
+	simulation *Simulation
+}
+
+func newMonitor(id string, sim *Simulation) *Monitor {
+	return &Monitor{
+		id:              id,
+		status:          "running",
+		windows:         make(map[string]*window),
+		phi:             make(map[string]float64),
+		maxPhi:          make(map[string]float64),
+		phiSuspects:     make(map[string]bool),
+		timeoutSuspects: make(map[string]bool),
+		inbox:           make(chan *transport.Envelope, 500),
+		simulation:      sim,
+	}
+}
+
+// Monitor implements engine.NodeController
+
+func (m *Monitor) ID() string {
+	return m.id
+}
+
+func (m *Monitor) Start(ctx context.Context) error {
+	return nil
+}
+
+func (m *Monitor) Stop() error {
+	return nil
+}
+
+func (m *Monitor) Tick() {
+	m.simulation.advanceSchedule(m.tick())
+}
+
+func (m *Monitor) tick() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.ticks++
+	if m.status == "crashed" {
+		return m.ticks
+	}
+
+	for i := 0; i < 50; i++ {
+		select {
+		case env := <-m.inbox:
+			m.processMessage(env)
+			continue
+		default:
+		}
+		break
+	}
+
+	sim := m.simulation
+	threshold, timeout := sim.limits()
+	now := sim.engine.GetVirtualTime()
+	sample := make(map[string]float64, len(m.windows))
+	for peer, w := range m.windows {
+		phi := w.phi(now)
+		silence := w.silence(now)
+		m.phi[peer] = phi
+		m.maxPhi[peer] = math.Max(m.maxPhi[peer], phi)
+		sample[peer] = math.Round(phi*100) / 100
+
+		if suspected := phi >= threshold; suspected != m.phiSuspects[peer] {
+			m.phiSuspects[peer] = suspected
+			sim.suspicionChanged("phi", m.id, peer, suspected, phi, silence)
+		}
+		if suspected := silence >= timeout; suspected != m.timeoutSuspects[peer] {
+			m.timeoutSuspects[peer] = suspected
+			sim.suspicionChanged("timeout", m.id, peer, suspected, phi, silence)
+		}
+	}
+	sim.sampled(m.id, sample)
+	return m.ticks
+}
+
+func (m *Monitor) GetState() map[string]interface{} {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := m.simulation.engine.GetVirtualTime()
+	peers := make(map[string]interface{}, len(m.windows))
+	suspected := make([]string, 0)
+	for peer, w := range m.windows {
+		mean, stdDev := w.stats()
+		peers[peer] = map[string]interface{}{
+			"phi":       m.phi[peer],
+			"maxPhi":    m.maxPhi[peer],
+			"silenceMs": w.silence(now),
+			"meanMs":    mean,
+			"stdDevMs":  stdDev,
+			"samples":   len(w.intervals),
+			"timedOut":  m.timeoutSuspects[peer], // What a fixed timeout would say
+		}
+		if m.phiSuspects[peer] {
+			suspected = append(suspected, peer)
+		}
+	}
+	sort.Strings(suspected)
+
+	return map[string]interface{}{
+		"id":        m.id,
+		"status":    m.status,
+		"role":      "monitor",
+		"peers":     peers,
+		"suspected": suspected, // Peers with phi at or over the threshold
+	}
+}
+
+func (m *Monitor) handleMessage(env *transport.Envelope) {
+	m.mu.RLock()
+	down := m.status == "crashed"
+	m.mu.RUnlock()
+
+	if down {
+		return
+	}
+	select {
+	case m.inbox <- env:
+	default:
+	}
+}
+
+func (m *Monitor) processMessage(env *transport.Envelope) {
+	m.simulation.received(env)
+	if env.Type != MsgHeartbeat {
+		return
+	}
+	w, ok := m.windows[env.From]
+	if !ok {
+		w = &window{}
+		m.windows[env.From] = w
+	}
+	// The transport stamps the delivery, so the interval is not rounded
+	// to the tick the monitor drains its inbox
+	w.arrived(env.ReceivedAt)
+}
+
+// currentPhi returns the last phi computed for a peer, for the invariants
+func (m *Monitor) currentPhi(peer string) float64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.phi[peer]
+}
@@ -0,0 +1,426 @@
+package phiaccrual
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+	"github.com/ersantana/distributed-systems-learning/packages/simulation/engine"
+)
+
+const (
+	baseMinLatency  = 20 * time.Millisecond
+	baseMaxLatency  = 80 * time.Millisecond
+	spikeMinLatency = 50 * time.Millisecond
+	spikeMaxLatency = 500 * time.Millisecond
+
+	spikeFrom  = 60  // Tick the "latency_spike" scenario slows peer-2's link
+	spikeUntil = 200 // Tick it restores it
+	crashAt    = 60  // Tick the "crash" scenario crashes peer-3
+)
+
+// Payload is the content of all messages exchanged in this project
+type Payload struct {
+	Seq int `json:"seq"`
+}
+
+// Simulation runs a phi accrual failure detector: a monitor receives the
+// peers' heartbeats and turns the silence since each peer's last one into
+// a continuous suspicion level, phi, scaled by how regular its heartbeats
+// have been. Scenario "latency_spike" makes one peer's link slow and
+// jittery for a while: its phi rises, then settles as the window learns
+// the new spread, where a fixed timeout keeps firing. Scenario "crash"
+// crashes a peer, whose phi grows without bound.
+type Simulation struct {
+	mu sync.RWMutex
+
+	engine    *engine.Engine
+	transport *transport.NetworkTransport
+	broadcast func(interface{})
+
+	monitor  *Monitor
+	peers    []*Peer
+	scenario string
+
+	threshold float64 // Phi at which a peer counts as suspected
+	timeout   float64 // Milliseconds of silence the fixed timeout allows
+
+	crashedAt map[string]time.Time
+	detected  map[string]map[string]bool // Detector -> crashed peers it suspected since
+
+	suspicions map[string]int       // Detector -> suspicions raised
+	mistaken   map[string]int       // Detector -> suspicions of a running peer
+	detections map[string][]float64 // Detector -> milliseconds from a crash to the suspicion
+	messages   int
+	lastTick   int
+
+	running bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// Config for phi accrual simulation
+type Config struct {
+	NodeCount int
+	Scenario  string // "latency_spike", "crash"
+}
+
+// NewSimulation creates a new phi accrual simulation
+func NewSimulation(eng *engine.Engine, trans *transport.NetworkTransport, broadcast func(interface{}), config Config) *Simulation {
+	switch config.Scenario {
+	case "latency_spike", "crash":
+	default:
+		config.Scenario = "latency_spike"
+	}
+	if config.NodeCount == 0 {
+		config.NodeCount = 5
+	}
+	config.NodeCount = max(config.NodeCount, 4)
+
+	sim := &Simulation{
+		engine:     eng,
+		transport:  trans,
+		broadcast:  broadcast,
+		scenario:   config.Scenario,
+		threshold:  8,
+		timeout:    500,
+		crashedAt:  make(map[string]time.Time),
+		detected:   map[string]map[string]bool{"phi": {}, "timeout": {}},
+		suspicions: make(map[string]int),
+		mistaken:   make(map[string]int),
+		detections: make(map[string][]float64),
+	}
+
+	trans.SetLatency(baseMinLatency, baseMaxLatency)
+	trans.SetPacketLoss(0)
+
+	sim.monitor = newMonitor("monitor", sim)
+	trans.RegisterHandler(sim.monitor.id, sim.monitor.handleMessage)
+	eng.AddNode(sim.monitor)
+	for i := 1; i < config.NodeCount; i++ {
+		peer := newPeer(fmt.Sprintf("peer-%d", i), sim)
+		sim.peers = append(sim.peers, peer)
+		eng.AddNode(peer)
+	}
+
+	return sim
+}
+
+// Start starts the simulation
+func (s *Simulation) Start(ctx context.Context) error {
+	s.mu.Lock()
+	s.running = true
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.mu.Unlock()
+
+	return s.engine.Start(ctx)
+}
+
+// Stop stops the simulation
+func (s *Simulation) Stop() error {
+	s.mu.Lock()
+	s.running = false
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Unlock()
+
+	return s.engine.Stop()
+}
+
+// GetState returns the current simulation state
+func (s *Simulation) GetState() *protocol.SimulationStateResponse {
+	nodes := make(map[string]protocol.NodeState)
+	state := s.monitor.GetState()
+	nodes[s.monitor.id] = protocol.NodeState{
+		ID:          s.monitor.id,
+		Status:      state["status"].(string),
+		Role:        "monitor",
+		CustomState: state,
+	}
+	for _, peer := range s.peers {
+		state := peer.GetState()
+		nodes[peer.id] = protocol.NodeState{
+			ID:          peer.id,
+			Status:      state["status"].(string),
+			Role:        "peer",
+			CustomState: state,
+		}
+	}
+
+	s.mu.RLock()
+	running := s.running
+	metadata := map[string]interface{}{
+		"scenario":           s.scenario,
+		"threshold":          s.threshold,
+		"timeoutMs":          s.timeout,
+		"phiSuspicions":      s.suspicions["phi"],
+		"phiMistaken":        s.mistaken["phi"], // Suspicions of a running peer
+		"timeoutSuspicions":  s.suspicions["timeout"],
+		"timeoutMistaken":    s.mistaken["timeout"],
+		"phiDetectionMs":     append([]float64{}, s.detections["phi"]...),
+		"timeoutDetectionMs": append([]float64{}, s.detections["timeout"]...),
+		"messages":           s.messages,
+	}
+	s.mu.RUnlock()
+
+	mode := "step"
+	if s.engine != nil {
+		mode = s.engine.GetMode().String()
+	}
+
+	return &protocol.SimulationStateResponse{
+		Type:        protocol.MsgSimulationState,
+		VirtualTime: time.Now().UnixMilli(),
+		Mode:        mode,
+		Speed:       1.0,
+		Running:     running,
+		Nodes:       nodes,
+		Metadata:    metadata,
+	}
+}
+
+// GetNodes returns node states
+func (s *Simulation) GetNodes() map[string]protocol.NodeState {
+	state := s.GetState()
+	return state.Nodes
+}
+
+// CrashNode crashes a peer, which stops its heartbeats, or the monitor
+func (s *Simulation) CrashNode(nodeID string) error {
+	if nodeID == s.monitor.id {
+		s.monitor.mu.Lock()
+		s.monitor.status = "crashed"
+		s.monitor.mu.Unlock()
+		return nil
+	}
+	peer := s.findPeer(nodeID)
+	if peer == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	peer.mu.Lock()
+	peer.status = "crashed"
+	peer.mu.Unlock()
+
+	s.mu.Lock()
+	s.crashedAt[nodeID] = s.engine.GetVirtualTime()
+	for _, detected := range s.detected {
+		delete(detected, nodeID)
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// RecoverNode recovers a crashed peer, which heartbeats again, or the
+// monitor
+func (s *Simulation) RecoverNode(nodeID string) error {
+	if nodeID == s.monitor.id {
+		s.monitor.mu.Lock()
+		s.monitor.status = "running"
+		s.monitor.mu.Unlock()
+		return nil
+	}
+	peer := s.findPeer(nodeID)
+	if peer == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	peer.mu.Lock()
+	peer.status = "running"
+	peer.mu.Unlock()
+
+	s.mu.Lock()
+	delete(s.crashedAt, nodeID)
+	s.mu.Unlock()
+	return nil
+}
+
+// HandleClientRequest runs a client command: "configure" sets the phi
+// threshold and the fixed timeout, {threshold, timeoutMs}, each optional
+func (s *Simulation) HandleClientRequest(command string, payload map[string]interface{}) error {
+	if command != "configure" {
+		return fmt.Errorf("unknown command: %s", command)
+	}
+
+	s.mu.Lock()
+	threshold, timeout := s.threshold, s.timeout
+	if v, ok := payload["threshold"].(float64); ok {
+		threshold = v
+	}
+	if v, ok := payload["timeoutMs"].(float64); ok {
+		timeout = v
+	}
+	if threshold <= 0 || timeout <= 0 {
+		s.mu.Unlock()
+		return fmt.Errorf("threshold and timeoutMs must be positive, got %v and %v", threshold, timeout)
+	}
+	s.threshold, s.timeout = threshold, timeout
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":      "limits_changed",
+		"threshold": threshold,
+		"timeoutMs": timeout,
+	})
+	return nil
+}
+
+// NodeActions lists the actions of a peer: "spike" makes its link to the
+// monitor slow and jittery, "calm" restores it
+func (s *Simulation) NodeActions(nodeID string) []string {
+	if s.findPeer(nodeID) == nil {
+		return nil
+	}
+	return []string{"spike", "calm"}
+}
+
+// InvokeNodeAction invokes an action listed by NodeActions
+func (s *Simulation) InvokeNodeAction(nodeID, action string, params map[string]interface{}) error {
+	if s.findPeer(nodeID) == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	switch action {
+	case "spike", "calm":
+		s.spike(nodeID, action == "spike")
+		return nil
+	}
+	return fmt.Errorf("unknown action: %s", action)
+}
+
+// findPeer looks up a peer by ID
+func (s *Simulation) findPeer(nodeID string) *Peer {
+	for _, peer := range s.peers {
+		if peer.id == nodeID {
+			return peer
+		}
+	}
+	return nil
+}
+
+// spike sets the latency of a peer's link to the monitor, spiked or back
+// to the base range
+func (s *Simulation) spike(nodeID string, on bool) {
+	minLatency, maxLatency := baseMinLatency, baseMaxLatency
+	if on {
+		minLatency, maxLatency = spikeMinLatency, spikeMaxLatency
+	}
+	s.transport.SetLinkLatency(nodeID, s.monitor.id, minLatency, maxLatency)
+
+	eventType := "latency_spiked"
+	if !on {
+		eventType = "latency_restored"
+	}
+	s.broadcast(map[string]interface{}{
+		"type":         eventType,
+		"nodeId":       nodeID,
+		"minLatencyMs": minLatency.Milliseconds(),
+		"maxLatencyMs": maxLatency.Milliseconds(),
+	})
+}
+
+// limits returns the phi threshold and the fixed timeout
+func (s *Simulation) limits() (float64, float64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.threshold, s.timeout
+}
+
+// advanceSchedule applies the faults of the scenario, once per tick
+func (s *Simulation) advanceSchedule(ticks int) {
+	s.mu.Lock()
+	if ticks <= s.lastTick {
+		s.mu.Unlock()
+		return
+	}
+	s.lastTick = ticks
+	s.mu.Unlock()
+
+	switch {
+	case s.scenario == "latency_spike" && (ticks == spikeFrom || ticks == spikeUntil):
+		s.spike(s.peers[1].id, ticks == spikeFrom)
+	case s.scenario == "crash" && ticks == crashAt:
+		victim := s.peers[2].id
+		s.CrashNode(victim)
+		s.broadcast(map[string]interface{}{
+			"type":   "node_crashed",
+			"nodeId": victim,
+		})
+	}
+}
+
+// sampled streams the phi of every peer, once per tick
+func (s *Simulation) sampled(monitorID string, phi map[string]float64) {
+	s.broadcast(map[string]interface{}{
+		"type":   "phi_sample",
+		"nodeId": monitorID,
+		"phi":    phi,
+	})
+}
+
+// suspicionChanged records a detector starting or ceasing to suspect a
+// peer
+func (s *Simulation) suspicionChanged(detector, monitorID, peer string, suspected bool, phi, silence float64) {
+	s.mu.Lock()
+	crashed, down := s.crashedAt[peer]
+	if suspected {
+		s.suspicions[detector]++
+		if !down {
+			s.mistaken[detector]++
+		} else if !s.detected[detector][peer] {
+			s.detected[detector][peer] = true
+			s.detections[detector] = append(s.detections[detector], float64(s.engine.GetVirtualTime().Sub(crashed).Milliseconds()))
+		}
+	}
+	s.mu.Unlock()
+
+	eventType := "peer_suspected"
+	if !suspected {
+		eventType = "peer_trusted"
+	}
+	s.broadcast(map[string]interface{}{
+		"type":      eventType,
+		"nodeId":    monitorID,
+		"peer":      peer,
+		"detector":  detector, // "phi" or "timeout"
+		"phi":       phi,
+		"silenceMs": silence,
+		"mistaken":  suspected && !down, // The peer is actually running
+	})
+}
+
+// send transmits a message and reports it to the frontend
+func (s *Simulation) send(from, to string, msgType transport.MessageType, payload Payload) {
+	env := transport.NewEnvelope(from, to, msgType, payload)
+
+	s.mu.Lock()
+	s.messages++
+	s.mu.Unlock()
+
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageSent,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+
+	s.transport.Send(s.ctx, env)
+}
+
+// received reports a delivered message and returns its payload
+func (s *Simulation) received(env *transport.Envelope) Payload {
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageReceived,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+	payload, _ := env.Payload.(Payload)
+	return payload
+}
@@ -0,0 +1,69 @@
+package phiaccrual
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+)
+
+// settle is how long a crashed peer may go before phi must cross the
+// threshold: the default threshold with the minimum deviation is under a
+// second of silence
+const settle = 2 * time.Second
+
+// Invariants judges the run on what the phi accrual detector is for:
+// suspecting crashed peers while riding out latency that a fixed timeout
+// mistakes for crashes
+func (s *Simulation) Invariants() []protocol.InvariantResult {
+	s.mu.RLock()
+	threshold := s.threshold
+	crashed := make([]string, 0)
+	for peer, at := range s.crashedAt {
+		if s.engine.GetVirtualTime().Sub(at) >= settle {
+			crashed = append(crashed, peer)
+		}
+	}
+	phiMistaken, timeoutMistaken := s.mistaken["phi"], s.mistaken["timeout"]
+	phiDetections := append([]float64{}, s.detections["phi"]...)
+	timeoutDetections := append([]float64{}, s.detections["timeout"]...)
+	s.mu.RUnlock()
+
+	missed := 0
+	for _, peer := range crashed {
+		if s.monitor.currentPhi(peer) < threshold {
+			missed++
+		}
+	}
+	detection := fmt.Sprintf("%d of %d peers crashed for over %v below phi %.1f", missed, len(crashed), settle, threshold)
+	if len(phiDetections) > 0 {
+		detection += fmt.Sprintf("; phi suspected them after %v ms, the fixed timeout after %v ms", phiDetections, timeoutDetections)
+	}
+
+	return []protocol.InvariantResult{
+		{
+			Name:   "crashed peers cross the phi threshold",
+			Holds:  missed == 0,
+			Detail: detection,
+		},
+		{
+			Name:   "running peers stay below the phi threshold",
+			Holds:  phiMistaken == 0,
+			Detail: fmt.Sprintf("phi suspected running peers %d times, the fixed timeout %d times", phiMistaken, timeoutMistaken),
+		},
+	}
+}
+
+// FollowUps suggests the scenarios that contrast with this one
+func (s *Simulation) FollowUps() []protocol.FollowUp {
+	if s.scenario == "crash" {
+		return []protocol.FollowUp{
+			{Project: "phi-accrual", Scenario: "latency_spike", Reason: "Slow a link down and watch phi rise and settle without a crash"},
+			{Project: "swim", Scenario: "crash", Reason: "Detect crashes across a whole group without a central monitor"},
+		}
+	}
+	return []protocol.FollowUp{
+		{Project: "phi-accrual", Scenario: "crash", Reason: "Crash a peer and compare how fast each detector notices"},
+		{Project: "swim", Scenario: "high_latency", Reason: "See a group of members cope with slow links through suspicion and refutation"},
+	}
+}
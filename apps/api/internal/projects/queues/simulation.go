@@ -0,0 +1,794 @@
+package queues
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/core/storage"
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+	"github.com/ersantana/distributed-systems-learning/packages/simulation/engine"
+)
+
+const (
+	MsgPublish    transport.MessageType = "publish"
+	MsgPublishAck transport.MessageType = "publish_ack"
+	MsgFetch      transport.MessageType = "fetch"
+	MsgDeliver    transport.MessageType = "deliver"
+	MsgEmpty      transport.MessageType = "empty"
+	MsgCommit     transport.MessageType = "commit"
+)
+
+const (
+	ProducerID = "producer"
+	BrokerID   = "broker"
+	ConsumerID = "consumer"
+)
+
+// Record is a message stored in the broker's queue
+type Record struct {
+	Offset int    `json:"offset"`
+	Seq    int    `json:"seq"` // Producer sequence number
+	Body   string `json:"body"`
+}
+
+// Payload is the content of queue protocol messages
+type Payload struct {
+	Seq    int    `json:"seq,omitempty"`
+	Offset int    `json:"offset"`
+	Body   string `json:"body,omitempty"`
+}
+
+// CommitMode defines when the consumer commits its offset
+type CommitMode int
+
+const (
+	// AutoCommit commits on receipt, before processing (at-most-once)
+	AutoCommit CommitMode = iota
+	// ManualCommit commits after processing (at-least-once)
+	ManualCommit
+)
+
+func (c CommitMode) String() string {
+	switch c {
+	case AutoCommit:
+		return "auto"
+	case ManualCommit:
+		return "manual"
+	default:
+		return "unknown"
+	}
+}
+
+// scriptedFault crashes or recovers a node at a given tick
+type scriptedFault struct {
+	tick    int
+	nodeID  string
+	recover bool
+}
+
+// crashPoint crashes the consumer at a stage of handling a message:
+// "processing" (halfway through) or "processed" (done, not yet committed)
+type crashPoint struct {
+	seq   int
+	stage string
+}
+
+// Simulation implements a producer, a broker and a consumer exchanging
+// messages through a queue whose durability and commit strategy vary
+type Simulation struct {
+	mu sync.RWMutex
+
+	engine    *engine.Engine
+	transport *transport.NetworkTransport
+	broadcast func(interface{})
+
+	nodes    []*QueueNode
+	scenario string
+	durable  bool
+	commit   CommitMode
+
+	maxMessages     int
+	publishInterval int
+	processTicks    int
+	retryTicks      int
+
+	script      []scriptedFault
+	nextFault   int
+	crashPoints []crashPoint
+	downTicks   int // How long crash points keep the consumer down
+
+	acked     map[int]bool // Producer seqs acknowledged by the broker
+	processed map[int]int  // Producer seq -> times processed by the consumer
+
+	running bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// QueueNode is the producer, the broker or the consumer
+type QueueNode struct {
+	mu sync.RWMutex
+
+	id     string
+	role   string
+	status string
+	ticks  int
+
+	// Producer: next sequence number and unacknowledged publishes
+	seq     int
+	unacked map[int]int // seq -> tick last sent
+
+	// Broker: queue and committed offsets, kept in the store
+	store storage.Store
+
+	// Consumer: position in the queue, fetch and processing progress
+	position   int // -1 = resume from the committed offset
+	waiting    int // Ticks since the outstanding fetch, 0 = none
+	current    *Record
+	processing int // Ticks of processing left
+
+	inbox      chan *transport.Envelope
+	simulation *Simulation
+}
+
+// Config for Queues simulation
+type Config struct {
+	Scenario    string
+	Durable     bool
+	Commit      CommitMode
+	MaxMessages int
+}
+
+// NewSimulation creates a new Queues simulation
+func NewSimulation(eng *engine.Engine, trans *transport.NetworkTransport, broadcast func(interface{}), config Config) *Simulation {
+	if config.MaxMessages == 0 {
+		config.MaxMessages = 12
+	}
+
+	sim := &Simulation{
+		engine:          eng,
+		transport:       trans,
+		broadcast:       broadcast,
+		scenario:        config.Scenario,
+		durable:         config.Durable,
+		commit:          config.Commit,
+		maxMessages:     config.MaxMessages,
+		publishInterval: 4,
+		processTicks:    6,
+		retryTicks:      15,
+		acked:           make(map[int]bool),
+		processed:       make(map[int]int),
+	}
+
+	// Scenarios combine a queue durability ("in_memory", "durable") and a
+	// commit strategy ("auto_commit", "manual_commit") with a scripted
+	// crash of the broker or of the consumer
+	if strings.Contains(config.Scenario, "in_memory") {
+		sim.durable = false
+	} else if strings.Contains(config.Scenario, "durable") {
+		sim.durable = true
+	}
+	if strings.Contains(config.Scenario, "manual_commit") {
+		sim.commit = ManualCommit
+	} else if strings.Contains(config.Scenario, "auto_commit") {
+		sim.commit = AutoCommit
+	}
+	if strings.HasSuffix(config.Scenario, "broker_crash") {
+		sim.script = []scriptedFault{
+			{tick: 30, nodeID: BrokerID},
+			{tick: 45, nodeID: BrokerID, recover: true},
+		}
+	} else if strings.HasSuffix(config.Scenario, "consumer_crash") {
+		// Auto commit loses the message being processed at the first
+		// crash; manual commit redelivers the message processed just
+		// before the second crash
+		sim.crashPoints = []crashPoint{
+			{seq: 3, stage: "processing"},
+			{seq: 7, stage: "processed"},
+		}
+		sim.downTicks = 10
+	}
+
+	trans.SetLatency(50*time.Millisecond, 150*time.Millisecond)
+	trans.SetPacketLoss(0)
+
+	var store storage.Store = storage.NewVolatileStore()
+	if sim.durable {
+		store = storage.NewDurableStore()
+	}
+
+	sim.nodes = []*QueueNode{
+		sim.newQueueNode(ProducerID, "producer"),
+		sim.newQueueNode(BrokerID, "broker"),
+		sim.newQueueNode(ConsumerID, "consumer"),
+	}
+	sim.nodes[1].store = store
+
+	for _, node := range sim.nodes {
+		trans.RegisterHandler(node.id, node.handleMessage)
+		eng.AddNode(node)
+	}
+
+	return sim
+}
+
+func (s *Simulation) newQueueNode(id, role string) *QueueNode {
+	return &QueueNode{
+		id:         id,
+		role:       role,
+		status:     "running",
+		unacked:    make(map[int]int),
+		position:   -1,
+		inbox:      make(chan *transport.Envelope, 100),
+		simulation: s,
+	}
+}
+
+// Start starts the simulation
+func (s *Simulation) Start(ctx context.Context) error {
+	s.mu.Lock()
+	s.running = true
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.mu.Unlock()
+
+	return s.engine.Start(ctx)
+}
+
+// Stop stops the simulation
+func (s *Simulation) Stop() error {
+	s.mu.Lock()
+	s.running = false
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Unlock()
+
+	return s.engine.Stop()
+}
+
+// GetState returns the current simulation state
+func (s *Simulation) GetState() *protocol.SimulationStateResponse {
+	// Node locks are taken without holding s.mu: nodes lock the
+	// simulation while ticking, so the reverse order would deadlock.
+	nodes := make(map[string]protocol.NodeState)
+	var pending []int
+	inFlight := -1
+	for _, node := range s.nodes {
+		nodeState := node.GetState()
+		switch node.role {
+		case "broker":
+			pending = nodeState["pendingSeqs"].([]int)
+		case "consumer":
+			inFlight = nodeState["processingSeq"].(int)
+		}
+		nodes[node.id] = protocol.NodeState{
+			ID:          node.id,
+			Status:      nodeState["status"].(string),
+			Role:        node.role,
+			CustomState: nodeState,
+		}
+	}
+
+	s.mu.RLock()
+	running := s.running
+	duplicates := make([]int, 0)
+	for seq, count := range s.processed {
+		if count > 1 {
+			duplicates = append(duplicates, seq)
+		}
+	}
+	// Acknowledged messages that were never processed and will not be
+	// delivered again have been lost: dropped by the broker, or skipped
+	// because their offset was committed
+	present := make(map[int]bool, len(pending))
+	for _, seq := range pending {
+		present[seq] = true
+	}
+	lost := make([]int, 0)
+	for seq := range s.acked {
+		if s.processed[seq] == 0 && !present[seq] && seq != inFlight {
+			lost = append(lost, seq)
+		}
+	}
+	acked := len(s.acked)
+	processed := len(s.processed)
+	s.mu.RUnlock()
+
+	sort.Ints(duplicates)
+	sort.Ints(lost)
+
+	mode := "step"
+	if s.engine != nil {
+		mode = s.engine.GetMode().String()
+	}
+
+	return &protocol.SimulationStateResponse{
+		Type:        protocol.MsgSimulationState,
+		VirtualTime: time.Now().UnixMilli(),
+		Mode:        mode,
+		Speed:       1.0,
+		Running:     running,
+		Nodes:       nodes,
+		Metadata: map[string]interface{}{
+			"durable":    s.durable,
+			"commitMode": s.commit.String(),
+			"acked":      acked,
+			"processed":  processed,
+			"duplicates": duplicates,
+			"lost":       lost,
+		},
+	}
+}
+
+// GetNodes returns node states
+func (s *Simulation) GetNodes() map[string]protocol.NodeState {
+	state := s.GetState()
+	return state.Nodes
+}
+
+// Layout draws the pipeline from producer to consumer
+func (s *Simulation) Layout() *protocol.Layout {
+	return &protocol.Layout{
+		Kind:  protocol.LayoutChain,
+		Order: []string{ProducerID, BrokerID, ConsumerID},
+	}
+}
+
+// CrashNode crashes a node
+func (s *Simulation) CrashNode(nodeID string) error {
+	node := s.findNode(nodeID)
+	if node == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	node.mu.Lock()
+	node.crash()
+	node.mu.Unlock()
+	return nil
+}
+
+// RecoverNode recovers a crashed node
+func (s *Simulation) RecoverNode(nodeID string) error {
+	node := s.findNode(nodeID)
+	if node == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	node.mu.Lock()
+	node.recover()
+	node.mu.Unlock()
+	return nil
+}
+
+// findNode looks up a node by ID; the node list is fixed after construction
+func (s *Simulation) findNode(nodeID string) *QueueNode {
+	for _, node := range s.nodes {
+		if node.id == nodeID {
+			return node
+		}
+	}
+	return nil
+}
+
+// advanceSchedule applies scripted faults. It runs after the ticking
+// node has released its lock since it locks the faulted node.
+func (s *Simulation) advanceSchedule(ticks int) {
+	s.mu.Lock()
+	due := make([]scriptedFault, 0)
+	for s.nextFault < len(s.script) && s.script[s.nextFault].tick <= ticks {
+		due = append(due, s.script[s.nextFault])
+		s.nextFault++
+	}
+	s.mu.Unlock()
+
+	for _, fault := range due {
+		var err error
+		if fault.recover {
+			err = s.RecoverNode(fault.nodeID)
+		} else {
+			err = s.CrashNode(fault.nodeID)
+		}
+		if err == nil {
+			s.broadcast(map[string]interface{}{
+				"type":    "scripted_fault",
+				"nodeId":  fault.nodeID,
+				"recover": fault.recover,
+			})
+		}
+	}
+}
+
+// QueueNode implements engine.NodeController
+
+func (n *QueueNode) ID() string {
+	return n.id
+}
+
+func (n *QueueNode) Start(ctx context.Context) error {
+	return nil
+}
+
+func (n *QueueNode) Stop() error {
+	return nil
+}
+
+func (n *QueueNode) Tick() {
+	ticks := n.tick()
+
+	// The producer never crashes in scripts, so its clock drives them
+	if n.role == "producer" {
+		n.simulation.advanceSchedule(ticks)
+	}
+}
+
+func (n *QueueNode) tick() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.ticks++
+	if n.status != "running" {
+		return n.ticks
+	}
+
+	// Process any pending messages
+	select {
+	case env := <-n.inbox:
+		n.processMessage(env)
+	default:
+	}
+
+	switch n.role {
+	case "producer":
+		n.producerTick()
+	case "consumer":
+		n.consumerTick()
+	}
+	return n.ticks
+}
+
+func (n *QueueNode) GetState() map[string]interface{} {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	state := map[string]interface{}{
+		"id":     n.id,
+		"status": n.status,
+	}
+
+	switch n.role {
+	case "producer":
+		unacked := make([]int, 0, len(n.unacked))
+		for seq := range n.unacked {
+			unacked = append(unacked, seq)
+		}
+		sort.Ints(unacked)
+		state["published"] = n.seq
+		state["unacked"] = unacked
+
+	case "broker":
+		records := n.records()
+		committed := n.committed()
+		pendingSeqs := make([]int, 0, len(records))
+		for _, r := range records {
+			if r.Offset >= committed {
+				pendingSeqs = append(pendingSeqs, r.Seq)
+			}
+		}
+		state["queue"] = records
+		state["pendingSeqs"] = pendingSeqs
+		state["committedOffset"] = committed
+		state["storage"] = n.store.Stats()
+
+	case "consumer":
+		processingSeq := -1
+		if n.current != nil {
+			processingSeq = n.current.Seq
+		}
+		state["position"] = n.position
+		state["processingSeq"] = processingSeq
+		state["processingLeft"] = n.processing
+		state["commitMode"] = n.simulation.commit.String()
+	}
+
+	return state
+}
+
+func (n *QueueNode) handleMessage(env *transport.Envelope) {
+	n.mu.RLock()
+	crashed := n.status != "running"
+	n.mu.RUnlock()
+
+	// Crashed processes do not receive anything
+	if crashed {
+		return
+	}
+	select {
+	case n.inbox <- env:
+	default:
+	}
+}
+
+// crash stops the node; what it loses depends on its role (must hold n.mu)
+func (n *QueueNode) crash() {
+	n.status = "crashed"
+	switch n.role {
+	case "broker":
+		n.store.Crash()
+	case "consumer":
+		// In-memory position and the message being processed are gone
+		n.position = -1
+		n.waiting = 0
+		n.current = nil
+		n.processing = 0
+	}
+	for len(n.inbox) > 0 {
+		<-n.inbox
+	}
+}
+
+// recover restarts the node from whatever survived (must hold n.mu)
+func (n *QueueNode) recover() {
+	n.status = "running"
+}
+
+func (n *QueueNode) send(to string, msgType transport.MessageType, payload Payload) {
+	sim := n.simulation
+	env := transport.NewEnvelope(n.id, to, msgType, payload)
+
+	sim.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageSent,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+
+	sim.transport.Send(sim.ctx, env)
+}
+
+func (n *QueueNode) processMessage(env *transport.Envelope) {
+	sim := n.simulation
+
+	sim.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageReceived,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+
+	payload, ok := env.Payload.(Payload)
+	if !ok {
+		return
+	}
+
+	switch env.Type {
+	case MsgPublish:
+		n.brokerPublish(env.From, payload)
+	case MsgFetch:
+		n.brokerFetch(env.From, payload)
+	case MsgCommit:
+		n.brokerCommit(env.From, payload)
+	case MsgPublishAck:
+		n.producerAck(payload)
+	case MsgDeliver:
+		n.consumerDeliver(payload)
+	case MsgEmpty:
+		n.consumerEmpty(payload)
+	}
+}
+
+// Producer
+
+func (n *QueueNode) producerTick() {
+	sim := n.simulation
+
+	if n.seq < sim.maxMessages && n.ticks%sim.publishInterval == 2 {
+		n.seq++
+		n.unacked[n.seq] = n.ticks
+		n.send(BrokerID, MsgPublish, Payload{Seq: n.seq, Body: fmt.Sprintf("order-%d", n.seq)})
+	}
+
+	// Retry publishes that were not acknowledged in time
+	for seq, sentAt := range n.unacked {
+		if n.ticks-sentAt >= sim.retryTicks {
+			n.unacked[seq] = n.ticks
+			n.send(BrokerID, MsgPublish, Payload{Seq: seq, Body: fmt.Sprintf("order-%d", seq)})
+			sim.broadcast(map[string]interface{}{
+				"type":   "publish_retry",
+				"nodeId": n.id,
+				"seq":    seq,
+			})
+		}
+	}
+}
+
+func (n *QueueNode) producerAck(payload Payload) {
+	sim := n.simulation
+	delete(n.unacked, payload.Seq)
+
+	sim.mu.Lock()
+	sim.acked[payload.Seq] = true
+	sim.mu.Unlock()
+}
+
+// Broker
+
+func (n *QueueNode) nextOffset() int {
+	if v, ok := n.store.Get("next"); ok {
+		return v.(int)
+	}
+	return 0
+}
+
+func (n *QueueNode) committed() int {
+	if v, ok := n.store.Get("commit/" + ConsumerID); ok {
+		return v.(int)
+	}
+	return 0
+}
+
+// records returns the stored queue in offset order
+func (n *QueueNode) records() []Record {
+	records := make([]Record, 0)
+	for offset := 0; offset < n.nextOffset(); offset++ {
+		if v, ok := n.store.Get(fmt.Sprintf("msg/%d", offset)); ok {
+			records = append(records, v.(Record))
+		}
+	}
+	return records
+}
+
+func (n *QueueNode) brokerPublish(from string, payload Payload) {
+	offset := n.nextOffset()
+	n.store.Put(fmt.Sprintf("msg/%d", offset), Record{Offset: offset, Seq: payload.Seq, Body: payload.Body})
+	n.store.Put("next", offset+1)
+
+	// Acknowledge once stored; only a durable store makes that a promise
+	n.send(from, MsgPublishAck, Payload{Seq: payload.Seq, Offset: offset})
+}
+
+func (n *QueueNode) brokerFetch(from string, payload Payload) {
+	offset := payload.Offset
+	if offset < 0 {
+		offset = n.committed()
+	}
+
+	// A position past the end of the queue means the broker lost
+	// messages; -1 tells the consumer to reset to the committed offset
+	if offset > n.nextOffset() {
+		n.send(from, MsgEmpty, Payload{Offset: -1})
+		return
+	}
+
+	v, ok := n.store.Get(fmt.Sprintf("msg/%d", offset))
+	if !ok {
+		n.send(from, MsgEmpty, Payload{Offset: offset})
+		return
+	}
+	record := v.(Record)
+	n.send(from, MsgDeliver, Payload{Seq: record.Seq, Offset: record.Offset, Body: record.Body})
+}
+
+func (n *QueueNode) brokerCommit(from string, payload Payload) {
+	if payload.Offset > n.committed() {
+		n.store.Put("commit/"+from, payload.Offset)
+	}
+}
+
+// Consumer
+
+func (n *QueueNode) consumerTick() {
+	sim := n.simulation
+
+	if n.current != nil {
+		n.processing--
+		if n.processing == sim.processTicks/2 && n.crashPoint(n.current.Seq, "processing") {
+			return
+		}
+		if n.processing > 0 {
+			return
+		}
+
+		record := n.current
+		n.current = nil
+		n.position = record.Offset + 1
+
+		sim.mu.Lock()
+		sim.processed[record.Seq]++
+		count := sim.processed[record.Seq]
+		sim.mu.Unlock()
+
+		sim.broadcast(map[string]interface{}{
+			"type":      "message_processed",
+			"nodeId":    n.id,
+			"seq":       record.Seq,
+			"offset":    record.Offset,
+			"duplicate": count > 1,
+		})
+
+		if n.crashPoint(record.Seq, "processed") {
+			return
+		}
+		if sim.commit == ManualCommit {
+			n.send(BrokerID, MsgCommit, Payload{Offset: record.Offset + 1})
+		}
+		return
+	}
+
+	// Poll for the next message, re-polling if the reply was lost
+	if n.waiting > 0 {
+		n.waiting++
+		if n.waiting < 10 {
+			return
+		}
+	}
+	if n.ticks%3 == 0 {
+		n.waiting = 1
+		n.send(BrokerID, MsgFetch, Payload{Offset: n.position})
+	}
+}
+
+// crashPoint crashes the consumer if the scenario scripts a crash at
+// this stage of handling seq, scheduling its recovery (must hold n.mu)
+func (n *QueueNode) crashPoint(seq int, stage string) bool {
+	sim := n.simulation
+
+	sim.mu.Lock()
+	hit := false
+	for i, point := range sim.crashPoints {
+		if point.seq == seq && point.stage == stage {
+			sim.crashPoints = append(sim.crashPoints[:i], sim.crashPoints[i+1:]...)
+			sim.script = append(sim.script, scriptedFault{tick: n.ticks + sim.downTicks, nodeID: n.id, recover: true})
+			hit = true
+			break
+		}
+	}
+	sim.mu.Unlock()
+
+	if !hit {
+		return false
+	}
+	n.crash()
+	sim.broadcast(map[string]interface{}{
+		"type":   "scripted_fault",
+		"nodeId": n.id,
+		"seq":    seq,
+		"stage":  stage,
+	})
+	return true
+}
+
+func (n *QueueNode) consumerEmpty(payload Payload) {
+	n.waiting = 0
+	if payload.Offset < 0 && n.position >= 0 {
+		n.simulation.broadcast(map[string]interface{}{
+			"type":     "offset_reset",
+			"nodeId":   n.id,
+			"position": n.position,
+		})
+		n.position = -1
+	}
+}
+
+func (n *QueueNode) consumerDeliver(payload Payload) {
+	sim := n.simulation
+	n.waiting = 0
+	// Ignore busy periods and stale replies to re-sent fetches
+	if n.current != nil || (n.position >= 0 && payload.Offset != n.position) {
+		return
+	}
+
+	n.current = &Record{Offset: payload.Offset, Seq: payload.Seq, Body: payload.Body}
+	n.processing = sim.processTicks
+	n.position = payload.Offset + 1
+
+	if sim.commit == AutoCommit {
+		n.send(BrokerID, MsgCommit, Payload{Offset: payload.Offset + 1})
+	}
+}
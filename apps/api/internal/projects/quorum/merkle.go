@@ -0,0 +1,70 @@
+package quorum
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"sort"
+)
+
+// merkleLeaves is the number of buckets the keys of a range are hashed
+// into; the tree over them has 2*merkleLeaves-1 nodes
+const merkleLeaves = 8
+
+// leafOf returns the bucket of a key
+func leafOf(key string) int {
+	return int(hash(key)>>8) % merkleLeaves
+}
+
+// buildTree hashes the entries of a range into a Merkle tree, stored as a
+// heap: the root at 0, the children of i at 2i+1 and 2i+2, the leaves
+// last. Replicas holding the same versions build the same tree.
+func buildTree(entries map[string][]Versioned) []string {
+	buckets := make([][]string, merkleLeaves)
+	for key, siblings := range entries {
+		leaf := leafOf(key)
+		buckets[leaf] = append(buckets[leaf], fmt.Sprintf("%s=%v", key, versionsOf(siblings)))
+	}
+
+	tree := make([]string, 2*merkleLeaves-1)
+	for i, bucket := range buckets {
+		sort.Strings(bucket)
+		tree[merkleLeaves-1+i] = digest(bucket...)
+	}
+	for i := merkleLeaves - 2; i >= 0; i-- {
+		tree[i] = digest(tree[2*i+1], tree[2*i+2])
+	}
+	return tree
+}
+
+// digest hashes strings together, shortened for display
+func digest(parts ...string) string {
+	h := sha1.New()
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:8]
+}
+
+// diffTrees walks two trees down from the root, only into the subtrees
+// whose hashes differ, and returns the differing leaves and the number of
+// tree nodes compared on the way
+func diffTrees(a, b []string) (leaves []int, compared int) {
+	leaves = make([]int, 0)
+	queue := []int{0}
+	for len(queue) > 0 {
+		i := queue[0]
+		queue = queue[1:]
+		compared++
+		if a[i] == b[i] {
+			continue
+		}
+		if i >= merkleLeaves-1 {
+			leaves = append(leaves, i-(merkleLeaves-1))
+			continue
+		}
+		queue = append(queue, 2*i+1, 2*i+2)
+	}
+	return leaves, compared
+}
@@ -0,0 +1,768 @@
+package quorum
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+const (
+	MsgWrite      transport.MessageType = "write"
+	MsgWriteAck   transport.MessageType = "write_ack"
+	MsgRead       transport.MessageType = "read"
+	MsgReadReply  transport.MessageType = "read_reply"
+	MsgHandoff    transport.MessageType = "handoff"
+	MsgHandoffAck transport.MessageType = "handoff_ack"
+	MsgRepair     transport.MessageType = "repair"
+	MsgMerkleTree transport.MessageType = "merkle_tree"
+	MsgMerkleDiff transport.MessageType = "merkle_diff"
+	MsgSync       transport.MessageType = "sync"
+)
+
+const (
+	ackTimeout   = 8  // Ticks a coordinator waits for a replica before suspecting it
+	requestTicks = 30 // Ticks a coordinator keeps a request open
+	suspectTicks = 30 // Ticks a suspected replica is skipped by writes
+	handoffTicks = 10 // Ticks between attempts to hand hints over
+	syncTicks    = 25 // Ticks between anti-entropy rounds of a node
+)
+
+// Versioned is a value with the version that wrote it, unique to the
+// write, and in the "siblings" scenario the clock of the values it
+// replaces and the dot that names the write
+type Versioned struct {
+	Value   string `json:"value"`
+	Version int64  `json:"version"`
+	Clock   Clock  `json:"clock,omitempty"`
+	Dot     *Dot   `json:"dot,omitempty"`
+}
+
+// Hint is a write a fallback holds for a replica it could not reach
+type Hint struct {
+	Key      string      `json:"key"`
+	Value    string      `json:"value"`
+	Version  int64       `json:"version"`
+	Clock    Clock       `json:"clock,omitempty"`
+	Dot      *Dot        `json:"dot,omitempty"`
+	Siblings []Versioned `json:"siblings,omitempty"` // Stored values the write kept, with plain version vectors
+	For      string      `json:"for"`
+	StoredAt int         `json:"storedAt"` // Tick of the fallback
+}
+
+func (h Hint) versioned() Versioned {
+	return Versioned{Value: h.Value, Version: h.Version, Clock: h.Clock, Dot: h.Dot}
+}
+
+// values returns the values the hint hands over
+func (h Hint) values() []Versioned {
+	return append(append([]Versioned{}, h.Siblings...), h.versioned())
+}
+
+// request is a read or write a node coordinates
+type request struct {
+	id       string
+	kind     string // "write" or "read"
+	key      string
+	value    string
+	version  int64
+	clock    Clock
+	dot      *Dot
+	siblings []Versioned // Stored values a write keeps, with plain version vectors
+	client   string      // Scripted client waiting for the request, if any
+	started  int
+
+	asked    map[string]int    // Replica or fallback asked, tick asked
+	standIn  map[string]string // Fallback, replica it stands in for
+	timedOut map[string]bool
+	acks     map[string]bool
+	replies  map[string][]Versioned
+
+	committed int64 // Reads: version of the last write completed when the read started
+	done      bool  // Reported to the simulation
+}
+
+// homeAcks counts the acknowledgments from the key's preference list,
+// leaving out the fallbacks standing in for it
+func (r *request) homeAcks() int {
+	home := 0
+	for node := range r.acks {
+		if _, standIn := r.standIn[node]; !standIn {
+			home++
+		}
+	}
+	return home
+}
+
+// Node is a replica of the key-value store. Any node coordinates the
+// reads and writes clients send it: it asks the key's preference list,
+// the first N replicas after the key on the ring, and answers once W
+// acknowledged the write or R replied to the read.
+type Node struct {
+	mu sync.RWMutex
+
+	id        string
+	status    string                 // "running" or "crashed"
+	store     map[string][]Versioned // Siblings of each key, a single value unless writes were concurrent
+	hints     map[string][]Hint      // By the replica they are meant for
+	handedAt  map[string]int         // Tick hints were last sent to each replica
+	suspected map[string]int         // Replica, tick until which writes skip it
+	counters  map[string]uint64      // Counter of the last dot this node issued for each key
+	requests  map[string]*request
+	nextReq   int
+	nextSync  int // Tick of the next anti-entropy round
+	ticks     int
+
+	inbox      chan *transport.Envelope
+	simulation *Simulation
+}
+
+func newNode(id string, sim *Simulation) *Node {
+	return &Node{
+		id:         id,
+		status:     "running",
+		store:      make(map[string][]Versioned),
+		hints:      make(map[string][]Hint),
+		handedAt:   make(map[string]int),
+		suspected:  make(map[string]int),
+		counters:   make(map[string]uint64),
+		requests:   make(map[string]*request),
+		inbox:      make(chan *transport.Envelope, 500),
+		simulation: sim,
+	}
+}
+
+// Node implements engine.NodeController
+
+func (n *Node) ID() string {
+	return n.id
+}
+
+func (n *Node) Start(ctx context.Context) error {
+	return nil
+}
+
+func (n *Node) Stop() error {
+	return nil
+}
+
+func (n *Node) Tick() {
+	n.simulation.advanceSchedule(n.tick())
+}
+
+func (n *Node) tick() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.ticks++
+	if n.status == "crashed" {
+		return n.ticks
+	}
+
+	for i := 0; i < 50; i++ {
+		select {
+		case env := <-n.inbox:
+			n.processMessage(env)
+			continue
+		default:
+		}
+		break
+	}
+
+	n.checkRequests()
+	n.handOff()
+	if n.simulation.antiEntropy && n.ticks >= n.nextSync {
+		n.nextSync = n.ticks + syncTicks
+		n.startSync()
+	}
+	return n.ticks
+}
+
+func (n *Node) GetState() map[string]interface{} {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	versions := make(map[string]int64, len(n.store))
+	siblings := make(map[string][]Versioned)
+	for key, values := range n.store {
+		versions[key] = latest(values).Version
+		if len(values) > 1 {
+			siblings[key] = append([]Versioned{}, values...)
+		}
+	}
+	hints := make(map[string]int, len(n.hints))
+	queue := make([]Hint, 0)
+	for replica, held := range n.hints {
+		hints[replica] = len(held)
+		queue = append(queue, held...)
+	}
+	suspected := make([]string, 0, len(n.suspected))
+	for replica, until := range n.suspected {
+		if until > n.ticks {
+			suspected = append(suspected, replica)
+		}
+	}
+
+	return map[string]interface{}{
+		"id":        n.id,
+		"status":    n.status,
+		"keys":      len(n.store),
+		"versions":  versions,
+		"siblings":  siblings,
+		"hints":     hints,
+		"hintQueue": queue,
+		"suspected": suspected,
+		"requests":  len(n.requests),
+	}
+}
+
+func (n *Node) handleMessage(env *transport.Envelope) {
+	n.mu.RLock()
+	down := n.status == "crashed"
+	n.mu.RUnlock()
+
+	if down {
+		return
+	}
+	select {
+	case n.inbox <- env:
+	default:
+	}
+}
+
+func (n *Node) processMessage(env *transport.Envelope) {
+	payload := n.simulation.received(env)
+	// Hearing from a replica clears the suspicion
+	delete(n.suspected, env.From)
+
+	switch env.Type {
+	case MsgWrite:
+		n.handleWrite(env.From, payload)
+	case MsgWriteAck:
+		n.handleWriteAck(env.From, payload)
+	case MsgRead:
+		siblings := n.store[payload.Key]
+		if payload.HintFor != "" && payload.HintFor != n.id {
+			siblings = n.hinted(payload.HintFor, payload.Key)
+		}
+		n.simulation.send(n.id, env.From, MsgReadReply, Payload{
+			RequestID: payload.RequestID,
+			Key:       payload.Key,
+			Siblings:  siblings,
+		})
+	case MsgReadReply:
+		n.handleReadReply(env.From, payload)
+	case MsgHandoff:
+		for _, hint := range payload.Hints {
+			for _, v := range hint.values() {
+				n.apply(hint.Key, v)
+			}
+		}
+		n.simulation.send(n.id, env.From, MsgHandoffAck, Payload{Hints: payload.Hints})
+	case MsgHandoffAck:
+		n.handleHandoffAck(env.From, payload)
+	case MsgRepair:
+		n.applyRepairs(map[string][]Versioned{payload.Key: payload.Siblings}, "read_repair")
+	case MsgMerkleTree:
+		n.handleMerkleTree(env.From, payload)
+	case MsgMerkleDiff:
+		n.handleMerkleDiff(env.From, payload)
+	case MsgSync:
+		n.applyRepairs(payload.Entries, "anti_entropy")
+	}
+}
+
+// apply reconciles a value with the key's siblings, reports a conflict
+// when concurrent values first meet, and returns whether the siblings
+// changed (must hold n.mu)
+func (n *Node) apply(key string, v Versioned) bool {
+	before := n.store[key]
+	siblings, changed := reconcile(before, v, n.simulation.causality)
+	if !changed {
+		return false
+	}
+	n.store[key] = siblings
+	if len(siblings) > 1 && len(before) <= 1 {
+		n.simulation.conflictDetected(n.id, key, siblings)
+	}
+	return true
+}
+
+// hinted returns the siblings of a key among the hints held for a
+// replica (must hold n.mu)
+func (n *Node) hinted(replica, key string) []Versioned {
+	siblings := make([]Versioned, 0)
+	for _, hint := range n.hints[replica] {
+		if hint.Key == key {
+			for _, v := range hint.values() {
+				siblings, _ = reconcile(siblings, v, n.simulation.causality)
+			}
+		}
+	}
+	return siblings
+}
+
+// handleWrite stores a write, or holds it as a hint when this node only
+// stands in for an unreachable replica (must hold n.mu)
+func (n *Node) handleWrite(from string, payload Payload) {
+	if payload.HintFor != "" && payload.HintFor != n.id {
+		n.hints[payload.HintFor] = append(n.hints[payload.HintFor], Hint{
+			Key:      payload.Key,
+			Value:    payload.Value,
+			Version:  payload.Version,
+			Clock:    payload.Clock,
+			Dot:      payload.Dot,
+			Siblings: payload.Siblings,
+			For:      payload.HintFor,
+			StoredAt: n.ticks,
+		})
+		n.simulation.hintStored(n.id, payload.HintFor, payload.Key, payload.Version)
+	} else {
+		for _, sibling := range payload.Siblings {
+			n.apply(payload.Key, sibling)
+		}
+		n.apply(payload.Key, Versioned{Value: payload.Value, Version: payload.Version, Clock: payload.Clock, Dot: payload.Dot})
+	}
+	n.simulation.send(n.id, from, MsgWriteAck, Payload{RequestID: payload.RequestID, Key: payload.Key, Version: payload.Version})
+}
+
+// startWrite coordinates a blind write: it replaces nothing it has not
+// seen, so with clocks it adds a sibling to those stored
+func (n *Node) startWrite(key, value string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.status != "running" {
+		return fmt.Errorf("node %s is down", n.id)
+	}
+	n.write(key, value, Clock{}, "")
+	return nil
+}
+
+// write coordinates a write that replaces the values whose merged clock
+// is seen (must hold n.mu)
+func (n *Node) write(key, value string, seen Clock, client string) {
+	req := n.newRequest("write", key)
+	req.value = value
+	req.version = n.simulation.nextVersion()
+	req.client = client
+	if n.simulation.clocks {
+		n.counters[key] = max(seen[n.id], n.counters[key]) + 1
+		req.clock = seen
+		req.dot = &Dot{Node: n.id, Counter: n.counters[key]}
+	}
+	if n.simulation.causality == versionVectors {
+		n.putVV(req, seen)
+	}
+	n.askReplicas(req)
+}
+
+// putVV turns a write into the object a server keeping plain version
+// vectors stores: the write's vector merges the one stored and the one
+// the client read, counting the write itself. A single vector cannot say
+// which of the stored siblings the client read, so unless it read them
+// all, they are all kept, those it had replaced too. (must hold n.mu)
+func (n *Node) putVV(req *request, seen Clock) {
+	stored := Clock{}
+	for _, v := range n.store[req.key] {
+		stored = stored.merge(v.Clock)
+	}
+	req.clock = stored.merge(seen)
+	req.clock[n.id] = req.dot.Counter
+
+	falseSiblings := 0
+	if !seen.descends(stored) {
+		for _, v := range n.store[req.key] {
+			if seen.covers(v.Dot) {
+				falseSiblings++
+			}
+			v.Clock = req.clock
+			req.siblings = append(req.siblings, v)
+		}
+	}
+	// The server stores the object, so its vector keeps counting only
+	// writes it stored
+	n.store[req.key] = append(append([]Versioned{}, req.siblings...), Versioned{Value: req.value, Version: req.version, Clock: req.clock, Dot: req.dot})
+	if falseSiblings > 0 {
+		n.simulation.falseConflict(n.id, req.key, falseSiblings, len(req.siblings))
+	}
+}
+
+// startRead coordinates a read
+func (n *Node) startRead(key string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.status != "running" {
+		return fmt.Errorf("node %s is down", n.id)
+	}
+	n.read(key, "")
+	return nil
+}
+
+// read coordinates a read, for a scripted client if client is set (must
+// hold n.mu)
+func (n *Node) read(key, client string) {
+	req := n.newRequest("read", key)
+	req.committed = n.simulation.committedVersion(key)
+	req.client = client
+	n.askReplicas(req)
+}
+
+// askReplicas sends a request to the key's preference list. With a
+// sloppy quorum, replicas suspected to be down are replaced by fallbacks
+// right away. (must hold n.mu)
+func (n *Node) askReplicas(req *request) {
+	for _, replica := range n.simulation.preferenceList(req.key) {
+		if n.simulation.sloppy && n.suspected[replica] > n.ticks {
+			n.askFallback(req, replica)
+			continue
+		}
+		n.ask(req, replica, "")
+	}
+}
+
+// newRequest opens a request (must hold n.mu)
+func (n *Node) newRequest(kind, key string) *request {
+	n.nextReq++
+	req := &request{
+		id:       fmt.Sprintf("%s-%d", n.id, n.nextReq),
+		kind:     kind,
+		key:      key,
+		started:  n.ticks,
+		asked:    make(map[string]int),
+		standIn:  make(map[string]string),
+		timedOut: make(map[string]bool),
+		acks:     make(map[string]bool),
+		replies:  make(map[string][]Versioned),
+	}
+	n.requests[req.id] = req
+	return req
+}
+
+// ask sends a request to a replica, or to a fallback standing in for
+// replica hintFor (must hold n.mu)
+func (n *Node) ask(req *request, to, hintFor string) {
+	req.asked[to] = n.ticks
+	if hintFor != "" {
+		req.standIn[to] = hintFor
+	}
+	if req.kind == "read" {
+		n.simulation.send(n.id, to, MsgRead, Payload{RequestID: req.id, Key: req.key, HintFor: hintFor})
+		return
+	}
+	n.simulation.send(n.id, to, MsgWrite, Payload{
+		RequestID: req.id,
+		Key:       req.key,
+		Value:     req.value,
+		Version:   req.version,
+		Clock:     req.clock,
+		Dot:       req.dot,
+		Siblings:  req.siblings,
+		HintFor:   hintFor,
+	})
+}
+
+// askFallback sends a request meant for replica to the next node on the
+// ring that is neither in the preference list, asked already nor
+// suspected (must hold n.mu)
+func (n *Node) askFallback(req *request, replica string) {
+	for _, candidate := range n.simulation.fallbacks(req.key) {
+		if _, asked := req.asked[candidate]; asked || n.suspected[candidate] > n.ticks {
+			continue
+		}
+		n.ask(req, candidate, replica)
+		return
+	}
+}
+
+// handleWriteAck counts an acknowledgment; W of them complete the write
+// (must hold n.mu)
+func (n *Node) handleWriteAck(from string, payload Payload) {
+	req := n.requests[payload.RequestID]
+	if req == nil {
+		return
+	}
+	req.acks[from] = true
+	if !req.done && len(req.acks) >= n.simulation.w {
+		req.done = true
+		n.simulation.writeDone(n.id, req.key, req.value, req.version, len(req.acks), len(req.standIn), req.homeAcks(), true)
+	}
+	if n.covered(req) {
+		delete(n.requests, req.id)
+	}
+}
+
+// covered reports whether every replica of the preference list has the
+// write, itself or through a fallback (must hold n.mu)
+func (n *Node) covered(req *request) bool {
+	has := make(map[string]bool)
+	for node := range req.acks {
+		if replica, ok := req.standIn[node]; ok {
+			has[replica] = true
+		} else {
+			has[node] = true
+		}
+	}
+	for _, replica := range n.simulation.preferenceList(req.key) {
+		if !has[replica] {
+			return false
+		}
+	}
+	return true
+}
+
+// handleReadReply collects a reply; R of them complete the read with the
+// newest value among them, or with clocks every concurrent value (must
+// hold n.mu)
+func (n *Node) handleReadReply(from string, payload Payload) {
+	req := n.requests[payload.RequestID]
+	if req == nil {
+		return
+	}
+	req.replies[from] = payload.Siblings
+	if !req.done && len(req.replies) >= n.simulation.r {
+		req.done = true
+		siblings := n.merged(req)
+		n.simulation.readDone(n.id, req.key, latest(siblings).Version, req.committed, true)
+		if req.client != "" {
+			n.resolve(req, siblings)
+		}
+	}
+	if len(req.replies) == len(req.asked) {
+		n.repairRead(req)
+		delete(n.requests, req.id)
+	}
+}
+
+// merged reconciles the replies to a read (must hold n.mu)
+func (n *Node) merged(req *request) []Versioned {
+	sets := make([][]Versioned, 0, len(req.replies))
+	for _, siblings := range req.replies {
+		sets = append(sets, siblings)
+	}
+	return reconcileAll(sets, n.simulation.causality)
+}
+
+// resolve continues a scripted client's read-modify-write: it merges the
+// siblings the read returned, adds an item and writes the result with
+// their merged clock, which replaces them all (must hold n.mu)
+func (n *Node) resolve(req *request, siblings []Versioned) {
+	value := union(siblings)
+	if len(siblings) > 1 {
+		n.simulation.conflictResolved(req.client, n.id, req.key, siblings, value)
+	}
+	item := n.simulation.nextItem(req.client)
+	if value != "" {
+		item = value + "," + item
+	}
+	n.write(req.key, item, contextOf(siblings), req.client)
+}
+
+// repairRead sends what a read saw, reconciled, to the replicas that
+// replied with something else (must hold n.mu)
+func (n *Node) repairRead(req *request) {
+	if !n.simulation.readRepair || len(req.replies) < 2 {
+		return
+	}
+	siblings := n.merged(req)
+	for replica, reply := range req.replies {
+		if _, standIn := req.standIn[replica]; standIn || sameSiblings(reply, siblings) {
+			continue
+		}
+		n.simulation.send(n.id, replica, MsgRepair, Payload{Key: req.key, Siblings: siblings})
+	}
+}
+
+// checkRequests suspects the replicas that did not answer in time, hands
+// their writes to fallbacks under a sloppy quorum, and gives up on
+// requests that stayed open too long (must hold n.mu)
+func (n *Node) checkRequests() {
+	for id, req := range n.requests {
+		for node, asked := range req.asked {
+			if req.timedOut[node] || req.acks[node] || n.ticks-asked < ackTimeout {
+				continue
+			}
+			if _, replied := req.replies[node]; replied {
+				continue
+			}
+			req.timedOut[node] = true
+			n.suspected[node] = n.ticks + suspectTicks
+			if n.simulation.sloppy {
+				replica := node
+				if meant, ok := req.standIn[node]; ok {
+					replica = meant
+				}
+				n.askFallback(req, replica)
+			}
+		}
+
+		if n.ticks-req.started < requestTicks {
+			continue
+		}
+		if req.kind == "read" {
+			n.repairRead(req)
+		}
+		if !req.done {
+			if req.kind == "write" {
+				n.simulation.writeDone(n.id, req.key, req.value, req.version, len(req.acks), len(req.standIn), req.homeAcks(), false)
+			} else {
+				n.simulation.readDone(n.id, req.key, 0, req.committed, false)
+			}
+		}
+		delete(n.requests, id)
+	}
+}
+
+// handOff sends the hints held for each replica to it every handoffTicks
+// ticks, until it acknowledges them (must hold n.mu)
+func (n *Node) handOff() {
+	for replica, held := range n.hints {
+		if len(held) == 0 || n.ticks-n.handedAt[replica] < handoffTicks {
+			continue
+		}
+		n.handedAt[replica] = n.ticks
+		n.simulation.send(n.id, replica, MsgHandoff, Payload{Hints: append([]Hint{}, held...)})
+	}
+}
+
+// handleHandoffAck drops the hints a replica acknowledged (must hold
+// n.mu)
+func (n *Node) handleHandoffAck(from string, payload Payload) {
+	delivered := make(map[string]int64, len(payload.Hints))
+	for _, hint := range payload.Hints {
+		delivered[hint.Key] = max(delivered[hint.Key], hint.Version)
+	}
+
+	kept := make([]Hint, 0)
+	keys := make([]string, 0)
+	longest := 0
+	for _, hint := range n.hints[from] {
+		if hint.Version <= delivered[hint.Key] {
+			keys = append(keys, hint.Key)
+			longest = max(longest, n.ticks-hint.StoredAt)
+			continue
+		}
+		kept = append(kept, hint)
+	}
+	if len(kept) == 0 {
+		delete(n.hints, from)
+	} else {
+		n.hints[from] = kept
+	}
+	if len(keys) > 0 {
+		n.simulation.hintDelivered(n.id, from, keys, longest)
+	}
+}
+
+// crash loses the requests in progress; the store and the hints are on
+// disk (must hold n.mu)
+func (n *Node) crash() {
+	n.status = "crashed"
+	n.requests = make(map[string]*request)
+	n.suspected = make(map[string]int)
+	for {
+		select {
+		case <-n.inbox:
+			continue
+		default:
+		}
+		break
+	}
+}
+
+// startSync starts an anti-entropy round: it sends the Merkle tree of one
+// of the ranges this node replicates to another replica of the range
+// (must hold n.mu)
+func (n *Node) startSync() {
+	ranges := n.simulation.rangesOf(n.id)
+	if len(ranges) == 0 {
+		return
+	}
+	primary := ranges[n.simulation.rng.Intn(len(ranges))]
+	peers := make([]string, 0, n.simulation.n-1)
+	for _, replica := range n.simulation.replicasOfRange(primary) {
+		if replica != n.id {
+			peers = append(peers, replica)
+		}
+	}
+	if len(peers) == 0 {
+		return
+	}
+	peer := peers[n.simulation.rng.Intn(len(peers))]
+	n.simulation.send(n.id, peer, MsgMerkleTree, Payload{Range: primary, Tree: buildTree(n.entries(primary, nil))})
+}
+
+// handleMerkleTree compares a peer's tree with this node's and answers
+// with its entries in the leaves that differ (must hold n.mu)
+func (n *Node) handleMerkleTree(from string, payload Payload) {
+	mine := buildTree(n.entries(payload.Range, nil))
+	leaves, compared := diffTrees(payload.Tree, mine)
+	n.simulation.compared(n.id, from, payload.Range, leaves, compared, payload.Tree, mine)
+	if len(leaves) == 0 {
+		return
+	}
+	n.simulation.send(n.id, from, MsgMerkleDiff, Payload{
+		Range:   payload.Range,
+		Leaves:  leaves,
+		Entries: n.entries(payload.Range, leaves),
+	})
+}
+
+// handleMerkleDiff reconciles a peer's entries in the differing leaves
+// with this node's and sends back those that still differ (must hold
+// n.mu)
+func (n *Node) handleMerkleDiff(from string, payload Payload) {
+	n.applyRepairs(payload.Entries, "anti_entropy")
+
+	differ := make(map[string][]Versioned)
+	for key, siblings := range n.entries(payload.Range, payload.Leaves) {
+		if !sameSiblings(siblings, payload.Entries[key]) {
+			differ[key] = siblings
+		}
+	}
+	if len(differ) > 0 {
+		n.simulation.send(n.id, from, MsgSync, Payload{Range: payload.Range, Entries: differ})
+	}
+}
+
+// entries returns the stored entries of a range, only those in the given
+// leaves unless leaves is nil (must hold n.mu)
+func (n *Node) entries(primary string, leaves []int) map[string][]Versioned {
+	wanted := make(map[int]bool, len(leaves))
+	for _, leaf := range leaves {
+		wanted[leaf] = true
+	}
+	entries := make(map[string][]Versioned)
+	for key, siblings := range n.store {
+		if n.simulation.rangeOf(key) != primary {
+			continue
+		}
+		if leaves != nil && !wanted[leafOf(key)] {
+			continue
+		}
+		entries[key] = append([]Versioned{}, siblings...)
+	}
+	return entries
+}
+
+// applyRepairs reconciles entries with this node's and reports the keys
+// repaired (must hold n.mu)
+func (n *Node) applyRepairs(entries map[string][]Versioned, source string) {
+	repaired := make([]string, 0)
+	for key, siblings := range entries {
+		changed := false
+		for _, v := range siblings {
+			changed = n.apply(key, v) || changed
+		}
+		if changed {
+			repaired = append(repaired, key)
+		}
+	}
+	if len(repaired) > 0 {
+		sort.Strings(repaired)
+		n.simulation.repaired(n.id, repaired, source)
+	}
+}
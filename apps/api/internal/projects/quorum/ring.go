@@ -0,0 +1,53 @@
+package quorum
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"sort"
+)
+
+// hash places a key or a replica on the ring
+func hash(name string) uint32 {
+	sum := sha1.Sum([]byte(name))
+	return binary.BigEndian.Uint32(sum[:4])
+}
+
+// ring places every replica at one position; a key is stored on the
+// first replicas after it
+type ring struct {
+	replicas []string // Sorted by position
+}
+
+func newRing(replicas []string) *ring {
+	sorted := append([]string{}, replicas...)
+	sort.Slice(sorted, func(i, j int) bool { return hash(sorted[i]) < hash(sorted[j]) })
+	return &ring{replicas: sorted}
+}
+
+// walk returns every replica in ring order from the key on. The first n
+// are the key's preference list, the others its fallbacks in the order
+// they are tried.
+func (r *ring) walk(key string) []string {
+	h := hash(key)
+	start := sort.Search(len(r.replicas), func(i int) bool { return hash(r.replicas[i]) >= h })
+	order := make([]string, 0, len(r.replicas))
+	for i := range r.replicas {
+		order = append(order, r.replicas[(start+i)%len(r.replicas)])
+	}
+	return order
+}
+
+// from returns every replica in ring order from the given one on
+func (r *ring) from(replica string) []string {
+	start := 0
+	for i, id := range r.replicas {
+		if id == replica {
+			start = i
+		}
+	}
+	order := make([]string, 0, len(r.replicas))
+	for i := range r.replicas {
+		order = append(order, r.replicas[(start+i)%len(r.replicas)])
+	}
+	return order
+}
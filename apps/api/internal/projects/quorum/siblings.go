@@ -0,0 +1,212 @@
+package quorum
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/ersantana/distributed-systems-learning/packages/core/clock"
+)
+
+// Clock is a vector clock: for a key, the writes of each coordinator
+// seen, up to a counter. A write carries the clock of the values the
+// client read, and replaces exactly those.
+type Clock map[string]uint64
+
+// Dot names a write: the coordinator and its counter for the key. A
+// coordinator may issue a dot beyond a clock that missed its last write,
+// so that write is judged by its own dot rather than by the new clock,
+// which would otherwise appear to descend it.
+type Dot = clock.Dot
+
+// causality is how replicas order the values of a key
+type causality int
+
+const (
+	highestVersion causality = iota // The highest version wins
+	dottedClocks                    // A dotted version vector per value
+	versionVectors                  // A plain version vector per write, counting what its server stored
+)
+
+func (c causality) String() string {
+	switch c {
+	case dottedClocks:
+		return "dotted_version_vectors"
+	case versionVectors:
+		return "version_vectors"
+	}
+	return "highest_version"
+}
+
+// covers reports whether c has seen the write named by d
+func (c Clock) covers(d *Dot) bool {
+	return d != nil && c[d.Node] >= d.Counter
+}
+
+// descends reports whether c has seen every write o has
+func (c Clock) descends(o Clock) bool {
+	for id, count := range o {
+		if c[id] < count {
+			return false
+		}
+	}
+	return true
+}
+
+// merge returns a clock that descends both c and o
+func (c Clock) merge(o Clock) Clock {
+	merged := make(Clock, len(c)+len(o))
+	for id, count := range c {
+		merged[id] = count
+	}
+	for id, count := range o {
+		merged[id] = max(merged[id], count)
+	}
+	return merged
+}
+
+// dvv returns the dotted version vector of a value: its dot and the
+// clock it was written with
+func (v Versioned) dvv() clock.DottedVersionVector {
+	dvv := clock.DottedVersionVector{Context: v.Clock}
+	if v.Dot != nil {
+		dvv.Dot = *v.Dot
+	}
+	return dvv
+}
+
+// reconcile adds a value to the siblings of a key and reports whether
+// they changed. With dotted version vectors, the siblings whose write the
+// value has seen are replaced and the value is dropped if it is known
+// already or a sibling has seen it, so concurrent values are all kept;
+// with the highest version winning, the value replaces an older one.
+func reconcile(siblings []Versioned, v Versioned, mode causality) ([]Versioned, bool) {
+	switch mode {
+	case highestVersion:
+		if v.Version > latest(siblings).Version {
+			return []Versioned{v}, true
+		}
+		return siblings, false
+	case versionVectors:
+		return reconcileVV(siblings, v)
+	}
+
+	kept := make([]Versioned, 0, len(siblings)+1)
+	for _, sibling := range siblings {
+		switch clock.CompareDottedVersionVectors(sibling.dvv(), v.dvv()) {
+		case clock.Equal, clock.HappensAfter:
+			return siblings, false
+		case clock.Concurrent:
+			kept = append(kept, sibling)
+		}
+	}
+	return append(kept, v), true
+}
+
+// reconcileVV reconciles with plain version vectors. Every sibling
+// carries the vector of the last write that kept it, which counts every
+// write its server had stored, so a write's vector descends all siblings
+// it kept; a value is replaced by one whose vector descends its own, and
+// values with equal or concurrent vectors are all kept. A stored copy of
+// the value itself takes the merged vector.
+func reconcileVV(siblings []Versioned, v Versioned) ([]Versioned, bool) {
+	kept := make([]Versioned, 0, len(siblings)+1)
+	for _, sibling := range siblings {
+		newer, older := v.Clock.descends(sibling.Clock), sibling.Clock.descends(v.Clock)
+		switch {
+		case sibling.Version == v.Version && older:
+			return siblings, false
+		case sibling.Version == v.Version:
+			v.Clock = v.Clock.merge(sibling.Clock)
+		case older && !newer:
+			return siblings, false
+		case newer && !older:
+		default:
+			kept = append(kept, sibling)
+		}
+	}
+	return append(kept, v), true
+}
+
+// reconcileAll reconciles sets of siblings into one
+func reconcileAll(sets [][]Versioned, mode causality) []Versioned {
+	merged := make([]Versioned, 0)
+	for _, siblings := range sets {
+		for _, v := range siblings {
+			merged, _ = reconcile(merged, v, mode)
+		}
+	}
+	return merged
+}
+
+// latest returns the sibling with the highest version
+func latest(siblings []Versioned) Versioned {
+	var best Versioned
+	for _, v := range siblings {
+		if v.Version > best.Version {
+			best = v
+		}
+	}
+	return best
+}
+
+// contextOf merges the clocks and dots of siblings: a write with it
+// replaces them
+func contextOf(siblings []Versioned) Clock {
+	context := Clock{}
+	for _, v := range siblings {
+		context = context.merge(v.Clock)
+		if v.Dot != nil {
+			context[v.Dot.Node] = max(context[v.Dot.Node], v.Dot.Counter)
+		}
+	}
+	return context
+}
+
+// versionsOf returns the sorted versions of siblings, to compare sets
+func versionsOf(siblings []Versioned) []int64 {
+	versions := make([]int64, 0, len(siblings))
+	for _, v := range siblings {
+		versions = append(versions, v.Version)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+	return versions
+}
+
+// sameSiblings reports whether two sets hold the same values
+func sameSiblings(a, b []Versioned) bool {
+	va, vb := versionsOf(a), versionsOf(b)
+	if len(va) != len(vb) {
+		return false
+	}
+	for i := range va {
+		if va[i] != vb[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// items splits a value into the items of a shopping cart
+func items(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+// union resolves siblings the way a shopping cart does: the resolved
+// value holds every item of every sibling
+func union(siblings []Versioned) string {
+	seen := make(map[string]bool)
+	all := make([]string, 0)
+	for _, v := range siblings {
+		for _, item := range items(v.Value) {
+			if !seen[item] {
+				seen[item] = true
+				all = append(all, item)
+			}
+		}
+	}
+	sort.Strings(all)
+	return strings.Join(all, ",")
+}
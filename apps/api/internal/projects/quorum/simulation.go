@@ -0,0 +1,962 @@
+package quorum
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+	"github.com/ersantana/distributed-systems-learning/packages/simulation/engine"
+)
+
+const (
+	replicationFactor = 3  // N: replicas of every key
+	writeQuorum       = 2  // W
+	readQuorum        = 2  // R
+	keyCount          = 10 // Keys the clients read and write
+	faultCycle        = 240
+	crashAt           = 40 // Ticks into every fault cycle
+	isolateAt         = 80
+	recoverAt         = 140
+	healAt            = 180
+	splitAt           = 40 // Ticks into every fault cycle of "siblings" and the partition scenarios
+	rejoinAt          = 140
+	clientEvery       = 6 // Ticks between the read-modify-writes of a client
+	cartKeys          = 2
+	keptComparisons   = 10
+)
+
+// Payload is the content of all messages exchanged in this project
+type Payload struct {
+	RequestID string      `json:"requestId,omitempty"`
+	Key       string      `json:"key,omitempty"`
+	Value     string      `json:"value,omitempty"`
+	Version   int64       `json:"version,omitempty"`
+	Clock     Clock       `json:"clock,omitempty"`
+	Dot       *Dot        `json:"dot,omitempty"`
+	Siblings  []Versioned `json:"siblings,omitempty"` // Read replies, repairs and the stored values a write keeps
+	HintFor   string      `json:"hintFor,omitempty"`  // Replica a fallback stands in for
+	Hints     []Hint      `json:"hints,omitempty"`
+
+	// Anti-entropy
+	Range   string                 `json:"range,omitempty"` // Primary replica of the keys compared
+	Tree    []string               `json:"tree,omitempty"`
+	Leaves  []int                  `json:"leaves,omitempty"`
+	Entries map[string][]Versioned `json:"entries,omitempty"`
+}
+
+// Comparison describes one comparison of two replicas' Merkle trees
+type Comparison struct {
+	Node     string   `json:"node"`
+	Peer     string   `json:"peer"`
+	Range    string   `json:"range"`
+	NodeTree []string `json:"nodeTree"`
+	PeerTree []string `json:"peerTree"`
+	Leaves   []int    `json:"leaves"`   // Leaves that differ
+	Compared int      `json:"compared"` // Tree nodes compared to find them
+	Tick     int      `json:"tick"`
+}
+
+// Simulation is a Dynamo-style replicated key-value store. Every key is
+// stored on N replicas; a write succeeds once W acknowledged it and a
+// read returns the newest of R replies, so with W + R > N every read
+// overlaps the last successful write.
+//
+// The "strict" scenario keeps to the preference list: with two of a
+// key's three replicas down its writes fail. The "hinted_handoff"
+// scenario uses a sloppy quorum instead: a write a replica does not
+// acknowledge goes to the next node on the ring, which holds it as a
+// hint and hands it over once the replica is back. Writes stay
+// available, but until the hints are delivered the replicas disagree and
+// a read of the preference list can miss the write.
+//
+// Nothing above repairs a replica that missed writes while it was down.
+// The "anti_entropy" scenario does: every replica periodically compares
+// the Merkle tree of a key range with another replica of the range, and
+// only the leaves whose hashes differ are synced; a read that sees an
+// older value on some replicas also writes the newest back to them.
+//
+// With the highest version winning, one of two concurrent writes is
+// silently lost. The "siblings" scenario tracks causality with vector
+// clocks instead: two clients on either side of a partition keep adding
+// items to shopping carts, and the writes neither saw the other of are
+// both kept as siblings. The next read returns them all, and the client
+// resolves them by merging the carts. Every value carries a dotted
+// version vector: the dot naming its write and the clock of the values
+// the client had read. The "version_vectors" scenario runs the same
+// clients with plain version vectors: a coordinator gives a write the
+// vector of what it stores, merged with the client's, and counts the
+// write in it. The vector cannot say which of the stored siblings the
+// client read, so unless it read them all, the write keeps them all, as
+// false conflicts the client has to resolve again.
+//
+// The "partition_strict" and "partition_sloppy" scenarios split the ring
+// in two for part of every fault cycle while clients on both sides keep
+// writing and reading. With a strict quorum only the side holding W of a
+// key's replicas can write it, and no read misses a write. With a sloppy
+// quorum a write goes to the first N nodes on the ring the coordinator
+// finds healthy, fallbacks holding hints for the replicas across the
+// split: every write succeeds, but many are acknowledged by fewer than W
+// of their replicas, and reads on the other side miss them until the
+// hints are handed over after the split heals.
+type Simulation struct {
+	mu sync.RWMutex
+
+	engine    *engine.Engine
+	rng       *rand.Rand
+	transport *transport.NetworkTransport
+	broadcast func(interface{})
+
+	nodes    []*Node
+	scenario string
+	ring     *ring
+	n, w, r  int
+	sloppy   bool
+
+	antiEntropy bool
+	readRepair  bool
+	clocks      bool // Concurrent writes become siblings
+	causality   causality
+	partitioned bool // Split the ring instead of crashing and isolating nodes
+
+	version   int64
+	committed map[string]int64 // Version of the last successful write of each key
+	isolated  map[string]bool
+
+	writes         int
+	failedWrites   int
+	hintedWrites   int // Successful writes acknowledged by a fallback
+	weakWrites     int // Successful writes fewer than W replicas of the preference list acknowledged
+	homelessWrites int // Successful writes no replica of the preference list acknowledged
+	reads          int
+	failedReads    int
+	staleReads     int
+	hintsStored    int
+	hintsDelivered int
+	handoffs       int
+	windowTotal    int // Ticks the delivered hints were held, summed over handoffs
+	windowMax      int
+	comparisons    []Comparison // Most recent last
+	compareCount   int
+	inSync         int                        // Comparisons that found the trees equal
+	readRepairs    int                        // Keys repaired by read repair
+	syncRepairs    int                        // Keys repaired by anti-entropy
+	conflicts      int                        // Replicas that found concurrent values of a key
+	falseSiblings  int                        // Siblings a write kept although its client had read them
+	resolved       int                        // Sibling sets resolved by clients
+	written        map[string]map[string]bool // Items of the successful writes of each cart
+	itemCounts     map[string]int             // Items each client added
+	messages       map[string]int
+
+	lastTick int
+
+	running bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// Config for quorum simulation
+type Config struct {
+	NodeCount int
+	Scenario  string // "strict", "hinted_handoff", "anti_entropy", "siblings", "version_vectors", "partition_strict", "partition_sloppy"
+}
+
+// NewSimulation creates a new quorum simulation
+func NewSimulation(eng *engine.Engine, trans *transport.NetworkTransport, broadcast func(interface{}), config Config) *Simulation {
+	switch config.Scenario {
+	case "strict", "hinted_handoff", "anti_entropy", "siblings", "version_vectors", "partition_strict", "partition_sloppy":
+	default:
+		config.Scenario = "strict"
+	}
+	mode := highestVersion
+	switch config.Scenario {
+	case "siblings":
+		mode = dottedClocks
+	case "version_vectors":
+		mode = versionVectors
+	}
+	// Fallbacks need nodes beyond the preference list
+	if config.NodeCount < replicationFactor+2 {
+		config.NodeCount = replicationFactor + 2
+	}
+
+	sim := &Simulation{
+		engine:      eng,
+		rng:         eng.Rand(),
+		transport:   trans,
+		broadcast:   broadcast,
+		scenario:    config.Scenario,
+		n:           replicationFactor,
+		w:           writeQuorum,
+		r:           readQuorum,
+		sloppy:      config.Scenario == "hinted_handoff" || mode != highestVersion || config.Scenario == "partition_sloppy",
+		antiEntropy: config.Scenario == "anti_entropy" || mode != highestVersion,
+		readRepair:  config.Scenario == "anti_entropy" || mode != highestVersion,
+		clocks:      mode != highestVersion,
+		causality:   mode,
+		partitioned: config.Scenario == "partition_strict" || config.Scenario == "partition_sloppy",
+		written:     make(map[string]map[string]bool),
+		itemCounts:  make(map[string]int),
+		committed:   make(map[string]int64),
+		isolated:    make(map[string]bool),
+		comparisons: make([]Comparison, 0),
+		messages:    make(map[string]int),
+	}
+
+	trans.SetLatency(20*time.Millisecond, 80*time.Millisecond)
+	trans.SetPacketLoss(0)
+
+	ids := make([]string, 0, config.NodeCount)
+	for i := 0; i < config.NodeCount; i++ {
+		node := newNode(fmt.Sprintf("node-%d", i+1), sim)
+		sim.nodes = append(sim.nodes, node)
+		ids = append(ids, node.id)
+		trans.RegisterHandler(node.id, node.handleMessage)
+		eng.AddNode(node)
+	}
+	sim.ring = newRing(ids)
+
+	return sim
+}
+
+// Start starts the simulation
+func (s *Simulation) Start(ctx context.Context) error {
+	s.mu.Lock()
+	s.running = true
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.mu.Unlock()
+
+	return s.engine.Start(ctx)
+}
+
+// Stop stops the simulation
+func (s *Simulation) Stop() error {
+	s.mu.Lock()
+	s.running = false
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Unlock()
+
+	return s.engine.Stop()
+}
+
+// GetState returns the current simulation state
+func (s *Simulation) GetState() *protocol.SimulationStateResponse {
+	nodes := make(map[string]protocol.NodeState)
+	versions := make(map[string]map[string]int64)
+	siblings := make(map[string]int) // Most siblings of each key on a node
+	pendingHints := 0
+	for _, node := range s.nodes {
+		state := node.GetState()
+		nodes[node.id] = protocol.NodeState{
+			ID:          node.id,
+			Status:      state["status"].(string),
+			Role:        "replica",
+			CustomState: state,
+		}
+		versions[node.id] = state["versions"].(map[string]int64)
+		for key, values := range state["siblings"].(map[string][]Versioned) {
+			siblings[key] = max(siblings[key], len(values))
+		}
+		for _, count := range state["hints"].(map[string]int) {
+			pendingHints += count
+		}
+	}
+
+	s.mu.RLock()
+	running := s.running
+	behind := s.replicasBehind(versions)
+	committed := make(map[string]int64, len(s.committed))
+	for key, version := range s.committed {
+		committed[key] = version
+	}
+	messages := make(map[string]int, len(s.messages))
+	for msgType, count := range s.messages {
+		messages[msgType] = count
+	}
+	isolated := make([]string, 0, len(s.isolated))
+	for nodeID := range s.isolated {
+		isolated = append(isolated, nodeID)
+	}
+	sort.Strings(isolated)
+	avgWindow := 0.0
+	if s.handoffs > 0 {
+		avgWindow = float64(s.windowTotal) / float64(s.handoffs)
+	}
+	metadata := map[string]interface{}{
+		"scenario":       s.scenario,
+		"n":              s.n,
+		"w":              s.w,
+		"r":              s.r,
+		"sloppyQuorum":   s.sloppy,
+		"ring":           append([]string{}, s.ring.replicas...),
+		"committed":      committed,
+		"writes":         s.writes,
+		"failedWrites":   s.failedWrites,
+		"hintedWrites":   s.hintedWrites,
+		"weakWrites":     s.weakWrites,     // Acknowledged by fewer than W replicas of the preference list
+		"homelessWrites": s.homelessWrites, // Acknowledged by fallbacks only
+		"reads":          s.reads,
+		"failedReads":    s.failedReads,
+		"staleReads":     s.staleReads,
+		"hintsStored":    s.hintsStored,
+		"hintsDelivered": s.hintsDelivered,
+		"pendingHints":   pendingHints,
+		"replicasBehind": behind, // Key, replicas of its preference list missing its last write
+		"divergentKeys":  len(behind),
+		"avgHintWindow":  avgWindow, // Ticks from a hint stored to its delivery
+		"maxHintWindow":  s.windowMax,
+		"isolated":       isolated,
+		"partitioned":    s.partitioned,
+		"antiEntropy":    s.antiEntropy,
+		"readRepair":     s.readRepair,
+		"comparisons":    append([]Comparison{}, s.comparisons...),
+		"compareCount":   s.compareCount,
+		"inSync":         s.inSync,
+		"readRepairs":    s.readRepairs,
+		"syncRepairs":    s.syncRepairs,
+		"vectorClocks":   s.clocks,
+		"causality":      s.causality.String(),
+		"siblings":       siblings,
+		"conflicts":      s.conflicts,
+		"resolved":       s.resolved,
+		"falseSiblings":  s.falseSiblings,
+		"messages":       messages,
+	}
+	s.mu.RUnlock()
+
+	mode := "step"
+	if s.engine != nil {
+		mode = s.engine.GetMode().String()
+	}
+
+	return &protocol.SimulationStateResponse{
+		Type:        protocol.MsgSimulationState,
+		VirtualTime: time.Now().UnixMilli(),
+		Mode:        mode,
+		Speed:       1.0,
+		Running:     running,
+		Nodes:       nodes,
+		Metadata:    metadata,
+	}
+}
+
+// GetNodes returns node states
+func (s *Simulation) GetNodes() map[string]protocol.NodeState {
+	state := s.GetState()
+	return state.Nodes
+}
+
+// Layout places the nodes at their ring positions
+func (s *Simulation) Layout() *protocol.Layout {
+	positions := make(map[string]float64, len(s.nodes))
+	for _, node := range s.nodes {
+		positions[node.id] = float64(hash(node.id)) / (1 << 32)
+	}
+	return &protocol.Layout{Kind: protocol.LayoutHashRing, Positions: positions}
+}
+
+// CrashNode crashes a node; it keeps its store and hints
+func (s *Simulation) CrashNode(nodeID string) error {
+	node := s.findNode(nodeID)
+	if node == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	node.mu.Lock()
+	node.crash()
+	node.mu.Unlock()
+	return nil
+}
+
+// RecoverNode recovers a crashed node
+func (s *Simulation) RecoverNode(nodeID string) error {
+	node := s.findNode(nodeID)
+	if node == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	node.mu.Lock()
+	node.status = "running"
+	node.mu.Unlock()
+	return nil
+}
+
+// HandleClientRequest sends a client request to a coordinator. Commands
+// are "put" (payload: key, value) and "get" (payload: key), both with an
+// optional nodeId of the coordinator.
+func (s *Simulation) HandleClientRequest(command string, payload map[string]interface{}) error {
+	key, _ := payload["key"].(string)
+	if key == "" {
+		return fmt.Errorf("missing key")
+	}
+	nodeID, _ := payload["nodeId"].(string)
+	if nodeID == "" {
+		nodeID = s.coordinator()
+	}
+	node := s.findNode(nodeID)
+	if node == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+
+	switch command {
+	case "put":
+		value, _ := payload["value"].(string)
+		return node.startWrite(key, value)
+	case "get":
+		return node.startRead(key)
+	}
+	return fmt.Errorf("unknown command: %s", command)
+}
+
+// NodeActions lists the actions of a node: coordinating a "put" (params:
+// key, value) or a "get" (params: key), starting an anti-entropy round
+// with "sync" and, when it holds hints, handing them over with
+// "deliver_hints"
+func (s *Simulation) NodeActions(nodeID string) []string {
+	node := s.findNode(nodeID)
+	if node == nil {
+		return nil
+	}
+	node.mu.RLock()
+	defer node.mu.RUnlock()
+
+	if node.status != "running" {
+		return nil
+	}
+	actions := []string{"put", "get", "sync"}
+	if len(node.hints) > 0 {
+		actions = append(actions, "deliver_hints")
+	}
+	return actions
+}
+
+// InvokeNodeAction invokes an action listed by NodeActions
+func (s *Simulation) InvokeNodeAction(nodeID, action string, params map[string]interface{}) error {
+	node := s.findNode(nodeID)
+	if node == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+
+	switch action {
+	case "put", "get":
+		payload := make(map[string]interface{}, len(params)+1)
+		for name, value := range params {
+			payload[name] = value
+		}
+		payload["nodeId"] = nodeID
+		return s.HandleClientRequest(action, payload)
+	case "sync":
+		node.mu.Lock()
+		node.nextSync = node.ticks + syncTicks
+		node.startSync()
+		node.mu.Unlock()
+	case "deliver_hints":
+		node.mu.Lock()
+		node.handedAt = make(map[string]int)
+		node.handOff()
+		node.mu.Unlock()
+	default:
+		return fmt.Errorf("unknown action: %s", action)
+	}
+	return nil
+}
+
+// findNode looks up a node by ID; the node list is fixed after
+// construction
+func (s *Simulation) findNode(nodeID string) *Node {
+	for _, node := range s.nodes {
+		if node.id == nodeID {
+			return node
+		}
+	}
+	return nil
+}
+
+// advanceSchedule has a client write or read a random key every tick,
+// and in every fault cycle crashes one node and cuts another off while
+// the first is down, or in the partition scenarios splits the ring in two
+func (s *Simulation) advanceSchedule(ticks int) {
+	s.mu.Lock()
+	if ticks <= s.lastTick {
+		s.mu.Unlock()
+		return
+	}
+	s.lastTick = ticks
+	s.mu.Unlock()
+
+	if s.clocks {
+		s.advanceClients(ticks)
+		return
+	}
+
+	crashed, isolated := s.nodes[1].id, s.nodes[3].id
+	switch {
+	case s.partitioned:
+		switch ticks % faultCycle {
+		case splitAt:
+			s.split(true)
+		case rejoinAt:
+			s.split(false)
+		}
+	case ticks%faultCycle == crashAt:
+		s.CrashNode(crashed)
+		s.broadcast(map[string]interface{}{
+			"type":   "node_crashed",
+			"nodeId": crashed,
+		})
+	case ticks%faultCycle == isolateAt:
+		s.isolate(isolated, true)
+	case ticks%faultCycle == recoverAt:
+		s.RecoverNode(crashed)
+		s.broadcast(map[string]interface{}{
+			"type":   "node_recovered",
+			"nodeId": crashed,
+		})
+	case ticks%faultCycle == healAt:
+		s.isolate(isolated, false)
+	}
+
+	node := s.findNode(s.coordinator())
+	if node == nil {
+		return
+	}
+	key := fmt.Sprintf("key-%d", s.rng.Intn(keyCount)+1)
+	if ticks%2 == 0 {
+		node.startWrite(key, fmt.Sprintf("v%d", ticks))
+	} else {
+		node.startRead(key)
+	}
+}
+
+// advanceClients splits the nodes in two halves for part of every fault
+// cycle, and has a client on each side read-modify-write a cart every
+// clientEvery ticks
+func (s *Simulation) advanceClients(ticks int) {
+	half := len(s.nodes) / 2
+	switch ticks % faultCycle {
+	case splitAt:
+		s.split(true)
+	case rejoinAt:
+		s.split(false)
+	}
+
+	var client string
+	var side []*Node
+	switch ticks % clientEvery {
+	case 0:
+		client, side = "client-a", s.nodes[:half]
+	case clientEvery / 2:
+		client, side = "client-b", s.nodes[half:]
+	default:
+		return
+	}
+	node := side[s.rng.Intn(len(side))]
+	node.mu.Lock()
+	if node.status == "running" {
+		node.read(fmt.Sprintf("cart-%d", s.rng.Intn(cartKeys)+1), client)
+	}
+	node.mu.Unlock()
+}
+
+// split cuts the two halves of the nodes off from each other, or
+// reconnects them
+func (s *Simulation) split(cut bool) {
+	half := len(s.nodes) / 2
+	for _, a := range s.nodes[:half] {
+		for _, b := range s.nodes[half:] {
+			if cut {
+				s.transport.CreateBidirectionalPartition(a.id, b.id)
+			} else {
+				s.transport.ClearBidirectionalPartition(a.id, b.id)
+			}
+		}
+	}
+	eventType := "partition_created"
+	if !cut {
+		eventType = "partition_healed"
+	}
+	s.broadcast(map[string]interface{}{
+		"type":   eventType,
+		"groups": s.sides(),
+	})
+}
+
+// sides returns the nodes each client talks to
+func (s *Simulation) sides() map[string][]string {
+	half := len(s.nodes) / 2
+	sides := make(map[string][]string)
+	for i, node := range s.nodes {
+		side := "client-a"
+		if i >= half {
+			side = "client-b"
+		}
+		sides[side] = append(sides[side], node.id)
+	}
+	return sides
+}
+
+// nextItem names the next item a client adds to a cart
+func (s *Simulation) nextItem(client string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.itemCounts[client]++
+	return fmt.Sprintf("%s%d", client[len(client)-1:], s.itemCounts[client])
+}
+
+// isolate cuts a node off from all the others, or reconnects it
+func (s *Simulation) isolate(nodeID string, cut bool) {
+	for _, node := range s.nodes {
+		if node.id == nodeID {
+			continue
+		}
+		if cut {
+			s.transport.CreateBidirectionalPartition(nodeID, node.id)
+		} else {
+			s.transport.ClearBidirectionalPartition(nodeID, node.id)
+		}
+	}
+
+	s.mu.Lock()
+	if cut {
+		s.isolated[nodeID] = true
+	} else {
+		delete(s.isolated, nodeID)
+	}
+	s.mu.Unlock()
+
+	eventType := "partition_created"
+	if !cut {
+		eventType = "partition_healed"
+	}
+	s.broadcast(map[string]interface{}{
+		"type":   eventType,
+		"nodeId": nodeID,
+	})
+}
+
+// coordinator picks a random running node the clients can reach
+func (s *Simulation) coordinator() string {
+	s.mu.RLock()
+	isolated := make(map[string]bool, len(s.isolated))
+	for nodeID := range s.isolated {
+		isolated[nodeID] = true
+	}
+	s.mu.RUnlock()
+
+	candidates := make([]string, 0, len(s.nodes))
+	for _, node := range s.nodes {
+		node.mu.RLock()
+		up := node.status == "running"
+		node.mu.RUnlock()
+		if up && !isolated[node.id] {
+			candidates = append(candidates, node.id)
+		}
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+	return candidates[s.rng.Intn(len(candidates))]
+}
+
+// preferenceList returns the N replicas of a key
+func (s *Simulation) preferenceList(key string) []string {
+	return s.ring.walk(key)[:s.n]
+}
+
+// fallbacks returns the nodes standing in for a key's replicas, in the
+// order they are tried
+func (s *Simulation) fallbacks(key string) []string {
+	return s.ring.walk(key)[s.n:]
+}
+
+// rangeOf returns the range of a key, named after its primary replica
+func (s *Simulation) rangeOf(key string) string {
+	return s.ring.walk(key)[0]
+}
+
+// replicasOfRange returns the N replicas of a range
+func (s *Simulation) replicasOfRange(primary string) []string {
+	return s.ring.from(primary)[:s.n]
+}
+
+// rangesOf returns the ranges a node is a replica of
+func (s *Simulation) rangesOf(nodeID string) []string {
+	ranges := make([]string, 0, s.n)
+	for _, primary := range s.ring.replicas {
+		for _, replica := range s.replicasOfRange(primary) {
+			if replica == nodeID {
+				ranges = append(ranges, primary)
+			}
+		}
+	}
+	return ranges
+}
+
+// nextVersion returns the version of a new write
+func (s *Simulation) nextVersion() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version++
+	return s.version
+}
+
+// committedVersion returns the version of the last successful write of a
+// key
+func (s *Simulation) committedVersion(key string) int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.committed[key]
+}
+
+// writeDone records the outcome of a write; home counts the
+// acknowledgments from the key's preference list itself
+func (s *Simulation) writeDone(coordinator, key, value string, version int64, acks, hinted, home int, ok bool) {
+	s.mu.Lock()
+	if ok {
+		s.writes++
+		s.committed[key] = max(s.committed[key], version)
+		if hinted > 0 {
+			s.hintedWrites++
+		}
+		if home < s.w {
+			s.weakWrites++
+		}
+		if home == 0 {
+			s.homelessWrites++
+		}
+		if s.clocks {
+			if s.written[key] == nil {
+				s.written[key] = make(map[string]bool)
+			}
+			for _, item := range items(value) {
+				s.written[key][item] = true
+			}
+		}
+	} else {
+		s.failedWrites++
+	}
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":        "write_completed",
+		"coordinator": coordinator,
+		"key":         key,
+		"version":     version,
+		"acks":        acks,
+		"fallbacks":   hinted,
+		"homeAcks":    home,
+		"ok":          ok,
+	})
+}
+
+// readDone records the outcome of a read; it is stale if it missed a
+// write that had succeeded before it started
+func (s *Simulation) readDone(coordinator, key string, version, committed int64, ok bool) {
+	stale := ok && version < committed
+
+	s.mu.Lock()
+	switch {
+	case !ok:
+		s.failedReads++
+	case stale:
+		s.reads++
+		s.staleReads++
+	default:
+		s.reads++
+	}
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":        "read_completed",
+		"coordinator": coordinator,
+		"key":         key,
+		"version":     version,
+		"expected":    committed,
+		"stale":       stale,
+		"ok":          ok,
+	})
+}
+
+// hintStored records a write a fallback holds for another replica
+func (s *Simulation) hintStored(nodeID, replica, key string, version int64) {
+	s.mu.Lock()
+	s.hintsStored++
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":    "hint_stored",
+		"nodeId":  nodeID,
+		"for":     replica,
+		"key":     key,
+		"version": version,
+	})
+}
+
+// hintDelivered records hints a replica acknowledged, and how long the
+// oldest of them was held
+func (s *Simulation) hintDelivered(nodeID, replica string, keys []string, held int) {
+	s.mu.Lock()
+	s.hintsDelivered += len(keys)
+	s.handoffs++
+	s.windowTotal += held
+	s.windowMax = max(s.windowMax, held)
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":      "hint_delivered",
+		"nodeId":    nodeID,
+		"for":       replica,
+		"keys":      keys,
+		"heldTicks": held,
+	})
+}
+
+// compared records a comparison of two Merkle trees
+func (s *Simulation) compared(nodeID, peer, primary string, leaves []int, compared int, peerTree, nodeTree []string) {
+	s.mu.Lock()
+	s.compareCount++
+	if len(leaves) == 0 {
+		s.inSync++
+	}
+	s.comparisons = append(s.comparisons, Comparison{
+		Node:     nodeID,
+		Peer:     peer,
+		Range:    primary,
+		NodeTree: nodeTree,
+		PeerTree: peerTree,
+		Leaves:   leaves,
+		Compared: compared,
+		Tick:     s.lastTick,
+	})
+	if len(s.comparisons) > keptComparisons {
+		s.comparisons = s.comparisons[1:]
+	}
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":     "merkle_compared",
+		"nodeId":   nodeID,
+		"peer":     peer,
+		"range":    primary,
+		"leaves":   leaves,
+		"compared": compared,
+		"inSync":   len(leaves) == 0,
+	})
+}
+
+// repaired records keys a replica brought up to date, by "read_repair"
+// or "anti_entropy"
+func (s *Simulation) repaired(nodeID string, keys []string, source string) {
+	s.mu.Lock()
+	if source == "read_repair" {
+		s.readRepairs += len(keys)
+	} else {
+		s.syncRepairs += len(keys)
+	}
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":   "keys_repaired",
+		"nodeId": nodeID,
+		"keys":   keys,
+		"source": source,
+	})
+}
+
+// conflictDetected records a replica finding concurrent values of a key
+func (s *Simulation) conflictDetected(nodeID, key string, siblings []Versioned) {
+	s.mu.Lock()
+	s.conflicts++
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":     "conflict_detected",
+		"nodeId":   nodeID,
+		"key":      key,
+		"siblings": siblings,
+	})
+}
+
+// falseConflict records a write that kept siblings its client had read,
+// out of those it kept
+func (s *Simulation) falseConflict(nodeID, key string, falseSiblings, kept int) {
+	s.mu.Lock()
+	s.falseSiblings += falseSiblings
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":          "false_conflict",
+		"nodeId":        nodeID,
+		"key":           key,
+		"falseSiblings": falseSiblings,
+		"kept":          kept,
+	})
+}
+
+// conflictResolved records a client merging the siblings it read
+func (s *Simulation) conflictResolved(client, coordinator, key string, siblings []Versioned, value string) {
+	s.mu.Lock()
+	s.resolved++
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":        "conflict_resolved",
+		"client":      client,
+		"coordinator": coordinator,
+		"key":         key,
+		"siblings":    siblings,
+		"value":       value,
+	})
+}
+
+// replicasBehind returns, for every key, the replicas of its preference
+// list that miss its last successful write (must hold s.mu)
+func (s *Simulation) replicasBehind(versions map[string]map[string]int64) map[string][]string {
+	behind := make(map[string][]string)
+	for key, version := range s.committed {
+		for _, replica := range s.preferenceList(key) {
+			if versions[replica][key] < version {
+				behind[key] = append(behind[key], replica)
+			}
+		}
+	}
+	return behind
+}
+
+// send transmits a message and reports it to the frontend
+func (s *Simulation) send(from, to string, msgType transport.MessageType, payload Payload) {
+	env := transport.NewEnvelope(from, to, msgType, payload)
+
+	s.mu.Lock()
+	s.messages[string(msgType)]++
+	s.mu.Unlock()
+
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageSent,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+
+	s.transport.Send(s.ctx, env)
+}
+
+// received reports a delivered message and returns its payload
+func (s *Simulation) received(env *transport.Envelope) Payload {
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageReceived,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+	payload, _ := env.Payload.(Payload)
+	return payload
+}
@@ -0,0 +1,135 @@
+package quorum
+
+import (
+	"fmt"
+
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+)
+
+// Invariants judges the run: no successful write was lost, and with W + R
+// > N no read missed a write that succeeded before it, which a sloppy
+// quorum does not promise while hints are held. A sloppy quorum also
+// counts acknowledgments from fallbacks, so a successful write may rest on
+// fewer than W of its replicas. With vector clocks, no item a client
+// added to a cart may be lost either.
+func (s *Simulation) Invariants() []protocol.InvariantResult {
+	held := make(map[string]int64)           // Newest version of each key anywhere
+	kept := make(map[string]map[string]bool) // Items of each cart anywhere
+	keep := func(key string, v Versioned) {
+		held[key] = max(held[key], v.Version)
+		if kept[key] == nil {
+			kept[key] = make(map[string]bool)
+		}
+		for _, item := range items(v.Value) {
+			kept[key][item] = true
+		}
+	}
+	for _, node := range s.nodes {
+		node.mu.RLock()
+		for key, siblings := range node.store {
+			for _, v := range siblings {
+				keep(key, v)
+			}
+		}
+		for _, hints := range node.hints {
+			for _, hint := range hints {
+				keep(hint.Key, hint.versioned())
+			}
+		}
+		node.mu.RUnlock()
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	lost := 0
+	for key, version := range s.committed {
+		if held[key] < version {
+			lost++
+		}
+	}
+
+	results := []protocol.InvariantResult{
+		{
+			Name:   "successful writes are not lost",
+			Holds:  lost == 0,
+			Detail: fmt.Sprintf("%d of %d keys lost their last successful write", lost, len(s.committed)),
+		},
+		{
+			Name:   "reads see the last successful write",
+			Holds:  s.staleReads == 0,
+			Detail: fmt.Sprintf("%d of %d reads stale with N=%d, W=%d, R=%d (sloppy quorum: %v)", s.staleReads, s.reads, s.n, s.w, s.r, s.sloppy),
+		},
+		{
+			Name:   "successful writes reach W replicas",
+			Holds:  s.weakWrites == 0,
+			Detail: fmt.Sprintf("%d of %d successful writes acknowledged by fewer than W=%d of their replicas, %d by fallbacks only", s.weakWrites, s.writes, s.w, s.homelessWrites),
+		},
+	}
+	if !s.clocks {
+		return results
+	}
+
+	missing, total := 0, 0
+	for key, written := range s.written {
+		for item := range written {
+			total++
+			if !kept[key][item] {
+				missing++
+			}
+		}
+	}
+	return append(results,
+		protocol.InvariantResult{
+			Name:   "concurrent writes kept as siblings",
+			Holds:  missing == 0,
+			Detail: fmt.Sprintf("%d of %d cart items written lost; %d conflicts found, %d resolved by clients", missing, total, s.conflicts, s.resolved),
+		},
+		protocol.InvariantResult{
+			Name:   "siblings are concurrent",
+			Holds:  s.falseSiblings == 0,
+			Detail: fmt.Sprintf("%d siblings kept although the writer had read them (%s)", s.falseSiblings, s.causality),
+		},
+	)
+}
+
+// FollowUps suggests the scenarios that contrast with this one
+func (s *Simulation) FollowUps() []protocol.FollowUp {
+	switch s.scenario {
+	case "hinted_handoff":
+		return []protocol.FollowUp{
+			{Project: "quorum", Scenario: "strict", Reason: "Compare the writes that fail without fallbacks"},
+			{Project: "quorum", Scenario: "anti_entropy", Reason: "Repair the replicas that missed writes without hints"},
+		}
+	case "anti_entropy":
+		return []protocol.FollowUp{
+			{Project: "quorum", Scenario: "strict", Reason: "Compare how long replicas stay behind without repair"},
+			{Project: "quorum", Scenario: "siblings", Reason: "Keep concurrent writes as siblings instead of the last one"},
+		}
+	case "partition_strict":
+		return []protocol.FollowUp{
+			{Project: "quorum", Scenario: "partition_sloppy", Reason: "Keep both sides writable with a sloppy quorum under the same split"},
+			{Project: "cap", Scenario: "consistent", Reason: "Compare with a primary that turns clients away when cut off"},
+		}
+	case "partition_sloppy":
+		return []protocol.FollowUp{
+			{Project: "quorum", Scenario: "partition_strict", Reason: "Compare the writes that fail but stay consistent under the same split"},
+			{Project: "quorum", Scenario: "anti_entropy", Reason: "Repair the replicas that missed writes without hints"},
+		}
+	case "siblings":
+		return []protocol.FollowUp{
+			{Project: "quorum", Scenario: "version_vectors", Reason: "Track the same writes with plain version vectors"},
+			{Project: "quorum", Scenario: "anti_entropy", Reason: "Compare with the highest version winning"},
+			{Project: "crdt", Scenario: "or_set_partition", Reason: "Merge concurrent cart updates without client resolution"},
+		}
+	case "version_vectors":
+		return []protocol.FollowUp{
+			{Project: "quorum", Scenario: "siblings", Reason: "Tell the siblings apart with dotted version vectors"},
+		}
+	}
+	return []protocol.FollowUp{
+		{Project: "quorum", Scenario: "hinted_handoff", Reason: "Keep writes available with a sloppy quorum and hinted handoff"},
+		{Project: "quorum", Scenario: "partition_strict", Reason: "Split the ring in two with clients on both sides"},
+		{Project: "quorum", Scenario: "anti_entropy", Reason: "Repair stale replicas with Merkle trees and read repair"},
+	}
+}
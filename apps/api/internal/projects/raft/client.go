@@ -0,0 +1,205 @@
+package raft
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+const (
+	opTicks       = 2  // Ticks between a client's operations
+	clientTimeout = 15 // Ticks a client waits for an answer before trying another server
+)
+
+// operation is the request a client is waiting on
+type operation struct {
+	op       string // "write" or "read"
+	seq      int
+	value    int
+	to       string
+	sentAt   int
+	snapshot int // Highest write index acknowledged to anyone when the operation started
+}
+
+// Client alternates writes and reads of the register, sending each to
+// the server it believes leads and following redirects. A read must
+// return at least every write acknowledged before it started; one that
+// returns less is stale, which a linearizable register never allows.
+type Client struct {
+	mu sync.RWMutex
+
+	id      string
+	status  string // "running" or "crashed"
+	ticks   int
+	servers []string
+
+	hint    string
+	seq     int
+	pending *operation
+	nextAt  int
+	reading bool // Next operation is a read
+
+	writes   int
+	reads    int
+	stale    int
+	timeouts int
+
+	inbox      chan *transport.Envelope
+	simulation *Simulation
+}
+
+func newClient(id string, servers []string, sim *Simulation) *Client {
+	return &Client{
+		id:         id,
+		status:     "running",
+		servers:    servers,
+		nextAt:     electionMax + 5, // Once a leader is likely elected
+		inbox:      make(chan *transport.Envelope, 500),
+		simulation: sim,
+	}
+}
+
+// Client implements engine.NodeController
+
+func (c *Client) ID() string {
+	return c.id
+}
+
+func (c *Client) Start(ctx context.Context) error {
+	return nil
+}
+
+func (c *Client) Stop() error {
+	return nil
+}
+
+func (c *Client) Tick() {
+	c.simulation.advanceSchedule(c.tick())
+}
+
+func (c *Client) tick() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ticks++
+	if c.status == "crashed" {
+		return c.ticks
+	}
+
+	for i := 0; i < 50; i++ {
+		select {
+		case env := <-c.inbox:
+			c.processMessage(env)
+			continue
+		default:
+		}
+		break
+	}
+
+	sim := c.simulation
+	if c.pending != nil && c.ticks-c.pending.sentAt >= clientTimeout {
+		c.timeouts++
+		sim.clientTimedOut(c.id, c.pending.to, c.pending.op)
+		c.hint = c.otherServer(c.pending.to)
+		c.pending = nil
+	}
+	if c.pending == nil && c.ticks >= c.nextAt {
+		c.seq++
+		op := &operation{op: "write", seq: c.seq, snapshot: sim.acknowledged()}
+		if c.reading {
+			op.op = "read"
+		} else {
+			op.value = sim.nextValue()
+		}
+		c.reading = !c.reading
+		c.pending = op
+		c.submit()
+	}
+	return c.ticks
+}
+
+func (c *Client) GetState() map[string]interface{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	state := map[string]interface{}{
+		"id":       c.id,
+		"status":   c.status,
+		"role":     "client",
+		"leader":   c.hint, // Server it believes leads
+		"writes":   c.writes,
+		"reads":    c.reads,
+		"stale":    c.stale,
+		"timeouts": c.timeouts,
+	}
+	if c.pending != nil {
+		state["waitingOn"] = map[string]interface{}{"op": c.pending.op, "server": c.pending.to}
+	}
+	return state
+}
+
+func (c *Client) handleMessage(env *transport.Envelope) {
+	c.mu.RLock()
+	down := c.status == "crashed"
+	c.mu.RUnlock()
+
+	if down {
+		return
+	}
+	select {
+	case c.inbox <- env:
+	default:
+	}
+}
+
+func (c *Client) processMessage(env *transport.Envelope) {
+	sim := c.simulation
+	payload := sim.received(env)
+	op := c.pending
+	if env.Type != MsgClientReply || op == nil || payload.Seq != op.seq {
+		return
+	}
+	if payload.Error == "not_leader" {
+		c.hint = payload.Leader
+		if c.hint == "" {
+			c.hint = c.otherServer(env.From)
+		}
+		c.submit()
+		return
+	}
+
+	if op.op == "write" {
+		c.writes++
+		sim.writeAcknowledged(payload.Index)
+	} else {
+		c.reads++
+		if payload.Index < op.snapshot {
+			c.stale++
+		}
+		sim.readServed(c.id, env.From, payload.ReadMode, payload.Index, op.snapshot)
+	}
+	c.pending = nil
+	c.nextAt = c.ticks + opTicks
+}
+
+// submit sends the pending operation to the believed leader (must hold
+// c.mu)
+func (c *Client) submit() {
+	if c.hint == "" {
+		c.hint = c.servers[c.simulation.rng.Intn(len(c.servers))]
+	}
+	op := c.pending
+	op.to = c.hint
+	op.sentAt = c.ticks
+	c.simulation.send(c.id, op.to, MsgClientRequest, Payload{Op: op.op, Seq: op.seq, Value: op.value})
+}
+
+// otherServer picks a server at random other than one (must hold c.mu)
+func (c *Client) otherServer(not string) string {
+	for {
+		if server := c.servers[c.simulation.rng.Intn(len(c.servers))]; server != not || len(c.servers) == 1 {
+			return server
+		}
+	}
+}
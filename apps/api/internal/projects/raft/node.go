@@ -0,0 +1,590 @@
+package raft
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+const (
+	MsgRequestVote   transport.MessageType = "request_vote"
+	MsgVote          transport.MessageType = "vote"
+	MsgAppendEntries transport.MessageType = "append_entries"
+	MsgAppendReply   transport.MessageType = "append_reply"
+	MsgClientRequest transport.MessageType = "client_request"
+	MsgClientReply   transport.MessageType = "client_reply"
+)
+
+const (
+	heartbeatTicks = 2  // Ticks between two rounds of AppendEntries from the leader
+	electionMin    = 10 // Election timeout, drawn between these, in ticks
+	electionMax    = 20
+	leaseTicks     = 8  // A lease ends before any follower can time out: electionMin minus a margin for clock drift
+	maxEntries     = 20 // Entries carried by one AppendEntries
+)
+
+// Entry is a log entry: a client's write, a client's read when reads go
+// through the log, or the no-op a new leader appends to commit an entry
+// of its own term
+type Entry struct {
+	Term   int    `json:"term"`
+	Index  int    `json:"index"`
+	Kind   string `json:"kind"` // "write", "read", "noop"
+	Value  int    `json:"value,omitempty"`
+	Client string `json:"client,omitempty"`
+	Seq    int    `json:"seq,omitempty"`
+}
+
+// pendingRead is a ReadIndex read waiting to be served: the commit index
+// when it arrived, and the heartbeat round that must confirm the node
+// still leads
+type pendingRead struct {
+	client  string
+	seq     int
+	index   int // -1 until the leader has committed an entry of its term
+	round   int
+	arrived int
+}
+
+// Node is a Raft server holding a replicated register. Followers that
+// hear nothing from a leader for an election timeout stand for election;
+// the leader replicates the clients' writes and commits them once a
+// majority stores them. With PreVote, a node first asks whether it could
+// win, without raising its term, and nodes that still hear from a leader
+// say no. Reads are served according to the simulation's read mode.
+type Node struct {
+	mu sync.RWMutex
+
+	id     string
+	status string // "running" or "crashed"
+	ticks  int
+	peers  []string
+
+	state    string // "follower", "pre_candidate", "candidate", "leader"
+	term     int
+	votedFor string
+	leader   string // Leader hint for clients
+	deadline int    // Tick the election timeout fires
+	heardAt  int    // Tick a leader was last heard from
+	votes    map[string]bool
+
+	log         []Entry // log[0] is a sentinel
+	commitIndex int
+	lastApplied int
+	value       int // The register, as applied
+	valueIndex  int // Index of the write that set it
+
+	// Leader
+	nextIndex      map[string]int
+	matchIndex     map[string]int
+	noopIndex      int
+	lastHeartbeat  int
+	round          int
+	roundSent      map[int]int
+	roundAcks      map[int]map[string]bool
+	confirmedRound int // Last round a majority answered
+	majorityAt     int // Tick that round was sent
+	leaseUntil     int
+	reads          []pendingRead
+
+	inbox      chan *transport.Envelope
+	simulation *Simulation
+}
+
+func newNode(id string, peers []string, sim *Simulation) *Node {
+	n := &Node{
+		id:         id,
+		status:     "running",
+		peers:      peers,
+		state:      "follower",
+		log:        []Entry{{}},
+		inbox:      make(chan *transport.Envelope, 500),
+		simulation: sim,
+	}
+	n.resetDeadline()
+	return n
+}
+
+// Node implements engine.NodeController
+
+func (n *Node) ID() string {
+	return n.id
+}
+
+func (n *Node) Start(ctx context.Context) error {
+	return nil
+}
+
+func (n *Node) Stop() error {
+	return nil
+}
+
+func (n *Node) Tick() {
+	n.simulation.advanceSchedule(n.tick())
+}
+
+func (n *Node) tick() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.ticks++
+	if n.status == "crashed" {
+		return n.ticks
+	}
+
+	for i := 0; i < 50; i++ {
+		select {
+		case env := <-n.inbox:
+			n.processMessage(env)
+			continue
+		default:
+		}
+		break
+	}
+
+	if n.state == "leader" {
+		if n.ticks-n.lastHeartbeat >= heartbeatTicks {
+			n.replicate()
+		}
+		n.serveReads()
+	} else if n.ticks >= n.deadline {
+		if n.simulation.preVoteEnabled() {
+			n.startPreVote()
+		} else {
+			n.startElection()
+		}
+	}
+	return n.ticks
+}
+
+func (n *Node) GetState() map[string]interface{} {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	tail := n.log[max(1, len(n.log)-8):]
+	state := map[string]interface{}{
+		"id":          n.id,
+		"status":      n.status,
+		"role":        n.state,
+		"term":        n.term,
+		"votedFor":    n.votedFor,
+		"leader":      n.leader,
+		"lastIndex":   n.lastIndex(),
+		"commitIndex": n.commitIndex,
+		"lastApplied": n.lastApplied,
+		"value":       n.value,
+		"log":         append([]Entry{}, tail...), // Latest entries
+	}
+	if n.state == "leader" {
+		state["leaseTicks"] = max(0, n.leaseUntil-n.ticks) // Left on the lease
+		state["pendingReads"] = len(n.reads)
+		state["matchIndex"] = copyCounts(n.matchIndex)
+	}
+	return state
+}
+
+func (n *Node) handleMessage(env *transport.Envelope) {
+	n.mu.RLock()
+	down := n.status == "crashed"
+	n.mu.RUnlock()
+
+	if down {
+		return
+	}
+	select {
+	case n.inbox <- env:
+	default:
+	}
+}
+
+func (n *Node) processMessage(env *transport.Envelope) {
+	payload := n.simulation.received(env)
+	if env.Type == MsgClientRequest {
+		n.handleClient(env.From, payload)
+		return
+	}
+	// Pre-votes carry the term the candidate would stand in, not one
+	// anybody is in
+	if !payload.PreVote && payload.Term > n.term {
+		n.stepDown(payload.Term, env.From)
+	}
+
+	switch env.Type {
+	case MsgRequestVote:
+		if payload.PreVote {
+			n.handlePreVote(env.From, payload)
+			return
+		}
+		granted := payload.Term == n.term && (n.votedFor == "" || n.votedFor == env.From) && n.upToDate(payload)
+		if granted {
+			n.votedFor = env.From
+			n.resetDeadline()
+		}
+		n.send(env.From, MsgVote, Payload{Term: n.term, Granted: granted})
+	case MsgVote:
+		n.handleVote(env.From, payload)
+	case MsgAppendEntries:
+		n.handleAppend(env.From, payload)
+	case MsgAppendReply:
+		n.handleAppendReply(env.From, payload)
+	}
+}
+
+// startPreVote asks the peers whether they would vote for this node in
+// the next term, without moving to it (must hold n.mu)
+func (n *Node) startPreVote() {
+	n.become("pre_candidate")
+	n.votes = map[string]bool{n.id: true}
+	n.resetDeadline()
+	for _, peer := range n.peers {
+		n.send(peer, MsgRequestVote, Payload{Term: n.term + 1, PreVote: true, LastLogIndex: n.lastIndex(), LastLogTerm: n.lastTerm()})
+	}
+}
+
+// handlePreVote grants a pre-vote to a node with an up-to-date log, unless
+// this node still hears from a leader: then there is no election to win
+// (must hold n.mu)
+func (n *Node) handlePreVote(from string, payload Payload) {
+	heard := n.state == "leader" || (n.leader != "" && n.ticks-n.heardAt < electionMin)
+	granted := payload.Term > n.term && !heard && n.upToDate(payload)
+	term := n.term
+	if granted {
+		term = payload.Term
+	}
+	n.send(from, MsgVote, Payload{Term: term, PreVote: true, Granted: granted})
+}
+
+// startElection moves to the next term and asks for votes (must hold n.mu)
+func (n *Node) startElection() {
+	n.term++
+	n.become("candidate")
+	n.votedFor = n.id
+	n.votes = map[string]bool{n.id: true}
+	n.resetDeadline()
+	n.simulation.electionStarted(n.id, n.term)
+	for _, peer := range n.peers {
+		n.send(peer, MsgRequestVote, Payload{Term: n.term, LastLogIndex: n.lastIndex(), LastLogTerm: n.lastTerm()})
+	}
+}
+
+// handleVote counts a vote or a pre-vote (must hold n.mu)
+func (n *Node) handleVote(from string, payload Payload) {
+	if payload.PreVote {
+		if !payload.Granted {
+			if payload.Term > n.term {
+				n.stepDown(payload.Term, from)
+			}
+			return
+		}
+		if n.state == "pre_candidate" && payload.Term == n.term+1 {
+			n.votes[from] = true
+			if len(n.votes) >= n.majority() {
+				n.startElection()
+			}
+		}
+		return
+	}
+	if n.state != "candidate" || payload.Term != n.term || !payload.Granted {
+		return
+	}
+	n.votes[from] = true
+	if len(n.votes) >= n.majority() {
+		n.becomeLeader()
+	}
+}
+
+// becomeLeader takes over the log and appends a no-op, the first entry of
+// its term: until it commits, the leader cannot tell which entries are
+// committed, nor serve a read (must hold n.mu)
+func (n *Node) becomeLeader() {
+	n.become("leader")
+	n.leader = n.id
+	n.nextIndex = make(map[string]int)
+	n.matchIndex = make(map[string]int)
+	for _, peer := range n.peers {
+		n.nextIndex[peer] = n.lastIndex() + 1
+		n.matchIndex[peer] = 0
+	}
+	n.roundSent = make(map[int]int)
+	n.roundAcks = make(map[int]map[string]bool)
+	n.confirmedRound, n.majorityAt, n.leaseUntil = n.round, n.ticks, 0
+	n.reads = nil
+	n.noopIndex = n.append(Entry{Kind: "noop"})
+	n.simulation.leaderElected(n.id, n.term)
+	n.replicate()
+}
+
+// stepDown moves to a higher term as a follower. A leader that a
+// majority answered within the last election timeout was still leading
+// fine: the higher term only disrupted it (must hold n.mu)
+func (n *Node) stepDown(term int, from string) {
+	if n.state == "leader" && n.ticks-n.majorityAt < electionMin {
+		n.simulation.disrupted(n.id, from, n.term, term)
+	}
+	n.term = term
+	n.votedFor = ""
+	n.leader = ""
+	n.become("follower")
+	n.resetDeadline()
+}
+
+// become changes the node's state and reports it (must hold n.mu)
+func (n *Node) become(state string) {
+	if state == n.state {
+		return
+	}
+	from := n.state
+	n.state = state
+	if from == "leader" {
+		n.reads = nil
+		n.leaseUntil = 0
+	}
+	n.simulation.stateChanged(n.id, from, state, n.term)
+}
+
+// replicate starts a heartbeat round: AppendEntries to every peer with
+// the entries it lacks (must hold n.mu)
+func (n *Node) replicate() {
+	n.lastHeartbeat = n.ticks
+	n.round++
+	n.roundSent[n.round] = n.ticks
+	n.roundAcks[n.round] = map[string]bool{n.id: true}
+	for _, peer := range n.peers {
+		next := min(n.nextIndex[peer], n.lastIndex()+1)
+		entries := append([]Entry{}, n.log[next:min(len(n.log), next+maxEntries)]...)
+		n.send(peer, MsgAppendEntries, Payload{
+			Term:         n.term,
+			PrevLogIndex: next - 1,
+			PrevLogTerm:  n.log[next-1].Term,
+			Entries:      entries,
+			LeaderCommit: n.commitIndex,
+			Round:        n.round,
+		})
+	}
+}
+
+// handleAppend accepts the leader of the term, and its entries when they
+// follow on from this node's log (must hold n.mu)
+func (n *Node) handleAppend(from string, payload Payload) {
+	if payload.Term < n.term {
+		n.send(from, MsgAppendReply, Payload{Term: n.term, Round: payload.Round})
+		return
+	}
+	n.become("follower")
+	n.leader = from
+	n.heardAt = n.ticks
+	n.resetDeadline()
+
+	prev := payload.PrevLogIndex
+	if prev > n.lastIndex() || n.log[prev].Term != payload.PrevLogTerm {
+		n.send(from, MsgAppendReply, Payload{Term: n.term, Round: payload.Round, MatchIndex: min(n.lastIndex(), prev-1)})
+		return
+	}
+	for _, entry := range payload.Entries {
+		if entry.Index <= n.lastIndex() && n.log[entry.Index].Term != entry.Term {
+			n.log = n.log[:entry.Index]
+		}
+		if entry.Index > n.lastIndex() {
+			n.log = append(n.log, entry)
+		}
+	}
+	match := prev + len(payload.Entries)
+	if payload.LeaderCommit > n.commitIndex {
+		n.commitIndex = max(n.commitIndex, min(payload.LeaderCommit, match))
+		n.apply()
+	}
+	n.send(from, MsgAppendReply, Payload{Term: n.term, Success: true, MatchIndex: match, Round: payload.Round})
+}
+
+// handleAppendReply counts the follower towards its round and, when it
+// stored the entries, towards committing them (must hold n.mu)
+func (n *Node) handleAppendReply(from string, payload Payload) {
+	if n.state != "leader" || payload.Term != n.term {
+		return
+	}
+	n.confirm(from, payload.Round)
+	if !payload.Success {
+		n.nextIndex[from] = max(1, min(n.nextIndex[from]-1, payload.MatchIndex+1))
+		return
+	}
+	n.matchIndex[from] = max(n.matchIndex[from], payload.MatchIndex)
+	n.nextIndex[from] = n.matchIndex[from] + 1
+
+	for index := n.lastIndex(); index > n.commitIndex; index-- {
+		if n.log[index].Term != n.term {
+			break // Entries of older terms commit with one of this term
+		}
+		stored := 1
+		for _, match := range n.matchIndex {
+			if match >= index {
+				stored++
+			}
+		}
+		if stored >= n.majority() {
+			n.commitIndex = index
+			n.apply()
+			break
+		}
+	}
+	n.serveReads()
+}
+
+// confirm records a follower answering a heartbeat round. Once a majority
+// answered, everything before the round was sent happened while this node
+// led, and it may lease leadership until a follower could first time out
+// (must hold n.mu)
+func (n *Node) confirm(from string, round int) {
+	acks, ok := n.roundAcks[round]
+	if !ok {
+		return
+	}
+	acks[from] = true
+	if len(acks) < n.majority() || round <= n.confirmedRound {
+		return
+	}
+	n.confirmedRound = round
+	n.majorityAt = n.roundSent[round]
+	n.leaseUntil = n.roundSent[round] + leaseTicks
+	for r := range n.roundAcks {
+		if r <= round {
+			delete(n.roundAcks, r)
+			delete(n.roundSent, r)
+		}
+	}
+}
+
+// handleClient serves a client's request, or points it to the leader
+// (must hold n.mu)
+func (n *Node) handleClient(from string, payload Payload) {
+	if n.state != "leader" {
+		n.send(from, MsgClientReply, Payload{Seq: payload.Seq, Error: "not_leader", Leader: n.leader})
+		return
+	}
+	if payload.Op == "write" {
+		n.append(Entry{Kind: "write", Value: payload.Value, Client: from, Seq: payload.Seq})
+		return
+	}
+
+	switch mode := n.simulation.readMode(); {
+	case mode == "local":
+		// Whatever this node applied, whether it still leads or not
+		n.reply(from, payload.Seq, "local")
+	case mode == "log":
+		n.append(Entry{Kind: "read", Client: from, Seq: payload.Seq})
+	case mode == "lease" && n.ticks < n.leaseUntil && n.commitIndex >= n.noopIndex:
+		// No other leader can exist before the lease ends
+		n.reply(from, payload.Seq, "lease")
+	default:
+		// ReadIndex, which is also what a lease read falls back to
+		n.reads = append(n.reads, pendingRead{client: from, seq: payload.Seq, index: -1, arrived: n.ticks})
+		n.serveReads()
+	}
+}
+
+// serveReads answers the ReadIndex reads whose round a majority confirmed
+// once the node applied up to their index, and drops those waiting too
+// long (must hold n.mu)
+func (n *Node) serveReads() {
+	kept := n.reads[:0]
+	for _, read := range n.reads {
+		if read.index < 0 && n.commitIndex >= n.noopIndex {
+			read.index = n.commitIndex
+			read.round = n.round + 1 // The next heartbeat round confirms it
+		}
+		switch {
+		case read.index >= 0 && n.confirmedRound >= read.round && n.lastApplied >= read.index:
+			n.reply(read.client, read.seq, "read_index")
+		case n.ticks-read.arrived < clientTimeout:
+			kept = append(kept, read)
+		}
+	}
+	n.reads = kept
+}
+
+// append adds an entry of the current term to the leader's log and
+// returns its index (must hold n.mu)
+func (n *Node) append(entry Entry) int {
+	entry.Term = n.term
+	entry.Index = n.lastIndex() + 1
+	n.log = append(n.log, entry)
+	return entry.Index
+}
+
+// apply applies the newly committed entries; the leader answers the
+// clients waiting on them (must hold n.mu)
+func (n *Node) apply() {
+	for n.lastApplied < n.commitIndex {
+		n.lastApplied++
+		entry := n.log[n.lastApplied]
+		switch entry.Kind {
+		case "write":
+			n.value, n.valueIndex = entry.Value, entry.Index
+			if n.state == "leader" && entry.Client != "" {
+				n.send(entry.Client, MsgClientReply, Payload{Seq: entry.Seq, OK: true, Value: entry.Value, Index: entry.Index})
+			}
+		case "read":
+			if n.state == "leader" && entry.Client != "" {
+				n.reply(entry.Client, entry.Seq, "log")
+			}
+		}
+	}
+}
+
+// reply answers a read with the register as applied (must hold n.mu)
+func (n *Node) reply(client string, seq int, how string) {
+	n.send(client, MsgClientReply, Payload{Seq: seq, OK: true, Value: n.value, Index: n.valueIndex, ReadMode: how})
+}
+
+// upToDate reports whether a candidate's log is at least as up to date
+// as this node's (must hold n.mu)
+func (n *Node) upToDate(payload Payload) bool {
+	if payload.LastLogTerm != n.lastTerm() {
+		return payload.LastLogTerm > n.lastTerm()
+	}
+	return payload.LastLogIndex >= n.lastIndex()
+}
+
+// resetDeadline draws a new election timeout (must hold n.mu)
+func (n *Node) resetDeadline() {
+	n.deadline = n.ticks + electionMin + n.simulation.rng.Intn(electionMax-electionMin+1)
+}
+
+func (n *Node) lastIndex() int {
+	return len(n.log) - 1
+}
+
+func (n *Node) lastTerm() int {
+	return n.log[len(n.log)-1].Term
+}
+
+func (n *Node) majority() int {
+	return (len(n.peers)+1)/2 + 1
+}
+
+// send transmits a message (must hold n.mu)
+func (n *Node) send(to string, msgType transport.MessageType, payload Payload) {
+	n.simulation.send(n.id, to, msgType, payload)
+}
+
+// committed returns the terms of the committed entries, for the log
+// matching check
+func (n *Node) committed() []int {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	terms := make([]int, min(n.commitIndex, n.lastIndex())+1)
+	for i := range terms {
+		terms[i] = n.log[i].Term
+	}
+	return terms
+}
+
+// copyCounts copies a map of counters
+func copyCounts(counts map[string]int) map[string]int {
+	copied := make(map[string]int, len(counts))
+	for k, v := range counts {
+		copied[k] = v
+	}
+	return copied
+}
@@ -0,0 +1,716 @@
+package raft
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+	"github.com/ersantana/distributed-systems-learning/packages/simulation/engine"
+)
+
+const (
+	isolateAt   = 40  // Tick a follower is cut off in the "rejoin" scenarios
+	rejoinAt    = 160 // Tick it rejoins
+	partitionAt = 60  // Tick the leader and a client are cut off in the read scenarios
+	healAt      = 200 // Tick the partition heals
+)
+
+// Payload is the content of all messages exchanged in this project
+type Payload struct {
+	Term int `json:"term,omitempty"`
+
+	// RequestVote, and the vote
+	PreVote      bool `json:"preVote,omitempty"`
+	LastLogIndex int  `json:"lastLogIndex,omitempty"`
+	LastLogTerm  int  `json:"lastLogTerm,omitempty"`
+	Granted      bool `json:"granted,omitempty"`
+
+	// AppendEntries, and the reply
+	PrevLogIndex int     `json:"prevLogIndex,omitempty"`
+	PrevLogTerm  int     `json:"prevLogTerm,omitempty"`
+	Entries      []Entry `json:"entries,omitempty"`
+	LeaderCommit int     `json:"leaderCommit,omitempty"`
+	Round        int     `json:"round,omitempty"` // Heartbeat round, confirming leadership for reads
+	Success      bool    `json:"success,omitempty"`
+	MatchIndex   int     `json:"matchIndex,omitempty"`
+
+	// Client requests, and the replies
+	Op       string `json:"op,omitempty"` // "write" or "read"
+	Seq      int    `json:"seq,omitempty"`
+	Value    int    `json:"value,omitempty"`
+	Index    int    `json:"index,omitempty"` // Log index of the write the value comes from
+	OK       bool   `json:"ok,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Leader   string `json:"leader,omitempty"`
+	ReadMode string `json:"readMode,omitempty"`
+}
+
+// Simulation runs Raft replicating a register that two clients write and
+// read, with the optimizations that can be toggled on. In "rejoin" a
+// follower cut off from the others keeps standing for election, raising
+// its term each time; when it rejoins, its term forces the healthy leader
+// to step down. In "rejoin_prevote" it first asks for pre-votes, which
+// nobody grants while they hear from the leader, so its term stays put
+// and it rejoins quietly. The read scenarios cut the leader off with one
+// of the clients while the majority elects a new leader: in
+// "local_reads" the old leader keeps answering reads from its own state,
+// which goes stale; in "read_index" it answers only after a majority
+// confirms it still leads, which never happens; in "lease_reads" it
+// answers from its state while its lease lasts, which ends before
+// anyone else can be elected.
+type Simulation struct {
+	mu sync.RWMutex
+
+	engine    *engine.Engine
+	rng       *rand.Rand
+	transport *transport.NetworkTransport
+	broadcast func(interface{})
+
+	nodes    []*Node
+	clients  []*Client
+	scenario string
+
+	preVote  bool
+	reads    string // "log", "read_index", "lease", "local"
+	isolated map[string]bool
+	cut      []string // Nodes on the small side of the scheduled partition
+
+	leaders     map[int]string // Term -> leader elected in it
+	splitBrain  int            // Terms in which two leaders were elected
+	elections   int
+	disruptions int
+	maxTerm     int
+
+	values   int // Last value written
+	acked    int // Highest log index of a write acknowledged to a client
+	served   map[string]int
+	stale    int
+	timeouts int
+	messages int
+	lastTick int
+
+	running bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// Config for Raft simulation
+type Config struct {
+	NodeCount int    // Servers
+	Scenario  string // "rejoin", "rejoin_prevote", "local_reads", "read_index", "lease_reads"
+}
+
+// NewSimulation creates a new Raft simulation
+func NewSimulation(eng *engine.Engine, trans *transport.NetworkTransport, broadcast func(interface{}), config Config) *Simulation {
+	sim := &Simulation{
+		engine:    eng,
+		rng:       eng.Rand(),
+		transport: trans,
+		broadcast: broadcast,
+		reads:     "log",
+		isolated:  make(map[string]bool),
+		leaders:   make(map[int]string),
+		served:    make(map[string]int),
+	}
+	switch config.Scenario {
+	case "rejoin":
+	case "rejoin_prevote":
+		sim.preVote = true
+	case "local_reads":
+		sim.reads = "local"
+	case "read_index":
+		sim.reads = "read_index"
+	case "lease_reads":
+		sim.reads = "lease"
+	default:
+		config.Scenario = "rejoin"
+	}
+	sim.scenario = config.Scenario
+	if config.NodeCount == 0 {
+		config.NodeCount = 5
+	}
+	config.NodeCount = max(config.NodeCount, 3)
+
+	trans.SetLatency(20*time.Millisecond, 80*time.Millisecond)
+	trans.SetPacketLoss(0)
+
+	ids := make([]string, config.NodeCount)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("node-%d", i+1)
+	}
+	for _, id := range ids {
+		peers := make([]string, 0, len(ids)-1)
+		for _, peer := range ids {
+			if peer != id {
+				peers = append(peers, peer)
+			}
+		}
+		node := newNode(id, peers, sim)
+		sim.nodes = append(sim.nodes, node)
+		trans.RegisterHandler(id, node.handleMessage)
+		eng.AddNode(node)
+	}
+	for i := 1; i <= 2; i++ {
+		client := newClient(fmt.Sprintf("client-%d", i), ids, sim)
+		sim.clients = append(sim.clients, client)
+		trans.RegisterHandler(client.id, client.handleMessage)
+		eng.AddNode(client)
+	}
+	eng.RegisterInvariant("election safety", sim.electionSafety)
+
+	return sim
+}
+
+// Start starts the simulation
+func (s *Simulation) Start(ctx context.Context) error {
+	s.mu.Lock()
+	s.running = true
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.mu.Unlock()
+
+	return s.engine.Start(ctx)
+}
+
+// Stop stops the simulation
+func (s *Simulation) Stop() error {
+	s.mu.Lock()
+	s.running = false
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Unlock()
+
+	return s.engine.Stop()
+}
+
+// GetState returns the current simulation state
+func (s *Simulation) GetState() *protocol.SimulationStateResponse {
+	nodes := make(map[string]protocol.NodeState)
+	leaders := make([]string, 0, 1)
+	for _, node := range s.nodes {
+		state := node.GetState()
+		nodes[node.id] = protocol.NodeState{
+			ID:          node.id,
+			Status:      state["status"].(string),
+			Role:        state["role"].(string),
+			CustomState: state,
+		}
+		if state["role"] == "leader" {
+			leaders = append(leaders, node.id)
+		}
+	}
+	for _, client := range s.clients {
+		state := client.GetState()
+		nodes[client.id] = protocol.NodeState{
+			ID:          client.id,
+			Status:      state["status"].(string),
+			Role:        "client",
+			CustomState: state,
+		}
+	}
+
+	s.mu.RLock()
+	running := s.running
+	isolated := make([]string, 0, len(s.isolated))
+	for id := range s.isolated {
+		isolated = append(isolated, id)
+	}
+	sort.Strings(isolated)
+	metadata := map[string]interface{}{
+		"scenario":     s.scenario,
+		"preVote":      s.preVote,
+		"readMode":     s.reads,
+		"leaders":      leaders, // Nodes that believe they lead; two during a partition
+		"term":         s.maxTerm,
+		"elections":    s.elections,
+		"disruptions":  s.disruptions,
+		"partitioned":  append([]string{}, s.cut...),
+		"isolated":     isolated,
+		"acknowledged": s.acked,
+		"readsServed":  copyCounts(s.served), // By how they were served
+		"staleReads":   s.stale,
+		"timeouts":     s.timeouts,
+		"messages":     s.messages,
+	}
+	s.mu.RUnlock()
+
+	mode := "step"
+	if s.engine != nil {
+		mode = s.engine.GetMode().String()
+	}
+
+	return &protocol.SimulationStateResponse{
+		Type:        protocol.MsgSimulationState,
+		VirtualTime: time.Now().UnixMilli(),
+		Mode:        mode,
+		Speed:       1.0,
+		Running:     running,
+		Nodes:       nodes,
+		Metadata:    metadata,
+	}
+}
+
+// GetNodes returns node states
+func (s *Simulation) GetNodes() map[string]protocol.NodeState {
+	state := s.GetState()
+	return state.Nodes
+}
+
+// Layout draws the servers and the clients as two groups
+func (s *Simulation) Layout() *protocol.Layout {
+	servers := make([]string, len(s.nodes))
+	for i, node := range s.nodes {
+		servers[i] = node.id
+	}
+	clients := make([]string, len(s.clients))
+	for i, client := range s.clients {
+		clients[i] = client.id
+	}
+	return &protocol.Layout{
+		Kind: protocol.LayoutGroups,
+		Groups: []protocol.LayoutGroup{
+			{Name: "servers", Nodes: servers},
+			{Name: "clients", Nodes: clients},
+		},
+	}
+}
+
+// CrashNode crashes a server, which keeps its term, vote and log, or a
+// client
+func (s *Simulation) CrashNode(nodeID string) error {
+	if node := s.findNode(nodeID); node != nil {
+		node.mu.Lock()
+		node.status = "crashed"
+		node.become("follower")
+		node.mu.Unlock()
+		return nil
+	}
+	if client := s.findClient(nodeID); client != nil {
+		client.mu.Lock()
+		client.status = "crashed"
+		client.pending = nil
+		client.mu.Unlock()
+		return nil
+	}
+	return fmt.Errorf("unknown node: %s", nodeID)
+}
+
+// RecoverNode recovers a crashed server, as a follower, or client
+func (s *Simulation) RecoverNode(nodeID string) error {
+	if node := s.findNode(nodeID); node != nil {
+		node.mu.Lock()
+		node.status = "running"
+		node.resetDeadline()
+		node.mu.Unlock()
+		return nil
+	}
+	if client := s.findClient(nodeID); client != nil {
+		client.mu.Lock()
+		client.status = "running"
+		client.mu.Unlock()
+		return nil
+	}
+	return fmt.Errorf("unknown node: %s", nodeID)
+}
+
+// HandleClientRequest runs a client command: "configure" turns PreVote
+// on or off, {preVote}, and picks how reads are served, {readMode: "log",
+// "read_index", "lease" or "local"}
+func (s *Simulation) HandleClientRequest(command string, payload map[string]interface{}) error {
+	if command != "configure" {
+		return fmt.Errorf("unknown command: %s", command)
+	}
+	readMode, hasReadMode := payload["readMode"].(string)
+	switch readMode {
+	case "", "log", "read_index", "lease", "local":
+	default:
+		return fmt.Errorf("unknown read mode: %q", readMode)
+	}
+	preVote, hasPreVote := payload["preVote"].(bool)
+
+	s.mu.Lock()
+	if hasReadMode && readMode != "" {
+		s.reads = readMode
+	}
+	if hasPreVote {
+		s.preVote = preVote
+	}
+	readMode, preVote = s.reads, s.preVote
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":     "raft_configured",
+		"preVote":  preVote,
+		"readMode": readMode,
+	})
+	return nil
+}
+
+// NodeActions lists the actions of a server: "isolate" cuts it off from
+// every other node, "rejoin" reconnects it
+func (s *Simulation) NodeActions(nodeID string) []string {
+	if s.findNode(nodeID) == nil {
+		return nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.isolated[nodeID] {
+		return []string{"rejoin"}
+	}
+	return []string{"isolate"}
+}
+
+// InvokeNodeAction invokes an action listed by NodeActions
+func (s *Simulation) InvokeNodeAction(nodeID, action string, params map[string]interface{}) error {
+	if s.findNode(nodeID) == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	switch action {
+	case "isolate":
+		s.isolate(nodeID, true)
+	case "rejoin":
+		s.isolate(nodeID, false)
+	default:
+		return fmt.Errorf("unknown action: %s", action)
+	}
+	return nil
+}
+
+// findNode looks up a server by ID
+func (s *Simulation) findNode(nodeID string) *Node {
+	for _, node := range s.nodes {
+		if node.id == nodeID {
+			return node
+		}
+	}
+	return nil
+}
+
+// findClient looks up a client by ID
+func (s *Simulation) findClient(nodeID string) *Client {
+	for _, client := range s.clients {
+		if client.id == nodeID {
+			return client
+		}
+	}
+	return nil
+}
+
+// everyone returns the IDs of the servers and the clients
+func (s *Simulation) everyone() []string {
+	ids := make([]string, 0, len(s.nodes)+len(s.clients))
+	for _, node := range s.nodes {
+		ids = append(ids, node.id)
+	}
+	for _, client := range s.clients {
+		ids = append(ids, client.id)
+	}
+	return ids
+}
+
+// isolate cuts a node off from every other node, or reconnects it
+func (s *Simulation) isolate(nodeID string, cut bool) {
+	s.mu.Lock()
+	if cut {
+		s.isolated[nodeID] = true
+	} else {
+		delete(s.isolated, nodeID)
+	}
+	s.mu.Unlock()
+
+	for _, other := range s.everyone() {
+		if other == nodeID {
+			continue
+		}
+		if cut {
+			s.transport.CreateBidirectionalPartition(nodeID, other)
+		} else {
+			s.transport.ClearBidirectionalPartition(nodeID, other)
+		}
+	}
+	eventType := "node_isolated"
+	if !cut {
+		eventType = "node_rejoined"
+	}
+	s.broadcast(map[string]interface{}{
+		"type":   eventType,
+		"nodeId": nodeID,
+	})
+}
+
+// split cuts a group of nodes off from the rest, or heals the cut
+func (s *Simulation) split(group []string, cut bool) {
+	inGroup := make(map[string]bool, len(group))
+	for _, id := range group {
+		inGroup[id] = true
+	}
+	rest := make([]string, 0)
+	for _, id := range s.everyone() {
+		if !inGroup[id] {
+			rest = append(rest, id)
+		}
+	}
+	for _, a := range group {
+		for _, b := range rest {
+			if cut {
+				s.transport.CreateBidirectionalPartition(a, b)
+			} else {
+				s.transport.ClearBidirectionalPartition(a, b)
+			}
+		}
+	}
+	eventType := "partition_created"
+	if !cut {
+		eventType = "partition_healed"
+	}
+	s.broadcast(map[string]interface{}{
+		"type":   eventType,
+		"groups": [][]string{group, rest},
+	})
+}
+
+// currentLeader returns a server that believes it leads, if any
+func (s *Simulation) currentLeader() *Node {
+	for _, node := range s.nodes {
+		node.mu.RLock()
+		leads := node.state == "leader" && node.status == "running"
+		node.mu.RUnlock()
+		if leads {
+			return node
+		}
+	}
+	return nil
+}
+
+// advanceSchedule applies the partitions of the scenario, once per tick
+func (s *Simulation) advanceSchedule(ticks int) {
+	s.mu.Lock()
+	if ticks <= s.lastTick {
+		s.mu.Unlock()
+		return
+	}
+	s.lastTick = ticks
+	cut := s.cut
+	s.mu.Unlock()
+
+	switch s.scenario {
+	case "rejoin", "rejoin_prevote":
+		switch ticks {
+		case isolateAt:
+			// A follower: the leader is the last node to pick
+			victim := s.nodes[len(s.nodes)-1]
+			if victim == s.currentLeader() {
+				victim = s.nodes[0]
+			}
+			s.mu.Lock()
+			s.cut = []string{victim.id}
+			s.mu.Unlock()
+			s.isolate(victim.id, true)
+		case rejoinAt:
+			if len(cut) > 0 {
+				s.isolate(cut[0], false)
+			}
+		}
+	default:
+		switch {
+		case ticks >= partitionAt && ticks < healAt && cut == nil:
+			// The leader, and the client that keeps reading from it
+			leader := s.currentLeader()
+			if leader == nil {
+				return // Not elected yet; try again next tick
+			}
+			group := []string{leader.id, s.clients[1].id}
+			s.mu.Lock()
+			s.cut = group
+			s.mu.Unlock()
+			s.split(group, true)
+		case ticks == healAt && cut != nil:
+			s.split(cut, false)
+		}
+	}
+}
+
+// preVoteEnabled reports whether servers ask for pre-votes
+func (s *Simulation) preVoteEnabled() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.preVote
+}
+
+// readMode returns how leaders serve reads
+func (s *Simulation) readMode() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.reads
+}
+
+// nextValue returns a new value for a client to write
+func (s *Simulation) nextValue() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.values++
+	return s.values
+}
+
+// acknowledged returns the highest index of a write acknowledged so far
+func (s *Simulation) acknowledged() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.acked
+}
+
+// writeAcknowledged records a write acknowledged to a client
+func (s *Simulation) writeAcknowledged(index int) {
+	s.mu.Lock()
+	s.acked = max(s.acked, index)
+	s.mu.Unlock()
+}
+
+// readServed records a read and reports it if it missed a write
+// acknowledged before it started
+func (s *Simulation) readServed(clientID, nodeID, how string, index, snapshot int) {
+	s.mu.Lock()
+	s.served[how]++
+	stale := index < snapshot
+	if stale {
+		s.stale++
+	}
+	s.mu.Unlock()
+
+	if stale {
+		s.broadcast(map[string]interface{}{
+			"type":     "stale_read",
+			"nodeId":   nodeID,
+			"client":   clientID,
+			"readMode": how,
+			"index":    index,    // Write the value came from
+			"expected": snapshot, // Write acknowledged before the read started
+		})
+	}
+}
+
+// clientTimedOut reports a client giving up on a server
+func (s *Simulation) clientTimedOut(clientID, nodeID, op string) {
+	s.mu.Lock()
+	s.timeouts++
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":   "client_timeout",
+		"nodeId": nodeID,
+		"client": clientID,
+		"op":     op,
+	})
+}
+
+// stateChanged reports a server moving between follower, pre-candidate,
+// candidate and leader
+func (s *Simulation) stateChanged(nodeID, from, to string, term int) {
+	s.mu.Lock()
+	s.maxTerm = max(s.maxTerm, term)
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":   "raft_state",
+		"nodeId": nodeID,
+		"from":   from,
+		"to":     to,
+		"term":   term,
+	})
+}
+
+// electionStarted counts an election, a term some node stood in
+func (s *Simulation) electionStarted(nodeID string, term int) {
+	s.mu.Lock()
+	s.elections++
+	s.maxTerm = max(s.maxTerm, term)
+	s.mu.Unlock()
+}
+
+// leaderElected records the leader of a term, checking that it is the
+// only one
+func (s *Simulation) leaderElected(nodeID string, term int) {
+	s.mu.Lock()
+	if other, ok := s.leaders[term]; ok && other != nodeID {
+		s.splitBrain++
+	}
+	s.leaders[term] = nodeID
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":   "leader_elected",
+		"nodeId": nodeID,
+		"term":   term,
+	})
+}
+
+// electionSafety is checked after every tick: at most one leader is
+// elected in a term
+func (s *Simulation) electionSafety() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.splitBrain > 0 {
+		return fmt.Errorf("%d terms elected two leaders", s.splitBrain)
+	}
+	return nil
+}
+
+// disrupted reports a leader in touch with a majority stepping down for
+// a node with a higher term
+func (s *Simulation) disrupted(nodeID, by string, term, newTerm int) {
+	s.mu.Lock()
+	s.disruptions++
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":    "leader_disrupted",
+		"nodeId":  nodeID,
+		"by":      by,
+		"term":    term,
+		"newTerm": newTerm,
+	})
+}
+
+// send transmits a message and reports it to the frontend
+func (s *Simulation) send(from, to string, msgType transport.MessageType, payload Payload) {
+	env := transport.NewEnvelope(from, to, msgType, payload)
+
+	s.mu.Lock()
+	s.messages++
+	s.mu.Unlock()
+
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageSent,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+
+	s.transport.Send(s.ctx, env)
+}
+
+// received reports a delivered message and returns its payload
+func (s *Simulation) received(env *transport.Envelope) Payload {
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageReceived,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+	payload, _ := env.Payload.(Payload)
+	return payload
+}
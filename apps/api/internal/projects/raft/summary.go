@@ -0,0 +1,85 @@
+package raft
+
+import (
+	"fmt"
+
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+)
+
+// Invariants checks that reads see every acknowledged write, that a
+// rejoining node leaves the leader alone, and that terms and logs agree
+func (s *Simulation) Invariants() []protocol.InvariantResult {
+	// Committed prefixes first: node locks come before the simulation's
+	var longest []int
+	diverged := ""
+	for _, node := range s.nodes {
+		terms := node.committed()
+		shorter, longer := terms, longest
+		if len(shorter) > len(longer) {
+			shorter, longer = longer, shorter
+		}
+		for i := range shorter {
+			if shorter[i] != longer[i] && diverged == "" {
+				diverged = fmt.Sprintf("%s disagrees at index %d", node.id, i)
+			}
+		}
+		if len(terms) > len(longest) {
+			longest = terms
+		}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	reads := 0
+	for _, count := range s.served {
+		reads += count
+	}
+	logs := fmt.Sprintf("%d terms elected, committed logs agree up to index %d", len(s.leaders), len(longest)-1)
+	if diverged != "" {
+		logs = diverged
+	}
+	return []protocol.InvariantResult{
+		{
+			Name:   "reads are linearizable",
+			Holds:  s.stale == 0,
+			Detail: fmt.Sprintf("%d of %d reads missed an acknowledged write (read mode %s)", s.stale, reads, s.reads),
+		},
+		{
+			Name:   "rejoining nodes do not disrupt the leader",
+			Holds:  s.disruptions == 0,
+			Detail: fmt.Sprintf("%d healthy leaders deposed, %d elections, term %d (PreVote %t)", s.disruptions, s.elections, s.maxTerm, s.preVote),
+		},
+		{
+			Name:   "election safety and log matching",
+			Holds:  s.splitBrain == 0 && diverged == "",
+			Detail: fmt.Sprintf("%d terms with two leaders; %s", s.splitBrain, logs),
+		},
+	}
+}
+
+// FollowUps suggests the scenarios that contrast with this one
+func (s *Simulation) FollowUps() []protocol.FollowUp {
+	switch s.scenario {
+	case "rejoin":
+		return []protocol.FollowUp{
+			{Project: "raft", Scenario: "rejoin_prevote", Reason: "Ask for pre-votes so the rejoining node cannot raise the term"},
+			{Project: "election", Reason: "Compare with leader election on its own"},
+		}
+	case "rejoin_prevote":
+		return []protocol.FollowUp{
+			{Project: "raft", Scenario: "rejoin", Reason: "Turn PreVote off and watch the rejoining node depose the leader"},
+			{Project: "raft", Scenario: "local_reads", Reason: "Serve reads from a partitioned leader"},
+		}
+	case "local_reads":
+		return []protocol.FollowUp{
+			{Project: "raft", Scenario: "read_index", Reason: "Confirm leadership with a majority before each read"},
+			{Project: "raft", Scenario: "lease_reads", Reason: "Serve reads locally only while the leader's lease lasts"},
+		}
+	default:
+		return []protocol.FollowUp{
+			{Project: "raft", Scenario: "local_reads", Reason: "Serve reads from local state and see them go stale"},
+			{Project: "percolator", Reason: "Build transactions on top of a linearizable store"},
+		}
+	}
+}
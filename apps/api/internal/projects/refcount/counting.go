@@ -0,0 +1,88 @@
+package refcount
+
+// Naive reference counting: the owner counts references. A copy is an
+// increment sent to the owner together with the reference sent to the
+// new holder, a drop is a decrement. The two go to different nodes, so
+// the new holder may drop its copy and its decrement reach the owner
+// before the increment: the count hits zero while the sender still holds
+// its reference.
+//
+// Weighted reference counting: every object starts with a weight the
+// owner remembers and its creator holds. Copying splits the holder's
+// weight and hands half of it over, so the sum of all weights held
+// always equals what the owner remembers and no message to the owner is
+// needed. A drop returns the weight; the owner collects the object when
+// all of it is back. A weight of 1 cannot be split; real systems then
+// ask the owner for more or copy an indirection, here the copy is
+// refused.
+const initialWeight = 1 << 16
+
+// copyRef hands a copy of a reference to another node (must hold n.mu)
+func (n *Node) copyRef(object, to string) {
+	sim := n.simulation
+
+	amount := 1
+	if sim.weighted {
+		weight := n.refs[object]
+		if weight < 2 {
+			sim.broadcast(map[string]interface{}{
+				"type":   "weight_exhausted",
+				"nodeId": n.id,
+				"object": object,
+			})
+			return
+		}
+		amount = weight / 2
+		n.refs[object] -= amount
+	} else if owner(object) == n.id {
+		n.increment(object, 1)
+	} else {
+		n.increments++
+		n.deliver(owner(object), MsgIncrement, Payload{Object: object, Amount: 1}, sim.reliable)
+	}
+
+	// The copy itself is application data and always retried
+	n.deliver(to, MsgCopy, Payload{Object: object, Amount: amount}, true)
+}
+
+// drop releases every reference to an object this node holds (must hold
+// n.mu)
+func (n *Node) drop(object string) {
+	amount := n.refs[object]
+	delete(n.refs, object)
+
+	if owner(object) == n.id {
+		n.decrement(object, amount)
+		return
+	}
+	n.decrements++
+	n.deliver(owner(object), MsgDecrement, Payload{Object: object, Amount: amount}, n.simulation.reliable)
+}
+
+// increment counts new references to an owned object (must hold n.mu)
+func (n *Node) increment(object string, amount int) {
+	if obj, ok := n.objects[object]; ok {
+		obj.Count += amount
+	}
+}
+
+// decrement uncounts released references and collects the object once
+// none are left (must hold n.mu)
+func (n *Node) decrement(object string, amount int) {
+	obj, ok := n.objects[object]
+	if !ok {
+		return
+	}
+	obj.Count -= amount
+	if obj.Count > 0 || obj.Collected {
+		return
+	}
+	obj.Collected = true
+
+	n.simulation.broadcast(map[string]interface{}{
+		"type":   "object_collected",
+		"nodeId": n.id,
+		"object": object,
+		"count":  obj.Count,
+	})
+}
@@ -0,0 +1,300 @@
+package refcount
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+const (
+	MsgCopy      transport.MessageType = "copy"
+	MsgIncrement transport.MessageType = "increment"
+	MsgDecrement transport.MessageType = "decrement"
+	MsgAck       transport.MessageType = "ack"
+	MsgAccess    transport.MessageType = "access"
+	MsgValue     transport.MessageType = "value"
+)
+
+const (
+	retryTicks = 8 // Ticks before an unacknowledged message is sent again
+	maxRefs    = 4 // References a node holds before it stops creating objects
+)
+
+// Object is an object as its owner sees it
+type Object struct {
+	ID        string
+	Count     int // References, or outstanding weight when weighted
+	Collected bool
+}
+
+// unacked is a message retried until the receiver acknowledges it
+type unacked struct {
+	to      string
+	msgType transport.MessageType
+	payload Payload
+	sentAt  int
+}
+
+// Node owns the objects it creates and runs a mutator that creates
+// objects, copies references to other nodes, drops them and uses them
+type Node struct {
+	mu sync.RWMutex
+
+	id      string
+	status  string
+	ticks   int
+	nodeIDs []string
+
+	objects map[string]*Object // Owned objects, collected ones included
+	refs    map[string]int     // Object -> references held, or weight
+	created int
+	nextAct int
+
+	seq       int
+	unacked   map[string]*unacked
+	delivered map[string]bool // IDs of retried messages already applied
+
+	increments       int
+	decrements       int
+	danglingAccesses int
+
+	inbox      chan *transport.Envelope
+	simulation *Simulation
+}
+
+func newNode(id string, nodeIDs []string, sim *Simulation) *Node {
+	return &Node{
+		id:         id,
+		status:     "running",
+		nodeIDs:    nodeIDs,
+		objects:    make(map[string]*Object),
+		refs:       make(map[string]int),
+		nextAct:    3 + sim.rng.Intn(3),
+		unacked:    make(map[string]*unacked),
+		delivered:  make(map[string]bool),
+		inbox:      make(chan *transport.Envelope, 500),
+		simulation: sim,
+	}
+}
+
+// Node implements engine.NodeController
+
+func (n *Node) ID() string {
+	return n.id
+}
+
+func (n *Node) Start(ctx context.Context) error {
+	return nil
+}
+
+func (n *Node) Stop() error {
+	return nil
+}
+
+func (n *Node) Tick() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.ticks++
+	if n.status != "running" {
+		return
+	}
+
+	for i := 0; i < 50; i++ {
+		select {
+		case env := <-n.inbox:
+			n.processMessage(env)
+			continue
+		default:
+		}
+		break
+	}
+
+	for _, msg := range n.unacked {
+		if n.ticks-msg.sentAt >= retryTicks {
+			msg.sentAt = n.ticks
+			n.simulation.send(n.id, msg.to, msg.msgType, msg.payload)
+		}
+	}
+
+	if n.ticks >= n.nextAct {
+		n.nextAct = n.ticks + 2 + n.simulation.rng.Intn(4)
+		n.mutate()
+	}
+}
+
+func (n *Node) GetState() map[string]interface{} {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	refs := make(map[string]int, len(n.refs))
+	for object, amount := range n.refs {
+		refs[object] = amount
+	}
+	objects := make(map[string]map[string]interface{}, len(n.objects))
+	for id, obj := range n.objects {
+		objects[id] = map[string]interface{}{
+			"count":     obj.Count,
+			"collected": obj.Collected,
+		}
+	}
+	copying := make([]string, 0)
+	for _, msg := range n.unacked {
+		if msg.msgType == MsgCopy {
+			copying = append(copying, msg.payload.Object)
+		}
+	}
+	sort.Strings(copying)
+
+	return map[string]interface{}{
+		"id":               n.id,
+		"status":           n.status,
+		"refs":             refs,
+		"objects":          objects,
+		"copying":          copying,
+		"unacked":          len(n.unacked),
+		"increments":       n.increments,
+		"decrements":       n.decrements,
+		"danglingAccesses": n.danglingAccesses,
+	}
+}
+
+func (n *Node) handleMessage(env *transport.Envelope) {
+	n.mu.RLock()
+	crashed := n.status != "running"
+	n.mu.RUnlock()
+
+	// Crashed nodes do not receive anything
+	if crashed {
+		return
+	}
+	select {
+	case n.inbox <- env:
+	default:
+	}
+}
+
+func (n *Node) processMessage(env *transport.Envelope) {
+	payload := n.simulation.received(env)
+
+	if env.Type == MsgAck {
+		delete(n.unacked, payload.ID)
+		return
+	}
+
+	// Retried messages are acknowledged every time but applied once
+	if payload.ID != "" {
+		n.simulation.send(n.id, env.From, MsgAck, Payload{ID: payload.ID})
+		if n.delivered[payload.ID] {
+			return
+		}
+		n.delivered[payload.ID] = true
+	}
+
+	switch env.Type {
+	case MsgCopy:
+		n.refs[payload.Object] += payload.Amount
+
+	case MsgIncrement:
+		n.increment(payload.Object, payload.Amount)
+
+	case MsgDecrement:
+		n.decrement(payload.Object, payload.Amount)
+
+	case MsgAccess:
+		obj, ok := n.objects[payload.Object]
+		n.simulation.send(n.id, env.From, MsgValue, Payload{
+			Object: payload.Object,
+			Gone:   !ok || obj.Collected,
+		})
+
+	case MsgValue:
+		if payload.Gone {
+			n.dangling(payload.Object)
+		}
+	}
+}
+
+// mutate runs one step of the application (must hold n.mu)
+func (n *Node) mutate() {
+	held := make([]string, 0, len(n.refs))
+	for object := range n.refs {
+		held = append(held, object)
+	}
+	sort.Strings(held)
+
+	roll := n.simulation.rng.Float64()
+	switch {
+	case len(held) == 0 || (roll < 0.2 && len(held) < maxRefs):
+		n.create()
+	case roll < 0.6:
+		peers := make([]string, 0, len(n.nodeIDs)-1)
+		for _, peer := range n.nodeIDs {
+			if peer != n.id {
+				peers = append(peers, peer)
+			}
+		}
+		n.copyRef(held[n.simulation.rng.Intn(len(held))], peers[n.simulation.rng.Intn(len(peers))])
+	case roll < 0.85:
+		n.drop(held[n.simulation.rng.Intn(len(held))])
+	default:
+		n.access(held[n.simulation.rng.Intn(len(held))])
+	}
+}
+
+// create allocates an object held by its creator (must hold n.mu)
+func (n *Node) create() {
+	n.created++
+	id := fmt.Sprintf("%s/obj-%d", n.id, n.created)
+	amount := 1
+	if n.simulation.weighted {
+		amount = initialWeight
+	}
+	n.objects[id] = &Object{ID: id, Count: amount}
+	n.refs[id] = amount
+
+	n.simulation.broadcast(map[string]interface{}{
+		"type":   "object_created",
+		"nodeId": n.id,
+		"object": id,
+	})
+}
+
+// access uses a referenced object, which fails if the owner collected it
+// (must hold n.mu)
+func (n *Node) access(object string) {
+	if owner(object) != n.id {
+		n.simulation.send(n.id, owner(object), MsgAccess, Payload{Object: object})
+		return
+	}
+	if n.objects[object].Collected {
+		n.dangling(object)
+	}
+}
+
+// dangling reports an access to a collected object (must hold n.mu)
+func (n *Node) dangling(object string) {
+	if _, ok := n.refs[object]; !ok {
+		return // Dropped meanwhile, nothing was lost
+	}
+	n.danglingAccesses++
+	n.simulation.broadcast(map[string]interface{}{
+		"type":   "dangling_reference",
+		"nodeId": n.id,
+		"object": object,
+	})
+}
+
+// deliver sends a message, retrying it until acknowledged if reliable
+// (must hold n.mu)
+func (n *Node) deliver(to string, msgType transport.MessageType, payload Payload, reliable bool) {
+	if reliable {
+		n.seq++
+		payload.ID = fmt.Sprintf("%s-%d", n.id, n.seq)
+		n.unacked[payload.ID] = &unacked{to: to, msgType: msgType, payload: payload, sentAt: n.ticks}
+	}
+	n.simulation.send(n.id, to, msgType, payload)
+}
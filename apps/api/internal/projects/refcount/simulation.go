@@ -0,0 +1,294 @@
+package refcount
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+	"github.com/ersantana/distributed-systems-learning/packages/simulation/engine"
+)
+
+// Payload is the content of all messages exchanged in this project
+type Payload struct {
+	ID     string `json:"id,omitempty"` // Set on messages that are retried until acknowledged
+	Object string `json:"object,omitempty"`
+	Amount int    `json:"amount,omitempty"` // References or weight
+	Gone   bool   `json:"gone,omitempty"`
+}
+
+// Simulation runs distributed garbage collection by reference counting.
+// Every node owns the objects it creates and keeps their count; the other
+// nodes hold references, pass copies of them around and drop them. The
+// owner collects an object once its count drops to zero.
+//
+// In the "naive" scenario holders tell the owner about every copy and
+// drop with fire-and-forget increments and decrements: a lost decrement
+// leaks the object, a lost increment frees it while still referenced.
+// "naive_reliable" retries them until acknowledged, which stops the
+// leaks but not the premature collections, since a decrement can still
+// overtake the increment for the copy it releases. "weighted" uses
+// weighted reference counting, which needs no increments at all.
+type Simulation struct {
+	mu sync.RWMutex
+
+	engine    *engine.Engine
+	rng       *rand.Rand
+	transport *transport.NetworkTransport
+	broadcast func(interface{})
+
+	nodes    []*Node
+	scenario string
+	weighted bool
+	reliable bool // Counting messages are retried until acknowledged
+
+	running bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// Config for reference counting simulation
+type Config struct {
+	NodeCount  int
+	Scenario   string  // "naive", "naive_reliable", "weighted"
+	PacketLoss float64 // Defaults to 10%
+}
+
+// NewSimulation creates a new reference counting simulation
+func NewSimulation(eng *engine.Engine, trans *transport.NetworkTransport, broadcast func(interface{}), config Config) *Simulation {
+	switch config.Scenario {
+	case "naive", "naive_reliable", "weighted":
+	default:
+		config.Scenario = "naive"
+	}
+	if config.NodeCount == 0 {
+		config.NodeCount = 4
+	}
+	if config.PacketLoss == 0 {
+		config.PacketLoss = 0.1
+	}
+
+	sim := &Simulation{
+		engine:    eng,
+		rng:       eng.Rand(),
+		transport: trans,
+		broadcast: broadcast,
+		scenario:  config.Scenario,
+		weighted:  config.Scenario == "weighted",
+		reliable:  config.Scenario != "naive",
+	}
+
+	// Jitter larger than a tick lets messages overtake each other
+	trans.SetLatency(20*time.Millisecond, 150*time.Millisecond)
+	trans.SetPacketLoss(config.PacketLoss)
+
+	nodeIDs := make([]string, config.NodeCount)
+	for i := range nodeIDs {
+		nodeIDs[i] = fmt.Sprintf("node-%d", i+1)
+	}
+	for _, id := range nodeIDs {
+		node := newNode(id, nodeIDs, sim)
+		sim.nodes = append(sim.nodes, node)
+		trans.RegisterHandler(id, node.handleMessage)
+		eng.AddNode(node)
+	}
+
+	return sim
+}
+
+// Start starts the simulation
+func (s *Simulation) Start(ctx context.Context) error {
+	s.mu.Lock()
+	s.running = true
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.mu.Unlock()
+
+	return s.engine.Start(ctx)
+}
+
+// Stop stops the simulation
+func (s *Simulation) Stop() error {
+	s.mu.Lock()
+	s.running = false
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Unlock()
+
+	return s.engine.Stop()
+}
+
+// GetState returns the current simulation state. Garbage and dangling
+// references are found by looking at every node at once, which the
+// nodes themselves cannot do.
+func (s *Simulation) GetState() *protocol.SimulationStateResponse {
+	nodes := make(map[string]protocol.NodeState)
+	held := make(map[string]bool)     // Objects some node holds a reference to
+	inFlight := make(map[string]bool) // Objects in a copy not yet acknowledged
+	collected := make(map[string]bool)
+	live := make(map[string]bool)
+	danglingAccesses, increments, decrements := 0, 0, 0
+	for _, node := range s.nodes {
+		state := node.GetState()
+		nodes[node.id] = protocol.NodeState{
+			ID:          node.id,
+			Status:      state["status"].(string),
+			Role:        "node",
+			CustomState: state,
+		}
+		for object := range state["refs"].(map[string]int) {
+			held[object] = true
+		}
+		for _, object := range state["copying"].([]string) {
+			inFlight[object] = true
+		}
+		for object, info := range state["objects"].(map[string]map[string]interface{}) {
+			if info["collected"].(bool) {
+				collected[object] = true
+			} else {
+				live[object] = true
+			}
+		}
+		danglingAccesses += state["danglingAccesses"].(int)
+		increments += state["increments"].(int)
+		decrements += state["decrements"].(int)
+	}
+
+	// Garbage: nobody can reach the object but its owner still keeps it;
+	// includes objects whose last decrement is still on its way
+	garbage := make([]string, 0)
+	for object := range live {
+		if !held[object] && !inFlight[object] {
+			garbage = append(garbage, object)
+		}
+	}
+	sort.Strings(garbage)
+
+	// Dangling: collected while a node still holds a reference
+	dangling := make([]string, 0)
+	for object := range collected {
+		if held[object] {
+			dangling = append(dangling, object)
+		}
+	}
+	sort.Strings(dangling)
+
+	s.mu.RLock()
+	running := s.running
+	s.mu.RUnlock()
+
+	mode := "step"
+	if s.engine != nil {
+		mode = s.engine.GetMode().String()
+	}
+
+	return &protocol.SimulationStateResponse{
+		Type:        protocol.MsgSimulationState,
+		VirtualTime: time.Now().UnixMilli(),
+		Mode:        mode,
+		Speed:       1.0,
+		Running:     running,
+		Nodes:       nodes,
+		Metadata: map[string]interface{}{
+			"scenario":         s.scenario,
+			"weighted":         s.weighted,
+			"reliable":         s.reliable,
+			"liveObjects":      len(live),
+			"collected":        len(collected),
+			"garbage":          garbage,
+			"dangling":         dangling,
+			"danglingAccesses": danglingAccesses,
+			"increments":       increments,
+			"decrements":       decrements,
+		},
+	}
+}
+
+// GetNodes returns node states
+func (s *Simulation) GetNodes() map[string]protocol.NodeState {
+	state := s.GetState()
+	return state.Nodes
+}
+
+// Layout places the nodes on a ring
+func (s *Simulation) Layout() *protocol.Layout {
+	order := make([]string, len(s.nodes))
+	for i, node := range s.nodes {
+		order[i] = node.id
+	}
+	return &protocol.Layout{Kind: protocol.LayoutRing, Order: order}
+}
+
+// CrashNode crashes a node; its objects and references survive
+func (s *Simulation) CrashNode(nodeID string) error {
+	node := s.findNode(nodeID)
+	if node == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	node.mu.Lock()
+	node.status = "crashed"
+	node.mu.Unlock()
+	return nil
+}
+
+// RecoverNode recovers a crashed node
+func (s *Simulation) RecoverNode(nodeID string) error {
+	node := s.findNode(nodeID)
+	if node == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	node.mu.Lock()
+	node.status = "running"
+	node.mu.Unlock()
+	return nil
+}
+
+// findNode looks up a node by ID; the node list is fixed after construction
+func (s *Simulation) findNode(nodeID string) *Node {
+	for _, node := range s.nodes {
+		if node.id == nodeID {
+			return node
+		}
+	}
+	return nil
+}
+
+// send transmits a message and reports it to the frontend
+func (s *Simulation) send(from, to string, msgType transport.MessageType, payload Payload) {
+	env := transport.NewEnvelope(from, to, msgType, payload)
+
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageSent,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+
+	s.transport.Send(s.ctx, env)
+}
+
+// received reports a delivered message and returns its payload
+func (s *Simulation) received(env *transport.Envelope) Payload {
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageReceived,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+	payload, _ := env.Payload.(Payload)
+	return payload
+}
+
+// owner returns the node that created an object
+func owner(object string) string {
+	return strings.SplitN(object, "/", 2)[0]
+}
@@ -0,0 +1,46 @@
+package refcount
+
+import (
+	"fmt"
+
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+)
+
+// Invariants judges the run: nothing reachable was collected, and nothing
+// unreachable is kept
+func (s *Simulation) Invariants() []protocol.InvariantResult {
+	metadata := s.GetState().Metadata
+	dangling := metadata["dangling"].([]string)
+	garbage := metadata["garbage"].([]string)
+	accesses := metadata["danglingAccesses"].(int)
+
+	return []protocol.InvariantResult{
+		{
+			Name:   "safety",
+			Holds:  len(dangling) == 0 && accesses == 0,
+			Detail: fmt.Sprintf("%d objects collected while referenced, %d accesses to collected objects", len(dangling), accesses),
+		},
+		{
+			Name:   "no leaks",
+			Holds:  len(garbage) == 0,
+			Detail: fmt.Sprintf("%d unreachable objects kept", len(garbage)),
+		},
+	}
+}
+
+// FollowUps suggests the scenarios that contrast with this one
+func (s *Simulation) FollowUps() []protocol.FollowUp {
+	switch s.scenario {
+	case "naive":
+		return []protocol.FollowUp{
+			{Project: "refcount", Scenario: "naive_reliable", Reason: "Retrying the counting messages stops the leaks, but not the premature collections"},
+		}
+	case "naive_reliable":
+		return []protocol.FollowUp{
+			{Project: "refcount", Scenario: "weighted", Reason: "Weighted counting needs no increments, so nothing can overtake them"},
+		}
+	}
+	return []protocol.FollowUp{
+		{Project: "refcount", Scenario: "naive", Reason: "See what goes wrong with plain increments and decrements over a lossy network"},
+	}
+}
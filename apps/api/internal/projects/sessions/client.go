@@ -0,0 +1,253 @@
+package sessions
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/ersantana/distributed-systems-learning/packages/core/clock"
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+const (
+	opTicks      = 3        // Ticks between the end of a request and the next one
+	requestTicks = 40       // Ticks before the client gives up on a replica
+	key          = "status" // The key the client writes and reads
+)
+
+// Guarantees are the session guarantees the client asks for. Each one
+// makes a request carry one of the session's vectors for the replica to
+// cover before serving it.
+type Guarantees struct {
+	ReadYourWrites    bool `json:"readYourWrites"`    // Reads cover the session's writes
+	MonotonicReads    bool `json:"monotonicReads"`    // Reads cover what the session read
+	MonotonicWrites   bool `json:"monotonicWrites"`   // Writes cover the session's writes
+	WritesFollowReads bool `json:"writesFollowReads"` // Writes cover what the session read
+}
+
+// Session is what a client remembers across replicas: the writes it made
+// and the writes behind what it read, each as a version vector
+type Session struct {
+	ReadVector  map[string]uint64 `json:"readVector"`
+	WriteVector map[string]uint64 `json:"writeVector"`
+}
+
+// Client is a mobile client that moves to the next replica after every
+// request, alternately writing a new value of a key and reading it. With
+// no guarantees each replica answers from whatever it has, which may not
+// include the client's own write, or may be older than the last replica
+// it read from.
+type Client struct {
+	mu sync.RWMutex
+
+	id     string
+	status string // "running" or "crashed"
+	ticks  int
+
+	session Session
+	replica int // Index of the replica the client is at
+	ops     int // Operations completed
+	seq     int // Requests sent, to match the replies
+	waiting bool
+	sentAt  int
+	nextAt  int
+
+	lastWritten string
+	lastRead    string
+
+	inbox      chan *transport.Envelope
+	simulation *Simulation
+}
+
+func newClient(id string, sim *Simulation) *Client {
+	return &Client{
+		id:     id,
+		status: "running",
+		session: Session{
+			ReadVector:  make(map[string]uint64),
+			WriteVector: make(map[string]uint64),
+		},
+		inbox:      make(chan *transport.Envelope, 500),
+		simulation: sim,
+	}
+}
+
+// Client implements engine.NodeController
+
+func (c *Client) ID() string {
+	return c.id
+}
+
+func (c *Client) Start(ctx context.Context) error {
+	return nil
+}
+
+func (c *Client) Stop() error {
+	return nil
+}
+
+func (c *Client) Tick() {
+	c.simulation.advanceSchedule(c.tick())
+}
+
+func (c *Client) tick() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ticks++
+	if c.status == "crashed" {
+		return c.ticks
+	}
+
+	for i := 0; i < 50; i++ {
+		select {
+		case env := <-c.inbox:
+			c.processMessage(env)
+			continue
+		default:
+		}
+		break
+	}
+
+	switch {
+	case !c.waiting && c.ticks >= c.nextAt:
+		c.request()
+	case c.waiting && c.ticks-c.sentAt >= requestTicks:
+		// The replica is down or cannot catch up: try the next one
+		c.simulation.gaveUp(c.id, c.simulation.replicas[c.replica].id)
+		c.moveOn()
+	}
+	return c.ticks
+}
+
+func (c *Client) GetState() map[string]interface{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return map[string]interface{}{
+		"id":          c.id,
+		"status":      c.status,
+		"role":        "client",
+		"replica":     c.simulation.replicas[c.replica].id,
+		"readVector":  copyVector(c.session.ReadVector),
+		"writeVector": copyVector(c.session.WriteVector),
+		"waiting":     c.waiting,
+		"lastWritten": c.lastWritten,
+		"lastRead":    c.lastRead,
+	}
+}
+
+func (c *Client) handleMessage(env *transport.Envelope) {
+	c.mu.RLock()
+	down := c.status == "crashed"
+	c.mu.RUnlock()
+
+	if down {
+		return
+	}
+	select {
+	case c.inbox <- env:
+	default:
+	}
+}
+
+// request sends the next operation to the current replica, with the part
+// of the session the guarantees require (must hold c.mu)
+func (c *Client) request() {
+	sim := c.simulation
+	guarantees := sim.currentGuarantees()
+	to := sim.replicas[c.replica].id
+	c.seq++
+	c.waiting = true
+	c.sentAt = c.ticks
+
+	required := make(map[string]uint64)
+	if c.ops%2 == 0 {
+		if guarantees.MonotonicWrites {
+			required = mergeVectors(required, c.session.WriteVector)
+		}
+		if guarantees.WritesFollowReads {
+			required = mergeVectors(required, c.session.ReadVector)
+		}
+		sim.send(c.id, to, MsgWrite, Payload{Seq: c.seq, Key: key, Value: fmt.Sprintf("v%d", c.ops/2+1), Requires: required})
+		return
+	}
+	if guarantees.ReadYourWrites {
+		required = mergeVectors(required, c.session.WriteVector)
+	}
+	if guarantees.MonotonicReads {
+		required = mergeVectors(required, c.session.ReadVector)
+	}
+	sim.send(c.id, to, MsgRead, Payload{Seq: c.seq, Key: key, Requires: required})
+}
+
+func (c *Client) processMessage(env *transport.Envelope) {
+	sim := c.simulation
+	payload := sim.received(env)
+	if !c.waiting || payload.Seq != c.seq || payload.Write == nil {
+		return
+	}
+
+	// Whatever the guarantees, check the answer against the session
+	replica := env.From
+	switch env.Type {
+	case MsgReadOK:
+		if !dominates(payload.Vector, c.session.WriteVector) {
+			sim.anomaly(c.id, "read_your_writes", replica, fmt.Sprintf("read %q after writing %q", payload.Write.Value, c.lastWritten))
+		}
+		if !dominates(payload.Vector, c.session.ReadVector) {
+			sim.anomaly(c.id, "monotonic_reads", replica, fmt.Sprintf("read %q after reading %q", payload.Write.Value, c.lastRead))
+		}
+		c.lastRead = payload.Write.Value
+		c.session.ReadVector = mergeVectors(c.session.ReadVector, payload.Vector)
+		sim.completed(false, c.ticks-c.sentAt)
+	case MsgWriteOK:
+		if !dominates(payload.Vector, c.session.WriteVector) {
+			sim.anomaly(c.id, "monotonic_writes", replica, fmt.Sprintf("wrote %q where %q is missing", payload.Write.Value, c.lastWritten))
+		}
+		if !dominates(payload.Vector, c.session.ReadVector) {
+			sim.anomaly(c.id, "writes_follow_reads", replica, fmt.Sprintf("wrote %q where %q, which it read, is missing", payload.Write.Value, c.lastRead))
+		}
+		c.lastWritten = payload.Write.Value
+		write := payload.Write
+		c.session.WriteVector[write.Origin] = max(c.session.WriteVector[write.Origin], write.Seq)
+		sim.completed(true, c.ticks-c.sentAt)
+	default:
+		return
+	}
+
+	c.ops++
+	c.moveOn()
+}
+
+// moveOn sends the next request to the next replica, as a phone changes
+// cell (must hold c.mu)
+func (c *Client) moveOn() {
+	c.waiting = false
+	c.replica = (c.replica + 1) % len(c.simulation.replicas)
+	c.nextAt = c.ticks + opTicks
+}
+
+// dominates reports whether vector a counts every write b counts
+func dominates(a, b map[string]uint64) bool {
+	relation := clock.CompareVectorClocks(a, b)
+	return relation == clock.HappensAfter || relation == clock.Equal
+}
+
+// mergeVectors returns the entry-wise maximum of two vectors
+func mergeVectors(a, b map[string]uint64) map[string]uint64 {
+	merged := copyVector(a)
+	for id, n := range b {
+		merged[id] = max(merged[id], n)
+	}
+	return merged
+}
+
+// copyVector returns a copy of a vector
+func copyVector(v map[string]uint64) map[string]uint64 {
+	copied := make(map[string]uint64, len(v))
+	for id, n := range v {
+		copied[id] = n
+	}
+	return copied
+}
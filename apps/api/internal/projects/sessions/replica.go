@@ -0,0 +1,234 @@
+package sessions
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ersantana/distributed-systems-learning/packages/core/clock"
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+const (
+	MsgRead        transport.MessageType = "read"
+	MsgWrite       transport.MessageType = "write"
+	MsgReadOK      transport.MessageType = "read_ok"
+	MsgWriteOK     transport.MessageType = "write_ok"
+	MsgSyncRequest transport.MessageType = "sync_request"
+	MsgSync        transport.MessageType = "sync"
+)
+
+const syncTicks = 12 // Ticks between a replica's anti-entropy pulls
+
+// Write is an update accepted by a replica, named by the replica and its
+// counter; the Lamport timestamp orders writes to the same key
+type Write struct {
+	Key     string `json:"key"`
+	Value   string `json:"value"`
+	Origin  string `json:"origin"`
+	Seq     uint64 `json:"seq"`
+	Lamport uint64 `json:"lamport"`
+}
+
+// Replica accepts reads and writes from any client and pulls the writes
+// it misses from a random peer every few ticks. Its version vector counts
+// the writes of each origin it applied, always in their origin's order,
+// so comparing it with a session's vector tells whether it has seen
+// everything the session depends on. A request whose session needs more
+// waits until the replica catches up.
+type Replica struct {
+	mu sync.RWMutex
+
+	id     string
+	status string // "running" or "crashed"
+	ticks  int
+
+	vector  *clock.VectorClock // Writes applied per origin
+	lamport uint64
+	log     []Write
+	store   map[string]Write // Key -> write of its current value
+
+	pending []*transport.Envelope // Requests waiting for writes their session saw
+
+	inbox      chan *transport.Envelope
+	simulation *Simulation
+}
+
+func newReplica(id string, replicas []string, sim *Simulation) *Replica {
+	return &Replica{
+		id:         id,
+		status:     "running",
+		vector:     clock.NewVectorClock(id, replicas),
+		store:      make(map[string]Write),
+		inbox:      make(chan *transport.Envelope, 500),
+		simulation: sim,
+	}
+}
+
+// Replica implements engine.NodeController
+
+func (r *Replica) ID() string {
+	return r.id
+}
+
+func (r *Replica) Start(ctx context.Context) error {
+	return nil
+}
+
+func (r *Replica) Stop() error {
+	return nil
+}
+
+func (r *Replica) Tick() {
+	r.simulation.advanceSchedule(r.tick())
+}
+
+func (r *Replica) tick() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.ticks++
+	if r.status == "crashed" {
+		return r.ticks
+	}
+
+	for i := 0; i < 50; i++ {
+		select {
+		case env := <-r.inbox:
+			r.processMessage(env)
+			continue
+		default:
+		}
+		break
+	}
+
+	if r.ticks%syncTicks == 0 {
+		peers := r.simulation.peersOf(r.id)
+		r.simulation.send(r.id, peers[r.simulation.rng.Intn(len(peers))], MsgSyncRequest, Payload{Vector: r.vector.Time()})
+	}
+	return r.ticks
+}
+
+func (r *Replica) GetState() map[string]interface{} {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	store := make(map[string]string, len(r.store))
+	for key, write := range r.store {
+		store[key] = write.Value
+	}
+
+	return map[string]interface{}{
+		"id":      r.id,
+		"status":  r.status,
+		"role":    "replica",
+		"vector":  r.vector.Time(),
+		"store":   store,
+		"writes":  len(r.log),
+		"pending": len(r.pending), // Requests waiting for the replica to catch up
+	}
+}
+
+func (r *Replica) handleMessage(env *transport.Envelope) {
+	r.mu.RLock()
+	down := r.status == "crashed"
+	r.mu.RUnlock()
+
+	if down {
+		return
+	}
+	select {
+	case r.inbox <- env:
+	default:
+	}
+}
+
+func (r *Replica) processMessage(env *transport.Envelope) {
+	sim := r.simulation
+	payload := sim.received(env)
+
+	switch env.Type {
+	case MsgRead, MsgWrite:
+		if !r.covers(payload.Requires) {
+			// Pull from every peer rather than wait for anti-entropy
+			r.pending = append(r.pending, env)
+			sim.deferred(r.id, env.From, string(env.Type))
+			for _, peer := range sim.peersOf(r.id) {
+				sim.send(r.id, peer, MsgSyncRequest, Payload{Vector: r.vector.Time()})
+			}
+			return
+		}
+		r.serve(env.From, env.Type, payload)
+	case MsgSyncRequest:
+		missing := make([]Write, 0)
+		for _, write := range r.log {
+			if write.Seq > payload.Vector[write.Origin] {
+				missing = append(missing, write)
+			}
+		}
+		if len(missing) > 0 {
+			sim.send(r.id, env.From, MsgSync, Payload{Writes: missing})
+		}
+	case MsgSync:
+		for _, write := range payload.Writes {
+			r.apply(write)
+		}
+		r.retry()
+	}
+}
+
+// covers reports whether the replica has applied every write a vector
+// counts (must hold r.mu)
+func (r *Replica) covers(required map[string]uint64) bool {
+	return dominates(r.vector.Time(), required)
+}
+
+// serve answers a read or a write with the replica's vector after it
+// (must hold r.mu)
+func (r *Replica) serve(client string, msgType transport.MessageType, payload Payload) {
+	sim := r.simulation
+	if msgType == MsgRead {
+		current := r.store[payload.Key]
+		sim.send(r.id, client, MsgReadOK, Payload{Seq: payload.Seq, Key: payload.Key, Write: &current, Vector: r.vector.Time()})
+		return
+	}
+	r.lamport++
+	write := Write{
+		Key:     payload.Key,
+		Value:   payload.Value,
+		Origin:  r.id,
+		Seq:     r.vector.Get(r.id) + 1,
+		Lamport: r.lamport,
+	}
+	r.apply(write)
+	sim.send(r.id, client, MsgWriteOK, Payload{Seq: payload.Seq, Key: payload.Key, Write: &write, Vector: r.vector.Time()})
+}
+
+// apply applies a write if it is the next one of its origin; the last
+// writer by Lamport timestamp wins the key (must hold r.mu)
+func (r *Replica) apply(write Write) {
+	if write.Seq != r.vector.Get(write.Origin)+1 {
+		return
+	}
+	r.vector.Advance(write.Origin)
+	r.lamport = max(r.lamport, write.Lamport)
+	r.log = append(r.log, write)
+	current, ok := r.store[write.Key]
+	if !ok || write.Lamport > current.Lamport || (write.Lamport == current.Lamport && write.Origin > current.Origin) {
+		r.store[write.Key] = write
+	}
+}
+
+// retry serves the waiting requests the replica now covers (must hold
+// r.mu)
+func (r *Replica) retry() {
+	waiting := r.pending
+	r.pending = nil
+	for _, env := range waiting {
+		payload, _ := env.Payload.(Payload)
+		if r.covers(payload.Requires) {
+			r.serve(env.From, env.Type, payload)
+		} else {
+			r.pending = append(r.pending, env)
+		}
+	}
+}
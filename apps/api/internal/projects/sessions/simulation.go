@@ -0,0 +1,381 @@
+package sessions
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+	"github.com/ersantana/distributed-systems-learning/packages/simulation/engine"
+)
+
+// anomalyKinds are the session guarantees an anomaly can break, in order
+var anomalyKinds = []string{"read_your_writes", "monotonic_reads", "monotonic_writes", "writes_follow_reads"}
+
+// Payload is the content of all messages exchanged in this project
+type Payload struct {
+	Seq      int               `json:"seq,omitempty"` // Client request, echoed in the reply
+	Key      string            `json:"key,omitempty"`
+	Value    string            `json:"value,omitempty"`
+	Requires map[string]uint64 `json:"requires,omitempty"` // Writes the replica must have applied first
+	Write    *Write            `json:"write,omitempty"`    // Write read, or made
+	Vector   map[string]uint64 `json:"vector,omitempty"`   // Replica's version vector
+	Writes   []Write           `json:"writes,omitempty"`   // Anti-entropy batch
+}
+
+// Simulation runs session guarantees over eventually consistent replicas.
+// A mobile client moves to another replica with every request, writing
+// and reading one key, while the replicas exchange writes only every few
+// ticks. Scenario "eventual" asks for no guarantee, so the client reads
+// values older than its own writes, or older than what it read before;
+// "read_your_writes", "monotonic_reads" and "session", with all four
+// guarantees, make replicas hold requests until they cover the session.
+type Simulation struct {
+	mu sync.RWMutex
+
+	engine    *engine.Engine
+	rng       *rand.Rand
+	transport *transport.NetworkTransport
+	broadcast func(interface{})
+
+	replicas []*Replica
+	client   *Client
+	scenario string
+
+	guarantees Guarantees
+
+	anomalies map[string]int // Guarantee -> answers that broke it
+	reads     int
+	writes    int
+	deferrals int // Requests a replica held to catch up first
+	abandoned int // Requests the client gave up on
+	waited    int // Ticks from request to answer, summed
+	messages  int
+	lastTick  int
+
+	running bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// Config for session guarantees simulation
+type Config struct {
+	NodeCount int
+	Scenario  string // "eventual", "read_your_writes", "monotonic_reads", "session"
+}
+
+// NewSimulation creates a new session guarantees simulation
+func NewSimulation(eng *engine.Engine, trans *transport.NetworkTransport, broadcast func(interface{}), config Config) *Simulation {
+	var guarantees Guarantees
+	switch config.Scenario {
+	case "eventual":
+	case "read_your_writes":
+		guarantees.ReadYourWrites = true
+	case "monotonic_reads":
+		guarantees.MonotonicReads = true
+	case "session":
+		guarantees = Guarantees{true, true, true, true}
+	default:
+		config.Scenario = "eventual"
+	}
+	if config.NodeCount == 0 {
+		config.NodeCount = 3
+	}
+	config.NodeCount = max(config.NodeCount, 2)
+
+	sim := &Simulation{
+		engine:     eng,
+		rng:        eng.Rand(),
+		transport:  trans,
+		broadcast:  broadcast,
+		scenario:   config.Scenario,
+		guarantees: guarantees,
+		anomalies:  make(map[string]int),
+	}
+
+	trans.SetLatency(20*time.Millisecond, 80*time.Millisecond)
+	trans.SetPacketLoss(0)
+
+	ids := make([]string, config.NodeCount)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("replica-%d", i+1)
+	}
+	for _, id := range ids {
+		replica := newReplica(id, ids, sim)
+		sim.replicas = append(sim.replicas, replica)
+		trans.RegisterHandler(replica.id, replica.handleMessage)
+		eng.AddNode(replica)
+	}
+	sim.client = newClient("phone", sim)
+	trans.RegisterHandler(sim.client.id, sim.client.handleMessage)
+	eng.AddNode(sim.client)
+
+	return sim
+}
+
+// Start starts the simulation
+func (s *Simulation) Start(ctx context.Context) error {
+	s.mu.Lock()
+	s.running = true
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.mu.Unlock()
+
+	return s.engine.Start(ctx)
+}
+
+// Stop stops the simulation
+func (s *Simulation) Stop() error {
+	s.mu.Lock()
+	s.running = false
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Unlock()
+
+	return s.engine.Stop()
+}
+
+// GetState returns the current simulation state
+func (s *Simulation) GetState() *protocol.SimulationStateResponse {
+	nodes := make(map[string]protocol.NodeState)
+	for _, replica := range s.replicas {
+		state := replica.GetState()
+		nodes[replica.id] = protocol.NodeState{
+			ID:          replica.id,
+			Status:      state["status"].(string),
+			Role:        "replica",
+			CustomState: state,
+		}
+	}
+	state := s.client.GetState()
+	nodes[s.client.id] = protocol.NodeState{
+		ID:          s.client.id,
+		Status:      state["status"].(string),
+		Role:        "client",
+		CustomState: state,
+	}
+
+	s.mu.RLock()
+	running := s.running
+	anomalies := make(map[string]int, len(anomalyKinds))
+	for _, kind := range anomalyKinds {
+		anomalies[kind] = s.anomalies[kind]
+	}
+	wait := 0.0
+	if s.reads+s.writes > 0 {
+		wait = float64(s.waited) / float64(s.reads+s.writes)
+	}
+	metadata := map[string]interface{}{
+		"scenario":     s.scenario,
+		"guarantees":   s.guarantees,
+		"anomalies":    anomalies, // Guarantee -> answers that broke it
+		"reads":        s.reads,
+		"writes":       s.writes,
+		"deferrals":    s.deferrals,
+		"abandoned":    s.abandoned,
+		"waitTicks":    wait, // Mean ticks from request to answer
+		"messages":     s.messages,
+		"syncInterval": syncTicks,
+	}
+	s.mu.RUnlock()
+
+	mode := "step"
+	if s.engine != nil {
+		mode = s.engine.GetMode().String()
+	}
+
+	return &protocol.SimulationStateResponse{
+		Type:        protocol.MsgSimulationState,
+		VirtualTime: time.Now().UnixMilli(),
+		Mode:        mode,
+		Speed:       1.0,
+		Running:     running,
+		Nodes:       nodes,
+		Metadata:    metadata,
+	}
+}
+
+// GetNodes returns node states
+func (s *Simulation) GetNodes() map[string]protocol.NodeState {
+	state := s.GetState()
+	return state.Nodes
+}
+
+// CrashNode crashes a replica or the client
+func (s *Simulation) CrashNode(nodeID string) error {
+	return s.setStatus(nodeID, "crashed")
+}
+
+// RecoverNode recovers a crashed replica, which keeps its writes, or the
+// client, which keeps its session
+func (s *Simulation) RecoverNode(nodeID string) error {
+	return s.setStatus(nodeID, "running")
+}
+
+// setStatus crashes or recovers a node
+func (s *Simulation) setStatus(nodeID, status string) error {
+	if nodeID == s.client.id {
+		s.client.mu.Lock()
+		s.client.status = status
+		s.client.mu.Unlock()
+		return nil
+	}
+	for _, replica := range s.replicas {
+		if replica.id == nodeID {
+			replica.mu.Lock()
+			replica.status = status
+			replica.mu.Unlock()
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown node: %s", nodeID)
+}
+
+// HandleClientRequest runs a client command: "set_guarantees" switches
+// session guarantees on or off, {readYourWrites, monotonicReads,
+// monotonicWrites, writesFollowReads}, each optional
+func (s *Simulation) HandleClientRequest(command string, payload map[string]interface{}) error {
+	if command != "set_guarantees" {
+		return fmt.Errorf("unknown command: %s", command)
+	}
+
+	s.mu.Lock()
+	for field, guarantee := range map[string]*bool{
+		"readYourWrites":    &s.guarantees.ReadYourWrites,
+		"monotonicReads":    &s.guarantees.MonotonicReads,
+		"monotonicWrites":   &s.guarantees.MonotonicWrites,
+		"writesFollowReads": &s.guarantees.WritesFollowReads,
+	} {
+		if on, ok := payload[field].(bool); ok {
+			*guarantee = on
+		}
+	}
+	guarantees := s.guarantees
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":       "guarantees_changed",
+		"guarantees": guarantees,
+	})
+	return nil
+}
+
+// currentGuarantees returns the guarantees switched on
+func (s *Simulation) currentGuarantees() Guarantees {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.guarantees
+}
+
+// peersOf returns the other replicas
+func (s *Simulation) peersOf(replicaID string) []string {
+	peers := make([]string, 0, len(s.replicas)-1)
+	for _, replica := range s.replicas {
+		if replica.id != replicaID {
+			peers = append(peers, replica.id)
+		}
+	}
+	return peers
+}
+
+// advanceSchedule counts ticks; the scenarios have no scripted faults
+func (s *Simulation) advanceSchedule(ticks int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastTick = max(s.lastTick, ticks)
+}
+
+// deferred records a replica holding a request until it covers the
+// session
+func (s *Simulation) deferred(replicaID, clientID, op string) {
+	s.mu.Lock()
+	s.deferrals++
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":   "request_deferred",
+		"nodeId": replicaID,
+		"client": clientID,
+		"op":     op,
+	})
+}
+
+// gaveUp records the client giving up on a replica
+func (s *Simulation) gaveUp(clientID, replicaID string) {
+	s.mu.Lock()
+	s.abandoned++
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":    "request_abandoned",
+		"nodeId":  clientID,
+		"replica": replicaID,
+	})
+}
+
+// completed records an answered request and how long it took
+func (s *Simulation) completed(write bool, ticks int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if write {
+		s.writes++
+	} else {
+		s.reads++
+	}
+	s.waited += ticks
+}
+
+// anomaly records an answer that broke a session guarantee
+func (s *Simulation) anomaly(clientID, guarantee, replicaID, detail string) {
+	s.mu.Lock()
+	s.anomalies[guarantee]++
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":      "session_anomaly",
+		"nodeId":    clientID,
+		"guarantee": guarantee,
+		"replica":   replicaID,
+		"detail":    detail,
+	})
+}
+
+// send transmits a message and reports it to the frontend
+func (s *Simulation) send(from, to string, msgType transport.MessageType, payload Payload) {
+	env := transport.NewEnvelope(from, to, msgType, payload)
+
+	s.mu.Lock()
+	s.messages++
+	s.mu.Unlock()
+
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageSent,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+
+	s.transport.Send(s.ctx, env)
+}
+
+// received reports a delivered message and returns its payload
+func (s *Simulation) received(env *transport.Envelope) Payload {
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageReceived,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+	payload, _ := env.Payload.(Payload)
+	return payload
+}
@@ -0,0 +1,50 @@
+package sessions
+
+import (
+	"fmt"
+
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+)
+
+// Invariants reports one result per session guarantee: whether any
+// answer the client got broke it. A guarantee that is switched on never
+// breaks; one that is off breaks as soon as the client outruns
+// anti-entropy.
+func (s *Simulation) Invariants() []protocol.InvariantResult {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	on := map[string]bool{
+		"read_your_writes":    s.guarantees.ReadYourWrites,
+		"monotonic_reads":     s.guarantees.MonotonicReads,
+		"monotonic_writes":    s.guarantees.MonotonicWrites,
+		"writes_follow_reads": s.guarantees.WritesFollowReads,
+	}
+	results := make([]protocol.InvariantResult, 0, len(anomalyKinds))
+	for _, kind := range anomalyKinds {
+		state := "off"
+		if on[kind] {
+			state = "on"
+		}
+		results = append(results, protocol.InvariantResult{
+			Name:   kind,
+			Holds:  s.anomalies[kind] == 0,
+			Detail: fmt.Sprintf("%d anomalies with the guarantee %s, in %d reads and %d writes; %d requests deferred", s.anomalies[kind], state, s.reads, s.writes, s.deferrals),
+		})
+	}
+	return results
+}
+
+// FollowUps suggests the scenarios that contrast with this one
+func (s *Simulation) FollowUps() []protocol.FollowUp {
+	if s.scenario == "eventual" {
+		return []protocol.FollowUp{
+			{Project: "session-guarantees", Scenario: "read_your_writes", Reason: "Make replicas catch up with the client's writes before answering"},
+			{Project: "session-guarantees", Scenario: "session", Reason: "Switch on all four guarantees and measure the waiting"},
+		}
+	}
+	return []protocol.FollowUp{
+		{Project: "session-guarantees", Scenario: "eventual", Reason: "Drop the guarantees and watch the client read the past"},
+		{Project: "quorum", Reason: "Get read-your-writes from overlapping quorums instead of sessions"},
+	}
+}
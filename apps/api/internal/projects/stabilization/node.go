@@ -0,0 +1,171 @@
+package stabilization
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+const MsgState transport.MessageType = "state"
+
+const (
+	holdTicks    = 3 // Ticks a machine keeps the token before moving
+	refreshTicks = 5 // Ticks between unchanged state messages
+)
+
+// Node is a machine of the ring. It reads its predecessor's value from
+// the state messages the predecessor keeps sending, so it acts on a
+// possibly stale copy.
+type Node struct {
+	mu sync.RWMutex
+
+	id     string
+	index  int
+	status string
+	ticks  int
+	succ   string
+
+	value     int
+	predValue int // Last value heard from the predecessor
+	predSeq   int
+	seq       int
+	lastSent  int
+	holding   bool
+	holdUntil int
+	moves     int
+
+	inbox      chan *transport.Envelope
+	simulation *Simulation
+}
+
+func newNode(index, count int, sim *Simulation) *Node {
+	return &Node{
+		id:         fmt.Sprintf("node-%d", index+1),
+		index:      index,
+		status:     "running",
+		succ:       fmt.Sprintf("node-%d", (index+1)%count+1),
+		inbox:      make(chan *transport.Envelope, 500),
+		simulation: sim,
+	}
+}
+
+// Node implements engine.NodeController
+
+func (n *Node) ID() string {
+	return n.id
+}
+
+func (n *Node) Start(ctx context.Context) error {
+	return nil
+}
+
+func (n *Node) Stop() error {
+	return nil
+}
+
+func (n *Node) Tick() {
+	ticks, moved := n.tick()
+	if n.index == 0 {
+		n.simulation.advanceSchedule(ticks, moved)
+	}
+}
+
+func (n *Node) tick() (int, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.ticks++
+	if n.status != "running" {
+		return n.ticks, false
+	}
+
+	for i := 0; i < 50; i++ {
+		select {
+		case env := <-n.inbox:
+			payload := n.simulation.received(env)
+			if payload.Seq > n.predSeq {
+				n.predSeq = payload.Seq
+				n.predValue = payload.Value
+			}
+			continue
+		default:
+		}
+		break
+	}
+
+	moved := false
+	switch {
+	case !n.privileged():
+		n.holding = false
+	case !n.holding:
+		n.holding = true
+		n.holdUntil = n.ticks + holdTicks
+	case n.ticks >= n.holdUntil:
+		n.move()
+		moved = true
+	}
+
+	if moved || n.ticks-n.lastSent >= refreshTicks {
+		n.seq++
+		n.lastSent = n.ticks
+		n.simulation.send(n.id, n.succ, MsgState, Payload{Value: n.value, Seq: n.seq})
+	}
+	return n.ticks, moved
+}
+
+func (n *Node) GetState() map[string]interface{} {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	role := "machine"
+	if n.index == 0 {
+		role = "bottom"
+	}
+
+	return map[string]interface{}{
+		"id":         n.id,
+		"status":     n.status,
+		"role":       role,
+		"value":      n.value,
+		"predValue":  n.predValue,
+		"privileged": n.privileged(),
+		"moves":      n.moves,
+	}
+}
+
+func (n *Node) handleMessage(env *transport.Envelope) {
+	n.mu.RLock()
+	crashed := n.status != "running"
+	n.mu.RUnlock()
+
+	// Crashed nodes do not receive anything
+	if crashed {
+		return
+	}
+	select {
+	case n.inbox <- env:
+	default:
+	}
+}
+
+// privileged reports whether this machine holds a token as far as it
+// knows
+func (n *Node) privileged() bool {
+	if n.index == 0 {
+		return n.value == n.predValue
+	}
+	return n.value != n.predValue
+}
+
+// move passes the token on (must hold n.mu)
+func (n *Node) move() {
+	if n.index == 0 {
+		n.value = (n.value + 1) % n.simulation.k
+	} else {
+		n.value = n.predValue
+	}
+	n.holding = false
+	n.moves++
+}
@@ -0,0 +1,391 @@
+package stabilization
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+	"github.com/ersantana/distributed-systems-learning/packages/simulation/engine"
+)
+
+const (
+	historyLength = 20 // Rounds kept for the frontend
+	corruptEvery  = 80 // Ticks between corruptions in "periodic_corruption"
+)
+
+// Payload is the content of all messages exchanged in this project
+type Payload struct {
+	Value int `json:"value"`
+	Seq   int `json:"seq"` // Lets the successor ignore reordered, older values
+}
+
+// Round is the outcome of a legitimacy check, made every time the bottom
+// machine moves
+type Round struct {
+	Round      int      `json:"round"`
+	Tick       int      `json:"tick"`
+	Privileged []string `json:"privileged"`
+	Legitimate bool     `json:"legitimate"`
+}
+
+// Simulation runs Dijkstra's K-state self-stabilizing token ring. Every
+// machine holds a value in [0, K) and watches its predecessor's. The
+// bottom machine is privileged when its value equals its predecessor's
+// and moves by incrementing it; every other machine is privileged when
+// its value differs and moves by copying it. A privilege is a token. From
+// any values whatsoever, with K at least the number of machines, the ring
+// converges to exactly one token circulating forever: corrupt it and it
+// heals by itself, without anyone detecting the corruption.
+type Simulation struct {
+	mu sync.RWMutex
+
+	engine    *engine.Engine
+	rng       *rand.Rand
+	transport *transport.NetworkTransport
+	broadcast func(interface{})
+
+	nodes    []*Node
+	scenario string
+	k        int
+
+	round       int
+	history     []Round
+	legitimate  bool
+	corruptedAt int // Tick of the last corruption
+	convergence int // Ticks the last convergence took
+	corruptions int
+	nextCorrupt int
+
+	running bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// Config for self-stabilization simulation
+type Config struct {
+	NodeCount int
+	Scenario  string // "corrupted", "legitimate", "periodic_corruption"
+	K         int    // Values per machine, at least NodeCount; defaults to NodeCount+1
+}
+
+// NewSimulation creates a new self-stabilizing token ring simulation
+func NewSimulation(eng *engine.Engine, trans *transport.NetworkTransport, broadcast func(interface{}), config Config) *Simulation {
+	switch config.Scenario {
+	case "corrupted", "legitimate", "periodic_corruption":
+	default:
+		config.Scenario = "corrupted"
+	}
+	if config.NodeCount == 0 {
+		config.NodeCount = 5
+	}
+	if config.K < config.NodeCount {
+		config.K = config.NodeCount + 1
+	}
+
+	sim := &Simulation{
+		engine:     eng,
+		rng:        eng.Rand(),
+		transport:  trans,
+		broadcast:  broadcast,
+		scenario:   config.Scenario,
+		k:          config.K,
+		legitimate: config.Scenario == "legitimate",
+	}
+	if config.Scenario == "periodic_corruption" {
+		sim.nextCorrupt = corruptEvery
+	}
+
+	trans.SetLatency(20*time.Millisecond, 80*time.Millisecond)
+	trans.SetPacketLoss(0)
+
+	for i := 0; i < config.NodeCount; i++ {
+		node := newNode(i, config.NodeCount, sim)
+		if config.Scenario != "legitimate" {
+			// An arbitrary state, caches included
+			node.value = sim.rng.Intn(sim.k)
+			node.predValue = sim.rng.Intn(sim.k)
+		}
+		sim.nodes = append(sim.nodes, node)
+		trans.RegisterHandler(node.id, node.handleMessage)
+		eng.AddNode(node)
+	}
+
+	return sim
+}
+
+// Start starts the simulation
+func (s *Simulation) Start(ctx context.Context) error {
+	s.mu.Lock()
+	s.running = true
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.mu.Unlock()
+
+	return s.engine.Start(ctx)
+}
+
+// Stop stops the simulation
+func (s *Simulation) Stop() error {
+	s.mu.Lock()
+	s.running = false
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Unlock()
+
+	return s.engine.Stop()
+}
+
+// GetState returns the current simulation state
+func (s *Simulation) GetState() *protocol.SimulationStateResponse {
+	nodes := make(map[string]protocol.NodeState)
+	values := make([]int, len(s.nodes))
+	for i, node := range s.nodes {
+		state := node.GetState()
+		nodes[node.id] = protocol.NodeState{
+			ID:          node.id,
+			Status:      state["status"].(string),
+			Role:        state["role"].(string),
+			CustomState: state,
+		}
+		values[i] = state["value"].(int)
+	}
+	privileged := s.privileged(values)
+
+	s.mu.RLock()
+	running := s.running
+	metadata := map[string]interface{}{
+		"scenario":    s.scenario,
+		"k":           s.k,
+		"values":      values,
+		"privileged":  privileged,
+		"tokens":      len(privileged),
+		"legitimate":  len(privileged) == 1,
+		"round":       s.round,
+		"rounds":      append([]Round{}, s.history...),
+		"corruptions": s.corruptions,
+		"convergence": s.convergence,
+	}
+	s.mu.RUnlock()
+
+	mode := "step"
+	if s.engine != nil {
+		mode = s.engine.GetMode().String()
+	}
+
+	return &protocol.SimulationStateResponse{
+		Type:        protocol.MsgSimulationState,
+		VirtualTime: time.Now().UnixMilli(),
+		Mode:        mode,
+		Speed:       1.0,
+		Running:     running,
+		Nodes:       nodes,
+		Metadata:    metadata,
+	}
+}
+
+// GetNodes returns node states
+func (s *Simulation) GetNodes() map[string]protocol.NodeState {
+	state := s.GetState()
+	return state.Nodes
+}
+
+// Layout places the machines on the ring in token order
+func (s *Simulation) Layout() *protocol.Layout {
+	order := make([]string, len(s.nodes))
+	for i, node := range s.nodes {
+		order[i] = node.id
+	}
+	return &protocol.Layout{Kind: protocol.LayoutRing, Order: order}
+}
+
+// CrashNode crashes a machine; the token cannot pass it until it recovers
+func (s *Simulation) CrashNode(nodeID string) error {
+	node := s.findNode(nodeID)
+	if node == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	node.mu.Lock()
+	node.status = "crashed"
+	node.mu.Unlock()
+	return nil
+}
+
+// RecoverNode recovers a crashed machine
+func (s *Simulation) RecoverNode(nodeID string) error {
+	node := s.findNode(nodeID)
+	if node == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	node.mu.Lock()
+	node.status = "running"
+	node.mu.Unlock()
+	return nil
+}
+
+// HandleClientRequest injects corruption. Commands are "corrupt"
+// (payload: optional nodeId and value, random otherwise) and
+// "corrupt_all".
+func (s *Simulation) HandleClientRequest(command string, payload map[string]interface{}) error {
+	switch command {
+	case "corrupt":
+		node := s.nodes[s.rng.Intn(len(s.nodes))]
+		if nodeID, ok := payload["nodeId"].(string); ok && nodeID != "" {
+			if node = s.findNode(nodeID); node == nil {
+				return fmt.Errorf("unknown node: %s", nodeID)
+			}
+		}
+		value := s.rng.Intn(s.k)
+		if v, ok := payload["value"].(float64); ok {
+			value = int(v)
+			if value < 0 || value >= s.k {
+				return fmt.Errorf("value must be in [0, %d)", s.k)
+			}
+		}
+		s.corrupt(node, value)
+
+	case "corrupt_all":
+		for _, node := range s.nodes {
+			s.corrupt(node, s.rng.Intn(s.k))
+		}
+
+	default:
+		return fmt.Errorf("unknown command: %s", command)
+	}
+	return nil
+}
+
+// corrupt overwrites a machine's value and its copy of its predecessor's
+func (s *Simulation) corrupt(node *Node, value int) {
+	node.mu.Lock()
+	node.value = value
+	node.predValue = s.rng.Intn(s.k)
+	node.holding = false
+	ticks := node.ticks
+	node.mu.Unlock()
+
+	s.mu.Lock()
+	s.corruptions++
+	s.corruptedAt = ticks
+	s.legitimate = false // Until a round finds a single token again
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":   "state_corrupted",
+		"nodeId": node.id,
+		"value":  value,
+	})
+}
+
+// findNode looks up a node by ID; the node list is fixed after construction
+func (s *Simulation) findNode(nodeID string) *Node {
+	for _, node := range s.nodes {
+		if node.id == nodeID {
+			return node
+		}
+	}
+	return nil
+}
+
+// privileged returns the machines holding a token given everyone's value
+func (s *Simulation) privileged(values []int) []string {
+	tokens := make([]string, 0)
+	for i, node := range s.nodes {
+		pred := values[(i+len(values)-1)%len(values)]
+		if (i == 0 && values[i] == pred) || (i != 0 && values[i] != pred) {
+			tokens = append(tokens, node.id)
+		}
+	}
+	return tokens
+}
+
+// advanceSchedule checks legitimacy after a move of the bottom machine
+// and runs the scripted corruptions; called by the bottom machine after
+// its tick
+func (s *Simulation) advanceSchedule(ticks int, moved bool) {
+	s.mu.Lock()
+	corrupt := s.nextCorrupt > 0 && ticks >= s.nextCorrupt
+	if corrupt {
+		s.nextCorrupt = ticks + corruptEvery
+	}
+	s.mu.Unlock()
+
+	if corrupt {
+		s.corrupt(s.nodes[1+s.rng.Intn(len(s.nodes)-1)], s.rng.Intn(s.k))
+	}
+	if !moved {
+		return
+	}
+
+	values := make([]int, len(s.nodes))
+	for i, node := range s.nodes {
+		node.mu.RLock()
+		values[i] = node.value
+		node.mu.RUnlock()
+	}
+	privileged := s.privileged(values)
+	legitimate := len(privileged) == 1
+
+	s.mu.Lock()
+	s.round++
+	round := Round{Round: s.round, Tick: ticks, Privileged: privileged, Legitimate: legitimate}
+	s.history = append(s.history, round)
+	if len(s.history) > historyLength {
+		s.history = s.history[1:]
+	}
+	stabilized := legitimate && !s.legitimate
+	s.legitimate = legitimate
+	if stabilized {
+		s.convergence = ticks - s.corruptedAt
+	}
+	convergence := s.convergence
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":       "round_completed",
+		"round":      round.Round,
+		"privileged": privileged,
+		"tokens":     len(privileged),
+		"legitimate": legitimate,
+	})
+	if stabilized {
+		s.broadcast(map[string]interface{}{
+			"type":  "stabilized",
+			"round": round.Round,
+			"ticks": convergence,
+		})
+	}
+}
+
+// send transmits a message and reports it to the frontend
+func (s *Simulation) send(from, to string, msgType transport.MessageType, payload Payload) {
+	env := transport.NewEnvelope(from, to, msgType, payload)
+
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageSent,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+
+	s.transport.Send(s.ctx, env)
+}
+
+// received reports a delivered message and returns its payload
+func (s *Simulation) received(env *transport.Envelope) Payload {
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageReceived,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+	payload, _ := env.Payload.(Payload)
+	return payload
+}
@@ -0,0 +1,32 @@
+package stabilization
+
+import (
+	"fmt"
+
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+)
+
+// Invariants judges the run: the ring ends up with a single token
+func (s *Simulation) Invariants() []protocol.InvariantResult {
+	metadata := s.GetState().Metadata
+
+	return []protocol.InvariantResult{
+		{
+			Name:   "single token",
+			Holds:  metadata["legitimate"].(bool),
+			Detail: fmt.Sprintf("%d tokens at the end, after %d corruptions", metadata["tokens"].(int), metadata["corruptions"].(int)),
+		},
+	}
+}
+
+// FollowUps suggests the scenarios that contrast with this one
+func (s *Simulation) FollowUps() []protocol.FollowUp {
+	if s.scenario == "periodic_corruption" {
+		return []protocol.FollowUp{
+			{Project: "mutex", Scenario: "token_loss", Reason: "Compare with a token ring that detects the lost token with a timeout instead"},
+		}
+	}
+	return []protocol.FollowUp{
+		{Project: "stabilization", Scenario: "periodic_corruption", Reason: "Keep corrupting the ring and watch it heal every time"},
+	}
+}
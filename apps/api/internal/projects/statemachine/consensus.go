@@ -0,0 +1,134 @@
+package statemachine
+
+import (
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+// Entry is a command at its position in the replicated log
+type Entry struct {
+	Index   int     `json:"index"`
+	Command Command `json:"command"`
+}
+
+// Log is the consensus layer under the state machine. It agrees on the
+// position of every submitted command and hands committed entries to the
+// node, possibly out of index order since messages may be reordered; the
+// node applies them in index order. All methods are called with the
+// node's lock held.
+type Log interface {
+	// Name identifies the implementation
+	Name() string
+
+	// Role of this node in the layer, e.g. "sequencer"
+	Role() string
+
+	// Submit proposes a command issued by a client of this node
+	Submit(cmd Command)
+
+	// Handle processes a protocol message, reporting whether it was one
+	Handle(env *transport.Envelope, payload Payload) bool
+
+	// Sync asks for the committed entries after index, e.g. after a
+	// crash or when an entry seems to be missing
+	Sync(after int)
+}
+
+// layers are the available consensus layers by name. Only the mock
+// sequencer exists so far; Raft plugs in here once that project exists.
+var layers = map[string]func(n *Node) Log{
+	"sequencer": func(n *Node) Log { return newSequencerLog(n) },
+}
+
+// Sequencer log messages
+const (
+	MsgSubmit  transport.MessageType = "submit"
+	MsgOrdered transport.MessageType = "ordered"
+	MsgSync    transport.MessageType = "sync"
+)
+
+// sequencerLog is a mock total order layer: the first node stamps every
+// command with the next index and sends it to everyone. It needs no
+// quorum, so it is fast, but nothing is ordered while the sequencer is
+// down; Raft would elect a replacement.
+type sequencerLog struct {
+	node      *Node
+	sequencer string
+	entries   []Command // Sequencer only
+}
+
+func newSequencerLog(n *Node) *sequencerLog {
+	return &sequencerLog{
+		node:      n,
+		sequencer: n.nodeIDs[0],
+	}
+}
+
+func (l *sequencerLog) Name() string {
+	return "sequencer"
+}
+
+func (l *sequencerLog) Role() string {
+	if l.node.id == l.sequencer {
+		return "sequencer"
+	}
+	return "replica"
+}
+
+func (l *sequencerLog) Submit(cmd Command) {
+	n := l.node
+	if n.id == l.sequencer {
+		l.order(cmd)
+		return
+	}
+	n.simulation.send(n.id, l.sequencer, MsgSubmit, Payload{Command: &cmd})
+}
+
+// order appends a command to the log and sends it to every node
+func (l *sequencerLog) order(cmd Command) {
+	n := l.node
+
+	l.entries = append(l.entries, cmd)
+	entry := Entry{Index: len(l.entries), Command: cmd}
+	for _, peer := range n.nodeIDs {
+		if peer != n.id {
+			n.simulation.send(n.id, peer, MsgOrdered, Payload{Entry: &entry})
+		}
+	}
+	n.committed(entry)
+}
+
+func (l *sequencerLog) Handle(env *transport.Envelope, payload Payload) bool {
+	n := l.node
+
+	switch env.Type {
+	case MsgSubmit:
+		if n.id == l.sequencer && payload.Command != nil {
+			l.order(*payload.Command)
+		}
+
+	case MsgOrdered:
+		if payload.Entry != nil {
+			n.committed(*payload.Entry)
+		}
+
+	case MsgSync:
+		if n.id != l.sequencer {
+			return true
+		}
+		for i := payload.After; i < len(l.entries); i++ {
+			entry := Entry{Index: i + 1, Command: l.entries[i]}
+			n.simulation.send(n.id, env.From, MsgOrdered, Payload{Entry: &entry})
+		}
+
+	default:
+		return false
+	}
+	return true
+}
+
+func (l *sequencerLog) Sync(after int) {
+	n := l.node
+	if n.id != l.sequencer {
+		n.simulation.send(n.id, l.sequencer, MsgSync, Payload{After: after})
+	}
+}
@@ -0,0 +1,74 @@
+package statemachine
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+)
+
+// Command is an operation on the replicated key-value store
+type Command struct {
+	ID     string `json:"id"`
+	Op     string `json:"op"` // "set", "append", "delete"
+	Key    string `json:"key"`
+	Value  string `json:"value,omitempty"`
+	Client string `json:"client"`
+}
+
+// KVStore is the deterministic state machine every node runs. Appends do
+// not commute, so replicas that apply the same commands in different
+// orders end up with different values.
+type KVStore struct {
+	data map[string]string
+}
+
+// NewKVStore creates an empty store
+func NewKVStore() *KVStore {
+	return &KVStore{data: make(map[string]string)}
+}
+
+// Apply executes a command and returns the key's new value
+func (kv *KVStore) Apply(cmd Command) (string, error) {
+	switch cmd.Op {
+	case "set":
+		kv.data[cmd.Key] = cmd.Value
+	case "append":
+		kv.data[cmd.Key] += cmd.Value
+	case "delete":
+		delete(kv.data, cmd.Key)
+	default:
+		return "", fmt.Errorf("unknown op: %s", cmd.Op)
+	}
+	return kv.data[cmd.Key], nil
+}
+
+// Get returns the value of key
+func (kv *KVStore) Get(key string) (string, bool) {
+	value, ok := kv.data[key]
+	return value, ok
+}
+
+// Snapshot returns a copy of the store
+func (kv *KVStore) Snapshot() map[string]string {
+	snapshot := make(map[string]string, len(kv.data))
+	for k, v := range kv.data {
+		snapshot[k] = v
+	}
+	return snapshot
+}
+
+// Digest returns a short hash of the store; equal stores have equal
+// digests
+func (kv *KVStore) Digest() string {
+	keys := make([]string, 0, len(kv.data))
+	for k := range kv.data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := fnv.New32a()
+	for _, k := range keys {
+		fmt.Fprintf(h, "%s=%s;", k, kv.data[k])
+	}
+	return fmt.Sprintf("%08x", h.Sum32())
+}
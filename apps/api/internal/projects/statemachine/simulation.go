@@ -0,0 +1,529 @@
+package statemachine
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+	"github.com/ersantana/distributed-systems-learning/packages/simulation/engine"
+)
+
+const (
+	gapTimeout   = 10 // Ticks an entry may be missing before asking again
+	recentLength = 10 // Applied indices kept per node
+)
+
+// keys the simulated clients write to
+var keys = []string{"a", "b", "c"}
+
+// Payload is the content of all messages exchanged in this project
+type Payload struct {
+	Command *Command `json:"command,omitempty"`
+	Entry   *Entry   `json:"entry,omitempty"`
+	After   int      `json:"after,omitempty"` // Sync: entries after this index
+}
+
+// Simulation replicates a key-value store: every node submits its
+// clients' commands to a consensus layer and applies the committed log in
+// index order, so all nodes go through the same states. The
+// "out_of_order" scenario applies entries as they arrive instead and the
+// replicas drift apart.
+type Simulation struct {
+	mu sync.RWMutex
+
+	engine    *engine.Engine
+	rng       *rand.Rand
+	transport *transport.NetworkTransport
+	broadcast func(interface{})
+
+	nodes      []*Node
+	consensus  string
+	outOfOrder bool
+
+	running bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// Config for state machine replication simulation
+type Config struct {
+	NodeCount int
+	Scenario  string // "ordered", "out_of_order"
+	Consensus string // Consensus layer, "sequencer"
+}
+
+// NewSimulation creates a new state machine replication simulation
+func NewSimulation(eng *engine.Engine, trans *transport.NetworkTransport, broadcast func(interface{}), config Config) *Simulation {
+	if config.NodeCount == 0 {
+		config.NodeCount = 3
+	}
+	if _, ok := layers[config.Consensus]; !ok {
+		config.Consensus = "sequencer"
+	}
+
+	sim := &Simulation{
+		engine:     eng,
+		rng:        eng.Rand(),
+		transport:  trans,
+		broadcast:  broadcast,
+		consensus:  config.Consensus,
+		outOfOrder: config.Scenario == "out_of_order",
+	}
+
+	// Jitter larger than a tick reorders the log entries in flight
+	trans.SetLatency(20*time.Millisecond, 150*time.Millisecond)
+	trans.SetPacketLoss(0)
+
+	nodeIDs := make([]string, config.NodeCount)
+	for i := range nodeIDs {
+		nodeIDs[i] = fmt.Sprintf("node-%d", i+1)
+	}
+	for _, id := range nodeIDs {
+		node := newNode(id, nodeIDs, sim)
+		sim.nodes = append(sim.nodes, node)
+		trans.RegisterHandler(id, node.handleMessage)
+		eng.AddNode(node)
+	}
+
+	return sim
+}
+
+// Start starts the simulation
+func (s *Simulation) Start(ctx context.Context) error {
+	s.mu.Lock()
+	s.running = true
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.mu.Unlock()
+
+	return s.engine.Start(ctx)
+}
+
+// Stop stops the simulation
+func (s *Simulation) Stop() error {
+	s.mu.Lock()
+	s.running = false
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Unlock()
+
+	return s.engine.Stop()
+}
+
+// GetState returns the current simulation state
+func (s *Simulation) GetState() *protocol.SimulationStateResponse {
+	nodes := make(map[string]protocol.NodeState)
+	appliedIndex := make(map[string]int)
+	digests := make(map[string]string)
+	byIndex := make(map[int]string)
+	divergent := false
+	violations := 0
+	for _, node := range s.nodes {
+		state := node.GetState()
+		nodes[node.id] = protocol.NodeState{
+			ID:          node.id,
+			Status:      state["status"].(string),
+			Role:        state["role"].(string),
+			CustomState: state,
+		}
+		appliedIndex[node.id] = state["appliedIndex"].(int)
+		digest := state["digest"].(string)
+		digests[node.id] = digest
+		violations += state["outOfOrder"].(int)
+
+		// Nodes that applied exactly the same prefix of the log must be
+		// in the same state
+		if state["ahead"].(int) == 0 {
+			index := state["appliedIndex"].(int)
+			if other, ok := byIndex[index]; ok && other != digest {
+				divergent = true
+			}
+			byIndex[index] = digest
+		}
+	}
+
+	s.mu.RLock()
+	running := s.running
+	s.mu.RUnlock()
+
+	mode := "step"
+	if s.engine != nil {
+		mode = s.engine.GetMode().String()
+	}
+
+	return &protocol.SimulationStateResponse{
+		Type:        protocol.MsgSimulationState,
+		VirtualTime: time.Now().UnixMilli(),
+		Mode:        mode,
+		Speed:       1.0,
+		Running:     running,
+		Nodes:       nodes,
+		Metadata: map[string]interface{}{
+			"consensus":       s.consensus,
+			"applyOutOfOrder": s.outOfOrder,
+			"appliedIndex":    appliedIndex,
+			"digests":         digests,
+			"divergent":       divergent,
+			"outOfOrder":      violations,
+		},
+	}
+}
+
+// GetNodes returns node states
+func (s *Simulation) GetNodes() map[string]protocol.NodeState {
+	state := s.GetState()
+	return state.Nodes
+}
+
+// Layout puts the node ordering the log in the middle of the replicas
+func (s *Simulation) Layout() *protocol.Layout {
+	center := ""
+	order := make([]string, 0, len(s.nodes))
+	for _, node := range s.nodes {
+		node.mu.RLock()
+		role := node.log.Role()
+		node.mu.RUnlock()
+		if role == "sequencer" && center == "" {
+			center = node.id
+			continue
+		}
+		order = append(order, node.id)
+	}
+	if center == "" {
+		return &protocol.Layout{Kind: protocol.LayoutRing, Order: order}
+	}
+	return &protocol.Layout{
+		Kind:   protocol.LayoutStar,
+		Center: center,
+		Order:  order,
+	}
+}
+
+// CrashNode crashes a node; its store and log survive
+func (s *Simulation) CrashNode(nodeID string) error {
+	node := s.findNode(nodeID)
+	if node == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	node.mu.Lock()
+	node.status = "crashed"
+	node.mu.Unlock()
+	return nil
+}
+
+// RecoverNode recovers a crashed node, which then catches up on the
+// entries committed meanwhile
+func (s *Simulation) RecoverNode(nodeID string) error {
+	node := s.findNode(nodeID)
+	if node == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	node.mu.Lock()
+	defer node.mu.Unlock()
+
+	node.status = "running"
+	for len(node.inbox) > 0 {
+		<-node.inbox
+	}
+	node.log.Sync(node.appliedIndex)
+	return nil
+}
+
+// HandleClientRequest submits a command through one node. Commands are
+// "set", "append" and "delete" (payload: nodeId, key, value).
+func (s *Simulation) HandleClientRequest(command string, payload map[string]interface{}) error {
+	nodeID, _ := payload["nodeId"].(string)
+	node := s.findNode(nodeID)
+	if node == nil {
+		return fmt.Errorf("unknown node: %q", nodeID)
+	}
+	key, _ := payload["key"].(string)
+	value, _ := payload["value"].(string)
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+
+	switch command {
+	case "set", "append", "delete":
+	default:
+		return fmt.Errorf("unknown command: %s", command)
+	}
+
+	node.mu.Lock()
+	defer node.mu.Unlock()
+
+	if node.status != "running" {
+		return fmt.Errorf("node %s is crashed", nodeID)
+	}
+	node.submit(command, key, value)
+	return nil
+}
+
+// findNode looks up a node by ID; the node list is fixed after construction
+func (s *Simulation) findNode(nodeID string) *Node {
+	for _, node := range s.nodes {
+		if node.id == nodeID {
+			return node
+		}
+	}
+	return nil
+}
+
+// send transmits a message and reports it to the frontend
+func (s *Simulation) send(from, to string, msgType transport.MessageType, payload Payload) {
+	env := transport.NewEnvelope(from, to, msgType, payload)
+
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageSent,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+
+	s.transport.Send(s.ctx, env)
+}
+
+// received reports a delivered message and returns its payload
+func (s *Simulation) received(env *transport.Envelope) Payload {
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageReceived,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+	payload, _ := env.Payload.(Payload)
+	return payload
+}
+
+// Node is a replica of the key-value store with a local client issuing
+// random writes
+type Node struct {
+	mu sync.RWMutex
+
+	id      string
+	status  string
+	ticks   int
+	nodeIDs []string
+
+	log   Log
+	store *KVStore
+
+	appliedIndex int // Every entry up to here is applied
+	applied      int // Entries applied, out of order ones included
+	highest      int // Highest committed index received
+	holdBack     map[int]Command
+	seen         map[int]bool // Applied indices beyond appliedIndex
+	gapSince     int
+	recent       []int // Indices in the order they were applied
+	outOfOrder   int   // Entries applied ahead of an earlier one
+
+	issued    int
+	nextIssue int
+
+	inbox      chan *transport.Envelope
+	simulation *Simulation
+}
+
+func newNode(id string, nodeIDs []string, sim *Simulation) *Node {
+	n := &Node{
+		id:         id,
+		status:     "running",
+		nodeIDs:    nodeIDs,
+		store:      NewKVStore(),
+		holdBack:   make(map[int]Command),
+		seen:       make(map[int]bool),
+		nextIssue:  5 + sim.rng.Intn(5),
+		inbox:      make(chan *transport.Envelope, 500),
+		simulation: sim,
+	}
+	n.log = layers[sim.consensus](n)
+	return n
+}
+
+// Node implements engine.NodeController
+
+func (n *Node) ID() string {
+	return n.id
+}
+
+func (n *Node) Start(ctx context.Context) error {
+	return nil
+}
+
+func (n *Node) Stop() error {
+	return nil
+}
+
+func (n *Node) Tick() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.ticks++
+	if n.status != "running" {
+		return
+	}
+
+	for i := 0; i < 50; i++ {
+		select {
+		case env := <-n.inbox:
+			n.log.Handle(env, n.simulation.received(env))
+			continue
+		default:
+		}
+		break
+	}
+
+	// An entry still missing after a while was probably lost with a crash
+	if n.waiting() > 0 && n.ticks-n.gapSince > gapTimeout {
+		n.gapSince = n.ticks
+		n.log.Sync(n.appliedIndex)
+	}
+
+	if n.ticks >= n.nextIssue {
+		n.nextIssue = n.ticks + 3 + n.simulation.rng.Intn(4)
+		op := "append"
+		if n.simulation.rng.Float64() < 0.05 {
+			op = "set"
+		}
+		n.submit(op, keys[n.simulation.rng.Intn(len(keys))], n.id[len(n.id)-1:])
+	}
+}
+
+func (n *Node) GetState() map[string]interface{} {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	return map[string]interface{}{
+		"id":           n.id,
+		"status":       n.status,
+		"role":         n.log.Role(),
+		"consensus":    n.log.Name(),
+		"appliedIndex": n.appliedIndex,
+		"applied":      n.applied,
+		"highestIndex": n.highest,
+		"heldBack":     len(n.holdBack),
+		"ahead":        len(n.seen),
+		"outOfOrder":   n.outOfOrder,
+		"recent":       append([]int{}, n.recent...),
+		"store":        n.store.Snapshot(),
+		"digest":       n.store.Digest(),
+		"issued":       n.issued,
+	}
+}
+
+func (n *Node) handleMessage(env *transport.Envelope) {
+	n.mu.RLock()
+	crashed := n.status != "running"
+	n.mu.RUnlock()
+
+	// Crashed nodes do not receive anything
+	if crashed {
+		return
+	}
+	select {
+	case n.inbox <- env:
+	default:
+	}
+}
+
+// submit hands a client command to the consensus layer (must hold n.mu)
+func (n *Node) submit(op, key, value string) {
+	n.issued++
+	n.log.Submit(Command{
+		ID:     fmt.Sprintf("%s-%d", n.id, n.issued),
+		Op:     op,
+		Key:    key,
+		Value:  value,
+		Client: n.id,
+	})
+}
+
+// committed receives an entry from the consensus layer. Entries wait in
+// the hold-back queue until every earlier index is applied, unless the
+// scenario forces applying them on arrival (must hold n.mu).
+func (n *Node) committed(entry Entry) {
+	if entry.Index <= n.appliedIndex || n.seen[entry.Index] {
+		return // Duplicate
+	}
+	if _, ok := n.holdBack[entry.Index]; ok {
+		return
+	}
+	if entry.Index > n.highest {
+		n.highest = entry.Index
+	}
+	before := n.appliedIndex
+	early := entry.Index != n.appliedIndex+1
+
+	if n.simulation.outOfOrder {
+		if early {
+			n.outOfOrder++
+		}
+		n.seen[entry.Index] = true
+		n.apply(entry, early)
+		for n.seen[n.appliedIndex+1] {
+			delete(n.seen, n.appliedIndex+1)
+			n.appliedIndex++
+		}
+	} else {
+		n.holdBack[entry.Index] = entry.Command
+		if early {
+			n.simulation.broadcast(map[string]interface{}{
+				"type":     "entry_held_back",
+				"nodeId":   n.id,
+				"index":    entry.Index,
+				"expected": n.appliedIndex + 1,
+			})
+		}
+		for {
+			cmd, ok := n.holdBack[n.appliedIndex+1]
+			if !ok {
+				break
+			}
+			delete(n.holdBack, n.appliedIndex+1)
+			n.appliedIndex++
+			n.apply(Entry{Index: n.appliedIndex, Command: cmd}, false)
+		}
+	}
+
+	// The gap timer runs from the last time the applied prefix grew
+	if n.appliedIndex != before || n.waiting() == 0 {
+		n.gapSince = n.ticks
+	}
+}
+
+// waiting returns the number of entries received ahead of a gap
+func (n *Node) waiting() int {
+	return len(n.holdBack) + len(n.seen)
+}
+
+// apply runs an entry on the store; early entries skipped ahead of an
+// index that is still missing (must hold n.mu)
+func (n *Node) apply(entry Entry, early bool) {
+	value, err := n.store.Apply(entry.Command)
+	if err != nil {
+		return
+	}
+	n.applied++
+	n.recent = append(n.recent, entry.Index)
+	if len(n.recent) > recentLength {
+		n.recent = n.recent[1:]
+	}
+
+	n.simulation.broadcast(map[string]interface{}{
+		"type":       "command_applied",
+		"nodeId":     n.id,
+		"index":      entry.Index,
+		"command":    entry.Command.ID,
+		"key":        entry.Command.Key,
+		"value":      value,
+		"outOfOrder": early,
+	})
+}
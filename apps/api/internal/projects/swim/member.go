@@ -0,0 +1,398 @@
+package swim
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+const (
+	MsgPing    transport.MessageType = "ping"
+	MsgPingReq transport.MessageType = "ping_req"
+	MsgAck     transport.MessageType = "ack"
+)
+
+const (
+	indirectProbes = 3 // k, members asked to probe a target that missed its ack
+	maxPiggyback   = 6 // Updates carried by a message
+)
+
+// Update is a rumor about a member, gossiped on the probe messages. A
+// higher incarnation, which only the member itself can raise, overrides
+// what was said about an older one.
+type Update struct {
+	Member      string `json:"member"`
+	State       string `json:"state"` // "alive", "suspect" or "dead"
+	Incarnation int    `json:"incarnation"`
+}
+
+// Peer is what a member believes about another
+type Peer struct {
+	State       string `json:"state"`
+	Incarnation int    `json:"incarnation"`
+	Since       int    `json:"since"` // Tick of the last change
+}
+
+// rumor is an update still to be piggybacked a number of times
+type rumor struct {
+	update Update
+	left   int
+}
+
+// Member runs SWIM. Every protocol period it pings one member, in a
+// shuffled round-robin order; without an ack in time it asks k others to
+// ping the target for it, and without any ack by the end of the period it
+// suspects the target. A suspect not refuted within the suspicion timeout
+// is declared dead. Suspicions, refutations and deaths travel piggybacked
+// on the pings and acks, each retransmitted a logarithmic number of
+// times, so the load per member stays constant however many there are.
+type Member struct {
+	mu sync.RWMutex
+
+	id          string
+	status      string // "running" or "crashed"
+	ticks       int
+	incarnation int
+
+	view   map[string]*Peer
+	order  []string // Probe order, reshuffled every round
+	next   int
+	gossip []*rumor
+
+	// Probe of the current period
+	target      string
+	seq         int
+	sentAt      int
+	periodStart int
+	acked       bool
+	indirect    bool // Ping-reqs sent
+
+	probes     int
+	suspicions int // Targets this member suspected itself
+	refutes    int
+
+	inbox      chan *transport.Envelope
+	simulation *Simulation
+}
+
+func newMember(id string, sim *Simulation) *Member {
+	return &Member{
+		id:         id,
+		status:     "running",
+		view:       make(map[string]*Peer),
+		inbox:      make(chan *transport.Envelope, 500),
+		simulation: sim,
+	}
+}
+
+// join fills the member's view with every other member, alive
+func (m *Member) join(ids []string) {
+	for _, id := range ids {
+		if id != m.id {
+			m.view[id] = &Peer{State: "alive"}
+			m.order = append(m.order, id)
+		}
+	}
+	m.simulation.rng.Shuffle(len(m.order), func(i, j int) { m.order[i], m.order[j] = m.order[j], m.order[i] })
+}
+
+// Member implements engine.NodeController
+
+func (m *Member) ID() string {
+	return m.id
+}
+
+func (m *Member) Start(ctx context.Context) error {
+	return nil
+}
+
+func (m *Member) Stop() error {
+	return nil
+}
+
+func (m *Member) Tick() {
+	m.simulation.advanceSchedule(m.tick())
+}
+
+func (m *Member) tick() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.ticks++
+	if m.status == "crashed" {
+		return m.ticks
+	}
+
+	for i := 0; i < 50; i++ {
+		select {
+		case env := <-m.inbox:
+			m.processMessage(env)
+			continue
+		default:
+		}
+		break
+	}
+
+	sim := m.simulation
+	probeInterval, ackTimeout, suspicionTimeout := sim.timing()
+	switch {
+	case m.ticks-m.periodStart >= probeInterval:
+		m.probe()
+	case m.target != "" && !m.acked && !m.indirect && m.ticks-m.sentAt > ackTimeout:
+		m.probeIndirectly()
+	}
+
+	for _, id := range m.viewIDs() {
+		peer := m.view[id]
+		if peer.State == "suspect" && m.ticks-peer.Since >= suspicionTimeout {
+			peer.State = "dead"
+			peer.Since = m.ticks
+			m.spread(Update{Member: id, State: "dead", Incarnation: peer.Incarnation})
+			sim.declaredDead(m.id, id, peer.Incarnation)
+		}
+	}
+	return m.ticks
+}
+
+func (m *Member) GetState() map[string]interface{} {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	view := make(map[string]string, len(m.view))
+	suspected := make([]string, 0)
+	dead := make([]string, 0)
+	for id, peer := range m.view {
+		view[id] = peer.State
+		switch peer.State {
+		case "suspect":
+			suspected = append(suspected, id)
+		case "dead":
+			dead = append(dead, id)
+		}
+	}
+	sort.Strings(suspected)
+	sort.Strings(dead)
+
+	return map[string]interface{}{
+		"id":          m.id,
+		"status":      m.status,
+		"role":        "member",
+		"incarnation": m.incarnation,
+		"view":        view, // Member -> alive, suspect or dead
+		"suspected":   suspected,
+		"dead":        dead,
+		"probing":     m.target,
+		"acked":       m.acked,
+		"rumors":      len(m.gossip), // Updates still being piggybacked
+		"probes":      m.probes,
+		"suspicions":  m.suspicions,
+		"refutes":     m.refutes,
+	}
+}
+
+func (m *Member) handleMessage(env *transport.Envelope) {
+	m.mu.RLock()
+	down := m.status == "crashed"
+	m.mu.RUnlock()
+
+	if down {
+		return
+	}
+	select {
+	case m.inbox <- env:
+	default:
+	}
+}
+
+func (m *Member) processMessage(env *transport.Envelope) {
+	payload := m.simulation.received(env)
+	for _, update := range payload.Updates {
+		m.merge(update)
+	}
+
+	switch env.Type {
+	case MsgPing:
+		// Ack to whoever pinged, the prober or a member probing for it
+		m.send(env.From, MsgAck, Payload{Seq: payload.Seq, Target: m.id, Origin: payload.Origin})
+	case MsgPingReq:
+		m.send(payload.Target, MsgPing, Payload{Seq: payload.Seq, Origin: env.From})
+	case MsgAck:
+		if payload.Origin != "" && payload.Origin != m.id {
+			// An ack to an indirect probe: pass it on to the prober
+			m.send(payload.Origin, MsgAck, Payload{Seq: payload.Seq, Target: payload.Target, Origin: payload.Origin})
+			return
+		}
+		if payload.Target == m.target && payload.Seq == m.seq {
+			m.acked = true
+		}
+	}
+}
+
+// send transmits a message with the freshest rumors piggybacked (must
+// hold m.mu)
+func (m *Member) send(to string, msgType transport.MessageType, payload Payload) {
+	payload.Updates = m.piggyback()
+	m.simulation.send(m.id, to, msgType, payload)
+}
+
+// probe closes the period, suspecting a target that never acked, and
+// pings the next member in the order (must hold m.mu)
+func (m *Member) probe() {
+	if m.target != "" && !m.acked {
+		m.suspect(m.target)
+	}
+	m.periodStart = m.ticks
+	m.target, m.acked, m.indirect = "", false, false
+
+	target := m.nextTarget()
+	if target == "" {
+		return
+	}
+	m.seq++
+	m.target = target
+	m.sentAt = m.ticks
+	m.probes++
+	m.simulation.probed()
+	m.send(target, MsgPing, Payload{Seq: m.seq})
+}
+
+// probeIndirectly asks k random members to ping the target, in case the
+// direct path or the ack was only slow (must hold m.mu)
+func (m *Member) probeIndirectly() {
+	m.indirect = true
+	helpers := make([]string, 0, len(m.view))
+	for _, id := range m.viewIDs() {
+		if id != m.target && m.view[id].State != "dead" {
+			helpers = append(helpers, id)
+		}
+	}
+	m.simulation.rng.Shuffle(len(helpers), func(i, j int) { helpers[i], helpers[j] = helpers[j], helpers[i] })
+	for _, helper := range helpers[:min(indirectProbes, len(helpers))] {
+		m.send(helper, MsgPingReq, Payload{Seq: m.seq, Target: m.target})
+	}
+}
+
+// viewIDs returns the members in the view in a fixed order, so that runs
+// with the same seed make the same choices (must hold m.mu)
+func (m *Member) viewIDs() []string {
+	ids := make([]string, 0, len(m.view))
+	for id := range m.view {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// nextTarget returns the next member not known dead, reshuffling the
+// order at the end of every round (must hold m.mu)
+func (m *Member) nextTarget() string {
+	for range m.order {
+		if m.next >= len(m.order) {
+			m.next = 0
+			m.simulation.rng.Shuffle(len(m.order), func(i, j int) { m.order[i], m.order[j] = m.order[j], m.order[i] })
+		}
+		id := m.order[m.next]
+		m.next++
+		if m.view[id].State != "dead" {
+			return id
+		}
+	}
+	return ""
+}
+
+// suspect starts suspecting a member that missed a probe (must hold m.mu)
+func (m *Member) suspect(id string) {
+	peer := m.view[id]
+	if peer.State != "alive" {
+		return
+	}
+	peer.State = "suspect"
+	peer.Since = m.ticks
+	m.suspicions++
+	m.spread(Update{Member: id, State: "suspect", Incarnation: peer.Incarnation})
+	m.simulation.suspected(m.id, id, peer.Incarnation)
+}
+
+// merge applies a rumor if it overrides what the member believes. A
+// rumor that the member itself is suspected or dead is refuted with a
+// higher incarnation (must hold m.mu)
+func (m *Member) merge(update Update) {
+	if update.Member == m.id {
+		if update.State != "alive" && update.Incarnation >= m.incarnation {
+			m.incarnation = update.Incarnation + 1
+			m.refutes++
+			m.spread(Update{Member: m.id, State: "alive", Incarnation: m.incarnation})
+			m.simulation.refuted(m.id, update.State, m.incarnation)
+		}
+		return
+	}
+	peer, ok := m.view[update.Member]
+	if !ok || !overrides(update, peer) {
+		return
+	}
+	peer.State = update.State
+	peer.Incarnation = update.Incarnation
+	peer.Since = m.ticks
+	m.spread(update)
+}
+
+// overrides reports whether an update supersedes a member's belief:
+// alive needs a higher incarnation, even over dead, which is how a
+// member rejoins; suspect needs at least the same one; dead is final
+// for the incarnation
+func overrides(update Update, peer *Peer) bool {
+	switch update.State {
+	case "alive":
+		return update.Incarnation > peer.Incarnation
+	case "suspect":
+		return (peer.State == "alive" && update.Incarnation >= peer.Incarnation) ||
+			(peer.State == "suspect" && update.Incarnation > peer.Incarnation)
+	case "dead":
+		return peer.State != "dead" || update.Incarnation > peer.Incarnation
+	}
+	return false
+}
+
+// spread queues a rumor for piggybacking, replacing any older one about
+// the same member (must hold m.mu)
+func (m *Member) spread(update Update) {
+	for i, r := range m.gossip {
+		if r.update.Member == update.Member {
+			m.gossip = append(m.gossip[:i], m.gossip[i+1:]...)
+			break
+		}
+	}
+	m.gossip = append(m.gossip, &rumor{update: update, left: m.simulation.retransmits()})
+}
+
+// piggyback takes the rumors sent the fewest times so far, dropping those
+// sent often enough (must hold m.mu)
+func (m *Member) piggyback() []Update {
+	sort.SliceStable(m.gossip, func(i, j int) bool { return m.gossip[i].left > m.gossip[j].left })
+	updates := make([]Update, 0, maxPiggyback)
+	for _, r := range m.gossip[:min(maxPiggyback, len(m.gossip))] {
+		updates = append(updates, r.update)
+		r.left--
+	}
+	kept := m.gossip[:0]
+	for _, r := range m.gossip {
+		if r.left > 0 {
+			kept = append(kept, r)
+		}
+	}
+	m.gossip = kept
+	return updates
+}
+
+// believes returns what the member thinks of another, for the invariants
+func (m *Member) believes(id string) string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if peer, ok := m.view[id]; ok {
+		return peer.State
+	}
+	return ""
+}
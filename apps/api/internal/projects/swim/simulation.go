@@ -0,0 +1,488 @@
+package swim
+
+import (
+	"context"
+	"fmt"
+	"math/bits"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+	"github.com/ersantana/distributed-systems-learning/packages/simulation/engine"
+)
+
+const (
+	crashAt   = 60          // Tick the "crash" scenario crashes member-5
+	slowFrom  = 40          // Tick the "slow_member" scenario slows member-3 down
+	slowUntil = 240         // Tick it restores it
+	slowDelay = time.Second // Delay of a slow member's messages
+)
+
+// Payload is the content of all messages exchanged in this project
+type Payload struct {
+	Seq     int      `json:"seq"`
+	Target  string   `json:"target,omitempty"` // Member probed
+	Origin  string   `json:"origin,omitempty"` // Prober of an indirect probe
+	Updates []Update `json:"updates,omitempty"`
+}
+
+// Simulation runs SWIM membership. Scenario "crash" crashes a member and
+// measures how long the others take to declare it dead. Scenario
+// "slow_member" makes one member's messages late for a while, so its acks
+// miss the probe period: it gets suspected while alive, and survives if
+// its refutation spreads before the suspicion timeout. Scenario
+// "high_latency" stretches every link's latency, the same trade-off
+// across the whole group. The probe interval, ack timeout and suspicion
+// timeout are tunable while it runs.
+type Simulation struct {
+	mu sync.RWMutex
+
+	engine    *engine.Engine
+	rng       *rand.Rand
+	transport *transport.NetworkTransport
+	broadcast func(interface{})
+
+	members  []*Member
+	scenario string
+
+	probeInterval    int // Ticks of a protocol period
+	ackTimeout       int // Ticks to wait for a direct ack before the ping-reqs
+	suspicionTimeout int // Ticks a suspect has to refute before it is dead
+
+	crashedAt  map[string]int  // Member -> tick it crashed
+	detected   map[string]bool // Crashed members declared dead since
+	detections []int           // Ticks from a crash to the first death declared
+	deaths     map[Update]bool // Deaths declared, per incarnation
+
+	probes          int
+	suspicions      int
+	falseSuspicions int // Running members suspected
+	falseDeaths     int // Running members declared dead
+	refutations     int
+	messages        int
+	lastTick        int
+
+	running bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// Config for SWIM simulation
+type Config struct {
+	NodeCount int
+	Scenario  string // "crash", "slow_member", "high_latency"
+}
+
+// NewSimulation creates a new SWIM simulation
+func NewSimulation(eng *engine.Engine, trans *transport.NetworkTransport, broadcast func(interface{}), config Config) *Simulation {
+	switch config.Scenario {
+	case "crash", "slow_member", "high_latency":
+	default:
+		config.Scenario = "crash"
+	}
+	if config.NodeCount == 0 {
+		config.NodeCount = 8
+	}
+	config.NodeCount = max(config.NodeCount, 5)
+
+	sim := &Simulation{
+		engine:           eng,
+		rng:              eng.Rand(),
+		transport:        trans,
+		broadcast:        broadcast,
+		scenario:         config.Scenario,
+		probeInterval:    8,
+		ackTimeout:       2,
+		suspicionTimeout: 30,
+		crashedAt:        make(map[string]int),
+		detected:         make(map[string]bool),
+		deaths:           make(map[Update]bool),
+	}
+
+	trans.SetLatency(20*time.Millisecond, 80*time.Millisecond)
+	if config.Scenario == "high_latency" {
+		trans.SetLatency(50*time.Millisecond, 450*time.Millisecond)
+	}
+	trans.SetPacketLoss(0)
+
+	ids := make([]string, config.NodeCount)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("member-%d", i+1)
+	}
+	for _, id := range ids {
+		member := newMember(id, sim)
+		member.join(ids)
+		sim.members = append(sim.members, member)
+		trans.RegisterHandler(member.id, member.handleMessage)
+		eng.AddNode(member)
+	}
+
+	return sim
+}
+
+// Start starts the simulation
+func (s *Simulation) Start(ctx context.Context) error {
+	s.mu.Lock()
+	s.running = true
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.mu.Unlock()
+
+	return s.engine.Start(ctx)
+}
+
+// Stop stops the simulation
+func (s *Simulation) Stop() error {
+	s.mu.Lock()
+	s.running = false
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Unlock()
+
+	return s.engine.Stop()
+}
+
+// GetState returns the current simulation state
+func (s *Simulation) GetState() *protocol.SimulationStateResponse {
+	nodes := make(map[string]protocol.NodeState)
+	for _, member := range s.members {
+		state := member.GetState()
+		nodes[member.id] = protocol.NodeState{
+			ID:          member.id,
+			Status:      state["status"].(string),
+			Role:        "member",
+			CustomState: state,
+		}
+	}
+
+	s.mu.RLock()
+	running := s.running
+	rate, perPeriod := 0.0, 0.0
+	if s.probes > 0 {
+		rate = float64(s.falseSuspicions) / float64(s.probes)
+		perPeriod = float64(s.messages) / float64(s.probes)
+	}
+	metadata := map[string]interface{}{
+		"scenario":            s.scenario,
+		"probeInterval":       s.probeInterval,
+		"ackTimeout":          s.ackTimeout,
+		"suspicionTimeout":    s.suspicionTimeout,
+		"probes":              s.probes,
+		"suspicions":          s.suspicions,
+		"falseSuspicions":     s.falseSuspicions,
+		"falsePositiveRate":   rate, // False suspicions per probe
+		"falseDeaths":         s.falseDeaths,
+		"refutations":         s.refutations,
+		"messages":            s.messages,
+		"messagesPerProbe":    perPeriod, // Constant in the group size
+		"detectionTicks":      append([]int{}, s.detections...),
+		"retransmitsPerRumor": s.retransmitsLocked(),
+	}
+	s.mu.RUnlock()
+
+	mode := "step"
+	if s.engine != nil {
+		mode = s.engine.GetMode().String()
+	}
+
+	return &protocol.SimulationStateResponse{
+		Type:        protocol.MsgSimulationState,
+		VirtualTime: time.Now().UnixMilli(),
+		Mode:        mode,
+		Speed:       1.0,
+		Running:     running,
+		Nodes:       nodes,
+		Metadata:    metadata,
+	}
+}
+
+// GetNodes returns node states
+func (s *Simulation) GetNodes() map[string]protocol.NodeState {
+	state := s.GetState()
+	return state.Nodes
+}
+
+// CrashNode crashes a member; the others have to notice
+func (s *Simulation) CrashNode(nodeID string) error {
+	member := s.findMember(nodeID)
+	if member == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	member.mu.Lock()
+	member.status = "crashed"
+	member.mu.Unlock()
+
+	s.mu.Lock()
+	s.crashedAt[nodeID] = s.lastTick
+	delete(s.detected, nodeID)
+	s.mu.Unlock()
+	return nil
+}
+
+// RecoverNode recovers a crashed member; it rejoins with a higher
+// incarnation, which overrides its death
+func (s *Simulation) RecoverNode(nodeID string) error {
+	member := s.findMember(nodeID)
+	if member == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	member.mu.Lock()
+	member.status = "running"
+	member.incarnation++
+	member.periodStart = member.ticks
+	member.target = ""
+	member.spread(Update{Member: member.id, State: "alive", Incarnation: member.incarnation})
+	member.mu.Unlock()
+
+	s.mu.Lock()
+	delete(s.crashedAt, nodeID)
+	s.mu.Unlock()
+	return nil
+}
+
+// HandleClientRequest runs a client command: "configure" sets the timing
+// of the protocol, {probeInterval, ackTimeout, suspicionTimeout} in
+// ticks, each optional
+func (s *Simulation) HandleClientRequest(command string, payload map[string]interface{}) error {
+	if command != "configure" {
+		return fmt.Errorf("unknown command: %s", command)
+	}
+
+	s.mu.Lock()
+	probeInterval, ackTimeout, suspicionTimeout := s.probeInterval, s.ackTimeout, s.suspicionTimeout
+	if v, ok := payload["probeInterval"].(float64); ok {
+		probeInterval = int(v)
+	}
+	if v, ok := payload["ackTimeout"].(float64); ok {
+		ackTimeout = int(v)
+	}
+	if v, ok := payload["suspicionTimeout"].(float64); ok {
+		suspicionTimeout = int(v)
+	}
+	if ackTimeout < 1 || probeInterval <= ackTimeout || suspicionTimeout < 1 {
+		s.mu.Unlock()
+		return fmt.Errorf("need 1 <= ackTimeout < probeInterval and suspicionTimeout >= 1, got %d, %d, %d", ackTimeout, probeInterval, suspicionTimeout)
+	}
+	s.probeInterval, s.ackTimeout, s.suspicionTimeout = probeInterval, ackTimeout, suspicionTimeout
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":             "timing_changed",
+		"probeInterval":    probeInterval,
+		"ackTimeout":       ackTimeout,
+		"suspicionTimeout": suspicionTimeout,
+	})
+	return nil
+}
+
+// NodeActions lists the actions of a member: "slow_down" delays every
+// message it sends, "restore" ends the delay
+func (s *Simulation) NodeActions(nodeID string) []string {
+	if s.findMember(nodeID) == nil {
+		return nil
+	}
+	if s.transport.NodeDelay(nodeID) > 0 {
+		return []string{"restore"}
+	}
+	return []string{"slow_down"}
+}
+
+// InvokeNodeAction invokes an action listed by NodeActions; "slow_down"
+// takes an optional {delayMs}
+func (s *Simulation) InvokeNodeAction(nodeID, action string, params map[string]interface{}) error {
+	if s.findMember(nodeID) == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	switch action {
+	case "slow_down":
+		delay := slowDelay
+		if ms, ok := params["delayMs"].(float64); ok && ms > 0 {
+			delay = time.Duration(ms) * time.Millisecond
+		}
+		s.slow(nodeID, delay)
+	case "restore":
+		s.slow(nodeID, 0)
+	default:
+		return fmt.Errorf("unknown action: %s", action)
+	}
+	return nil
+}
+
+// findMember looks up a member by ID
+func (s *Simulation) findMember(nodeID string) *Member {
+	for _, member := range s.members {
+		if member.id == nodeID {
+			return member
+		}
+	}
+	return nil
+}
+
+// slow delays the messages a member sends, or restores it with no delay
+func (s *Simulation) slow(nodeID string, delay time.Duration) {
+	if delay > 0 {
+		s.transport.SetNodeDelay(nodeID, delay)
+	} else {
+		s.transport.ClearNodeDelay(nodeID)
+	}
+	s.broadcast(map[string]interface{}{
+		"type":    "member_slowed",
+		"nodeId":  nodeID,
+		"delayMs": delay.Milliseconds(),
+	})
+}
+
+// timing returns the probe interval, ack timeout and suspicion timeout
+func (s *Simulation) timing() (int, int, int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.probeInterval, s.ackTimeout, s.suspicionTimeout
+}
+
+// retransmits returns how many messages piggyback each rumor: λ·log n,
+// enough for it to reach every member with high probability
+func (s *Simulation) retransmits() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.retransmitsLocked()
+}
+
+// retransmitsLocked is retransmits (must hold s.mu)
+func (s *Simulation) retransmitsLocked() int {
+	return 3 * bits.Len(uint(len(s.members)))
+}
+
+// down reports whether a member is really crashed (must hold s.mu)
+func (s *Simulation) down(nodeID string) bool {
+	_, ok := s.crashedAt[nodeID]
+	return ok
+}
+
+// advanceSchedule applies the faults of the scenario, once per tick
+func (s *Simulation) advanceSchedule(ticks int) {
+	s.mu.Lock()
+	if ticks <= s.lastTick {
+		s.mu.Unlock()
+		return
+	}
+	s.lastTick = ticks
+	s.mu.Unlock()
+
+	switch {
+	case s.scenario == "crash" && ticks == crashAt:
+		victim := s.members[4].id
+		s.CrashNode(victim)
+		s.broadcast(map[string]interface{}{
+			"type":   "node_crashed",
+			"nodeId": victim,
+		})
+	case s.scenario == "slow_member" && ticks == slowFrom:
+		s.slow(s.members[2].id, slowDelay)
+	case s.scenario == "slow_member" && ticks == slowUntil:
+		s.slow(s.members[2].id, 0)
+	}
+}
+
+// probed counts a protocol period's probe
+func (s *Simulation) probed() {
+	s.mu.Lock()
+	s.probes++
+	s.mu.Unlock()
+}
+
+// suspected records a prober suspecting the member it probed
+func (s *Simulation) suspected(proberID, memberID string, incarnation int) {
+	s.mu.Lock()
+	s.suspicions++
+	mistaken := !s.down(memberID)
+	if mistaken {
+		s.falseSuspicions++
+	}
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":        "member_suspected",
+		"nodeId":      proberID,
+		"member":      memberID,
+		"incarnation": incarnation,
+		"mistaken":    mistaken, // The member is actually running
+	})
+}
+
+// declaredDead records a member's suspicion of another timing out
+func (s *Simulation) declaredDead(nodeID, memberID string, incarnation int) {
+	death := Update{Member: memberID, State: "dead", Incarnation: incarnation}
+
+	s.mu.Lock()
+	first := !s.deaths[death]
+	s.deaths[death] = true
+	mistaken := !s.down(memberID)
+	if first && mistaken {
+		s.falseDeaths++
+	}
+	if crashed, ok := s.crashedAt[memberID]; ok && !s.detected[memberID] {
+		s.detected[memberID] = true
+		s.detections = append(s.detections, s.lastTick-crashed)
+	}
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":        "member_dead",
+		"nodeId":      nodeID,
+		"member":      memberID,
+		"incarnation": incarnation,
+		"mistaken":    mistaken,
+	})
+}
+
+// refuted records a member denying a rumor about itself
+func (s *Simulation) refuted(memberID, rumor string, incarnation int) {
+	s.mu.Lock()
+	s.refutations++
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":        "member_refuted",
+		"nodeId":      memberID,
+		"rumor":       rumor,
+		"incarnation": incarnation,
+	})
+}
+
+// send transmits a message and reports it to the frontend
+func (s *Simulation) send(from, to string, msgType transport.MessageType, payload Payload) {
+	env := transport.NewEnvelope(from, to, msgType, payload)
+
+	s.mu.Lock()
+	s.messages++
+	s.mu.Unlock()
+
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageSent,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+
+	s.transport.Send(s.ctx, env)
+}
+
+// received reports a delivered message and returns its payload
+func (s *Simulation) received(env *transport.Envelope) Payload {
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageReceived,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+	payload, _ := env.Payload.(Payload)
+	return payload
+}
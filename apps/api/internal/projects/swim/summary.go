@@ -0,0 +1,80 @@
+package swim
+
+import (
+	"fmt"
+
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+)
+
+// Invariants judges the run on SWIM's two promises, completeness and
+// accuracy: a crashed member is eventually declared dead by everyone, and
+// a running member is at most suspected, refuting before it is declared
+// dead. A suspicion timeout shorter than a slow member's refutation takes
+// breaks the second.
+func (s *Simulation) Invariants() []protocol.InvariantResult {
+	s.mu.RLock()
+	lastTick := s.lastTick
+	crashedAt := make(map[string]int, len(s.crashedAt))
+	for id, tick := range s.crashedAt {
+		crashedAt[id] = tick
+	}
+	// A crashed member is probed within a round of periods, then has the
+	// suspicion timeout and the gossip to get through
+	bound := len(s.members)*s.probeInterval + s.suspicionTimeout + s.retransmitsLocked()*s.probeInterval
+	falseDeaths, falseSuspicions, probes, refutations := s.falseDeaths, s.falseSuspicions, s.probes, s.refutations
+	detections := append([]int{}, s.detections...)
+	s.mu.RUnlock()
+
+	// Running members that still believe a long-crashed member is alive
+	missed, judged := 0, 0
+	for id, tick := range crashedAt {
+		if lastTick-tick < bound {
+			continue
+		}
+		judged++
+		for _, member := range s.members {
+			member.mu.RLock()
+			up := member.status == "running"
+			member.mu.RUnlock()
+			if up && member.believes(id) != "dead" {
+				missed++
+			}
+		}
+	}
+	completeness := fmt.Sprintf("%d members crashed for over %d ticks, %d views still without their death", judged, bound, missed)
+	if len(detections) > 0 {
+		completeness += fmt.Sprintf("; first death declared %v ticks after each crash", detections)
+	}
+
+	rate := 0.0
+	if probes > 0 {
+		rate = float64(falseSuspicions) / float64(probes)
+	}
+	return []protocol.InvariantResult{
+		{
+			Name:   "crashed members are declared dead",
+			Holds:  missed == 0,
+			Detail: completeness,
+		},
+		{
+			Name:   "no running member is declared dead",
+			Holds:  falseDeaths == 0,
+			Detail: fmt.Sprintf("%d false deaths; %d false suspicions in %d probes (%.1f%%), %d refutations", falseDeaths, falseSuspicions, probes, 100*rate, refutations),
+		},
+	}
+}
+
+// FollowUps suggests the scenarios that contrast with this one
+func (s *Simulation) FollowUps() []protocol.FollowUp {
+	switch s.scenario {
+	case "slow_member", "high_latency":
+		return []protocol.FollowUp{
+			{Project: "swim", Scenario: "crash", Reason: "Detect a member that really crashed and time it"},
+			{Project: "election", Reason: "See what a false suspicion costs when it triggers an election"},
+		}
+	}
+	return []protocol.FollowUp{
+		{Project: "swim", Scenario: "slow_member", Reason: "Slow a member down and watch it refute the suspicions"},
+		{Project: "swim", Scenario: "high_latency", Reason: "Stretch every link and tune the timeouts against false positives"},
+	}
+}
@@ -0,0 +1,277 @@
+package truetime
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+const (
+	MsgPrepare transport.MessageType = "prepare"
+	MsgAck     transport.MessageType = "ack"
+	MsgCommit  transport.MessageType = "commit"
+)
+
+const (
+	driftMs      = 5.0 // Most a clock's error moves in a tick
+	abortTicks   = 50  // Ticks a transaction waits for a quorum before aborting
+	minEpsilonMs = 10.0
+	maxEpsilonMs = 5000.0
+)
+
+// Interval is what TT.now() returns: an interval guaranteed to contain
+// the true time
+type Interval struct {
+	Earliest float64 `json:"earliest"`
+	Latest   float64 `json:"latest"`
+}
+
+// Txn is a read-write transaction a node coordinates
+type Txn struct {
+	ID        string  `json:"id"`
+	Timestamp float64 `json:"timestamp"` // Commit timestamp, TT.now().latest when it started
+	issued    int     // Tick the client issued it
+	floor     float64 // Highest timestamp committed before it was issued
+	acks      map[string]bool
+	ackedAt   int  // Tick a quorum held it, 0 until then
+	chained   bool // Issued once the previous chained transaction committed
+}
+
+// Node is a replica with a physical clock whose error stays within ±ε
+// of the true time. It coordinates transactions: it picks the commit
+// timestamp, replicates it to a quorum and, with commit wait, holds the
+// commit until TT.now().earliest has passed the timestamp.
+type Node struct {
+	mu sync.RWMutex
+
+	id        string
+	status    string  // "running" or "crashed"
+	epsilonMs float64 // Bound on the clock error
+	offsetMs  float64 // Clock error: local time minus true time
+	lastTs    float64 // Last timestamp assigned, so they never go backwards
+	pending   map[string]*Txn
+	applied   map[string]float64 // Transactions replicated here, with their timestamp
+	nextID    int
+	committed int
+	ticks     int
+
+	inbox      chan *transport.Envelope
+	simulation *Simulation
+}
+
+func newNode(id string, epsilonMs, offsetMs float64, sim *Simulation) *Node {
+	return &Node{
+		id:         id,
+		status:     "running",
+		epsilonMs:  epsilonMs,
+		offsetMs:   offsetMs,
+		pending:    make(map[string]*Txn),
+		applied:    make(map[string]float64),
+		inbox:      make(chan *transport.Envelope, 500),
+		simulation: sim,
+	}
+}
+
+// Node implements engine.NodeController
+
+func (n *Node) ID() string {
+	return n.id
+}
+
+func (n *Node) Start(ctx context.Context) error {
+	return nil
+}
+
+func (n *Node) Stop() error {
+	return nil
+}
+
+func (n *Node) Tick() {
+	n.simulation.advanceSchedule(n.tick())
+}
+
+func (n *Node) tick() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.ticks++
+	if n.status == "crashed" {
+		return n.ticks
+	}
+
+	n.drift()
+	for i := 0; i < 50; i++ {
+		select {
+		case env := <-n.inbox:
+			n.processMessage(env)
+			continue
+		default:
+		}
+		break
+	}
+
+	n.finish()
+	return n.ticks
+}
+
+func (n *Node) GetState() map[string]interface{} {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	now := n.now()
+	return map[string]interface{}{
+		"id":        n.id,
+		"status":    n.status,
+		"epsilonMs": n.epsilonMs,
+		"offsetMs":  math.Round(n.offsetMs*10) / 10,
+		"clockMs":   math.Round(n.localMs()),
+		"earliest":  math.Round(now.Earliest),
+		"latest":    math.Round(now.Latest),
+		"lastTs":    n.lastTs,
+		"pending":   len(n.pending),
+		"applied":   len(n.applied),
+		"committed": n.committed,
+	}
+}
+
+func (n *Node) handleMessage(env *transport.Envelope) {
+	n.mu.RLock()
+	down := n.status == "crashed"
+	n.mu.RUnlock()
+
+	if down {
+		return
+	}
+	select {
+	case n.inbox <- env:
+	default:
+	}
+}
+
+func (n *Node) processMessage(env *transport.Envelope) {
+	payload := n.simulation.received(env)
+
+	switch env.Type {
+	case MsgPrepare:
+		n.applied[payload.TxnID] = payload.Timestamp
+		n.simulation.send(n.id, env.From, MsgAck, Payload{TxnID: payload.TxnID})
+	case MsgAck:
+		if txn, ok := n.pending[payload.TxnID]; ok {
+			txn.acks[env.From] = true
+		}
+	case MsgCommit:
+		n.applied[payload.TxnID] = payload.Timestamp
+	}
+}
+
+// localMs reads the node's clock (must hold n.mu)
+func (n *Node) localMs() float64 {
+	return n.simulation.trueMs() + n.offsetMs
+}
+
+// now is TT.now(): the local clock widened by ε on both sides, which
+// holds the true time as long as the error stays within ε (must hold
+// n.mu)
+func (n *Node) now() Interval {
+	local := n.localMs()
+	return Interval{Earliest: local - n.epsilonMs, Latest: local + n.epsilonMs}
+}
+
+// drift moves the clock error a little, keeping it within ε (must hold
+// n.mu)
+func (n *Node) drift() {
+	n.offsetMs += (n.simulation.rng.Float64()*2 - 1) * driftMs
+	n.offsetMs = max(-n.epsilonMs, min(n.epsilonMs, n.offsetMs))
+}
+
+// setEpsilon changes ε; the clock error shrinks with it, as a narrower
+// bound comes from a closer sync with the time masters (must hold n.mu)
+func (n *Node) setEpsilon(epsilonMs float64) {
+	n.epsilonMs = max(minEpsilonMs, min(maxEpsilonMs, epsilonMs))
+	n.offsetMs = max(-n.epsilonMs, min(n.epsilonMs, n.offsetMs))
+}
+
+// begin starts a transaction: its timestamp is TT.now().latest, no
+// earlier than the true time, and it is replicated to the other nodes
+// (must hold n.mu)
+func (n *Node) begin(floor float64, chained bool) (*Txn, error) {
+	if n.status != "running" {
+		return nil, fmt.Errorf("node %s is down", n.id)
+	}
+
+	n.nextID++
+	ts := max(n.now().Latest, n.lastTs+1)
+	n.lastTs = ts
+	txn := &Txn{
+		ID:        fmt.Sprintf("%s-%d", n.id, n.nextID),
+		Timestamp: math.Round(ts),
+		issued:    n.simulation.tickOf(),
+		floor:     floor,
+		acks:      map[string]bool{n.id: true},
+		chained:   chained,
+	}
+	n.pending[txn.ID] = txn
+	n.applied[txn.ID] = txn.Timestamp
+
+	for _, peer := range n.simulation.nodes {
+		if peer.id != n.id {
+			n.simulation.send(n.id, peer.id, MsgPrepare, Payload{TxnID: txn.ID, Timestamp: txn.Timestamp})
+		}
+	}
+	return txn, nil
+}
+
+// finish commits the transactions a quorum holds once commit wait is
+// over, that is once TT.now().earliest is past their timestamp: then
+// the timestamp is in the past on every clock, and any transaction that
+// starts later gets a larger one. Without commit wait they commit as
+// soon as a quorum holds them. (must hold n.mu)
+func (n *Node) finish() {
+	sim := n.simulation
+	tick := sim.tickOf()
+	for id, txn := range n.pending {
+		if txn.ackedAt == 0 && len(txn.acks) >= sim.quorum() {
+			txn.ackedAt = tick
+		}
+		switch {
+		case txn.ackedAt == 0:
+			if tick-txn.issued >= abortTicks {
+				delete(n.pending, id)
+				sim.aborted(n.id, txn)
+			}
+			continue
+		case sim.commitWait && n.now().Earliest <= txn.Timestamp:
+			continue
+		}
+
+		delete(n.pending, id)
+		n.committed++
+		for _, peer := range sim.nodes {
+			if peer.id != n.id {
+				sim.send(n.id, peer.id, MsgCommit, Payload{TxnID: txn.ID, Timestamp: txn.Timestamp})
+			}
+		}
+		sim.committed(n.id, txn)
+	}
+}
+
+// crash aborts the transactions the node coordinates and loses the
+// messages in flight
+func (n *Node) crash() {
+	n.status = "crashed"
+	for id, txn := range n.pending {
+		delete(n.pending, id)
+		n.simulation.aborted(n.id, txn)
+	}
+	for {
+		select {
+		case <-n.inbox:
+			continue
+		default:
+		}
+		break
+	}
+}
@@ -0,0 +1,514 @@
+package truetime
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+	"github.com/ersantana/distributed-systems-learning/packages/simulation/engine"
+)
+
+const (
+	tickMs           = 100.0  // True time a tick stands for, the engine's tick rate
+	defaultEpsilonMs = 250.0  // ε of every clock
+	badEpsilonMs     = 1000.0 // ε of the clock that lost its time masters in "uneven_epsilon"
+	txnEvery         = 3      // Ticks between independent transactions
+)
+
+// Payload is the content of all messages exchanged in this project
+type Payload struct {
+	TxnID     string  `json:"txnId"`
+	Timestamp float64 `json:"timestamp,omitempty"`
+}
+
+// Simulation is Spanner's commit wait. Every node's clock is off by up
+// to ε, and TT.now() answers with the interval [local - ε, local + ε]
+// that holds the true time. A transaction takes TT.now().latest as its
+// timestamp, and its coordinator does not report the commit before
+// TT.now().earliest has passed that timestamp. Any transaction that
+// starts after the commit, on whichever node, then gets a larger
+// timestamp: timestamps follow the real-time order of transactions,
+// which is external consistency. The price is a wait of about 2ε per
+// commit, so the commit latency grows as ε widens.
+//
+// Scenarios: "commit_wait" where every clock has the same ε,
+// "uneven_epsilon" where one clock lost its time masters and has a wide
+// ε, so the transactions it coordinates are slow, and "no_commit_wait"
+// which commits as soon as a quorum holds a transaction: latency drops,
+// and a transaction started after another committed can get a smaller
+// timestamp. A chain of transactions, each issued on the next node once
+// the previous one committed, runs alongside independent ones.
+type Simulation struct {
+	mu sync.RWMutex
+
+	engine    *engine.Engine
+	rng       *rand.Rand
+	transport *transport.NetworkTransport
+	broadcast func(interface{})
+
+	nodes      []*Node
+	scenario   string
+	commitWait bool
+
+	settled  float64 // Highest timestamp committed before the current tick
+	latest   float64 // Highest timestamp committed at the current tick
+	chainAt  int     // Node the next chained transaction goes to
+	chainDue bool    // The chain's last transaction committed or aborted
+
+	issued           int
+	commits          int
+	aborts           int
+	latencyTicks     int            // Ticks from issue to commit, summed
+	waitTicks        int            // Ticks from quorum to commit, summed
+	nodeLatency      map[string]int // Latency ticks summed per coordinator
+	nodeCommits      map[string]int
+	violations       int // Transactions with a timestamp below one committed before they started
+	futureTimestamps int // Commits whose timestamp was still ahead of the true time
+	messages         map[string]int
+
+	lastTick int
+
+	running bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// Config for TrueTime simulation
+type Config struct {
+	NodeCount int
+	Scenario  string // "commit_wait", "uneven_epsilon", "no_commit_wait"
+}
+
+// NewSimulation creates a new TrueTime simulation
+func NewSimulation(eng *engine.Engine, trans *transport.NetworkTransport, broadcast func(interface{}), config Config) *Simulation {
+	switch config.Scenario {
+	case "commit_wait", "uneven_epsilon", "no_commit_wait":
+	default:
+		config.Scenario = "commit_wait"
+	}
+	if config.NodeCount < 3 {
+		config.NodeCount = 3
+	}
+
+	sim := &Simulation{
+		engine:      eng,
+		rng:         eng.Rand(),
+		transport:   trans,
+		broadcast:   broadcast,
+		scenario:    config.Scenario,
+		commitWait:  config.Scenario != "no_commit_wait",
+		chainDue:    true,
+		nodeLatency: make(map[string]int),
+		nodeCommits: make(map[string]int),
+		messages:    make(map[string]int),
+	}
+
+	trans.SetLatency(20*time.Millisecond, 60*time.Millisecond)
+	trans.SetPacketLoss(0)
+
+	for i := 0; i < config.NodeCount; i++ {
+		epsilon := defaultEpsilonMs
+		if config.Scenario == "uneven_epsilon" && i == config.NodeCount-1 {
+			epsilon = badEpsilonMs
+		}
+		// Clocks start spread across their bounds, from slow to fast
+		offset := epsilon * 0.8 * (2*float64(i)/float64(config.NodeCount-1) - 1)
+		node := newNode(fmt.Sprintf("node-%d", i+1), epsilon, offset, sim)
+		sim.nodes = append(sim.nodes, node)
+		trans.RegisterHandler(node.id, node.handleMessage)
+		eng.AddNode(node)
+	}
+
+	return sim
+}
+
+// Start starts the simulation
+func (s *Simulation) Start(ctx context.Context) error {
+	s.mu.Lock()
+	s.running = true
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.mu.Unlock()
+
+	return s.engine.Start(ctx)
+}
+
+// Stop stops the simulation
+func (s *Simulation) Stop() error {
+	s.mu.Lock()
+	s.running = false
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Unlock()
+
+	return s.engine.Stop()
+}
+
+// GetState returns the current simulation state
+func (s *Simulation) GetState() *protocol.SimulationStateResponse {
+	nodes := make(map[string]protocol.NodeState)
+	epsilons := make(map[string]float64)
+	offsets := make(map[string]float64)
+	for _, node := range s.nodes {
+		state := node.GetState()
+		nodes[node.id] = protocol.NodeState{
+			ID:          node.id,
+			Status:      state["status"].(string),
+			Role:        "replica",
+			CustomState: state,
+		}
+		epsilons[node.id] = state["epsilonMs"].(float64)
+		offsets[node.id] = state["offsetMs"].(float64)
+	}
+
+	s.mu.RLock()
+	running := s.running
+	avgLatency, avgWait := 0.0, 0.0
+	if s.commits > 0 {
+		avgLatency = float64(s.latencyTicks) * tickMs / float64(s.commits)
+		avgWait = float64(s.waitTicks) * tickMs / float64(s.commits)
+	}
+	nodeLatency := make(map[string]float64, len(s.nodeCommits))
+	for nodeID, commits := range s.nodeCommits {
+		nodeLatency[nodeID] = math.Round(float64(s.nodeLatency[nodeID]) * tickMs / float64(commits))
+	}
+	messages := make(map[string]int, len(s.messages))
+	for msgType, count := range s.messages {
+		messages[msgType] = count
+	}
+	metadata := map[string]interface{}{
+		"scenario":           s.scenario,
+		"commitWait":         s.commitWait,
+		"trueTimeMs":         float64(s.lastTick) * tickMs,
+		"epsilonMs":          epsilons,
+		"offsetMs":           offsets,
+		"issued":             s.issued,
+		"commits":            s.commits,
+		"aborts":             s.aborts,
+		"avgCommitLatencyMs": math.Round(avgLatency),
+		"avgCommitWaitMs":    math.Round(avgWait), // Part of the latency spent in commit wait
+		"commitLatencyMs":    nodeLatency,         // Average per coordinator
+		"violations":         s.violations,
+		"futureTimestamps":   s.futureTimestamps,
+		"messages":           messages,
+	}
+	s.mu.RUnlock()
+
+	mode := "step"
+	if s.engine != nil {
+		mode = s.engine.GetMode().String()
+	}
+
+	return &protocol.SimulationStateResponse{
+		Type:        protocol.MsgSimulationState,
+		VirtualTime: time.Now().UnixMilli(),
+		Mode:        mode,
+		Speed:       1.0,
+		Running:     running,
+		Nodes:       nodes,
+		Metadata:    metadata,
+	}
+}
+
+// GetNodes returns node states
+func (s *Simulation) GetNodes() map[string]protocol.NodeState {
+	state := s.GetState()
+	return state.Nodes
+}
+
+// CrashNode crashes a node, aborting the transactions it coordinates
+func (s *Simulation) CrashNode(nodeID string) error {
+	node := s.findNode(nodeID)
+	if node == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	node.mu.Lock()
+	node.crash()
+	node.mu.Unlock()
+	return nil
+}
+
+// RecoverNode recovers a crashed node
+func (s *Simulation) RecoverNode(nodeID string) error {
+	node := s.findNode(nodeID)
+	if node == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	node.mu.Lock()
+	node.status = "running"
+	node.mu.Unlock()
+	return nil
+}
+
+// HandleClientRequest runs a client command. Commands are "transaction"
+// (payload: nodeId) which starts a transaction on a node, and
+// "set_epsilon" (payload: nodeId, epsilonMs) which changes a clock's ε.
+func (s *Simulation) HandleClientRequest(command string, payload map[string]interface{}) error {
+	nodeID, _ := payload["nodeId"].(string)
+	switch command {
+	case "transaction":
+		return s.transaction(nodeID, false)
+	case "set_epsilon":
+		epsilon, ok := payload["epsilonMs"].(float64)
+		if !ok || epsilon <= 0 {
+			return fmt.Errorf("epsilonMs must be a positive number")
+		}
+		return s.setEpsilon(nodeID, epsilon)
+	}
+	return fmt.Errorf("unknown command: %s", command)
+}
+
+// NodeActions lists the actions of a node: starting a "transaction",
+// and doubling or halving its clock's ε with "widen_epsilon" and
+// "narrow_epsilon"
+func (s *Simulation) NodeActions(nodeID string) []string {
+	node := s.findNode(nodeID)
+	if node == nil {
+		return nil
+	}
+	node.mu.RLock()
+	defer node.mu.RUnlock()
+
+	if node.status != "running" {
+		return nil
+	}
+	return []string{"transaction", "widen_epsilon", "narrow_epsilon"}
+}
+
+// InvokeNodeAction invokes an action listed by NodeActions
+func (s *Simulation) InvokeNodeAction(nodeID, action string, params map[string]interface{}) error {
+	node := s.findNode(nodeID)
+	if node == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+
+	switch action {
+	case "transaction":
+		return s.transaction(nodeID, false)
+	case "widen_epsilon", "narrow_epsilon":
+		node.mu.RLock()
+		epsilon := node.epsilonMs
+		node.mu.RUnlock()
+		if action == "widen_epsilon" {
+			return s.setEpsilon(nodeID, epsilon*2)
+		}
+		return s.setEpsilon(nodeID, epsilon/2)
+	}
+	return fmt.Errorf("unknown action: %s", action)
+}
+
+// findNode looks up a node by ID; the node list is fixed after
+// construction
+func (s *Simulation) findNode(nodeID string) *Node {
+	for _, node := range s.nodes {
+		if node.id == nodeID {
+			return node
+		}
+	}
+	return nil
+}
+
+// quorum returns the number of nodes forming a majority
+func (s *Simulation) quorum() int {
+	return len(s.nodes)/2 + 1
+}
+
+// tickOf returns the current tick of the simulation
+func (s *Simulation) tickOf() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lastTick
+}
+
+// trueMs returns the true time, which no node can read
+func (s *Simulation) trueMs() float64 {
+	return float64(s.tickOf()) * tickMs
+}
+
+// advanceSchedule issues an independent transaction on a random node
+// every txnEvery ticks, and the next transaction of the chain once the
+// previous one is done
+func (s *Simulation) advanceSchedule(ticks int) {
+	s.mu.Lock()
+	if ticks <= s.lastTick {
+		s.mu.Unlock()
+		return
+	}
+	s.lastTick = ticks
+	s.settled = max(s.settled, s.latest)
+	chain := ""
+	if s.chainDue {
+		chain = s.nodes[s.chainAt%len(s.nodes)].id
+		s.chainAt++
+	}
+	s.mu.Unlock()
+
+	if chain != "" {
+		s.transaction(chain, true)
+	}
+	if ticks%txnEvery == 0 {
+		s.transaction(s.nodes[s.rng.Intn(len(s.nodes))].id, false)
+	}
+}
+
+// transaction starts a transaction on a node
+func (s *Simulation) transaction(nodeID string, chained bool) error {
+	node := s.findNode(nodeID)
+	if node == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+
+	s.mu.Lock()
+	floor := s.settled
+	if chained {
+		s.chainDue = false
+	}
+	s.mu.Unlock()
+
+	node.mu.Lock()
+	txn, err := node.begin(floor, chained)
+	var now Interval
+	if err == nil {
+		now = node.now()
+	}
+	node.mu.Unlock()
+
+	s.mu.Lock()
+	if err != nil {
+		s.chainDue = s.chainDue || chained
+		s.mu.Unlock()
+		return err
+	}
+	s.issued++
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":      "txn_started",
+		"nodeId":    nodeID,
+		"txnId":     txn.ID,
+		"timestamp": txn.Timestamp,
+		"earliest":  math.Round(now.Earliest),
+		"latest":    math.Round(now.Latest),
+		"chained":   chained,
+	})
+	return nil
+}
+
+// setEpsilon changes the ε of a node's clock
+func (s *Simulation) setEpsilon(nodeID string, epsilon float64) error {
+	node := s.findNode(nodeID)
+	if node == nil {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	node.mu.Lock()
+	node.setEpsilon(epsilon)
+	epsilon = node.epsilonMs
+	node.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":      "epsilon_changed",
+		"nodeId":    nodeID,
+		"epsilonMs": epsilon,
+	})
+	return nil
+}
+
+// committed records a commit. The transaction breaks external
+// consistency if its timestamp is not above one that committed before it
+// was issued.
+func (s *Simulation) committed(nodeID string, txn *Txn) {
+	s.mu.Lock()
+	latency := s.lastTick - txn.issued
+	waited := s.lastTick - txn.ackedAt
+	violation := txn.Timestamp <= txn.floor
+	future := txn.Timestamp > float64(s.lastTick)*tickMs
+	s.commits++
+	s.latencyTicks += latency
+	s.waitTicks += waited
+	s.nodeLatency[nodeID] += latency
+	s.nodeCommits[nodeID]++
+	if violation {
+		s.violations++
+	}
+	if future {
+		s.futureTimestamps++
+	}
+	s.latest = max(s.latest, txn.Timestamp)
+	if txn.chained {
+		s.chainDue = true
+	}
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":      "txn_committed",
+		"nodeId":    nodeID,
+		"txnId":     txn.ID,
+		"timestamp": txn.Timestamp,
+		"latencyMs": float64(latency) * tickMs,
+		"waitedMs":  float64(waited) * tickMs,
+	})
+	if violation {
+		s.broadcast(map[string]interface{}{
+			"type":      "external_consistency_violation",
+			"nodeId":    nodeID,
+			"txnId":     txn.ID,
+			"timestamp": txn.Timestamp,
+			"committed": txn.floor, // Timestamp committed before the transaction started
+		})
+	}
+}
+
+// aborted records a transaction that never reached a quorum
+func (s *Simulation) aborted(nodeID string, txn *Txn) {
+	s.mu.Lock()
+	s.aborts++
+	if txn.chained {
+		s.chainDue = true
+	}
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":   "txn_aborted",
+		"nodeId": nodeID,
+		"txnId":  txn.ID,
+	})
+}
+
+// send transmits a message and reports it to the frontend
+func (s *Simulation) send(from, to string, msgType transport.MessageType, payload Payload) {
+	env := transport.NewEnvelope(from, to, msgType, payload)
+
+	s.mu.Lock()
+	s.messages[string(msgType)]++
+	s.mu.Unlock()
+
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageSent,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+
+	s.transport.Send(s.ctx, env)
+}
+
+// received reports a delivered message and returns its payload
+func (s *Simulation) received(env *transport.Envelope) Payload {
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageReceived,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+	payload, _ := env.Payload.(Payload)
+	return payload
+}
@@ -0,0 +1,47 @@
+package truetime
+
+import (
+	"fmt"
+
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+)
+
+// Invariants judges the run: a transaction that started after another
+// committed got a larger timestamp, and no commit was reported while its
+// timestamp was still in the future, which commit wait rules out
+func (s *Simulation) Invariants() []protocol.InvariantResult {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return []protocol.InvariantResult{
+		{
+			Name:   "timestamps follow the real-time order of transactions",
+			Holds:  s.violations == 0,
+			Detail: fmt.Sprintf("%d of %d commits got a timestamp below one committed before they started (commit wait: %v)", s.violations, s.commits, s.commitWait),
+		},
+		{
+			Name:   "commits are reported after their timestamp",
+			Holds:  s.futureTimestamps == 0,
+			Detail: fmt.Sprintf("%d of %d commits reported while their timestamp was ahead of the true time", s.futureTimestamps, s.commits),
+		},
+	}
+}
+
+// FollowUps suggests the scenarios that contrast with this one
+func (s *Simulation) FollowUps() []protocol.FollowUp {
+	switch s.scenario {
+	case "no_commit_wait":
+		return []protocol.FollowUp{
+			{Project: "truetime", Scenario: "commit_wait", Reason: "Wait out the uncertainty and keep timestamps in real-time order"},
+		}
+	case "uneven_epsilon":
+		return []protocol.FollowUp{
+			{Project: "truetime", Scenario: "no_commit_wait", Reason: "Skip commit wait and see timestamps go out of order"},
+			{Project: "clocks", Reason: "Order events with logical clocks instead of physical time"},
+		}
+	}
+	return []protocol.FollowUp{
+		{Project: "truetime", Scenario: "uneven_epsilon", Reason: "Give one clock a wide ε and watch its commits slow down"},
+		{Project: "truetime", Scenario: "no_commit_wait", Reason: "Skip commit wait and see timestamps go out of order"},
+	}
+}
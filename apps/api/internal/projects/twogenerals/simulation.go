@@ -24,6 +24,7 @@ type Simulation struct {
 	mu sync.RWMutex
 
 	engine    *engine.Engine
+	rng       *rand.Rand
 	transport *transport.NetworkTransport
 	broadcast func(interface{})
 
@@ -79,6 +80,7 @@ func NewSimulation(eng *engine.Engine, trans *transport.NetworkTransport, broadc
 
 	sim := &Simulation{
 		engine:    eng,
+		rng:       eng.Rand(),
 		transport: trans,
 		broadcast: broadcast,
 		dropRate:  config.DropRate,
@@ -203,6 +205,40 @@ func (s *Simulation) GetNodes() map[string]protocol.NodeState {
 	return state.Nodes
 }
 
+// Done tells that the run is over once the commander has sent its last
+// proposal and every message was delivered or lost, and read
+func (s *Simulation) Done() (string, bool) {
+	s.mu.RLock()
+	spent := s.round >= s.maxRounds
+	s.mu.RUnlock()
+	if !spent || s.transport.Summary().InFlight > 0 || len(s.commander.inbox) > 0 || len(s.responder.inbox) > 0 {
+		return "", false
+	}
+
+	s.commander.mu.RLock()
+	commander := s.commander.decision
+	s.commander.mu.RUnlock()
+	s.responder.mu.RLock()
+	responder := s.responder.decision
+	s.responder.mu.RUnlock()
+
+	switch {
+	case responder == "":
+		return fmt.Sprintf("no proposal got through in %d rounds: general-1 would %s alone", s.maxRounds, commander), true
+	case responder != commander:
+		return fmt.Sprintf("the generals disagree: general-1 would %s, general-2 %s", commander, responder), true
+	}
+	return fmt.Sprintf("both generals %s, though neither can be sure of the other", commander), true
+}
+
+// Layout places the two generals on either side of the valley
+func (s *Simulation) Layout() *protocol.Layout {
+	return &protocol.Layout{
+		Kind:  protocol.LayoutChain,
+		Order: []string{s.commander.id, s.responder.id},
+	}
+}
+
 // CrashNode crashes a node
 func (s *Simulation) CrashNode(nodeID string) error {
 	s.mu.Lock()
@@ -298,7 +334,10 @@ func (n *GeneralNode) GetState() map[string]interface{} {
 }
 
 func (n *GeneralNode) handleMessage(env *transport.Envelope) {
-	n.inbox <- env
+	select {
+	case n.inbox <- env:
+	default:
+	}
 }
 
 func (n *GeneralNode) processMessage(env *transport.Envelope) {
@@ -432,7 +471,3 @@ func (s *Simulation) GetDropRate() float64 {
 	defer s.mu.RUnlock()
 	return s.dropRate
 }
-
-func init() {
-	rand.Seed(time.Now().UnixNano())
-}
@@ -0,0 +1,313 @@
+package twophaselocking
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+const (
+	workTicks    = 3   // Ticks a transfer computes between its reads and its writes
+	thinkTicks   = 4   // Ticks between transactions
+	restartTicks = 6   // Ticks an aborted transaction waits before retrying
+	lockTimeout  = 15  // Ticks a transaction waits for a lock before aborting, with timeouts
+	auditShare   = 0.3 // Share of the transactions that are audits
+)
+
+// Op is one lock a transaction takes, and what it does with the key
+type Op struct {
+	Key  string `json:"key"`
+	Mode string `json:"mode"` // "read", "write"
+}
+
+// Client runs transactions one after the other: transfers, which read
+// two accounts and write them back with an amount moved from one to the
+// other, and audits, which read every account and check the total. Each
+// lock is requested in turn from the partition owning the key. Under
+// two-phase locking every lock is held until the commit, which carries
+// the writes; with short read locks, a read lock is released as soon as
+// the value is read.
+type Client struct {
+	mu sync.RWMutex
+
+	id     string
+	status string // "running" or "crashed"
+	phase  string // "thinking", "acquiring", "waiting", "working", "aborted"
+	ticks  int
+
+	kind    string // "transfer" or "audit"
+	plan    []Op
+	amount  int
+	next    int // Op waiting for its lock
+	ts      int // Age, kept across restarts so an old transaction eventually wins
+	attempt int
+
+	reads     map[string]int
+	versions  map[string]int
+	touched   map[string]bool // Partitions asked for a lock
+	wakeAt    int
+	requested int
+	blockers  []string
+
+	commits   int
+	aborts    int
+	anomalies int
+
+	inbox      chan *transport.Envelope
+	simulation *Simulation
+}
+
+func newClient(id string, sim *Simulation) *Client {
+	return &Client{
+		id:         id,
+		status:     "running",
+		phase:      "thinking",
+		wakeAt:     sim.rng.Intn(thinkTicks) + 1,
+		inbox:      make(chan *transport.Envelope, 500),
+		simulation: sim,
+	}
+}
+
+// Client implements engine.NodeController
+
+func (c *Client) ID() string {
+	return c.id
+}
+
+func (c *Client) Start(ctx context.Context) error {
+	return nil
+}
+
+func (c *Client) Stop() error {
+	return nil
+}
+
+func (c *Client) Tick() {
+	c.simulation.advanceSchedule(c.tick())
+}
+
+func (c *Client) tick() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ticks++
+	if c.status == "crashed" {
+		return c.ticks
+	}
+
+	for i := 0; i < 50; i++ {
+		select {
+		case env := <-c.inbox:
+			c.processMessage(env)
+			continue
+		default:
+		}
+		break
+	}
+
+	switch c.phase {
+	case "thinking", "aborted":
+		if c.ticks >= c.wakeAt {
+			c.begin()
+		}
+	case "working":
+		if c.ticks >= c.wakeAt {
+			c.request()
+		}
+	case "waiting":
+		if c.simulation.deadlockPolicy() == "timeout" && c.ticks-c.requested >= lockTimeout {
+			c.abort("lock_timeout")
+		}
+	}
+	return c.ticks
+}
+
+func (c *Client) GetState() map[string]interface{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	plan := make([]string, len(c.plan))
+	for i, op := range c.plan {
+		plan[i] = op.Key + ":" + op.Mode
+	}
+	reads := make(map[string]int, len(c.reads))
+	for key, value := range c.reads {
+		reads[key] = value
+	}
+
+	return map[string]interface{}{
+		"id":        c.id,
+		"status":    c.status,
+		"role":      "client",
+		"phase":     c.phase,
+		"kind":      c.kind,
+		"plan":      plan,
+		"next":      c.next,
+		"reads":     reads,
+		"ts":        c.ts,
+		"attempt":   c.attempt,
+		"blockers":  append([]string{}, c.blockers...),
+		"commits":   c.commits,
+		"aborts":    c.aborts,
+		"anomalies": c.anomalies, // Audits that saw a wrong total
+	}
+}
+
+func (c *Client) handleMessage(env *transport.Envelope) {
+	c.mu.RLock()
+	down := c.status == "crashed"
+	c.mu.RUnlock()
+
+	if down {
+		return
+	}
+	select {
+	case c.inbox <- env:
+	default:
+	}
+}
+
+func (c *Client) processMessage(env *transport.Envelope) {
+	sim := c.simulation
+	payload := sim.received(env)
+	if payload.Attempt != c.attempt || !c.expecting(payload.Key, payload.Mode) {
+		return // Stale: the partition drops locks of ended attempts itself
+	}
+
+	switch env.Type {
+	case MsgGranted:
+		op := c.plan[c.next]
+		if op.Mode == "read" {
+			c.reads[op.Key] = payload.Value
+			c.versions[op.Key] = payload.Version
+			if sim.shortReadLocks() {
+				sim.send(c.id, env.From, MsgRelease, Payload{Txn: c.id, Attempt: c.attempt, Keys: []string{op.Key}})
+			}
+		}
+		c.next++
+		c.blockers = nil
+		switch {
+		case c.next == len(c.plan):
+			c.commit()
+		case c.kind == "transfer" && c.plan[c.next].Mode == "write" && op.Mode == "read":
+			c.phase = "working"
+			c.wakeAt = c.ticks + workTicks
+		default:
+			c.request()
+		}
+	case MsgBlocked:
+		c.phase = "waiting"
+		c.blockers = payload.Blockers
+	case MsgDie:
+		c.abort("wait_die")
+	}
+}
+
+// expecting reports whether a lock is the one the transaction is trying
+// to take (must hold c.mu)
+func (c *Client) expecting(key, mode string) bool {
+	if c.phase != "acquiring" && c.phase != "waiting" {
+		return false
+	}
+	return c.next < len(c.plan) && c.plan[c.next] == Op{Key: key, Mode: mode}
+}
+
+// begin starts an attempt. An aborted transaction retries the same plan
+// with its original age; a committed one moves on to a new one (must hold
+// c.mu)
+func (c *Client) begin() {
+	if c.phase == "thinking" {
+		c.newPlan()
+		c.ts = c.simulation.timestamp()
+	}
+	c.attempt++
+	c.next = 0
+	c.reads = make(map[string]int)
+	c.versions = make(map[string]int)
+	c.touched = make(map[string]bool)
+	c.request()
+}
+
+// newPlan picks the next transaction: an audit of every account, or a
+// transfer between two (must hold c.mu)
+func (c *Client) newPlan() {
+	keys := c.simulation.keys()
+	if c.simulation.rng.Float64() < auditShare {
+		c.kind = "audit"
+		c.plan = make([]Op, len(keys))
+		for i, key := range keys {
+			c.plan[i] = Op{Key: key, Mode: "read"}
+		}
+		return
+	}
+	picked := c.simulation.rng.Perm(len(keys))[:2]
+	from, to := keys[picked[0]], keys[picked[1]]
+	c.kind = "transfer"
+	c.amount = 1 + c.simulation.rng.Intn(10)
+	c.plan = []Op{{from, "read"}, {to, "read"}, {from, "write"}, {to, "write"}}
+}
+
+// request asks for the lock of the next op (must hold c.mu)
+func (c *Client) request() {
+	op := c.plan[c.next]
+	owner := c.simulation.owner(op.Key)
+	c.touched[owner] = true
+	c.phase = "acquiring"
+	c.requested = c.ticks
+	c.simulation.send(c.id, owner, MsgLock, Payload{Txn: c.id, Attempt: c.attempt, TS: c.ts, Key: op.Key, Mode: op.Mode})
+}
+
+// commit sends each partition its writes, which also releases the
+// transaction's locks there; an audit checks the total it read first
+// (must hold c.mu)
+func (c *Client) commit() {
+	sim := c.simulation
+	writes := make(map[string][]Write)
+	if c.kind == "transfer" {
+		from, to := c.plan[0].Key, c.plan[1].Key
+		writes[sim.owner(from)] = append(writes[sim.owner(from)], Write{Key: from, Value: c.reads[from] - c.amount, ReadVersion: c.versions[from]})
+		writes[sim.owner(to)] = append(writes[sim.owner(to)], Write{Key: to, Value: c.reads[to] + c.amount, ReadVersion: c.versions[to]})
+	}
+	for _, partition := range c.partitions() {
+		sim.send(c.id, partition, MsgCommit, Payload{Txn: c.id, Attempt: c.attempt, Writes: writes[partition]})
+	}
+
+	total := 0
+	for _, value := range c.reads {
+		total += value
+	}
+	consistent := c.kind != "audit" || total == sim.expectedTotal()
+	if !consistent {
+		c.anomalies++
+	}
+	c.commits++
+	c.phase = "thinking"
+	c.wakeAt = c.ticks + thinkTicks + c.simulation.rng.Intn(thinkTicks)
+	sim.committed(c.id, c.kind, c.attempt, total, consistent)
+}
+
+// abort gives up the attempt and releases everything it holds or waits
+// for (must hold c.mu)
+func (c *Client) abort(reason string) {
+	for _, partition := range c.partitions() {
+		c.simulation.send(c.id, partition, MsgRelease, Payload{Txn: c.id, Attempt: c.attempt})
+	}
+	c.aborts++
+	c.blockers = nil
+	c.phase = "aborted"
+	c.wakeAt = c.ticks + restartTicks + c.simulation.rng.Intn(restartTicks)
+	c.simulation.aborted(c.id, c.kind, c.attempt, reason)
+}
+
+// partitions returns the partitions the attempt asked for a lock, sorted
+// (must hold c.mu)
+func (c *Client) partitions() []string {
+	partitions := make([]string, 0, len(c.touched))
+	for partition := range c.touched {
+		partitions = append(partitions, partition)
+	}
+	sort.Strings(partitions)
+	return partitions
+}
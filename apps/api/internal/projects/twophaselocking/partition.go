@@ -0,0 +1,327 @@
+package twophaselocking
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+const (
+	MsgLock    transport.MessageType = "lock"
+	MsgGranted transport.MessageType = "granted"
+	MsgBlocked transport.MessageType = "blocked"
+	MsgDie     transport.MessageType = "die"
+	MsgRelease transport.MessageType = "release"
+	MsgCommit  transport.MessageType = "commit"
+)
+
+// Edge is an edge of the wait-for graph: From waits for To to release Key
+type Edge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Key  string `json:"key"`
+}
+
+// lockEntry is a transaction's lock on a key, held or requested
+type lockEntry struct {
+	txn     string
+	attempt int
+	ts      int    // Age of the transaction, for wait-die
+	mode    string // "read", "write"
+}
+
+// account is a key with its value and lock table entry. Requests are
+// granted in FIFO order; readers share the lock, a writer holds it
+// alone. A reader that asks to write upgrades its lock once it is the
+// only holder.
+type account struct {
+	value   int
+	version int // Commits that wrote the key
+	holders []lockEntry
+	queue   []lockEntry
+}
+
+// Partition stores some of the accounts and is the lock manager for them.
+// With wait-die, a request that conflicts is only queued when it comes
+// from a transaction older than everyone it would wait for; a younger one
+// is told to die, so waits always go from older to younger and can never
+// close a cycle.
+type Partition struct {
+	mu sync.RWMutex
+
+	id     string
+	status string // "running" or "crashed"
+	ticks  int
+
+	accounts map[string]*account
+	ended    map[string]int // Txn -> last attempt released; older requests are stale
+
+	inbox      chan *transport.Envelope
+	simulation *Simulation
+}
+
+func newPartition(id string, keys []string, balance int, sim *Simulation) *Partition {
+	p := &Partition{
+		id:         id,
+		status:     "running",
+		accounts:   make(map[string]*account),
+		ended:      make(map[string]int),
+		inbox:      make(chan *transport.Envelope, 500),
+		simulation: sim,
+	}
+	for _, key := range keys {
+		p.accounts[key] = &account{value: balance}
+	}
+	return p
+}
+
+// Partition implements engine.NodeController
+
+func (p *Partition) ID() string {
+	return p.id
+}
+
+func (p *Partition) Start(ctx context.Context) error {
+	return nil
+}
+
+func (p *Partition) Stop() error {
+	return nil
+}
+
+func (p *Partition) Tick() {
+	p.simulation.advanceSchedule(p.tick())
+}
+
+func (p *Partition) tick() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.ticks++
+	if p.status == "crashed" {
+		return p.ticks
+	}
+
+	for i := 0; i < 50; i++ {
+		select {
+		case env := <-p.inbox:
+			p.processMessage(env)
+			continue
+		default:
+		}
+		break
+	}
+	return p.ticks
+}
+
+func (p *Partition) GetState() map[string]interface{} {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	accounts := make(map[string]interface{}, len(p.accounts))
+	for key, acct := range p.accounts {
+		holders := make([]map[string]string, 0, len(acct.holders))
+		for _, h := range acct.holders {
+			holders = append(holders, map[string]string{"txn": h.txn, "mode": h.mode})
+		}
+		queue := make([]map[string]string, 0, len(acct.queue))
+		for _, q := range acct.queue {
+			queue = append(queue, map[string]string{"txn": q.txn, "mode": q.mode})
+		}
+		accounts[key] = map[string]interface{}{
+			"value":   acct.value,
+			"version": acct.version,
+			"holders": holders,
+			"queue":   queue,
+		}
+	}
+
+	return map[string]interface{}{
+		"id":       p.id,
+		"status":   p.status,
+		"role":     "lock-manager",
+		"accounts": accounts,
+		"edges":    p.edges(),
+	}
+}
+
+func (p *Partition) handleMessage(env *transport.Envelope) {
+	p.mu.RLock()
+	down := p.status == "crashed"
+	p.mu.RUnlock()
+
+	if down {
+		return
+	}
+	select {
+	case p.inbox <- env:
+	default:
+	}
+}
+
+func (p *Partition) processMessage(env *transport.Envelope) {
+	payload := p.simulation.received(env)
+
+	switch env.Type {
+	case MsgLock:
+		p.lock(payload)
+	case MsgRelease:
+		if len(payload.Keys) == 0 {
+			p.end(payload.Txn, payload.Attempt)
+			return
+		}
+		// Short read locks go as soon as the value is read
+		for _, key := range payload.Keys {
+			if acct, ok := p.accounts[key]; ok {
+				acct.holders = drop(acct.holders, payload.Txn, payload.Attempt)
+				p.grant(key)
+			}
+		}
+	case MsgCommit:
+		for _, write := range payload.Writes {
+			acct, ok := p.accounts[write.Key]
+			if !ok {
+				continue
+			}
+			if acct.version != write.ReadVersion {
+				// Someone committed the key between this transaction's
+				// read and its write, and the write wipes that out
+				p.simulation.lostUpdate(p.id, payload.Txn, write.Key, write.ReadVersion, acct.version)
+			}
+			acct.value = write.Value
+			acct.version++
+		}
+		p.end(payload.Txn, payload.Attempt)
+	}
+}
+
+// lock grants a request, queues it, or, with wait-die, tells a younger
+// requester to die (must hold p.mu)
+func (p *Partition) lock(payload Payload) {
+	acct, ok := p.accounts[payload.Key]
+	if !ok || payload.Attempt <= p.ended[payload.Txn] {
+		return // Not ours, or the attempt already ended
+	}
+	req := lockEntry{txn: payload.Txn, attempt: payload.Attempt, ts: payload.TS, mode: payload.Mode}
+
+	blockers := p.blockers(acct, req, len(acct.queue))
+	if len(blockers) == 0 && len(acct.queue) == 0 {
+		acct.queue = append(acct.queue, req)
+		p.grant(payload.Key)
+		return
+	}
+
+	sim := p.simulation
+	names := make([]string, 0, len(blockers))
+	oldest := req.ts
+	for _, b := range blockers {
+		names = append(names, b.txn)
+		oldest = min(oldest, b.ts)
+	}
+	if sim.deadlockPolicy() == "wait_die" && oldest < req.ts {
+		sim.send(p.id, req.txn, MsgDie, Payload{Txn: req.txn, Attempt: req.attempt, Key: payload.Key, Mode: req.mode, Blockers: names})
+		return
+	}
+	acct.queue = append(acct.queue, req)
+	sim.lockWaited(p.id, req.txn, payload.Key, req.mode, names)
+	sim.send(p.id, req.txn, MsgBlocked, Payload{Txn: req.txn, Attempt: req.attempt, Key: payload.Key, Mode: req.mode, Blockers: names})
+}
+
+// grant hands the lock to queued requests in FIFO order while they are
+// compatible with the holders (must hold p.mu)
+func (p *Partition) grant(key string) {
+	acct := p.accounts[key]
+	for len(acct.queue) > 0 {
+		head := acct.queue[0]
+		if len(p.blockers(acct, head, 0)) > 0 {
+			return
+		}
+		acct.queue = acct.queue[1:]
+		acct.holders = append(drop(acct.holders, head.txn, head.attempt), head)
+		p.simulation.send(p.id, head.txn, MsgGranted, Payload{
+			Txn:     head.txn,
+			Attempt: head.attempt,
+			Key:     key,
+			Mode:    head.mode,
+			Value:   acct.value,
+			Version: acct.version,
+		})
+	}
+}
+
+// blockers returns the entries a request conflicts with: the holders, and
+// the first ahead of the queue requests (must hold p.mu)
+func (p *Partition) blockers(acct *account, req lockEntry, ahead int) []lockEntry {
+	blockers := make([]lockEntry, 0)
+	for _, other := range append(append([]lockEntry{}, acct.holders...), acct.queue[:ahead]...) {
+		if other.txn != req.txn && (other.mode == "write" || req.mode == "write") {
+			blockers = append(blockers, other)
+		}
+	}
+	return blockers
+}
+
+// end drops every lock and request of an attempt, committed or aborted
+// (must hold p.mu)
+func (p *Partition) end(txn string, attempt int) {
+	p.ended[txn] = max(p.ended[txn], attempt)
+	for key, acct := range p.accounts {
+		acct.holders = drop(acct.holders, txn, attempt)
+		acct.queue = drop(acct.queue, txn, attempt)
+		p.grant(key)
+	}
+}
+
+// edges returns this partition's part of the wait-for graph (must hold
+// p.mu)
+func (p *Partition) edges() []Edge {
+	keys := make([]string, 0, len(p.accounts))
+	for key := range p.accounts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	edges := make([]Edge, 0)
+	for _, key := range keys {
+		acct := p.accounts[key]
+		for i, q := range acct.queue {
+			for _, b := range p.blockers(acct, q, i) {
+				edges = append(edges, Edge{From: q.txn, To: b.txn, Key: key})
+			}
+		}
+	}
+	return edges
+}
+
+// waitFor returns the edges, for the deadlock check
+func (p *Partition) waitFor() []Edge {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.edges()
+}
+
+// balance returns the sum of the partition's accounts
+func (p *Partition) balance() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	total := 0
+	for _, acct := range p.accounts {
+		total += acct.value
+	}
+	return total
+}
+
+// drop removes the entries of txn up to attempt
+func drop(list []lockEntry, txn string, attempt int) []lockEntry {
+	kept := make([]lockEntry, 0, len(list))
+	for _, e := range list {
+		if e.txn != txn || e.attempt > attempt {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
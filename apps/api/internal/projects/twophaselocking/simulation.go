@@ -0,0 +1,567 @@
+package twophaselocking
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+	"github.com/ersantana/distributed-systems-learning/packages/simulation/engine"
+)
+
+const (
+	partitionCount = 3
+	balance        = 100 // Every account's starting value
+)
+
+// accounts are the keys, account i stored on partition i mod partitionCount
+var accounts = []string{"A", "B", "C", "D", "E", "F"}
+
+// Write is a committed value, with the version of the key the
+// transaction read before computing it
+type Write struct {
+	Key         string `json:"key"`
+	Value       int    `json:"value"`
+	ReadVersion int    `json:"readVersion"`
+}
+
+// Payload is the content of all messages exchanged in this project
+type Payload struct {
+	Txn      string   `json:"txn"`
+	Attempt  int      `json:"attempt"`
+	TS       int      `json:"ts,omitempty"`
+	Key      string   `json:"key,omitempty"`
+	Mode     string   `json:"mode,omitempty"`
+	Value    int      `json:"value,omitempty"`
+	Version  int      `json:"version,omitempty"`
+	Keys     []string `json:"keys,omitempty"`     // Read locks to release early
+	Writes   []Write  `json:"writes,omitempty"`   // Carried by the commit
+	Blockers []string `json:"blockers,omitempty"` // Holders a request conflicts with
+}
+
+// Simulation runs bank transfers and audits over accounts spread across
+// partitions, each the lock manager of its own accounts. In "wait_die"
+// transactions hold every lock until they commit, which makes them
+// serializable, and a younger transaction that would wait for an older
+// one aborts instead, so no deadlock ever forms. In "timeout" they wait
+// for any lock: transfers that read an account and then both want to
+// write it deadlock, and sit there until a lock timeout aborts one. In
+// "short_read_locks" read locks are released right after the read, as
+// read committed does: no transaction waits long, and audits see money
+// in flight while concurrent transfers overwrite each other's updates.
+type Simulation struct {
+	mu sync.RWMutex
+
+	engine    *engine.Engine
+	rng       *rand.Rand
+	transport *transport.NetworkTransport
+	broadcast func(interface{})
+
+	partitions []*Partition
+	clients    []*Client
+	owners     map[string]string // Account -> partition
+	scenario   string
+
+	deadlock   string // "wait_die" or "timeout"
+	shortReads bool
+
+	clock int // Transaction timestamps
+
+	commits     int
+	audits      int
+	badAudits   int // Audits that saw a total other than the real one
+	lostUpdates int
+	aborts      map[string]int // Reason -> aborts
+	waits       int
+	deadlocks   int
+	lastCycle   string
+	messages    int
+	lastTick    int
+
+	running bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// Config for two-phase locking simulation
+type Config struct {
+	NodeCount int    // Clients
+	Scenario  string // "wait_die", "timeout", "short_read_locks"
+}
+
+// NewSimulation creates a new two-phase locking simulation
+func NewSimulation(eng *engine.Engine, trans *transport.NetworkTransport, broadcast func(interface{}), config Config) *Simulation {
+	switch config.Scenario {
+	case "wait_die", "timeout", "short_read_locks":
+	default:
+		config.Scenario = "wait_die"
+	}
+	if config.NodeCount == 0 {
+		config.NodeCount = 4
+	}
+	config.NodeCount = max(config.NodeCount, 2)
+
+	sim := &Simulation{
+		engine:     eng,
+		rng:        eng.Rand(),
+		transport:  trans,
+		broadcast:  broadcast,
+		owners:     make(map[string]string),
+		scenario:   config.Scenario,
+		deadlock:   "wait_die",
+		shortReads: config.Scenario == "short_read_locks",
+		aborts:     make(map[string]int),
+	}
+	if config.Scenario == "timeout" {
+		sim.deadlock = "timeout"
+	}
+
+	trans.SetLatency(20*time.Millisecond, 80*time.Millisecond)
+	trans.SetPacketLoss(0)
+
+	owned := make(map[string][]string)
+	for i, key := range accounts {
+		partition := fmt.Sprintf("partition-%d", i%partitionCount+1)
+		sim.owners[key] = partition
+		owned[partition] = append(owned[partition], key)
+	}
+	for i := 0; i < partitionCount; i++ {
+		id := fmt.Sprintf("partition-%d", i+1)
+		partition := newPartition(id, owned[id], balance, sim)
+		sim.partitions = append(sim.partitions, partition)
+		trans.RegisterHandler(id, partition.handleMessage)
+		eng.AddNode(partition)
+	}
+	for i := 0; i < config.NodeCount; i++ {
+		client := newClient(fmt.Sprintf("txn-%d", i+1), sim)
+		sim.clients = append(sim.clients, client)
+		trans.RegisterHandler(client.id, client.handleMessage)
+		eng.AddNode(client)
+	}
+
+	return sim
+}
+
+// Start starts the simulation
+func (s *Simulation) Start(ctx context.Context) error {
+	s.mu.Lock()
+	s.running = true
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.mu.Unlock()
+
+	return s.engine.Start(ctx)
+}
+
+// Stop stops the simulation
+func (s *Simulation) Stop() error {
+	s.mu.Lock()
+	s.running = false
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Unlock()
+
+	return s.engine.Stop()
+}
+
+// GetState returns the current simulation state
+func (s *Simulation) GetState() *protocol.SimulationStateResponse {
+	nodes := make(map[string]protocol.NodeState)
+	waitFor := make([]Edge, 0)
+	total := 0
+	for _, partition := range s.partitions {
+		state := partition.GetState()
+		nodes[partition.id] = protocol.NodeState{
+			ID:          partition.id,
+			Status:      state["status"].(string),
+			Role:        "lock-manager",
+			CustomState: state,
+		}
+		waitFor = append(waitFor, state["edges"].([]Edge)...)
+		total += partition.balance()
+	}
+	for _, client := range s.clients {
+		state := client.GetState()
+		nodes[client.id] = protocol.NodeState{
+			ID:          client.id,
+			Status:      state["status"].(string),
+			Role:        "client",
+			CustomState: state,
+		}
+	}
+
+	s.mu.RLock()
+	running := s.running
+	aborts := make(map[string]int, len(s.aborts))
+	for reason, n := range s.aborts {
+		aborts[reason] = n
+	}
+	metadata := map[string]interface{}{
+		"scenario":       s.scenario,
+		"deadlock":       s.deadlock,
+		"shortReadLocks": s.shortReads,
+		"owners":         s.owners,
+		"waitFor":        waitFor,
+		"deadlocked":     onCycle(waitFor),
+		"total":          total, // Sum of the balances, a constant without lost updates
+		"expectedTotal":  s.expectedTotal(),
+		"commits":        s.commits,
+		"audits":         s.audits,
+		"badAudits":      s.badAudits,
+		"lostUpdates":    s.lostUpdates,
+		"aborts":         aborts, // By reason: "wait_die", "lock_timeout"
+		"lockWaits":      s.waits,
+		"deadlocks":      s.deadlocks,
+		"messages":       s.messages,
+	}
+	s.mu.RUnlock()
+
+	mode := "step"
+	if s.engine != nil {
+		mode = s.engine.GetMode().String()
+	}
+
+	return &protocol.SimulationStateResponse{
+		Type:        protocol.MsgSimulationState,
+		VirtualTime: time.Now().UnixMilli(),
+		Mode:        mode,
+		Speed:       1.0,
+		Running:     running,
+		Nodes:       nodes,
+		Metadata:    metadata,
+	}
+}
+
+// GetNodes returns node states
+func (s *Simulation) GetNodes() map[string]protocol.NodeState {
+	state := s.GetState()
+	return state.Nodes
+}
+
+// Layout draws the lock managers and the clients as two columns, the
+// wait-for edges running between them
+func (s *Simulation) Layout() *protocol.Layout {
+	partitions := make([]string, len(s.partitions))
+	for i, partition := range s.partitions {
+		partitions[i] = partition.id
+	}
+	clients := make([]string, len(s.clients))
+	for i, client := range s.clients {
+		clients[i] = client.id
+	}
+	return &protocol.Layout{
+		Kind: protocol.LayoutGroups,
+		Groups: []protocol.LayoutGroup{
+			{Name: "lock managers", Nodes: partitions},
+			{Name: "clients", Nodes: clients},
+		},
+	}
+}
+
+// CrashNode crashes a partition, which keeps its lock table and accounts,
+// or a client, which keeps its locks and blocks everyone waiting for them
+func (s *Simulation) CrashNode(nodeID string) error {
+	if partition := s.findPartition(nodeID); partition != nil {
+		partition.mu.Lock()
+		partition.status = "crashed"
+		partition.mu.Unlock()
+		return nil
+	}
+	if client := s.findClient(nodeID); client != nil {
+		client.mu.Lock()
+		client.status = "crashed"
+		client.mu.Unlock()
+		return nil
+	}
+	return fmt.Errorf("unknown node: %s", nodeID)
+}
+
+// RecoverNode recovers a crashed node
+func (s *Simulation) RecoverNode(nodeID string) error {
+	if partition := s.findPartition(nodeID); partition != nil {
+		partition.mu.Lock()
+		partition.status = "running"
+		partition.mu.Unlock()
+		return nil
+	}
+	if client := s.findClient(nodeID); client != nil {
+		client.mu.Lock()
+		client.status = "running"
+		client.mu.Unlock()
+		return nil
+	}
+	return fmt.Errorf("unknown node: %s", nodeID)
+}
+
+// HandleClientRequest runs a client command: "configure" sets how
+// deadlocks are handled, {deadlock: "wait_die" or "timeout"}, and whether
+// read locks are released early, {shortReadLocks}
+func (s *Simulation) HandleClientRequest(command string, payload map[string]interface{}) error {
+	if command != "configure" {
+		return fmt.Errorf("unknown command: %s", command)
+	}
+	deadlock, hasDeadlock := payload["deadlock"].(string)
+	if hasDeadlock && deadlock != "wait_die" && deadlock != "timeout" {
+		return fmt.Errorf("unknown deadlock handling: %q", deadlock)
+	}
+	shortReads, hasShortReads := payload["shortReadLocks"].(bool)
+
+	s.mu.Lock()
+	if hasDeadlock {
+		s.deadlock = deadlock
+	}
+	if hasShortReads {
+		s.shortReads = shortReads
+	}
+	deadlock, shortReads = s.deadlock, s.shortReads
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":           "locking_configured",
+		"deadlock":       deadlock,
+		"shortReadLocks": shortReads,
+	})
+	return nil
+}
+
+// findPartition looks up a partition by ID
+func (s *Simulation) findPartition(nodeID string) *Partition {
+	for _, partition := range s.partitions {
+		if partition.id == nodeID {
+			return partition
+		}
+	}
+	return nil
+}
+
+// findClient looks up a client by ID
+func (s *Simulation) findClient(nodeID string) *Client {
+	for _, client := range s.clients {
+		if client.id == nodeID {
+			return client
+		}
+	}
+	return nil
+}
+
+// keys returns every account
+func (s *Simulation) keys() []string {
+	return accounts
+}
+
+// owner returns the partition storing an account
+func (s *Simulation) owner(key string) string {
+	return s.owners[key]
+}
+
+// expectedTotal returns what every audit should see: transfers only move
+// money around
+func (s *Simulation) expectedTotal() int {
+	return balance * len(accounts)
+}
+
+// deadlockPolicy returns how the transactions handle deadlocks
+func (s *Simulation) deadlockPolicy() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.deadlock
+}
+
+// shortReadLocks reports whether read locks are released after the read
+func (s *Simulation) shortReadLocks() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.shortReads
+}
+
+// timestamp returns the age of a new transaction, for wait-die
+func (s *Simulation) timestamp() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.clock++
+	return s.clock
+}
+
+// advanceSchedule looks for a cycle in the wait-for graph, once per tick
+func (s *Simulation) advanceSchedule(ticks int) {
+	s.mu.Lock()
+	if ticks <= s.lastTick {
+		s.mu.Unlock()
+		return
+	}
+	s.lastTick = ticks
+	s.mu.Unlock()
+
+	waitFor := make([]Edge, 0)
+	for _, partition := range s.partitions {
+		waitFor = append(waitFor, partition.waitFor()...)
+	}
+	cycle := onCycle(waitFor)
+	signature := strings.Join(cycle, ",")
+
+	s.mu.Lock()
+	formed := signature != "" && signature != s.lastCycle
+	s.lastCycle = signature
+	if formed {
+		s.deadlocks++
+	}
+	s.mu.Unlock()
+
+	if formed {
+		s.broadcast(map[string]interface{}{
+			"type":         "deadlock",
+			"transactions": cycle,
+			"edges":        waitFor,
+		})
+	}
+}
+
+// lockWaited reports a request queued behind conflicting locks
+func (s *Simulation) lockWaited(nodeID, txn, key, mode string, blockers []string) {
+	s.mu.Lock()
+	s.waits++
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":     "lock_wait",
+		"nodeId":   nodeID,
+		"txn":      txn,
+		"key":      key,
+		"mode":     mode,
+		"blockers": blockers,
+	})
+}
+
+// lostUpdate reports a write computed from a read that another commit
+// had already overwritten
+func (s *Simulation) lostUpdate(nodeID, txn, key string, read, current int) {
+	s.mu.Lock()
+	s.lostUpdates++
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":        "anomaly",
+		"nodeId":      nodeID,
+		"kind":        "lost_update",
+		"txn":         txn,
+		"key":         key,
+		"readVersion": read,
+		"version":     current,
+	})
+}
+
+// committed records a committed transaction, and an audit that saw the
+// wrong total
+func (s *Simulation) committed(txn, kind string, attempt, total int, consistent bool) {
+	s.mu.Lock()
+	s.commits++
+	if kind == "audit" {
+		s.audits++
+		if !consistent {
+			s.badAudits++
+		}
+	}
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":    "transaction_committed",
+		"nodeId":  txn,
+		"kind":    kind,
+		"attempt": attempt,
+	})
+	if !consistent {
+		s.broadcast(map[string]interface{}{
+			"type":     "anomaly",
+			"nodeId":   txn,
+			"kind":     "inconsistent_read",
+			"total":    total,
+			"expected": s.expectedTotal(),
+		})
+	}
+}
+
+// aborted records an aborted attempt
+func (s *Simulation) aborted(txn, kind string, attempt int, reason string) {
+	s.mu.Lock()
+	s.aborts[reason]++
+	s.mu.Unlock()
+
+	s.broadcast(map[string]interface{}{
+		"type":    "transaction_aborted",
+		"nodeId":  txn,
+		"kind":    kind,
+		"attempt": attempt,
+		"reason":  reason, // "wait_die" or "lock_timeout"
+	})
+}
+
+// send transmits a message and reports it to the frontend
+func (s *Simulation) send(from, to string, msgType transport.MessageType, payload Payload) {
+	env := transport.NewEnvelope(from, to, msgType, payload)
+
+	s.mu.Lock()
+	s.messages++
+	s.mu.Unlock()
+
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageSent,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+
+	s.transport.Send(s.ctx, env)
+}
+
+// received reports a delivered message and returns its payload
+func (s *Simulation) received(env *transport.Envelope) Payload {
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageReceived,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+	payload, _ := env.Payload.(Payload)
+	return payload
+}
+
+// onCycle returns the transactions on a cycle of the wait-for graph
+func onCycle(edges []Edge) []string {
+	out := make(map[string][]string)
+	for _, e := range edges {
+		out[e.From] = append(out[e.From], e.To)
+	}
+
+	// A transaction is deadlocked if it can reach itself
+	cycle := make([]string, 0)
+	for start := range out {
+		visited := make(map[string]bool)
+		stack := append([]string{}, out[start]...)
+		for len(stack) > 0 {
+			next := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if next == start {
+				cycle = append(cycle, start)
+				break
+			}
+			if !visited[next] {
+				visited[next] = true
+				stack = append(stack, out[next]...)
+			}
+		}
+	}
+	sort.Strings(cycle)
+	return cycle
+}
@@ -0,0 +1,62 @@
+package twophaselocking
+
+import (
+	"fmt"
+
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+)
+
+// Invariants checks the isolation the locking gave: audits see the real
+// total and no update is lost; and that no deadlock formed on the way
+func (s *Simulation) Invariants() []protocol.InvariantResult {
+	total := 0
+	for _, partition := range s.partitions {
+		total += partition.balance()
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	expected := s.expectedTotal()
+	aborts := 0
+	for _, n := range s.aborts {
+		aborts += n
+	}
+	return []protocol.InvariantResult{
+		{
+			Name:   "audits see a consistent total",
+			Holds:  s.badAudits == 0,
+			Detail: fmt.Sprintf("%d of %d audits saw a total other than %d", s.badAudits, s.audits, expected),
+		},
+		{
+			Name:   "no update is lost",
+			Holds:  s.lostUpdates == 0 && total == expected,
+			Detail: fmt.Sprintf("%d writes overwrote a commit they never read, balances sum to %d (expected %d)", s.lostUpdates, total, expected),
+		},
+		{
+			Name:   "no deadlock forms",
+			Holds:  s.deadlocks == 0,
+			Detail: fmt.Sprintf("%d deadlocks with %s, %d lock waits, %d aborts (%d wait-die, %d lock timeouts) for %d commits", s.deadlocks, s.deadlock, s.waits, aborts, s.aborts["wait_die"], s.aborts["lock_timeout"], s.commits),
+		},
+	}
+}
+
+// FollowUps suggests the scenarios that contrast with this one
+func (s *Simulation) FollowUps() []protocol.FollowUp {
+	switch s.scenario {
+	case "timeout":
+		return []protocol.FollowUp{
+			{Project: "two-phase-locking", Scenario: "wait_die", Reason: "Abort the younger transaction up front so no deadlock forms"},
+			{Project: "locks", Scenario: "deadlock", Reason: "Find the deadlock cycle with edge-chasing probes instead of waiting it out"},
+		}
+	case "short_read_locks":
+		return []protocol.FollowUp{
+			{Project: "two-phase-locking", Scenario: "wait_die", Reason: "Hold the read locks until the commit and the anomalies go away"},
+			{Project: "percolator", Reason: "Get snapshot isolation from timestamps instead of read locks"},
+		}
+	}
+	return []protocol.FollowUp{
+		{Project: "two-phase-locking", Scenario: "short_read_locks", Reason: "Release read locks early and watch audits and updates go wrong"},
+		{Project: "two-phase-locking", Scenario: "timeout", Reason: "Let transactions wait for anyone and deadlock"},
+	}
+}
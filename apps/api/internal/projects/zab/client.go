@@ -0,0 +1,345 @@
+package zab
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+const (
+	MsgRequest        transport.MessageType = "request"
+	MsgResponse       transport.MessageType = "response"
+	MsgWatchEvent     transport.MessageType = "watch_event"
+	MsgPing           transport.MessageType = "ping"
+	MsgPong           transport.MessageType = "pong"
+	MsgSessionExpired transport.MessageType = "session_expired"
+)
+
+const (
+	pingInterval     = 4
+	reconnectTimeout = 12 // Ticks without a pong before switching servers
+	lockPrefix       = "/lock/lock-"
+)
+
+// Client is a ZooKeeper client running the lock recipe: create an
+// ephemeral sequential node under /lock and hold the lock while it is
+// the lowest child. Its session is kept alive by pings; when the
+// session expires the ephemeral node, and with it the lock, goes away.
+type Client struct {
+	mu sync.RWMutex
+
+	id     string
+	status string
+	ticks  int
+
+	server   string // Server the session is connected to
+	servers  []string
+	lastPong int
+
+	state    string // "idle", "connecting", "connected", "waiting", "holding", "releasing", "expired"
+	cxid     int
+	pending  map[int]Request
+	nextResp int              // Responses are handled in cxid order
+	early    map[int]Response // Responses received ahead of an earlier one
+
+	lockNode   string
+	heldSince  int
+	releasedAt int
+	expiredAt  int
+	acquired   int
+
+	holdTicks int // 0 = hold until the session ends
+	startAt   int
+
+	inbox      chan *transport.Envelope
+	simulation *Simulation
+}
+
+func newClient(id string, servers []string, index int, sim *Simulation) *Client {
+	return &Client{
+		id:         id,
+		status:     "running",
+		server:     servers[index%len(servers)],
+		servers:    servers,
+		state:      "idle",
+		pending:    make(map[int]Request),
+		nextResp:   1,
+		early:      make(map[int]Response),
+		inbox:      make(chan *transport.Envelope, 100),
+		simulation: sim,
+	}
+}
+
+// Client implements engine.NodeController
+
+func (c *Client) ID() string {
+	return c.id
+}
+
+func (c *Client) Start(ctx context.Context) error {
+	return nil
+}
+
+func (c *Client) Stop() error {
+	return nil
+}
+
+func (c *Client) Tick() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ticks++
+	if c.status != "running" || c.ticks < c.startAt {
+		return
+	}
+
+	for i := 0; i < 20; i++ {
+		select {
+		case env := <-c.inbox:
+			c.processMessage(env)
+			continue
+		default:
+		}
+		break
+	}
+
+	sim := c.simulation
+	switch c.state {
+	case "idle":
+		c.lastPong = c.ticks
+		c.state = "connecting"
+		c.request(Request{Op: "create_session"})
+	case "expired":
+		// The lock is gone with the session; start over
+		if c.ticks-c.expiredAt >= 10 {
+			c.state = "idle"
+		}
+		return
+	case "holding":
+		if c.holdTicks > 0 && c.ticks-c.heldSince >= c.holdTicks {
+			c.state = "releasing"
+			c.request(Request{Op: "delete", Path: c.lockNode})
+		}
+	case "connected":
+		if c.ticks-c.releasedAt >= 10 {
+			c.acquire()
+		}
+	}
+
+	if c.ticks%pingInterval == 0 {
+		sim.send(c.id, c.server, MsgPing, Payload{Session: c.id})
+	}
+
+	if c.ticks-c.lastPong > reconnectTimeout {
+		c.reconnect()
+	}
+}
+
+func (c *Client) GetState() map[string]interface{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return map[string]interface{}{
+		"id":       c.id,
+		"status":   c.status,
+		"state":    c.state,
+		"server":   c.server,
+		"lockNode": c.lockNode,
+		"pending":  len(c.pending),
+		"acquired": c.acquired,
+	}
+}
+
+func (c *Client) handleMessage(env *transport.Envelope) {
+	c.mu.RLock()
+	crashed := c.status != "running"
+	c.mu.RUnlock()
+
+	// Crashed clients do not receive anything
+	if crashed {
+		return
+	}
+	select {
+	case c.inbox <- env:
+	default:
+	}
+}
+
+// request sends the next request of the session (must hold c.mu)
+func (c *Client) request(req Request) {
+	c.cxid++
+	req.Session = c.id
+	req.Cxid = c.cxid
+	c.pending[req.Cxid] = req
+	req.Base = c.base()
+	c.simulation.send(c.id, c.server, MsgRequest, Payload{Request: &req})
+}
+
+// base is the lowest cxid still awaiting a response
+func (c *Client) base() int {
+	base := c.cxid
+	for cxid := range c.pending {
+		if cxid < base {
+			base = cxid
+		}
+	}
+	return base
+}
+
+// acquire starts the lock recipe. The create and the read are sent back
+// to back: session FIFO order guarantees the read sees the create.
+func (c *Client) acquire() {
+	c.state = "waiting"
+	c.request(Request{Op: "create", Path: lockPrefix, Ephemeral: true, Sequential: true})
+	c.request(Request{Op: "get_children", Path: "/lock", Watch: true})
+}
+
+// reconnect moves the session to the next server and resends whatever
+// is still outstanding (must hold c.mu)
+func (c *Client) reconnect() {
+	sim := c.simulation
+
+	from := c.server
+	for i, s := range c.servers {
+		if s == c.server {
+			c.server = c.servers[(i+1)%len(c.servers)]
+			break
+		}
+	}
+	c.lastPong = c.ticks
+
+	sim.broadcast(map[string]interface{}{
+		"type":   "client_reconnect",
+		"nodeId": c.id,
+		"from":   from,
+		"to":     c.server,
+	})
+
+	cxids := make([]int, 0, len(c.pending))
+	for cxid := range c.pending {
+		cxids = append(cxids, cxid)
+	}
+	sort.Ints(cxids)
+	base := c.base()
+	for _, cxid := range cxids {
+		req := c.pending[cxid]
+		req.Base = base
+		sim.send(c.id, c.server, MsgRequest, Payload{Request: &req})
+	}
+
+	// Watches live on the server they were set on; re-register ours
+	// like ZooKeeper's SetWatches does on reconnect
+	if c.state == "waiting" && c.lockNode != "" {
+		c.request(Request{Op: "get_children", Path: "/lock", Watch: true})
+	}
+}
+
+func (c *Client) processMessage(env *transport.Envelope) {
+	sim := c.simulation
+	payload := sim.received(env)
+
+	switch env.Type {
+	case MsgPong:
+		if env.From == c.server {
+			c.lastPong = c.ticks
+		}
+
+	case MsgResponse:
+		if payload.Response == nil || payload.Response.Cxid < c.nextResp {
+			return
+		}
+		c.early[payload.Response.Cxid] = *payload.Response
+		for {
+			resp, ok := c.early[c.nextResp]
+			if !ok {
+				break
+			}
+			delete(c.early, c.nextResp)
+			delete(c.pending, c.nextResp)
+			c.nextResp++
+			c.handleResponse(resp)
+		}
+
+	case MsgWatchEvent:
+		// Any change under /lock may have made us the lowest node. The
+		// full recipe watches only the predecessor to avoid a herd.
+		if c.state == "waiting" && c.lockNode != "" {
+			c.request(Request{Op: "get_children", Path: "/lock", Watch: true})
+		}
+
+	case MsgSessionExpired:
+		if c.state == "expired" {
+			return
+		}
+		sim.broadcast(map[string]interface{}{
+			"type":     "session_expired",
+			"nodeId":   c.id,
+			"lockNode": c.lockNode,
+			"held":     c.state == "holding",
+		})
+		c.state = "expired"
+		c.expiredAt = c.ticks
+		c.lockNode = ""
+		c.pending = make(map[int]Request)
+		c.early = make(map[int]Response)
+		c.nextResp = c.cxid + 1
+	}
+}
+
+func (c *Client) handleResponse(resp Response) {
+	sim := c.simulation
+
+	switch resp.Op {
+	case "create_session":
+		if c.state == "connecting" {
+			c.state = "connected"
+			c.releasedAt = c.ticks - 10
+		}
+
+	case "create":
+		if resp.Error != "" {
+			c.state = "connected"
+			c.releasedAt = c.ticks
+			return
+		}
+		c.lockNode = resp.Path
+
+	case "get_children":
+		if c.state != "waiting" || c.lockNode == "" {
+			return
+		}
+		name := c.lockNode[strings.LastIndex(c.lockNode, "/")+1:]
+		if len(resp.Children) > 0 && resp.Children[0] == name {
+			c.state = "holding"
+			c.heldSince = c.ticks
+			c.acquired++
+			sim.broadcast(map[string]interface{}{
+				"type":     "lock_acquired",
+				"nodeId":   c.id,
+				"lockNode": c.lockNode,
+			})
+			return
+		}
+		sim.broadcast(map[string]interface{}{
+			"type":     "lock_waiting",
+			"nodeId":   c.id,
+			"lockNode": c.lockNode,
+			"queue":    resp.Children,
+		})
+
+	case "delete":
+		if c.state == "releasing" {
+			sim.broadcast(map[string]interface{}{
+				"type":     "lock_released",
+				"nodeId":   c.id,
+				"lockNode": c.lockNode,
+			})
+			c.state = "connected"
+			c.lockNode = ""
+			c.releasedAt = c.ticks
+		}
+	}
+}
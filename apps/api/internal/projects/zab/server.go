@@ -0,0 +1,992 @@
+package zab
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+const (
+	// Discovery and synchronization
+	MsgNewEpoch     transport.MessageType = "new_epoch"
+	MsgAckEpoch     transport.MessageType = "ack_epoch"
+	MsgRejectEpoch  transport.MessageType = "reject_epoch"
+	MsgNewLeader    transport.MessageType = "new_leader"
+	MsgAckLeader    transport.MessageType = "ack_leader"
+	MsgCommitLeader transport.MessageType = "commit_leader"
+
+	// Broadcast
+	MsgPropose      transport.MessageType = "propose"
+	MsgAck          transport.MessageType = "ack"
+	MsgCommit       transport.MessageType = "commit"
+	MsgHeartbeat    transport.MessageType = "heartbeat"
+	MsgHeartbeatAck transport.MessageType = "heartbeat_ack"
+	MsgForward      transport.MessageType = "forward"
+	MsgTouch        transport.MessageType = "touch"
+)
+
+const (
+	heartbeatInterval = 3
+	gapTimeout        = 10 // Ticks a follower waits for a missing proposal
+)
+
+// Server is a ZooKeeper server. It is "looking" until it follows or
+// leads an epoch; a leader becomes active once a quorum has synced to
+// its history.
+type Server struct {
+	mu sync.RWMutex
+
+	id     string
+	status string
+	ticks  int
+
+	phase  string // "looking", "following", "leading"
+	leader string
+
+	// Persistent state
+	acceptedEpoch int // Last epoch promised to a prospective leader
+	currentEpoch  int // Last epoch whose history was accepted
+	history       []Txn
+	committed     int // Prefix of history applied to the tree
+	discarded     int // Proposals dropped for never being committed
+
+	tree    *DataTree
+	results map[string]Response // Outcome of applied txns by session/cxid
+
+	lastHeard       int
+	electionTimeout int
+
+	// Leader
+	activated       bool
+	electionStarted int
+	epochAcks       map[string]Payload
+	leaderAcks      map[string]bool
+	synced          map[string]bool
+	followerSeen    map[string]int
+	counter         int
+	proposalAcks    map[Zxid]map[string]bool
+	proposed        map[string]bool
+	sessionSeen     map[string]int
+	expiring        map[string]bool
+
+	// Follower
+	inSync       bool
+	syncAsked    int
+	pending      map[Zxid]Txn // Proposals received ahead of a gap
+	gapSince     int
+	commitTarget Zxid
+
+	// Client sessions connected to this server
+	clients     map[string]bool
+	nextCxid    map[string]int
+	held        map[string]map[int]Request // Requests ahead of a gap
+	queues      map[string][]Request
+	inflight    map[string]bool   // Session has a write awaiting commit
+	forwardedTo map[string]string // Leader the inflight write went to
+	watches     map[string]map[string]bool
+
+	inbox      chan *transport.Envelope
+	simulation *Simulation
+	serverIDs  []string
+}
+
+func newServer(id string, serverIDs []string, sim *Simulation) *Server {
+	n := &Server{
+		id:         id,
+		status:     "running",
+		tree:       NewDataTree(),
+		results:    make(map[string]Response),
+		inbox:      make(chan *transport.Envelope, 500),
+		simulation: sim,
+		serverIDs:  serverIDs,
+	}
+	n.reset()
+	return n
+}
+
+// reset drops all volatile state; epochs, history and the tree survive
+func (n *Server) reset() {
+	n.resetRole()
+	n.clients = make(map[string]bool)
+	n.nextCxid = make(map[string]int)
+	n.held = make(map[string]map[int]Request)
+	n.queues = make(map[string][]Request)
+	n.inflight = make(map[string]bool)
+	n.forwardedTo = make(map[string]string)
+	n.watches = make(map[string]map[string]bool)
+}
+
+// resetRole goes back to looking, forgetting leader and follower state
+func (n *Server) resetRole() {
+	n.phase = "looking"
+	n.leader = ""
+	n.lastHeard = n.ticks
+	n.electionTimeout = 10 + n.simulation.rng.Intn(10)
+	n.activated = false
+	n.epochAcks = nil
+	n.leaderAcks = nil
+	n.synced = make(map[string]bool)
+	n.followerSeen = make(map[string]int)
+	n.proposalAcks = make(map[Zxid]map[string]bool)
+	n.proposed = make(map[string]bool)
+	n.sessionSeen = make(map[string]int)
+	n.expiring = make(map[string]bool)
+	n.inSync = false
+	n.pending = make(map[Zxid]Txn)
+	n.gapSince = 0
+}
+
+func (n *Server) crash() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.status = "crashed"
+}
+
+func (n *Server) recover() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.status = "running"
+	n.reset()
+	for len(n.inbox) > 0 {
+		<-n.inbox
+	}
+}
+
+// Server implements engine.NodeController
+
+func (n *Server) ID() string {
+	return n.id
+}
+
+func (n *Server) Start(ctx context.Context) error {
+	return nil
+}
+
+func (n *Server) Stop() error {
+	return nil
+}
+
+func (n *Server) Tick() {
+	ticks := n.tick()
+	n.simulation.advanceSchedule(ticks)
+}
+
+func (n *Server) tick() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.ticks++
+	if n.status != "running" {
+		return n.ticks
+	}
+
+	for i := 0; i < 50; i++ {
+		select {
+		case env := <-n.inbox:
+			n.processMessage(env)
+			continue
+		default:
+		}
+		break
+	}
+
+	switch {
+	case n.phase == "leading" && n.activated:
+		n.leaderTick()
+	case n.phase == "leading":
+		// Discovery or synchronization did not reach a quorum
+		if n.ticks-n.electionStarted > 2*n.electionTimeout {
+			n.simulation.broadcast(map[string]interface{}{
+				"type":   "election_failed",
+				"nodeId": n.id,
+				"epoch":  n.acceptedEpoch,
+			})
+			n.phase = "looking"
+			n.lastHeard = n.ticks
+		}
+	case n.ticks-n.lastHeard > n.electionTimeout:
+		n.startElection()
+	}
+
+	if n.phase == "following" && len(n.pending) > 0 && n.ticks-n.gapSince > gapTimeout {
+		// A proposal never arrived; resynchronize from the leader
+		n.simulation.broadcast(map[string]interface{}{
+			"type":   "follower_resync",
+			"nodeId": n.id,
+			"leader": n.leader,
+		})
+		n.pending = make(map[Zxid]Txn)
+		n.inSync = false
+		n.requestSync()
+	}
+
+	// Writes waiting on a leader that went away are sent to the new one
+	for _, session := range sortedKeys(n.inflight) {
+		if leader := n.currentLeader(); leader != "" && n.forwardedTo[session] != leader {
+			n.forward(session)
+		}
+	}
+
+	return n.ticks
+}
+
+// leaderTick sends heartbeats, expires sessions and steps down when the
+// leader no longer hears from a quorum (must hold n.mu)
+func (n *Server) leaderTick() {
+	sim := n.simulation
+
+	if n.ticks%heartbeatInterval == 0 {
+		for _, peer := range n.serverIDs {
+			if peer != n.id {
+				sim.send(n.id, peer, MsgHeartbeat, Payload{Epoch: n.currentEpoch})
+			}
+		}
+	}
+
+	alive := 1
+	for _, seen := range n.followerSeen {
+		if n.ticks-seen <= 2*n.electionTimeout {
+			alive++
+		}
+	}
+	if alive < sim.quorum() && n.ticks-n.electionStarted > 2*n.electionTimeout {
+		sim.broadcast(map[string]interface{}{
+			"type":   "leader_stepped_down",
+			"nodeId": n.id,
+			"epoch":  n.currentEpoch,
+		})
+		n.resetRole()
+		return
+	}
+
+	sessions := make([]string, 0, len(n.sessionSeen))
+	for session := range n.sessionSeen {
+		sessions = append(sessions, session)
+	}
+	sort.Strings(sessions)
+	for _, session := range sessions {
+		if n.ticks-n.sessionSeen[session] > sim.sessionTimeout && !n.expiring[session] {
+			n.expiring[session] = true
+			sim.broadcast(map[string]interface{}{
+				"type":    "session_expiring",
+				"nodeId":  n.id,
+				"session": session,
+			})
+			n.propose(Request{Session: session, Op: "close_session"})
+		}
+	}
+}
+
+func (n *Server) GetState() map[string]interface{} {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	role := n.phase
+	switch {
+	case n.phase == "leading" && n.activated:
+		role = "leader"
+	case n.phase == "leading":
+		role = "candidate"
+	case n.phase == "following":
+		role = "follower"
+	}
+
+	lastZxid, committedZxid := Zxid{}, Zxid{}
+	if len(n.history) > 0 {
+		lastZxid = n.history[len(n.history)-1].Zxid
+	}
+	if n.committed > 0 {
+		committedZxid = n.history[n.committed-1].Zxid
+	}
+
+	return map[string]interface{}{
+		"id":            n.id,
+		"status":        n.status,
+		"role":          role,
+		"zabPhase":      n.zabPhase(),
+		"leader":        n.leader,
+		"epoch":         n.currentEpoch,
+		"acceptedEpoch": n.acceptedEpoch,
+		"lastZxid":      lastZxid.String(),
+		"committedZxid": committedZxid.String(),
+		"history":       len(n.history),
+		"committed":     n.committed,
+		"discarded":     n.discarded,
+		"tree":          n.tree.Nodes(),
+		"sessions":      n.tree.Sessions(),
+		"clients":       len(n.clients),
+	}
+}
+
+// zabPhase names the Zab phase the server is in: "election" while
+// looking, "discovery" until the leader of the epoch has a quorum's
+// histories, "synchronization" until a quorum accepted the leader's
+// history, then "broadcast" (must hold n.mu)
+func (n *Server) zabPhase() string {
+	switch {
+	case n.phase == "leading" && n.activated:
+		return "broadcast"
+	case n.phase == "leading" && n.leaderAcks != nil:
+		return "synchronization"
+	case n.phase == "leading":
+		return "discovery"
+	case n.phase == "following" && n.inSync:
+		return "broadcast"
+	case n.phase == "following" && n.currentEpoch == n.acceptedEpoch:
+		return "synchronization"
+	case n.phase == "following":
+		return "discovery"
+	}
+	return "election"
+}
+
+func (n *Server) handleMessage(env *transport.Envelope) {
+	n.mu.RLock()
+	crashed := n.status != "running"
+	n.mu.RUnlock()
+
+	// Crashed servers do not receive anything
+	if crashed {
+		return
+	}
+	select {
+	case n.inbox <- env:
+	default:
+	}
+}
+
+// currentLeader returns the active leader this server can forward to
+func (n *Server) currentLeader() string {
+	switch {
+	case n.phase == "leading" && n.activated:
+		return n.id
+	case n.phase == "following" && n.inSync:
+		return n.leader
+	}
+	return ""
+}
+
+// startElection proposes the next epoch with this server as leader
+// (must hold n.mu)
+func (n *Server) startElection() {
+	sim := n.simulation
+
+	n.resetRole()
+	n.acceptedEpoch++
+	n.phase = "leading"
+	n.leader = n.id
+	n.electionStarted = n.ticks
+	n.epochAcks = map[string]Payload{
+		n.id: {CurrentEpoch: n.currentEpoch, History: n.history},
+	}
+
+	sim.broadcast(map[string]interface{}{
+		"type":   "election_started",
+		"nodeId": n.id,
+		"epoch":  n.acceptedEpoch,
+	})
+	for _, peer := range n.serverIDs {
+		if peer != n.id {
+			sim.send(n.id, peer, MsgNewEpoch, Payload{Epoch: n.acceptedEpoch})
+		}
+	}
+	n.checkEpochQuorum()
+}
+
+// follow makes this server a follower of leader (must hold n.mu)
+func (n *Server) follow(leader string, epoch int) {
+	if n.phase == "leading" {
+		n.resetRole()
+	}
+	n.acceptedEpoch = epoch
+	n.phase = "following"
+	n.leader = leader
+	n.inSync = false
+	n.pending = make(map[Zxid]Txn)
+	n.gapSince = 0
+	n.lastHeard = n.ticks
+}
+
+// requestSync asks the leader for its history (must hold n.mu)
+func (n *Server) requestSync() {
+	n.syncAsked = n.ticks
+	n.simulation.send(n.id, n.leader, MsgAckEpoch, Payload{
+		Epoch:        n.acceptedEpoch,
+		CurrentEpoch: n.currentEpoch,
+		History:      n.history,
+	})
+}
+
+func (n *Server) processMessage(env *transport.Envelope) {
+	sim := n.simulation
+	payload := sim.received(env)
+
+	if n.phase == "leading" {
+		n.followerSeen[env.From] = n.ticks
+	}
+
+	switch env.Type {
+	case MsgNewEpoch:
+		if payload.Epoch > n.acceptedEpoch {
+			n.follow(env.From, payload.Epoch)
+			n.requestSync()
+		} else {
+			sim.send(n.id, env.From, MsgRejectEpoch, Payload{Epoch: n.acceptedEpoch})
+		}
+
+	case MsgRejectEpoch:
+		if n.phase == "leading" && !n.activated && payload.Epoch >= n.acceptedEpoch {
+			n.resetRole()
+			n.acceptedEpoch = payload.Epoch
+		}
+
+	case MsgAckEpoch:
+		n.handleAckEpoch(env.From, payload)
+
+	case MsgNewLeader:
+		if env.From == n.leader && payload.Epoch == n.acceptedEpoch {
+			n.currentEpoch = payload.Epoch
+			n.adoptHistory(env.From, payload.History)
+			n.lastHeard = n.ticks
+			sim.send(n.id, env.From, MsgAckLeader, Payload{Epoch: payload.Epoch})
+		}
+
+	case MsgAckLeader:
+		n.handleAckLeader(env.From, payload)
+
+	case MsgCommitLeader:
+		if env.From == n.leader && payload.Epoch == n.acceptedEpoch {
+			n.currentEpoch = payload.Epoch
+			n.adoptHistory(env.From, payload.History)
+			n.inSync = true
+			n.lastHeard = n.ticks
+			n.commitTo(payload.Committed)
+			// Proposals made while we were syncing arrive in the history;
+			// the leader still needs our acks to commit them
+			for _, txn := range n.history[n.committed:] {
+				sim.send(n.id, env.From, MsgAck, Payload{Zxid: txn.Zxid})
+			}
+			n.drainProposals()
+			sim.broadcast(map[string]interface{}{
+				"type":      "follower_synced",
+				"nodeId":    n.id,
+				"leader":    env.From,
+				"epoch":     payload.Epoch,
+				"committed": n.committed,
+			})
+		}
+
+	case MsgPropose:
+		if env.From == n.leader && payload.Txn != nil && payload.Txn.Zxid.Epoch == n.acceptedEpoch {
+			n.pending[payload.Txn.Zxid] = *payload.Txn
+			n.drainProposals()
+		}
+
+	case MsgAck:
+		if acks, ok := n.proposalAcks[payload.Zxid]; ok && n.activated {
+			acks[env.From] = true
+			n.advanceCommit()
+		}
+
+	case MsgCommit:
+		if env.From == n.leader {
+			if n.commitTarget.Less(payload.Zxid) {
+				n.commitTarget = payload.Zxid
+			}
+			n.applyCommits()
+		}
+
+	case MsgHeartbeat:
+		n.handleHeartbeat(env.From, payload)
+
+	case MsgHeartbeatAck:
+		// followerSeen was updated above
+
+	case MsgForward:
+		if n.activated && payload.Request != nil {
+			n.propose(*payload.Request)
+		}
+
+	case MsgTouch:
+		if n.activated {
+			if _, ok := n.sessionSeen[payload.Session]; ok {
+				n.sessionSeen[payload.Session] = n.ticks
+			}
+		}
+
+	case MsgRequest:
+		if payload.Request != nil {
+			n.handleRequest(env.From, *payload.Request)
+		}
+
+	case MsgPing:
+		n.clients[payload.Session] = true
+		sim.send(n.id, env.From, MsgPong, Payload{})
+		switch leader := n.currentLeader(); leader {
+		case "":
+		case n.id:
+			if _, ok := n.sessionSeen[payload.Session]; ok {
+				n.sessionSeen[payload.Session] = n.ticks
+			}
+		default:
+			sim.send(n.id, leader, MsgTouch, Payload{Session: payload.Session})
+		}
+	}
+}
+
+func (n *Server) handleHeartbeat(from string, payload Payload) {
+	sim := n.simulation
+
+	if n.phase == "following" && from == n.leader && payload.Epoch == n.acceptedEpoch {
+		n.lastHeard = n.ticks
+		sim.send(n.id, from, MsgHeartbeatAck, Payload{Epoch: payload.Epoch})
+		if !n.inSync && n.ticks-n.syncAsked > gapTimeout {
+			n.requestSync()
+		}
+		return
+	}
+
+	// An active leader of this or a newer epoch: join it
+	if payload.Epoch > n.acceptedEpoch || (payload.Epoch == n.acceptedEpoch && from != n.leader && !(n.phase == "leading" && n.activated)) {
+		n.follow(from, payload.Epoch)
+		n.requestSync()
+	}
+}
+
+// handleAckEpoch collects followers' histories; with a quorum the
+// prospective leader adopts the most up-to-date one (must hold n.mu)
+func (n *Server) handleAckEpoch(from string, payload Payload) {
+	sim := n.simulation
+	if n.phase != "leading" || payload.Epoch != n.acceptedEpoch {
+		return
+	}
+
+	// Late joiners and resyncing followers get the history directly
+	if n.leaderAcks != nil {
+		sim.send(n.id, from, MsgNewLeader, Payload{Epoch: n.acceptedEpoch, History: n.history})
+		return
+	}
+
+	n.epochAcks[from] = payload
+	n.checkEpochQuorum()
+}
+
+func (n *Server) checkEpochQuorum() {
+	sim := n.simulation
+	if len(n.epochAcks) < sim.quorum() {
+		return
+	}
+
+	// The history with the highest (currentEpoch, lastZxid) contains
+	// every committed transaction
+	best := n.epochAcks[n.id]
+	for _, ack := range n.epochAcks {
+		if newerHistory(ack, best) {
+			best = ack
+		}
+	}
+	n.currentEpoch = n.acceptedEpoch
+	n.adoptHistory(n.id, best.History)
+	n.leaderAcks = map[string]bool{n.id: true}
+
+	sim.broadcast(map[string]interface{}{
+		"type":    "epoch_established",
+		"nodeId":  n.id,
+		"epoch":   n.currentEpoch,
+		"history": len(n.history),
+	})
+	for _, follower := range sortedKeys(n.epochAcks) {
+		if follower != n.id {
+			sim.send(n.id, follower, MsgNewLeader, Payload{Epoch: n.currentEpoch, History: n.history})
+		}
+	}
+	n.checkLeaderQuorum()
+}
+
+// adoptHistory replaces the history with the one the leader of the
+// epoch settled on. Proposals this server logged that are not in it,
+// such as those an old leader made just before it crashed, were never
+// committed and are discarded. (must hold n.mu)
+func (n *Server) adoptHistory(leader string, history []Txn) {
+	kept := make(map[Zxid]bool, len(history))
+	for _, txn := range history {
+		kept[txn.Zxid] = true
+	}
+	discarded := make([]string, 0)
+	for _, txn := range n.history {
+		if !kept[txn.Zxid] {
+			discarded = append(discarded, txn.Zxid.String())
+		}
+	}
+	n.history = append([]Txn{}, history...)
+
+	if len(discarded) > 0 {
+		n.discarded += len(discarded)
+		n.simulation.broadcast(map[string]interface{}{
+			"type":   "proposals_discarded",
+			"nodeId": n.id,
+			"leader": leader,
+			"epoch":  n.currentEpoch,
+			"zxids":  discarded,
+		})
+	}
+}
+
+// newerHistory reports whether a's history supersedes b's
+func newerHistory(a, b Payload) bool {
+	if a.CurrentEpoch != b.CurrentEpoch {
+		return a.CurrentEpoch > b.CurrentEpoch
+	}
+	lastA, lastB := Zxid{}, Zxid{}
+	if len(a.History) > 0 {
+		lastA = a.History[len(a.History)-1].Zxid
+	}
+	if len(b.History) > 0 {
+		lastB = b.History[len(b.History)-1].Zxid
+	}
+	return lastB.Less(lastA)
+}
+
+func (n *Server) handleAckLeader(from string, payload Payload) {
+	sim := n.simulation
+	if n.phase != "leading" || n.leaderAcks == nil || payload.Epoch != n.currentEpoch {
+		return
+	}
+
+	if n.activated {
+		n.synced[from] = true
+		sim.send(n.id, from, MsgCommitLeader, Payload{Epoch: n.currentEpoch, History: n.history, Committed: n.committed})
+		return
+	}
+
+	n.leaderAcks[from] = true
+	n.checkLeaderQuorum()
+}
+
+// checkLeaderQuorum activates the leader once a quorum accepted its
+// history: everything in it is committed and broadcast can begin
+func (n *Server) checkLeaderQuorum() {
+	sim := n.simulation
+	if n.activated || len(n.leaderAcks) < sim.quorum() {
+		return
+	}
+
+	n.activated = true
+	n.counter = 0
+	n.commitTo(len(n.history))
+	for _, txn := range n.history {
+		n.proposed[requestKey(txn.Session, txn.Cxid)] = true
+	}
+	// Session timers restart with the new leader
+	for _, session := range n.tree.Sessions() {
+		n.sessionSeen[session] = n.ticks
+	}
+
+	for _, follower := range sortedKeys(n.leaderAcks) {
+		if follower != n.id {
+			n.synced[follower] = true
+			n.followerSeen[follower] = n.ticks
+			sim.send(n.id, follower, MsgCommitLeader, Payload{Epoch: n.currentEpoch, History: n.history, Committed: n.committed})
+		}
+	}
+
+	sim.broadcast(map[string]interface{}{
+		"type":      "leader_activated",
+		"nodeId":    n.id,
+		"epoch":     n.currentEpoch,
+		"committed": n.committed,
+	})
+}
+
+// propose assigns the next zxid to a request and broadcasts it
+// (must hold n.mu)
+func (n *Server) propose(req Request) {
+	sim := n.simulation
+
+	key := requestKey(req.Session, req.Cxid)
+	if req.Op != "close_session" {
+		if n.proposed[key] {
+			return
+		}
+		n.proposed[key] = true
+	}
+
+	n.counter++
+	txn := Txn{
+		Zxid:       Zxid{Epoch: n.currentEpoch, Counter: n.counter},
+		Op:         req.Op,
+		Path:       req.Path,
+		Ephemeral:  req.Ephemeral,
+		Sequential: req.Sequential,
+		Session:    req.Session,
+		Cxid:       req.Cxid,
+	}
+	if txn.Sequential {
+		txn.Seq = len(n.history) + 1
+	}
+	n.history = append(n.history, txn)
+	n.proposalAcks[txn.Zxid] = map[string]bool{n.id: true}
+
+	for _, follower := range sortedKeys(n.synced) {
+		sim.send(n.id, follower, MsgPropose, Payload{Txn: &txn})
+	}
+	n.advanceCommit()
+}
+
+// advanceCommit commits proposals acknowledged by a quorum, in zxid
+// order (must hold n.mu)
+func (n *Server) advanceCommit() {
+	sim := n.simulation
+	for n.committed < len(n.history) {
+		zxid := n.history[n.committed].Zxid
+		if len(n.proposalAcks[zxid]) < sim.quorum() {
+			return
+		}
+		delete(n.proposalAcks, zxid)
+		n.apply(n.committed)
+		for _, follower := range sortedKeys(n.synced) {
+			sim.send(n.id, follower, MsgCommit, Payload{Zxid: zxid})
+		}
+	}
+}
+
+// drainProposals appends buffered proposals that extend the history
+// without a gap and acknowledges them (must hold n.mu)
+func (n *Server) drainProposals() {
+	if !n.inSync {
+		return
+	}
+
+	for {
+		next := Zxid{Epoch: n.currentEpoch, Counter: 1}
+		if len(n.history) > 0 {
+			last := n.history[len(n.history)-1].Zxid
+			if last.Epoch == n.currentEpoch {
+				next.Counter = last.Counter + 1
+			}
+			for zxid := range n.pending {
+				if !last.Less(zxid) {
+					delete(n.pending, zxid)
+				}
+			}
+		}
+
+		txn, ok := n.pending[next]
+		if !ok {
+			break
+		}
+		delete(n.pending, next)
+		n.history = append(n.history, txn)
+		n.simulation.send(n.id, n.leader, MsgAck, Payload{Zxid: txn.Zxid})
+	}
+
+	if len(n.pending) == 0 {
+		n.gapSince = 0
+	} else if n.gapSince == 0 {
+		n.gapSince = n.ticks
+	}
+	n.applyCommits()
+}
+
+// applyCommits applies history up to the highest zxid the leader
+// committed (must hold n.mu)
+func (n *Server) applyCommits() {
+	for n.committed < len(n.history) && !n.commitTarget.Less(n.history[n.committed].Zxid) {
+		n.apply(n.committed)
+	}
+}
+
+// commitTo applies history up to index count (must hold n.mu)
+func (n *Server) commitTo(count int) {
+	if count > len(n.history) {
+		count = len(n.history)
+	}
+	for n.committed < count {
+		n.apply(n.committed)
+	}
+	if n.committed > 0 {
+		n.commitTarget = n.history[n.committed-1].Zxid
+	}
+}
+
+// apply executes history[i] on the data tree, then fires watches and
+// answers the client that issued it (must hold n.mu)
+func (n *Server) apply(i int) {
+	sim := n.simulation
+	txn := n.history[i]
+	n.committed = i + 1
+
+	path, changed, err := n.tree.Apply(txn)
+	resp := Response{Cxid: txn.Cxid, Op: txn.Op, Path: path}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	n.results[requestKey(txn.Session, txn.Cxid)] = resp
+
+	event := map[string]interface{}{
+		"type":    "txn_committed",
+		"nodeId":  n.id,
+		"zxid":    txn.Zxid.String(),
+		"op":      txn.Op,
+		"session": txn.Session,
+		"path":    path,
+	}
+	if err != nil {
+		event["error"] = err.Error()
+	}
+	sim.broadcast(event)
+
+	for _, p := range changed {
+		for _, session := range sortedKeys(n.watches[p]) {
+			sim.send(n.id, session, MsgWatchEvent, Payload{Path: p})
+		}
+		delete(n.watches, p)
+	}
+
+	switch txn.Op {
+	case "create_session":
+		if n.activated {
+			n.sessionSeen[txn.Session] = n.ticks
+		}
+	case "close_session":
+		delete(n.sessionSeen, txn.Session)
+		delete(n.expiring, txn.Session)
+		if n.clients[txn.Session] {
+			sim.send(n.id, txn.Session, MsgSessionExpired, Payload{Session: txn.Session})
+		}
+		n.dropSession(txn.Session)
+		return
+	}
+
+	session := txn.Session
+	if queue := n.queues[session]; n.inflight[session] && len(queue) > 0 && queue[0].Cxid == txn.Cxid {
+		n.respond(session, resp)
+		n.processQueue(session)
+	}
+}
+
+// dropSession forgets a closed session's pending requests and watches
+func (n *Server) dropSession(session string) {
+	delete(n.clients, session)
+	delete(n.nextCxid, session)
+	delete(n.held, session)
+	delete(n.queues, session)
+	delete(n.inflight, session)
+	delete(n.forwardedTo, session)
+	for _, sessions := range n.watches {
+		delete(sessions, session)
+	}
+}
+
+// handleRequest queues a client request, holding back any that arrive
+// ahead of an earlier one of the same session (must hold n.mu)
+func (n *Server) handleRequest(client string, req Request) {
+	sim := n.simulation
+	session := req.Session
+	n.clients[session] = true
+
+	if _, ok := n.nextCxid[session]; !ok {
+		n.nextCxid[session] = req.Base
+	}
+	if req.Cxid < n.nextCxid[session] {
+		return // Duplicate of a request already queued
+	}
+	if n.held[session] == nil {
+		n.held[session] = make(map[int]Request)
+	}
+	n.held[session][req.Cxid] = req
+
+	if req.Cxid != n.nextCxid[session] {
+		sim.broadcast(map[string]interface{}{
+			"type":     "request_held",
+			"nodeId":   n.id,
+			"session":  session,
+			"cxid":     req.Cxid,
+			"expected": n.nextCxid[session],
+		})
+	}
+
+	for {
+		next, ok := n.held[session][n.nextCxid[session]]
+		if !ok {
+			break
+		}
+		delete(n.held[session], next.Cxid)
+		n.queues[session] = append(n.queues[session], next)
+		n.nextCxid[session]++
+	}
+	n.processQueue(session)
+}
+
+// processQueue runs a session's requests in order. Reads are answered
+// from the local tree, but only once every earlier write of the session
+// has been applied here; that is what makes sessions FIFO.
+func (n *Server) processQueue(session string) {
+	for !n.inflight[session] && len(n.queues[session]) > 0 {
+		req := n.queues[session][0]
+
+		if req.Op == "get_children" {
+			if req.Watch {
+				if n.watches[req.Path] == nil {
+					n.watches[req.Path] = make(map[string]bool)
+				}
+				n.watches[req.Path][session] = true
+			}
+			n.respond(session, Response{Cxid: req.Cxid, Op: req.Op, Path: req.Path, Children: n.tree.Children(req.Path)})
+			continue
+		}
+
+		// Already committed through another server before a reconnect
+		if resp, ok := n.results[requestKey(session, req.Cxid)]; ok {
+			n.respond(session, resp)
+			continue
+		}
+
+		n.inflight[session] = true
+		n.forwardedTo[session] = ""
+		n.forward(session)
+	}
+}
+
+// respond answers the request at the head of a session's queue
+func (n *Server) respond(session string, resp Response) {
+	n.queues[session] = n.queues[session][1:]
+	n.inflight[session] = false
+	delete(n.forwardedTo, session)
+	n.simulation.send(n.id, session, MsgResponse, Payload{Response: &resp})
+}
+
+// forward hands the session's pending write to the active leader, if
+// there is one; the tick loop retries otherwise (must hold n.mu)
+func (n *Server) forward(session string) {
+	leader := n.currentLeader()
+	if leader == "" || len(n.queues[session]) == 0 {
+		return
+	}
+	req := n.queues[session][0]
+	n.forwardedTo[session] = leader
+
+	if leader == n.id {
+		n.propose(req)
+		return
+	}
+	n.simulation.send(n.id, leader, MsgForward, Payload{Request: &req})
+}
+
+func requestKey(session string, cxid int) string {
+	return fmt.Sprintf("%s/%d", session, cxid)
+}
+
+// sortedKeys returns the keys of a map in order, so that runs with the
+// same seed send the same messages in the same order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
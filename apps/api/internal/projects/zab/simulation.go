@@ -0,0 +1,454 @@
+package zab
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+	"github.com/ersantana/distributed-systems-learning/packages/simulation/engine"
+)
+
+// Request is a client operation. Requests of one session carry
+// increasing cxids and are executed in that order.
+type Request struct {
+	Session    string `json:"session"`
+	Cxid       int    `json:"cxid"`
+	Base       int    `json:"base"` // Lowest cxid the client still waits for
+	Op         string `json:"op"`   // "create_session", "create", "delete", "get_children"
+	Path       string `json:"path,omitempty"`
+	Ephemeral  bool   `json:"ephemeral,omitempty"`
+	Sequential bool   `json:"sequential,omitempty"`
+	Watch      bool   `json:"watch,omitempty"`
+}
+
+// Response answers one request
+type Response struct {
+	Cxid     int      `json:"cxid"`
+	Op       string   `json:"op"`
+	Path     string   `json:"path,omitempty"`
+	Children []string `json:"children,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// Payload is the content of all messages exchanged in this project
+type Payload struct {
+	Epoch        int       `json:"epoch,omitempty"`
+	CurrentEpoch int       `json:"currentEpoch,omitempty"`
+	History      []Txn     `json:"history,omitempty"`
+	Committed    int       `json:"committed,omitempty"`
+	Zxid         Zxid      `json:"zxid,omitempty"`
+	Txn          *Txn      `json:"txn,omitempty"`
+	Request      *Request  `json:"request,omitempty"`
+	Response     *Response `json:"response,omitempty"`
+	Session      string    `json:"session,omitempty"`
+	Path         string    `json:"path,omitempty"`
+}
+
+// scriptedFault crashes, recovers or isolates a node at a given tick;
+// the target "leader" is resolved when the fault fires
+type scriptedFault struct {
+	tick   int
+	action string // "crash", "recover", "isolate", "heal", "write"
+	target string
+}
+
+// Simulation implements Zab, ZooKeeper's atomic broadcast: epoch
+// discovery and leader activation, then proposal/ack/commit of
+// transactions that clients issue through FIFO sessions
+type Simulation struct {
+	mu sync.RWMutex
+
+	engine    *engine.Engine
+	rng       *rand.Rand
+	transport *transport.NetworkTransport
+	broadcast func(interface{})
+
+	servers  []*Server
+	clients  []*Client
+	scenario string
+
+	sessionTimeout int // Ticks without a ping before a session expires
+	script         []scriptedFault
+	crashedLeader  string // Resolved target of the scripted crash
+	scriptedWrites int    // Writes the script had the isolated leader propose
+
+	running bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// Config for Zab simulation
+type Config struct {
+	NodeCount int // Servers
+	Scenario  string
+}
+
+// NewSimulation creates a new Zab simulation
+func NewSimulation(eng *engine.Engine, trans *transport.NetworkTransport, broadcast func(interface{}), config Config) *Simulation {
+	if config.NodeCount == 0 {
+		config.NodeCount = 3
+	}
+
+	sim := &Simulation{
+		engine:         eng,
+		rng:            eng.Rand(),
+		transport:      trans,
+		broadcast:      broadcast,
+		scenario:       config.Scenario,
+		sessionTimeout: 20,
+	}
+
+	// Scenarios: "broadcast" where two clients take turns holding the
+	// lock, "leader_crash" which kills the leader mid-stream,
+	// "session_expiry" where the lock holder dies and its ephemeral lock
+	// node disappears once the session expires, and "stale_leader" where
+	// the leader is cut off from the other servers, proposes writes no
+	// follower receives and crashes: the others elect a leader of a new
+	// epoch, and when the old leader recovers it discards the proposals
+	// it never got committed
+	holdTicks := 15
+	switch config.Scenario {
+	case "leader_crash":
+		sim.script = []scriptedFault{
+			{tick: 80, action: "crash", target: "leader"},
+			{tick: 150, action: "recover", target: "leader"},
+		}
+	case "stale_leader":
+		sim.script = []scriptedFault{
+			{tick: 80, action: "isolate", target: "leader"},
+			{tick: 82, action: "write", target: "leader"},
+			{tick: 84, action: "write", target: "leader"},
+			{tick: 90, action: "crash", target: "leader"},
+			{tick: 150, action: "heal", target: "leader"},
+			{tick: 150, action: "recover", target: "leader"},
+		}
+	case "session_expiry":
+		holdTicks = 0 // Hold until the session ends
+		sim.script = []scriptedFault{
+			{tick: 60, action: "crash", target: "client-1"},
+		}
+	}
+
+	trans.SetLatency(30*time.Millisecond, 120*time.Millisecond)
+	trans.SetPacketLoss(0)
+
+	serverIDs := make([]string, config.NodeCount)
+	for i := range serverIDs {
+		serverIDs[i] = fmt.Sprintf("server-%d", i+1)
+	}
+	for _, id := range serverIDs {
+		server := newServer(id, serverIDs, sim)
+		sim.servers = append(sim.servers, server)
+		trans.RegisterHandler(id, server.handleMessage)
+		eng.AddNode(server)
+	}
+
+	for i := 0; i < 2; i++ {
+		id := fmt.Sprintf("client-%d", i+1)
+		client := newClient(id, serverIDs, i, sim)
+		client.holdTicks = holdTicks
+		client.startAt = 30 + i*5
+		sim.clients = append(sim.clients, client)
+		trans.RegisterHandler(id, client.handleMessage)
+		eng.AddNode(client)
+	}
+
+	return sim
+}
+
+// Start starts the simulation
+func (s *Simulation) Start(ctx context.Context) error {
+	s.mu.Lock()
+	s.running = true
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	s.mu.Unlock()
+
+	return s.engine.Start(ctx)
+}
+
+// Stop stops the simulation
+func (s *Simulation) Stop() error {
+	s.mu.Lock()
+	s.running = false
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Unlock()
+
+	return s.engine.Stop()
+}
+
+// GetState returns the current simulation state
+func (s *Simulation) GetState() *protocol.SimulationStateResponse {
+	nodes := make(map[string]protocol.NodeState)
+	committed := make(map[string]int)
+	zxids := make(map[string]string)
+	phases := make(map[string]string)
+	discarded := 0
+	for _, server := range s.servers {
+		state := server.GetState()
+		nodes[server.id] = protocol.NodeState{
+			ID:          server.id,
+			Status:      state["status"].(string),
+			Role:        state["role"].(string),
+			Term:        state["epoch"].(int),
+			CustomState: state,
+		}
+		committed[server.id] = state["committed"].(int)
+		zxids[server.id] = state["lastZxid"].(string)
+		phases[server.id] = state["zabPhase"].(string)
+		discarded += state["discarded"].(int)
+	}
+	leader := s.activeLeader()
+
+	holders := make([]string, 0)
+	for _, client := range s.clients {
+		state := client.GetState()
+		nodes[client.id] = protocol.NodeState{
+			ID:          client.id,
+			Status:      state["status"].(string),
+			Role:        "client",
+			CustomState: state,
+		}
+		if state["status"] == "running" && state["state"] == "holding" {
+			holders = append(holders, client.id)
+		}
+	}
+
+	s.mu.RLock()
+	running := s.running
+	s.mu.RUnlock()
+
+	mode := "step"
+	if s.engine != nil {
+		mode = s.engine.GetMode().String()
+	}
+
+	return &protocol.SimulationStateResponse{
+		Type:        protocol.MsgSimulationState,
+		VirtualTime: time.Now().UnixMilli(),
+		Mode:        mode,
+		Speed:       1.0,
+		Running:     running,
+		Nodes:       nodes,
+		Metadata: map[string]interface{}{
+			"leader":          leader,
+			"committed":       committed,
+			"zxids":           zxids,
+			"phases":          phases,
+			"discarded":       discarded,
+			"lockHolders":     holders,
+			"mutualExclusion": len(holders) <= 1,
+			"sessionTimeout":  s.sessionTimeout,
+		},
+	}
+}
+
+// GetNodes returns node states
+func (s *Simulation) GetNodes() map[string]protocol.NodeState {
+	state := s.GetState()
+	return state.Nodes
+}
+
+// Layout puts the active leader in the middle of the other servers and
+// the clients, or places everyone on a ring during an election
+func (s *Simulation) Layout() *protocol.Layout {
+	leader := s.activeLeader()
+	order := make([]string, 0, len(s.servers)+len(s.clients))
+	for _, server := range s.servers {
+		if server.id != leader {
+			order = append(order, server.id)
+		}
+	}
+	for _, client := range s.clients {
+		order = append(order, client.id)
+	}
+	if leader == "" {
+		return &protocol.Layout{Kind: protocol.LayoutRing, Order: order}
+	}
+	return &protocol.Layout{
+		Kind:   protocol.LayoutStar,
+		Center: leader,
+		Order:  order,
+	}
+}
+
+// CrashNode crashes a server or client
+func (s *Simulation) CrashNode(nodeID string) error {
+	if server := s.findServer(nodeID); server != nil {
+		server.crash()
+		return nil
+	}
+	if client := s.findClient(nodeID); client != nil {
+		client.mu.Lock()
+		client.status = "crashed"
+		client.mu.Unlock()
+		return nil
+	}
+	return fmt.Errorf("unknown node: %s", nodeID)
+}
+
+// RecoverNode recovers a crashed server or client
+func (s *Simulation) RecoverNode(nodeID string) error {
+	if server := s.findServer(nodeID); server != nil {
+		server.recover()
+		return nil
+	}
+	if client := s.findClient(nodeID); client != nil {
+		client.mu.Lock()
+		client.status = "running"
+		client.lastPong = client.ticks
+		client.mu.Unlock()
+		return nil
+	}
+	return fmt.Errorf("unknown node: %s", nodeID)
+}
+
+// findServer looks up a server; the node lists are fixed after construction
+func (s *Simulation) findServer(nodeID string) *Server {
+	for _, server := range s.servers {
+		if server.id == nodeID {
+			return server
+		}
+	}
+	return nil
+}
+
+func (s *Simulation) findClient(nodeID string) *Client {
+	for _, client := range s.clients {
+		if client.id == nodeID {
+			return client
+		}
+	}
+	return nil
+}
+
+// activeLeader returns the running leader of the highest epoch
+func (s *Simulation) activeLeader() string {
+	leader, epoch := "", 0
+	for _, server := range s.servers {
+		server.mu.RLock()
+		if server.status == "running" && server.phase == "leading" && server.activated && server.currentEpoch > epoch {
+			leader, epoch = server.id, server.currentEpoch
+		}
+		server.mu.RUnlock()
+	}
+	return leader
+}
+
+// quorum returns the number of servers forming a majority
+func (s *Simulation) quorum() int {
+	return len(s.servers)/2 + 1
+}
+
+// advanceSchedule applies scripted faults. It runs after the ticking
+// node has released its lock since it locks other nodes.
+func (s *Simulation) advanceSchedule(ticks int) {
+	s.mu.Lock()
+	due := make([]scriptedFault, 0)
+	remaining := s.script[:0]
+	for _, fault := range s.script {
+		if fault.tick <= ticks {
+			due = append(due, fault)
+		} else {
+			remaining = append(remaining, fault)
+		}
+	}
+	s.script = remaining
+	s.mu.Unlock()
+
+	for _, fault := range due {
+		target := fault.target
+		if target == "leader" {
+			leader := s.activeLeader()
+			s.mu.Lock()
+			if s.crashedLeader == "" {
+				s.crashedLeader = leader
+			}
+			target = s.crashedLeader
+			s.mu.Unlock()
+		}
+		if target == "" {
+			continue
+		}
+
+		switch fault.action {
+		case "crash":
+			s.CrashNode(target)
+		case "recover":
+			s.RecoverNode(target)
+		case "isolate", "heal":
+			for _, server := range s.servers {
+				if server.id == target {
+					continue
+				}
+				if fault.action == "isolate" {
+					s.transport.CreateBidirectionalPartition(target, server.id)
+				} else {
+					s.transport.ClearBidirectionalPartition(target, server.id)
+				}
+			}
+		case "write":
+			s.scriptedWrite(target)
+		}
+		s.broadcast(map[string]interface{}{
+			"type":   "scripted_fault",
+			"action": fault.action,
+			"nodeId": target,
+		})
+	}
+}
+
+// scriptedWrite has a leader propose a write of the script's own
+// session, as if a client connected to it had sent one
+func (s *Simulation) scriptedWrite(leaderID string) {
+	server := s.findServer(leaderID)
+	if server == nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.scriptedWrites++
+	cxid := s.scriptedWrites
+	s.mu.Unlock()
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.status == "running" && server.phase == "leading" && server.activated {
+		server.propose(Request{Session: "script", Cxid: cxid, Op: "create", Path: fmt.Sprintf("/stale-%d", cxid)})
+	}
+}
+
+// send transmits a message and reports it to the frontend
+func (s *Simulation) send(from, to string, msgType transport.MessageType, payload Payload) {
+	env := transport.NewEnvelope(from, to, msgType, payload)
+
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageSent,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+
+	s.transport.Send(s.ctx, env)
+}
+
+// received reports a delivered message and returns its payload
+func (s *Simulation) received(env *transport.Envelope) Payload {
+	s.broadcast(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageReceived,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+	payload, _ := env.Payload.(Payload)
+	return payload
+}
@@ -0,0 +1,164 @@
+package zab
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Zxid is a ZooKeeper transaction id: the leader's epoch and a counter
+// that restarts with every epoch
+type Zxid struct {
+	Epoch   int `json:"epoch"`
+	Counter int `json:"counter"`
+}
+
+// Less orders zxids by epoch, then counter
+func (z Zxid) Less(other Zxid) bool {
+	if z.Epoch != other.Epoch {
+		return z.Epoch < other.Epoch
+	}
+	return z.Counter < other.Counter
+}
+
+func (z Zxid) String() string {
+	return fmt.Sprintf("%d.%d", z.Epoch, z.Counter)
+}
+
+// Txn is a state change broadcast by the leader
+type Txn struct {
+	Zxid       Zxid   `json:"zxid"`
+	Op         string `json:"op"` // "create", "delete", "create_session", "close_session"
+	Path       string `json:"path,omitempty"`
+	Ephemeral  bool   `json:"ephemeral,omitempty"`
+	Sequential bool   `json:"sequential,omitempty"`
+	Seq        int    `json:"seq,omitempty"` // Suffix of sequential nodes
+	Session    string `json:"session"`
+	Cxid       int    `json:"cxid,omitempty"` // Client request the txn answers
+}
+
+// Znode is a node of the data tree
+type Znode struct {
+	Path           string `json:"path"`
+	EphemeralOwner string `json:"ephemeralOwner,omitempty"`
+	Zxid           Zxid   `json:"zxid"`
+}
+
+// DataTree is the replicated state every server builds by applying
+// committed transactions in zxid order
+type DataTree struct {
+	nodes    map[string]*Znode
+	sessions map[string]bool
+}
+
+// NewDataTree creates a tree holding "/" and the "/lock" parent used by
+// the lock recipe
+func NewDataTree() *DataTree {
+	return &DataTree{
+		nodes: map[string]*Znode{
+			"/":     {Path: "/"},
+			"/lock": {Path: "/lock"},
+		},
+		sessions: make(map[string]bool),
+	}
+}
+
+// Apply executes txn. It returns the resulting path (sequential creates
+// pick their name here), the parents whose children changed, and an
+// error for requests that fail; a failed txn changes nothing.
+func (t *DataTree) Apply(txn Txn) (string, []string, error) {
+	switch txn.Op {
+	case "create_session":
+		t.sessions[txn.Session] = true
+		return "", nil, nil
+
+	case "close_session":
+		delete(t.sessions, txn.Session)
+		changed := make([]string, 0)
+		for _, path := range t.paths() {
+			if t.nodes[path].EphemeralOwner == txn.Session {
+				delete(t.nodes, path)
+				changed = append(changed, parent(path))
+			}
+		}
+		return "", changed, nil
+
+	case "create":
+		path := txn.Path
+		if txn.Sequential {
+			path = fmt.Sprintf("%s%010d", txn.Path, txn.Seq)
+		}
+		if _, ok := t.nodes[path]; ok {
+			return path, nil, fmt.Errorf("node exists: %s", path)
+		}
+		if _, ok := t.nodes[parent(path)]; !ok {
+			return path, nil, fmt.Errorf("no parent: %s", path)
+		}
+		if txn.Ephemeral && !t.sessions[txn.Session] {
+			return path, nil, fmt.Errorf("session expired: %s", txn.Session)
+		}
+		node := &Znode{Path: path, Zxid: txn.Zxid}
+		if txn.Ephemeral {
+			node.EphemeralOwner = txn.Session
+		}
+		t.nodes[path] = node
+		return path, []string{parent(path)}, nil
+
+	case "delete":
+		if _, ok := t.nodes[txn.Path]; !ok {
+			return txn.Path, nil, fmt.Errorf("no node: %s", txn.Path)
+		}
+		delete(t.nodes, txn.Path)
+		return txn.Path, []string{parent(txn.Path)}, nil
+	}
+	return "", nil, fmt.Errorf("unknown op: %s", txn.Op)
+}
+
+// Children returns the sorted names of path's children
+func (t *DataTree) Children(path string) []string {
+	children := make([]string, 0)
+	for p := range t.nodes {
+		if p != "/" && parent(p) == path {
+			children = append(children, p[strings.LastIndex(p, "/")+1:])
+		}
+	}
+	sort.Strings(children)
+	return children
+}
+
+// Sessions returns the live sessions, sorted
+func (t *DataTree) Sessions() []string {
+	sessions := make([]string, 0, len(t.sessions))
+	for s := range t.sessions {
+		sessions = append(sessions, s)
+	}
+	sort.Strings(sessions)
+	return sessions
+}
+
+// Nodes returns copies of all znodes in path order
+func (t *DataTree) Nodes() []Znode {
+	nodes := make([]Znode, 0, len(t.nodes))
+	for _, path := range t.paths() {
+		nodes = append(nodes, *t.nodes[path])
+	}
+	return nodes
+}
+
+func (t *DataTree) paths() []string {
+	paths := make([]string, 0, len(t.nodes))
+	for p := range t.nodes {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// parent returns the parent path of p
+func parent(p string) string {
+	i := strings.LastIndex(p, "/")
+	if i <= 0 {
+		return "/"
+	}
+	return p[:i]
+}
@@ -0,0 +1,292 @@
+package simulation
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+	"github.com/ersantana/distributed-systems-learning/packages/simulation/engine"
+	"github.com/ersantana/distributed-systems-learning/packages/visualization/events"
+)
+
+// breakpoint is an armed breakpoint with what it remembers of the run
+type breakpoint struct {
+	spec      protocol.BreakpointSpec
+	roles     map[string]string // Role of each node at the last tick, for "role"
+	threshold int64             // Messages sent above which it hits, for "messages"
+	spent     bool              // Hit, and does not repeat
+}
+
+// breakpointSet pauses a run when one of its breakpoints hits. Events
+// are checked as they occur; roles and message counts on every tick.
+type breakpointSet struct {
+	mu sync.Mutex
+
+	engine *engine.Engine
+	sim    ProjectSimulation
+	armed  []*breakpoint
+	nextID int
+}
+
+func newBreakpointSet(eng *engine.Engine, sim ProjectSimulation, breakpoints []protocol.BreakpointSpec) *breakpointSet {
+	bs := &breakpointSet{engine: eng, sim: sim}
+	bs.add(breakpoints, false)
+	return bs
+}
+
+// add arms breakpoints, disarming the others first if asked to, and
+// returns their IDs
+func (bs *breakpointSet) add(breakpoints []protocol.BreakpointSpec, replace bool) []string {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	if replace {
+		bs.armed = nil
+	}
+	ids := make([]string, 0, len(breakpoints))
+	for _, spec := range breakpoints {
+		bs.nextID++
+		if spec.ID == "" {
+			spec.ID = fmt.Sprintf("bp-%d", bs.nextID)
+		}
+		ids = append(ids, spec.ID)
+		bs.armed = append(bs.armed, &breakpoint{
+			spec:      spec,
+			roles:     make(map[string]string),
+			threshold: spec.Count,
+		})
+	}
+	return ids
+}
+
+// remove disarms the breakpoints with the given IDs
+func (bs *breakpointSet) remove(ids []string) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	removed := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		removed[id] = true
+	}
+	armed := bs.armed[:0]
+	for _, b := range bs.armed {
+		if !removed[b.spec.ID] {
+			armed = append(armed, b)
+		}
+	}
+	bs.armed = armed
+}
+
+// onEvent returns the hits of the event breakpoints matching an event
+func (bs *breakpointSet) onEvent(eventType string, data map[string]interface{}) []protocol.BreakpointHitResponse {
+	// The engine emits these holding its own lock, and pausing takes it
+	if bs == nil || routineEvents[eventType] {
+		return nil
+	}
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	var hits []protocol.BreakpointHitResponse
+	for _, b := range bs.armed {
+		if b.spent || b.spec.Condition != "event" {
+			continue
+		}
+		messageType, _ := data["messageType"].(string)
+		if b.spec.EventType != eventType && b.spec.EventType != messageType {
+			continue
+		}
+		if b.spec.NodeID != "" && !involves(data, b.spec.NodeID) {
+			continue
+		}
+		hits = append(hits, bs.hit(b, eventType, data))
+	}
+	bs.disarmHit()
+	return hits
+}
+
+// onMessage returns the hits of the event breakpoints matching a message
+// a project broadcast
+func (bs *breakpointSet) onMessage(msg interface{}) []protocol.BreakpointHitResponse {
+	if bs == nil || !bs.watchesEvents() {
+		return nil
+	}
+	eventType, data, ok := eventOf(msg)
+	if !ok {
+		return nil
+	}
+	return bs.onEvent(eventType, data)
+}
+
+// watchesEvents tells whether an event breakpoint is armed, before
+// messages are decoded for it
+func (bs *breakpointSet) watchesEvents() bool {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	for _, b := range bs.armed {
+		if b.spec.Condition == "event" {
+			return true
+		}
+	}
+	return false
+}
+
+// onTick returns the hits of the role and message breakpoints, given the
+// messages sent so far
+func (bs *breakpointSet) onTick(sent int64) []protocol.BreakpointHitResponse {
+	if bs == nil {
+		return nil
+	}
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	var hits []protocol.BreakpointHitResponse
+	var nodes map[string]protocol.NodeState
+	for _, b := range bs.armed {
+		if b.spent {
+			continue
+		}
+		switch b.spec.Condition {
+		case "role":
+			if nodes == nil {
+				nodes = bs.sim.GetState().Nodes
+			}
+			hits = append(hits, bs.roleHits(b, nodes)...)
+		case "messages":
+			if sent > b.threshold {
+				hits = append(hits, bs.hit(b, "messages_sent", map[string]interface{}{
+					"count": sent,
+				}))
+				b.threshold = sent + b.spec.Count
+			}
+		}
+	}
+	bs.disarmHit()
+	return hits
+}
+
+// roleHits returns a hit for every watched node that took the role since
+// the last tick (must hold bs.mu)
+func (bs *breakpointSet) roleHits(b *breakpoint, nodes map[string]protocol.NodeState) []protocol.BreakpointHitResponse {
+	ids := make([]string, 0, len(nodes))
+	for id := range nodes {
+		if b.spec.NodeID == "" || b.spec.NodeID == id {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	var hits []protocol.BreakpointHitResponse
+	for _, id := range ids {
+		role, previous := nodes[id].Role, b.roles[id]
+		b.roles[id] = role
+		if role == b.spec.Role && previous != role {
+			hits = append(hits, bs.hit(b, "role_changed", map[string]interface{}{
+				"nodeId":       id,
+				"role":         role,
+				"previousRole": previous,
+			}))
+			if b.spent {
+				break
+			}
+		}
+	}
+	return hits
+}
+
+// hit marks a breakpoint hit by an event (must hold bs.mu)
+func (bs *breakpointSet) hit(b *breakpoint, eventType string, data map[string]interface{}) protocol.BreakpointHitResponse {
+	b.spent = !b.spec.Repeat
+	return protocol.BreakpointHitResponse{
+		Type:       protocol.MsgBreakpointHit,
+		Breakpoint: b.spec,
+		Event: protocol.TimelineEvent{
+			Time: time.Now().UnixMilli(),
+			Type: eventType,
+			Data: data,
+		},
+		ElapsedMs: bs.engine.Elapsed().Milliseconds(),
+	}
+}
+
+// disarmHit drops the breakpoints that hit and do not repeat (must hold
+// bs.mu)
+func (bs *breakpointSet) disarmHit() {
+	armed := bs.armed[:0]
+	for _, b := range bs.armed {
+		if !b.spent {
+			armed = append(armed, b)
+		}
+	}
+	bs.armed = armed
+}
+
+// involves tells whether an event names a node in any of its fields
+func involves(data map[string]interface{}, nodeID string) bool {
+	for _, v := range data {
+		if s, ok := v.(string); ok && s == nodeID {
+			return true
+		}
+	}
+	return false
+}
+
+// eventOf reads the type and the data of a message a project broadcast
+func eventOf(msg interface{}) (string, map[string]interface{}, bool) {
+	switch m := msg.(type) {
+	case *protocol.MessageEventResponse:
+		return string(m.Type), map[string]interface{}{
+			"from":        m.From,
+			"to":          m.To,
+			"messageType": m.MessageType,
+		}, true
+	case map[string]interface{}:
+		eventType, ok := m["type"].(string)
+		return eventType, m, ok
+	case events.Event:
+		return string(m.EventType()), m.Data(), true
+	}
+	return "", nil, false
+}
+
+// withPauseOnEvents returns the breakpoints of a run along with those that
+// pause it at every event of the given types
+func withPauseOnEvents(breakpoints []protocol.BreakpointSpec, eventTypes []string) []protocol.BreakpointSpec {
+	all := append(make([]protocol.BreakpointSpec, 0, len(breakpoints)+len(eventTypes)), breakpoints...)
+	for _, eventType := range eventTypes {
+		all = append(all, protocol.BreakpointSpec{
+			ID:        "pause-on-" + eventType,
+			Condition: "event",
+			EventType: eventType,
+			Repeat:    true,
+		})
+	}
+	return all
+}
+
+// pauseAt pauses the run for the breakpoints that hit and tells the
+// clients what triggered them
+func (m *Manager) pauseAt(hits []protocol.BreakpointHitResponse) {
+	if len(hits) == 0 {
+		return
+	}
+	m.breakpoints.Load().engine.Pause()
+	m.completion.Load().record(hits)
+	for i := range hits {
+		m.BroadcastMessage(&hits[i])
+	}
+}
+
+// SetBreakpoints arms breakpoints on the current run
+func (m *Manager) SetBreakpoints(breakpoints []protocol.BreakpointSpec, replace bool) error {
+	if err := protocol.ValidateBreakpoints(breakpoints); err != nil {
+		return err
+	}
+	set := m.breakpoints.Load()
+	if set == nil {
+		return fmt.Errorf("no simulation running")
+	}
+	set.add(breakpoints, replace)
+	return nil
+}
@@ -0,0 +1,273 @@
+package simulation
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+)
+
+// checkpointVersion is the format of the checkpoints this server saves
+// and loads
+const checkpointVersion = 1
+
+// inputLog records what a run started from and every input made to it
+// since, which is all it takes to replay the run from its seed
+type inputLog struct {
+	mu      sync.Mutex
+	request protocol.StartSimulationRequest
+	inputs  []protocol.CheckpointInput
+}
+
+func newInputLog(project, scenario string, config protocol.StartSimulationRequest, seed int64) *inputLog {
+	config.Project = project
+	config.Scenario = scenario
+	config.Config.Seed = seed
+	return &inputLog{request: config}
+}
+
+func (l *inputLog) record(input protocol.CheckpointInput) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inputs = append(l.inputs, input)
+}
+
+func (l *inputLog) snapshot() (protocol.StartSimulationRequest, []protocol.CheckpointInput) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.request, append([]protocol.CheckpointInput(nil), l.inputs...)
+}
+
+// recordInput stamps an input with the virtual time it was made at
+// (must hold m.mu)
+func (m *Manager) recordInput(input protocol.CheckpointInput) {
+	if m.engine == nil {
+		return
+	}
+	input.AtNs = int64(m.engine.Elapsed())
+	input.Events = m.engine.Executed()
+	m.checkpoint.Load().record(input)
+}
+
+// SaveCheckpoint captures the current run
+func (m *Manager) SaveCheckpoint() (*protocol.Checkpoint, error) {
+	m.mu.RLock()
+	eng, trans := m.engine, m.transport
+	running := m.simulation != nil
+	m.mu.RUnlock()
+
+	inputs := m.checkpoint.Load()
+	if !running || eng == nil || inputs == nil {
+		return nil, fmt.Errorf("no simulation running")
+	}
+
+	cp := &protocol.Checkpoint{
+		Version: checkpointVersion,
+		SavedAt: time.Now().UnixMilli(),
+	}
+	cp.Request, cp.Inputs = inputs.snapshot()
+
+	// Between two events, so the nodes and the network agree on the time
+	eng.Inspect(func() {
+		elapsed := eng.Elapsed()
+		now := eng.GetVirtualTime()
+		cp.ElapsedNs = int64(elapsed)
+		cp.Nodes = m.GetState().Nodes
+		for _, flight := range trans.InFlightMessages() {
+			cp.InFlight = append(cp.InFlight, protocol.InFlightMessage{
+				MessageID:   flight.Envelope.ID,
+				From:        flight.Envelope.From,
+				To:          flight.Envelope.To,
+				MessageType: string(flight.Envelope.Type),
+				Payload:     flight.Envelope.Payload,
+				DeliverAtNs: int64(elapsed + flight.DeliverAt.Sub(now)),
+			})
+		}
+	})
+	return cp, nil
+}
+
+// LoadCheckpoint replaces the current run with the one saved: it starts
+// it again from its seed and replays its inputs up to the point it was
+// saved at, then leaves it paused. Nodes or messages that differ from
+// the saved ones are reported.
+func (m *Manager) LoadCheckpoint(cp protocol.Checkpoint) error {
+	if cp.Version != checkpointVersion {
+		return fmt.Errorf("unsupported checkpoint version %d", cp.Version)
+	}
+	if cp.Request.Config.Seed == 0 {
+		return fmt.Errorf("checkpoint has no seed")
+	}
+	if cp.ElapsedNs < 0 {
+		return fmt.Errorf("checkpoint has a negative time")
+	}
+	for i, input := range cp.Inputs {
+		if input.AtNs < 0 || input.AtNs > cp.ElapsedNs || (i > 0 && input.AtNs < cp.Inputs[i-1].AtNs) {
+			return fmt.Errorf("checkpoint input %d is out of order", i+1)
+		}
+	}
+
+	m.campaign.Store(nil)
+	request := cp.Request
+	if err := m.start(request.Project, request.Scenario, request, true); err != nil {
+		return err
+	}
+	eng := m.GetEngine()
+
+	// Inputs are applied without the lock: the events run may need it
+	failed := 0
+	for _, input := range cp.Inputs {
+		if ahead := time.Duration(input.AtNs) - eng.Elapsed(); ahead > 0 {
+			eng.FastForward(ahead)
+		}
+		if err := m.applyInput(input); err != nil {
+			failed++
+		}
+	}
+	if ahead := time.Duration(cp.ElapsedNs) - eng.Elapsed(); ahead > 0 {
+		eng.FastForward(ahead)
+	}
+
+	restored, err := m.SaveCheckpoint()
+	if err != nil {
+		return err
+	}
+	mismatched := mismatchedNodes(cp.Nodes, restored.Nodes)
+	m.handleEvent("checkpoint_restored", map[string]interface{}{
+		"elapsedMs":        time.Duration(cp.ElapsedNs).Milliseconds(),
+		"inputs":           len(cp.Inputs),
+		"failedInputs":     failed,
+		"mismatchedNodes":  mismatched,
+		"inFlightMatches":  sameFlights(cp.InFlight, restored.InFlight),
+		"inFlightMessages": len(restored.InFlight),
+	})
+
+	m.mu.RLock()
+	m.broadcastState()
+	m.mu.RUnlock()
+	return nil
+}
+
+// applyInput makes an input of a saved run again
+func (m *Manager) applyInput(input protocol.CheckpointInput) error {
+	switch input.Kind {
+	case "crash":
+		return m.CrashNode(input.NodeID)
+	case "recover":
+		return m.RecoverNode(input.NodeID)
+	case "partition":
+		m.InjectPartition(input.From, input.To, input.Bidirectional)
+	case "heal":
+		m.HealPartition(input.From, input.To, input.Bidirectional)
+	case "partition_groups":
+		return m.PartitionGroups(input.Groups)
+	case "heal_all":
+		return m.HealAllPartitions()
+	case "delay":
+		return m.InjectDelay(input.NodeID, time.Duration(input.DelayMs)*time.Millisecond)
+	case "link_latency", "link_latency_reset":
+		if input.Link == nil {
+			return fmt.Errorf("link latency input without a link")
+		}
+		return m.SetLinkLatency(*input.Link, input.Kind == "link_latency_reset")
+	case "link_loss", "link_loss_reset":
+		if input.Loss == nil {
+			return fmt.Errorf("link loss input without a link")
+		}
+		return m.SetLinkPacketLoss(*input.Loss, input.Kind == "link_loss_reset")
+	case "link_bandwidth", "link_bandwidth_reset":
+		if input.Bandwidth == nil {
+			return fmt.Errorf("link bandwidth input without a link")
+		}
+		return m.SetLinkBandwidth(*input.Bandwidth, input.Kind == "link_bandwidth_reset")
+	case "fifo", "fifo_reset":
+		if input.FIFO == nil {
+			return fmt.Errorf("fifo input without a link")
+		}
+		return m.SetFIFO(*input.FIFO, input.Kind == "fifo_reset")
+	case "hold", "release":
+		return m.HoldMessages(input.Kind == "hold")
+	case "deliver_message", "drop_message":
+		m.mu.RLock()
+		defer m.mu.RUnlock()
+		return m.deliverHeld(input.Held, input.Kind == "drop_message")
+	case "drop_in_flight":
+		m.mu.RLock()
+		defer m.mu.RUnlock()
+		return m.dropFlight(input.Flight)
+	case "corruption":
+		return m.InjectCorruption(input.Probability, input.Corruptions)
+	case "tick_rate":
+		return m.SetNodeTickRate(input.NodeID, time.Duration(input.TickMs)*time.Millisecond)
+	case "time_dilation":
+		return m.SetTimeDilation(input.NodeID, input.Factor, time.Duration(input.DurationMs)*time.Millisecond)
+	case "step_event":
+		return m.StepEvent(input.Seq)
+	case "undo":
+		return m.UndoLastFailure()
+	case "client_request":
+		return m.HandleClientRequest(input.Command, input.Params)
+	case "node_action":
+		return m.InvokeNodeAction(input.NodeID, input.Command, input.Params)
+	default:
+		return fmt.Errorf("unknown checkpoint input: %s", input.Kind)
+	}
+	return nil
+}
+
+// mismatchedNodes lists the nodes whose restored state differs from the
+// saved one, or that exist on one side only
+func mismatchedNodes(saved, restored map[string]protocol.NodeState) []string {
+	mismatched := make([]string, 0)
+	for id, node := range saved {
+		other, ok := restored[id]
+		if !ok || canonicalJSON(node) != canonicalJSON(other) {
+			mismatched = append(mismatched, id)
+		}
+	}
+	for id := range restored {
+		if _, ok := saved[id]; !ok {
+			mismatched = append(mismatched, id)
+		}
+	}
+	sort.Strings(mismatched)
+	return mismatched
+}
+
+// sameFlights tells whether two runs have the same messages on their way.
+// Message IDs are not drawn from the seed, so they are left out.
+func sameFlights(saved, restored []protocol.InFlightMessage) bool {
+	if len(saved) != len(restored) {
+		return false
+	}
+	counts := make(map[string]int)
+	for _, msg := range saved {
+		msg.MessageID = ""
+		counts[canonicalJSON(msg)]++
+	}
+	for _, msg := range restored {
+		msg.MessageID = ""
+		key := canonicalJSON(msg)
+		if counts[key] == 0 {
+			return false
+		}
+		counts[key]--
+	}
+	return true
+}
+
+// canonicalJSON encodes v the way it reads back from JSON, so a value
+// compares equal to itself after a round trip through a client
+func canonicalJSON(v interface{}) string {
+	var decoded interface{}
+	if err := json.Unmarshal(toJSON(v), &decoded); err != nil {
+		return ""
+	}
+	return string(toJSON(decoded))
+}
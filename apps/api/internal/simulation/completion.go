@@ -0,0 +1,110 @@
+package simulation
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+)
+
+// maxCompletion bounds the virtual time of a run to completion
+const maxCompletion = time.Hour
+
+// completionRun is a run to completion under way: when it began, the
+// breakpoints that end it and those that hit
+type completionRun struct {
+	mu sync.Mutex
+
+	began   time.Time
+	elapsed time.Duration  // Virtual time it began at
+	counts  map[string]int // Events recorded before it began
+	until   []string       // IDs of the breakpoints armed to end it
+	hits    []protocol.BreakpointHitResponse
+}
+
+// record keeps the breakpoint hits that paused the run
+func (c *completionRun) record(hits []protocol.BreakpointHitResponse) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hits = append(c.hits, hits...)
+}
+
+// RunToCompletion runs the current simulation as fast as it goes, without
+// streaming it to the clients, until one of the until breakpoints or any
+// other one hits, the run is paused, or limit of virtual time has passed.
+// The clients then get the final state and what happened meanwhile.
+func (m *Manager) RunToCompletion(until []protocol.BreakpointSpec, limit time.Duration) error {
+	if limit == 0 {
+		limit = maxCompletion
+	}
+	if limit < 0 || limit > maxCompletion {
+		return fmt.Errorf("a run to completion must last between 0 and %s", maxCompletion)
+	}
+	if err := protocol.ValidateBreakpoints(until); err != nil {
+		return err
+	}
+	eng := m.GetEngine()
+	breakpoints := m.breakpoints.Load()
+	if eng == nil || breakpoints == nil {
+		return fmt.Errorf("no simulation running")
+	}
+
+	for i := range until {
+		until[i].Repeat = false
+	}
+	run := &completionRun{
+		began:   time.Now(),
+		elapsed: eng.Elapsed(),
+		counts:  m.run.Load().eventCounts(),
+		until:   breakpoints.add(until, false),
+	}
+	if !m.completion.CompareAndSwap(nil, run) {
+		breakpoints.remove(run.until)
+		return fmt.Errorf("already running to completion")
+	}
+	eng.RunToCompletion(limit, func(reason string) {
+		m.completeRun(run, reason)
+	})
+	return nil
+}
+
+// completeRun ends a run to completion: streaming resumes with the
+// compressed timeline of the run, then its final state
+func (m *Manager) completeRun(run *completionRun, reason string) {
+	if !m.completion.CompareAndSwap(run, nil) {
+		return // The simulation was restarted or stopped meanwhile
+	}
+	m.breakpoints.Load().remove(run.until)
+
+	run.mu.Lock()
+	hits := run.hits
+	run.mu.Unlock()
+	if len(hits) > 0 && reason == "interrupted" {
+		reason = "breakpoint"
+	}
+
+	counts := m.run.Load().eventCounts()
+	for eventType, before := range run.counts {
+		counts[eventType] -= before
+		if counts[eventType] == 0 {
+			delete(counts, eventType)
+		}
+	}
+	m.BroadcastMessage(&protocol.RunCompletedResponse{
+		Type:        protocol.MsgRunCompleted,
+		Reason:      reason,
+		Hits:        hits,
+		ElapsedMs:   (m.GetEngine().Elapsed() - run.elapsed).Milliseconds(),
+		WallMs:      time.Since(run.began).Milliseconds(),
+		KeyEvents:   m.run.Load().keyEventsSince(run.began.UnixMilli()),
+		EventCounts: counts,
+	})
+
+	m.mu.RLock()
+	m.broadcastState()
+	m.mu.RUnlock()
+}
@@ -0,0 +1,120 @@
+package simulation
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+	"github.com/ersantana/distributed-systems-learning/packages/simulation/engine"
+)
+
+// controlSchedule applies speed changes and pauses to a run when its
+// virtual time reaches them, so a recorded demo slows down exactly at the
+// interesting moment
+type controlSchedule struct {
+	mu sync.Mutex
+
+	engine  *engine.Engine
+	pending []protocol.ControlSpec // Sorted by time
+}
+
+func newControlSchedule(eng *engine.Engine, controls []protocol.ControlSpec) *controlSchedule {
+	cs := &controlSchedule{engine: eng}
+	cs.add(controls, false)
+	return cs
+}
+
+// add schedules controls, replacing those still pending if asked to
+func (cs *controlSchedule) add(controls []protocol.ControlSpec, replace bool) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if replace {
+		cs.pending = nil
+	}
+	cs.pending = append(cs.pending, controls...)
+	sort.SliceStable(cs.pending, func(i, j int) bool { return cs.pending[i].AtMs < cs.pending[j].AtMs })
+}
+
+// due removes and returns the controls whose time has come
+func (cs *controlSchedule) due(elapsedMs int64) []protocol.ControlSpec {
+	if cs == nil {
+		return nil
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	n := 0
+	for n < len(cs.pending) && cs.pending[n].AtMs <= elapsedMs {
+		n++
+	}
+	due := cs.pending[:n:n]
+	cs.pending = cs.pending[n:]
+	return due
+}
+
+// apply changes the engine as a control asks
+func (cs *controlSchedule) apply(control protocol.ControlSpec) {
+	switch control.Action {
+	case "speed":
+		cs.engine.SetSpeed(control.Speed)
+	case "pause":
+		cs.engine.Pause()
+	}
+}
+
+// applyControls applies the controls due at the virtual time of a tick
+func (m *Manager) applyControls(elapsedMs int64) {
+	controls := m.controls.Load()
+	for _, control := range controls.due(elapsedMs) {
+		controls.apply(control)
+		data := map[string]interface{}{
+			"action": control.Action,
+			"atMs":   control.AtMs,
+		}
+		if control.Action == "speed" {
+			data["speed"] = control.Speed
+		}
+		m.handleEvent("control_applied", data)
+	}
+}
+
+// ScheduleControls adds speed changes and pauses to the current run. Their
+// times count from its start, so a time already passed applies at once.
+func (m *Manager) ScheduleControls(controls []protocol.ControlSpec, replace bool) error {
+	if err := protocol.ValidateControls(controls); err != nil {
+		return err
+	}
+	schedule := m.controls.Load()
+	if schedule == nil {
+		return fmt.Errorf("no simulation running")
+	}
+	schedule.add(controls, replace)
+	return nil
+}
+
+// maxFastForward bounds a fast-forward, whose events all run before the
+// state is sent again
+const maxFastForward = 10 * time.Minute
+
+// FastForward runs the next stretch of virtual time at once
+func (m *Manager) FastForward(d time.Duration) error {
+	if d <= 0 || d > maxFastForward {
+		return fmt.Errorf("fast-forward must be between 0 and %s", maxFastForward)
+	}
+	m.mu.RLock()
+	eng := m.engine
+	m.mu.RUnlock()
+	if eng == nil {
+		return fmt.Errorf("no simulation running")
+	}
+
+	// Without the lock: the events run may need it
+	eng.FastForward(d)
+	m.mu.RLock()
+	m.broadcastState()
+	m.mu.RUnlock()
+	return nil
+}
@@ -0,0 +1,68 @@
+package simulation
+
+import (
+	"fmt"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+)
+
+// InjectCorruption makes a fraction of the messages arrive with their
+// payload corrupted in one of the given ways (none = any), so the run
+// shows what a protocol without checksums or validation makes of them.
+// A probability of 0 stops corrupting messages.
+func (m *Manager) InjectCorruption(probability float64, corruptions []string) error {
+	if probability < 0 || probability > 1 {
+		return fmt.Errorf("the probability of corruption must be between 0 and 1")
+	}
+	kinds := make([]transport.Corruption, 0, len(corruptions))
+	for _, c := range corruptions {
+		if !transport.ValidCorruption(transport.Corruption(c)) {
+			return fmt.Errorf("unknown corruption %q, want one of %v", c, transport.Corruptions)
+		}
+		kinds = append(kinds, transport.Corruption(c))
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.transport == nil || m.simulation == nil {
+		return fmt.Errorf("no simulation running")
+	}
+	m.transport.SetCorruption(probability, kinds...)
+	m.handleEvent("corruption_injected", map[string]interface{}{
+		"probability": probability,
+		"corruptions": corruptions,
+	})
+	m.recordInput(protocol.CheckpointInput{Kind: "corruption", Probability: probability, Corruptions: corruptions})
+	m.broadcastState()
+	return nil
+}
+
+// messageCorrupted reports a message corrupted on its way
+func (m *Manager) messageCorrupted(env *transport.Envelope, corruption transport.Corruption, detail string) {
+	msg := &protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageCorrupted,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+		Reason:      string(corruption),
+		Detail:      detail,
+	}
+	// In performance mode corruptions are only counted
+	if m.perf.Load().absorb(msg) {
+		m.run.Load().recordMessage(msg)
+		return
+	}
+
+	m.handleEvent("message_corrupted", map[string]interface{}{
+		"from":       env.From,
+		"to":         env.To,
+		"type":       string(env.Type),
+		"corruption": string(corruption),
+		"detail":     detail,
+	})
+	m.send(msg)
+}
@@ -0,0 +1,34 @@
+package simulation
+
+import (
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+	"github.com/ersantana/distributed-systems-learning/packages/simulation/engine"
+)
+
+// engineMetricsInterval is the wall time between two reports on how the
+// engine keeps up with the run
+const engineMetricsInterval = time.Second
+
+// engineMetrics turns the data of an "engine_metrics" event into the
+// message for the clients
+func engineMetrics(data map[string]interface{}) *protocol.EngineMetricsResponse {
+	msg := &protocol.EngineMetricsResponse{Type: protocol.MsgEngineMetrics}
+	msg.IntervalMs, _ = data["intervalMs"].(int64)
+	msg.Ticks, _ = data["ticks"].(int)
+	msg.TickAvgUs, _ = data["tickAvgUs"].(int64)
+	msg.TickMaxUs, _ = data["tickMaxUs"].(int64)
+	msg.LagMs, _ = data["lagMs"].(int64)
+	msg.MaxLagMs, _ = data["maxLagMs"].(int64)
+	msg.Backlog, _ = data["backlog"].(int)
+	msg.Speed, _ = data["speed"].(float64)
+	msg.Nodes, _ = data["nodes"].(int)
+
+	slowest, _ := data["slowestNodes"].([]engine.NodeTickTime)
+	msg.SlowestNodes = make([]protocol.NodeTickTime, len(slowest))
+	for i, node := range slowest {
+		msg.SlowestNodes[i] = protocol.NodeTickTime(node)
+	}
+	return msg
+}
@@ -0,0 +1,114 @@
+package simulation
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+	"github.com/ersantana/distributed-systems-learning/packages/simulation/engine"
+)
+
+// executionTraceVersion is the format of the execution traces this server
+// saves and replays
+const executionTraceVersion = 1
+
+// SaveExecutionTrace returns the trace of the current run
+func (m *Manager) SaveExecutionTrace() (*protocol.ExecutionTrace, error) {
+	eng := m.GetEngine()
+	inputs := m.checkpoint.Load()
+	if eng == nil || inputs == nil {
+		return nil, fmt.Errorf("no simulation running")
+	}
+
+	tr := &protocol.ExecutionTrace{
+		Version: executionTraceVersion,
+		SavedAt: time.Now().UnixMilli(),
+	}
+	// Between two events, so the inputs and the entries agree
+	eng.Inspect(func() {
+		tr.Request, tr.Inputs = inputs.snapshot()
+		var entries []engine.TraceEntry
+		entries, tr.Truncated = eng.Trace()
+		tr.Entries = make([]protocol.ExecutionTraceEntry, len(entries))
+		for i, entry := range entries {
+			tr.Entries[i] = protocol.ExecutionTraceEntry(entry)
+		}
+	})
+	return tr, nil
+}
+
+// ReplayExecutionTrace replaces the current run with the one traced: it
+// starts it again from its seed and runs it event by event, in the order
+// traced, making each input after as many events as it was made after, up
+// to the last event traced. The run is left paused there, and the first event that differs
+// from the trace, if any, is reported.
+func (m *Manager) ReplayExecutionTrace(tr protocol.ExecutionTrace) error {
+	if tr.Version != executionTraceVersion {
+		return fmt.Errorf("unsupported execution trace version %d", tr.Version)
+	}
+	if tr.Request.Config.Seed == 0 {
+		return fmt.Errorf("execution trace has no seed")
+	}
+	for i, input := range tr.Inputs {
+		if i > 0 && input.Events < tr.Inputs[i-1].Events {
+			return fmt.Errorf("execution trace input %d is out of order", i+1)
+		}
+	}
+
+	m.campaign.Store(nil)
+	request := tr.Request
+	if err := m.start(request.Project, request.Scenario, request, true); err != nil {
+		return err
+	}
+	eng := m.GetEngine()
+	expected := make([]engine.TraceEntry, len(tr.Entries))
+	for i, entry := range tr.Entries {
+		expected[i] = engine.TraceEntry(entry)
+	}
+	eng.ReplayTrace(expected)
+
+	// Inputs are applied without the lock: the events run may need it
+	failed := 0
+	for _, input := range tr.Inputs {
+		runTraced(eng, tr.Entries, input.Events)
+		if ahead := time.Duration(input.AtNs) - eng.Elapsed(); ahead > 0 {
+			eng.FastForward(ahead)
+		}
+		if err := m.applyInput(input); err != nil {
+			failed++
+		}
+	}
+	runTraced(eng, tr.Entries, uint64(len(tr.Entries)))
+
+	data := map[string]interface{}{
+		"events":       eng.Executed(),
+		"traced":       len(tr.Entries),
+		"inputs":       len(tr.Inputs),
+		"failedInputs": failed,
+		"diverged":     false,
+	}
+	if divergence := eng.Divergence(); divergence != nil {
+		data["diverged"] = true
+		data["divergence"] = divergence
+	}
+	m.handleEvent("execution_replayed", data)
+
+	m.mu.RLock()
+	m.broadcastState()
+	m.mu.RUnlock()
+	return nil
+}
+
+// runTraced runs events until the first n have, each the one traced at
+// its index: an event run ahead of others, e.g. by an exploration, is
+// again. Once the run diverges, events run in the order they are due.
+func runTraced(eng *engine.Engine, entries []protocol.ExecutionTraceEntry, n uint64) {
+	for i := eng.Executed(); i < n; i = eng.Executed() {
+		if i < uint64(len(entries)) && entries[i].Seq != 0 && eng.RunPending(entries[i].Seq) {
+			continue
+		}
+		if eng.RunEvents(1) == 0 {
+			return
+		}
+	}
+}
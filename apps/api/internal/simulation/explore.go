@@ -0,0 +1,189 @@
+package simulation
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+	"github.com/ersantana/distributed-systems-learning/packages/simulation/engine"
+)
+
+// Bounds and defaults of an exploration, which grows exponentially with
+// its depth
+const (
+	defaultExploreDepth = 12
+	maxExploreDepth     = 200
+	defaultExplorePaths = 2000
+	maxExplorePaths     = 50000
+)
+
+// exploration is an exploration under way
+type exploration struct {
+	campaign
+	request  protocol.StartSimulationRequest
+	depth    int
+	maxPaths int
+}
+
+// Explore enumerates, in the background, the interleavings of the events
+// of a small simulation up to depth events from its start. At each event
+// either the next one due runs or a message on its way is delivered at
+// once, as if the network had been faster. Every interleaving is checked
+// against the invariants after each event, and against those about the
+// outcome when it ends with no message on its way. The clients then get
+// an execution trace of the first interleaving violating each invariant,
+// the first of which is left loaded and paused. Starting or stopping a
+// simulation meanwhile ends the exploration.
+func (m *Manager) Explore(req protocol.ExploreRequest) error {
+	if req.Depth == 0 {
+		req.Depth = defaultExploreDepth
+	}
+	if req.MaxPaths == 0 {
+		req.MaxPaths = defaultExplorePaths
+	}
+	if req.Depth < 0 || req.Depth > maxExploreDepth {
+		return fmt.Errorf("an exploration must go between 1 and %d events deep", maxExploreDepth)
+	}
+	if req.MaxPaths < 0 || req.MaxPaths > maxExplorePaths {
+		return fmt.Errorf("an exploration must explore between 1 and %d interleavings", maxExplorePaths)
+	}
+	if req.Request.Project == "" {
+		return fmt.Errorf("an exploration needs a project")
+	}
+	if req.Request.Config.Seed == 0 {
+		req.Request.Config.Seed = engine.NewSeed()
+	}
+
+	x := &exploration{
+		campaign: campaign{kind: "exploring"},
+		request:  req.Request,
+		depth:    req.Depth,
+		maxPaths: req.MaxPaths,
+	}
+	if err := m.beginCampaign(&x.campaign); err != nil {
+		return err
+	}
+	// The first run starts at once, to fail a request that cannot
+	if err := m.start(x.request.Project, x.request.Scenario, x.request, true); err != nil {
+		m.campaign.CompareAndSwap(&x.campaign, nil)
+		return err
+	}
+	go m.runExploration(x)
+	return nil
+}
+
+// runExploration explores depth first, running each interleaving from the
+// start of the simulation: the first choice at each event is followed at
+// once, the others are left for later runs
+func (m *Manager) runExploration(x *exploration) {
+	began := time.Now()
+	report := &protocol.ExploreReportResponse{
+		Type:            protocol.MsgExploreReport,
+		Project:         x.request.Project,
+		Scenario:        x.request.Scenario,
+		Depth:           x.depth,
+		Counterexamples: make([]protocol.Counterexample, 0),
+	}
+	found := make(map[string]bool)
+
+	unexplored := [][]uint64{nil}
+	for len(unexplored) > 0 && report.Paths < x.maxPaths && m.campaign.Load() == &x.campaign {
+		prefix := unexplored[len(unexplored)-1]
+		unexplored = unexplored[:len(unexplored)-1]
+		if report.Paths > 0 {
+			if err := m.start(x.request.Project, x.request.Scenario, x.request, true); err != nil {
+				m.campaign.CompareAndSwap(&x.campaign, nil)
+				m.send(protocol.NewError("explore_error", err.Error()))
+				return
+			}
+		}
+		eng := m.GetEngine()
+		invariants := m.invariants.Load()
+
+		path := append([]uint64(nil), prefix...)
+		var violations []protocol.InvariantResult
+		for step := 0; step < x.depth && violations == nil; step++ {
+			if step >= len(path) {
+				choices := exploreChoices(eng.Pending())
+				if len(choices) == 0 {
+					break
+				}
+				for i := len(choices) - 1; i > 0; i-- {
+					unexplored = append(unexplored, append(append([]uint64(nil), path...), choices[i]))
+				}
+				path = append(path, choices[0])
+			}
+			if !eng.RunPending(path[step]) {
+				break
+			}
+			violations = invariants.violations(m.simulationState(), false)
+			for _, result := range engineInvariantResults(eng) {
+				if !result.Holds {
+					violations = append(violations, result)
+				}
+			}
+		}
+		if violations == nil && quiescent(eng.Pending()) {
+			violations = invariants.violations(m.simulationState(), true)
+		}
+		report.Paths++
+
+		for _, violation := range violations {
+			if found[violation.Name] {
+				continue
+			}
+			found[violation.Name] = true
+			if trace, err := m.SaveExecutionTrace(); err == nil {
+				report.Counterexamples = append(report.Counterexamples, protocol.Counterexample{Violation: violation, Trace: *trace})
+			}
+		}
+		m.mu.Lock()
+		m.summarizeRun()
+		m.mu.Unlock()
+	}
+	report.Exhausted = len(unexplored) == 0
+
+	if !m.campaign.CompareAndSwap(&x.campaign, nil) {
+		return // A simulation was started or stopped meanwhile
+	}
+	report.WallMs = time.Since(began).Milliseconds()
+	m.BroadcastMessage(report)
+
+	if len(report.Counterexamples) == 0 {
+		m.Stop()
+		return
+	}
+	if err := m.ReplayExecutionTrace(report.Counterexamples[0].Trace); err != nil {
+		m.send(protocol.NewError("explore_error", err.Error()))
+	}
+}
+
+// exploreChoices returns the events an exploration may run next: the
+// next one due, or any message delivery
+func exploreChoices(pending []engine.PendingEvent) []uint64 {
+	choices := make([]uint64, 0, len(pending))
+	for i, ev := range pending {
+		if i == 0 || strings.HasPrefix(ev.Label, "deliver ") {
+			choices = append(choices, ev.Seq)
+		}
+	}
+	return choices
+}
+
+// quiescent tells whether no message is on its way
+func quiescent(pending []engine.PendingEvent) bool {
+	for _, ev := range pending {
+		if strings.HasPrefix(ev.Label, "deliver ") {
+			return false
+		}
+	}
+	return true
+}
+
+// simulationState returns the state of the current project simulation
+func (m *Manager) simulationState() *protocol.SimulationStateResponse {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.simulation.GetState()
+}
@@ -0,0 +1,78 @@
+package simulation
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+	"github.com/ersantana/distributed-systems-learning/packages/simulation/engine"
+)
+
+// stateExportVersion is the format of the state exports this server
+// writes and reads
+const stateExportVersion = 1
+
+// ExportState captures the exact situation of the current run, to share
+func (m *Manager) ExportState() (*protocol.StateExport, error) {
+	// The checkpoint first: the run may go on meanwhile, and the import
+	// catches up to the engine's snapshot from there
+	cp, err := m.SaveCheckpoint()
+	if err != nil {
+		return nil, err
+	}
+	snapshot, err := m.GetEngine().Export()
+	if err != nil {
+		return nil, err
+	}
+	return &protocol.StateExport{
+		Version:    stateExportVersion,
+		Engine:     snapshot,
+		Checkpoint: *cp,
+		ExportedAt: time.Now().UnixMilli(),
+	}, nil
+}
+
+// ImportState replaces the current run with an exported one: its
+// checkpoint is loaded, the run brought to the engine's snapshot, and the
+// snapshot's mode and speed restored
+func (m *Manager) ImportState(export protocol.StateExport) (*protocol.StateImportResponse, error) {
+	if export.Version != stateExportVersion {
+		return nil, fmt.Errorf("unsupported state export version %d", export.Version)
+	}
+	var snapshot engine.Snapshot
+	if err := json.Unmarshal(export.Engine, &snapshot); err != nil {
+		return nil, fmt.Errorf("invalid engine snapshot: %w", err)
+	}
+	if snapshot.ElapsedNs < export.Checkpoint.ElapsedNs {
+		return nil, fmt.Errorf("the engine snapshot is older than the checkpoint")
+	}
+
+	if err := m.LoadCheckpoint(export.Checkpoint); err != nil {
+		return nil, err
+	}
+	eng := m.GetEngine()
+	if ahead := time.Duration(snapshot.ElapsedNs) - eng.Elapsed(); ahead > 0 {
+		eng.FastForward(ahead)
+	}
+	mismatched, err := eng.Import(export.Engine)
+	if err != nil {
+		return nil, err
+	}
+
+	imported := &protocol.StateImportResponse{
+		ElapsedMs:       time.Duration(snapshot.ElapsedNs).Milliseconds(),
+		Mode:            eng.GetMode().String(),
+		MismatchedNodes: append(make([]string, 0), mismatched...),
+	}
+	m.handleEvent("state_imported", map[string]interface{}{
+		"elapsedMs":       imported.ElapsedMs,
+		"mode":            imported.Mode,
+		"mismatchedNodes": imported.MismatchedNodes,
+	})
+
+	m.mu.RLock()
+	m.broadcastState()
+	m.mu.RUnlock()
+	return imported, nil
+}
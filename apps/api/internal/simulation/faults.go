@@ -0,0 +1,418 @@
+package simulation
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/failure/injector"
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+)
+
+// faultTarget adapts the manager to the injector's node and network managers
+type faultTarget struct {
+	manager *Manager
+}
+
+func (f *faultTarget) CrashNode(nodeID string) {
+	if sim := f.manager.currentSimulation(); sim != nil {
+		if err := sim.CrashNode(nodeID); err != nil {
+			log.Printf("Scheduled crash failed: %v", err)
+			return
+		}
+		f.manager.broadcastState()
+	}
+}
+
+func (f *faultTarget) RecoverNode(nodeID string) {
+	if sim := f.manager.currentSimulation(); sim != nil {
+		if err := sim.RecoverNode(nodeID); err != nil {
+			log.Printf("Scheduled recovery failed: %v", err)
+			return
+		}
+		f.manager.broadcastState()
+	}
+}
+
+func (f *faultTarget) SetNodeDelay(nodeID string, delay time.Duration) {
+	if t := f.manager.GetTransport(); t != nil {
+		t.SetNodeDelay(nodeID, delay)
+	}
+}
+
+func (f *faultTarget) ClearNodeDelay(nodeID string) {
+	if t := f.manager.GetTransport(); t != nil {
+		t.ClearNodeDelay(nodeID)
+	}
+}
+
+func (f *faultTarget) CreatePartition(from, to string) {
+	if t := f.manager.GetTransport(); t != nil {
+		t.SetPartition(from, to, true)
+	}
+}
+
+func (f *faultTarget) HealPartition(from, to string) {
+	if t := f.manager.GetTransport(); t != nil {
+		t.ClearPartition(from, to)
+	}
+}
+
+func (f *faultTarget) SetLatency(min, max time.Duration) {
+	if t := f.manager.GetTransport(); t != nil {
+		t.SetLatency(min, max)
+	}
+}
+
+// currentSimulation returns the running project simulation, if any
+func (m *Manager) currentSimulation() ProjectSimulation {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.simulation
+}
+
+// applyNetworkPreset configures the transport from a preset
+func (m *Manager) applyNetworkPreset(preset protocol.NetworkPreset) {
+	m.transport.SetLatencyDistribution(latencyDistribution(preset.MinLatencyMs, preset.MaxLatencyMs, preset.Distribution))
+	m.transport.SetPacketLoss(preset.PacketLoss)
+	for _, link := range preset.Links {
+		m.setLinkLatency(link)
+	}
+	for _, link := range preset.Losses {
+		m.setLinkPacketLoss(link)
+	}
+	m.transport.SetBandwidth(transport.Bandwidth{
+		BytesPerSecond: preset.BandwidthBytesPerSec,
+		QueueCapacity:  preset.QueueCapacity,
+	})
+	for _, link := range preset.Bandwidths {
+		m.setLinkBandwidth(link)
+	}
+	m.transport.SetFIFO(preset.FIFO)
+	for _, link := range preset.FIFOLinks {
+		m.setLinkFIFO(link)
+	}
+	m.transport.SetSerialization(transport.Serialization{
+		Enabled:   preset.Serialize,
+		CostPerKB: time.Duration(preset.SerializeCostPerKBMs * float64(time.Millisecond)),
+	})
+}
+
+// SetLinkLatency sets the latency of a link of the running simulation, or
+// with reset brings it back to the latency of the rest of the network
+func (m *Manager) SetLinkLatency(link protocol.LinkLatencySpec, reset bool) error {
+	if reset {
+		link.MinLatencyMs, link.MaxLatencyMs = 0, 0
+	}
+	if err := protocol.ValidateLinkLatencies([]protocol.LinkLatencySpec{link}); err != nil {
+		return err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.transport == nil || m.simulation == nil {
+		return fmt.Errorf("no simulation running")
+	}
+	nodes := m.simulation.GetNodes()
+	for _, nodeID := range []string{link.From, link.To} {
+		if _, ok := nodes[nodeID]; !ok {
+			return fmt.Errorf("unknown node: %s", nodeID)
+		}
+	}
+
+	kind := "link_latency"
+	if reset {
+		kind = "link_latency_reset"
+		m.transport.ClearLinkLatency(link.From, link.To)
+		if link.Bidirectional {
+			m.transport.ClearLinkLatency(link.To, link.From)
+		}
+	} else {
+		m.setLinkLatency(link)
+	}
+	m.handleEvent("link_latency_set", map[string]interface{}{
+		"from":          link.From,
+		"to":            link.To,
+		"minLatencyMs":  link.MinLatencyMs,
+		"maxLatencyMs":  link.MaxLatencyMs,
+		"bidirectional": link.Bidirectional,
+		"reset":         reset,
+	})
+	m.recordInput(protocol.CheckpointInput{Kind: kind, Link: &link})
+	m.broadcastState()
+	return nil
+}
+
+// setLinkLatency sets the latency of a link, both ways if it is
+// bidirectional
+func (m *Manager) setLinkLatency(link protocol.LinkLatencySpec) {
+	latency := latencyDistribution(link.MinLatencyMs, link.MaxLatencyMs, link.Distribution)
+	m.transport.SetLinkLatencyDistribution(link.From, link.To, latency)
+	if link.Bidirectional {
+		m.transport.SetLinkLatencyDistribution(link.To, link.From, latency)
+	}
+}
+
+// SetLinkPacketLoss sets the packet loss of a link of the running
+// simulation, one way unless bidirectional, or with reset brings it back to
+// the packet loss of the rest of the network
+func (m *Manager) SetLinkPacketLoss(link protocol.LinkPacketLossSpec, reset bool) error {
+	if reset {
+		link.PacketLoss = 0
+	}
+	if err := protocol.ValidateLinkPacketLosses([]protocol.LinkPacketLossSpec{link}); err != nil {
+		return err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.transport == nil || m.simulation == nil {
+		return fmt.Errorf("no simulation running")
+	}
+	nodes := m.simulation.GetNodes()
+	for _, nodeID := range []string{link.From, link.To} {
+		if _, ok := nodes[nodeID]; !ok {
+			return fmt.Errorf("unknown node: %s", nodeID)
+		}
+	}
+
+	kind := "link_loss"
+	if reset {
+		kind = "link_loss_reset"
+		m.transport.ClearLinkPacketLoss(link.From, link.To)
+		if link.Bidirectional {
+			m.transport.ClearLinkPacketLoss(link.To, link.From)
+		}
+	} else {
+		m.setLinkPacketLoss(link)
+	}
+	m.handleEvent("link_loss_set", map[string]interface{}{
+		"from":          link.From,
+		"to":            link.To,
+		"packetLoss":    link.PacketLoss,
+		"bidirectional": link.Bidirectional,
+		"reset":         reset,
+	})
+	m.recordInput(protocol.CheckpointInput{Kind: kind, Loss: &link})
+	m.broadcastState()
+	return nil
+}
+
+// setLinkPacketLoss sets the packet loss of a link, both ways if it is
+// bidirectional
+func (m *Manager) setLinkPacketLoss(link protocol.LinkPacketLossSpec) {
+	m.transport.SetLinkPacketLoss(link.From, link.To, link.PacketLoss)
+	if link.Bidirectional {
+		m.transport.SetLinkPacketLoss(link.To, link.From, link.PacketLoss)
+	}
+}
+
+// SetLinkBandwidth sets the bandwidth of a link of the running simulation,
+// or with reset brings it back to the bandwidth of the rest of the network
+func (m *Manager) SetLinkBandwidth(link protocol.LinkBandwidthSpec, reset bool) error {
+	if reset {
+		link.BytesPerSec, link.QueueCapacity = 0, 0
+	}
+	if err := protocol.ValidateLinkBandwidths([]protocol.LinkBandwidthSpec{link}); err != nil {
+		return err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.transport == nil || m.simulation == nil {
+		return fmt.Errorf("no simulation running")
+	}
+	nodes := m.simulation.GetNodes()
+	for _, nodeID := range []string{link.From, link.To} {
+		if _, ok := nodes[nodeID]; !ok {
+			return fmt.Errorf("unknown node: %s", nodeID)
+		}
+	}
+
+	kind := "link_bandwidth"
+	if reset {
+		kind = "link_bandwidth_reset"
+		m.transport.ClearLinkBandwidth(link.From, link.To)
+		if link.Bidirectional {
+			m.transport.ClearLinkBandwidth(link.To, link.From)
+		}
+	} else {
+		m.setLinkBandwidth(link)
+	}
+	m.handleEvent("link_bandwidth_set", map[string]interface{}{
+		"from":          link.From,
+		"to":            link.To,
+		"bytesPerSec":   link.BytesPerSec,
+		"queueCapacity": link.QueueCapacity,
+		"bidirectional": link.Bidirectional,
+		"reset":         reset,
+	})
+	m.recordInput(protocol.CheckpointInput{Kind: kind, Bandwidth: &link})
+	m.broadcastState()
+	return nil
+}
+
+// setLinkBandwidth sets the bandwidth of a link, both ways if it is
+// bidirectional
+func (m *Manager) setLinkBandwidth(link protocol.LinkBandwidthSpec) {
+	bandwidth := transport.Bandwidth{BytesPerSecond: link.BytesPerSec, QueueCapacity: link.QueueCapacity}
+	m.transport.SetLinkBandwidth(link.From, link.To, bandwidth)
+	if link.Bidirectional {
+		m.transport.SetLinkBandwidth(link.To, link.From, bandwidth)
+	}
+}
+
+// SetFIFO sets whether a link of the running simulation delivers messages
+// in the order they were sent, or every link without From and To. With
+// reset the link is brought back to the ordering of the rest of the
+// network.
+func (m *Manager) SetFIFO(link protocol.LinkFIFOSpec, reset bool) error {
+	everyLink := link.From == "" && link.To == ""
+	if everyLink && reset {
+		return fmt.Errorf("a link is required to reset")
+	}
+	if !everyLink {
+		if err := protocol.ValidateLinkFIFOs([]protocol.LinkFIFOSpec{link}); err != nil {
+			return err
+		}
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.transport == nil || m.simulation == nil {
+		return fmt.Errorf("no simulation running")
+	}
+	if !everyLink {
+		nodes := m.simulation.GetNodes()
+		for _, nodeID := range []string{link.From, link.To} {
+			if _, ok := nodes[nodeID]; !ok {
+				return fmt.Errorf("unknown node: %s", nodeID)
+			}
+		}
+	}
+
+	kind := "fifo"
+	switch {
+	case everyLink:
+		m.transport.SetFIFO(link.FIFO)
+	case reset:
+		kind = "fifo_reset"
+		m.transport.ClearLinkFIFO(link.From, link.To)
+		if link.Bidirectional {
+			m.transport.ClearLinkFIFO(link.To, link.From)
+		}
+	default:
+		m.setLinkFIFO(link)
+	}
+	m.handleEvent("fifo_set", map[string]interface{}{
+		"from":          link.From,
+		"to":            link.To,
+		"fifo":          link.FIFO,
+		"bidirectional": link.Bidirectional,
+		"reset":         reset,
+	})
+	m.recordInput(protocol.CheckpointInput{Kind: kind, FIFO: &link})
+	m.broadcastState()
+	return nil
+}
+
+// setLinkFIFO sets the ordering of a link, both ways if it is
+// bidirectional
+func (m *Manager) setLinkFIFO(link protocol.LinkFIFOSpec) {
+	m.transport.SetLinkFIFO(link.From, link.To, link.FIFO)
+	if link.Bidirectional {
+		m.transport.SetLinkFIFO(link.To, link.From, link.FIFO)
+	}
+}
+
+// latencyDistribution returns the distribution latencies are drawn from,
+// bounded by a range of milliseconds
+func latencyDistribution(minMs, maxMs int64, spec *protocol.LatencyDistributionSpec) transport.LatencyDistribution {
+	min := time.Duration(minMs) * time.Millisecond
+	max := time.Duration(maxMs) * time.Millisecond
+	if spec == nil {
+		return transport.Uniform{Min: min, Max: max}
+	}
+	switch spec.Kind {
+	case "normal":
+		return transport.Normal{
+			Mean:   time.Duration(spec.MeanMs) * time.Millisecond,
+			StdDev: time.Duration(spec.StdDevMs) * time.Millisecond,
+			Min:    min,
+			Max:    max,
+		}
+	case "lognormal":
+		return transport.LogNormal{Median: time.Duration(spec.MedianMs) * time.Millisecond, Sigma: spec.Sigma, Min: min, Max: max}
+	case "pareto":
+		return transport.Pareto{Scale: min, Shape: spec.Shape, Max: max}
+	}
+	return transport.Uniform{Min: min, Max: max}
+}
+
+// latencySpec describes a distribution latencies are drawn from, for the
+// clients
+func latencySpec(latency transport.LatencyDistribution) (minMs, maxMs int64, spec *protocol.LatencyDistributionSpec) {
+	min, max := latency.Bounds()
+	switch d := latency.(type) {
+	case transport.Normal:
+		spec = &protocol.LatencyDistributionSpec{Kind: "normal", MeanMs: d.Mean.Milliseconds(), StdDevMs: d.StdDev.Milliseconds()}
+	case transport.LogNormal:
+		spec = &protocol.LatencyDistributionSpec{Kind: "lognormal", MedianMs: d.Median.Milliseconds(), Sigma: d.Sigma}
+	case transport.Pareto:
+		spec = &protocol.LatencyDistributionSpec{Kind: "pareto", Shape: d.Shape}
+	}
+	return min.Milliseconds(), max.Milliseconds(), spec
+}
+
+// scheduleFaults hands a fault schedule to a fresh injector
+func (m *Manager) scheduleFaults(faults []protocol.FaultSpec) {
+	if len(faults) == 0 {
+		return
+	}
+
+	target := &faultTarget{manager: m}
+	inj := injector.NewInjector(target, target, &eventEmitter{manager: m})
+	// Faults happen in virtual time, so that replaying the run replays them
+	inj.SetScheduler(m.GetEngine())
+	inj.Start()
+
+	for i, spec := range faults {
+		failure := &injector.Failure{
+			ID:        fmt.Sprintf("scheduled-%d", i+1),
+			StartTime: time.Duration(spec.AtMs) * time.Millisecond,
+			Duration:  time.Duration(spec.DurationMs) * time.Millisecond,
+		}
+		switch spec.Type {
+		case "crash":
+			failure.Type = injector.FailureCrash
+			failure.Target = spec.Target
+		case "partition":
+			failure.Type = injector.FailurePartition
+			failure.Target = spec.From + ":" + spec.To
+			failure.Params = map[string]interface{}{
+				"from":          spec.From,
+				"to":            spec.To,
+				"bidirectional": spec.Bidirectional,
+			}
+		case "delay":
+			failure.Type = injector.FailureDelay
+			failure.Target = spec.Target
+			failure.Params = map[string]interface{}{
+				"delay": time.Duration(spec.DelayMs) * time.Millisecond,
+			}
+		default:
+			log.Printf("Ignoring unknown fault type: %s", spec.Type)
+			continue
+		}
+		inj.ScheduleFailure(failure)
+	}
+
+	m.mu.Lock()
+	m.injector = inj
+	m.mu.Unlock()
+}
@@ -0,0 +1,151 @@
+package simulation
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+	"github.com/ersantana/distributed-systems-learning/packages/simulation/engine"
+)
+
+// Bounds and defaults of a fuzzing campaign
+const (
+	defaultFuzzRuns   = 50
+	maxFuzzRuns       = 1000
+	defaultFuzzRunMs  = 30 * 1000
+	maxFuzzRun        = 10 * time.Minute
+	defaultFuzzWindow = 50
+)
+
+// campaign is a batch of runs made in the background, one at a time
+type campaign struct {
+	kind string // "fuzzing" or "exploring"
+}
+
+// beginCampaign makes c the campaign under way, unless there is one
+func (m *Manager) beginCampaign(c *campaign) error {
+	if !m.campaign.CompareAndSwap(nil, c) {
+		return fmt.Errorf("a campaign is already under way")
+	}
+	return nil
+}
+
+// fuzzCampaign is a fuzzing campaign under way
+type fuzzCampaign struct {
+	campaign
+	request  protocol.StartSimulationRequest
+	runs     int
+	run      time.Duration
+	windowMs int64
+}
+
+// Fuzz runs a simulation again and again in the background, as fast as it
+// goes, each time from the next seed with the schedule fuzzed, and checks
+// every run against the invariants. The clients then get the runs that
+// violated one, the first of which is left loaded and paused. Starting or
+// stopping a simulation meanwhile ends the campaign.
+func (m *Manager) Fuzz(req protocol.FuzzRequest) error {
+	if req.Runs == 0 {
+		req.Runs = defaultFuzzRuns
+	}
+	if req.RunMs == 0 {
+		req.RunMs = defaultFuzzRunMs
+	}
+	if req.WindowMs == 0 {
+		req.WindowMs = req.Request.Config.FuzzWindowMs
+	}
+	if req.WindowMs == 0 {
+		req.WindowMs = defaultFuzzWindow
+	}
+	run := time.Duration(req.RunMs) * time.Millisecond
+	if req.Runs < 0 || req.Runs > maxFuzzRuns {
+		return fmt.Errorf("a fuzzing campaign must make between 1 and %d runs", maxFuzzRuns)
+	}
+	if run < 0 || run > maxFuzzRun {
+		return fmt.Errorf("a fuzzed run must last between 0 and %s", maxFuzzRun)
+	}
+	if req.WindowMs < 0 {
+		return fmt.Errorf("the fuzz window must not be negative")
+	}
+	if err := protocol.ValidateInvariants(req.Request.Invariants); err != nil {
+		return err
+	}
+	if req.Request.Project == "" {
+		return fmt.Errorf("a fuzzing campaign needs a project")
+	}
+
+	c := &fuzzCampaign{
+		campaign: campaign{kind: "fuzzing"},
+		request:  req.Request,
+		runs:     req.Runs,
+		run:      run,
+		windowMs: req.WindowMs,
+	}
+	if c.request.Config.Seed == 0 {
+		c.request.Config.Seed = engine.NewSeed()
+	}
+	if err := m.beginCampaign(&c.campaign); err != nil {
+		return err
+	}
+	go m.runFuzz(c)
+	return nil
+}
+
+// runFuzz makes the runs of a fuzzing campaign, then reports on them
+func (m *Manager) runFuzz(c *fuzzCampaign) {
+	began := time.Now()
+	report := &protocol.FuzzReportResponse{
+		Type:     protocol.MsgFuzzReport,
+		Project:  c.request.Project,
+		Scenario: c.request.Scenario,
+		WindowMs: c.windowMs,
+		Runs:     c.runs,
+		Failing:  make([]protocol.FuzzRun, 0),
+	}
+
+	for i := 0; i < c.runs && m.campaign.Load() == &c.campaign; i++ {
+		request := c.request
+		request.Config.Seed += int64(i)
+		request.Config.FuzzWindowMs = c.windowMs
+		if err := m.start(request.Project, request.Scenario, request, true); err != nil {
+			m.campaign.CompareAndSwap(&c.campaign, nil)
+			m.send(protocol.NewError("fuzz_error", err.Error()))
+			return
+		}
+		if c.run > 0 {
+			m.GetEngine().FastForward(c.run)
+		}
+
+		var violations []protocol.InvariantResult
+		m.mu.Lock()
+		if summary := m.summarizeRun(); summary != nil && m.campaign.Load() == &c.campaign {
+			for _, result := range summary.Invariants {
+				if !result.Holds {
+					violations = append(violations, result)
+				}
+			}
+		}
+		m.mu.Unlock()
+		if violations != nil {
+			report.Failing = append(report.Failing, protocol.FuzzRun{Seed: request.Config.Seed, Violations: violations})
+		}
+		report.Completed++
+	}
+
+	if !m.campaign.CompareAndSwap(&c.campaign, nil) {
+		return // A simulation was started or stopped meanwhile
+	}
+	report.WallMs = time.Since(began).Milliseconds()
+	m.BroadcastMessage(report)
+
+	if len(report.Failing) == 0 {
+		m.Stop()
+		return
+	}
+	request := c.request
+	request.Config.Seed = report.Failing[0].Seed
+	request.Config.FuzzWindowMs = c.windowMs
+	if err := m.start(request.Project, request.Scenario, request, true); err != nil {
+		m.send(protocol.NewError("fuzz_error", err.Error()))
+	}
+}
@@ -0,0 +1,61 @@
+package simulation
+
+import (
+	"fmt"
+
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+)
+
+// PartitionGroups splits the cluster into named sides that cannot reach
+// one another, e.g. a majority and a minority, in one action instead of a
+// partition per pair of nodes
+func (m *Manager) PartitionGroups(groups []protocol.PartitionGroup) error {
+	if err := protocol.ValidatePartitionGroups(groups); err != nil {
+		return err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.transport == nil || m.simulation == nil {
+		return fmt.Errorf("no simulation running")
+	}
+	nodes := m.simulation.GetNodes()
+	sides := make([][]string, len(groups))
+	for i, g := range groups {
+		for _, nodeID := range g.Nodes {
+			if _, ok := nodes[nodeID]; !ok {
+				return fmt.Errorf("unknown node: %s", nodeID)
+			}
+		}
+		sides[i] = g.Nodes
+	}
+
+	m.transport.PartitionGroups(sides)
+	m.handleEvent("cluster_partitioned", map[string]interface{}{
+		"groups": groups,
+	})
+	m.failures.Load().push(manualFault{kind: "groups", groups: sides})
+	m.recordInput(protocol.CheckpointInput{Kind: "partition_groups", Groups: groups})
+	m.broadcastState()
+	return nil
+}
+
+// HealAllPartitions heals every partition at once, pairwise or between
+// groups
+func (m *Manager) HealAllPartitions() error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.transport == nil || m.simulation == nil {
+		return fmt.Errorf("no simulation running")
+	}
+	m.transport.ClearAllPartitions()
+	m.handleEvent("partitions_healed", map[string]interface{}{})
+	m.failures.Load().forget(func(f manualFault) bool {
+		return f.kind == "partition" || f.kind == "groups"
+	})
+	m.recordInput(protocol.CheckpointInput{Kind: "heal_all"})
+	m.broadcastState()
+	return nil
+}
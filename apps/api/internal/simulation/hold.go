@@ -0,0 +1,158 @@
+package simulation
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+)
+
+// HoldMessages makes the network hold back every message sent until the
+// client delivers or drops it, one at a time and in any order, so the run
+// takes the interleaving the client picks. Turning it off releases those
+// held, in the order they were sent.
+func (m *Manager) HoldMessages(hold bool) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.transport == nil || m.simulation == nil {
+		return fmt.Errorf("no simulation running")
+	}
+	released := 0
+	if !hold {
+		released = len(m.transport.Held())
+	}
+	m.transport.SetHold(hold)
+
+	kind := "hold"
+	if !hold {
+		kind = "release"
+	}
+	m.handleEvent("messages_held", map[string]interface{}{
+		"hold":     hold,
+		"released": released,
+	})
+	m.recordInput(protocol.CheckpointInput{Kind: kind})
+	m.broadcastState()
+	return nil
+}
+
+// DeliverMessage delivers a held message at once, ahead of those held
+// before it, or with drop loses it. An empty messageID is the oldest held.
+func (m *Manager) DeliverMessage(messageID string, drop bool) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.transport == nil || m.simulation == nil {
+		return fmt.Errorf("no simulation running")
+	}
+	held := m.transport.Held()
+	if len(held) == 0 {
+		return fmt.Errorf("no message is held")
+	}
+	if messageID == "" {
+		return m.deliverHeld(1, drop)
+	}
+	for i, h := range held {
+		if h.Envelope.ID == messageID {
+			return m.deliverHeld(i+1, drop)
+		}
+	}
+	return fmt.Errorf("message %s is not held", messageID)
+}
+
+// deliverHeld delivers or drops the message held at a position (1 = the
+// oldest), which replays the same choice in a restored run, where the
+// messages have other IDs (must hold m.mu)
+func (m *Manager) deliverHeld(position int, drop bool) error {
+	held := m.transport.Held()
+	if position < 1 || position > len(held) {
+		return fmt.Errorf("no message is held at position %d", position)
+	}
+	env := held[position-1].Envelope
+
+	kind := "deliver_message"
+	if drop {
+		kind = "drop_message"
+	}
+	// The message is delivered on the scheduler, after what is recorded
+	m.recordInput(protocol.CheckpointInput{Kind: kind, Held: position})
+	if drop {
+		m.transport.DropHeld(env.ID)
+	} else {
+		m.transport.Release(env.ID)
+	}
+	m.broadcastState()
+	return nil
+}
+
+// DropMessage drops a message on its way, held back or not, as if the
+// network lost it, e.g. exactly the commit to one node
+func (m *Manager) DropMessage(messageID string) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.transport == nil || m.simulation == nil {
+		return fmt.Errorf("no simulation running")
+	}
+	for i, h := range m.transport.Held() {
+		if h.Envelope.ID == messageID {
+			return m.deliverHeld(i+1, true)
+		}
+	}
+	for i, flight := range m.transport.InFlightMessages() {
+		if flight.Envelope.ID == messageID {
+			return m.dropFlight(i + 1)
+		}
+	}
+	return fmt.Errorf("message %s is not on its way", messageID)
+}
+
+// dropFlight drops the message on its way at a position, in the order
+// they are due (1 = the first), which replays the same choice in a
+// restored run (must hold m.mu)
+func (m *Manager) dropFlight(position int) error {
+	flights := m.transport.InFlightMessages()
+	if position < 1 || position > len(flights) {
+		return fmt.Errorf("no message is on its way at position %d", position)
+	}
+	m.recordInput(protocol.CheckpointInput{Kind: "drop_in_flight", Flight: position})
+	m.transport.DropMessage(flights[position-1].Envelope.ID)
+	m.broadcastState()
+	return nil
+}
+
+// messageHeld reports a message the network holds back. It is never
+// aggregated in performance mode: the client needs it to release it.
+func (m *Manager) messageHeld(env *transport.Envelope) {
+	m.send(&protocol.MessageEventResponse{
+		Type:        protocol.MsgMessageHeld,
+		MessageID:   env.ID,
+		From:        env.From,
+		To:          env.To,
+		MessageType: string(env.Type),
+		Payload:     env.Payload,
+	})
+}
+
+// heldMessages lists the messages the network holds back, for the
+// clients, at a virtual time now elapsed since the start of the run
+func heldMessages(t *transport.NetworkTransport, elapsed time.Duration, now time.Time) []protocol.HeldMessage {
+	held := t.Held()
+	if len(held) == 0 {
+		return nil
+	}
+	messages := make([]protocol.HeldMessage, len(held))
+	for i, h := range held {
+		messages[i] = protocol.HeldMessage{
+			MessageID:   h.Envelope.ID,
+			From:        h.Envelope.From,
+			To:          h.Envelope.To,
+			MessageType: string(h.Envelope.Type),
+			Payload:     h.Envelope.Payload,
+			HeldAtNs:    int64(elapsed + h.HeldAt.Sub(now)),
+		}
+	}
+	return messages
+}
@@ -0,0 +1,429 @@
+package simulation
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+	"github.com/ersantana/distributed-systems-learning/packages/simulation/engine"
+)
+
+// invariantCheckTicks is how often the invariants of a run are checked
+const invariantCheckTicks = 10
+
+// Invariant is a property of a project checked against its state while it
+// runs. Check reports whether the property holds and, either way, what it
+// saw.
+type Invariant struct {
+	Name string
+	// AtEnd invariants are about the outcome of a run, which may well be
+	// wrong halfway through: they are only judged when the run ends. The
+	// others must hold at every check.
+	AtEnd bool
+	Check func(state *protocol.SimulationStateResponse) (holds bool, detail string)
+}
+
+// defaultInvariants returns the invariants every run of a project is
+// checked against, so that a run gives correctness feedback without any
+// being registered. They are built anew for each run, as some remember
+// the states they saw.
+//
+// Two-phase commit gets none yet: it has no simulation and runs as a
+// demo, whose nodes decide nothing, so noMixedCommitAbort would pass
+// whatever happened. It is to be registered here once one lands.
+func defaultInvariants(project string) []Invariant {
+	switch project {
+	case "two-generals":
+		return []Invariant{noUnilateralAttack()}
+	case "byzantine":
+		return []Invariant{honestAgreement()}
+	case "clocks":
+		return []Invariant{vectorClocksMonotone()}
+	}
+	return nil
+}
+
+// invariantMonitor checks the invariants of a run
+type invariantMonitor struct {
+	mu sync.Mutex
+
+	sim        ProjectSimulation
+	invariants []Invariant
+	violated   map[string]string // First violation seen by each invariant
+	ticks      int
+}
+
+func newInvariantMonitor(sim ProjectSimulation, invariants []Invariant) *invariantMonitor {
+	return &invariantMonitor{
+		sim:        sim,
+		invariants: invariants,
+		violated:   make(map[string]string),
+	}
+}
+
+// register adds an invariant to the run
+func (im *invariantMonitor) register(invariant Invariant) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	im.invariants = append(im.invariants, invariant)
+}
+
+// tick checks the invariants every invariantCheckTicks ticks and returns
+// the violations not seen before, as events for the clients
+func (im *invariantMonitor) tick() []map[string]interface{} {
+	if im == nil {
+		return nil
+	}
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	im.ticks++
+	if im.ticks%invariantCheckTicks != 0 || len(im.invariants) == 0 {
+		return nil
+	}
+
+	state := im.sim.GetState()
+	violations := make([]map[string]interface{}, 0)
+	for _, invariant := range im.invariants {
+		if invariant.AtEnd {
+			continue
+		}
+		holds, detail := invariant.Check(state)
+		if holds {
+			continue
+		}
+		if _, seen := im.violated[invariant.Name]; seen {
+			continue
+		}
+		im.violated[invariant.Name] = fmt.Sprintf("tick %d: %s", im.ticks, detail)
+		violations = append(violations, map[string]interface{}{
+			"type":      "invariant_violated",
+			"invariant": invariant.Name,
+			"detail":    detail,
+			"tick":      im.ticks,
+		})
+	}
+	return violations
+}
+
+// results judges every invariant at the end of the run: an invariant
+// violated on the way does not hold, whatever the final state
+func (im *invariantMonitor) results(state *protocol.SimulationStateResponse) []protocol.InvariantResult {
+	if im == nil {
+		return nil
+	}
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	results := make([]protocol.InvariantResult, 0, len(im.invariants))
+	for _, invariant := range im.invariants {
+		holds, detail := invariant.Check(state)
+		if violation, seen := im.violated[invariant.Name]; seen && !invariant.AtEnd {
+			holds = false
+			detail = "violated at " + violation
+		}
+		results = append(results, protocol.InvariantResult{Name: invariant.Name, Holds: holds, Detail: detail})
+	}
+	return results
+}
+
+// violations checks the invariants against a state at once, those about
+// the outcome of the run too if it ended, and returns those that do not
+// hold
+func (im *invariantMonitor) violations(state *protocol.SimulationStateResponse, ended bool) []protocol.InvariantResult {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	var violations []protocol.InvariantResult
+	for _, invariant := range im.invariants {
+		if invariant.AtEnd && !ended {
+			continue
+		}
+		if holds, detail := invariant.Check(state); !holds {
+			violations = append(violations, protocol.InvariantResult{Name: invariant.Name, Detail: detail})
+		}
+	}
+	return violations
+}
+
+// engineInvariantResults judges the invariants the project checks after
+// every tick, registered with the engine
+func engineInvariantResults(eng *engine.Engine) []protocol.InvariantResult {
+	if eng == nil {
+		return nil
+	}
+	var results []protocol.InvariantResult
+	for _, result := range eng.InvariantResults() {
+		detail := "held at every tick"
+		if result.Violations > 0 {
+			detail = fmt.Sprintf("violated at %s: %s", time.Duration(result.AtNs), result.Detail)
+		}
+		if result.Violations > 1 {
+			detail += fmt.Sprintf(", and %d times since", result.Violations-1)
+		}
+		results = append(results, protocol.InvariantResult{Name: result.Name, Holds: result.Violations == 0, Detail: detail})
+	}
+	return results
+}
+
+// noUnilateralAttack: the two generals attack together or not at all. A
+// general attacks with the decision it holds when the run ends, unless it
+// crashed.
+func noUnilateralAttack() Invariant {
+	return Invariant{
+		Name:  "no unilateral attack",
+		AtEnd: true,
+		Check: func(state *protocol.SimulationStateResponse) (bool, string) {
+			attacking := make([]string, 0)
+			for id, node := range state.Nodes {
+				if node.Status == "running" && node.CustomState["decision"] == "attack" {
+					attacking = append(attacking, id)
+				}
+			}
+			sort.Strings(attacking)
+			switch len(attacking) {
+			case 0:
+				return true, "no general attacks"
+			case len(state.Nodes):
+				return true, "both generals attack"
+			}
+			return false, fmt.Sprintf("only %s attacks", strings.Join(attacking, ", "))
+		},
+	}
+}
+
+// noMixedCommitAbort: no participant commits the transaction while another
+// aborts it, going by the "decision" ("commit" or "abort") the nodes of a
+// two-phase commit report. It must hold at every check: a decision is
+// never taken back.
+func noMixedCommitAbort() Invariant {
+	return Invariant{
+		Name: "no mixed commit/abort",
+		Check: func(state *protocol.SimulationStateResponse) (bool, string) {
+			decided := map[string][]string{"commit": {}, "abort": {}}
+			for id, node := range state.Nodes {
+				if decision, _ := node.CustomState["decision"].(string); decided[decision] != nil {
+					decided[decision] = append(decided[decision], id)
+				}
+			}
+			committed, aborted := decided["commit"], decided["abort"]
+			sort.Strings(committed)
+			sort.Strings(aborted)
+			switch {
+			case len(committed) > 0 && len(aborted) > 0:
+				return false, fmt.Sprintf("%s committed but %s aborted", strings.Join(committed, ", "), strings.Join(aborted, ", "))
+			case len(committed) > 0:
+				return true, fmt.Sprintf("%d participant(s) committed", len(committed))
+			case len(aborted) > 0:
+				return true, fmt.Sprintf("%d participant(s) aborted", len(aborted))
+			}
+			return true, "no participant decided"
+		},
+	}
+}
+
+// honestAgreement: the honest generals that decide all decide the same
+// as long as fewer than a third of the generals are traitors. With n <=
+// 3f nothing is promised, and the detail tells whether they were lucky.
+func honestAgreement() Invariant {
+	return Invariant{
+		Name:  "honest agreement when n > 3f",
+		AtEnd: true,
+		Check: func(state *protocol.SimulationStateResponse) (bool, string) {
+			n, f := len(state.Nodes), 0
+			decisions := make(map[string]int)
+			for _, node := range state.Nodes {
+				if node.CustomState["behavior"] != "honest" {
+					f++
+					continue
+				}
+				if decision, _ := node.CustomState["decision"].(string); decision != "" {
+					decisions[decision]++
+				}
+			}
+			agreed := len(decisions) <= 1
+			outcome := "the honest generals agree"
+			if !agreed {
+				outcome = "the honest generals disagree: " + countList(decisions)
+			}
+			if n > 3*f {
+				return agreed, fmt.Sprintf("n=%d > 3f=%d: %s", n, 3*f, outcome)
+			}
+			return true, fmt.Sprintf("n=%d <= 3f=%d, agreement is not guaranteed: %s", n, 3*f, outcome)
+		},
+	}
+}
+
+// vectorClocksMonotone: no entry of a node's vector clock ever goes back
+func vectorClocksMonotone() Invariant {
+	last := make(map[string]map[string]uint64)
+	return Invariant{
+		Name: "vector clocks monotone",
+		Check: func(state *protocol.SimulationStateResponse) (bool, string) {
+			ids := make([]string, 0, len(state.Nodes))
+			for id := range state.Nodes {
+				ids = append(ids, id)
+			}
+			sort.Strings(ids)
+
+			backwards := ""
+			for _, id := range ids {
+				clock := state.Nodes[id].Clock
+				for entry, before := range last[id] {
+					if clock[entry] < before && backwards == "" {
+						backwards = fmt.Sprintf("%s's entry for %s went from %d to %d", id, entry, before, clock[entry])
+					}
+				}
+				last[id] = make(map[string]uint64, len(clock))
+				for entry, value := range clock {
+					last[id][entry] = value
+				}
+			}
+			if backwards != "" {
+				return false, backwards
+			}
+			return true, fmt.Sprintf("%d clocks only moved forward", len(ids))
+		},
+	}
+}
+
+// invariantFromSpec builds an invariant the user defined
+func invariantFromSpec(spec protocol.InvariantSpec) Invariant {
+	var invariant Invariant
+	switch spec.Kind {
+	case "unique_role":
+		invariant = uniqueRole(spec.Role, spec.Per)
+	case "agreement":
+		invariant = agreement(spec.Field)
+	case "expect":
+		invariant = expectation(spec.Node, spec.Field, spec.Equals)
+	}
+	if spec.Name != "" {
+		invariant.Name = spec.Name
+	}
+	return invariant
+}
+
+// uniqueRole: at most one node holds the role at a time or, with per, at
+// most one ever does for each value of that field. The first holder of
+// each value is remembered across checks, so two leaders of the same term
+// are caught even when they never lead at once.
+func uniqueRole(role, per string) Invariant {
+	name := "one " + role + " at a time"
+	if per != "" {
+		name = "one " + role + " per " + per
+	}
+	holders := make(map[string]string)
+	return Invariant{
+		Name: name,
+		Check: func(state *protocol.SimulationStateResponse) (bool, string) {
+			ids := make([]string, 0, len(state.Nodes))
+			for id, node := range state.Nodes {
+				if node.Role == role && node.Status != "crashed" {
+					ids = append(ids, id)
+				}
+			}
+			sort.Strings(ids)
+
+			if per == "" {
+				if len(ids) > 1 {
+					return false, fmt.Sprintf("%s are all %s", strings.Join(ids, ", "), role)
+				}
+				return true, fmt.Sprintf("%d %s", len(ids), role)
+			}
+			for _, id := range ids {
+				value, ok := fieldOf(state.Nodes[id], per)
+				if !ok {
+					continue
+				}
+				first, seen := holders[value]
+				if !seen {
+					holders[value] = id
+				} else if first != id {
+					return false, fmt.Sprintf("%s and %s were both %s at %s %s", first, id, role, per, value)
+				}
+			}
+			return true, fmt.Sprintf("%d %ss, one %s each", len(holders), per, role)
+		},
+	}
+}
+
+// agreement: the nodes that have a value for the field all have the same
+func agreement(field string) Invariant {
+	return Invariant{
+		Name:  "agreement on " + field,
+		AtEnd: true,
+		Check: func(state *protocol.SimulationStateResponse) (bool, string) {
+			values := make(map[string]int)
+			for _, node := range state.Nodes {
+				if value, ok := fieldOf(node, field); ok {
+					values[value]++
+				}
+			}
+			if len(values) > 1 {
+				return false, "the nodes disagree: " + countList(values)
+			}
+			return true, fmt.Sprintf("%d values", len(values))
+		},
+	}
+}
+
+// expectation: when the run ends, the node, or some node when none is
+// given, has the value for the field
+func expectation(nodeID, field, value string) Invariant {
+	subject := "some node"
+	if nodeID != "" {
+		subject = nodeID
+	}
+	return Invariant{
+		Name:  fmt.Sprintf("%s has %s %s", subject, field, value),
+		AtEnd: true,
+		Check: func(state *protocol.SimulationStateResponse) (bool, string) {
+			if nodeID != "" {
+				node, ok := state.Nodes[nodeID]
+				if !ok {
+					return false, nodeID + " does not exist"
+				}
+				got, ok := fieldOf(node, field)
+				if !ok {
+					return false, fmt.Sprintf("%s has no %s", nodeID, field)
+				}
+				return got == value, fmt.Sprintf("%s has %s %s", nodeID, field, got)
+			}
+			values := make(map[string]int)
+			for _, node := range state.Nodes {
+				if got, ok := fieldOf(node, field); ok {
+					values[got]++
+				}
+			}
+			switch {
+			case values[value] > 0:
+				return true, fmt.Sprintf("%d nodes have it", values[value])
+			case len(values) == 0:
+				return false, "no node has a " + field
+			}
+			return false, "the nodes have " + countList(values)
+		},
+	}
+}
+
+// fieldOf reads a field of a node's state as text, for the invariants
+// users define: a key of its custom state or else its role, status or
+// term, which projects keep in either place
+func fieldOf(node protocol.NodeState, field string) (string, bool) {
+	if value, ok := node.CustomState[field]; ok && value != nil && value != "" {
+		return fmt.Sprint(value), true
+	}
+	switch field {
+	case "role":
+		return node.Role, node.Role != ""
+	case "status":
+		return node.Status, node.Status != ""
+	case "term":
+		return fmt.Sprint(node.Term), node.Term != 0
+	}
+	return "", false
+}
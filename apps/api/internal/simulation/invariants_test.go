@@ -0,0 +1,42 @@
+package simulation
+
+import (
+	"testing"
+
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+)
+
+// decisions builds a state whose nodes report the decisions given, by node
+func decisions(byNode map[string]string) *protocol.SimulationStateResponse {
+	state := &protocol.SimulationStateResponse{Nodes: make(map[string]protocol.NodeState)}
+	for id, decision := range byNode {
+		state.Nodes[id] = protocol.NodeState{
+			ID:          id,
+			Status:      "running",
+			CustomState: map[string]interface{}{"decision": decision},
+		}
+	}
+	return state
+}
+
+func TestNoMixedCommitAbort(t *testing.T) {
+	tests := []struct {
+		name   string
+		nodes  map[string]string
+		holds  bool
+		detail string
+	}{
+		{"mixed", map[string]string{"p1": "commit", "p2": "abort", "p3": "commit"}, false, "p1, p3 committed but p2 aborted"},
+		{"all commit", map[string]string{"p1": "commit", "p2": "commit"}, true, "2 participant(s) committed"},
+		{"some abort", map[string]string{"p1": "abort", "p2": ""}, true, "1 participant(s) aborted"},
+		{"undecided", map[string]string{"p1": "", "p2": "prepared"}, true, "no participant decided"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			holds, detail := noMixedCommitAbort().Check(decisions(tt.nodes))
+			if holds != tt.holds || detail != tt.detail {
+				t.Fatalf("got %v %q, want %v %q", holds, detail, tt.holds, tt.detail)
+			}
+		})
+	}
+}
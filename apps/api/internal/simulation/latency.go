@@ -0,0 +1,108 @@
+package simulation
+
+import (
+	"math"
+	"sync"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+)
+
+const (
+	// defaultLatencyMatrixMaxNodes is the largest cluster whose latency
+	// matrix is reported when the client does not choose a size: the
+	// matrix grows with the square of the nodes
+	defaultLatencyMatrixMaxNodes = 16
+	// latencyMatrixTicks is how many ticks pass between two matrices
+	latencyMatrixTicks = 10
+	// latencyAlpha weighs a new sample in the rolling average, so slow
+	// links injected mid-run show within a few messages
+	latencyAlpha = 0.2
+)
+
+// linkLatency is the rolling average latency of one directed link
+type linkLatency struct {
+	avgMs   float64
+	samples int
+}
+
+// latencyMatrix watches the messages the transport delivers and reports
+// the latency between every pair of nodes every latencyMatrixTicks ticks
+type latencyMatrix struct {
+	mu sync.Mutex
+
+	transport *transport.NetworkTransport
+	maxNodes  int // <=0 = never report
+	ticks     int
+	links     map[linkKey]*linkLatency
+}
+
+func newLatencyMatrix(trans *transport.NetworkTransport, maxNodes int) *latencyMatrix {
+	if maxNodes == 0 {
+		maxNodes = defaultLatencyMatrixMaxNodes
+	}
+	return &latencyMatrix{
+		transport: trans,
+		maxNodes:  maxNodes,
+		links:     make(map[linkKey]*linkLatency),
+	}
+}
+
+// observe adds the latency of a delivered message to its link
+func (l *latencyMatrix) observe(env *transport.Envelope) {
+	if l == nil || l.maxNodes <= 0 {
+		return
+	}
+	ms := float64(env.ReceivedAt.Sub(env.SentAt).Microseconds()) / 1000
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := linkKey{from: env.From, to: env.To}
+	link := l.links[key]
+	if link == nil {
+		l.links[key] = &linkLatency{avgMs: ms, samples: 1}
+		return
+	}
+	link.avgMs += latencyAlpha * (ms - link.avgMs)
+	link.samples++
+}
+
+// tick closes the current tick, returning the matrix when one is due and
+// the cluster is small enough
+func (l *latencyMatrix) tick(virtualTime int64) *protocol.LatencyMatrixResponse {
+	if l == nil || l.maxNodes <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.ticks++
+	if l.ticks%latencyMatrixTicks != 0 || len(l.links) == 0 {
+		return nil
+	}
+	nodes := l.transport.Nodes()
+	if len(nodes) > l.maxNodes {
+		return nil
+	}
+
+	matrix := &protocol.LatencyMatrixResponse{
+		Type:        protocol.MsgLatencyMatrix,
+		VirtualTime: virtualTime,
+		Nodes:       nodes,
+		AvgMs:       make([][]float64, len(nodes)),
+		Samples:     make([][]int, len(nodes)),
+	}
+	for i, from := range nodes {
+		matrix.AvgMs[i] = make([]float64, len(nodes))
+		matrix.Samples[i] = make([]int, len(nodes))
+		for j, to := range nodes {
+			if link := l.links[linkKey{from: from, to: to}]; link != nil {
+				matrix.AvgMs[i][j] = math.Round(link.avgMs*10) / 10
+				matrix.Samples[i][j] = link.samples
+			}
+		}
+	}
+	return matrix
+}
@@ -0,0 +1,50 @@
+package simulation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+)
+
+// validLessonName restricts lesson names to safe file name characters
+var validLessonName = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// ReadLesson reads the lesson file name.json from dir
+func ReadLesson(dir, name string) (*protocol.Lesson, error) {
+	if !validLessonName.MatchString(name) {
+		return nil, fmt.Errorf("invalid lesson name: %q", name)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, name+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("lesson not found: %s", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var lesson protocol.Lesson
+	if err := json.Unmarshal(data, &lesson); err != nil {
+		return nil, fmt.Errorf("corrupt lesson %s: %w", name, err)
+	}
+	return &lesson, nil
+}
+
+// LoadScenario starts a lesson: its simulation, with its faults, controls
+// and client workload scheduled and its assertions checked as invariants
+func (m *Manager) LoadScenario(lesson protocol.Lesson) error {
+	if lesson.Project == "" {
+		return fmt.Errorf("a lesson needs a project")
+	}
+	if err := m.Start(lesson.Project, lesson.Scenario, lesson.StartRequest()); err != nil {
+		return err
+	}
+	m.handleEvent("lesson_loaded", map[string]interface{}{
+		"name":        lesson.Name,
+		"description": lesson.Description,
+	})
+	return nil
+}
@@ -0,0 +1,47 @@
+package simulation
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// stopRun stops run, if it is still the current one, recording why
+func (m *Manager) stopRun(run *runLog, reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if run == nil || m.run.Load() != run {
+		return
+	}
+	run.end(reason)
+	m.stop()
+}
+
+// CollectAbandoned stops the simulation once clients has been 0 for grace,
+// so that a run left behind by every browser does not go on forever. It
+// returns when ctx is done.
+func (m *Manager) CollectAbandoned(ctx context.Context, clients func() int, grace time.Duration) {
+	ticker := time.NewTicker(grace / 4)
+	defer ticker.Stop()
+
+	var alone time.Time // Since when nobody watches the run
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		run := m.run.Load()
+		switch {
+		case run == nil || clients() > 0:
+			alone = time.Time{}
+		case alone.IsZero():
+			alone = time.Now()
+		case time.Since(alone) >= grace:
+			log.Printf("Stopping %s: no client for %s", run.project, grace)
+			m.stopRun(run, "abandoned")
+			alone = time.Time{}
+		}
+	}
+}
@@ -3,10 +3,13 @@ package simulation
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/ersantana/distributed-systems-learning/packages/failure/injector"
 	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
 	"github.com/ersantana/distributed-systems-learning/packages/protocol"
 	"github.com/ersantana/distributed-systems-learning/packages/simulation/engine"
@@ -27,27 +30,123 @@ type ProjectSimulation interface {
 	RecoverNode(nodeID string) error
 }
 
+// ClientRequestHandler is implemented by project simulations that accept
+// user commands sent with MsgSendClientRequest
+type ClientRequestHandler interface {
+	HandleClientRequest(command string, payload map[string]interface{}) error
+}
+
+// NodeActionProvider is implemented by project simulations whose nodes
+// offer interactions of their own, e.g. a replica forcing a sync, invoked
+// with MsgInvokeNodeAction instead of a message type each
+type NodeActionProvider interface {
+	NodeActions(nodeID string) []string
+	InvokeNodeAction(nodeID, action string, params map[string]interface{}) error
+}
+
+// MembershipChanger is implemented by project simulations whose cluster
+// can grow and shrink while running
+type MembershipChanger interface {
+	AddNode(nodeID string) error
+	RemoveNode(nodeID string) error
+}
+
+// ByzantineInjector is implemented by project simulations that can turn
+// a correct node into one that lies
+type ByzantineInjector interface {
+	MakeByzantine(nodeID string) error
+}
+
+// Checkpointer is implemented by project simulations that can save their
+// state and restore it later
+type Checkpointer interface {
+	Checkpoint() ([]byte, error)
+	Restore(checkpoint []byte) error
+}
+
+// LayoutProvider is implemented by project simulations whose nodes have a
+// meaningful arrangement, e.g. a ring or a star around a coordinator
+type LayoutProvider interface {
+	Layout() *protocol.Layout
+}
+
+// Completer is implemented by project simulations that can tell when a
+// run is over, e.g. consensus was reached or the nodes went quiet, and
+// how it ended. The run is then paused and its clients told.
+type Completer interface {
+	Done() (outcome string, over bool)
+}
+
 // Manager orchestrates all simulations
 type Manager struct {
 	mu sync.RWMutex
 
-	broadcaster Broadcaster
+	broadcaster *sequencer      // Numbers what is broadcast, then hands it to replay
+	replay      *replayRecorder // Records what is broadcast for instant replays
 	engine      *engine.Engine
 	transport   *transport.NetworkTransport
 	simulation  ProjectSimulation
+	injector    *injector.Injector
 
 	currentProject string
 	currentScenario string
 	ctx            context.Context
 	cancel         context.CancelFunc
 
-	timeline []protocol.TimelineEvent
+	// perf aggregates message events when their rate gets too high
+	perf atomic.Pointer[messageAggregator]
+
+	// latency reports the latency observed between every pair of nodes
+	latency atomic.Pointer[latencyMatrix]
+
+	// netStats reports what happened to the messages of every link
+	netStats atomic.Pointer[networkStats]
+
+	// run records the events of the current run for its summary
+	run atomic.Pointer[runLog]
+
+	// invariants checks the current run against the project's invariants
+	invariants atomic.Pointer[invariantMonitor]
+
+	// controls holds the speed changes and pauses scheduled for the run
+	controls atomic.Pointer[controlSchedule]
+
+	// workload holds the client requests scheduled for the run
+	workload atomic.Pointer[workloadSchedule]
+
+	// failures holds the manual faults of the run that can be undone
+	failures atomic.Pointer[faultStack]
+
+	// checkpoint records the inputs of the run a checkpoint replays
+	checkpoint atomic.Pointer[inputLog]
+
+	// breakpoints pauses the run when a condition on it holds
+	breakpoints atomic.Pointer[breakpointSet]
+
+	// completion is the run to completion under way, which nothing is
+	// streamed during
+	completion atomic.Pointer[completionRun]
+
+	// campaign is the batch of runs made in the background, fuzzing or
+	// exploring, which nothing is streamed during either
+	campaign atomic.Pointer[campaign]
+
+	// timeline has its own lock: events are emitted while m.mu is held
+	timelineMu sync.RWMutex
+	timeline   []protocol.TimelineEvent
+
+	// dilations counts the time dilations set on each node, so a timed
+	// one only ends if no other was set since
+	dilationsMu sync.Mutex
+	dilations   map[string]uint64
 }
 
 // NewManager creates a new simulation manager
 func NewManager(broadcaster Broadcaster) *Manager {
+	replay := newReplayRecorder(broadcaster)
 	return &Manager{
-		broadcaster: broadcaster,
+		broadcaster: newSequencer(replay),
+		replay:      replay,
 		timeline:    make([]protocol.TimelineEvent, 0),
 	}
 }
@@ -63,58 +162,164 @@ func (e *eventEmitter) Emit(eventType string, data map[string]interface{}) {
 
 // handleEvent processes events from the simulation engine
 func (m *Manager) handleEvent(eventType string, data map[string]interface{}) {
-	m.mu.Lock()
+	// About the engine rather than the run: kept off the timeline
+	if eventType == "engine_metrics" {
+		m.send(engineMetrics(data))
+		return
+	}
+	m.run.Load().record(eventType, data)
+
+	if eventType == "simulation_tick" {
+		virtualTime, _ := data["virtualTime"].(int64)
+		for _, msg := range m.perf.Load().tick(virtualTime) {
+			m.BroadcastMessage(msg)
+		}
+		if matrix := m.latency.Load().tick(virtualTime); matrix != nil {
+			m.BroadcastMessage(matrix)
+		}
+		if stats := m.netStats.Load().tick(virtualTime); stats != nil {
+			m.BroadcastMessage(stats)
+		}
+		for _, violation := range m.invariants.Load().tick() {
+			m.BroadcastMessage(violation)
+		}
+		if elapsedMs, ok := data["elapsedMs"].(int64); ok {
+			m.applyControls(elapsedMs)
+			m.applyWorkload(elapsedMs)
+		}
+		m.pauseAt(m.breakpoints.Load().onTick(m.run.Load().count(string(protocol.MsgMessageSent))))
+	} else {
+		if completed := m.run.Load().completed(eventType, data); completed != nil {
+			m.BroadcastMessage(completed)
+		}
+		if eventType == "simulation_timed_out" {
+			// The engine stopped itself: stop the rest of the run, from
+			// outside of the engine's loop
+			go m.stopRun(m.run.Load(), "timeout")
+		}
+		m.pauseAt(m.breakpoints.Load().onEvent(eventType, data))
+	}
+
+	// Broadcast event to clients, under the timeline's lock so the event
+	// kept there has the number it was broadcast with
+	m.timelineMu.Lock()
 	event := protocol.TimelineEvent{
 		Time: time.Now().UnixMilli(),
 		Type: eventType,
 		Data: data,
 	}
+	err := m.sendNumbered(func(sequence uint64) interface{} {
+		event.Sequence = sequence
+		return map[string]interface{}{
+			"type": "timeline_event",
+			"event": event,
+		}
+	})
 	m.timeline = append(m.timeline, event)
 	// Keep last 100 events
 	if len(m.timeline) > 100 {
 		m.timeline = m.timeline[1:]
 	}
-	m.mu.Unlock()
+	m.timelineMu.Unlock()
 
-	// Broadcast event to clients
-	msg := map[string]interface{}{
-		"type": "timeline_event",
-		"event": event,
-	}
-	if err := m.broadcaster.BroadcastJSON(msg); err != nil {
+	if err != nil {
 		log.Printf("Error broadcasting event: %v", err)
 	}
 }
 
 // Start starts a simulation for the given project
 func (m *Manager) Start(project, scenario string, config protocol.StartSimulationRequest) error {
+	m.campaign.Store(nil)
+	return m.start(project, scenario, config, false)
+}
+
+// start starts a simulation, paused to bring it to a checkpoint first
+func (m *Manager) start(project, scenario string, config protocol.StartSimulationRequest, paused bool) error {
+	if err := protocol.ValidateControls(config.Controls); err != nil {
+		return err
+	}
+	if err := protocol.ValidateBreakpoints(config.Breakpoints); err != nil {
+		return err
+	}
+	if err := protocol.ValidateInvariants(config.Invariants); err != nil {
+		return err
+	}
+	if err := protocol.ValidateWorkload(config.Workload); err != nil {
+		return err
+	}
+	if len(config.Workload) > 0 {
+		accepts, err := acceptsClientRequests(project, scenario, config)
+		if err != nil {
+			return err
+		}
+		if !accepts {
+			return fmt.Errorf("project %s does not accept client requests", project)
+		}
+	}
+	if config.Network != nil {
+		if err := protocol.ValidateNetwork(*config.Network); err != nil {
+			return err
+		}
+	}
+	for _, eventType := range config.Config.PauseOnEvents {
+		if eventType == "" {
+			return fmt.Errorf("the events to pause on must have a type")
+		}
+	}
+	if config.Config.FuzzWindowMs < 0 {
+		return fmt.Errorf("the fuzz window must not be negative")
+	}
+	if config.Config.ParallelTicks < 0 {
+		return fmt.Errorf("the parallel ticks must not be negative")
+	}
+	if config.Config.MaxTicks < 0 || config.Config.MaxVirtualDurationMs < 0 {
+		return fmt.Errorf("the limits of the run must not be negative")
+	}
+	for nodeID, tickMs := range config.Config.NodeTickRatesMs {
+		if tickMs < 0 {
+			return fmt.Errorf("the tick rate of %s must not be negative", nodeID)
+		}
+	}
+
 	// Stop any existing simulation first (outside of lock to avoid deadlock)
 	m.mu.Lock()
+	m.completion.Store(nil)
+	summary := m.summarizeRun()
 	if m.cancel != nil {
 		m.cancel()
 	}
+	if m.transport != nil {
+		m.transport.Close()
+	}
+	if m.injector != nil {
+		m.injector.Stop()
+		m.injector = nil
+	}
 	m.mu.Unlock()
 
+	if summary != nil {
+		m.BroadcastMessage(summary)
+	}
+
 	// Set up new simulation state
 	m.mu.Lock()
 	m.currentProject = project
 	m.currentScenario = scenario
-	m.timeline = make([]protocol.TimelineEvent, 0)
+	m.resetTimeline()
 	m.ctx, m.cancel = context.WithCancel(context.Background())
 
 	// Create transport
 	m.transport = transport.NewNetworkTransport()
+	m.perf.Store(newMessageAggregator(config.Config.PerfThreshold))
+	latency := newLatencyMatrix(m.transport, config.Config.LatencyMatrixMaxNodes)
+	m.transport.OnDeliver(latency.observe)
+	m.latency.Store(latency)
+	m.netStats.Store(newNetworkStats(m.transport, project))
+	m.run.Store(newRunLog(project, scenario, config))
 	m.mu.Unlock()
 
 	// Set up drop handler to emit events
 	m.transport.OnDrop(func(env *transport.Envelope, reason string) {
-		m.handleEvent("message_dropped", map[string]interface{}{
-			"from":   env.From,
-			"to":     env.To,
-			"type":   string(env.Type),
-			"reason": reason,
-		})
-		// Also broadcast specific message dropped event
 		msg := &protocol.MessageEventResponse{
 			Type:        protocol.MsgMessageDropped,
 			MessageID:   env.ID,
@@ -123,58 +328,218 @@ func (m *Manager) Start(project, scenario string, config protocol.StartSimulatio
 			MessageType: string(env.Type),
 			Reason:      reason,
 		}
-		m.broadcaster.BroadcastJSON(msg)
+		// In performance mode drops are only counted
+		if m.perf.Load().absorb(msg) {
+			m.run.Load().recordMessage(msg)
+			return
+		}
+
+		m.handleEvent("message_dropped", map[string]interface{}{
+			"from":   env.From,
+			"to":     env.To,
+			"type":   string(env.Type),
+			"reason": reason,
+		})
+		// Also broadcast specific message dropped event
+		m.send(msg)
 	})
+	m.transport.OnCorrupt(m.messageCorrupted)
+	m.transport.OnHold(m.messageHeld)
 
 	// Create engine config
 	engineConfig := engine.Config{
-		Speed:       config.Config.Speed,
-		TickRate:    100 * time.Millisecond,
-		StepMode:    config.Config.StepMode,
-		ProjectName: project,
-		Scenario:    scenario,
+		Speed:            config.Config.Speed,
+		TickRate:         100 * time.Millisecond,
+		StepMode:         config.Config.StepMode,
+		ProjectName:      project,
+		Scenario:         scenario,
+		Seed:             config.Config.Seed,
+		Paused:           paused,
+		FuzzWindow:       time.Duration(config.Config.FuzzWindowMs) * time.Millisecond,
+		PauseOnViolation: config.Config.PauseOnViolation,
+		NodeTickRates:    make(map[string]time.Duration),
+		MetricsInterval:  engineMetricsInterval,
+		ParallelTicks:    config.Config.ParallelTicks,
+
+		MaxTicks:           config.Config.MaxTicks,
+		MaxVirtualDuration: time.Duration(config.Config.MaxVirtualDurationMs) * time.Millisecond,
 	}
 	if engineConfig.Speed == 0 {
 		engineConfig.Speed = 1.0
 	}
+	for nodeID, tickMs := range config.Config.NodeTickRatesMs {
+		engineConfig.NodeTickRates[nodeID] = time.Duration(tickMs) * time.Millisecond
+	}
 
 	// Create engine with event emitter
 	m.engine = engine.NewEngine(&eventEmitter{manager: m}, engineConfig)
+	m.transport.SetRand(m.engine.Rand())
+	m.transport.SetScheduler(m.engine)
+	m.checkpoint.Store(newInputLog(project, scenario, config, m.engine.Seed()))
 
 	// Create project-specific simulation
 	var err error
-	switch project {
-	case "two-generals":
-		m.simulation, err = m.createTwoGeneralsSimulation(scenario, config)
-	case "clocks":
-		m.simulation, err = m.createClocksSimulation(scenario, config)
-	case "byzantine":
-		m.simulation, err = m.createByzantineSimulation(scenario, config)
-	default:
-		// For projects not yet implemented, create a demo simulation
-		m.simulation, err = m.createDemoSimulation(project, config)
-	}
-
+	m.simulation, err = m.newSimulation(project, scenario, config)
 	if err != nil {
 		return err
 	}
+	// The workload was checked up front, before the previous run was
+	// stopped
+	var workload []protocol.WorkloadSpec
+	handler, ok := m.simulation.(ClientRequestHandler)
+	if ok {
+		workload = config.Workload
+	}
+
+	invariants := defaultInvariants(project)
+	for _, spec := range config.Invariants {
+		invariants = append(invariants, invariantFromSpec(spec))
+	}
+	m.invariants.Store(newInvariantMonitor(m.simulation, invariants))
+	m.breakpoints.Store(newBreakpointSet(m.engine, m.simulation, withPauseOnEvents(config.Breakpoints, config.Config.PauseOnEvents)))
+	if completer, ok := m.simulation.(Completer); ok {
+		m.engine.SetTermination(completer.Done)
+	}
+
+	// Network presets override the defaults the project just configured
+	if config.Network != nil {
+		m.applyNetworkPreset(*config.Network)
+	}
 
 	// Start the simulation
 	if err := m.simulation.Start(m.ctx); err != nil {
 		return err
 	}
 
+	m.scheduleFaults(config.Faults)
+	m.controls.Store(newControlSchedule(m.engine, config.Controls))
+	m.workload.Store(newWorkloadSchedule(handler, workload))
+	m.failures.Store(newFaultStack())
+
 	// Broadcast initial state
 	m.broadcastState()
 
 	return nil
 }
 
+// newSimulation creates the simulation of a project on the engine and
+// transport of m
+func (m *Manager) newSimulation(project, scenario string, config protocol.StartSimulationRequest) (ProjectSimulation, error) {
+	switch project {
+	case "two-generals":
+		return m.createTwoGeneralsSimulation(scenario, config)
+	case "clocks":
+		return m.createClocksSimulation(scenario, config)
+	case "byzantine":
+		return m.createByzantineSimulation(scenario, config)
+	case "broadcast":
+		return m.createBroadcastSimulation(scenario, config)
+	case "crdt":
+		return m.createCRDTSimulation(scenario, config)
+	case "queues":
+		return m.createQueuesSimulation(scenario, config)
+	case "mistakes":
+		return m.createMistakesSimulation(scenario, config)
+	case "zab":
+		return m.createZabSimulation(scenario, config)
+	case "epaxos":
+		return m.createEPaxosSimulation(scenario, config)
+	case "state-machine":
+		return m.createStateMachineSimulation(scenario, config)
+	case "locks":
+		return m.createLocksSimulation(scenario, config)
+	case "refcount":
+		return m.createRefCountSimulation(scenario, config)
+	case "mutex":
+		return m.createMutexSimulation(scenario, config)
+	case "stabilization":
+		return m.createStabilizationSimulation(scenario, config)
+	case "election":
+		return m.createElectionSimulation(scenario, config)
+	case "chord":
+		return m.createChordSimulation(scenario, config)
+	case "consistent-hashing":
+		return m.createHashRingSimulation(scenario, config)
+	case "quorum":
+		return m.createQuorumSimulation(scenario, config)
+	case "chain-replication":
+		return m.createChainSimulation(scenario, config)
+	case "truetime":
+		return m.createTrueTimeSimulation(scenario, config)
+	case "clock-sync":
+		return m.createClockSyncSimulation(scenario, config)
+	case "cap":
+		return m.createCAPSimulation(scenario, config)
+	case "percolator":
+		return m.createPercolatorSimulation(scenario, config)
+	case "calvin":
+		return m.createCalvinSimulation(scenario, config)
+	case "hotstuff":
+		return m.createHotStuffSimulation(scenario, config)
+	case "nakamoto":
+		return m.createNakamotoSimulation(scenario, config)
+	case "swim":
+		return m.createSWIMSimulation(scenario, config)
+	case "phi-accrual":
+		return m.createPhiAccrualSimulation(scenario, config)
+	case "heartbeat":
+		return m.createHeartbeatSimulation(scenario, config)
+	case "session-guarantees":
+		return m.createSessionsSimulation(scenario, config)
+	case "load-balancing":
+		return m.createLoadBalancingSimulation(scenario, config)
+	case "mapreduce":
+		return m.createMapReduceSimulation(scenario, config)
+	case "escrow":
+		return m.createEscrowSimulation(scenario, config)
+	case "two-phase-locking":
+		return m.createTwoPhaseLockingSimulation(scenario, config)
+	case "raft":
+		return m.createRaftSimulation(scenario, config)
+	default:
+		// For projects not yet implemented, create a demo simulation
+		return m.createDemoSimulation(project, config)
+	}
+}
+
+// acceptsClientRequests tells whether the simulations of a project are
+// ClientRequestHandlers, by creating one on an engine and a transport of
+// its own, so a workload is refused without stopping the running one
+func acceptsClientRequests(project, scenario string, config protocol.StartSimulationRequest) (bool, error) {
+	probe := NewManager(discardBroadcaster{})
+	probe.engine = engine.NewEngine(&eventEmitter{manager: probe}, engine.Config{TickRate: 100 * time.Millisecond, Seed: 1})
+	probe.transport = transport.NewNetworkTransport()
+	probe.transport.SetRand(probe.engine.Rand())
+	probe.transport.SetScheduler(probe.engine)
+	probe.ctx, probe.cancel = context.WithCancel(context.Background())
+	defer probe.cancel()
+	defer probe.transport.Close()
+
+	sim, err := probe.newSimulation(project, scenario, config)
+	if err != nil {
+		return false, err
+	}
+	_, ok := sim.(ClientRequestHandler)
+	return ok, nil
+}
+
+// discardBroadcaster drops what is broadcast
+type discardBroadcaster struct{}
+
+func (discardBroadcaster) BroadcastJSON(v interface{}) error {
+	return nil
+}
+
 // Stop stops the current simulation
 func (m *Manager) Stop() error {
+	m.campaign.Store(nil)
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	return m.stop()
+}
 
+// stop stops the current simulation (must hold m.mu)
+func (m *Manager) stop() error {
 	if m.simulation != nil {
 		m.simulation.Stop()
 	}
@@ -184,6 +549,17 @@ func (m *Manager) Stop() error {
 	if m.engine != nil {
 		m.engine.Stop()
 	}
+	if m.injector != nil {
+		m.injector.Stop()
+	}
+	if m.transport != nil {
+		m.transport.Close()
+	}
+
+	m.completion.Store(nil)
+	if summary := m.summarizeRun(); summary != nil {
+		m.BroadcastMessage(summary)
+	}
 
 	m.simulation = nil
 	m.engine = nil
@@ -214,17 +590,82 @@ func (m *Manager) Resume() {
 	}
 }
 
-// Step advances the simulation by one step
-func (m *Manager) Step() {
+// Step advances the simulation by one step, stepping a paused run from
+// there on, and returns once the tick and every event due before it have
+// run
+func (m *Manager) Step() (*protocol.StepCompletedResponse, error) {
+	return m.StepN(1, nil)
+}
+
+// maxStepN bounds the steps of a single StepN
+const maxStepN = 10000
+
+// stepProgressInterval is how often StepN reports its progress, in wall
+// time
+const stepProgressInterval = 100 * time.Millisecond
+
+// StepN advances the simulation by n steps, as Step does, one after the
+// other. progress, if not nil, is told how far it has got every so often;
+// the state is only broadcast once every step has run.
+func (m *Manager) StepN(n int, progress func(*protocol.StepProgressResponse)) (*protocol.StepCompletedResponse, error) {
+	if n < 1 || n > maxStepN {
+		return nil, fmt.Errorf("the number of steps must be between 1 and %d", maxStepN)
+	}
+
 	m.mu.RLock()
-	defer m.mu.RUnlock()
+	eng, ctx := m.engine, m.ctx
+	running := m.simulation != nil
+	m.mu.RUnlock()
 
-	if m.engine != nil {
-		m.engine.Step()
-		// Give time for tick to process
-		time.Sleep(50 * time.Millisecond)
-		m.broadcastState()
+	if eng == nil || !running {
+		return nil, fmt.Errorf("no simulation running")
 	}
+	switch eng.GetMode() {
+	case engine.ModeStepByStep:
+	case engine.ModePaused:
+		eng.SetMode(engine.ModeStepByStep)
+	default:
+		return nil, fmt.Errorf("pause the simulation to step through it")
+	}
+
+	reported := time.Now()
+	for done := 1; done <= n; done++ {
+		// Without the lock: the events run may need it
+		select {
+		case <-eng.Step():
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("the simulation stopped after %d of %d steps", done-1, n)
+		}
+		if done == n {
+			break
+		}
+		if eng.GetMode() != engine.ModeStepByStep {
+			// Resumed, or paused at a breakpoint, on the way
+			n = done
+			break
+		}
+		if progress != nil && time.Since(reported) >= stepProgressInterval {
+			reported = time.Now()
+			progress(&protocol.StepProgressResponse{
+				Type:      protocol.MsgStepProgress,
+				Done:      done,
+				Total:     n,
+				ElapsedMs: eng.Elapsed().Milliseconds(),
+			})
+		}
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	m.broadcastState()
+	return &protocol.StepCompletedResponse{
+		Type:      protocol.MsgStepCompleted,
+		Steps:     n,
+		ElapsedMs: eng.Elapsed().Milliseconds(),
+		State:     m.decorateState(m.simulation.GetState()),
+	}, nil
 }
 
 // SetSpeed sets the simulation speed
@@ -237,6 +678,90 @@ func (m *Manager) SetSpeed(speed float64) {
 	}
 }
 
+// SetNodeTickRate makes a node tick at its own rate, slower or faster
+// than the others, or in lockstep with them again with a zero rate
+func (m *Manager) SetNodeTickRate(nodeID string, rate time.Duration) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.engine == nil || m.simulation == nil {
+		return fmt.Errorf("no simulation running")
+	}
+	if _, ok := m.simulation.GetNodes()[nodeID]; !ok {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	if rate < 0 {
+		return fmt.Errorf("the tick rate must not be negative")
+	}
+	m.engine.SetNodeTickRate(nodeID, rate)
+	m.handleEvent("node_tick_rate", map[string]interface{}{
+		"nodeId": nodeID,
+		"tickMs": m.engine.NodeTickRate(nodeID).Milliseconds(),
+	})
+	m.recordInput(protocol.CheckpointInput{Kind: "tick_rate", NodeID: nodeID, TickMs: rate.Milliseconds()})
+	m.broadcastState()
+	return nil
+}
+
+// SetTimeDilation makes time pass factor times as fast for a node as for
+// the others, for duration of virtual time or, with a zero duration, until
+// set again: slower for an overloaded machine, stopped for a GC pause
+func (m *Manager) SetTimeDilation(nodeID string, factor float64, duration time.Duration) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.engine == nil || m.simulation == nil {
+		return fmt.Errorf("no simulation running")
+	}
+	if _, ok := m.simulation.GetNodes()[nodeID]; !ok {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	if factor < 0 || factor > engine.MaxTimeDilation {
+		return fmt.Errorf("the time dilation must be between 0 and %d", engine.MaxTimeDilation)
+	}
+	if duration < 0 {
+		return fmt.Errorf("the duration must not be negative")
+	}
+	eng := m.engine
+	previous := eng.SetTimeDilation(nodeID, factor)
+	generation := m.dilated(nodeID)
+	m.handleEvent("node_time_dilated", map[string]interface{}{
+		"nodeId":     nodeID,
+		"factor":     factor,
+		"durationMs": duration.Milliseconds(),
+	})
+	if duration > 0 {
+		eng.AfterLabeled(duration, "time dilation of "+nodeID+" ends", func() {
+			// Unless it was set again meanwhile
+			m.dilationsMu.Lock()
+			current := m.dilations[nodeID]
+			m.dilationsMu.Unlock()
+			if current != generation {
+				return
+			}
+			eng.SetTimeDilation(nodeID, previous)
+			m.handleEvent("node_time_dilated", map[string]interface{}{
+				"nodeId": nodeID,
+				"factor": previous,
+			})
+		})
+	}
+	m.recordInput(protocol.CheckpointInput{Kind: "time_dilation", NodeID: nodeID, Factor: factor, DurationMs: duration.Milliseconds()})
+	m.broadcastState()
+	return nil
+}
+
+// dilated counts a time dilation set on a node, and returns its number
+func (m *Manager) dilated(nodeID string) uint64 {
+	m.dilationsMu.Lock()
+	defer m.dilationsMu.Unlock()
+	if m.dilations == nil {
+		m.dilations = make(map[string]uint64)
+	}
+	m.dilations[nodeID]++
+	return m.dilations[nodeID]
+}
+
 // CrashNode crashes a node
 func (m *Manager) CrashNode(nodeID string) error {
 	m.mu.RLock()
@@ -248,6 +773,8 @@ func (m *Manager) CrashNode(nodeID string) error {
 			m.handleEvent("node_crashed", map[string]interface{}{
 				"nodeId": nodeID,
 			})
+			m.failures.Load().push(manualFault{kind: "crash", nodeID: nodeID})
+			m.recordInput(protocol.CheckpointInput{Kind: "crash", NodeID: nodeID})
 			m.broadcastState()
 		}
 		return err
@@ -255,6 +782,63 @@ func (m *Manager) CrashNode(nodeID string) error {
 	return nil
 }
 
+// HandleClientRequest forwards a user command to the current simulation
+func (m *Manager) HandleClientRequest(command string, payload map[string]interface{}) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.simulation == nil {
+		return fmt.Errorf("no simulation running")
+	}
+	handler, ok := m.simulation.(ClientRequestHandler)
+	if !ok {
+		return fmt.Errorf("project %s does not accept client requests", m.currentProject)
+	}
+	if err := handler.HandleClientRequest(command, payload); err != nil {
+		return err
+	}
+	m.handleEvent("client_request", map[string]interface{}{
+		"command": command,
+		"payload": payload,
+	})
+	m.recordInput(protocol.CheckpointInput{Kind: "client_request", Command: command, Params: payload})
+	m.broadcastState()
+	return nil
+}
+
+// InvokeNodeAction invokes an action offered by a node of the current
+// project
+func (m *Manager) InvokeNodeAction(nodeID, action string, params map[string]interface{}) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.simulation == nil {
+		return fmt.Errorf("no simulation running")
+	}
+	provider, ok := m.simulation.(NodeActionProvider)
+	if !ok {
+		return fmt.Errorf("project %s has no node actions", m.currentProject)
+	}
+	offered := false
+	for _, name := range provider.NodeActions(nodeID) {
+		offered = offered || name == action
+	}
+	if !offered {
+		return fmt.Errorf("node %s offers no action %q", nodeID, action)
+	}
+	if err := provider.InvokeNodeAction(nodeID, action, params); err != nil {
+		return err
+	}
+	m.handleEvent("node_action", map[string]interface{}{
+		"nodeId": nodeID,
+		"action": action,
+		"params": params,
+	})
+	m.recordInput(protocol.CheckpointInput{Kind: "node_action", NodeID: nodeID, Command: action, Params: params})
+	m.broadcastState()
+	return nil
+}
+
 // RecoverNode recovers a crashed node
 func (m *Manager) RecoverNode(nodeID string) error {
 	m.mu.RLock()
@@ -266,6 +850,8 @@ func (m *Manager) RecoverNode(nodeID string) error {
 			m.handleEvent("node_recovered", map[string]interface{}{
 				"nodeId": nodeID,
 			})
+			m.forgetRecovery(nodeID)
+			m.recordInput(protocol.CheckpointInput{Kind: "recover", NodeID: nodeID})
 			m.broadcastState()
 		}
 		return err
@@ -289,6 +875,8 @@ func (m *Manager) InjectPartition(from, to string, bidirectional bool) {
 			"to":            to,
 			"bidirectional": bidirectional,
 		})
+		m.failures.Load().push(manualFault{kind: "partition", from: from, to: to, bidirectional: bidirectional})
+		m.recordInput(protocol.CheckpointInput{Kind: "partition", From: from, To: to, Bidirectional: bidirectional})
 		m.broadcastState()
 	}
 }
@@ -309,6 +897,8 @@ func (m *Manager) HealPartition(from, to string, bidirectional bool) {
 			"to":            to,
 			"bidirectional": bidirectional,
 		})
+		m.forgetHeal(from, to, bidirectional)
+		m.recordInput(protocol.CheckpointInput{Kind: "heal", From: from, To: to, Bidirectional: bidirectional})
 		m.broadcastState()
 	}
 }
@@ -319,9 +909,7 @@ func (m *Manager) GetState() *protocol.SimulationStateResponse {
 	defer m.mu.RUnlock()
 
 	if m.simulation != nil {
-		state := m.simulation.GetState()
-		state.Timeline = m.timeline
-		return state
+		return m.decorateState(m.simulation.GetState())
 	}
 
 	// Return empty state
@@ -335,18 +923,213 @@ func (m *Manager) GetState() *protocol.SimulationStateResponse {
 	}
 }
 
+// send broadcasts to all clients, unless the run is going to completion
+// or part of a campaign
+func (m *Manager) send(v interface{}) error {
+	return m.sendNumbered(func(uint64) interface{} { return v })
+}
+
+// sendNumbered is send for a message that carries its sequence number
+// inside too, which message builds knowing it
+func (m *Manager) sendNumbered(message func(sequence uint64) interface{}) error {
+	if m.completion.Load() != nil || m.campaign.Load() != nil {
+		return nil
+	}
+	return m.broadcaster.broadcast(message)
+}
+
 // broadcastState sends current state to all clients
 func (m *Manager) broadcastState() {
 	if m.simulation != nil {
-		state := m.simulation.GetState()
-		state.Timeline = m.timeline
-		m.broadcaster.BroadcastJSON(state)
+		m.send(m.decorateState(m.simulation.GetState()))
+	}
+}
+
+// decorateState adds manager-level state to a project's state
+func (m *Manager) decorateState(state *protocol.SimulationStateResponse) *protocol.SimulationStateResponse {
+	state.Timeline = m.getTimeline()
+	if state.Metadata == nil {
+		state.Metadata = make(map[string]interface{})
+	}
+	state.Metadata["performanceMode"] = m.perf.Load().isEnabled()
+	state.Metadata["undoableFailures"] = m.failures.Load().size()
+	state.Capabilities = capabilitiesOf(m.simulation)
+	if m.engine != nil {
+		state.Seed = m.engine.Seed()
+		state.VirtualTime = m.engine.GetVirtualTime().UnixMilli()
+		if mode := m.engine.GetMode(); mode == engine.ModeStepByStep || mode == engine.ModePaused {
+			state.Pending = pendingEvents(m.engine)
+		}
+		if m.transport != nil {
+			state.Held = heldMessages(m.transport, m.engine.Elapsed(), m.engine.GetVirtualTime())
+		}
+	}
+	if provider, ok := m.simulation.(LayoutProvider); ok {
+		state.Layout = provider.Layout()
+	}
+	if provider, ok := m.simulation.(NodeActionProvider); ok {
+		for id, node := range state.Nodes {
+			node.Actions = provider.NodeActions(id)
+			state.Nodes[id] = node
+		}
+	}
+	if m.transport != nil {
+		network := m.transport.Summary()
+		state.Network = &protocol.NetworkSummary{
+			PacketLoss:           network.PacketLoss,
+			MinLatencyMs:         network.MinLatency.Milliseconds(),
+			MaxLatencyMs:         network.MaxLatency.Milliseconds(),
+			Latency:              network.Latency.String(),
+			Partitions:           network.Partitions,
+			InFlight:             network.InFlight,
+			Queued:               network.Queued,
+			BandwidthBytesPerSec: network.Bandwidth.BytesPerSecond,
+			QueueCapacity:        network.Bandwidth.QueueCapacity,
+			FIFO:                 network.FIFO,
+			Holding:              network.Holding,
+			Held:                 network.Held,
+			Serialize:            network.Serialization.Enabled,
+			SerializeCostPerKBMs: float64(network.Serialization.CostPerKB) / float64(time.Millisecond),
+		}
+		for _, link := range m.transport.LinkLatencies() {
+			minMs, maxMs, distribution := latencySpec(link.Latency)
+			state.Network.Links = append(state.Network.Links, protocol.LinkLatencySpec{
+				From:         link.From,
+				To:           link.To,
+				MinLatencyMs: minMs,
+				MaxLatencyMs: maxMs,
+				Distribution: distribution,
+			})
+		}
+		for _, link := range m.transport.LinkPacketLosses() {
+			state.Network.Losses = append(state.Network.Losses, protocol.LinkPacketLossSpec{
+				From:       link.From,
+				To:         link.To,
+				PacketLoss: link.PacketLoss,
+			})
+		}
+		for _, link := range m.transport.LinkBandwidths() {
+			state.Network.Bandwidths = append(state.Network.Bandwidths, protocol.LinkBandwidthSpec{
+				From:          link.From,
+				To:            link.To,
+				BytesPerSec:   link.Bandwidth.BytesPerSecond,
+				QueueCapacity: link.Bandwidth.QueueCapacity,
+			})
+		}
+		for _, link := range m.transport.LinkFIFOs() {
+			state.Network.FIFOLinks = append(state.Network.FIFOLinks, protocol.LinkFIFOSpec{
+				From: link.From,
+				To:   link.To,
+				FIFO: link.FIFO,
+			})
+		}
+	}
+	return state
+}
+
+// capabilitiesOf tells which optional interfaces a project simulation
+// implements
+func capabilitiesOf(sim ProjectSimulation) *protocol.Capabilities {
+	_, clientRequests := sim.(ClientRequestHandler)
+	_, nodeActions := sim.(NodeActionProvider)
+	_, membership := sim.(MembershipChanger)
+	_, byzantine := sim.(ByzantineInjector)
+	_, checkpoints := sim.(Checkpointer)
+	return &protocol.Capabilities{
+		SupportsClientRequests:     clientRequests,
+		SupportsNodeActions:        nodeActions,
+		SupportsMembershipChange:   membership,
+		SupportsByzantineInjection: byzantine,
+		SupportsCheckpoints:        checkpoints,
+	}
+}
+
+// summarizeRun builds the summary of the current run, if there is one,
+// and closes its log (must hold m.mu)
+func (m *Manager) summarizeRun() *protocol.RunSummaryResponse {
+	run := m.run.Swap(nil)
+	invariants := m.invariants.Swap(nil)
+	m.controls.Store(nil)
+	m.workload.Store(nil)
+	m.failures.Store(nil)
+	if run == nil || m.simulation == nil {
+		return nil
+	}
+	state := m.decorateState(m.simulation.GetState())
+	results := append(invariants.results(state), engineInvariantResults(m.engine)...)
+	summary := run.summarize(m.simulation, state, results, m.engine.Elapsed())
+	if bytes, types := wireBytes(m.transport); bytes > 0 {
+		summary.Metrics["bytesSent"] = bytes
+		summary.Metrics["bytesByType"] = types
+	}
+	return summary
+}
+
+// RegisterInvariant adds an invariant to those the current run is checked
+// against, on top of the project's defaults
+func (m *Manager) RegisterInvariant(invariant Invariant) error {
+	invariants := m.invariants.Load()
+	if invariants == nil {
+		return fmt.Errorf("no simulation running")
+	}
+	invariants.register(invariant)
+	return nil
+}
+
+// RunStats describes the current run for long-running health checks
+type RunStats struct {
+	ID           string // Project, scenario and start time
+	Project      string
+	Scenario     string
+	Events       int // Events recorded since the start
+	StoredEvents int // Events held for the run summary
+}
+
+// RunStats returns the statistics of the current run, if there is one
+func (m *Manager) RunStats() (RunStats, bool) {
+	run := m.run.Load()
+	if run == nil {
+		return RunStats{}, false
 	}
+	events, stored := run.size()
+	return RunStats{
+		ID:           fmt.Sprintf("%s/%s@%d", run.project, run.scenario, run.startedAt.Unix()),
+		Project:      run.project,
+		Scenario:     run.scenario,
+		Events:       events,
+		StoredEvents: stored,
+	}, true
+}
+
+// TimelineSize returns the number of events held in the timeline
+func (m *Manager) TimelineSize() int {
+	m.timelineMu.RLock()
+	defer m.timelineMu.RUnlock()
+	return len(m.timeline)
+}
+
+// getTimeline returns a copy of the recent timeline events
+func (m *Manager) getTimeline() []protocol.TimelineEvent {
+	m.timelineMu.RLock()
+	defer m.timelineMu.RUnlock()
+	return append([]protocol.TimelineEvent{}, m.timeline...)
+}
+
+// resetTimeline clears the timeline for a new run
+func (m *Manager) resetTimeline() {
+	m.timelineMu.Lock()
+	defer m.timelineMu.Unlock()
+	m.timeline = make([]protocol.TimelineEvent, 0)
 }
 
 // BroadcastMessage sends a specific message to clients
 func (m *Manager) BroadcastMessage(msg interface{}) {
-	if err := m.broadcaster.BroadcastJSON(msg); err != nil {
+	m.run.Load().recordMessage(msg)
+	m.pauseAt(m.breakpoints.Load().onMessage(msg))
+	if m.perf.Load().absorb(msg) {
+		return
+	}
+	if err := m.send(msg); err != nil {
 		log.Printf("Error broadcasting message: %v", err)
 	}
 }
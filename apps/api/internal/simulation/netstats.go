@@ -0,0 +1,100 @@
+package simulation
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+)
+
+// networkStatsTicks is how many ticks pass between two reports of the
+// links' statistics
+const networkStatsTicks = 10
+
+// networkStats reports what happened to the messages of every link, and
+// the bytes of each message type, every networkStatsTicks ticks, from the
+// counts the transport keeps
+type networkStats struct {
+	mu sync.Mutex
+
+	transport *transport.NetworkTransport
+	project   string
+	ticks     int
+}
+
+func newNetworkStats(trans *transport.NetworkTransport, project string) *networkStats {
+	return &networkStats{transport: trans, project: project}
+}
+
+// tick closes the current tick, returning the report when one is due and
+// a message was sent
+func (n *networkStats) tick(virtualTime int64) *protocol.NetworkStatsResponse {
+	if n == nil {
+		return nil
+	}
+
+	n.mu.Lock()
+	n.ticks++
+	due := n.ticks%networkStatsTicks == 0
+	n.mu.Unlock()
+	if !due {
+		return nil
+	}
+
+	links := n.transport.LinkStats()
+	if len(links) == 0 {
+		return nil
+	}
+	bytes, types := wireBytes(n.transport)
+	stats := &protocol.NetworkStatsResponse{
+		Type:        protocol.MsgNetworkStats,
+		Project:     n.project,
+		VirtualTime: virtualTime,
+		Links:       make([]protocol.LinkDeliveryStats, len(links)),
+		Types:       types,
+		BytesSent:   bytes,
+	}
+	for i, link := range links {
+		stats.Links[i] = protocol.LinkDeliveryStats{
+			From:           link.From,
+			To:             link.To,
+			Sent:           link.Sent,
+			Delivered:      link.Delivered,
+			Dropped:        link.Dropped,
+			Duplicated:     link.Duplicated,
+			MinLatencyMs:   durationMs(link.MinLatency),
+			AvgLatencyMs:   durationMs(link.AvgLatency),
+			P99LatencyMs:   durationMs(link.P99Latency),
+			BytesSent:      link.BytesSent,
+			BytesDelivered: link.BytesDelivered,
+		}
+	}
+	return stats
+}
+
+// wireBytes returns the bytes on the wire of all the messages sent, and
+// the counts of each message type; bytes are only counted while the
+// network serializes messages
+func wireBytes(trans *transport.NetworkTransport) (int64, []protocol.MessageTypeStats) {
+	types := make([]protocol.MessageTypeStats, 0)
+	if trans == nil {
+		return 0, types
+	}
+	var bytes int64
+	for _, s := range trans.TypeStats() {
+		types = append(types, protocol.MessageTypeStats{
+			Type:     string(s.Type),
+			Messages: s.Messages,
+			Bytes:    s.Bytes,
+		})
+		bytes += s.Bytes
+	}
+	return bytes, types
+}
+
+// durationMs is a duration in milliseconds, to a tenth
+func durationMs(d time.Duration) float64 {
+	return math.Round(float64(d.Microseconds())/100) / 10
+}
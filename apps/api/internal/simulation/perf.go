@@ -0,0 +1,154 @@
+package simulation
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+)
+
+const (
+	// defaultPerfThreshold is the message events per tick that switch on
+	// performance mode when the client does not choose a threshold
+	defaultPerfThreshold = 50
+	// perfCooldownTicks is how many quiet ticks (below half the threshold)
+	// it takes to switch performance mode off again
+	perfCooldownTicks = 20
+)
+
+type linkKey struct {
+	from string
+	to   string
+}
+
+// messageAggregator watches the rate of per-message events and, above a
+// threshold, replaces them with per-link counts reported once per tick
+type messageAggregator struct {
+	mu sync.Mutex
+
+	threshold int // <=0 = never aggregate
+	enabled   bool
+	quiet     int // Consecutive ticks below the exit level
+
+	tickEvents int
+	links      map[linkKey]*protocol.LinkStats
+}
+
+func newMessageAggregator(threshold int) *messageAggregator {
+	if threshold == 0 {
+		threshold = defaultPerfThreshold
+	}
+	return &messageAggregator{
+		threshold: threshold,
+		links:     make(map[linkKey]*protocol.LinkStats),
+	}
+}
+
+// absorb counts a message event and reports whether it was folded into
+// the aggregate instead of being sent on its own
+func (a *messageAggregator) absorb(msg interface{}) bool {
+	ev, ok := msg.(*protocol.MessageEventResponse)
+	if !ok || a == nil || a.threshold <= 0 {
+		return false
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.tickEvents++
+	if !a.enabled {
+		return false
+	}
+
+	key := linkKey{from: ev.From, to: ev.To}
+	link := a.links[key]
+	if link == nil {
+		link = &protocol.LinkStats{From: ev.From, To: ev.To}
+		a.links[key] = link
+	}
+	switch ev.Type {
+	case protocol.MsgMessageSent:
+		link.Sent++
+	case protocol.MsgMessageReceived:
+		link.Received++
+	case protocol.MsgMessageDropped:
+		link.Dropped++
+	case protocol.MsgMessageCorrupted:
+		link.Corrupted++
+	}
+	return true
+}
+
+// tick closes the current tick, returning the messages to send: a mode
+// change notification and/or the tick's aggregated counts
+func (a *messageAggregator) tick(virtualTime int64) []interface{} {
+	if a == nil || a.threshold <= 0 {
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	rate := a.tickEvents
+	a.tickEvents = 0
+	out := make([]interface{}, 0, 2)
+
+	if a.enabled && len(a.links) > 0 {
+		stats := &protocol.MessageStatsResponse{
+			Type:        protocol.MsgMessageStats,
+			VirtualTime: virtualTime,
+			Links:       make([]protocol.LinkStats, 0, len(a.links)),
+			Total:       rate,
+		}
+		for _, link := range a.links {
+			stats.Links = append(stats.Links, *link)
+		}
+		sort.Slice(stats.Links, func(i, j int) bool {
+			if stats.Links[i].From != stats.Links[j].From {
+				return stats.Links[i].From < stats.Links[j].From
+			}
+			return stats.Links[i].To < stats.Links[j].To
+		})
+		out = append(out, stats)
+		a.links = make(map[linkKey]*protocol.LinkStats)
+	}
+
+	switch {
+	case !a.enabled && rate > a.threshold:
+		a.enabled = true
+		a.quiet = 0
+		out = append(out, a.modeChange(rate))
+
+	case a.enabled && rate < a.threshold/2:
+		// Hysteresis keeps bursty runs from flapping between modes
+		a.quiet++
+		if a.quiet >= perfCooldownTicks {
+			a.enabled = false
+			out = append(out, a.modeChange(rate))
+		}
+
+	case a.enabled:
+		a.quiet = 0
+	}
+
+	return out
+}
+
+// isEnabled reports whether message events are currently aggregated
+func (a *messageAggregator) isEnabled() bool {
+	if a == nil {
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.enabled
+}
+
+func (a *messageAggregator) modeChange(rate int) *protocol.PerformanceModeResponse {
+	return &protocol.PerformanceModeResponse{
+		Type:      protocol.MsgPerformanceMode,
+		Enabled:   a.enabled,
+		Rate:      rate,
+		Threshold: a.threshold,
+	}
+}
@@ -6,9 +6,41 @@ import (
 	"sync"
 	"time"
 
+	"github.com/ersantana/distributed-systems-learning/apps/api/internal/projects/broadcast"
 	"github.com/ersantana/distributed-systems-learning/apps/api/internal/projects/byzantine"
+	"github.com/ersantana/distributed-systems-learning/apps/api/internal/projects/calvin"
+	"github.com/ersantana/distributed-systems-learning/apps/api/internal/projects/cap"
+	"github.com/ersantana/distributed-systems-learning/apps/api/internal/projects/chain"
+	"github.com/ersantana/distributed-systems-learning/apps/api/internal/projects/chord"
 	"github.com/ersantana/distributed-systems-learning/apps/api/internal/projects/clocks"
+	"github.com/ersantana/distributed-systems-learning/apps/api/internal/projects/clocksync"
+	"github.com/ersantana/distributed-systems-learning/apps/api/internal/projects/crdt"
+	"github.com/ersantana/distributed-systems-learning/apps/api/internal/projects/election"
+	"github.com/ersantana/distributed-systems-learning/apps/api/internal/projects/epaxos"
+	"github.com/ersantana/distributed-systems-learning/apps/api/internal/projects/escrow"
+	"github.com/ersantana/distributed-systems-learning/apps/api/internal/projects/hashring"
+	"github.com/ersantana/distributed-systems-learning/apps/api/internal/projects/heartbeat"
+	"github.com/ersantana/distributed-systems-learning/apps/api/internal/projects/hotstuff"
+	"github.com/ersantana/distributed-systems-learning/apps/api/internal/projects/loadbalancing"
+	"github.com/ersantana/distributed-systems-learning/apps/api/internal/projects/locks"
+	"github.com/ersantana/distributed-systems-learning/apps/api/internal/projects/mapreduce"
+	"github.com/ersantana/distributed-systems-learning/apps/api/internal/projects/mistakes"
+	"github.com/ersantana/distributed-systems-learning/apps/api/internal/projects/mutex"
+	"github.com/ersantana/distributed-systems-learning/apps/api/internal/projects/nakamoto"
+	"github.com/ersantana/distributed-systems-learning/apps/api/internal/projects/percolator"
+	"github.com/ersantana/distributed-systems-learning/apps/api/internal/projects/phiaccrual"
+	"github.com/ersantana/distributed-systems-learning/apps/api/internal/projects/queues"
+	"github.com/ersantana/distributed-systems-learning/apps/api/internal/projects/quorum"
+	"github.com/ersantana/distributed-systems-learning/apps/api/internal/projects/raft"
+	"github.com/ersantana/distributed-systems-learning/apps/api/internal/projects/refcount"
+	"github.com/ersantana/distributed-systems-learning/apps/api/internal/projects/sessions"
+	"github.com/ersantana/distributed-systems-learning/apps/api/internal/projects/stabilization"
+	"github.com/ersantana/distributed-systems-learning/apps/api/internal/projects/statemachine"
+	"github.com/ersantana/distributed-systems-learning/apps/api/internal/projects/swim"
+	"github.com/ersantana/distributed-systems-learning/apps/api/internal/projects/truetime"
 	"github.com/ersantana/distributed-systems-learning/apps/api/internal/projects/twogenerals"
+	"github.com/ersantana/distributed-systems-learning/apps/api/internal/projects/twophaselocking"
+	"github.com/ersantana/distributed-systems-learning/apps/api/internal/projects/zab"
 	"github.com/ersantana/distributed-systems-learning/packages/protocol"
 	"github.com/ersantana/distributed-systems-learning/packages/simulation/engine"
 )
@@ -87,6 +119,624 @@ func (m *Manager) createByzantineSimulation(scenario string, config protocol.Sta
 	return sim, nil
 }
 
+// createBroadcastSimulation creates a Broadcast protocols simulation
+func (m *Manager) createBroadcastSimulation(scenario string, config protocol.StartSimulationRequest) (ProjectSimulation, error) {
+	nodeCount := config.Config.NodeCount
+	if nodeCount == 0 {
+		nodeCount = 5
+	}
+	if scenario == "" {
+		scenario = "best_effort"
+	}
+
+	sim := broadcast.NewSimulation(
+		m.engine,
+		m.transport,
+		m.BroadcastMessage,
+		broadcast.Config{
+			NodeCount: nodeCount,
+			Scenario:  scenario,
+		},
+	)
+
+	return sim, nil
+}
+
+// createCRDTSimulation creates a CRDT replication simulation
+func (m *Manager) createCRDTSimulation(scenario string, config protocol.StartSimulationRequest) (ProjectSimulation, error) {
+	nodeCount := config.Config.NodeCount
+	if nodeCount == 0 {
+		nodeCount = 4
+	}
+	if scenario == "" {
+		scenario = "g_counter"
+	}
+
+	sim := crdt.NewSimulation(
+		m.engine,
+		m.transport,
+		m.BroadcastMessage,
+		crdt.Config{
+			NodeCount: nodeCount,
+			Scenario:  scenario,
+		},
+	)
+
+	return sim, nil
+}
+
+// createQueuesSimulation creates a persistent queues simulation
+func (m *Manager) createQueuesSimulation(scenario string, config protocol.StartSimulationRequest) (ProjectSimulation, error) {
+	if scenario == "" {
+		scenario = "durable_broker_crash"
+	}
+
+	sim := queues.NewSimulation(
+		m.engine,
+		m.transport,
+		m.BroadcastMessage,
+		queues.Config{
+			Scenario: scenario,
+		},
+	)
+
+	return sim, nil
+}
+
+// createMistakesSimulation creates a "common mistakes" simulation; each
+// scenario has a "_fixed" variant with the corrected design
+func (m *Manager) createMistakesSimulation(scenario string, config protocol.StartSimulationRequest) (ProjectSimulation, error) {
+	if scenario == "" {
+		scenario = "2pc_timeout_abort"
+	}
+
+	sim := mistakes.NewSimulation(
+		m.engine,
+		m.transport,
+		m.BroadcastMessage,
+		mistakes.Config{
+			Scenario: scenario,
+		},
+	)
+
+	return sim, nil
+}
+
+// createZabSimulation creates a Zab atomic broadcast simulation
+func (m *Manager) createZabSimulation(scenario string, config protocol.StartSimulationRequest) (ProjectSimulation, error) {
+	if scenario == "" {
+		scenario = "broadcast"
+	}
+
+	sim := zab.NewSimulation(
+		m.engine,
+		m.transport,
+		m.BroadcastMessage,
+		zab.Config{
+			NodeCount: config.Config.NodeCount,
+			Scenario:  scenario,
+		},
+	)
+
+	return sim, nil
+}
+
+// createEPaxosSimulation creates an EPaxos vs leader-based geo latency simulation
+func (m *Manager) createEPaxosSimulation(scenario string, config protocol.StartSimulationRequest) (ProjectSimulation, error) {
+	if scenario == "" {
+		scenario = "epaxos"
+	}
+
+	sim := epaxos.NewSimulation(
+		m.engine,
+		m.transport,
+		m.BroadcastMessage,
+		epaxos.Config{
+			NodeCount: config.Config.NodeCount,
+			Scenario:  scenario,
+		},
+	)
+
+	return sim, nil
+}
+
+// createStateMachineSimulation creates a replicated key-value state machine simulation
+func (m *Manager) createStateMachineSimulation(scenario string, config protocol.StartSimulationRequest) (ProjectSimulation, error) {
+	if scenario == "" {
+		scenario = "ordered"
+	}
+
+	sim := statemachine.NewSimulation(
+		m.engine,
+		m.transport,
+		m.BroadcastMessage,
+		statemachine.Config{
+			NodeCount: config.Config.NodeCount,
+			Scenario:  scenario,
+			Consensus: "sequencer",
+		},
+	)
+
+	return sim, nil
+}
+
+// createLocksSimulation creates a lock service simulation with deadlock detection
+func (m *Manager) createLocksSimulation(scenario string, config protocol.StartSimulationRequest) (ProjectSimulation, error) {
+	if scenario == "" {
+		scenario = "deadlock"
+	}
+
+	sim := locks.NewSimulation(
+		m.engine,
+		m.transport,
+		m.BroadcastMessage,
+		locks.Config{
+			NodeCount: config.Config.NodeCount,
+			Scenario:  scenario,
+		},
+	)
+
+	return sim, nil
+}
+
+// createRefCountSimulation creates a distributed reference counting simulation
+func (m *Manager) createRefCountSimulation(scenario string, config protocol.StartSimulationRequest) (ProjectSimulation, error) {
+	if scenario == "" {
+		scenario = "naive"
+	}
+
+	sim := refcount.NewSimulation(
+		m.engine,
+		m.transport,
+		m.BroadcastMessage,
+		refcount.Config{
+			NodeCount: config.Config.NodeCount,
+			Scenario:  scenario,
+		},
+	)
+
+	return sim, nil
+}
+
+// createMutexSimulation creates a Ricart-Agrawala mutual exclusion simulation
+func (m *Manager) createMutexSimulation(scenario string, config protocol.StartSimulationRequest) (ProjectSimulation, error) {
+	if scenario == "" {
+		scenario = "contention"
+	}
+
+	sim := mutex.NewSimulation(
+		m.engine,
+		m.transport,
+		m.BroadcastMessage,
+		mutex.Config{
+			NodeCount: config.Config.NodeCount,
+			Scenario:  scenario,
+		},
+	)
+
+	return sim, nil
+}
+
+// createStabilizationSimulation creates a self-stabilizing token ring simulation
+func (m *Manager) createStabilizationSimulation(scenario string, config protocol.StartSimulationRequest) (ProjectSimulation, error) {
+	if scenario == "" {
+		scenario = "corrupted"
+	}
+
+	sim := stabilization.NewSimulation(
+		m.engine,
+		m.transport,
+		m.BroadcastMessage,
+		stabilization.Config{
+			NodeCount: config.Config.NodeCount,
+			Scenario:  scenario,
+		},
+	)
+
+	return sim, nil
+}
+
+// createElectionSimulation creates a Bully leader election simulation
+func (m *Manager) createElectionSimulation(scenario string, config protocol.StartSimulationRequest) (ProjectSimulation, error) {
+	if scenario == "" {
+		scenario = "leader_crash"
+	}
+
+	sim := election.NewSimulation(
+		m.engine,
+		m.transport,
+		m.BroadcastMessage,
+		election.Config{
+			NodeCount: config.Config.NodeCount,
+			Scenario:  scenario,
+		},
+	)
+
+	return sim, nil
+}
+
+// createChordSimulation creates a Chord DHT simulation
+func (m *Manager) createChordSimulation(scenario string, config protocol.StartSimulationRequest) (ProjectSimulation, error) {
+	if scenario == "" {
+		scenario = "joins"
+	}
+
+	sim := chord.NewSimulation(
+		m.engine,
+		m.transport,
+		m.BroadcastMessage,
+		chord.Config{
+			NodeCount: config.Config.NodeCount,
+			Scenario:  scenario,
+		},
+	)
+
+	return sim, nil
+}
+
+// createHashRingSimulation creates a consistent hashing simulation
+func (m *Manager) createHashRingSimulation(scenario string, config protocol.StartSimulationRequest) (ProjectSimulation, error) {
+	if scenario == "" {
+		scenario = "add_remove"
+	}
+
+	sim := hashring.NewSimulation(
+		m.engine,
+		m.transport,
+		m.BroadcastMessage,
+		hashring.Config{
+			NodeCount: config.Config.NodeCount,
+			Scenario:  scenario,
+		},
+	)
+
+	return sim, nil
+}
+
+// createQuorumSimulation creates a quorum key-value store simulation
+func (m *Manager) createQuorumSimulation(scenario string, config protocol.StartSimulationRequest) (ProjectSimulation, error) {
+	if scenario == "" {
+		scenario = "strict"
+	}
+
+	sim := quorum.NewSimulation(
+		m.engine,
+		m.transport,
+		m.BroadcastMessage,
+		quorum.Config{
+			NodeCount: config.Config.NodeCount,
+			Scenario:  scenario,
+		},
+	)
+
+	return sim, nil
+}
+
+// createChainSimulation creates a chain replication simulation
+func (m *Manager) createChainSimulation(scenario string, config protocol.StartSimulationRequest) (ProjectSimulation, error) {
+	if scenario == "" {
+		scenario = "chain"
+	}
+
+	sim := chain.NewSimulation(
+		m.engine,
+		m.transport,
+		m.BroadcastMessage,
+		chain.Config{
+			NodeCount: config.Config.NodeCount,
+			Scenario:  scenario,
+		},
+	)
+
+	return sim, nil
+}
+
+// createTrueTimeSimulation creates a TrueTime commit wait simulation
+func (m *Manager) createTrueTimeSimulation(scenario string, config protocol.StartSimulationRequest) (ProjectSimulation, error) {
+	if scenario == "" {
+		scenario = "commit_wait"
+	}
+
+	sim := truetime.NewSimulation(
+		m.engine,
+		m.transport,
+		m.BroadcastMessage,
+		truetime.Config{
+			NodeCount: config.Config.NodeCount,
+			Scenario:  scenario,
+		},
+	)
+
+	return sim, nil
+}
+
+// createClockSyncSimulation creates a clock synchronization simulation
+func (m *Manager) createClockSyncSimulation(scenario string, config protocol.StartSimulationRequest) (ProjectSimulation, error) {
+	if scenario == "" {
+		scenario = "cristian"
+	}
+
+	sim := clocksync.NewSimulation(
+		m.engine,
+		m.transport,
+		m.BroadcastMessage,
+		clocksync.Config{
+			NodeCount: config.Config.NodeCount,
+			Scenario:  scenario,
+		},
+	)
+
+	return sim, nil
+}
+
+// createCAPSimulation creates a CAP theorem simulation; it always has two
+// replicas
+func (m *Manager) createCAPSimulation(scenario string, config protocol.StartSimulationRequest) (ProjectSimulation, error) {
+	if scenario == "" {
+		scenario = "available"
+	}
+
+	sim := cap.NewSimulation(
+		m.engine,
+		m.transport,
+		m.BroadcastMessage,
+		cap.Config{
+			Scenario: scenario,
+		},
+	)
+
+	return sim, nil
+}
+
+// createPercolatorSimulation creates a Percolator transactions simulation;
+// the node count is the number of tablet servers
+func (m *Manager) createPercolatorSimulation(scenario string, config protocol.StartSimulationRequest) (ProjectSimulation, error) {
+	if scenario == "" {
+		scenario = "transfers"
+	}
+
+	sim := percolator.NewSimulation(
+		m.engine,
+		m.transport,
+		m.BroadcastMessage,
+		percolator.Config{
+			NodeCount: config.Config.NodeCount,
+			Scenario:  scenario,
+		},
+	)
+
+	return sim, nil
+}
+
+// createCalvinSimulation creates a Calvin deterministic transactions
+// simulation; the node count is the number of partitions
+func (m *Manager) createCalvinSimulation(scenario string, config protocol.StartSimulationRequest) (ProjectSimulation, error) {
+	if scenario == "" {
+		scenario = "transfers"
+	}
+
+	sim := calvin.NewSimulation(
+		m.engine,
+		m.transport,
+		m.BroadcastMessage,
+		calvin.Config{
+			NodeCount: config.Config.NodeCount,
+			Scenario:  scenario,
+		},
+	)
+
+	return sim, nil
+}
+
+// createHotStuffSimulation creates a HotStuff BFT consensus simulation
+func (m *Manager) createHotStuffSimulation(scenario string, config protocol.StartSimulationRequest) (ProjectSimulation, error) {
+	if scenario == "" {
+		scenario = "steady"
+	}
+
+	sim := hotstuff.NewSimulation(
+		m.engine,
+		m.transport,
+		m.BroadcastMessage,
+		hotstuff.Config{
+			NodeCount: config.Config.NodeCount,
+			Scenario:  scenario,
+		},
+	)
+
+	return sim, nil
+}
+
+// createNakamotoSimulation creates a Nakamoto consensus simulation
+func (m *Manager) createNakamotoSimulation(scenario string, config protocol.StartSimulationRequest) (ProjectSimulation, error) {
+	if scenario == "" {
+		scenario = "steady"
+	}
+
+	sim := nakamoto.NewSimulation(
+		m.engine,
+		m.transport,
+		m.BroadcastMessage,
+		nakamoto.Config{
+			NodeCount: config.Config.NodeCount,
+			Scenario:  scenario,
+		},
+	)
+
+	return sim, nil
+}
+
+// createSWIMSimulation creates a SWIM membership simulation
+func (m *Manager) createSWIMSimulation(scenario string, config protocol.StartSimulationRequest) (ProjectSimulation, error) {
+	if scenario == "" {
+		scenario = "crash"
+	}
+
+	sim := swim.NewSimulation(
+		m.engine,
+		m.transport,
+		m.BroadcastMessage,
+		swim.Config{
+			NodeCount: config.Config.NodeCount,
+			Scenario:  scenario,
+		},
+	)
+
+	return sim, nil
+}
+
+// createPhiAccrualSimulation creates a phi accrual failure detector simulation
+func (m *Manager) createPhiAccrualSimulation(scenario string, config protocol.StartSimulationRequest) (ProjectSimulation, error) {
+	if scenario == "" {
+		scenario = "latency_spike"
+	}
+
+	sim := phiaccrual.NewSimulation(
+		m.engine,
+		m.transport,
+		m.BroadcastMessage,
+		phiaccrual.Config{
+			NodeCount: config.Config.NodeCount,
+			Scenario:  scenario,
+		},
+	)
+
+	return sim, nil
+}
+
+// createHeartbeatSimulation creates a heartbeat failure detector simulation
+func (m *Manager) createHeartbeatSimulation(scenario string, config protocol.StartSimulationRequest) (ProjectSimulation, error) {
+	if scenario == "" {
+		scenario = "steady"
+	}
+
+	sim := heartbeat.NewSimulation(
+		m.engine,
+		m.transport,
+		m.BroadcastMessage,
+		heartbeat.Config{
+			NodeCount: config.Config.NodeCount,
+			Scenario:  scenario,
+		},
+	)
+
+	return sim, nil
+}
+
+// createSessionsSimulation creates a session guarantees simulation
+func (m *Manager) createSessionsSimulation(scenario string, config protocol.StartSimulationRequest) (ProjectSimulation, error) {
+	if scenario == "" {
+		scenario = "eventual"
+	}
+
+	sim := sessions.NewSimulation(
+		m.engine,
+		m.transport,
+		m.BroadcastMessage,
+		sessions.Config{
+			NodeCount: config.Config.NodeCount,
+			Scenario:  scenario,
+		},
+	)
+
+	return sim, nil
+}
+
+// createLoadBalancingSimulation creates a load balancing simulation
+func (m *Manager) createLoadBalancingSimulation(scenario string, config protocol.StartSimulationRequest) (ProjectSimulation, error) {
+	if scenario == "" {
+		scenario = "random"
+	}
+
+	sim := loadbalancing.NewSimulation(
+		m.engine,
+		m.transport,
+		m.BroadcastMessage,
+		loadbalancing.Config{
+			NodeCount: config.Config.NodeCount,
+			Scenario:  scenario,
+		},
+	)
+
+	return sim, nil
+}
+
+// createMapReduceSimulation creates a MapReduce simulation
+func (m *Manager) createMapReduceSimulation(scenario string, config protocol.StartSimulationRequest) (ProjectSimulation, error) {
+	if scenario == "" {
+		scenario = "steady"
+	}
+
+	sim := mapreduce.NewSimulation(
+		m.engine,
+		m.transport,
+		m.BroadcastMessage,
+		mapreduce.Config{
+			NodeCount: config.Config.NodeCount,
+			Scenario:  scenario,
+		},
+	)
+
+	return sim, nil
+}
+
+// createEscrowSimulation creates an escrow counter simulation
+func (m *Manager) createEscrowSimulation(scenario string, config protocol.StartSimulationRequest) (ProjectSimulation, error) {
+	if scenario == "" {
+		scenario = "escrow"
+	}
+
+	sim := escrow.NewSimulation(
+		m.engine,
+		m.transport,
+		m.BroadcastMessage,
+		escrow.Config{
+			NodeCount: config.Config.NodeCount,
+			Scenario:  scenario,
+		},
+	)
+
+	return sim, nil
+}
+
+// createTwoPhaseLockingSimulation creates a two-phase locking simulation
+func (m *Manager) createTwoPhaseLockingSimulation(scenario string, config protocol.StartSimulationRequest) (ProjectSimulation, error) {
+	if scenario == "" {
+		scenario = "wait_die"
+	}
+
+	sim := twophaselocking.NewSimulation(
+		m.engine,
+		m.transport,
+		m.BroadcastMessage,
+		twophaselocking.Config{
+			NodeCount: config.Config.NodeCount,
+			Scenario:  scenario,
+		},
+	)
+
+	return sim, nil
+}
+
+// createRaftSimulation creates a Raft simulation
+func (m *Manager) createRaftSimulation(scenario string, config protocol.StartSimulationRequest) (ProjectSimulation, error) {
+	if scenario == "" {
+		scenario = "rejoin"
+	}
+
+	sim := raft.NewSimulation(
+		m.engine,
+		m.transport,
+		m.BroadcastMessage,
+		raft.Config{
+			NodeCount: config.Config.NodeCount,
+			Scenario:  scenario,
+		},
+	)
+
+	return sim, nil
+}
+
 // createDemoSimulation creates a demo simulation for unimplemented projects
 func (m *Manager) createDemoSimulation(project string, config protocol.StartSimulationRequest) (ProjectSimulation, error) {
 	nodeCount := config.Config.NodeCount
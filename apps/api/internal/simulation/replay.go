@@ -0,0 +1,165 @@
+package simulation
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+)
+
+const (
+	// replayWindow is how far back an instant replay can go
+	replayWindow = 60 * time.Second
+	// maxReplayMessages bounds the messages kept for replays, so a busy
+	// run keeps less than replayWindow
+	maxReplayMessages = 5000
+	// defaultReplaySeconds and defaultReplaySpeed apply when the client
+	// does not choose
+	defaultReplaySeconds = 10
+	defaultReplaySpeed   = 0.5
+)
+
+// recordedMessage is a message as it was broadcast
+type recordedMessage struct {
+	at   time.Time
+	data json.RawMessage
+}
+
+// replayRecorder is the broadcaster the manager sends through. It keeps
+// what was broadcast during the last replayWindow so an instant replay
+// can show it again, wrapped so clients never take it for live messages.
+type replayRecorder struct {
+	Broadcaster
+
+	mu        sync.Mutex
+	messages  []recordedMessage
+	replaying bool
+	replays   int
+}
+
+func newReplayRecorder(broadcaster Broadcaster) *replayRecorder {
+	return &replayRecorder{Broadcaster: broadcaster}
+}
+
+// BroadcastJSON records a message and broadcasts it
+func (r *replayRecorder) BroadcastJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err == nil {
+		r.record(data)
+	}
+	return r.Broadcaster.BroadcastJSON(v)
+}
+
+func (r *replayRecorder) record(data json.RawMessage) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	r.messages = append(r.messages, recordedMessage{at: now, data: data})
+	drop := max(len(r.messages)-maxReplayMessages, 0)
+	for drop < len(r.messages) && now.Sub(r.messages[drop].at) > replayWindow {
+		drop++
+	}
+	// Appending past the capacity copies only the messages kept
+	r.messages = r.messages[drop:]
+}
+
+// since returns the messages recorded during the last window
+func (r *replayRecorder) since(window time.Duration) []recordedMessage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	from := time.Now().Add(-window)
+	for i, msg := range r.messages {
+		if !msg.at.Before(from) {
+			return append([]recordedMessage{}, r.messages[i:]...)
+		}
+	}
+	return nil
+}
+
+// InstantReplay broadcasts again the messages of the last seconds, at
+// speed, to the same clients. The run goes on meanwhile; one replay runs
+// at a time.
+func (m *Manager) InstantReplay(seconds, speed float64) error {
+	if seconds == 0 {
+		seconds = defaultReplaySeconds
+	}
+	if speed == 0 {
+		speed = defaultReplaySpeed
+	}
+	window := time.Duration(seconds * float64(time.Second))
+	if seconds < 0 || window > replayWindow {
+		return fmt.Errorf("replay window must be between 0 and %v, got %gs", replayWindow, seconds)
+	}
+	if speed < 0.1 || speed > 1 {
+		return fmt.Errorf("replay speed must be between 0.1 and 1, got %g", speed)
+	}
+
+	messages := m.replay.since(window)
+	if len(messages) == 0 {
+		return fmt.Errorf("nothing broadcast in the last %gs", seconds)
+	}
+
+	r := m.replay
+	r.mu.Lock()
+	if r.replaying {
+		r.mu.Unlock()
+		return fmt.Errorf("a replay is already running")
+	}
+	r.replaying = true
+	r.replays++
+	id := r.replays
+	r.mu.Unlock()
+
+	go r.play(id, messages, seconds, speed)
+	return nil
+}
+
+// play sends a replay, spacing the messages as they were spaced when
+// recorded, stretched by 1/speed
+func (r *replayRecorder) play(id int, messages []recordedMessage, seconds, speed float64) {
+	defer func() {
+		r.mu.Lock()
+		r.replaying = false
+		r.mu.Unlock()
+	}()
+
+	status := &protocol.ReplayStatusResponse{
+		Type:     protocol.MsgReplayStatus,
+		ReplayID: id,
+		Status:   "started",
+		Seconds:  seconds,
+		Speed:    speed,
+		Messages: len(messages),
+	}
+	// Sent past the recorder: a replay is never replayed
+	if err := r.Broadcaster.BroadcastJSON(status); err != nil {
+		log.Printf("Error broadcasting replay status: %v", err)
+	}
+
+	start := messages[0].at
+	began := time.Now()
+	for _, msg := range messages {
+		offset := msg.at.Sub(start)
+		time.Sleep(time.Until(began.Add(time.Duration(float64(offset) / speed))))
+		event := &protocol.ReplayEventResponse{
+			Type:     protocol.MsgReplayEvent,
+			ReplayID: id,
+			Replay:   true,
+			OffsetMs: offset.Milliseconds(),
+			Message:  msg.data,
+		}
+		if err := r.Broadcaster.BroadcastJSON(event); err != nil {
+			log.Printf("Error broadcasting replay event: %v", err)
+		}
+	}
+
+	status.Status = "finished"
+	if err := r.Broadcaster.BroadcastJSON(status); err != nil {
+		log.Printf("Error broadcasting replay status: %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package simulation
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"sync"
+)
+
+// sequencer numbers what the manager broadcasts, whichever path it came
+// by: the engine's events, the projects' messages, the transport's drops.
+// Numbers go up by one in the order clients receive the messages, so they
+// can sort them, drop duplicates and tell when they missed some.
+type sequencer struct {
+	Broadcaster
+
+	mu   sync.Mutex
+	last uint64
+}
+
+func newSequencer(broadcaster Broadcaster) *sequencer {
+	return &sequencer{Broadcaster: broadcaster}
+}
+
+// BroadcastJSON numbers a message and broadcasts it
+func (s *sequencer) BroadcastJSON(v interface{}) error {
+	return s.broadcast(func(uint64) interface{} { return v })
+}
+
+// broadcast numbers the next message, which message builds knowing its
+// number, and broadcasts it before any other is numbered
+func (s *sequencer) broadcast(message func(sequence uint64) interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := json.Marshal(message(s.last + 1))
+	if err != nil {
+		return err
+	}
+	if err := s.Broadcaster.BroadcastJSON(json.RawMessage(withSequence(data, s.last+1))); err != nil {
+		return err
+	}
+	s.last++
+	return nil
+}
+
+// withSequence adds the sequence field to a JSON object
+func withSequence(data []byte, sequence uint64) []byte {
+	if len(data) < 2 || data[0] != '{' {
+		return data
+	}
+	field := `"sequence":` + strconv.FormatUint(sequence, 10)
+	if len(bytes.TrimSpace(data[1:len(data)-1])) == 0 {
+		return []byte("{" + field + "}")
+	}
+	return append([]byte("{"+field+","), data[1:]...)
+}
@@ -0,0 +1,72 @@
+package simulation
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+	"github.com/ersantana/distributed-systems-learning/packages/simulation/engine"
+)
+
+// StepEvent runs a single event of a run in step mode or paused, the next
+// one due when seq is 0. Any other runs at once, ahead of those due before
+// it, which delivers one message while the others wait. A tick still
+// ticks every node.
+func (m *Manager) StepEvent(seq uint64) error {
+	m.mu.RLock()
+	eng := m.engine
+	running := m.simulation != nil
+	m.mu.RUnlock()
+
+	if eng == nil || !running {
+		return fmt.Errorf("no simulation running")
+	}
+	if mode := eng.GetMode(); mode != engine.ModeStepByStep && mode != engine.ModePaused {
+		return fmt.Errorf("single events are stepped through in step mode or paused, not %s", mode)
+	}
+	pending := eng.Pending()
+	if len(pending) == 0 {
+		return fmt.Errorf("no event is waiting")
+	}
+	if seq == 0 {
+		seq = pending[0].Seq
+	}
+	waiting := false
+	for _, ev := range pending {
+		waiting = waiting || ev.Seq == seq
+	}
+	if !waiting {
+		return fmt.Errorf("no event %d is waiting", seq)
+	}
+
+	m.mu.RLock()
+	m.recordInput(protocol.CheckpointInput{Kind: "step_event", Seq: seq})
+	m.mu.RUnlock()
+
+	// Without the lock: the event run may need it
+	eng.RunPending(seq)
+
+	m.mu.RLock()
+	m.broadcastState()
+	m.mu.RUnlock()
+	return nil
+}
+
+// pendingEvents lists the events waiting to run, with the message of each
+// delivery
+func pendingEvents(eng *engine.Engine) []protocol.PendingEvent {
+	pending := eng.Pending()
+	events := make([]protocol.PendingEvent, len(pending))
+	for i, ev := range pending {
+		events[i] = protocol.PendingEvent{Seq: ev.Seq, AtNs: ev.AtNs, Kind: ev.Label}
+		// Deliveries are labeled "deliver <type> <from>-><to>"
+		fields := strings.Fields(ev.Label)
+		if len(fields) != 3 || fields[0] != "deliver" {
+			continue
+		}
+		events[i].Kind = "deliver"
+		events[i].MessageType = fields[1]
+		events[i].From, events[i].To, _ = strings.Cut(fields[2], "->")
+	}
+	return events
+}
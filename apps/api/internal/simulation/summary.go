@@ -0,0 +1,361 @@
+package simulation
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+	"github.com/ersantana/distributed-systems-learning/packages/visualization/events"
+)
+
+const (
+	// keyEventMaxCount is how often an event type may occur in a run for
+	// its events to count as key events; anything more frequent is part
+	// of the protocol's routine and only shows up in the counts
+	keyEventMaxCount = 10
+	// maxKeyEvents and maxFaults bound what a summary lists
+	maxKeyEvents = 50
+	maxFaults    = 100
+)
+
+// faultEvents are the event types recorded as faults: injected from the
+// client, by a fault schedule or by the project's own script
+var faultEvents = map[string]bool{
+	"node_crashed":        true,
+	"node_recovered":      true,
+	"partition_created":   true,
+	"partition_healed":    true,
+	"cluster_partitioned": true,
+	"partitions_healed":   true,
+	"node_delayed":        true,
+	"corruption_injected": true,
+	"client_request":      true,
+}
+
+// routineEvents are never key events, however rare
+var routineEvents = map[string]bool{
+	"simulation_tick":    true,
+	"simulation_paused":  true,
+	"simulation_resumed": true,
+	"simulation_stopped": true,
+}
+
+// SummaryProvider is implemented by project simulations that can judge a
+// run: which of their invariants held, and which scenarios are worth
+// running next given how this one went
+type SummaryProvider interface {
+	Invariants() []protocol.InvariantResult
+	FollowUps() []protocol.FollowUp
+}
+
+// runLog is the event store of a run. It counts every event by type and
+// keeps the events of each type until the type turns out to be frequent,
+// so its size does not grow with the length of the run.
+type runLog struct {
+	mu sync.Mutex
+
+	project   string
+	scenario  string
+	network   *protocol.NetworkPreset
+	startedAt time.Time
+
+	counts map[string]int
+	events []protocol.TimelineEvent // Events of types seen at most keyEventMaxCount times
+	faults []protocol.TimelineEvent
+
+	endedBy string // Why the run stopped, when not by hand
+}
+
+func newRunLog(project, scenario string, config protocol.StartSimulationRequest) *runLog {
+	return &runLog{
+		project:   project,
+		scenario:  scenario,
+		network:   config.Network,
+		startedAt: time.Now(),
+		counts:    make(map[string]int),
+	}
+}
+
+// record stores an event emitted by the engine, the injector or the manager
+func (r *runLog) record(eventType string, data map[string]interface{}) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.counts[eventType]++
+	if eventType == string(protocol.MsgMessageDropped) {
+		return
+	}
+
+	event := protocol.TimelineEvent{Time: time.Now().UnixMilli(), Type: eventType, Data: data}
+	if faultEvents[eventType] && len(r.faults) < maxFaults {
+		r.faults = append(r.faults, event)
+	}
+	if r.counts[eventType] <= keyEventMaxCount {
+		r.events = append(r.events, event)
+	}
+}
+
+// recordMessage stores a message a project broadcast to the clients
+func (r *runLog) recordMessage(msg interface{}) {
+	switch m := msg.(type) {
+	case *protocol.MessageEventResponse:
+		if r != nil {
+			r.mu.Lock()
+			r.counts[string(m.Type)]++
+			r.mu.Unlock()
+		}
+	case map[string]interface{}:
+		if eventType, ok := m["type"].(string); ok {
+			data := make(map[string]interface{}, len(m))
+			for k, v := range m {
+				if k != "type" {
+					data[k] = v
+				}
+			}
+			r.record(eventType, data)
+		}
+	case events.Event:
+		r.record(string(m.EventType()), m.Data())
+	}
+}
+
+// eventCounts returns how many events of each type were recorded
+func (r *runLog) eventCounts() map[string]int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	counts := make(map[string]int, len(r.counts))
+	for eventType, count := range r.counts {
+		counts[eventType] = count
+	}
+	return counts
+}
+
+// keyEventsSince returns the key events recorded from wall time t, in
+// Unix milliseconds
+func (r *runLog) keyEventsSince(t int64) []protocol.TimelineEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	events := make([]protocol.TimelineEvent, 0)
+	for _, event := range r.events {
+		if event.Time >= t {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// count returns how many events of a type were recorded
+func (r *runLog) count(eventType string) int64 {
+	if r == nil {
+		return 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return int64(r.counts[eventType])
+}
+
+// completed announces that the run is over when the engine tells so
+func (r *runLog) completed(eventType string, data map[string]interface{}) *protocol.SimulationCompletedResponse {
+	if r == nil || eventType != "simulation_completed" {
+		return nil
+	}
+	outcome, _ := data["outcome"].(string)
+	elapsedMs, _ := data["elapsedMs"].(int64)
+	rounds, _ := data["rounds"].(int)
+	seed, _ := data["seed"].(int64)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return &protocol.SimulationCompletedResponse{
+		Type:              protocol.MsgSimulationCompleted,
+		Project:           r.project,
+		Scenario:          r.scenario,
+		Seed:              seed,
+		Outcome:           outcome,
+		ElapsedMs:         elapsedMs,
+		Rounds:            rounds,
+		MessagesSent:      r.counts[string(protocol.MsgMessageSent)],
+		MessagesDelivered: r.counts[string(protocol.MsgMessageReceived)],
+		MessagesDropped:   r.counts[string(protocol.MsgMessageDropped)],
+	}
+}
+
+// end records why the run stopped, when it stopped on its own
+func (r *runLog) end(reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.endedBy = reason
+}
+
+// size returns the events recorded so far and how many of them are held
+func (r *runLog) size() (recorded, stored int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, count := range r.counts {
+		recorded += count
+	}
+	return recorded, len(r.events) + len(r.faults)
+}
+
+// summarize assembles the run summary from the log, the final state of
+// the project, the invariants the run was checked against and the
+// project's own judgement of the run, which lasted duration of virtual
+// time
+func (r *runLog) summarize(sim ProjectSimulation, state *protocol.SimulationStateResponse, checked []protocol.InvariantResult, duration time.Duration) *protocol.RunSummaryResponse {
+	r.mu.Lock()
+	counts := make(map[string]int, len(r.counts))
+	for eventType, count := range r.counts {
+		counts[eventType] = count
+	}
+	keyEvents := make([]protocol.TimelineEvent, 0)
+	for _, event := range r.events {
+		if counts[event.Type] <= keyEventMaxCount && !routineEvents[event.Type] && len(keyEvents) < maxKeyEvents {
+			keyEvents = append(keyEvents, event)
+		}
+	}
+	faults := append([]protocol.TimelineEvent{}, r.faults...)
+	endedBy := r.endedBy
+	r.mu.Unlock()
+
+	invariants := append(make([]protocol.InvariantResult, 0), checked...)
+	followUps := make([]protocol.FollowUp, 0)
+	if provider, ok := sim.(SummaryProvider); ok {
+		invariants = append(invariants, provider.Invariants()...)
+		followUps = append(followUps, provider.FollowUps()...)
+	}
+
+	sent := counts[string(protocol.MsgMessageSent)]
+	dropped := counts[string(protocol.MsgMessageDropped)]
+	metrics := map[string]interface{}{
+		"durationMs":       duration.Milliseconds(),
+		"ticks":            counts["simulation_tick"],
+		"messagesSent":     sent,
+		"messagesReceived": counts[string(protocol.MsgMessageReceived)],
+		"messagesDropped":  dropped,
+		"eventCounts":      counts,
+	}
+	// The project's own headline numbers are its scalar metadata
+	for key, value := range state.Metadata {
+		switch value.(type) {
+		case int, int64, uint64, float64, bool, string:
+			if _, taken := metrics[key]; !taken {
+				metrics[key] = value
+			}
+		}
+	}
+
+	// Generic suggestions come after the project's, which know better
+	if len(faults) == 0 {
+		followUps = append(followUps, protocol.FollowUp{
+			Project:  r.project,
+			Scenario: r.scenario,
+			Reason:   "Nothing went wrong on purpose: run it again and crash a node or cut a link while it works",
+		})
+	}
+	if dropped == 0 && r.network == nil {
+		followUps = append(followUps, protocol.FollowUp{
+			Project:  r.project,
+			Scenario: r.scenario,
+			Reason:   "No message was lost: run it again on a lossy network preset",
+		})
+	}
+
+	return &protocol.RunSummaryResponse{
+		Type:       protocol.MsgRunSummary,
+		Project:    r.project,
+		Scenario:   r.scenario,
+		Seed:       state.Seed,
+		DurationMs: duration.Milliseconds(),
+		Narrative:  r.narrate(duration, counts, faults, invariants, endedBy),
+		KeyEvents:  keyEvents,
+		Faults:     faults,
+		Invariants: invariants,
+		Metrics:    metrics,
+		FollowUps:  followUps,
+		EndedBy:    endedBy,
+	}
+}
+
+// narrate tells the run in a few sentences
+func (r *runLog) narrate(duration time.Duration, counts map[string]int, faults []protocol.TimelineEvent, invariants []protocol.InvariantResult, endedBy string) []string {
+	name := r.project
+	if r.scenario != "" {
+		name += " (" + r.scenario + ")"
+	}
+	narrative := []string{fmt.Sprintf("Ran %s for %s, %d ticks.", name, duration.Round(100*time.Millisecond), counts["simulation_tick"])}
+
+	sent, dropped := counts[string(protocol.MsgMessageSent)], counts[string(protocol.MsgMessageDropped)]
+	line := fmt.Sprintf("%d messages were sent", sent)
+	if dropped > 0 {
+		line += fmt.Sprintf(" and %d dropped", dropped)
+	}
+	narrative = append(narrative, line+".")
+
+	if len(faults) == 0 {
+		narrative = append(narrative, "No faults were injected.")
+	} else {
+		kinds := make(map[string]int)
+		for _, fault := range faults {
+			kinds[fault.Type]++
+		}
+		narrative = append(narrative, fmt.Sprintf("Faults: %s.", countList(kinds)))
+	}
+
+	// The project's own events, without the message traffic and the engine's
+	own := make(map[string]int)
+	for eventType, count := range counts {
+		if !strings.HasPrefix(eventType, "message_") && !strings.HasPrefix(eventType, "simulation_") && !faultEvents[eventType] {
+			own[eventType] = count
+		}
+	}
+	if len(own) > 0 {
+		narrative = append(narrative, fmt.Sprintf("Events: %s.", countList(own)))
+	}
+
+	violated := make([]string, 0)
+	for _, invariant := range invariants {
+		if !invariant.Holds {
+			violated = append(violated, invariant.Name)
+		}
+	}
+	switch {
+	case len(invariants) == 0:
+	case len(violated) == 0:
+		narrative = append(narrative, fmt.Sprintf("All %d invariants held.", len(invariants)))
+	default:
+		narrative = append(narrative, fmt.Sprintf("%d of %d invariants were violated: %s.", len(violated), len(invariants), strings.Join(violated, ", ")))
+	}
+
+	switch endedBy {
+	case "timeout":
+		narrative = append(narrative, "The run reached its limit and stopped itself.")
+	case "abandoned":
+		narrative = append(narrative, "Every client had left, so the run was stopped.")
+	}
+	return narrative
+}
+
+// countList renders counts as "a (3), b (1)", most frequent first
+func countList(counts map[string]int) string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		parts[i] = fmt.Sprintf("%s (%d)", key, counts[key])
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,58 @@
+package simulation
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+)
+
+// summaryCatcher is a Broadcaster that keeps the run summaries it is sent
+type summaryCatcher struct {
+	mu        sync.Mutex
+	summaries []*protocol.RunSummaryResponse
+}
+
+func (c *summaryCatcher) BroadcastJSON(v interface{}) error {
+	data, ok := v.(json.RawMessage)
+	if !ok {
+		return nil
+	}
+	var summary protocol.RunSummaryResponse
+	if err := json.Unmarshal(data, &summary); err != nil || summary.Type != protocol.MsgRunSummary {
+		return nil
+	}
+	c.mu.Lock()
+	c.summaries = append(c.summaries, &summary)
+	c.mu.Unlock()
+	return nil
+}
+
+func TestRunSummaryDurationIsVirtual(t *testing.T) {
+	const steps = 300
+
+	catcher := &summaryCatcher{}
+	m := NewManager(catcher)
+	if err := m.Start("raft", "", protocol.StartSimulationRequest{Config: protocol.SimulationConfig{StepMode: true, Seed: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.StepN(steps, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	catcher.mu.Lock()
+	defer catcher.mu.Unlock()
+	if len(catcher.summaries) != 1 {
+		t.Fatalf("got %d run summaries, want 1", len(catcher.summaries))
+	}
+	summary := catcher.summaries[0]
+	want := (steps * 100 * time.Millisecond).Milliseconds() // One tick of 100ms a step
+	if summary.DurationMs != want || summary.Metrics["durationMs"] != float64(want) {
+		t.Fatalf("got a duration of %dms (metric %v), want %dms", summary.DurationMs, summary.Metrics["durationMs"], want)
+	}
+}
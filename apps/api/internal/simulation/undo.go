@@ -0,0 +1,223 @@
+package simulation
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+)
+
+// manualFault is a fault injected from the client, with what it takes to
+// revert it
+type manualFault struct {
+	kind          string // "crash", "partition", "groups" or "delay"
+	nodeID        string // Crashes and delays
+	from          string // Partitions
+	to            string
+	bidirectional bool
+	groups        [][]string    // Groups: the sides the cluster was split into
+	delay         time.Duration // Delays: the delay injected
+	previous      time.Duration // Delays: the delay it replaced
+}
+
+// links returns the directed links a partition cuts
+func (f manualFault) links() []linkKey {
+	if f.kind == "groups" {
+		var links []linkKey
+		for i, group := range f.groups {
+			for _, other := range f.groups[i+1:] {
+				for _, a := range group {
+					for _, b := range other {
+						links = append(links, linkKey{from: a, to: b}, linkKey{from: b, to: a})
+					}
+				}
+			}
+		}
+		return links
+	}
+	links := []linkKey{{from: f.from, to: f.to}}
+	if f.bidirectional {
+		links = append(links, linkKey{from: f.to, to: f.from})
+	}
+	return links
+}
+
+func (f manualFault) data() map[string]interface{} {
+	data := map[string]interface{}{"kind": f.kind}
+	switch f.kind {
+	case "crash":
+		data["nodeId"] = f.nodeID
+	case "partition":
+		data["from"] = f.from
+		data["to"] = f.to
+		data["bidirectional"] = f.bidirectional
+	case "groups":
+		data["groups"] = f.groups
+	case "delay":
+		data["nodeId"] = f.nodeID
+		data["delayMs"] = f.delay.Milliseconds()
+		data["previousDelayMs"] = f.previous.Milliseconds()
+	}
+	return data
+}
+
+// faultStack holds the manual faults of a run still in effect, the most
+// recent last, so they can be undone one at a time
+type faultStack struct {
+	mu     sync.Mutex
+	faults []manualFault
+}
+
+func newFaultStack() *faultStack {
+	return &faultStack{}
+}
+
+func (s *faultStack) push(fault manualFault) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, fault)
+}
+
+// pop removes the most recent fault
+func (s *faultStack) pop() (manualFault, bool) {
+	if s == nil {
+		return manualFault{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.faults) == 0 {
+		return manualFault{}, false
+	}
+	fault := s.faults[len(s.faults)-1]
+	s.faults = s.faults[:len(s.faults)-1]
+	return fault, true
+}
+
+// forget drops the faults a manual recovery or heal reverted already, so
+// undoing never reverts a fault twice
+func (s *faultStack) forget(reverted func(manualFault) bool) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := s.faults[:0]
+	for _, fault := range s.faults {
+		if !reverted(fault) {
+			kept = append(kept, fault)
+		}
+	}
+	s.faults = kept
+}
+
+func (s *faultStack) size() int {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.faults)
+}
+
+// forgetRecovery drops the crashes of a node recovered by hand
+func (m *Manager) forgetRecovery(nodeID string) {
+	m.failures.Load().forget(func(f manualFault) bool {
+		return f.kind == "crash" && f.nodeID == nodeID
+	})
+}
+
+// forgetHeal drops the partitions whose every link a manual heal restored
+func (m *Manager) forgetHeal(from, to string, bidirectional bool) {
+	healed := make(map[linkKey]bool)
+	for _, link := range (manualFault{from: from, to: to, bidirectional: bidirectional}).links() {
+		healed[link] = true
+	}
+	m.failures.Load().forget(func(f manualFault) bool {
+		if f.kind != "partition" && f.kind != "groups" {
+			return false
+		}
+		for _, link := range f.links() {
+			if !healed[link] {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// InjectDelay makes a node slow, or normal again with a zero delay
+func (m *Manager) InjectDelay(nodeID string, delay time.Duration) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.transport == nil || m.simulation == nil {
+		return fmt.Errorf("no simulation running")
+	}
+	if _, ok := m.simulation.GetNodes()[nodeID]; !ok {
+		return fmt.Errorf("unknown node: %s", nodeID)
+	}
+	previous := m.setNodeDelay(nodeID, delay)
+	m.failures.Load().push(manualFault{kind: "delay", nodeID: nodeID, delay: delay, previous: previous})
+	m.recordInput(protocol.CheckpointInput{Kind: "delay", NodeID: nodeID, DelayMs: delay.Milliseconds()})
+	m.broadcastState()
+	return nil
+}
+
+// setNodeDelay changes the delay of a node and returns the one it had
+// (must hold m.mu)
+func (m *Manager) setNodeDelay(nodeID string, delay time.Duration) time.Duration {
+	previous := m.transport.NodeDelay(nodeID)
+	if delay > 0 {
+		m.transport.SetNodeDelay(nodeID, delay)
+	} else {
+		m.transport.ClearNodeDelay(nodeID)
+	}
+	m.handleEvent("node_delayed", map[string]interface{}{
+		"nodeId":  nodeID,
+		"delayMs": delay.Milliseconds(),
+	})
+	return previous
+}
+
+// UndoLastFailure reverts the most recent manual fault still in effect:
+// it recovers a crashed node, heals a partition or a split into groups, or
+// restores the delay a node had
+func (m *Manager) UndoLastFailure() error {
+	fault, ok := m.failures.Load().pop()
+	if !ok {
+		return fmt.Errorf("no failure to undo")
+	}
+
+	var err error
+	switch fault.kind {
+	case "crash":
+		err = m.RecoverNode(fault.nodeID)
+	case "partition":
+		m.HealPartition(fault.from, fault.to, fault.bidirectional)
+	case "groups":
+		m.mu.RLock()
+		if m.transport != nil {
+			m.transport.HealGroups(fault.groups)
+			m.recordInput(protocol.CheckpointInput{Kind: "undo"})
+			m.broadcastState()
+		}
+		m.mu.RUnlock()
+	case "delay":
+		m.mu.RLock()
+		if m.transport != nil {
+			m.setNodeDelay(fault.nodeID, fault.previous)
+			m.recordInput(protocol.CheckpointInput{Kind: "undo"})
+			m.broadcastState()
+		}
+		m.mu.RUnlock()
+	}
+	if err != nil {
+		return err
+	}
+	m.handleEvent("failure_undone", fault.data())
+	return nil
+}
@@ -0,0 +1,83 @@
+package simulation
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+)
+
+// workloadSchedule makes the client requests of a run when its virtual
+// time reaches them. Being part of the run's request, they are made again
+// when the run is replayed, so they are not recorded as inputs.
+type workloadSchedule struct {
+	mu sync.Mutex
+
+	handler ClientRequestHandler
+	pending []scheduledRequest // Sorted by time
+}
+
+// scheduledRequest is the next request of a workload entry
+type scheduledRequest struct {
+	atMs int64
+	left int // Requests still to make after this one, <0 = no end
+	spec protocol.WorkloadSpec
+}
+
+func newWorkloadSchedule(handler ClientRequestHandler, workload []protocol.WorkloadSpec) *workloadSchedule {
+	ws := &workloadSchedule{handler: handler}
+	for _, spec := range workload {
+		left := 0
+		if spec.EveryMs > 0 {
+			left = spec.Count - 1
+		}
+		ws.pending = append(ws.pending, scheduledRequest{atMs: spec.AtMs, left: left, spec: spec})
+	}
+	ws.sort()
+	return ws
+}
+
+func (ws *workloadSchedule) sort() {
+	sort.SliceStable(ws.pending, func(i, j int) bool { return ws.pending[i].atMs < ws.pending[j].atMs })
+}
+
+// due removes and returns the requests whose time has come, scheduling
+// the next of those repeated
+func (ws *workloadSchedule) due(elapsedMs int64) []scheduledRequest {
+	if ws == nil {
+		return nil
+	}
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	n := 0
+	for n < len(ws.pending) && ws.pending[n].atMs <= elapsedMs {
+		n++
+	}
+	due := append([]scheduledRequest(nil), ws.pending[:n]...)
+	ws.pending = ws.pending[n:]
+	for _, req := range due {
+		if req.spec.EveryMs > 0 && req.left != 0 {
+			ws.pending = append(ws.pending, scheduledRequest{atMs: req.atMs + req.spec.EveryMs, left: req.left - 1, spec: req.spec})
+		}
+	}
+	ws.sort()
+	return due
+}
+
+// applyWorkload makes the client requests due at the virtual time of a
+// tick
+func (m *Manager) applyWorkload(elapsedMs int64) {
+	workload := m.workload.Load()
+	for _, req := range workload.due(elapsedMs) {
+		data := map[string]interface{}{
+			"command": req.spec.Command,
+			"payload": req.spec.Params,
+			"atMs":    req.atMs,
+		}
+		if err := workload.handler.HandleClientRequest(req.spec.Command, req.spec.Params); err != nil {
+			data["error"] = err.Error()
+		}
+		m.handleEvent("scheduled_request", data)
+	}
+}
@@ -0,0 +1,295 @@
+package soak
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+)
+
+const (
+	// DefaultWindow is how many consecutive samples of growth count as a leak
+	DefaultWindow = 20
+	// maxSamples bounds the history: six hours at one sample every 30s
+	maxSamples = 720
+)
+
+// Session describes the simulation run being soaked
+type Session struct {
+	ID           string `json:"id"`
+	Project      string `json:"project"`
+	Scenario     string `json:"scenario,omitempty"`
+	Events       int    `json:"events"`       // Events recorded since the run started
+	StoredEvents int    `json:"storedEvents"` // Events held in memory for the run summary
+}
+
+// Config wires the monitor to the application's own gauges
+type Config struct {
+	Interval time.Duration
+	Window   int  // Samples of uninterrupted growth that count as a leak
+	FailFast bool // Exit the process on the first leak
+
+	Clients      func() int
+	TimelineSize func() int
+	Session      func() (Session, bool)
+	Broadcast    func(interface{})
+}
+
+// Sample is one reading of the gauges
+type Sample struct {
+	Time         time.Time `json:"time"`
+	Goroutines   int       `json:"goroutines"`
+	HeapAlloc    uint64    `json:"heapAlloc"`
+	HeapObjects  uint64    `json:"heapObjects"`
+	Clients      int       `json:"clients"`
+	TimelineSize int       `json:"timelineSize"`
+	StoredEvents int       `json:"storedEvents"`
+	Session      string    `json:"session,omitempty"`
+	EventRate    float64   `json:"eventRate"` // Events per second since the previous sample
+	Drift        float64   `json:"drift"`     // Event rate relative to the session's first, minus one
+}
+
+// Failure is a gauge found growing at every sample of a window
+type Failure struct {
+	Time    time.Time `json:"time"`
+	Metric  string    `json:"metric"`
+	From    float64   `json:"from"`
+	To      float64   `json:"to"`
+	Samples int       `json:"samples"`
+	Session string    `json:"session,omitempty"` // For per-session metrics
+}
+
+// Monitor samples the process and the simulation on an interval for
+// hours-long runs and reports gauges that only ever grow. A leak rarely
+// shows in a single sample, but a goroutine count, a heap or a store
+// that rises at every one of Window samples in a row is not noise.
+type Monitor struct {
+	mu sync.RWMutex
+
+	config   Config
+	samples  []Sample
+	failures []Failure
+	failing  map[string]bool // Metrics currently growing, reported once
+
+	lastEvents   int
+	lastSession  string
+	baselineRate float64 // First non-zero event rate of the session
+}
+
+// NewMonitor creates a soak monitor
+func NewMonitor(config Config) *Monitor {
+	if config.Window < 3 {
+		config.Window = DefaultWindow
+	}
+	return &Monitor{
+		config:  config,
+		samples: make([]Sample, 0),
+		failing: make(map[string]bool),
+	}
+}
+
+// Run samples until ctx is done
+func (m *Monitor) Run(ctx context.Context) {
+	log.Printf("Soak mode: sampling every %s, leak window %d samples", m.config.Interval, m.config.Window)
+	ticker := time.NewTicker(m.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.sample()
+		}
+	}
+}
+
+// sample takes a reading and checks the history for growth
+func (m *Monitor) sample() {
+	// Only the live heap tells a leak from garbage not collected yet
+	runtime.GC()
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	s := Sample{
+		Time:        time.Now(),
+		Goroutines:  runtime.NumGoroutine(),
+		HeapAlloc:   mem.HeapAlloc,
+		HeapObjects: mem.HeapObjects,
+	}
+	if m.config.Clients != nil {
+		s.Clients = m.config.Clients()
+	}
+	if m.config.TimelineSize != nil {
+		s.TimelineSize = m.config.TimelineSize()
+	}
+	var session Session
+	active := false
+	if m.config.Session != nil {
+		session, active = m.config.Session()
+	}
+
+	m.mu.Lock()
+	if active {
+		s.Session = session.ID
+		s.StoredEvents = session.StoredEvents
+		if session.ID != m.lastSession {
+			// A new run: its first sample only sets the counter
+			m.lastSession = session.ID
+			m.lastEvents = session.Events
+			m.baselineRate = 0
+		} else if len(m.samples) > 0 {
+			elapsed := s.Time.Sub(m.samples[len(m.samples)-1].Time).Seconds()
+			s.EventRate = float64(session.Events-m.lastEvents) / elapsed
+			m.lastEvents = session.Events
+			if m.baselineRate == 0 {
+				m.baselineRate = s.EventRate
+			} else {
+				s.Drift = s.EventRate/m.baselineRate - 1
+			}
+		}
+	}
+	m.samples = append(m.samples, s)
+	if len(m.samples) > maxSamples {
+		m.samples = m.samples[1:]
+	}
+	failures := m.check()
+	m.mu.Unlock()
+
+	for _, f := range failures {
+		m.fail(f)
+	}
+}
+
+// check returns the metrics that just started growing at every sample of
+// the window (must hold m.mu)
+func (m *Monitor) check() []Failure {
+	gauges := map[string]func(Sample) float64{
+		"goroutines":   func(s Sample) float64 { return float64(s.Goroutines) },
+		"heapAlloc":    func(s Sample) float64 { return float64(s.HeapAlloc) },
+		"heapObjects":  func(s Sample) float64 { return float64(s.HeapObjects) },
+		"timelineSize": func(s Sample) float64 { return float64(s.TimelineSize) },
+		"storedEvents": func(s Sample) float64 { return float64(s.StoredEvents) },
+		"eventRate":    func(s Sample) float64 { return s.EventRate },
+	}
+	// The session metrics only compare samples of the same run
+	perSession := map[string]bool{"storedEvents": true, "eventRate": true}
+	// The heap also grows with the history kept here, by a little at
+	// every sample: it must grow by this fraction over the window too
+	minGrowth := map[string]float64{"heapAlloc": 0.1, "heapObjects": 0.1}
+
+	window := m.config.Window
+	if len(m.samples) < window {
+		return nil
+	}
+	recent := m.samples[len(m.samples)-window:]
+
+	names := make([]string, 0, len(gauges))
+	for name := range gauges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	failures := make([]Failure, 0)
+	for _, name := range names {
+		value := gauges[name]
+		growing := true
+		for i := 1; i < len(recent) && growing; i++ {
+			if perSession[name] && (recent[i].Session == "" || recent[i].Session != recent[0].Session) {
+				growing = false
+			}
+			if value(recent[i]) <= value(recent[i-1]) {
+				growing = false
+			}
+		}
+		if growing && value(recent[len(recent)-1]) < value(recent[0])*(1+minGrowth[name]) {
+			growing = false
+		}
+
+		if !growing {
+			delete(m.failing, name)
+			continue
+		}
+		if m.failing[name] {
+			continue
+		}
+		m.failing[name] = true
+		f := Failure{
+			Time:    recent[len(recent)-1].Time,
+			Metric:  name,
+			From:    value(recent[0]),
+			To:      value(recent[len(recent)-1]),
+			Samples: window,
+		}
+		if perSession[name] {
+			f.Session = recent[0].Session
+		}
+		m.failures = append(m.failures, f)
+		failures = append(failures, f)
+	}
+	return failures
+}
+
+// fail reports a leak as loudly as possible: in the log, to every client
+// and, with FailFast, by exiting
+func (m *Monitor) fail(f Failure) {
+	msg := fmt.Sprintf("%s grew at each of the last %d samples, from %.0f to %.0f", f.Metric, f.Samples, f.From, f.To)
+	if f.Session != "" {
+		msg += " (session " + f.Session + ")"
+	}
+	if m.config.FailFast {
+		log.Fatalf("SOAK FAILURE: %s", msg)
+	}
+	log.Printf("SOAK FAILURE: %s", msg)
+
+	if m.config.Broadcast != nil {
+		m.config.Broadcast(protocol.NewError("soak_failure", msg))
+	}
+}
+
+// Register mounts /metrics on mux
+func (m *Monitor) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /metrics", m.serveMetrics)
+}
+
+// serveMetrics writes the latest sample in the Prometheus text format, or
+// the whole history as JSON with ?format=json
+func (m *Monitor) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if r.URL.Query().Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"failing":  len(m.failing) > 0,
+			"failures": m.failures,
+			"samples":  m.samples,
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if len(m.samples) > 0 {
+		s := m.samples[len(m.samples)-1]
+		fmt.Fprintf(w, "soak_goroutines %d\n", s.Goroutines)
+		fmt.Fprintf(w, "soak_heap_alloc_bytes %d\n", s.HeapAlloc)
+		fmt.Fprintf(w, "soak_heap_objects %d\n", s.HeapObjects)
+		fmt.Fprintf(w, "soak_clients %d\n", s.Clients)
+		fmt.Fprintf(w, "soak_timeline_size %d\n", s.TimelineSize)
+		fmt.Fprintf(w, "soak_stored_events{session=%q} %d\n", s.Session, s.StoredEvents)
+		fmt.Fprintf(w, "soak_event_rate{session=%q} %g\n", s.Session, s.EventRate)
+		fmt.Fprintf(w, "soak_event_rate_drift{session=%q} %g\n", s.Session, s.Drift)
+	}
+	fmt.Fprintf(w, "soak_samples %d\n", len(m.samples))
+	fmt.Fprintf(w, "soak_failures_total %d\n", len(m.failures))
+	for metric := range m.failing {
+		fmt.Fprintf(w, "soak_failing{metric=%q} 1\n", metric)
+	}
+}
@@ -0,0 +1,246 @@
+package templates
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+)
+
+var (
+	// ErrNotFound is returned when a template does not exist
+	ErrNotFound = errors.New("template not found")
+	// ErrExists is returned when creating a template whose name is taken
+	ErrExists = errors.New("template already exists")
+)
+
+// validName restricts namespaces and names to safe file name characters
+var validName = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// Template is a named, relaunchable simulation configuration
+type Template struct {
+	Namespace   string                    `json:"namespace"`
+	Name        string                    `json:"name"`
+	Description string                    `json:"description,omitempty"`
+	Project     string                    `json:"project"`
+	Scenario    string                    `json:"scenario,omitempty"`
+	Config      protocol.SimulationConfig `json:"config"`
+	Network     *protocol.NetworkPreset   `json:"network,omitempty"`
+	Faults      []protocol.FaultSpec      `json:"faults,omitempty"`
+	Controls    []protocol.ControlSpec    `json:"controls,omitempty"`
+	CreatedAt   time.Time                 `json:"createdAt"`
+	UpdatedAt   time.Time                 `json:"updatedAt"`
+}
+
+// StartRequest converts the template into a start simulation request
+func (t *Template) StartRequest() protocol.StartSimulationRequest {
+	return protocol.StartSimulationRequest{
+		Type:     protocol.MsgStartSimulation,
+		Project:  t.Project,
+		Scenario: t.Scenario,
+		Config:   t.Config,
+		Network:  t.Network,
+		Faults:   append([]protocol.FaultSpec{}, t.Faults...),
+		Controls: append([]protocol.ControlSpec{}, t.Controls...),
+	}
+}
+
+// Validate checks that the template can be stored and launched
+func (t *Template) Validate() error {
+	if !validName.MatchString(t.Namespace) {
+		return fmt.Errorf("invalid namespace: %q", t.Namespace)
+	}
+	if !validName.MatchString(t.Name) {
+		return fmt.Errorf("invalid name: %q", t.Name)
+	}
+	if t.Project == "" {
+		return fmt.Errorf("project is required")
+	}
+	if n := t.Network; n != nil {
+		if err := protocol.ValidateNetwork(*n); err != nil {
+			return err
+		}
+	}
+	for i, f := range t.Faults {
+		switch f.Type {
+		case "crash":
+			if f.Target == "" {
+				return fmt.Errorf("fault %d: crash requires a target", i)
+			}
+		case "partition":
+			if f.From == "" || f.To == "" {
+				return fmt.Errorf("fault %d: partition requires from and to", i)
+			}
+		case "delay":
+			if f.Target == "" || f.DelayMs <= 0 {
+				return fmt.Errorf("fault %d: delay requires a target and a positive delayMs", i)
+			}
+		default:
+			return fmt.Errorf("fault %d: unknown type %q", i, f.Type)
+		}
+		if f.AtMs < 0 || f.DurationMs < 0 {
+			return fmt.Errorf("fault %d: times must not be negative", i)
+		}
+	}
+	return protocol.ValidateControls(t.Controls)
+}
+
+// Store persists templates as JSON files, one directory per namespace
+type Store struct {
+	mu  sync.RWMutex
+	dir string
+}
+
+// NewStore creates a store rooted at dir, creating it if needed
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir}, nil
+}
+
+// List returns all templates in a namespace sorted by name
+func (s *Store) List(namespace string) ([]*Template, error) {
+	if !validName.MatchString(namespace) {
+		return nil, fmt.Errorf("invalid namespace: %q", namespace)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries, err := os.ReadDir(filepath.Join(s.dir, namespace))
+	if errors.Is(err, os.ErrNotExist) {
+		return []*Template{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	templates := make([]*Template, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		t, err := s.read(namespace, strings.TrimSuffix(entry.Name(), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, t)
+	}
+
+	sort.Slice(templates, func(i, j int) bool {
+		return templates[i].Name < templates[j].Name
+	})
+	return templates, nil
+}
+
+// Get returns a single template
+func (s *Store) Get(namespace, name string) (*Template, error) {
+	if !validName.MatchString(namespace) || !validName.MatchString(name) {
+		return nil, ErrNotFound
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.read(namespace, name)
+}
+
+// Create stores a new template, failing if the name is taken
+func (s *Store) Create(t *Template) error {
+	if err := t.Validate(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := os.Stat(s.path(t.Namespace, t.Name)); err == nil {
+		return ErrExists
+	}
+
+	now := time.Now()
+	t.CreatedAt = now
+	t.UpdatedAt = now
+	return s.write(t)
+}
+
+// Put creates or replaces a template, keeping the original creation time
+func (s *Store) Put(t *Template) error {
+	if err := t.Validate(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	t.CreatedAt = now
+	if existing, err := s.read(t.Namespace, t.Name); err == nil {
+		t.CreatedAt = existing.CreatedAt
+	}
+	t.UpdatedAt = now
+	return s.write(t)
+}
+
+// Delete removes a template
+func (s *Store) Delete(namespace, name string) error {
+	if !validName.MatchString(namespace) || !validName.MatchString(name) {
+		return ErrNotFound
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := os.Remove(s.path(namespace, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *Store) path(namespace, name string) string {
+	return filepath.Join(s.dir, namespace, name+".json")
+}
+
+// read loads a template from disk (must be called with lock held)
+func (s *Store) read(namespace, name string) (*Template, error) {
+	data, err := os.ReadFile(s.path(namespace, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var t Template
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("corrupt template %s/%s: %w", namespace, name, err)
+	}
+	return &t, nil
+}
+
+// write saves a template atomically (must be called with lock held)
+func (s *Store) write(t *Template) error {
+	if err := os.MkdirAll(filepath.Join(s.dir, t.Namespace), 0o755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(t, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path(t.Namespace, t.Name) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path(t.Namespace, t.Name))
+}
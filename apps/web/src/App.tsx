@@ -54,14 +54,15 @@ function App() {
     setCurrentProject(projectId);
   };
 
-  const handleStart = () => {
+  const handleStart = (seed?: number) => {
     console.log('[App] handleStart called, currentProject:', currentProject);
     if (currentProject) {
-      console.log('[App] Starting simulation for project:', currentProject);
+      console.log('[App] Starting simulation for project:', currentProject, 'seed:', seed);
       startSimulation(currentProject, undefined, {
         nodeCount: 5,
         speed: 1.0,
         stepMode: true,
+        seed,
       });
     } else {
       console.warn('[App] No project selected');
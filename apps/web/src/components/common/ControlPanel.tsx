@@ -1,8 +1,9 @@
-import { Play, Pause, StepForward, Square, Gauge } from 'lucide-react';
+import { useState } from 'react';
+import { Play, Pause, StepForward, Square, Gauge, Dices } from 'lucide-react';
 import { useSimulationStore } from '../../stores/simulationStore';
 
 interface ControlPanelProps {
-  onStart: () => void;
+  onStart: (seed?: number) => void;
   onPause: () => void;
   onResume: () => void;
   onStop: () => void;
@@ -19,12 +20,15 @@ export function ControlPanel({
   onSpeedChange,
 }: ControlPanelProps) {
   const { simulation, connected } = useSimulationStore();
+  const [seedInput, setSeedInput] = useState('');
 
   console.log('[ControlPanel] Render - connected:', connected, 'running:', simulation.running, 'mode:', simulation.mode);
 
   const handleStart = () => {
     console.log('[ControlPanel] Start button clicked');
-    onStart();
+    // An empty seed lets the server pick one
+    const seed = parseInt(seedInput, 10);
+    onStart(Number.isSafeInteger(seed) && seed > 0 ? seed : undefined);
   };
 
   const handleStep = () => {
@@ -95,6 +99,28 @@ export function ControlPanel({
         <span>{simulation.speed.toFixed(1)}x</span>
       </div>
 
+      <div className="seed-control">
+        <Dices size={16} />
+        <label>Seed:</label>
+        {!simulation.running ? (
+          <input
+            type="text"
+            inputMode="numeric"
+            placeholder={simulation.seed ? String(simulation.seed) : 'random'}
+            value={seedInput}
+            onChange={(e) => setSeedInput(e.target.value.replace(/\D/g, ''))}
+          />
+        ) : (
+          <span
+            className="seed-value"
+            title="Start with this seed to replay the run"
+            onClick={() => setSeedInput(String(simulation.seed))}
+          >
+            {simulation.seed}
+          </span>
+        )}
+      </div>
+
       <div className="status">
         <span className={`status-indicator ${connected ? 'connected' : 'disconnected'}`}>
           {connected ? 'Connected' : 'Disconnected'}
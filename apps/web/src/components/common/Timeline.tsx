@@ -1,5 +1,5 @@
 import { useSimulationStore } from '../../stores/simulationStore';
-import { Send, ArrowDown, X, Crown, CheckCircle } from 'lucide-react';
+import { Send, ArrowDown, X, Crown, CheckCircle, Bug, Pause } from 'lucide-react';
 
 export function Timeline() {
   const { timeline } = useSimulationStore();
@@ -12,6 +12,10 @@ export function Timeline() {
         return <ArrowDown size={14} className="text-green-500" />;
       case 'message_dropped':
         return <X size={14} className="text-red-500" />;
+      case 'message_corrupted':
+        return <Bug size={14} className="text-orange-500" />;
+      case 'message_held':
+        return <Pause size={14} className="text-gray-500" />;
       case 'leader_elected':
         return <Crown size={14} className="text-yellow-500" />;
       case 'consensus_reached':
@@ -29,6 +33,10 @@ export function Timeline() {
         return `${event.data.at} received from ${event.data.from}`;
       case 'message_dropped':
         return `Message dropped: ${event.data.reason}`;
+      case 'message_corrupted':
+        return `Message corrupted (${event.data.corruption}): ${event.data.detail}`;
+      case 'message_held':
+        return `Held ${event.data.from} → ${event.data.to}: ${event.data.messageType}`;
       case 'leader_elected':
         return `${event.data.leaderId} elected leader (term ${event.data.term})`;
       case 'consensus_reached':
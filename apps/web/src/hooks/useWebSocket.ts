@@ -10,6 +10,8 @@ interface UseWebSocketOptions {
 
 export function useWebSocket({ url, onOpen, onClose, onError }: UseWebSocketOptions) {
   const ws = useRef<WebSocket | null>(null);
+  // Sequence of the last broadcast handled, 0 until one arrives
+  const lastSequence = useRef(0);
   const [isConnected, setIsConnected] = useState(false);
   const [error, setError] = useState<string | null>(null);
 
@@ -28,6 +30,7 @@ export function useWebSocket({ url, onOpen, onClose, onError }: UseWebSocketOpti
 
         ws.current.onopen = () => {
           console.log('[WS] Connected successfully');
+          lastSequence.current = 0;
           setIsConnected(true);
           setConnected(true);
           setError(null);
@@ -58,6 +61,15 @@ export function useWebSocket({ url, onOpen, onClose, onError }: UseWebSocketOpti
             try {
               const msg = JSON.parse(msgStr);
               console.log('[WS] Parsed message type:', msg.type, 'nodes:', msg.nodes ? Object.keys(msg.nodes) : 'none');
+              if (typeof msg.sequence === 'number') {
+                if (msg.sequence <= lastSequence.current) {
+                  continue; // Already handled
+                }
+                if (lastSequence.current > 0 && msg.sequence > lastSequence.current + 1) {
+                  console.warn('[WS] Missed', msg.sequence - lastSequence.current - 1, 'messages before', msg.sequence);
+                }
+                lastSequence.current = msg.sequence;
+              }
               handleMessage(msg);
             } catch (e) {
               console.error('[WS] Failed to parse message:', e, msgStr.substring(0, 100));
@@ -90,6 +102,7 @@ export function useWebSocket({ url, onOpen, onClose, onError }: UseWebSocketOpti
           speed: msg.speed,
           virtualTime: msg.virtualTime,
           running: msg.running,
+          seed: msg.seed ?? 0,
         });
         if (msg.nodes) {
           console.log('[WS] Setting nodes:', Object.keys(msg.nodes));
@@ -138,6 +151,31 @@ export function useWebSocket({ url, onOpen, onClose, onError }: UseWebSocketOpti
         });
         break;
 
+      case 'message_corrupted':
+        addTimelineEvent({
+          type: 'message_corrupted',
+          time: msg.time || Date.now(),
+          data: {
+            messageId: msg.messageId,
+            corruption: msg.reason,
+            detail: msg.detail,
+          },
+        });
+        break;
+
+      case 'message_held':
+        addTimelineEvent({
+          type: 'message_held',
+          time: msg.time || Date.now(),
+          data: {
+            from: msg.from,
+            to: msg.to,
+            messageType: msg.messageType,
+            messageId: msg.messageId,
+          },
+        });
+        break;
+
       case 'leader_elected':
         addTimelineEvent({
           type: 'leader_elected',
@@ -194,6 +232,54 @@ export function useWebSocket({ url, onOpen, onClose, onError }: UseWebSocketOpti
     send({ type: 'step_forward' });
   }, [send]);
 
+  const stepN = useCallback((count: number) => {
+    send({ type: 'step_n', count });
+  }, [send]);
+
+  const stepEvent = useCallback((seq = 0) => {
+    send({ type: 'step_event', seq });
+  }, [send]);
+
+  const loadScenario = useCallback((name: string, scenario?: unknown) => {
+    send({ type: 'load_scenario', name, scenario });
+  }, [send]);
+
+  const fastForward = useCallback((durationMs: number) => {
+    send({ type: 'fast_forward', durationMs });
+  }, [send]);
+
+  const saveCheckpoint = useCallback(() => {
+    send({ type: 'save_checkpoint' });
+  }, [send]);
+
+  const loadCheckpoint = useCallback((checkpoint: unknown) => {
+    send({ type: 'load_checkpoint', checkpoint });
+  }, [send]);
+
+  const setBreakpoints = useCallback((breakpoints: unknown[], replace = false) => {
+    send({ type: 'set_breakpoints', breakpoints, replace });
+  }, [send]);
+
+  const runToCompletion = useCallback((until: unknown[] = [], maxMs = 0) => {
+    send({ type: 'run_to_completion', until, maxMs });
+  }, [send]);
+
+  const saveExecutionTrace = useCallback(() => {
+    send({ type: 'save_execution_trace' });
+  }, [send]);
+
+  const replayExecutionTrace = useCallback((trace: unknown) => {
+    send({ type: 'replay_execution_trace', trace });
+  }, [send]);
+
+  const startFuzz = useCallback((request: unknown, runs = 0, runMs = 0, windowMs = 0) => {
+    send({ type: 'start_fuzz', request, runs, runMs, windowMs });
+  }, [send]);
+
+  const explore = useCallback((request: unknown, depth = 0, maxPaths = 0) => {
+    send({ type: 'explore', request, depth, maxPaths });
+  }, [send]);
+
   const setSpeed = useCallback((speed: number) => {
     send({ type: 'set_speed', speed });
   }, [send]);
@@ -214,6 +300,76 @@ export function useWebSocket({ url, onOpen, onClose, onError }: UseWebSocketOpti
     send({ type: 'heal_partition', from, to, bidirectional });
   }, [send]);
 
+  // e.g. [{ name: 'majority', nodes: ['node-1', 'node-2', 'node-3'] }, { name: 'minority', nodes: ['node-4', 'node-5'] }]
+  const partitionGroups = useCallback((groups: { name?: string; nodes: string[] }[]) => {
+    send({ type: 'partition_groups', groups });
+  }, [send]);
+
+  const healAllPartitions = useCallback(() => {
+    send({ type: 'heal_all_partitions' });
+  }, [send]);
+
+  const injectCorruption = useCallback((probability: number, corruptions: string[] = []) => {
+    send({ type: 'inject_corruption', probability, corruptions });
+  }, [send]);
+
+  // distribution: e.g. { kind: 'pareto', shape: 1.5 }, bounded by the latency range (uniform when omitted)
+  const setLinkLatency = useCallback((from: string, to: string, minLatencyMs: number, maxLatencyMs: number, bidirectional = false, distribution?: unknown) => {
+    send({ type: 'set_link_latency', from, to, minLatencyMs, maxLatencyMs, bidirectional, distribution });
+  }, [send]);
+
+  const resetLinkLatency = useCallback((from: string, to: string, bidirectional = false) => {
+    send({ type: 'set_link_latency', from, to, bidirectional, reset: true });
+  }, [send]);
+
+  // packetLoss from 0 to 1, from -> to only unless bidirectional
+  const setLinkPacketLoss = useCallback((from: string, to: string, packetLoss: number, bidirectional = false) => {
+    send({ type: 'set_link_packet_loss', from, to, packetLoss, bidirectional });
+  }, [send]);
+
+  const resetLinkPacketLoss = useCallback((from: string, to: string, bidirectional = false) => {
+    send({ type: 'set_link_packet_loss', from, to, bidirectional, reset: true });
+  }, [send]);
+
+  // bytesPerSec 0 = unlimited; queueCapacity 0 = unbounded
+  const setLinkBandwidth = useCallback((from: string, to: string, bytesPerSec: number, queueCapacity = 0, bidirectional = false) => {
+    send({ type: 'set_link_bandwidth', from, to, bytesPerSec, queueCapacity, bidirectional });
+  }, [send]);
+
+  const resetLinkBandwidth = useCallback((from: string, to: string, bidirectional = false) => {
+    send({ type: 'set_link_bandwidth', from, to, bidirectional, reset: true });
+  }, [send]);
+
+  // Without from and to, every link
+  const setFifo = useCallback((fifo: boolean, from = '', to = '', bidirectional = false) => {
+    send({ type: 'set_fifo', from, to, fifo, bidirectional });
+  }, [send]);
+
+  const resetFifo = useCallback((from: string, to: string, bidirectional = false) => {
+    send({ type: 'set_fifo', from, to, bidirectional, reset: true });
+  }, [send]);
+
+  const holdMessages = useCallback((hold: boolean) => {
+    send({ type: 'hold_messages', hold });
+  }, [send]);
+
+  // Without messageId, the oldest held
+  const deliverMessage = useCallback((messageId = '', drop = false) => {
+    send({ type: 'deliver_message', messageId, drop });
+  }, [send]);
+
+  const dropMessage = useCallback((messageId: string) => {
+    send({ type: 'drop_message', messageId });
+  }, [send]);
+
+  const setNodeTickRate = useCallback((nodeId: string, tickMs: number) => {
+    send({ type: 'set_node_tick_rate', nodeId, tickMs });
+  }, [send]);
+
+  const setTimeDilation = useCallback((nodeId: string, factor: number, durationMs = 0) => {
+    send({ type: 'set_time_dilation', nodeId, factor, durationMs });
+  }, [send]);
+
   const getState = useCallback(() => {
     send({ type: 'get_state' });
   }, [send]);
@@ -227,11 +383,39 @@ export function useWebSocket({ url, onOpen, onClose, onError }: UseWebSocketOpti
     resumeSimulation,
     stopSimulation,
     stepForward,
+    stepN,
+    stepEvent,
+    loadScenario,
+    fastForward,
+    saveCheckpoint,
+    loadCheckpoint,
+    setBreakpoints,
+    runToCompletion,
+    saveExecutionTrace,
+    replayExecutionTrace,
+    startFuzz,
+    explore,
     setSpeed,
     injectCrash,
     recoverNode,
     injectPartition,
     healPartition,
+    partitionGroups,
+    healAllPartitions,
+    injectCorruption,
+    setLinkLatency,
+    resetLinkLatency,
+    setLinkPacketLoss,
+    resetLinkPacketLoss,
+    setLinkBandwidth,
+    resetLinkBandwidth,
+    setFifo,
+    resetFifo,
+    holdMessages,
+    deliverMessage,
+    dropMessage,
+    setNodeTickRate,
+    setTimeDilation,
     getState,
   };
 }
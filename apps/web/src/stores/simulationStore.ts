@@ -29,6 +29,7 @@ export interface SimulationState {
   speed: number;
   virtualTime: number;
   running: boolean;
+  seed: number; // Of the current run, to start it again identically (0 = none yet)
 }
 
 export interface Partition {
@@ -78,6 +79,7 @@ const initialSimulationState: SimulationState = {
   speed: 1.0,
   virtualTime: 0,
   running: false,
+  seed: 0,
 };
 
 export const useSimulationStore = create<SimulationStore>((set) => ({
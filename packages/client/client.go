@@ -0,0 +1,363 @@
+// Package client is a typed Go client for the simulation WebSocket protocol.
+//
+// It is used by tooling and automation that drive simulations headlessly:
+//
+//	c, err := client.Connect(ctx, "ws://localhost:8080/ws")
+//	if err != nil { ... }
+//	defer c.Close()
+//
+//	c.OnState(func(s *protocol.SimulationStateResponse) { ... })
+//	c.StartSimulation("raft", "leader_election", protocol.SimulationConfig{NodeCount: 5})
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/protocol"
+	"github.com/gorilla/websocket"
+)
+
+// ErrClosed is returned when sending on a closed client
+var ErrClosed = errors.New("client closed")
+
+// writeTimeout bounds how long a single request may block on the socket
+const writeTimeout = 10 * time.Second
+
+// Event is any server message that is not a state snapshot or an error,
+// e.g. timeline_event, message_sent or a project-specific event
+type Event struct {
+	Type protocol.MessageType
+	Raw  json.RawMessage
+	Data map[string]interface{}
+}
+
+// Decode unmarshals the raw event into v
+func (e Event) Decode(v interface{}) error {
+	return json.Unmarshal(e.Raw, v)
+}
+
+// Client is a connection to the simulation server
+type Client struct {
+	conn    *websocket.Conn
+	writeMu sync.Mutex
+
+	mu            sync.RWMutex
+	nextHandler   int
+	stateHandlers map[int]func(*protocol.SimulationStateResponse)
+	eventHandlers map[int]func(Event)
+	errorHandlers map[int]func(*protocol.ErrorResponse)
+
+	done chan struct{}
+	err  error
+}
+
+// Connect dials the server's WebSocket endpoint and starts reading
+func Connect(ctx context.Context, url string) (*Client, error) {
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("connect %s: %w", url, err)
+	}
+
+	c := &Client{
+		conn:          conn,
+		stateHandlers: make(map[int]func(*protocol.SimulationStateResponse)),
+		eventHandlers: make(map[int]func(Event)),
+		errorHandlers: make(map[int]func(*protocol.ErrorResponse)),
+		done:          make(chan struct{}),
+	}
+	go c.readLoop()
+	return c, nil
+}
+
+// Close closes the connection
+func (c *Client) Close() error {
+	c.writeMu.Lock()
+	c.conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(time.Second))
+	c.writeMu.Unlock()
+	err := c.conn.Close()
+	<-c.done
+	return err
+}
+
+// Done is closed when the connection ends
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns why the connection ended, once Done is closed
+func (c *Client) Err() error {
+	select {
+	case <-c.done:
+		return c.err
+	default:
+		return nil
+	}
+}
+
+// OnState registers a handler for simulation state snapshots.
+// Handlers run on the read goroutine and must not block.
+// The returned function unregisters the handler.
+func (c *Client) OnState(handler func(*protocol.SimulationStateResponse)) func() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id := c.nextHandler
+	c.nextHandler++
+	c.stateHandlers[id] = handler
+	return func() {
+		c.mu.Lock()
+		delete(c.stateHandlers, id)
+		c.mu.Unlock()
+	}
+}
+
+// OnEvent registers a handler for all other server messages
+func (c *Client) OnEvent(handler func(Event)) func() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id := c.nextHandler
+	c.nextHandler++
+	c.eventHandlers[id] = handler
+	return func() {
+		c.mu.Lock()
+		delete(c.eventHandlers, id)
+		c.mu.Unlock()
+	}
+}
+
+// OnError registers a handler for error responses
+func (c *Client) OnError(handler func(*protocol.ErrorResponse)) func() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id := c.nextHandler
+	c.nextHandler++
+	c.errorHandlers[id] = handler
+	return func() {
+		c.mu.Lock()
+		delete(c.errorHandlers, id)
+		c.mu.Unlock()
+	}
+}
+
+// WaitForState blocks until a state snapshot satisfies match
+func (c *Client) WaitForState(ctx context.Context, match func(*protocol.SimulationStateResponse) bool) (*protocol.SimulationStateResponse, error) {
+	found := make(chan *protocol.SimulationStateResponse, 1)
+	unregister := c.OnState(func(state *protocol.SimulationStateResponse) {
+		if match(state) {
+			select {
+			case found <- state:
+			default:
+			}
+		}
+	})
+	defer unregister()
+
+	select {
+	case state := <-found:
+		return state, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.done:
+		return nil, ErrClosed
+	}
+}
+
+// StartSimulation starts a project scenario
+func (c *Client) StartSimulation(project, scenario string, config protocol.SimulationConfig) error {
+	return c.Start(&protocol.StartSimulationRequest{
+		Project:  project,
+		Scenario: scenario,
+		Config:   config,
+	})
+}
+
+// Start sends a full start request, including network presets and faults
+func (c *Client) Start(req *protocol.StartSimulationRequest) error {
+	req.Type = protocol.MsgStartSimulation
+	return c.send(req)
+}
+
+// StartTemplate launches a saved simulation template
+func (c *Client) StartTemplate(namespace, name string) error {
+	return c.send(&protocol.StartTemplateRequest{
+		Type:      protocol.MsgStartTemplate,
+		Namespace: namespace,
+		Name:      name,
+	})
+}
+
+// Stop stops the running simulation
+func (c *Client) Stop() error {
+	return c.send(&protocol.BaseMessage{Type: protocol.MsgStopSimulation})
+}
+
+// Pause pauses the running simulation
+func (c *Client) Pause() error {
+	return c.send(&protocol.BaseMessage{Type: protocol.MsgPauseSimulation})
+}
+
+// Resume resumes a paused simulation
+func (c *Client) Resume() error {
+	return c.send(&protocol.BaseMessage{Type: protocol.MsgResumeSimulation})
+}
+
+// Step advances a paused simulation by one tick
+func (c *Client) Step() error {
+	return c.send(&protocol.BaseMessage{Type: protocol.MsgStepForward})
+}
+
+// SetSpeed changes the simulation speed multiplier
+func (c *Client) SetSpeed(speed float64) error {
+	return c.send(&protocol.SetSpeedRequest{Type: protocol.MsgSetSpeed, Speed: speed})
+}
+
+// InjectCrash crashes a node
+func (c *Client) InjectCrash(nodeID string) error {
+	return c.send(&protocol.InjectCrashRequest{Type: protocol.MsgInjectCrash, NodeID: nodeID})
+}
+
+// RecoverNode recovers a crashed node
+func (c *Client) RecoverNode(nodeID string) error {
+	return c.send(&protocol.RecoverNodeRequest{Type: protocol.MsgRecoverNode, NodeID: nodeID})
+}
+
+// InjectPartition blocks traffic from one node to another
+func (c *Client) InjectPartition(from, to string, bidirectional bool) error {
+	return c.send(&protocol.InjectPartitionRequest{
+		Type:          protocol.MsgInjectPartition,
+		From:          from,
+		To:            to,
+		Bidirectional: bidirectional,
+	})
+}
+
+// HealPartition restores traffic between two nodes
+func (c *Client) HealPartition(from, to string, bidirectional bool) error {
+	return c.send(&protocol.HealPartitionRequest{
+		Type:          protocol.MsgHealPartition,
+		From:          from,
+		To:            to,
+		Bidirectional: bidirectional,
+	})
+}
+
+// SendClientRequest sends a project-specific client command
+func (c *Client) SendClientRequest(command string, payload map[string]interface{}) error {
+	return c.send(&protocol.ClientRequest{
+		Type:    protocol.MsgSendClientRequest,
+		Command: command,
+		Payload: payload,
+	})
+}
+
+// RequestState asks the server for a state snapshot, delivered to OnState
+func (c *Client) RequestState() error {
+	return c.send(&protocol.BaseMessage{Type: protocol.MsgGetState})
+}
+
+// StartTrace enables server-side capture of this connection's traffic
+func (c *Client) StartTrace(maxBytes int) error {
+	return c.send(&protocol.StartTraceRequest{Type: protocol.MsgStartTrace, MaxBytes: maxBytes})
+}
+
+// StopTrace ends traffic capture
+func (c *Client) StopTrace() error {
+	return c.send(&protocol.BaseMessage{Type: protocol.MsgStopTrace})
+}
+
+// send writes a single request to the socket
+func (c *Client) send(msg interface{}) error {
+	data, err := protocol.ToJSON(msg)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-c.done:
+		return ErrClosed
+	default:
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return c.conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// readLoop reads frames until the connection closes
+func (c *Client) readLoop() {
+	defer close(c.done)
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				c.err = err
+			}
+			return
+		}
+
+		// The server batches queued messages into one frame, one per line
+		for _, line := range bytes.Split(data, []byte("\n")) {
+			if len(bytes.TrimSpace(line)) > 0 {
+				c.dispatch(line)
+			}
+		}
+	}
+}
+
+// dispatch routes a single message to the registered handlers
+func (c *Client) dispatch(data []byte) {
+	msgType, err := protocol.ParseMessage(data)
+	if err != nil {
+		return
+	}
+
+	switch msgType {
+	case protocol.MsgSimulationState:
+		var state protocol.SimulationStateResponse
+		if err := json.Unmarshal(data, &state); err != nil {
+			return
+		}
+		for _, handler := range snapshot(&c.mu, c.stateHandlers) {
+			handler(&state)
+		}
+
+	case protocol.MsgError:
+		var resp protocol.ErrorResponse
+		if err := json.Unmarshal(data, &resp); err != nil {
+			return
+		}
+		for _, handler := range snapshot(&c.mu, c.errorHandlers) {
+			handler(&resp)
+		}
+
+	default:
+		event := Event{Type: msgType, Raw: append(json.RawMessage{}, data...)}
+		if err := json.Unmarshal(data, &event.Data); err != nil {
+			return
+		}
+		for _, handler := range snapshot(&c.mu, c.eventHandlers) {
+			handler(event)
+		}
+	}
+}
+
+// snapshot copies handlers so they can run without holding the lock,
+// letting a handler register or unregister others
+func snapshot[H any](mu *sync.RWMutex, handlers map[int]H) []H {
+	mu.RLock()
+	defer mu.RUnlock()
+	list := make([]H, 0, len(handlers))
+	for _, handler := range handlers {
+		list = append(list, handler)
+	}
+	return list
+}
@@ -0,0 +1,10 @@
+module github.com/ersantana/distributed-systems-learning/packages/client
+
+go 1.23
+
+require (
+	github.com/ersantana/distributed-systems-learning/packages/protocol v0.0.0
+	github.com/gorilla/websocket v1.5.3
+)
+
+replace github.com/ersantana/distributed-systems-learning/packages/protocol => ../protocol
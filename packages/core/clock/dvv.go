@@ -0,0 +1,62 @@
+package clock
+
+// Dot names a single event: the node that issued it and the node's
+// counter for it
+type Dot struct {
+	Node    string `json:"node"`
+	Counter uint64 `json:"counter"`
+}
+
+// DottedVersionVector is the clock of a value written through a server:
+// the dot of the write and the version vector of the values the writer
+// had seen. A plain version vector would fold the dot into the vector,
+// claiming every earlier event of the dot's node was seen; when the server
+// issued events the writer missed, that claim is false, and the vector
+// either hides a concurrent write or, kept for a whole set of siblings,
+// reports conflicts with values the writer had replaced.
+type DottedVersionVector struct {
+	Dot     Dot               `json:"dot"`
+	Context map[string]uint64 `json:"context"`
+}
+
+// NewDottedVersionVector returns the clock of a write with the given dot,
+// made after seeing context
+func NewDottedVersionVector(dot Dot, context map[string]uint64) DottedVersionVector {
+	copied := make(map[string]uint64, len(context))
+	for k, v := range context {
+		copied[k] = v
+	}
+	return DottedVersionVector{Dot: dot, Context: copied}
+}
+
+// Covers returns true if the writer had seen the event named by dot
+func (d DottedVersionVector) Covers(dot Dot) bool {
+	return d.Dot == dot || d.Context[dot.Node] >= dot.Counter
+}
+
+// VersionVector folds the dot into the context, returning the plain
+// version vector of the value
+func (d DottedVersionVector) VersionVector() map[string]uint64 {
+	vv := make(map[string]uint64, len(d.Context)+1)
+	for k, v := range d.Context {
+		vv[k] = v
+	}
+	if d.Dot.Counter > vv[d.Dot.Node] {
+		vv[d.Dot.Node] = d.Dot.Counter
+	}
+	return vv
+}
+
+// CompareDottedVersionVectors compares the values two clocks belong to:
+// a value happened before another if the other's writer had seen its dot
+func CompareDottedVersionVectors(a, b DottedVersionVector) CausalRelation {
+	switch {
+	case a.Dot == b.Dot:
+		return Equal
+	case b.Context[a.Dot.Node] >= a.Dot.Counter:
+		return HappensBefore // a -> b
+	case a.Context[b.Dot.Node] >= b.Dot.Counter:
+		return HappensAfter // b -> a
+	}
+	return Concurrent
+}
@@ -0,0 +1,42 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// PhysicalClock models a node's wall clock. It reads a shared time
+// source (normally the simulation's virtual time) and adds a per-node
+// offset, so individual nodes can be made to run ahead or behind.
+type PhysicalClock struct {
+	mu     sync.RWMutex
+	source func() time.Time
+	offset time.Duration
+}
+
+// NewPhysicalClock creates a clock that reads source with no skew
+func NewPhysicalClock(source func() time.Time) *PhysicalClock {
+	return &PhysicalClock{source: source}
+}
+
+// Now returns the time as seen by this node
+func (c *PhysicalClock) Now() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.source().Add(c.offset)
+}
+
+// SetSkew sets how far this clock is ahead (positive) or behind
+// (negative) the true time
+func (c *PhysicalClock) SetSkew(offset time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.offset = offset
+}
+
+// Skew returns the current offset from the true time
+func (c *PhysicalClock) Skew() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.offset
+}
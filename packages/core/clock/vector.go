@@ -63,6 +63,15 @@ func (vc *VectorClock) Increment() map[string]uint64 {
 	return vc.copy()
 }
 
+// Advance increments the component for another node and returns the new clock
+// Used when a vector counts messages delivered from each node (causal broadcast)
+func (vc *VectorClock) Advance(nodeID string) map[string]uint64 {
+	vc.mu.Lock()
+	defer vc.mu.Unlock()
+	vc.clock[nodeID]++
+	return vc.copy()
+}
+
 // Merge merges a received vector clock with the local clock
 // Sets each component to max(local, received), then increments own component
 func (vc *VectorClock) Merge(received map[string]uint64) map[string]uint64 {
@@ -108,10 +117,10 @@ func CompareVectorClocks(a, b map[string]uint64) CausalRelation {
 			equal = false
 		}
 		if aVal > bVal {
-			bLessOrEqual = false
+			aLessOrEqual = false
 		}
 		if bVal > aVal {
-			aLessOrEqual = false
+			bLessOrEqual = false
 		}
 	}
 
@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"sort"
+	"sync"
+)
+
+// Store is a key-value store attached to a simulated node
+type Store interface {
+	Get(key string) (interface{}, bool)
+	Put(key string, value interface{})
+	Delete(key string)
+	Keys() []string
+
+	// Durable reports whether the contents survive a crash
+	Durable() bool
+	// Crash models the owning process dying: volatile stores lose
+	// everything, durable stores keep what was written
+	Crash()
+
+	// Stats returns counters for visualization
+	Stats() map[string]interface{}
+}
+
+// MemoryStore implements Store in memory, optionally emulating a disk
+// that survives crashes
+type MemoryStore struct {
+	mu sync.RWMutex
+
+	data    map[string]interface{}
+	durable bool
+	writes  int
+	lost    int // Keys discarded by crashes
+}
+
+// NewVolatileStore creates a store whose contents are lost on crash
+func NewVolatileStore() *MemoryStore {
+	return &MemoryStore{data: make(map[string]interface{})}
+}
+
+// NewDurableStore creates a store whose contents survive crashes
+func NewDurableStore() *MemoryStore {
+	return &MemoryStore{data: make(map[string]interface{}), durable: true}
+}
+
+// Get returns the value stored under key
+func (s *MemoryStore) Get(key string) (interface{}, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v, ok := s.data[key]
+	return v, ok
+}
+
+// Put stores value under key
+func (s *MemoryStore) Put(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data[key] = value
+	s.writes++
+}
+
+// Delete removes key
+func (s *MemoryStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data, key)
+	s.writes++
+}
+
+// Keys returns all keys, sorted
+func (s *MemoryStore) Keys() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]string, 0, len(s.data))
+	for k := range s.data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Durable reports whether the contents survive a crash
+func (s *MemoryStore) Durable() bool {
+	return s.durable
+}
+
+// Crash discards the contents of a volatile store
+func (s *MemoryStore) Crash() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.durable {
+		return
+	}
+	s.lost += len(s.data)
+	s.data = make(map[string]interface{})
+}
+
+// Stats returns write and loss counters for visualization
+func (s *MemoryStore) Stats() map[string]interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return map[string]interface{}{
+		"durable": s.durable,
+		"keys":    len(s.data),
+		"writes":  s.writes,
+		"lost":    s.lost,
+	}
+}
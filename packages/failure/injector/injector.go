@@ -61,6 +61,12 @@ type EventEmitter interface {
 	Emit(eventType string, data map[string]interface{})
 }
 
+// Scheduler runs scheduled failures in virtual time instead of the wall
+// clock
+type Scheduler interface {
+	After(delay time.Duration, fn func())
+}
+
 // Injector manages failure injection
 type Injector struct {
 	mu sync.RWMutex
@@ -73,6 +79,8 @@ type Injector struct {
 
 	startTime time.Time
 	running   bool
+	scheduler Scheduler     // nil = wall clock
+	wake      chan struct{} // Interrupts the wall clock scheduler's wait when failures change
 }
 
 type scheduledFailure struct {
@@ -89,6 +97,7 @@ func NewInjector(nodeManager NodeManager, networkManager NetworkManager, emitter
 		nodeManager:    nodeManager,
 		networkManager: networkManager,
 		emitter:        emitter,
+		wake:           make(chan struct{}, 1),
 	}
 }
 
@@ -221,11 +230,32 @@ func (i *Injector) HealPartition(from, to string, bidirectional bool) {
 	}
 }
 
+// SetScheduler makes scheduled failures happen at the virtual time of s,
+// counted from when they are scheduled, so they pause and speed up with
+// the simulation
+func (i *Injector) SetScheduler(s Scheduler) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.scheduler = s
+}
+
 // ScheduleFailure schedules a failure for future execution
 func (i *Injector) ScheduleFailure(failure *Failure) {
 	i.mu.Lock()
 	defer i.mu.Unlock()
 
+	if i.scheduler != nil {
+		i.scheduler.After(failure.StartTime, func() {
+			i.executeIfRunning(&scheduledFailure{failure: failure})
+		})
+		if failure.Duration > 0 {
+			i.scheduler.After(failure.StartTime+failure.Duration, func() {
+				i.executeIfRunning(&scheduledFailure{failure: failure, isRecover: true})
+			})
+		}
+		return
+	}
+
 	executeAt := i.startTime.Add(failure.StartTime)
 
 	i.scheduled = append(i.scheduled, &scheduledFailure{
@@ -242,6 +272,15 @@ func (i *Injector) ScheduleFailure(failure *Failure) {
 			isRecover: true,
 		})
 	}
+	i.wakeUp()
+}
+
+// wakeUp makes the wall clock scheduler look at the failures again
+func (i *Injector) wakeUp() {
+	select {
+	case i.wake <- struct{}{}:
+	default:
+	}
 }
 
 // Start starts the failure injection scheduler
@@ -249,9 +288,12 @@ func (i *Injector) Start() {
 	i.mu.Lock()
 	i.startTime = time.Now()
 	i.running = true
+	scheduler := i.scheduler
 	i.mu.Unlock()
 
-	go i.runScheduler()
+	if scheduler == nil {
+		go i.runScheduler()
+	}
 }
 
 // Stop stops the failure injection scheduler
@@ -259,12 +301,15 @@ func (i *Injector) Stop() {
 	i.mu.Lock()
 	defer i.mu.Unlock()
 	i.running = false
+	i.wakeUp()
 }
 
-// runScheduler runs the failure scheduler
+// runScheduler runs the failure scheduler: it sleeps until the next
+// failure is due, or the failures change
 func (i *Injector) runScheduler() {
-	ticker := time.NewTicker(10 * time.Millisecond)
-	defer ticker.Stop()
+	timer := time.NewTimer(0)
+	timer.Stop()
+	defer timer.Stop()
 
 	for {
 		i.mu.RLock()
@@ -279,12 +324,16 @@ func (i *Injector) runScheduler() {
 		i.mu.Lock()
 		toExecute := make([]*scheduledFailure, 0)
 		remaining := make([]*scheduledFailure, 0)
+		var next time.Time
 
 		for _, sf := range i.scheduled {
 			if now.After(sf.executeAt) || now.Equal(sf.executeAt) {
 				toExecute = append(toExecute, sf)
 			} else {
 				remaining = append(remaining, sf)
+				if next.IsZero() || sf.executeAt.Before(next) {
+					next = sf.executeAt
+				}
 			}
 		}
 		i.scheduled = remaining
@@ -294,7 +343,28 @@ func (i *Injector) runScheduler() {
 			i.executeScheduled(sf)
 		}
 
-		<-ticker.C
+		if next.IsZero() {
+			<-i.wake
+			continue
+		}
+		timer.Reset(time.Until(next))
+		select {
+		case <-timer.C:
+		case <-i.wake:
+			timer.Stop()
+		}
+	}
+}
+
+// executeIfRunning executes a failure or recovery scheduled on the
+// scheduler, unless the injector was stopped since
+func (i *Injector) executeIfRunning(sf *scheduledFailure) {
+	i.mu.RLock()
+	running := i.running
+	i.mu.RUnlock()
+
+	if running {
+		i.executeScheduled(sf)
 	}
 }
 
@@ -0,0 +1,254 @@
+// Package rpc makes request/response calls over a transport: it matches
+// replies to the calls they answer, and gives up on a call after a timeout,
+// retrying it first with a backoff if asked to.
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+// Metadata keys of the envelopes of a call
+const (
+	callIDKey  = "rpcCallId"  // On requests: the call they belong to
+	replyToKey = "rpcReplyTo" // On replies: the call they answer
+)
+
+// ErrTimeout is returned when no reply came before the timeout of the
+// call's last attempt
+var ErrTimeout = errors.New("rpc: call timed out")
+
+// RetryPolicy sends a call again when an attempt times out. The wait
+// before the next attempt starts at Backoff and is multiplied by
+// Multiplier after each, up to MaxBackoff (0 = no maximum).
+type RetryPolicy struct {
+	Attempts   int // In all, the first one included (<= 1 = no retry)
+	Backoff    time.Duration
+	Multiplier float64 // <= 1 = constant backoff
+	MaxBackoff time.Duration
+}
+
+// backoff returns the wait after a failed attempt (1 = the first)
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	wait := float64(p.Backoff)
+	for i := 1; i < attempt && p.Multiplier > 1; i++ {
+		wait *= p.Multiplier
+		if p.MaxBackoff > 0 && wait >= float64(p.MaxBackoff) {
+			return p.MaxBackoff
+		}
+	}
+	return time.Duration(wait)
+}
+
+// Request is a call from one node to another
+type Request struct {
+	From    string
+	To      string
+	Type    transport.MessageType
+	Payload interface{}
+	Timeout time.Duration // Of each attempt
+	Retry   RetryPolicy
+}
+
+// Client makes calls over a transport. Its Handler must wrap the delivery
+// handler of every node that makes calls, so replies reach the calls.
+type Client struct {
+	transport transport.Transport
+	scheduler transport.Scheduler // Timeouts in virtual time (nil = wall clock)
+
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+// call is a call waiting for its reply
+type call struct {
+	ctx     context.Context
+	req     Request
+	attempt int
+	timer   *time.Timer // Wall-clock timeout of the attempt
+	stop    func() bool // Stops watching ctx
+	done    func(reply *transport.Envelope, err error)
+}
+
+// NewClient creates a client over t, whose timeouts and backoffs run on
+// scheduler, in virtual time, or on wall-clock timers if it is nil
+func NewClient(t transport.Transport, scheduler transport.Scheduler) *Client {
+	return &Client{
+		transport: t,
+		scheduler: scheduler,
+		calls:     make(map[string]*call),
+	}
+}
+
+// Handler routes the replies to the calls of c, and hands every other
+// message to next. Replies that come after their call gave up are dropped.
+func (c *Client) Handler(next transport.DeliveryHandler) transport.DeliveryHandler {
+	return func(env *transport.Envelope) {
+		id, ok := env.Metadata[replyToKey].(string)
+		if !ok {
+			next(env)
+			return
+		}
+		if cl := c.finish(id); cl != nil {
+			cl.done(env, nil)
+		}
+	}
+}
+
+// Go makes a call and returns at once. done is called once, with the
+// reply, or with ErrTimeout when the last attempt timed out, or with the
+// error of ctx as soon as it is done first, then from a goroutine of its
+// own. On a scheduler, calls must be made this way from within its
+// events: the reply is one of them.
+func (c *Client) Go(ctx context.Context, req Request, done func(reply *transport.Envelope, err error)) {
+	c.start(ctx, req, done)
+}
+
+// Call makes a call and waits for its reply, or for timeout to pass
+// without one. It blocks: with a scheduler, use Go from within its events.
+func (c *Client) Call(ctx context.Context, from, to string, msgType transport.MessageType, payload interface{}, timeout time.Duration) (*transport.Envelope, error) {
+	return c.CallRetry(ctx, from, to, msgType, payload, timeout, RetryPolicy{})
+}
+
+// CallRetry is Call, trying again as retry says when an attempt times out
+func (c *Client) CallRetry(ctx context.Context, from, to string, msgType transport.MessageType, payload interface{}, timeout time.Duration, retry RetryPolicy) (*transport.Envelope, error) {
+	type result struct {
+		reply *transport.Envelope
+		err   error
+	}
+	results := make(chan result, 1)
+	c.start(ctx, Request{From: from, To: to, Type: msgType, Payload: payload, Timeout: timeout, Retry: retry}, func(reply *transport.Envelope, err error) {
+		results <- result{reply, err}
+	})
+	r := <-results
+	return r.reply, r.err
+}
+
+// Reply answers a request received through t
+func Reply(ctx context.Context, t transport.Transport, req *transport.Envelope, msgType transport.MessageType, payload interface{}) error {
+	id, ok := req.Metadata[callIDKey].(string)
+	if !ok {
+		return fmt.Errorf("rpc: message %s is not a call", req.ID)
+	}
+	env := transport.NewEnvelope(req.To, req.From, msgType, payload)
+	env.Metadata[replyToKey] = id
+	return t.Send(ctx, env)
+}
+
+// IsCall tells whether a message is a call to reply to
+func IsCall(env *transport.Envelope) bool {
+	_, ok := env.Metadata[callIDKey].(string)
+	return ok
+}
+
+// start makes a call, which gives up as soon as ctx is done
+func (c *Client) start(ctx context.Context, req Request, done func(reply *transport.Envelope, err error)) {
+	id := uuid.New().String()
+	c.mu.Lock()
+	cl := &call{ctx: ctx, req: req, done: done}
+	c.calls[id] = cl
+	cl.stop = context.AfterFunc(ctx, func() {
+		if cl := c.finish(id); cl != nil {
+			cl.done(nil, ctx.Err())
+		}
+	})
+	c.mu.Unlock()
+	c.attempt(id)
+}
+
+// attempt sends a call once more and waits for its timeout
+func (c *Client) attempt(id string) {
+	c.mu.Lock()
+	cl := c.calls[id]
+	if cl == nil {
+		c.mu.Unlock()
+		return
+	}
+	if err := cl.ctx.Err(); err != nil {
+		c.remove(id)
+		c.mu.Unlock()
+		cl.done(nil, err)
+		return
+	}
+	cl.attempt++
+	attempt := cl.attempt
+	if c.scheduler == nil {
+		cl.timer = time.AfterFunc(cl.req.Timeout, func() { c.timeout(id, attempt) })
+	}
+	req := cl.req
+	c.mu.Unlock()
+
+	if c.scheduler != nil {
+		c.scheduler.After(req.Timeout, func() { c.timeout(id, attempt) })
+	}
+	env := transport.NewEnvelope(req.From, req.To, req.Type, req.Payload)
+	env.Metadata[callIDKey] = id
+	if err := c.transport.Send(cl.ctx, env); err != nil {
+		if cl := c.finish(id); cl != nil {
+			cl.done(nil, err)
+		}
+	}
+}
+
+// timeout gives up on an attempt of a call still waiting for its reply,
+// and tries again after the backoff if the policy allows another attempt
+func (c *Client) timeout(id string, attempt int) {
+	c.mu.Lock()
+	cl := c.calls[id]
+	if cl == nil || cl.attempt != attempt {
+		c.mu.Unlock()
+		return
+	}
+	if err := cl.ctx.Err(); err != nil {
+		c.remove(id)
+		c.mu.Unlock()
+		cl.done(nil, err)
+		return
+	}
+	if attempt >= cl.req.Retry.Attempts {
+		c.remove(id)
+		c.mu.Unlock()
+		cl.done(nil, fmt.Errorf("%w after %d attempt(s) of %s", ErrTimeout, attempt, cl.req.Timeout))
+		return
+	}
+	wait := cl.req.Retry.backoff(attempt)
+	c.mu.Unlock()
+
+	switch {
+	case wait <= 0:
+		c.attempt(id)
+	case c.scheduler != nil:
+		c.scheduler.After(wait, func() { c.attempt(id) })
+	default:
+		time.AfterFunc(wait, func() { c.attempt(id) })
+	}
+}
+
+// finish takes a call out of those waiting (nil = it no longer waits)
+func (c *Client) finish(id string) *call {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.remove(id)
+}
+
+// remove takes a call out of those waiting, and stops its timer and the
+// watch on its context (must hold c.mu)
+func (c *Client) remove(id string) *call {
+	cl := c.calls[id]
+	if cl == nil {
+		return nil
+	}
+	delete(c.calls, id)
+	if cl.timer != nil {
+		cl.timer.Stop()
+	}
+	cl.stop()
+	return cl
+}
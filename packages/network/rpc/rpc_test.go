@@ -0,0 +1,178 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ersantana/distributed-systems-learning/packages/network/transport"
+)
+
+// virtualClock is a transport.Scheduler whose time only moves when told to
+type virtualClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	seq    int
+	events []virtualEvent
+}
+
+type virtualEvent struct {
+	at  time.Time
+	seq int
+	fn  func()
+}
+
+func (v *virtualClock) After(delay time.Duration, fn func()) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.seq++
+	v.events = append(v.events, virtualEvent{at: v.now.Add(delay), seq: v.seq, fn: fn})
+}
+
+func (v *virtualClock) GetVirtualTime() time.Time {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.now
+}
+
+// advance runs the events due within d, in order, then moves time to d
+func (v *virtualClock) advance(d time.Duration) {
+	v.mu.Lock()
+	until := v.now.Add(d)
+	v.mu.Unlock()
+	for {
+		v.mu.Lock()
+		sort.Slice(v.events, func(i, j int) bool {
+			if !v.events[i].at.Equal(v.events[j].at) {
+				return v.events[i].at.Before(v.events[j].at)
+			}
+			return v.events[i].seq < v.events[j].seq
+		})
+		if len(v.events) == 0 || v.events[0].at.After(until) {
+			v.now = until
+			v.mu.Unlock()
+			return
+		}
+		next := v.events[0]
+		v.events = v.events[1:]
+		v.now = next.at
+		v.mu.Unlock()
+		next.fn()
+	}
+}
+
+// result is what a call ended with
+type result struct {
+	reply *transport.Envelope
+	err   error
+}
+
+// setup connects a caller to a server that answers from its request on,
+// over a network with a latency of 5ms in virtual time
+func setup(answerFrom int32) (*virtualClock, *Client, *atomic.Int32) {
+	clock := &virtualClock{now: time.Unix(0, 0)}
+	t := transport.NewNetworkTransport()
+	t.SetScheduler(clock)
+	t.SetLatency(5*time.Millisecond, 5*time.Millisecond)
+	client := NewClient(t, clock)
+	t.RegisterHandler("caller", client.Handler(func(*transport.Envelope) {}))
+
+	requests := &atomic.Int32{}
+	t.RegisterHandler("server", func(env *transport.Envelope) {
+		if !IsCall(env) || requests.Add(1) < answerFrom || answerFrom == 0 {
+			return
+		}
+		Reply(context.Background(), t, env, "pong", env.Payload)
+	})
+	return clock, client, requests
+}
+
+// ping makes a call whose result comes on the channel returned
+func ping(ctx context.Context, client *Client, timeout time.Duration, retry RetryPolicy) <-chan result {
+	results := make(chan result, 1)
+	client.Go(ctx, Request{From: "caller", To: "server", Type: "ping", Payload: "hello", Timeout: timeout, Retry: retry}, func(reply *transport.Envelope, err error) {
+		results <- result{reply, err}
+	})
+	return results
+}
+
+// pending fails if the call ended
+func pending(t *testing.T, results <-chan result) {
+	t.Helper()
+	select {
+	case r := <-results:
+		t.Fatalf("call ended early: %v %v", r.reply, r.err)
+	default:
+	}
+}
+
+func TestCallVirtualTime(t *testing.T) {
+	t.Run("reply", func(t *testing.T) {
+		clock, client, _ := setup(1)
+		results := ping(context.Background(), client, 100*time.Millisecond, RetryPolicy{})
+		clock.advance(9 * time.Millisecond)
+		pending(t, results)
+		clock.advance(time.Millisecond)
+		r := <-results
+		if r.err != nil || r.reply.Type != "pong" || r.reply.Payload != "hello" {
+			t.Fatalf("got %v %v, want the pong", r.reply, r.err)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		clock, client, _ := setup(0)
+		results := ping(context.Background(), client, 100*time.Millisecond, RetryPolicy{})
+		clock.advance(99 * time.Millisecond)
+		pending(t, results)
+		clock.advance(time.Millisecond)
+		if r := <-results; !errors.Is(r.err, ErrTimeout) {
+			t.Fatalf("got %v, want ErrTimeout", r.err)
+		}
+	})
+
+	t.Run("retry", func(t *testing.T) {
+		clock, client, requests := setup(3)
+		retry := RetryPolicy{Attempts: 3, Backoff: 10 * time.Millisecond, Multiplier: 2}
+		results := ping(context.Background(), client, 50*time.Millisecond, retry)
+		// Attempts at 0, 60 (50 + 10) and 130ms (110 + 20), answered 10ms later
+		clock.advance(139 * time.Millisecond)
+		pending(t, results)
+		clock.advance(time.Millisecond)
+		r := <-results
+		if r.err != nil || requests.Load() != 3 {
+			t.Fatalf("got %v after %d requests, want the pong after 3", r.err, requests.Load())
+		}
+	})
+
+	t.Run("retries run out", func(t *testing.T) {
+		clock, client, requests := setup(0)
+		results := ping(context.Background(), client, 50*time.Millisecond, RetryPolicy{Attempts: 2})
+		clock.advance(time.Second)
+		if r := <-results; !errors.Is(r.err, ErrTimeout) || requests.Load() != 2 {
+			t.Fatalf("got %v after %d requests, want ErrTimeout after 2", r.err, requests.Load())
+		}
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		clock, client, _ := setup(0)
+		ctx, cancel := context.WithCancel(context.Background())
+		results := ping(ctx, client, 100*time.Millisecond, RetryPolicy{Attempts: 5})
+		clock.advance(10 * time.Millisecond)
+		cancel()
+		// Without virtual time moving on
+		select {
+		case r := <-results:
+			if !errors.Is(r.err, context.Canceled) {
+				t.Fatalf("got %v, want context.Canceled", r.err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("the call did not end when its context was cancelled")
+		}
+		clock.advance(time.Second)
+		pending(t, results)
+	})
+}
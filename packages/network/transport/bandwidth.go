@@ -0,0 +1,145 @@
+package transport
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// Bandwidth limits how fast a link carries messages: a message waits for
+// those sent on the link before it, then takes its size over the
+// bandwidth to go out. Messages that find the link's queue full are
+// dropped.
+type Bandwidth struct {
+	BytesPerSecond int64 // 0 = unlimited
+	QueueCapacity  int   // Messages waiting or going out (0 = unbounded)
+}
+
+// LinkBandwidth is the bandwidth of the link from one node to another,
+// apart from the rest of the network
+type LinkBandwidth struct {
+	From      string
+	To        string
+	Bandwidth Bandwidth
+}
+
+// linkQueue holds the times the messages on a link are done going out,
+// in order
+type linkQueue struct {
+	done []time.Time
+}
+
+// SetBandwidth limits the bandwidth of every link, but those with their
+// own
+func (t *NetworkTransport) SetBandwidth(b Bandwidth) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.bandwidth = b
+}
+
+// SetLinkBandwidth limits the bandwidth of the link from one node to
+// another, apart from the rest of the network
+func (t *NetworkTransport) SetLinkBandwidth(from, to string, b Bandwidth) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.linkBandwidths[from] == nil {
+		t.linkBandwidths[from] = make(map[string]Bandwidth)
+	}
+	t.linkBandwidths[from][to] = b
+}
+
+// ClearLinkBandwidth brings the bandwidth of the link from one node to
+// another back to that of the rest of the network
+func (t *NetworkTransport) ClearLinkBandwidth(from, to string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.linkBandwidths[from], to)
+}
+
+// LinkBandwidths returns the per-link bandwidth overrides, sorted by link
+func (t *NetworkTransport) LinkBandwidths() []LinkBandwidth {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	links := make([]LinkBandwidth, 0)
+	for from, tos := range t.linkBandwidths {
+		for to, b := range tos {
+			links = append(links, LinkBandwidth{From: from, To: to, Bandwidth: b})
+		}
+	}
+	sort.Slice(links, func(i, j int) bool {
+		if links[i].From != links[j].From {
+			return links[i].From < links[j].From
+		}
+		return links[i].To < links[j].To
+	})
+	return links
+}
+
+// Queued returns how many messages wait for or take the bandwidth of
+// their link at now
+func (t *NetworkTransport) Queued(now time.Time) int {
+	t.queuesMu.Lock()
+	defer t.queuesMu.Unlock()
+
+	queued := 0
+	for _, q := range t.queues {
+		for _, done := range q.done {
+			if done.After(now) {
+				queued++
+			}
+		}
+	}
+	return queued
+}
+
+// bandwidthOf returns the bandwidth of the link from one node to another
+// (must hold t.mu)
+func (t *NetworkTransport) bandwidthOf(from, to string) Bandwidth {
+	if b, ok := t.linkBandwidths[from][to]; ok {
+		return b
+	}
+	return t.bandwidth
+}
+
+// enqueue puts a message of size bytes on the link from one node to
+// another at now, and returns how long it waits for the messages ahead of
+// it and takes to go out. It is false when the link's queue is full.
+func (t *NetworkTransport) enqueue(from, to string, b Bandwidth, size int, now time.Time) (time.Duration, bool) {
+	t.queuesMu.Lock()
+	defer t.queuesMu.Unlock()
+
+	key := [2]string{from, to}
+	q := t.queues[key]
+	if q == nil {
+		q = &linkQueue{}
+		t.queues[key] = q
+	}
+	gone := 0
+	for gone < len(q.done) && !q.done[gone].After(now) {
+		gone++
+	}
+	q.done = q.done[gone:]
+	if b.QueueCapacity > 0 && len(q.done) >= b.QueueCapacity {
+		return 0, false
+	}
+
+	start := now
+	if len(q.done) > 0 {
+		start = q.done[len(q.done)-1]
+	}
+	done := start.Add(time.Duration(int64(size) * int64(time.Second) / b.BytesPerSecond))
+	q.done = append(q.done, done)
+	return done.Sub(now), true
+}
+
+// payloadSize is the size of a message on the wire, that of its payload
+// as JSON
+func payloadSize(env *Envelope) int {
+	data, err := json.Marshal(env.Payload)
+	if err != nil {
+		return 0
+	}
+	return len(data)
+}
@@ -0,0 +1,257 @@
+package transport
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Corruption is what happens to the payload of a corrupted message
+type Corruption string
+
+const (
+	CorruptFlip     Corruption = "flip"     // One field changes value
+	CorruptTruncate Corruption = "truncate" // The fields from one on are lost
+	CorruptGarbage  Corruption = "garbage"  // The payload becomes random bytes
+)
+
+// Corruptions are all the ways a payload can be corrupted
+var Corruptions = []Corruption{CorruptFlip, CorruptTruncate, CorruptGarbage}
+
+// CorruptHandler is called when a message is corrupted on its way, with
+// the message as it will arrive and what was done to it
+type CorruptHandler func(env *Envelope, corruption Corruption, detail string)
+
+// SetCorruption makes a fraction of the messages (0.0 to 1.0) arrive with
+// their payload corrupted in one of the given ways, picked at random (none
+// = any of them)
+func (t *NetworkTransport) SetCorruption(probability float64, corruptions ...Corruption) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if probability < 0 {
+		probability = 0
+	}
+	if probability > 1 {
+		probability = 1
+	}
+	if len(corruptions) == 0 {
+		corruptions = Corruptions
+	}
+	t.corruption = probability
+	t.corruptions = append([]Corruption(nil), corruptions...)
+}
+
+// OnCorrupt sets the handler called with every message corrupted
+func (t *NetworkTransport) OnCorrupt(handler CorruptHandler) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.corruptHandler = handler
+}
+
+// ValidCorruption tells whether c is one of Corruptions
+func ValidCorruption(c Corruption) bool {
+	for _, known := range Corruptions {
+		if c == known {
+			return true
+		}
+	}
+	return false
+}
+
+// corrupt returns a copy of env whose payload is corrupted in one of the
+// corruptions, and what was done. The sender's envelope and payload are
+// left alone. A payload with no field to flip or truncate becomes garbage.
+func corrupt(env *Envelope, corruptions []Corruption, rng *rand.Rand) (*Envelope, Corruption, string) {
+	corruption := corruptions[rng.Intn(len(corruptions))]
+	payload, detail, ok := env.Payload, "", false
+	switch corruption {
+	case CorruptFlip:
+		payload, detail, ok = flipField(env.Payload, rng)
+	case CorruptTruncate:
+		payload, detail, ok = truncateFields(env.Payload, rng)
+	}
+	if !ok {
+		corruption = CorruptGarbage
+		garbage := make([]byte, 1+rng.Intn(16))
+		rng.Read(garbage)
+		payload, detail = garbage, fmt.Sprintf("replaced with %d random bytes", len(garbage))
+	}
+
+	copied := *env
+	copied.Payload = payload
+	copied.Metadata = make(map[string]interface{}, len(env.Metadata)+1)
+	for k, v := range env.Metadata {
+		copied.Metadata[k] = v
+	}
+	copied.Metadata["corrupted"] = string(corruption)
+	return &copied, corruption, detail
+}
+
+// guardCorrupted delivers a corrupted message to handler, and drops it if
+// the node chokes on it rather than bringing the whole run down
+func guardCorrupted(handler DeliveryHandler, dropHandler DropHandler) DeliveryHandler {
+	return func(env *Envelope) {
+		defer func() {
+			if recover() != nil && dropHandler != nil {
+				dropHandler(env, "corrupt_payload")
+			}
+		}()
+		handler(env)
+	}
+}
+
+// field is a part of a payload that can be corrupted: a struct field or a
+// map entry
+type field struct {
+	name  string
+	value reflect.Value // Not settable for map entries
+	set   func(v reflect.Value)
+	clear func()
+}
+
+// payloadCopy copies a payload, a struct or a map or a pointer to a
+// struct, so that its fields can be changed, and lists them in order
+func payloadCopy(payload interface{}) (rebuild func() interface{}, fields []field) {
+	v := reflect.ValueOf(payload)
+	pointer := v.Kind() == reflect.Pointer && !v.IsNil()
+	if pointer {
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		copied := reflect.New(v.Type()).Elem()
+		copied.Set(v)
+		for i := 0; i < copied.NumField(); i++ {
+			f := copied.Field(i)
+			if !f.CanSet() {
+				continue
+			}
+			fields = append(fields, field{
+				name:  v.Type().Field(i).Name,
+				value: f,
+				set:   f.Set,
+				clear: func() { f.Set(reflect.Zero(f.Type())) },
+			})
+		}
+		if pointer {
+			return copied.Addr().Interface, fields
+		}
+		return copied.Interface, fields
+
+	case reflect.Map:
+		if pointer || v.Type().Key().Kind() != reflect.String {
+			return nil, nil
+		}
+		copied := reflect.MakeMapWithSize(v.Type(), v.Len())
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		for _, key := range keys {
+			copied.SetMapIndex(key, v.MapIndex(key))
+		}
+		for _, key := range keys {
+			value := copied.MapIndex(key)
+			if value.Kind() == reflect.Interface && !value.IsNil() {
+				value = value.Elem()
+			}
+			fields = append(fields, field{
+				name:  key.String(),
+				value: value,
+				set:   func(v reflect.Value) { copied.SetMapIndex(key, v) },
+				clear: func() { copied.SetMapIndex(key, reflect.Value{}) },
+			})
+		}
+		return copied.Interface, fields
+	}
+	return nil, nil
+}
+
+// flipField changes the value of one field of a copy of payload, one
+// that is set if there is any
+func flipField(payload interface{}, rng *rand.Rand) (interface{}, string, bool) {
+	rebuild, fields := payloadCopy(payload)
+	var flippable, set []field
+	for _, f := range fields {
+		if f.value.IsValid() && canFlip(f.value.Kind()) {
+			flippable = append(flippable, f)
+			if !f.value.IsZero() {
+				set = append(set, f)
+			}
+		}
+	}
+	if len(set) > 0 {
+		flippable = set
+	}
+	if len(flippable) == 0 {
+		return nil, "", false
+	}
+
+	f := flippable[rng.Intn(len(flippable))]
+	before := fmt.Sprint(f.value.Interface())
+	flipped := flippedValue(f.value, rng)
+	f.set(flipped)
+	return rebuild(), fmt.Sprintf("%s changed from %s to %v", f.name, before, flipped.Interface()), true
+}
+
+// canFlip tells whether values of a kind can be flipped: numbers, booleans
+// and strings
+func canFlip(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// flippedValue returns a different value of the same type as v, which
+// canFlip
+func flippedValue(v reflect.Value, rng *rand.Rand) reflect.Value {
+	flipped := reflect.New(v.Type()).Elem()
+	switch v.Kind() {
+	case reflect.Bool:
+		flipped.SetBool(!v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		flipped.SetInt(v.Int() ^ 1<<rng.Intn(4))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		flipped.SetUint(v.Uint() ^ 1<<rng.Intn(4))
+	case reflect.Float32, reflect.Float64:
+		flipped.SetFloat(v.Float() + float64(1+rng.Intn(10)))
+	case reflect.String:
+		s := []byte(v.String())
+		if len(s) == 0 {
+			s = []byte{'?'}
+		} else {
+			s[rng.Intn(len(s))] ^= 1 << rng.Intn(5)
+		}
+		flipped.SetString(string(s))
+	}
+	return flipped
+}
+
+// truncateFields loses the fields of a copy of payload that are set from
+// one on, as when a message is cut short
+func truncateFields(payload interface{}, rng *rand.Rand) (interface{}, string, bool) {
+	rebuild, fields := payloadCopy(payload)
+	var set []field
+	for _, f := range fields {
+		if f.value.IsValid() && !f.value.IsZero() {
+			set = append(set, f)
+		}
+	}
+	if len(set) == 0 {
+		return nil, "", false
+	}
+
+	from := rng.Intn(len(set))
+	lost := make([]string, 0, len(set)-from)
+	for _, f := range set[from:] {
+		f.clear()
+		lost = append(lost, f.name)
+	}
+	return rebuild(), "lost " + strings.Join(lost, ", "), true
+}
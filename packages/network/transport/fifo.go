@@ -0,0 +1,118 @@
+package transport
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// LinkFIFO tells whether the link from one node to another delivers
+// messages in the order they were sent, apart from the rest of the network
+type LinkFIFO struct {
+	From string
+	To   string
+	FIFO bool
+}
+
+// fifoLink is the last message sent on a FIFO link: when it arrives, and
+// a channel closed once it is delivered (nil in virtual time)
+type fifoLink struct {
+	at        time.Time
+	delivered chan struct{}
+}
+
+// SetFIFO makes every link, but those with their own setting, deliver
+// messages in the order they were sent. Otherwise each message takes its
+// own latency, and a later one can overtake an earlier one.
+func (t *NetworkTransport) SetFIFO(fifo bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.fifo = fifo
+}
+
+// SetLinkFIFO sets whether the link from one node to another delivers
+// messages in the order they were sent, apart from the rest of the network
+func (t *NetworkTransport) SetLinkFIFO(from, to string, fifo bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.linkFIFOs[from] == nil {
+		t.linkFIFOs[from] = make(map[string]bool)
+	}
+	t.linkFIFOs[from][to] = fifo
+}
+
+// ClearLinkFIFO brings the ordering of the link from one node to another
+// back to that of the rest of the network
+func (t *NetworkTransport) ClearLinkFIFO(from, to string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.linkFIFOs[from], to)
+}
+
+// LinkFIFOs returns the per-link ordering overrides, sorted by link
+func (t *NetworkTransport) LinkFIFOs() []LinkFIFO {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	links := make([]LinkFIFO, 0)
+	for from, tos := range t.linkFIFOs {
+		for to, fifo := range tos {
+			links = append(links, LinkFIFO{From: from, To: to, FIFO: fifo})
+		}
+	}
+	sort.Slice(links, func(i, j int) bool {
+		if links[i].From != links[j].From {
+			return links[i].From < links[j].From
+		}
+		return links[i].To < links[j].To
+	})
+	return links
+}
+
+// fifoOf tells whether the link from one node to another is FIFO (must
+// hold t.mu)
+func (t *NetworkTransport) fifoOf(from, to string) bool {
+	if fifo, ok := t.linkFIFOs[from][to]; ok {
+		return fifo
+	}
+	return t.fifo
+}
+
+// inOrder puts a message sent at now with a latency behind the last one
+// sent on its FIFO link, and returns the latency that makes it arrive no
+// earlier. With chained, it also returns a channel closed once the last
+// one is delivered, to wait for, and one to close once this one is.
+// Virtual time needs no chaining: the scheduler runs events due at the
+// same time in the order they were scheduled, and keeps those it fuzzes
+// in order on a FIFO link.
+func (t *NetworkTransport) inOrder(from, to string, now time.Time, latency time.Duration, chained bool) (time.Duration, <-chan struct{}, chan struct{}) {
+	t.fifoMu.Lock()
+	defer t.fifoMu.Unlock()
+
+	key := [2]string{from, to}
+	last := t.fifoLinks[key]
+	if wait := last.at.Sub(now); wait > latency {
+		latency = wait
+	}
+	next := fifoLink{at: now.Add(latency)}
+	if chained {
+		next.delivered = make(chan struct{})
+	}
+	t.fifoLinks[key] = next
+	return latency, last.delivered, next.delivered
+}
+
+// waitPrevious waits for the message before on a FIFO link to be
+// delivered (nil = none), and is false if ctx is done first
+func waitPrevious(ctx context.Context, previous <-chan struct{}) bool {
+	if previous == nil {
+		return true
+	}
+	select {
+	case <-previous:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
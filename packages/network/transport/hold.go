@@ -0,0 +1,140 @@
+package transport
+
+import (
+	"context"
+	"time"
+)
+
+// HeldMessage is a message the network holds back until it is released
+type HeldMessage struct {
+	Envelope *Envelope
+	HeldAt   time.Time
+}
+
+// heldMessage is a held message and what delivering it takes
+type heldMessage struct {
+	HeldMessage
+	ctx       context.Context
+	handler   DeliveryHandler
+	delivered DeliveryHandler
+	scheduler Scheduler
+}
+
+// SetHold makes the network hold back every message sent from now on, past
+// partitions, packet loss and corruption, until it is released or dropped
+// one by one, in any order. Turning it off releases those held, in the
+// order they were sent.
+func (t *NetworkTransport) SetHold(hold bool) {
+	t.heldMu.Lock()
+	t.holding = hold
+	var released []*heldMessage
+	if !hold {
+		released, t.held = t.held, nil
+	}
+	t.heldMu.Unlock()
+
+	for _, m := range released {
+		t.release(m)
+	}
+}
+
+// Holding tells whether the network holds back the messages sent
+func (t *NetworkTransport) Holding() bool {
+	t.heldMu.Lock()
+	defer t.heldMu.Unlock()
+	return t.holding
+}
+
+// OnHold sets the handler called with every message held back
+func (t *NetworkTransport) OnHold(handler DeliveryHandler) {
+	t.heldMu.Lock()
+	defer t.heldMu.Unlock()
+	t.holdHandler = handler
+}
+
+// Held returns the messages held back, in the order they were sent
+func (t *NetworkTransport) Held() []HeldMessage {
+	t.heldMu.Lock()
+	defer t.heldMu.Unlock()
+
+	held := make([]HeldMessage, len(t.held))
+	for i, m := range t.held {
+		held[i] = m.HeldMessage
+	}
+	return held
+}
+
+// Release delivers a held message at once, ahead of those held before it.
+// It returns false when no such message is held.
+func (t *NetworkTransport) Release(id string) bool {
+	m := t.unhold(id)
+	if m == nil {
+		return false
+	}
+	t.release(m)
+	return true
+}
+
+// DropHeld drops a held message, as if the network lost it. It returns
+// false when no such message is held.
+func (t *NetworkTransport) DropHeld(id string) bool {
+	m := t.unhold(id)
+	if m == nil {
+		return false
+	}
+	t.inFlight.Add(-1)
+	t.mu.RLock()
+	dropHandler := t.dropHandler
+	t.mu.RUnlock()
+	t.reportDrop(dropHandler, m.Envelope, "dropped_by_user")
+	return true
+}
+
+// hold holds a message back if the network holds messages, and tells
+// whether it did
+func (t *NetworkTransport) hold(ctx context.Context, env *Envelope, handler, delivered DeliveryHandler, scheduler Scheduler) bool {
+	t.heldMu.Lock()
+	if !t.holding {
+		t.heldMu.Unlock()
+		return false
+	}
+	now := time.Now()
+	if scheduler != nil {
+		now = scheduler.GetVirtualTime()
+		env.SentAt = now
+	}
+	t.inFlight.Add(1)
+	t.held = append(t.held, &heldMessage{
+		HeldMessage: HeldMessage{Envelope: env, HeldAt: now},
+		ctx:         ctx,
+		handler:     handler,
+		delivered:   delivered,
+		scheduler:   scheduler,
+	})
+	holdHandler := t.holdHandler
+	t.heldMu.Unlock()
+
+	if holdHandler != nil {
+		holdHandler(env)
+	}
+	return true
+}
+
+// unhold takes a message out of those held (nil = not held)
+func (t *NetworkTransport) unhold(id string) *heldMessage {
+	t.heldMu.Lock()
+	defer t.heldMu.Unlock()
+
+	for i, m := range t.held {
+		if m.Envelope.ID == id {
+			t.held = append(t.held[:i], t.held[i+1:]...)
+			return m
+		}
+	}
+	return nil
+}
+
+// release delivers a message no longer held, with no further latency
+func (t *NetworkTransport) release(m *heldMessage) {
+	t.dispatch(m.ctx, m.Envelope, m.handler, m.delivered, m.scheduler, 0, false, nil, nil)
+}
@@ -0,0 +1,132 @@
+package transport
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// LatencyDistribution draws the latency of each message on a link
+type LatencyDistribution interface {
+	Sample(rng *rand.Rand) time.Duration
+	// Bounds returns the range the samples fall in (max 0 = unbounded)
+	Bounds() (min, max time.Duration)
+	String() string
+}
+
+// Uniform latencies are equally likely anywhere from Min to Max
+type Uniform struct {
+	Min time.Duration
+	Max time.Duration
+}
+
+// Sample draws a latency
+func (u Uniform) Sample(rng *rand.Rand) time.Duration {
+	if u.Max > u.Min {
+		return u.Min + time.Duration(rng.Int63n(int64(u.Max-u.Min)))
+	}
+	return u.Min
+}
+
+// Bounds returns Min and Max
+func (u Uniform) Bounds() (time.Duration, time.Duration) { return u.Min, max(u.Min, u.Max) }
+
+func (u Uniform) String() string { return fmt.Sprintf("uniform %s-%s", u.Min, u.Max) }
+
+// Normal latencies gather around Mean, within StdDev for two thirds of
+// the messages. Samples are kept within Min and Max (0 = no maximum).
+type Normal struct {
+	Mean   time.Duration
+	StdDev time.Duration
+	Min    time.Duration
+	Max    time.Duration
+}
+
+// Sample draws a latency
+func (n Normal) Sample(rng *rand.Rand) time.Duration {
+	return clampLatency(float64(n.Mean)+rng.NormFloat64()*float64(n.StdDev), n.Min, n.Max)
+}
+
+// Bounds returns Min and Max
+func (n Normal) Bounds() (time.Duration, time.Duration) { return n.Min, n.Max }
+
+func (n Normal) String() string { return fmt.Sprintf("normal %s±%s", n.Mean, n.StdDev) }
+
+// LogNormal latencies have a long tail to the right: half of them are
+// below Median, and the larger Sigma the further the slowest are. Samples
+// are kept within Min and Max (0 = no maximum).
+type LogNormal struct {
+	Median time.Duration
+	Sigma  float64
+	Min    time.Duration
+	Max    time.Duration
+}
+
+// Sample draws a latency
+func (l LogNormal) Sample(rng *rand.Rand) time.Duration {
+	return clampLatency(float64(l.Median)*math.Exp(rng.NormFloat64()*l.Sigma), l.Min, l.Max)
+}
+
+// Bounds returns Min and Max
+func (l LogNormal) Bounds() (time.Duration, time.Duration) { return l.Min, l.Max }
+
+func (l LogNormal) String() string { return fmt.Sprintf("lognormal %s σ=%g", l.Median, l.Sigma) }
+
+// Pareto latencies are never below Scale, most close to it, and a few
+// very far above: the smaller Shape, the heavier the tail (below 2 its
+// variance is infinite). Samples are kept below Max (0 = no maximum).
+type Pareto struct {
+	Scale time.Duration
+	Shape float64
+	Max   time.Duration
+}
+
+// Sample draws a latency
+func (p Pareto) Sample(rng *rand.Rand) time.Duration {
+	// Inverse of the distribution function, 1 - Float64() in (0, 1]
+	return clampLatency(float64(p.Scale)/math.Pow(1-rng.Float64(), 1/p.Shape), p.Scale, p.Max)
+}
+
+// Bounds returns Scale and Max
+func (p Pareto) Bounds() (time.Duration, time.Duration) { return p.Scale, p.Max }
+
+func (p Pareto) String() string { return fmt.Sprintf("pareto %s α=%g", p.Scale, p.Shape) }
+
+// maxSampledLatency bounds the latencies drawn from unbounded
+// distributions, so no message is held back for good
+const maxSampledLatency = time.Hour
+
+// clampLatency brings a sample within min and max (0 = maxSampledLatency)
+func clampLatency(sample float64, min, max time.Duration) time.Duration {
+	if max <= 0 {
+		max = maxSampledLatency
+	}
+	if math.IsNaN(sample) || sample < float64(min) {
+		return min
+	}
+	if sample > float64(max) {
+		return max
+	}
+	return time.Duration(sample)
+}
+
+// SetLatencyDistribution draws the latency of every message from d, but on
+// the links with their own
+func (t *NetworkTransport) SetLatencyDistribution(d LatencyDistribution) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.latency = d
+}
+
+// SetLinkLatencyDistribution draws the latency of messages from one node
+// to another from d, apart from the rest of the network
+func (t *NetworkTransport) SetLinkLatencyDistribution(from, to string, d LatencyDistribution) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.links[from] == nil {
+		t.links[from] = make(map[string]LatencyDistribution)
+	}
+	t.links[from][to] = d
+}
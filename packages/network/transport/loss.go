@@ -0,0 +1,68 @@
+package transport
+
+import "sort"
+
+// LinkPacketLoss is the fraction of messages lost on the link from one
+// node to another, apart from the rest of the network
+type LinkPacketLoss struct {
+	From       string
+	To         string
+	PacketLoss float64
+}
+
+// SetLinkPacketLoss makes a fraction of the messages (0.0 to 1.0) from one
+// node to another get lost, apart from the rest of the network, e.g. a
+// single flaky link, or one direction of it
+func (t *NetworkTransport) SetLinkPacketLoss(from, to string, probability float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if probability < 0 {
+		probability = 0
+	}
+	if probability > 1 {
+		probability = 1
+	}
+	if t.linkLosses[from] == nil {
+		t.linkLosses[from] = make(map[string]float64)
+	}
+	t.linkLosses[from][to] = probability
+}
+
+// ClearLinkPacketLoss brings the packet loss of the link from one node to
+// another back to that of the rest of the network
+func (t *NetworkTransport) ClearLinkPacketLoss(from, to string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.linkLosses[from], to)
+}
+
+// LinkPacketLosses returns the per-link packet loss overrides, sorted by
+// link
+func (t *NetworkTransport) LinkPacketLosses() []LinkPacketLoss {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	links := make([]LinkPacketLoss, 0)
+	for from, tos := range t.linkLosses {
+		for to, probability := range tos {
+			links = append(links, LinkPacketLoss{From: from, To: to, PacketLoss: probability})
+		}
+	}
+	sort.Slice(links, func(i, j int) bool {
+		if links[i].From != links[j].From {
+			return links[i].From < links[j].From
+		}
+		return links[i].To < links[j].To
+	})
+	return links
+}
+
+// packetLossOf returns the packet loss of the link from one node to
+// another (must hold t.mu)
+func (t *NetworkTransport) packetLossOf(from, to string) float64 {
+	if probability, ok := t.linkLosses[from][to]; ok {
+		return probability
+	}
+	return t.packetLoss
+}
@@ -0,0 +1,67 @@
+package transport
+
+import (
+	"sort"
+	"time"
+)
+
+// Serialization makes the network encode every message it is sent, as
+// JSON, to tell its size on the wire: what the links' bandwidth carries
+// and the byte counts of the links and message types add up. Encoding
+// also takes time, added to the latency of the message.
+type Serialization struct {
+	Enabled   bool
+	CostPerKB time.Duration // Encoding time per 1024 bytes (0 = free)
+}
+
+// TypeStats counts the messages of one type sent since the transport was
+// created, and their bytes on the wire while serialization was enabled
+type TypeStats struct {
+	Type     MessageType
+	Messages int
+	Bytes    int64
+}
+
+// SetSerialization makes the network encode the messages sent from now
+// on, or stop
+func (t *NetworkTransport) SetSerialization(s Serialization) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if s.CostPerKB < 0 {
+		s.CostPerKB = 0
+	}
+	t.serialization = s
+}
+
+// TypeStats returns the counts of every message type sent, sorted by type
+func (t *NetworkTransport) TypeStats() []TypeStats {
+	t.statsMu.Lock()
+	defer t.statsMu.Unlock()
+
+	stats := make([]TypeStats, 0, len(t.typeStats))
+	for _, s := range t.typeStats {
+		stats = append(stats, *s)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Type < stats[j].Type })
+	return stats
+}
+
+// serialize encodes a message to set its size, and returns how long
+// encoding it takes, if serialization is enabled (must hold t.mu)
+func (t *NetworkTransport) serialize(env *Envelope) time.Duration {
+	if !t.serialization.Enabled {
+		return 0
+	}
+	env.Size = payloadSize(env)
+	return time.Duration(int64(t.serialization.CostPerKB) * int64(env.Size) / 1024)
+}
+
+// sizeOf is the size of a message on the wire, as serialized or else
+// encoded now
+func sizeOf(env *Envelope) int {
+	if env.Size > 0 {
+		return env.Size
+	}
+	return payloadSize(env)
+}
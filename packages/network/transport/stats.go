@@ -0,0 +1,165 @@
+package transport
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	// latencySamples is how many of the latest latencies of a link are
+	// kept to tell its 99th percentile
+	latencySamples = 1000
+	// recentDeliveries is how many of the latest messages delivered on a
+	// link are remembered to tell duplicates
+	recentDeliveries = 256
+)
+
+// LinkStats counts what happened to the messages sent on the link from one
+// node to another since the transport was created
+type LinkStats struct {
+	From       string
+	To         string
+	Sent       int
+	Delivered  int
+	Dropped    map[string]int // By reason, e.g. "packet_loss"
+	Duplicated int            // Deliveries of a message delivered before
+	MinLatency time.Duration  // Of the messages delivered
+	AvgLatency time.Duration
+	P99Latency time.Duration // Of the latest latencySamples
+
+	// Bytes on the wire of the messages sent and delivered while
+	// serialization was enabled
+	BytesSent      int64
+	BytesDelivered int64
+}
+
+// linkStats is the running count of a link
+type linkStats struct {
+	LinkStats
+	totalLatency time.Duration
+	latencies    []time.Duration // Ring of the latest latencySamples
+	next         int
+	recent       map[string]bool // IDs of the latest recentDeliveries
+	recentOrder  []string
+}
+
+// LinkStats returns the statistics of every link a message was sent on,
+// sorted by link
+func (t *NetworkTransport) LinkStats() []LinkStats {
+	t.statsMu.Lock()
+	defer t.statsMu.Unlock()
+
+	stats := make([]LinkStats, 0, len(t.stats))
+	for _, link := range t.stats {
+		s := link.LinkStats
+		s.Dropped = make(map[string]int, len(link.Dropped))
+		for reason, n := range link.Dropped {
+			s.Dropped[reason] = n
+		}
+		if link.Delivered > 0 {
+			s.AvgLatency = link.totalLatency / time.Duration(link.Delivered)
+			s.P99Latency = percentile(link.latencies, 0.99)
+		}
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].From != stats[j].From {
+			return stats[i].From < stats[j].From
+		}
+		return stats[i].To < stats[j].To
+	})
+	return stats
+}
+
+// statsOf returns the running count of a link (must hold t.statsMu)
+func (t *NetworkTransport) statsOf(from, to string) *linkStats {
+	key := [2]string{from, to}
+	link := t.stats[key]
+	if link == nil {
+		link = &linkStats{
+			LinkStats: LinkStats{From: from, To: to, Dropped: make(map[string]int)},
+			recent:    make(map[string]bool),
+		}
+		t.stats[key] = link
+	}
+	return link
+}
+
+// recordSent counts a message sent, and its bytes
+func (t *NetworkTransport) recordSent(env *Envelope) {
+	t.statsMu.Lock()
+	defer t.statsMu.Unlock()
+
+	link := t.statsOf(env.From, env.To)
+	link.Sent++
+	link.BytesSent += int64(env.Size)
+
+	typ := t.typeStats[env.Type]
+	if typ == nil {
+		typ = &TypeStats{Type: env.Type}
+		t.typeStats[env.Type] = typ
+	}
+	typ.Messages++
+	typ.Bytes += int64(env.Size)
+}
+
+// recordDelivered counts a message delivered, and its latency
+func (t *NetworkTransport) recordDelivered(env *Envelope) {
+	latency := env.ReceivedAt.Sub(env.SentAt)
+
+	t.statsMu.Lock()
+	defer t.statsMu.Unlock()
+
+	link := t.statsOf(env.From, env.To)
+	link.Delivered++
+	link.BytesDelivered += int64(env.Size)
+	if link.recent[env.ID] {
+		link.Duplicated++
+	} else {
+		link.recent[env.ID] = true
+		link.recentOrder = append(link.recentOrder, env.ID)
+		if len(link.recentOrder) > recentDeliveries {
+			delete(link.recent, link.recentOrder[0])
+			link.recentOrder = link.recentOrder[1:]
+		}
+	}
+
+	if link.Delivered == 1 || latency < link.MinLatency {
+		link.MinLatency = latency
+	}
+	link.totalLatency += latency
+	if len(link.latencies) < latencySamples {
+		link.latencies = append(link.latencies, latency)
+	} else {
+		link.latencies[link.next] = latency
+		link.next = (link.next + 1) % latencySamples
+	}
+}
+
+// reportDrop counts a message dropped and hands it to dropHandler, if any
+func (t *NetworkTransport) reportDrop(dropHandler DropHandler, env *Envelope, reason string) {
+	t.statsMu.Lock()
+	t.statsOf(env.From, env.To).Dropped[reason]++
+	t.statsMu.Unlock()
+
+	if dropHandler != nil {
+		dropHandler(env, reason)
+	}
+}
+
+// percentile returns the latency below which a fraction p of samples fall
+func percentile(samples []time.Duration, p float64) time.Duration {
+	if len(samples) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	index := int(p*float64(len(sorted))+0.5) - 1
+	if index < 0 {
+		index = 0
+	}
+	if index >= len(sorted) {
+		index = len(sorted) - 1
+	}
+	return sorted[index]
+}
@@ -3,7 +3,9 @@ package transport
 import (
 	"context"
 	"math/rand"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
@@ -24,6 +26,7 @@ type Envelope struct {
 	LamportTime uint64                 `json:"lamportTime,omitempty"`
 	VectorClock map[string]uint64      `json:"vectorClock,omitempty"`
 	Metadata    map[string]interface{} `json:"metadata,omitempty"`
+	Size        int                    `json:"size,omitempty"` // Bytes of the payload on the wire, when serialized
 }
 
 // NewEnvelope creates a new message envelope
@@ -62,35 +65,118 @@ type Transport interface {
 
 	// Event handlers
 	OnDrop(handler DropHandler)
+	OnDeliver(handler DeliveryHandler)
 
 	// Close shuts down the transport
 	Close()
 }
 
+// Scheduler runs deliveries in virtual time instead of the wall clock
+type Scheduler interface {
+	After(delay time.Duration, fn func())
+	GetVirtualTime() time.Time
+}
+
+// labeledScheduler is a Scheduler that tells what its events are, e.g. in
+// traces of the run
+type labeledScheduler interface {
+	AfterLabeled(delay time.Duration, label string, fn func())
+}
+
+// orderedScheduler is a labeledScheduler that can keep the deliveries on a
+// FIFO link in order when it moves them, e.g. while fuzzing
+type orderedScheduler interface {
+	AfterInOrder(delay time.Duration, label, link string, fn func())
+}
+
 // NetworkTransport implements Transport with configurable reliability
 type NetworkTransport struct {
 	mu sync.RWMutex
 
-	handlers   map[string]DeliveryHandler
-	dropHandler DropHandler
+	handlers       map[string]DeliveryHandler
+	dropHandler    DropHandler
+	deliverHandler DeliveryHandler
 
 	// Network characteristics
-	minLatency   time.Duration
-	maxLatency   time.Duration
-	packetLoss   float64 // 0.0 to 1.0
+	latency    LatencyDistribution
+	packetLoss float64 // 0.0 to 1.0
+
+	// Corrupted payloads: the fraction of messages (0.0 to 1.0) and how
+	corruption     float64
+	corruptions    []Corruption
+	corruptHandler CorruptHandler
+
+	// Random source for packet loss and latency (nil = math/rand's)
+	rng *rand.Rand
+
+	// Runs deliveries in virtual time, so latencies scale with the speed
+	// of the simulation and freeze while it is paused (nil = wall-clock
+	// timers)
+	scheduler Scheduler
 
 	// Partitions: partitions[from][to] = true means messages from->to are blocked
 	partitions map[string]map[string]bool
 
-	// Pending messages (for step mode)
-	pending []*pendingMessage
+	// Per-link latency overrides: links[from][to] replaces latency
+	links map[string]map[string]LatencyDistribution
+
+	// Per-link packet loss overrides: linkLosses[from][to] replaces
+	// packetLoss
+	linkLosses map[string]map[string]float64
+
+	// Slow nodes: every message a node sends is held back by its delay
+	nodeDelays map[string]time.Duration
+
+	// Bandwidth of every link, and per-link overrides:
+	// linkBandwidths[from][to] replaces bandwidth
+	bandwidth      Bandwidth
+	linkBandwidths map[string]map[string]Bandwidth
+
+	// The messages each link carries, for links with a bandwidth
+	queuesMu sync.Mutex
+	queues   map[[2]string]*linkQueue
+
+	// FIFO links deliver messages in the order they were sent:
+	// linkFIFOs[from][to] replaces fifo, and fifoLinks holds the last
+	// message sent on each
+	fifo      bool
+	linkFIFOs map[string]map[string]bool
+	fifoMu    sync.Mutex
+	fifoLinks map[[2]string]fifoLink
+
+	// Messages held back until released, while holding
+	heldMu      sync.Mutex
+	holding     bool
+	held        []*heldMessage
+	holdHandler DeliveryHandler
+
+	// Messages sent and not yet delivered
+	inFlight atomic.Int64
+
+	// Encodes the messages sent, to tell their size
+	serialization Serialization
+
+	// What happened to the messages of each link, and of each type
+	statsMu   sync.Mutex
+	stats     map[[2]string]*linkStats
+	typeStats map[MessageType]*TypeStats
+
+	// The messages themselves, when deliveries run on a scheduler, in the
+	// order they were sent, and those dropped on their way
+	flightsMu sync.Mutex
+	flights   map[string]Flight
+	flightSeq uint64
+	dropped   map[string]bool
 
 	closed bool
 }
 
-type pendingMessage struct {
-	env       *Envelope
-	deliverAt time.Time
+// Flight is a message on its way, and when it arrives
+type Flight struct {
+	Envelope  *Envelope
+	DeliverAt time.Time
+
+	seq uint64 // Order it was sent in, to break ties
 }
 
 // NewNetworkTransport creates a new network transport
@@ -98,9 +184,20 @@ func NewNetworkTransport() *NetworkTransport {
 	return &NetworkTransport{
 		handlers:   make(map[string]DeliveryHandler),
 		partitions: make(map[string]map[string]bool),
-		minLatency: 0,
-		maxLatency: 0,
-		packetLoss: 0,
+		links:      make(map[string]map[string]LatencyDistribution),
+		linkLosses: make(map[string]map[string]float64),
+		nodeDelays: make(map[string]time.Duration),
+		flights:    make(map[string]Flight),
+		dropped:    make(map[string]bool),
+		stats:      make(map[[2]string]*linkStats),
+		typeStats:  make(map[MessageType]*TypeStats),
+		queues:     make(map[[2]string]*linkQueue),
+
+		linkBandwidths: make(map[string]map[string]Bandwidth),
+		linkFIFOs:      make(map[string]map[string]bool),
+		fifoLinks:      make(map[[2]string]fifoLink),
+		latency:        Uniform{},
+		packetLoss:     0,
 	}
 }
 
@@ -118,6 +215,14 @@ func (t *NetworkTransport) OnDrop(handler DropHandler) {
 	t.dropHandler = handler
 }
 
+// OnDeliver sets a handler called with every message delivered, before
+// the node receives it
+func (t *NetworkTransport) OnDeliver(handler DeliveryHandler) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.deliverHandler = handler
+}
+
 // Send sends a message through the network
 func (t *NetworkTransport) Send(ctx context.Context, env *Envelope) error {
 	t.mu.RLock()
@@ -126,68 +231,336 @@ func (t *NetworkTransport) Send(ctx context.Context, env *Envelope) error {
 		return nil
 	}
 
+	// Encode the message to tell its size, if serialization is enabled
+	encoding := t.serialize(env)
+	t.recordSent(env)
+
 	// Check for partition
 	if t.isPartitioned(env.From, env.To) {
 		dropHandler := t.dropHandler
 		t.mu.RUnlock()
-		if dropHandler != nil {
-			dropHandler(env, "network_partition")
-		}
+		t.reportDrop(dropHandler, env, "network_partition")
 		return nil
 	}
 
 	// Check for packet loss
-	if t.packetLoss > 0 && rand.Float64() < t.packetLoss {
+	rng := t.rng
+	if rng == nil {
+		rng = globalRand
+	}
+	if loss := t.packetLossOf(env.From, env.To); loss > 0 && rng.Float64() < loss {
 		dropHandler := t.dropHandler
 		t.mu.RUnlock()
-		if dropHandler != nil {
-			dropHandler(env, "packet_loss")
-		}
+		t.reportDrop(dropHandler, env, "packet_loss")
 		return nil
 	}
 
+	// Check for corruption, which the message still arrives with
+	var corruption Corruption
+	var detail string
+	if t.corruption > 0 && rng.Float64() < t.corruption {
+		env, corruption, detail = corrupt(env, t.corruptions, rng)
+	}
+	corruptHandler := t.corruptHandler
+	dropHandler := t.dropHandler
+
 	handler := t.handlers[env.To]
-	minLat := t.minLatency
-	maxLat := t.maxLatency
+	delivered := t.deliverHandler
+	distribution := t.latency
+	if link, ok := t.links[env.From][env.To]; ok {
+		distribution = link
+	}
+	slow := t.nodeDelays[env.From]
+	bandwidth := t.bandwidthOf(env.From, env.To)
+	fifo := t.fifoOf(env.From, env.To)
+	scheduler := t.scheduler
 	t.mu.RUnlock()
 
 	if handler == nil {
 		return nil // No handler registered
 	}
+	if corruption != "" {
+		handler = guardCorrupted(handler, func(env *Envelope, reason string) {
+			t.reportDrop(dropHandler, env, reason)
+		})
+		if corruptHandler != nil {
+			corruptHandler(env, corruption, detail)
+		}
+	}
+
+	// Hold the message back until it is released, while messages are held
+	if t.hold(ctx, env, handler, delivered, scheduler) {
+		return nil
+	}
 
-	// Calculate latency
-	latency := minLat
-	if maxLat > minLat {
-		latency = minLat + time.Duration(rand.Int63n(int64(maxLat-minLat)))
+	// Wait for the link's bandwidth, unless its queue is full
+	var queueing time.Duration
+	if bandwidth.BytesPerSecond > 0 {
+		now := time.Now()
+		if scheduler != nil {
+			now = scheduler.GetVirtualTime()
+		}
+		var ok bool
+		if queueing, ok = t.enqueue(env.From, env.To, bandwidth, sizeOf(env), now); !ok {
+			t.reportDrop(dropHandler, env, "queue_overflow")
+			return nil
+		}
+	}
+
+	// Calculate latency, behind the messages sent before on a FIFO link
+	latency := distribution.Sample(rng) + slow + queueing + encoding
+	var previous <-chan struct{}
+	var done chan struct{}
+	if fifo {
+		now := time.Now()
+		if scheduler != nil {
+			now = scheduler.GetVirtualTime()
+		}
+		latency, previous, done = t.inOrder(env.From, env.To, now, latency, scheduler == nil)
 	}
 
 	// Deliver with latency
-	if latency > 0 {
+	t.inFlight.Add(1)
+	if scheduler != nil {
+		env.SentAt = scheduler.GetVirtualTime()
+	}
+	t.dispatch(ctx, env, handler, delivered, scheduler, latency, fifo, previous, done)
+
+	return nil
+}
+
+// dispatch delivers a message counted in flight to handler after latency,
+// on the scheduler if any, once the message before it on a FIFO link is
+// delivered (previous), then closes done
+func (t *NetworkTransport) dispatch(ctx context.Context, env *Envelope, handler, delivered DeliveryHandler, scheduler Scheduler, latency time.Duration, fifo bool, previous <-chan struct{}, done chan struct{}) {
+	if scheduler != nil {
+		t.flightsMu.Lock()
+		t.flightSeq++
+		t.flights[env.ID] = Flight{Envelope: env, DeliverAt: scheduler.GetVirtualTime().Add(latency), seq: t.flightSeq}
+		t.flightsMu.Unlock()
+		deliver := func() {
+			t.flightsMu.Lock()
+			dropped := t.dropped[env.ID]
+			delete(t.dropped, env.ID)
+			delete(t.flights, env.ID)
+			t.flightsMu.Unlock()
+			if dropped {
+				return
+			}
+			t.inFlight.Add(-1)
+			t.mu.RLock()
+			closed := t.closed
+			t.mu.RUnlock()
+			if closed || ctx.Err() != nil {
+				return
+			}
+			envCopy := *env
+			envCopy.ReceivedAt = scheduler.GetVirtualTime()
+			t.recordDelivered(&envCopy)
+			if delivered != nil {
+				delivered(&envCopy)
+			}
+			handler(&envCopy)
+		}
+		label := "deliver " + string(env.Type) + " " + env.From + "->" + env.To
+		if ordered, ok := scheduler.(orderedScheduler); ok && fifo {
+			ordered.AfterInOrder(latency, label, env.From+"->"+env.To, deliver)
+		} else if labeled, ok := scheduler.(labeledScheduler); ok {
+			labeled.AfterLabeled(latency, label, deliver)
+		} else {
+			scheduler.After(latency, deliver)
+		}
+	} else if latency > 0 {
 		go func() {
+			if done != nil {
+				defer close(done)
+			}
 			select {
 			case <-ctx.Done():
+				t.inFlight.Add(-1)
 				return
 			case <-time.After(latency):
+				if !waitPrevious(ctx, previous) {
+					t.inFlight.Add(-1)
+					return
+				}
+				t.inFlight.Add(-1)
 				envCopy := *env
 				envCopy.ReceivedAt = time.Now()
+				t.recordDelivered(&envCopy)
+				if delivered != nil {
+					delivered(&envCopy)
+				}
 				handler(&envCopy)
 			}
 		}()
 	} else {
 		envCopy := *env
 		envCopy.ReceivedAt = time.Now()
-		go handler(&envCopy)
+		go func() {
+			if done != nil {
+				defer close(done)
+			}
+			if !waitPrevious(ctx, previous) {
+				t.inFlight.Add(-1)
+				return
+			}
+			t.inFlight.Add(-1)
+			t.recordDelivered(&envCopy)
+			if delivered != nil {
+				delivered(&envCopy)
+			}
+			handler(&envCopy)
+		}()
 	}
-
-	return nil
 }
 
 // SetLatency sets the min and max latency for message delivery
 func (t *NetworkTransport) SetLatency(min, max time.Duration) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
-	t.minLatency = min
-	t.maxLatency = max
+	t.latency = Uniform{Min: min, Max: max}
+}
+
+// SetLinkLatency overrides the latency of messages from one node to
+// another, e.g. to place nodes in distant regions
+func (t *NetworkTransport) SetLinkLatency(from, to string, min, max time.Duration) {
+	t.SetLinkLatencyDistribution(from, to, Uniform{Min: min, Max: max})
+}
+
+// ClearLinkLatency brings the latency of messages from one node to
+// another back to that of the rest of the network
+func (t *NetworkTransport) ClearLinkLatency(from, to string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.links[from], to)
+}
+
+// LinkLatency is the latency of the messages from one node to another,
+// apart from the rest of the network
+type LinkLatency struct {
+	From    string
+	To      string
+	Latency LatencyDistribution
+}
+
+// LinkLatencies returns the per-link latency overrides, sorted by link
+func (t *NetworkTransport) LinkLatencies() []LinkLatency {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	links := make([]LinkLatency, 0)
+	for from, tos := range t.links {
+		for to, latency := range tos {
+			links = append(links, LinkLatency{From: from, To: to, Latency: latency})
+		}
+	}
+	sort.Slice(links, func(i, j int) bool {
+		if links[i].From != links[j].From {
+			return links[i].From < links[j].From
+		}
+		return links[i].To < links[j].To
+	})
+	return links
+}
+
+// ClearLinkLatencies removes all per-link latency overrides
+func (t *NetworkTransport) ClearLinkLatencies() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.links = make(map[string]map[string]LatencyDistribution)
+}
+
+// SetNodeDelay makes a node slow: everything it sends is delayed by
+// delay on top of the link latency
+func (t *NetworkTransport) SetNodeDelay(nodeID string, delay time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.nodeDelays[nodeID] = delay
+}
+
+// ClearNodeDelay makes a slow node normal again
+func (t *NetworkTransport) ClearNodeDelay(nodeID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.nodeDelays, nodeID)
+}
+
+// NodeDelay returns the extra delay of a slow node
+func (t *NetworkTransport) NodeDelay(nodeID string) time.Duration {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.nodeDelays[nodeID]
+}
+
+// globalRand draws from math/rand's shared source
+var globalRand = rand.New(globalSource{})
+
+type globalSource struct{}
+
+func (globalSource) Int63() int64    { return rand.Int63() }
+func (globalSource) Seed(seed int64) {}
+
+// SetRand makes packet loss and latency draw from r, which must be safe
+// for concurrent use, so a seeded run drops and delays the same messages
+func (t *NetworkTransport) SetRand(r *rand.Rand) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rng = r
+}
+
+// InFlightMessages returns the messages on their way, in the order they
+// arrive. Only deliveries run on a scheduler are known.
+func (t *NetworkTransport) InFlightMessages() []Flight {
+	t.flightsMu.Lock()
+	flights := make([]Flight, 0, len(t.flights))
+	for _, flight := range t.flights {
+		flights = append(flights, flight)
+	}
+	t.flightsMu.Unlock()
+
+	sort.Slice(flights, func(i, j int) bool {
+		if !flights[i].DeliverAt.Equal(flights[j].DeliverAt) {
+			return flights[i].DeliverAt.Before(flights[j].DeliverAt)
+		}
+		return flights[i].seq < flights[j].seq
+	})
+	return flights
+}
+
+// DropMessage drops a message on its way, as if the network lost it: one
+// held back, or one delivered on a scheduler. It returns false when no
+// such message is on its way.
+func (t *NetworkTransport) DropMessage(id string) bool {
+	if t.DropHeld(id) {
+		return true
+	}
+
+	t.flightsMu.Lock()
+	flight, ok := t.flights[id]
+	if ok {
+		delete(t.flights, id)
+		t.dropped[id] = true
+	}
+	t.flightsMu.Unlock()
+	if !ok {
+		return false
+	}
+
+	t.inFlight.Add(-1)
+	t.mu.RLock()
+	dropHandler := t.dropHandler
+	t.mu.RUnlock()
+	t.reportDrop(dropHandler, flight.Envelope, "dropped_by_user")
+	return true
+}
+
+// SetScheduler makes messages arrive as events of s, ordered by virtual
+// time, instead of on wall-clock timers
+func (t *NetworkTransport) SetScheduler(s Scheduler) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.scheduler = s
 }
 
 // SetPacketLoss sets the probability of packet loss (0.0 to 1.0)
@@ -252,6 +625,43 @@ func (t *NetworkTransport) ClearBidirectionalPartition(a, b string) {
 	t.SetPartition(b, a, false)
 }
 
+// PartitionGroups splits the nodes into groups that cannot reach one
+// another, both ways, all at once. Nodes reach the nodes of their own
+// group as before, and nodes in no group are left alone.
+func (t *NetworkTransport) PartitionGroups(groups [][]string) {
+	t.setGroupPartitions(groups, true)
+}
+
+// HealGroups lets the groups of a PartitionGroups reach one another again
+func (t *NetworkTransport) HealGroups(groups [][]string) {
+	t.setGroupPartitions(groups, false)
+}
+
+// setGroupPartitions cuts or restores every link between two groups
+func (t *NetworkTransport) setGroupPartitions(groups [][]string, enabled bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i, group := range groups {
+		for _, other := range groups[i+1:] {
+			for _, a := range group {
+				for _, b := range other {
+					for _, link := range [][2]string{{a, b}, {b, a}} {
+						if !enabled {
+							delete(t.partitions[link[0]], link[1])
+							continue
+						}
+						if t.partitions[link[0]] == nil {
+							t.partitions[link[0]] = make(map[string]bool)
+						}
+						t.partitions[link[0]][link[1]] = true
+					}
+				}
+			}
+		}
+	}
+}
+
 // Close shuts down the transport
 func (t *NetworkTransport) Close() {
 	t.mu.Lock()
@@ -259,6 +669,75 @@ func (t *NetworkTransport) Close() {
 	t.closed = true
 }
 
+// Nodes returns the IDs of the nodes registered on the network, sorted
+func (t *NetworkTransport) Nodes() []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	nodes := make([]string, 0, len(t.handlers))
+	for nodeID := range t.handlers {
+		nodes = append(nodes, nodeID)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// Summary is a compact view of the current network conditions
+type Summary struct {
+	PacketLoss    float64
+	Latency       LatencyDistribution
+	MinLatency    time.Duration // Bounds of Latency
+	MaxLatency    time.Duration
+	Bandwidth     Bandwidth
+	FIFO          bool // Whether links deliver in send order, but those with their own setting
+	Partitions    int  // Pairs of nodes that cannot reach each other in at least one direction
+	InFlight      int  // Messages sent and not yet delivered, held ones included
+	Holding       bool // Whether messages sent are held back until released
+	Held          int  // Messages held back until released
+	Queued        int  // Messages waiting for or taking the bandwidth of their link
+	Serialization Serialization
+}
+
+// Summary returns the current network conditions
+func (t *NetworkTransport) Summary() Summary {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	pairs := make(map[[2]string]bool)
+	for from, tos := range t.partitions {
+		for to, blocked := range tos {
+			if !blocked {
+				continue
+			}
+			if from < to {
+				pairs[[2]string{from, to}] = true
+			} else {
+				pairs[[2]string{to, from}] = true
+			}
+		}
+	}
+
+	now := time.Now()
+	if t.scheduler != nil {
+		now = t.scheduler.GetVirtualTime()
+	}
+	minLatency, maxLatency := t.latency.Bounds()
+	return Summary{
+		PacketLoss:    t.packetLoss,
+		Latency:       t.latency,
+		MinLatency:    minLatency,
+		MaxLatency:    maxLatency,
+		Bandwidth:     t.bandwidth,
+		FIFO:          t.fifo,
+		Partitions:    len(pairs),
+		InFlight:      int(t.inFlight.Load()),
+		Holding:       t.Holding(),
+		Held:          len(t.Held()),
+		Queued:        t.Queued(now),
+		Serialization: t.serialization,
+	}
+}
+
 // GetNetworkStats returns current network configuration
 func (t *NetworkTransport) GetNetworkStats() map[string]interface{} {
 	t.mu.RLock()
@@ -274,10 +753,37 @@ func (t *NetworkTransport) GetNetworkStats() map[string]interface{} {
 		}
 	}
 
+	links := 0
+	for _, tos := range t.links {
+		links += len(tos)
+	}
+	linkLosses := make([]map[string]interface{}, 0)
+	for from, tos := range t.linkLosses {
+		for to, probability := range tos {
+			linkLosses = append(linkLosses, map[string]interface{}{
+				"from":       from,
+				"to":         to,
+				"packetLoss": probability,
+			})
+		}
+	}
+
+	slowNodes := make(map[string]string, len(t.nodeDelays))
+	for node, delay := range t.nodeDelays {
+		slowNodes[node] = delay.String()
+	}
+
+	minLatency, maxLatency := t.latency.Bounds()
 	return map[string]interface{}{
-		"minLatency":  t.minLatency.String(),
-		"maxLatency":  t.maxLatency.String(),
-		"packetLoss":  t.packetLoss,
-		"partitions":  partitionList,
+		"latency":    t.latency.String(),
+		"minLatency": minLatency.String(),
+		"maxLatency": maxLatency.String(),
+		"packetLoss": t.packetLoss,
+		"partitions": partitionList,
+		"links":      links,
+		"linkLosses": linkLosses,
+		"linkStats":  t.LinkStats(),
+		"typeStats":  t.TypeStats(),
+		"slowNodes":  slowNodes,
 	}
 }
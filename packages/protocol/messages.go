@@ -2,6 +2,7 @@ package protocol
 
 import (
 	"encoding/json"
+	"fmt"
 )
 
 // MessageType defines WebSocket message types
@@ -10,64 +11,498 @@ type MessageType string
 // Client -> Server message types
 const (
 	// Simulation control
-	MsgStartSimulation   MessageType = "start_simulation"
-	MsgPauseSimulation   MessageType = "pause_simulation"
-	MsgResumeSimulation  MessageType = "resume_simulation"
-	MsgStopSimulation    MessageType = "stop_simulation"
-	MsgStepForward       MessageType = "step_forward"
-	MsgSetSpeed          MessageType = "set_speed"
+	MsgStartSimulation  MessageType = "start_simulation"
+	MsgPauseSimulation  MessageType = "pause_simulation"
+	MsgResumeSimulation MessageType = "resume_simulation"
+	MsgStopSimulation   MessageType = "stop_simulation"
+	MsgStepForward      MessageType = "step_forward"
+	MsgStepEvent        MessageType = "step_event"
+	MsgStepN            MessageType = "step_n"
+	MsgSetSpeed         MessageType = "set_speed"
+	MsgStartTemplate    MessageType = "start_template"
+	MsgLoadScenario     MessageType = "load_scenario"
+	MsgScheduleControl  MessageType = "schedule_control"
+	MsgInstantReplay    MessageType = "instant_replay"
+	MsgFastForward      MessageType = "fast_forward"
+	MsgSaveCheckpoint   MessageType = "save_checkpoint"
+	MsgLoadCheckpoint   MessageType = "load_checkpoint"
+	MsgSetBreakpoints   MessageType = "set_breakpoints"
+	MsgRunToCompletion  MessageType = "run_to_completion"
+	MsgStartFuzz        MessageType = "start_fuzz"
+	MsgExplore          MessageType = "explore"
+
+	// Execution traces
+	MsgSaveExecutionTrace   MessageType = "save_execution_trace"
+	MsgReplayExecutionTrace MessageType = "replay_execution_trace"
 
 	// Failure injection
-	MsgInjectCrash     MessageType = "inject_crash"
-	MsgRecoverNode     MessageType = "recover_node"
-	MsgInjectPartition MessageType = "inject_partition"
-	MsgHealPartition   MessageType = "heal_partition"
+	MsgInjectCrash       MessageType = "inject_crash"
+	MsgRecoverNode       MessageType = "recover_node"
+	MsgInjectPartition   MessageType = "inject_partition"
+	MsgHealPartition     MessageType = "heal_partition"
+	MsgPartitionGroups   MessageType = "partition_groups"
+	MsgHealAllPartitions MessageType = "heal_all_partitions"
+	MsgInjectDelay       MessageType = "inject_delay"
+	MsgInjectCorruption  MessageType = "inject_corruption"
+	MsgSetLinkLatency    MessageType = "set_link_latency"
+	MsgSetLinkBandwidth  MessageType = "set_link_bandwidth"
+	MsgSetLinkPacketLoss MessageType = "set_link_packet_loss"
+	MsgSetFIFO           MessageType = "set_fifo"
+	MsgHoldMessages      MessageType = "hold_messages"
+	MsgDeliverMessage    MessageType = "deliver_message"
+	MsgDropMessage       MessageType = "drop_message"
+	MsgUndoLastFailure   MessageType = "undo_last_failure"
+	MsgSetNodeTickRate   MessageType = "set_node_tick_rate"
+	MsgSetTimeDilation   MessageType = "set_time_dilation"
 
 	// User interactions
 	MsgSendClientRequest MessageType = "send_client_request"
 	MsgSelectScenario    MessageType = "select_scenario"
+	MsgInvokeNodeAction  MessageType = "invoke_node_action"
 
 	// Query state
 	MsgGetState MessageType = "get_state"
+
+	// Protocol debugging
+	MsgStartTrace MessageType = "start_trace"
+	MsgStopTrace  MessageType = "stop_trace"
 )
 
 // Server -> Client message types
 const (
 	// State updates
-	MsgSimulationState  MessageType = "simulation_state"
-	MsgNodeStateUpdate  MessageType = "node_state_update"
+	MsgSimulationState MessageType = "simulation_state"
+	MsgNodeStateUpdate MessageType = "node_state_update"
+	MsgStepCompleted   MessageType = "step_completed"
+	MsgStepProgress    MessageType = "step_progress"
 
 	// Events
-	MsgMessageSent     MessageType = "message_sent"
-	MsgMessageReceived MessageType = "message_received"
-	MsgMessageDropped  MessageType = "message_dropped"
-	MsgLeaderElected   MessageType = "leader_elected"
+	MsgMessageSent      MessageType = "message_sent"
+	MsgMessageReceived  MessageType = "message_received"
+	MsgMessageDropped   MessageType = "message_dropped"
+	MsgMessageCorrupted MessageType = "message_corrupted"
+	MsgMessageHeld      MessageType = "message_held"
+	MsgLeaderElected    MessageType = "leader_elected"
 	MsgConsensusReached MessageType = "consensus_reached"
 	MsgTransactionState MessageType = "transaction_state"
 
+	// Performance mode
+	MsgPerformanceMode MessageType = "performance_mode"
+	MsgMessageStats    MessageType = "message_stats"
+	MsgEngineMetrics   MessageType = "engine_metrics"
+
+	// Network observation
+	MsgLatencyMatrix MessageType = "latency_matrix"
+	MsgNetworkStats  MessageType = "network_stats"
+
 	// Visualization
 	MsgTimelineEvent MessageType = "timeline_event"
 	MsgClockUpdate   MessageType = "clock_update"
 
+	// Run summaries
+	MsgRunSummary          MessageType = "run_summary"
+	MsgSimulationCompleted MessageType = "simulation_completed"
+
+	// Instant replay
+	MsgReplayStatus MessageType = "replay_status"
+	MsgReplayEvent  MessageType = "replay_event"
+
+	// Debugging
+	MsgTraceStatus MessageType = "trace_status"
+
+	// Checkpoints
+	MsgCheckpoint MessageType = "checkpoint"
+
+	// Execution traces
+	MsgExecutionTrace MessageType = "execution_trace"
+
+	// Breakpoints
+	MsgBreakpointHit MessageType = "breakpoint_hit"
+	MsgRunCompleted  MessageType = "run_completed"
+
+	// Schedule fuzzing
+	MsgFuzzReport    MessageType = "fuzz_report"
+	MsgExploreReport MessageType = "explore_report"
+
 	// Errors
 	MsgError MessageType = "error"
 )
 
-// BaseMessage is the base structure for all messages
+// BaseMessage is the base structure for all messages. What the server
+// broadcasts about a run also has a Sequence, one more than the previous
+// broadcast's, so clients can sort it, drop duplicates and detect gaps;
+// replies to a single client do not.
 type BaseMessage struct {
-	Type MessageType `json:"type"`
+	Type     MessageType `json:"type"`
+	Sequence uint64      `json:"sequence,omitempty"`
 }
 
 // StartSimulationRequest starts a simulation
 type StartSimulationRequest struct {
+	Type        MessageType      `json:"type"`
+	Project     string           `json:"project"`
+	Scenario    string           `json:"scenario,omitempty"`
+	Config      SimulationConfig `json:"config,omitempty"`
+	Network     *NetworkPreset   `json:"network,omitempty"`     // Overrides the project's network defaults
+	Faults      []FaultSpec      `json:"faults,omitempty"`      // Faults injected on a schedule after start
+	Controls    []ControlSpec    `json:"controls,omitempty"`    // Speed changes and pauses on a schedule after start
+	Breakpoints []BreakpointSpec `json:"breakpoints,omitempty"` // Conditions that pause the run
+	Invariants  []InvariantSpec  `json:"invariants,omitempty"`  // Checked on top of the project's own
+	Workload    []WorkloadSpec   `json:"workload,omitempty"`    // Client requests made on a schedule after start
+}
+
+// SimulationConfig holds the tunable parameters of a simulation run
+type SimulationConfig struct {
+	NodeCount int     `json:"nodeCount,omitempty"`
+	Speed     float64 `json:"speed,omitempty"`
+	StepMode  bool    `json:"stepMode,omitempty"`
+
+	// Seed of the run's random numbers: starting with the seed another run
+	// reported replays its random choices (0 = pick one)
+	Seed int64 `json:"seed,omitempty"`
+
+	// PerfThreshold is the message events per tick above which individual
+	// message events are replaced by per-link counts (0 = default, <0 = never)
+	PerfThreshold int `json:"perfThreshold,omitempty"`
+
+	// LatencyMatrixMaxNodes is the largest cluster for which the observed
+	// latency between every pair of nodes is reported (0 = default, <0 =
+	// never)
+	LatencyMatrixMaxNodes int `json:"latencyMatrixMaxNodes,omitempty"`
+
+	// FuzzWindowMs fuzzes the schedule of the run: every delivery is
+	// delayed by up to this much more and the nodes tick in a shuffled
+	// order, both drawn from the seed (0 = off)
+	FuzzWindowMs int64 `json:"fuzzWindowMs,omitempty"`
+
+	// PauseOnViolation pauses the run when an invariant the project
+	// checks after every tick is violated
+	PauseOnViolation bool `json:"pauseOnViolation,omitempty"`
+
+	// PauseOnEvents pauses the run every time an event of one of these
+	// types occurs, e.g. "leader_elected" or "message_dropped", like an
+	// event breakpoint that stays armed
+	PauseOnEvents []string `json:"pauseOnEvents,omitempty"`
+
+	// NodeTickRatesMs makes nodes tick at their own interval instead of in
+	// lockstep with the others, e.g. to watch a slow node lag behind its
+	// quorum (by node ID)
+	NodeTickRatesMs map[string]int64 `json:"nodeTickRatesMs,omitempty"`
+
+	// ParallelTicks ticks the nodes on this many goroutines at once, for
+	// runs of hundreds of nodes. The run then no longer replays from its
+	// seed (0 = one node after the other)
+	ParallelTicks int `json:"parallelTicks,omitempty"`
+
+	// MaxTicks and MaxVirtualDurationMs stop the run once it has ticked
+	// that many times or run that long in virtual time, with a run summary
+	// that says so (0 = no limit)
+	MaxTicks             int   `json:"maxTicks,omitempty"`
+	MaxVirtualDurationMs int64 `json:"maxVirtualDurationMs,omitempty"`
+}
+
+// NetworkPreset describes network characteristics applied to the transport
+type NetworkPreset struct {
+	MinLatencyMs int64                    `json:"minLatencyMs"`
+	MaxLatencyMs int64                    `json:"maxLatencyMs"`
+	Distribution *LatencyDistributionSpec `json:"distribution,omitempty"` // nil = uniform
+	PacketLoss   float64                  `json:"packetLoss"`
+	Links        []LinkLatencySpec        `json:"links,omitempty"`  // Links slower or faster than the rest
+	Losses       []LinkPacketLossSpec     `json:"losses,omitempty"` // Links flakier or sounder than the rest
+
+	// BandwidthBytesPerSec limits every link: a message waits for those
+	// sent on its link before it, then takes its size over the bandwidth
+	// to go out, and is dropped if QueueCapacity messages are already
+	// waiting or going out (0 = unlimited)
+	BandwidthBytesPerSec int64               `json:"bandwidthBytesPerSec,omitempty"`
+	QueueCapacity        int                 `json:"queueCapacity,omitempty"`
+	Bandwidths           []LinkBandwidthSpec `json:"bandwidths,omitempty"` // Links narrower or wider than the rest
+
+	// FIFO makes every link deliver messages in the order they were sent;
+	// otherwise each takes its own latency and may overtake those before
+	FIFO      bool           `json:"fifo,omitempty"`
+	FIFOLinks []LinkFIFOSpec `json:"fifoLinks,omitempty"` // Links ordered otherwise than the rest
+
+	// Serialize encodes every message as JSON to tell its size on the
+	// wire, which the bandwidth carries and network_stats counts; encoding
+	// takes SerializeCostPerKBMs per 1024 bytes, added to its latency
+	Serialize            bool    `json:"serialize,omitempty"`
+	SerializeCostPerKBMs float64 `json:"serializeCostPerKBMs,omitempty"`
+}
+
+// LinkFIFOSpec sets whether the link from one node to another delivers
+// messages in the order they were sent, apart from the rest of the network
+type LinkFIFOSpec struct {
+	From          string `json:"from"`
+	To            string `json:"to"`
+	FIFO          bool   `json:"fifo"`
+	Bidirectional bool   `json:"bidirectional,omitempty"` // And from To to From
+}
+
+// LinkPacketLossSpec sets the fraction of messages (0.0 to 1.0) lost on
+// the link from one node to another apart from the rest of the network
+type LinkPacketLossSpec struct {
+	From          string  `json:"from"`
+	To            string  `json:"to"`
+	PacketLoss    float64 `json:"packetLoss"`
+	Bidirectional bool    `json:"bidirectional,omitempty"` // And from To to From
+}
+
+// LinkBandwidthSpec sets the bandwidth and queue capacity of the link
+// from one node to another apart from the rest of the network (0 =
+// unlimited)
+type LinkBandwidthSpec struct {
+	From          string `json:"from"`
+	To            string `json:"to"`
+	BytesPerSec   int64  `json:"bytesPerSec"`
+	QueueCapacity int    `json:"queueCapacity,omitempty"`
+	Bidirectional bool   `json:"bidirectional,omitempty"` // And from To to From
+}
+
+// LatencyDistributionSpec draws latencies from another distribution than
+// the uniform one between the minimum and maximum latency, which then
+// bound it (a maximum of 0 = none). Heavy tails, where a few messages
+// take far longer than the rest, are what quorums and speculative retries
+// are about.
+type LatencyDistributionSpec struct {
+	Kind     string  `json:"kind"`               // "uniform", "normal", "lognormal" or "pareto"
+	MeanMs   int64   `json:"meanMs,omitempty"`   // Normal
+	StdDevMs int64   `json:"stdDevMs,omitempty"` // Normal
+	MedianMs int64   `json:"medianMs,omitempty"` // Lognormal
+	Sigma    float64 `json:"sigma,omitempty"`    // Lognormal: the larger, the longer the tail
+	Shape    float64 `json:"shape,omitempty"`    // Pareto, from the minimum latency on: the smaller, the heavier the tail
+}
+
+// LinkLatencySpec sets the latency of the messages from one node to
+// another apart from the rest of the network, e.g. for one slow replica
+// or a link across regions
+type LinkLatencySpec struct {
+	From          string                   `json:"from"`
+	To            string                   `json:"to"`
+	MinLatencyMs  int64                    `json:"minLatencyMs"`
+	MaxLatencyMs  int64                    `json:"maxLatencyMs"`
+	Distribution  *LatencyDistributionSpec `json:"distribution,omitempty"`  // nil = uniform
+	Bidirectional bool                     `json:"bidirectional,omitempty"` // And from To to From
+}
+
+// FaultSpec describes a fault injected at a fixed offset from start
+type FaultSpec struct {
+	Type          string `json:"type"`             // "crash", "partition" or "delay"
+	Target        string `json:"target,omitempty"` // Node ID for crashes and delays
+	From          string `json:"from,omitempty"`   // Partition endpoints
+	To            string `json:"to,omitempty"`
+	Bidirectional bool   `json:"bidirectional,omitempty"`
+	DelayMs       int64  `json:"delayMs,omitempty"` // Extra delay of a slow node
+	AtMs          int64  `json:"atMs"`
+	DurationMs    int64  `json:"durationMs,omitempty"` // 0 = permanent
+}
+
+// ControlSpec describes a control change applied at a fixed virtual time
+// from start. Virtual time stands still while the simulation is paused, so
+// a pause can be scheduled but the resume cannot.
+type ControlSpec struct {
+	Action string  `json:"action"`          // "speed" or "pause"
+	Speed  float64 `json:"speed,omitempty"` // New speed multiplier
+	AtMs   int64   `json:"atMs"`
+}
+
+// WorkloadSpec describes client requests made from a fixed virtual time
+// after start, once or every EveryMs
+type WorkloadSpec struct {
+	Command string                 `json:"command"`
+	Params  map[string]interface{} `json:"params,omitempty"`
+	AtMs    int64                  `json:"atMs"`
+	EveryMs int64                  `json:"everyMs,omitempty"` // 0 = once
+	Count   int                    `json:"count,omitempty"`   // Requests made when repeated; 0 = until the run ends
+}
+
+// ScheduleControlRequest adds control changes to the running simulation
+type ScheduleControlRequest struct {
+	Type     MessageType   `json:"type"`
+	Controls []ControlSpec `json:"controls"`
+	Replace  bool          `json:"replace,omitempty"` // Drop the changes still pending first
+}
+
+// BreakpointSpec pauses the run when its condition holds:
+//   - "event": an event of type EventType occurs, or a message of that
+//     type is sent; with NodeID, only one involving that node
+//   - "role": node NodeID, or any node without it, takes role Role
+//   - "messages": more than Count messages have been sent
+type BreakpointSpec struct {
+	ID        string `json:"id,omitempty"` // Assigned by the server when empty
+	Condition string `json:"condition"`
+	EventType string `json:"eventType,omitempty"`
+	NodeID    string `json:"nodeId,omitempty"`
+	Role      string `json:"role,omitempty"`
+	Count     int64  `json:"count,omitempty"`
+	Repeat    bool   `json:"repeat,omitempty"` // Stay armed after a hit; "messages" then waits for Count more
+}
+
+// SetBreakpointsRequest arms breakpoints on the running simulation
+type SetBreakpointsRequest struct {
+	Type        MessageType      `json:"type"`
+	Breakpoints []BreakpointSpec `json:"breakpoints"`
+	Replace     bool             `json:"replace,omitempty"` // Disarm the others first; with none, clears them all
+}
+
+// BreakpointHitResponse announces that a breakpoint paused the run, with
+// the event that triggered it
+type BreakpointHitResponse struct {
+	Type       MessageType    `json:"type"`
+	Breakpoint BreakpointSpec `json:"breakpoint"`
+	Event      TimelineEvent  `json:"event"`
+	ElapsedMs  int64          `json:"elapsedMs"` // Virtual time since the start of the run
+}
+
+// RunToCompletionRequest runs the simulation as fast as it goes, without
+// streaming it, until one of the Until breakpoints hits or MaxMs of
+// virtual time has passed
+type RunToCompletionRequest struct {
+	Type  MessageType      `json:"type"`
+	Until []BreakpointSpec `json:"until,omitempty"`
+	MaxMs int64            `json:"maxMs,omitempty"` // 0 = the longest allowed
+}
+
+// RunCompletedResponse ends a run to completion with what happened
+// during it, compressed: the events of the types that occurred only a few
+// times, and how many of each type there were
+type RunCompletedResponse struct {
+	Type        MessageType             `json:"type"`
+	Reason      string                  `json:"reason"` // "breakpoint", "done", "limit", "timeout" or "interrupted"
+	Hits        []BreakpointHitResponse `json:"hits,omitempty"`
+	ElapsedMs   int64                   `json:"elapsedMs"` // Virtual time run to completion
+	WallMs      int64                   `json:"wallMs"`    // Wall-clock time it took
+	KeyEvents   []TimelineEvent         `json:"keyEvents"`
+	EventCounts map[string]int          `json:"eventCounts"`
+}
+
+// InvariantSpec is an invariant defined by the user:
+//   - "unique_role": at most one node holds role Role at a time; with Per,
+//     at most one ever does for each value of that field, as in "one
+//     leader per term"
+//   - "agreement": when the run ends, the nodes that have a value for
+//     Field all have the same one
+//   - "expect": when the run ends, node Node, or some node when Node is
+//     empty, has the value Equals for Field
+//
+// Fields are "role", "status", "term" or a key of the custom state.
+type InvariantSpec struct {
+	Name   string `json:"name,omitempty"` // Derived from the rest when empty
+	Kind   string `json:"kind"`
+	Role   string `json:"role,omitempty"`
+	Per    string `json:"per,omitempty"`
+	Field  string `json:"field,omitempty"`
+	Node   string `json:"node,omitempty"`
+	Equals string `json:"equals,omitempty"`
+}
+
+// FuzzRequest runs a simulation again and again, each time with the next
+// seed and a fuzzed schedule, looking for runs that violate an invariant
+type FuzzRequest struct {
+	Type     MessageType            `json:"type"`
+	Request  StartSimulationRequest `json:"request"`            // The run to fuzz, from its seed on
+	Runs     int                    `json:"runs,omitempty"`     // 0 = 50
+	RunMs    int64                  `json:"runMs,omitempty"`    // Virtual time of each run; 0 = 30s
+	WindowMs int64                  `json:"windowMs,omitempty"` // Fuzz window; 0 = the request's or 50ms
+}
+
+// FuzzReportResponse ends a fuzzing campaign with the runs that violated
+// an invariant. The first of them is left loaded, paused, to be explored.
+type FuzzReportResponse struct {
+	Type      MessageType `json:"type"`
+	Project   string      `json:"project"`
+	Scenario  string      `json:"scenario,omitempty"`
+	WindowMs  int64       `json:"windowMs"`
+	Runs      int         `json:"runs"`      // Runs asked for
+	Completed int         `json:"completed"` // Runs made before it ended
+	Failing   []FuzzRun   `json:"failing"`
+	WallMs    int64       `json:"wallMs"`
+}
+
+// FuzzRun is a run of a fuzzing campaign: starting with its seed and the
+// campaign's window replays it
+type FuzzRun struct {
+	Seed       int64             `json:"seed"`
+	Violations []InvariantResult `json:"violations"`
+}
+
+// ExploreRequest explores every interleaving of a small simulation's
+// events up to Depth events from its start: at each event, the next one
+// due runs or any message on its way is delivered at once instead
+type ExploreRequest struct {
+	Type     MessageType            `json:"type"`
+	Request  StartSimulationRequest `json:"request"`            // The run to explore, from its seed on
+	Depth    int                    `json:"depth,omitempty"`    // 0 = 12
+	MaxPaths int                    `json:"maxPaths,omitempty"` // Interleavings explored at most; 0 = 2000
+}
+
+// ExploreReportResponse ends an exploration with the interleavings that
+// violated an invariant, the first for each invariant. The first of them
+// is left loaded, paused where it was violated, to be explored.
+type ExploreReportResponse struct {
+	Type            MessageType      `json:"type"`
+	Project         string           `json:"project"`
+	Scenario        string           `json:"scenario,omitempty"`
+	Depth           int              `json:"depth"`
+	Paths           int              `json:"paths"`     // Interleavings explored
+	Exhausted       bool             `json:"exhausted"` // All of them were, up to the depth
+	Counterexamples []Counterexample `json:"counterexamples"`
+	WallMs          int64            `json:"wallMs"`
+}
+
+// Counterexample is an interleaving that violates an invariant, traced
+// so that replaying the trace runs it again
+type Counterexample struct {
+	Violation InvariantResult `json:"violation"`
+	Trace     ExecutionTrace  `json:"trace"`
+}
+
+// StartTemplateRequest launches a saved simulation template
+type StartTemplateRequest struct {
+	Type      MessageType `json:"type"`
+	Namespace string      `json:"namespace"`
+	Name      string      `json:"name"`
+}
+
+// Lesson is a curated, repeatable run in one document: the simulation to
+// start, what happens to it on a schedule and what should hold, for
+// instructors to ship as a file
+type Lesson struct {
+	Name        string           `json:"name"`
+	Description string           `json:"description,omitempty"`
+	Project     string           `json:"project"`
+	Scenario    string           `json:"scenario,omitempty"`
+	Config      SimulationConfig `json:"config,omitempty"`
+	Network     *NetworkPreset   `json:"network,omitempty"`
+	Faults      []FaultSpec      `json:"faults,omitempty"`
+	Controls    []ControlSpec    `json:"controls,omitempty"`
+	Workload    []WorkloadSpec   `json:"workload,omitempty"`
+	Breakpoints []BreakpointSpec `json:"breakpoints,omitempty"`
+	Invariants  []InvariantSpec  `json:"invariants,omitempty"` // The assertions the lesson expects to hold
+}
+
+// StartRequest converts the lesson into a start simulation request
+func (l *Lesson) StartRequest() StartSimulationRequest {
+	return StartSimulationRequest{
+		Type:        MsgStartSimulation,
+		Project:     l.Project,
+		Scenario:    l.Scenario,
+		Config:      l.Config,
+		Network:     l.Network,
+		Faults:      l.Faults,
+		Controls:    l.Controls,
+		Breakpoints: l.Breakpoints,
+		Invariants:  l.Invariants,
+		Workload:    l.Workload,
+	}
+}
+
+// LoadScenarioRequest starts a lesson, given inline or by the name of a
+// lesson file the server ships
+type LoadScenarioRequest struct {
 	Type     MessageType `json:"type"`
-	Project  string      `json:"project"`
-	Scenario string      `json:"scenario,omitempty"`
-	Config   struct {
-		NodeCount int     `json:"nodeCount,omitempty"`
-		Speed     float64 `json:"speed,omitempty"`
-		StepMode  bool    `json:"stepMode,omitempty"`
-	} `json:"config,omitempty"`
+	Name     string      `json:"name,omitempty"`
+	Scenario *Lesson     `json:"scenario,omitempty"` // Takes precedence over Name
 }
 
 // SetSpeedRequest sets simulation speed
@@ -104,6 +539,313 @@ type HealPartitionRequest struct {
 	Bidirectional bool        `json:"bidirectional,omitempty"`
 }
 
+// PartitionGroupsRequest splits the cluster into named sides, e.g. a
+// majority and a minority, that cannot reach one another
+type PartitionGroupsRequest struct {
+	Type   MessageType      `json:"type"`
+	Groups []PartitionGroup `json:"groups"`
+}
+
+// PartitionGroup is a side of a split cluster: its nodes reach one another
+// but none of the other sides. Nodes in no group are left alone.
+type PartitionGroup struct {
+	Name  string   `json:"name,omitempty"`
+	Nodes []string `json:"nodes"`
+}
+
+// InjectCorruptionRequest makes a fraction of the messages arrive with
+// their payload corrupted: a field flipped, the fields from one on lost,
+// or the whole payload replaced with garbage
+type InjectCorruptionRequest struct {
+	Type        MessageType `json:"type"`
+	Probability float64     `json:"probability"`           // 0 = no more corruption
+	Corruptions []string    `json:"corruptions,omitempty"` // "flip", "truncate" or "garbage" (none = any)
+}
+
+// SetLinkLatencyRequest sets the latency of a link, or with Reset brings
+// it back to the latency of the rest of the network
+type SetLinkLatencyRequest struct {
+	Type MessageType `json:"type"`
+	LinkLatencySpec
+	Reset bool `json:"reset,omitempty"`
+}
+
+// SetLinkPacketLossRequest sets the packet loss of a link, or with Reset
+// brings it back to the packet loss of the rest of the network
+type SetLinkPacketLossRequest struct {
+	Type MessageType `json:"type"`
+	LinkPacketLossSpec
+	Reset bool `json:"reset,omitempty"`
+}
+
+// SetLinkBandwidthRequest sets the bandwidth of a link, or with Reset
+// brings it back to the bandwidth of the rest of the network
+type SetLinkBandwidthRequest struct {
+	Type MessageType `json:"type"`
+	LinkBandwidthSpec
+	Reset bool `json:"reset,omitempty"`
+}
+
+// SetFIFORequest sets whether a link delivers messages in the order they
+// were sent, or every link without From and To. With Reset the link is
+// brought back to the ordering of the rest of the network.
+type SetFIFORequest struct {
+	Type MessageType `json:"type"`
+	LinkFIFOSpec
+	Reset bool `json:"reset,omitempty"`
+}
+
+// HoldMessagesRequest makes the network hold back every message sent
+// until the client delivers or drops it, or with Hold false releases
+// those held, in the order they were sent
+type HoldMessagesRequest struct {
+	Type MessageType `json:"type"`
+	Hold bool        `json:"hold"`
+}
+
+// DeliverMessageRequest delivers a held message at once, ahead of those
+// held before it, or with Drop loses it
+type DeliverMessageRequest struct {
+	Type      MessageType `json:"type"`
+	MessageID string      `json:"messageId"` // Empty = the oldest held
+	Drop      bool        `json:"drop,omitempty"`
+}
+
+// DropMessageRequest drops a message on its way, held back or not, as
+// if the network lost it
+type DropMessageRequest struct {
+	Type      MessageType `json:"type"`
+	MessageID string      `json:"messageId"`
+}
+
+// InjectDelayRequest makes a node slow: everything it sends is held back
+// by DelayMs on top of the link latency
+type InjectDelayRequest struct {
+	Type    MessageType `json:"type"`
+	NodeID  string      `json:"nodeId"`
+	DelayMs int64       `json:"delayMs"` // 0 = back to normal
+}
+
+// SetNodeTickRateRequest makes a node tick every TickMs of virtual time
+// instead of in lockstep with the others
+type SetNodeTickRateRequest struct {
+	Type   MessageType `json:"type"`
+	NodeID string      `json:"nodeId"`
+	TickMs int64       `json:"tickMs"` // 0 = back in lockstep
+}
+
+// SetTimeDilationRequest makes time pass Factor times as fast for a node
+// as for the others, for DurationMs of virtual time or until set again:
+// 0.5 for an overloaded machine, 0 for a GC pause
+type SetTimeDilationRequest struct {
+	Type       MessageType `json:"type"`
+	NodeID     string      `json:"nodeId"`
+	Factor     float64     `json:"factor"`               // 1 = back in lockstep, 0 = stopped
+	DurationMs int64       `json:"durationMs,omitempty"` // 0 = until set again
+}
+
+// StartTraceRequest enables raw traffic capture for the sending client
+type StartTraceRequest struct {
+	Type     MessageType `json:"type"`
+	MaxBytes int         `json:"maxBytes,omitempty"`
+}
+
+// TraceStatusResponse reports the state of a client's traffic capture
+type TraceStatusResponse struct {
+	Type   MessageType            `json:"type"`
+	Status map[string]interface{} `json:"status"`
+}
+
+// StepCompletedResponse answers a step forward once the tick and every
+// event due before it have run, with the state they left
+type StepCompletedResponse struct {
+	Type      MessageType              `json:"type"`
+	Steps     int                      `json:"steps"`
+	ElapsedMs int64                    `json:"elapsedMs"` // Virtual time since the start of the run
+	State     *SimulationStateResponse `json:"state"`
+}
+
+// StepNRequest steps forward Count times in a row, reporting progress on
+// the way, instead of one step_forward per step
+type StepNRequest struct {
+	Type  MessageType `json:"type"`
+	Count int         `json:"count"`
+}
+
+// StepProgressResponse tells how far a step_n has got, "stepping 34/100"
+type StepProgressResponse struct {
+	Type      MessageType `json:"type"`
+	Done      int         `json:"done"`
+	Total     int         `json:"total"`
+	ElapsedMs int64       `json:"elapsedMs"` // Virtual time since the start of the run
+}
+
+// StepEventRequest runs a single event of the run, ahead of those due
+// before it unless it is the next: e.g. one message delivered while others
+// wait
+type StepEventRequest struct {
+	Type MessageType `json:"type"`
+	Seq  uint64      `json:"seq,omitempty"` // 0 = the next due
+}
+
+// FastForwardRequest runs the next DurationMs of virtual time at once
+type FastForwardRequest struct {
+	Type       MessageType `json:"type"`
+	DurationMs int64       `json:"durationMs"`
+}
+
+// InstantReplayRequest shows the last Seconds of the session again,
+// slowed down to Speed
+type InstantReplayRequest struct {
+	Type    MessageType `json:"type"`
+	Seconds float64     `json:"seconds,omitempty"` // 0 = default
+	Speed   float64     `json:"speed,omitempty"`   // Below 1, 0 = default
+}
+
+// ReplayStatusResponse announces the start and the end of an instant
+// replay
+type ReplayStatusResponse struct {
+	Type     MessageType `json:"type"`
+	ReplayID int         `json:"replayId"`
+	Status   string      `json:"status"` // "started" or "finished"
+	Seconds  float64     `json:"seconds"`
+	Speed    float64     `json:"speed"`
+	Messages int         `json:"messages"` // Messages the replay shows
+}
+
+// ReplayEventResponse wraps a message broadcast earlier, shown again by
+// an instant replay. It is never a live message.
+type ReplayEventResponse struct {
+	Type     MessageType     `json:"type"`
+	ReplayID int             `json:"replayId"`
+	Replay   bool            `json:"replay"`   // Always true
+	OffsetMs int64           `json:"offsetMs"` // Since the start of the replayed window, in recorded time
+	Message  json.RawMessage `json:"message"`
+}
+
+// LoadCheckpointRequest replaces the current run with a saved one
+type LoadCheckpointRequest struct {
+	Type       MessageType `json:"type"`
+	Checkpoint Checkpoint  `json:"checkpoint"`
+}
+
+// CheckpointResponse carries a checkpoint of the current run, to keep and
+// load later, also on another server process
+type CheckpointResponse struct {
+	Type       MessageType `json:"type"`
+	Checkpoint Checkpoint  `json:"checkpoint"`
+}
+
+// Checkpoint is a saved run: how it started, with the seed it drew from,
+// and the inputs made since at their virtual time. Replaying them
+// reproduces the run up to ElapsedNs; Nodes and InFlight are the state it
+// had then, to check the restored run against.
+type Checkpoint struct {
+	Version   int                    `json:"version"`
+	Request   StartSimulationRequest `json:"request"`
+	ElapsedNs int64                  `json:"elapsedNs"` // Virtual time since the start of the run
+	Inputs    []CheckpointInput      `json:"inputs"`
+	Nodes     map[string]NodeState   `json:"nodes"`
+	InFlight  []InFlightMessage      `json:"inFlight"`
+	SavedAt   int64                  `json:"savedAt"` // Wall clock, Unix milliseconds
+}
+
+// CheckpointInput is a user input to a run: a crash, recovery, partition,
+// heal, delay, corruption, link latency, loss, bandwidth or ordering,
+// messages held, delivered or dropped, tick rate, time dilation, client
+// request, node action or single event stepped through
+type CheckpointInput struct {
+	AtNs          int64                  `json:"atNs"`             // Virtual time since the start of the run
+	Events        uint64                 `json:"events,omitempty"` // Events the engine ran before it
+	Kind          string                 `json:"kind"`
+	NodeID        string                 `json:"nodeId,omitempty"`
+	From          string                 `json:"from,omitempty"`
+	To            string                 `json:"to,omitempty"`
+	Bidirectional bool                   `json:"bidirectional,omitempty"`
+	DelayMs       int64                  `json:"delayMs,omitempty"`
+	Probability   float64                `json:"probability,omitempty"` // Of corrupting messages
+	Corruptions   []string               `json:"corruptions,omitempty"`
+	Link          *LinkLatencySpec       `json:"link,omitempty"`      // Link latency set or reset
+	Bandwidth     *LinkBandwidthSpec     `json:"bandwidth,omitempty"` // Link bandwidth set or reset
+	Loss          *LinkPacketLossSpec    `json:"loss,omitempty"`      // Link packet loss set or reset
+	FIFO          *LinkFIFOSpec          `json:"fifo,omitempty"`      // Ordering set or reset
+	Held          int                    `json:"held,omitempty"`      // Position among those held of the message delivered or dropped (1 = the oldest)
+	Flight        int                    `json:"flight,omitempty"`    // Position among those on their way of the message dropped (1 = the first due)
+	Groups        []PartitionGroup       `json:"groups,omitempty"`    // Sides of a split cluster
+	TickMs        int64                  `json:"tickMs,omitempty"`
+	Factor        float64                `json:"factor,omitempty"`     // Time dilation
+	DurationMs    int64                  `json:"durationMs,omitempty"` // Of the time dilation
+	Seq           uint64                 `json:"seq,omitempty"`        // Event stepped through
+	Command       string                 `json:"command,omitempty"`    // Client request command, or node action
+	Params        map[string]interface{} `json:"params,omitempty"`
+}
+
+// ReplayExecutionTraceRequest replaces the current run with the one
+// traced, replayed event by event
+type ReplayExecutionTraceRequest struct {
+	Type  MessageType    `json:"type"`
+	Trace ExecutionTrace `json:"trace"`
+}
+
+// ExecutionTraceResponse carries the execution trace of the current run,
+// to share as a file and replay later
+type ExecutionTraceResponse struct {
+	Type  MessageType    `json:"type"`
+	Trace ExecutionTrace `json:"trace"`
+}
+
+// ExecutionTrace is every event a run ran: ticks, message deliveries and
+// timers, with the random numbers drawn up to each. With the seed and the
+// inputs, a replay re-runs the same events and tells where it first
+// differs.
+type ExecutionTrace struct {
+	Version   int                    `json:"version"`
+	Request   StartSimulationRequest `json:"request"`
+	Inputs    []CheckpointInput      `json:"inputs"`
+	Entries   []ExecutionTraceEntry  `json:"entries"`
+	Truncated bool                   `json:"truncated,omitempty"` // The run went on beyond the entries
+	SavedAt   int64                  `json:"savedAt"`             // Wall clock, Unix milliseconds
+}
+
+// ExecutionTraceEntry is an event of a run
+type ExecutionTraceEntry struct {
+	Index  uint64 `json:"index"`         // Events run before it
+	Seq    uint64 `json:"seq,omitempty"` // Order it was scheduled in: a replay runs it next even if others are due before it (0 = the next due)
+	AtNs   int64  `json:"atNs"`          // Virtual time since the start of the run
+	Label  string `json:"label"`         // "tick", "timer" or the message delivered
+	Draws  uint64 `json:"draws"`         // Random numbers drawn so far
+	Digest uint64 `json:"digest"`
+}
+
+// InFlightMessage is a message sent and not yet delivered
+type InFlightMessage struct {
+	MessageID   string      `json:"messageId"`
+	From        string      `json:"from"`
+	To          string      `json:"to"`
+	MessageType string      `json:"messageType"`
+	Payload     interface{} `json:"payload,omitempty"`
+	DeliverAtNs int64       `json:"deliverAtNs"` // Virtual time since the start of the run
+}
+
+// StateExport is the exact situation of a run, to share with another
+// user: the engine's snapshot of its mode, clock, nodes and pending events,
+// and the checkpoint that brings a run back to it, with the messages in
+// flight
+type StateExport struct {
+	Version    int             `json:"version"`
+	Engine     json.RawMessage `json:"engine"`
+	Checkpoint Checkpoint      `json:"checkpoint"`
+	ExportedAt int64           `json:"exportedAt"` // Wall clock, Unix milliseconds
+}
+
+// StateImportResponse tells how closely an imported run matches the
+// export
+type StateImportResponse struct {
+	ElapsedMs       int64    `json:"elapsedMs"`
+	Mode            string   `json:"mode"`
+	MismatchedNodes []string `json:"mismatchedNodes"` // Nodes whose state differs from the export's
+}
+
 // ClientRequest sends a client request to the simulation
 type ClientRequest struct {
 	Type    MessageType            `json:"type"`
@@ -111,17 +853,111 @@ type ClientRequest struct {
 	Payload map[string]interface{} `json:"payload,omitempty"`
 }
 
+// InvokeNodeActionRequest invokes an action a node of the running project
+// offers, listed in its NodeState
+type InvokeNodeActionRequest struct {
+	Type   MessageType            `json:"type"`
+	NodeID string                 `json:"nodeId"`
+	Action string                 `json:"action"`
+	Params map[string]interface{} `json:"params,omitempty"`
+}
+
 // SimulationStateResponse contains the full simulation state
 type SimulationStateResponse struct {
-	Type        MessageType              `json:"type"`
-	VirtualTime int64                    `json:"virtualTime"`
-	Mode        string                   `json:"mode"`
-	Speed       float64                  `json:"speed"`
-	Running     bool                     `json:"running"`
-	Nodes       map[string]NodeState     `json:"nodes"`
-	Messages    []MessageState           `json:"messages,omitempty"`
-	Partitions  []PartitionState         `json:"partitions,omitempty"`
-	Timeline    []TimelineEvent          `json:"timeline,omitempty"`
+	Type         MessageType            `json:"type"`
+	VirtualTime  int64                  `json:"virtualTime"`
+	Mode         string                 `json:"mode"`
+	Speed        float64                `json:"speed"`
+	Running      bool                   `json:"running"`
+	Nodes        map[string]NodeState   `json:"nodes"`
+	Messages     []MessageState         `json:"messages,omitempty"`
+	Partitions   []PartitionState       `json:"partitions,omitempty"`
+	Timeline     []TimelineEvent        `json:"timeline,omitempty"`
+	Metadata     map[string]interface{} `json:"metadata,omitempty"`     // Project-level state not tied to a node
+	Layout       *Layout                `json:"layout,omitempty"`       // Preferred arrangement of the nodes
+	Network      *NetworkSummary        `json:"network,omitempty"`      // Current network conditions
+	Capabilities *Capabilities          `json:"capabilities,omitempty"` // Controls the running project responds to
+	Seed         int64                  `json:"seed,omitempty"`         // Seed of the run, to replay it
+	Pending      []PendingEvent         `json:"pending,omitempty"`      // Events waiting to run, while stepping or paused
+	Held         []HeldMessage          `json:"held,omitempty"`         // Messages the network holds back, in the order they were sent
+}
+
+// HeldMessage is a message the network holds back until the client
+// delivers or drops it
+type HeldMessage struct {
+	MessageID   string      `json:"messageId"`
+	From        string      `json:"from"`
+	To          string      `json:"to"`
+	MessageType string      `json:"messageType"`
+	Payload     interface{} `json:"payload,omitempty"`
+	HeldAtNs    int64       `json:"heldAtNs"` // Virtual time since the start of the run
+}
+
+// PendingEvent is an event of the run waiting for its virtual time: a tick
+// of the nodes, a timer or the delivery of a message
+type PendingEvent struct {
+	Seq         uint64 `json:"seq"`  // Order it was scheduled in, to step through it
+	AtNs        int64  `json:"atNs"` // Virtual time it is due at
+	Kind        string `json:"kind"` // "tick", "timer" or "deliver"
+	MessageType string `json:"messageType,omitempty"`
+	From        string `json:"from,omitempty"`
+	To          string `json:"to,omitempty"`
+}
+
+// Capabilities tells which optional controls the running project
+// implements, so clients only offer those that do something
+type Capabilities struct {
+	SupportsClientRequests     bool `json:"supportsClientRequests"`
+	SupportsNodeActions        bool `json:"supportsNodeActions"`
+	SupportsMembershipChange   bool `json:"supportsMembershipChange"`
+	SupportsByzantineInjection bool `json:"supportsByzantineInjection"`
+	SupportsCheckpoints        bool `json:"supportsCheckpoints"`
+}
+
+// NetworkSummary describes the network conditions a simulation runs under
+type NetworkSummary struct {
+	PacketLoss           float64              `json:"packetLoss"`
+	MinLatencyMs         int64                `json:"minLatencyMs"`
+	MaxLatencyMs         int64                `json:"maxLatencyMs"`
+	Latency              string               `json:"latency,omitempty"` // How latencies are drawn, e.g. "pareto 20ms α=1.5"
+	Partitions           int                  `json:"partitions"`        // Node pairs cut off in at least one direction
+	InFlight             int                  `json:"inFlight"`          // Messages sent and not yet delivered
+	Queued               int                  `json:"queued,omitempty"`  // Messages waiting for or taking the bandwidth of their link
+	BandwidthBytesPerSec int64                `json:"bandwidthBytesPerSec,omitempty"`
+	QueueCapacity        int                  `json:"queueCapacity,omitempty"`
+	FIFO                 bool                 `json:"fifo,omitempty"`       // Links deliver messages in the order they were sent
+	Holding              bool                 `json:"holding,omitempty"`    // Messages sent are held back until delivered
+	Held                 int                  `json:"held,omitempty"`       // Messages held back
+	Links                []LinkLatencySpec    `json:"links,omitempty"`      // Links whose latency differs from the rest
+	Losses               []LinkPacketLossSpec `json:"losses,omitempty"`     // Links whose packet loss differs from the rest
+	Bandwidths           []LinkBandwidthSpec  `json:"bandwidths,omitempty"` // Links whose bandwidth differs from the rest
+	FIFOLinks            []LinkFIFOSpec       `json:"fifoLinks,omitempty"`  // Links whose ordering differs from the rest
+	Serialize            bool                 `json:"serialize,omitempty"`  // Messages are encoded to tell their size
+	SerializeCostPerKBMs float64              `json:"serializeCostPerKBMs,omitempty"`
+}
+
+// Layout kinds
+const (
+	LayoutRing     = "ring"      // Nodes on a circle in Order
+	LayoutStar     = "star"      // Center in the middle, the others around it in Order
+	LayoutChain    = "chain"     // Nodes on a line in Order
+	LayoutHashRing = "hash-ring" // Nodes on a circle at their Positions
+	LayoutGroups   = "groups"    // One column per group
+)
+
+// Layout tells the frontend how a project's nodes are best arranged
+type Layout struct {
+	Kind      string             `json:"kind"`
+	Order     []string           `json:"order,omitempty"`
+	Center    string             `json:"center,omitempty"`
+	Positions map[string]float64 `json:"positions,omitempty"` // Fraction of the ring, in [0, 1)
+	Groups    []LayoutGroup      `json:"groups,omitempty"`
+}
+
+// LayoutGroup is a named set of nodes drawn together
+type LayoutGroup struct {
+	Name  string   `json:"name"`
+	Nodes []string `json:"nodes"`
 }
 
 // NodeState represents a node's state
@@ -135,6 +971,7 @@ type NodeState struct {
 	CommitIndex int                    `json:"commitIndex,omitempty"`
 	Clock       map[string]uint64      `json:"clock,omitempty"`
 	CustomState map[string]interface{} `json:"customState,omitempty"`
+	Actions     []string               `json:"actions,omitempty"` // Actions to invoke with MsgInvokeNodeAction
 }
 
 // LogEntry represents a log entry
@@ -161,9 +998,10 @@ type PartitionState struct {
 
 // TimelineEvent represents an event in the timeline
 type TimelineEvent struct {
-	Time int64                  `json:"time"`
-	Type string                 `json:"type"`
-	Data map[string]interface{} `json:"data"`
+	Sequence uint64                 `json:"sequence,omitempty"` // Number it was broadcast with
+	Time     int64                  `json:"time"`
+	Type     string                 `json:"type"`
+	Data     map[string]interface{} `json:"data"`
 }
 
 // NodeStateUpdateResponse updates a single node's state
@@ -184,10 +1022,159 @@ type MessageEventResponse struct {
 	MessageType string            `json:"messageType"`
 	Payload     interface{}       `json:"payload,omitempty"`
 	Clock       map[string]uint64 `json:"clock,omitempty"`
-	Reason      string            `json:"reason,omitempty"` // For dropped messages
+	Reason      string            `json:"reason,omitempty"`  // For dropped messages, and how corrupted ones were
+	Detail      string            `json:"detail,omitempty"`  // What corrupting a message changed
 	Latency     int64             `json:"latency,omitempty"` // For received messages
 }
 
+// PerformanceModeResponse announces a switch between individual and
+// aggregated message reporting
+type PerformanceModeResponse struct {
+	Type      MessageType `json:"type"`
+	Enabled   bool        `json:"enabled"`
+	Rate      int         `json:"rate"`      // Message events in the tick that triggered the switch
+	Threshold int         `json:"threshold"` // Message events per tick
+}
+
+// MessageStatsResponse replaces individual message events while
+// performance mode is enabled, one per tick
+type MessageStatsResponse struct {
+	Type        MessageType `json:"type"`
+	VirtualTime int64       `json:"virtualTime"`
+	Links       []LinkStats `json:"links"`
+	Total       int         `json:"total"`
+}
+
+// LinkStats counts message events on one directed link during a tick
+type LinkStats struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Sent      int    `json:"sent"`
+	Received  int    `json:"received"`
+	Dropped   int    `json:"dropped"`
+	Corrupted int    `json:"corrupted"`
+}
+
+// EngineMetricsResponse tells, about once a second, how the engine keeps
+// up with the run: how long its ticks and the nodes' Tick() took, and how
+// far behind schedule realtime mode has fallen
+type EngineMetricsResponse struct {
+	Type         MessageType    `json:"type"`
+	IntervalMs   int64          `json:"intervalMs"` // Wall time the metrics cover
+	Ticks        int            `json:"ticks"`
+	TickAvgUs    int64          `json:"tickAvgUs"`
+	TickMaxUs    int64          `json:"tickMaxUs"`
+	LagMs        int64          `json:"lagMs"` // How late the last event ran in realtime mode
+	MaxLagMs     int64          `json:"maxLagMs"`
+	Backlog      int            `json:"backlog"` // Events overdue in realtime mode
+	Speed        float64        `json:"speed"`
+	Nodes        int            `json:"nodes"`
+	SlowestNodes []NodeTickTime `json:"slowestNodes"` // Slowest to tick on average
+}
+
+// NodeTickTime is how long a node took to tick
+type NodeTickTime struct {
+	NodeID string `json:"nodeId"`
+	AvgUs  int64  `json:"avgUs"`
+	MaxUs  int64  `json:"maxUs"`
+}
+
+// LatencyMatrixResponse reports the latency observed between every pair
+// of nodes, for a heatmap. Row i, column j is the link from Nodes[i] to
+// Nodes[j].
+type LatencyMatrixResponse struct {
+	Type        MessageType `json:"type"`
+	VirtualTime int64       `json:"virtualTime"`
+	Nodes       []string    `json:"nodes"`
+	AvgMs       [][]float64 `json:"avgMs"`   // Rolling average of delivered messages, 0 without samples
+	Samples     [][]int     `json:"samples"` // Messages delivered on the link since start
+}
+
+// NetworkStatsResponse reports what happened to the messages of every
+// link a message was sent on since the start of the run, for link-health
+// heatmaps, and the bytes each message type took on the wire, to compare
+// protocols by
+type NetworkStatsResponse struct {
+	Type        MessageType         `json:"type"`
+	Project     string              `json:"project"`
+	VirtualTime int64               `json:"virtualTime"`
+	Links       []LinkDeliveryStats `json:"links"`
+	Types       []MessageTypeStats  `json:"types"`
+	BytesSent   int64               `json:"bytesSent,omitempty"` // Of all messages, while serialized
+}
+
+// MessageTypeStats counts the messages of one type sent, and their bytes
+// on the wire while the network serialized them
+type MessageTypeStats struct {
+	Type     string `json:"type"`
+	Messages int    `json:"messages"`
+	Bytes    int64  `json:"bytes,omitempty"`
+}
+
+// LinkDeliveryStats counts the messages sent on the link from one node to
+// another, and how long those delivered took
+type LinkDeliveryStats struct {
+	From           string         `json:"from"`
+	To             string         `json:"to"`
+	Sent           int            `json:"sent"`
+	Delivered      int            `json:"delivered"`
+	Dropped        map[string]int `json:"dropped,omitempty"` // By reason, e.g. "packet_loss"
+	Duplicated     int            `json:"duplicated,omitempty"`
+	MinLatencyMs   float64        `json:"minLatencyMs"`
+	AvgLatencyMs   float64        `json:"avgLatencyMs"`
+	P99LatencyMs   float64        `json:"p99LatencyMs"`        // Of the latest deliveries
+	BytesSent      int64          `json:"bytesSent,omitempty"` // While serialized
+	BytesDelivered int64          `json:"bytesDelivered,omitempty"`
+}
+
+// RunSummaryResponse digests a finished run: what happened, which faults
+// were injected, whether the project's invariants held and what to try
+// next
+type RunSummaryResponse struct {
+	Type       MessageType            `json:"type"`
+	Project    string                 `json:"project"`
+	Scenario   string                 `json:"scenario,omitempty"`
+	Seed       int64                  `json:"seed,omitempty"` // Start with this seed to replay the run
+	DurationMs int64                  `json:"durationMs"`
+	Narrative  []string               `json:"narrative"`
+	KeyEvents  []TimelineEvent        `json:"keyEvents"` // Events of the types that occurred only a few times
+	Faults     []TimelineEvent        `json:"faults"`
+	Invariants []InvariantResult      `json:"invariants"`
+	Metrics    map[string]interface{} `json:"metrics"`
+	FollowUps  []FollowUp             `json:"followUps"`
+	EndedBy    string                 `json:"endedBy,omitempty"` // "timeout" or "abandoned" when the run did not stop by hand
+}
+
+// SimulationCompletedResponse announces that a run is over, as its project
+// tells, e.g. because consensus was reached; the run is left paused
+type SimulationCompletedResponse struct {
+	Type              MessageType `json:"type"`
+	Project           string      `json:"project"`
+	Scenario          string      `json:"scenario,omitempty"`
+	Seed              int64       `json:"seed,omitempty"`
+	Outcome           string      `json:"outcome"`
+	ElapsedMs         int64       `json:"elapsedMs"` // Virtual time the run took
+	Rounds            int         `json:"rounds"`    // Ticks of the nodes
+	MessagesSent      int         `json:"messagesSent"`
+	MessagesDelivered int         `json:"messagesDelivered"`
+	MessagesDropped   int         `json:"messagesDropped"`
+}
+
+// InvariantResult tells whether a property the project promises held
+// throughout the run
+type InvariantResult struct {
+	Name   string `json:"name"`
+	Holds  bool   `json:"holds"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// FollowUp suggests a scenario worth running next
+type FollowUp struct {
+	Project  string `json:"project"`
+	Scenario string `json:"scenario,omitempty"`
+	Reason   string `json:"reason"`
+}
+
 // ErrorResponse represents an error
 type ErrorResponse struct {
 	Type    MessageType `json:"type"`
@@ -231,6 +1218,209 @@ func ParseInjectCrash(data []byte) (*InjectCrashRequest, error) {
 	return &msg, nil
 }
 
+// ValidateControls checks a control schedule
+func ValidateControls(controls []ControlSpec) error {
+	for i, c := range controls {
+		switch c.Action {
+		case "speed":
+			if c.Speed < 0.1 || c.Speed > 10 {
+				return fmt.Errorf("control %d: speed must be between 0.1 and 10", i)
+			}
+		case "pause":
+		default:
+			return fmt.Errorf("control %d: unknown action %q", i, c.Action)
+		}
+		if c.AtMs < 0 {
+			return fmt.Errorf("control %d: time must not be negative", i)
+		}
+	}
+	return nil
+}
+
+// ValidateBreakpoints checks breakpoints before they are armed
+func ValidateBreakpoints(breakpoints []BreakpointSpec) error {
+	for i, b := range breakpoints {
+		switch b.Condition {
+		case "event":
+			if b.EventType == "" {
+				return fmt.Errorf("breakpoint %d: an event breakpoint needs an event type", i)
+			}
+		case "role":
+			if b.Role == "" {
+				return fmt.Errorf("breakpoint %d: a role breakpoint needs a role", i)
+			}
+		case "messages":
+			if b.Count <= 0 {
+				return fmt.Errorf("breakpoint %d: a message breakpoint needs a positive count", i)
+			}
+		default:
+			return fmt.Errorf("breakpoint %d: unknown condition %q", i, b.Condition)
+		}
+	}
+	return nil
+}
+
+// ValidateInvariants checks invariants defined by the user
+func ValidateInvariants(invariants []InvariantSpec) error {
+	for i, inv := range invariants {
+		switch inv.Kind {
+		case "unique_role":
+			if inv.Role == "" {
+				return fmt.Errorf("invariant %d: a unique_role invariant needs a role", i)
+			}
+		case "agreement":
+			if inv.Field == "" {
+				return fmt.Errorf("invariant %d: an agreement invariant needs a field", i)
+			}
+		case "expect":
+			if inv.Field == "" || inv.Equals == "" {
+				return fmt.Errorf("invariant %d: an expect invariant needs a field and a value", i)
+			}
+		default:
+			return fmt.Errorf("invariant %d: unknown kind %q", i, inv.Kind)
+		}
+	}
+	return nil
+}
+
+// ValidateWorkload checks a client workload before it is scheduled
+func ValidateWorkload(workload []WorkloadSpec) error {
+	for i, w := range workload {
+		if w.Command == "" {
+			return fmt.Errorf("workload %d: a command is required", i)
+		}
+		if w.AtMs < 0 || w.EveryMs < 0 || w.Count < 0 {
+			return fmt.Errorf("workload %d: times and counts must not be negative", i)
+		}
+	}
+	return nil
+}
+
+// ValidateNetwork checks a network preset before it is applied
+func ValidateNetwork(preset NetworkPreset) error {
+	if err := validateLatency(preset.MinLatencyMs, preset.MaxLatencyMs, preset.Distribution); err != nil {
+		return err
+	}
+	if preset.PacketLoss < 0 || preset.PacketLoss > 1 {
+		return fmt.Errorf("packet loss must be between 0 and 1")
+	}
+	if preset.BandwidthBytesPerSec < 0 || preset.QueueCapacity < 0 {
+		return fmt.Errorf("the bandwidth and queue capacity must not be negative")
+	}
+	if preset.SerializeCostPerKBMs < 0 {
+		return fmt.Errorf("the serialization cost must not be negative")
+	}
+	if err := ValidateLinkLatencies(preset.Links); err != nil {
+		return err
+	}
+	if err := ValidateLinkPacketLosses(preset.Losses); err != nil {
+		return err
+	}
+	if err := ValidateLinkBandwidths(preset.Bandwidths); err != nil {
+		return err
+	}
+	return ValidateLinkFIFOs(preset.FIFOLinks)
+}
+
+// ValidatePartitionGroups checks the sides of a split cluster: two or
+// more, none empty, no node on two of them
+func ValidatePartitionGroups(groups []PartitionGroup) error {
+	if len(groups) < 2 {
+		return fmt.Errorf("at least two groups are required")
+	}
+	seen := make(map[string]bool)
+	for i, g := range groups {
+		if len(g.Nodes) == 0 {
+			return fmt.Errorf("group %d has no nodes", i)
+		}
+		for _, node := range g.Nodes {
+			if seen[node] {
+				return fmt.Errorf("node %s is in more than one group", node)
+			}
+			seen[node] = true
+		}
+	}
+	return nil
+}
+
+// ValidateLinkFIFOs checks link orderings before they are set
+func ValidateLinkFIFOs(links []LinkFIFOSpec) error {
+	for i, l := range links {
+		if l.From == "" || l.To == "" || l.From == l.To {
+			return fmt.Errorf("fifo link %d: two different nodes are required", i)
+		}
+	}
+	return nil
+}
+
+// ValidateLinkPacketLosses checks link packet losses before they are set
+func ValidateLinkPacketLosses(links []LinkPacketLossSpec) error {
+	for i, l := range links {
+		if l.From == "" || l.To == "" || l.From == l.To {
+			return fmt.Errorf("loss %d: two different nodes are required", i)
+		}
+		if l.PacketLoss < 0 || l.PacketLoss > 1 {
+			return fmt.Errorf("loss %d: packet loss must be between 0 and 1", i)
+		}
+	}
+	return nil
+}
+
+// ValidateLinkBandwidths checks link bandwidths before they are set
+func ValidateLinkBandwidths(links []LinkBandwidthSpec) error {
+	for i, l := range links {
+		if l.From == "" || l.To == "" || l.From == l.To {
+			return fmt.Errorf("bandwidth %d: two different nodes are required", i)
+		}
+		if l.BytesPerSec < 0 || l.QueueCapacity < 0 {
+			return fmt.Errorf("bandwidth %d: the bandwidth and queue capacity must not be negative", i)
+		}
+	}
+	return nil
+}
+
+// ValidateLinkLatencies checks link latencies before they are set
+func ValidateLinkLatencies(links []LinkLatencySpec) error {
+	for i, l := range links {
+		if l.From == "" || l.To == "" || l.From == l.To {
+			return fmt.Errorf("link %d: two different nodes are required", i)
+		}
+		if err := validateLatency(l.MinLatencyMs, l.MaxLatencyMs, l.Distribution); err != nil {
+			return fmt.Errorf("link %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// validateLatency checks a latency range and the distribution drawn from
+// it
+func validateLatency(minMs, maxMs int64, d *LatencyDistributionSpec) error {
+	uniform := d == nil || d.Kind == "" || d.Kind == "uniform"
+	if minMs < 0 || (maxMs < minMs && (uniform || maxMs != 0)) {
+		return fmt.Errorf("invalid latency range: %d-%d ms", minMs, maxMs)
+	}
+	if uniform {
+		return nil
+	}
+	switch d.Kind {
+	case "normal":
+		if d.MeanMs < 0 || d.StdDevMs < 0 {
+			return fmt.Errorf("a normal latency needs a mean and standard deviation of at least 0")
+		}
+	case "lognormal":
+		if d.MedianMs <= 0 || d.Sigma < 0 {
+			return fmt.Errorf("a lognormal latency needs a positive median and a sigma of at least 0")
+		}
+	case "pareto":
+		if minMs <= 0 || d.Shape <= 0 {
+			return fmt.Errorf("a pareto latency needs a positive minimum latency and shape")
+		}
+	default:
+		return fmt.Errorf("unknown latency distribution %q", d.Kind)
+	}
+	return nil
+}
+
 // NewSimulationState creates a new simulation state response
 func NewSimulationState(virtualTime int64, mode string, speed float64, running bool, nodes map[string]NodeState) *SimulationStateResponse {
 	return &SimulationStateResponse{
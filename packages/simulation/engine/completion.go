@@ -0,0 +1,64 @@
+package engine
+
+import "time"
+
+// completion is a run to completion: how far it may go, and who to tell
+// when it ends
+type completion struct {
+	until    time.Duration // Virtual time it stops at
+	finished func(reason string)
+	reason   string // Why it ended, when it was not interrupted by hand
+}
+
+// RunToCompletion runs ticks back to back, without waiting for the wall
+// clock, until limit of virtual time has passed or the mode changes, e.g.
+// because the run was paused. The run is then paused and finished called
+// with "limit" or "interrupted". It returns at once.
+func (e *Engine) RunToCompletion(limit time.Duration, finished func(reason string)) {
+	e.mu.Lock()
+	e.completion = &completion{until: e.Elapsed() + limit, finished: finished}
+	e.mode = ModeCompletion
+	e.mu.Unlock()
+	e.wakeUp()
+}
+
+// runCompletion runs the events up to the next tick, or to the limit of
+// the run to completion
+func (e *Engine) runCompletion() {
+	e.mu.RLock()
+	c := e.completion
+	until := e.nextTick
+	e.mu.RUnlock()
+	if c == nil {
+		e.SetMode(ModePaused)
+		return
+	}
+
+	if until > c.until {
+		until = c.until
+	}
+	e.advance(until)
+	if e.Elapsed() >= c.until {
+		e.Pause()
+		e.endCompletion("limit")
+	}
+}
+
+// endCompletion tells the run to completion under way, if any, that it is
+// over, for the reason it recorded if any
+func (e *Engine) endCompletion(reason string) {
+	e.mu.Lock()
+	c := e.completion
+	e.completion = nil
+	if c != nil {
+		e.pace()
+		if c.reason != "" {
+			reason = c.reason
+		}
+	}
+	e.mu.Unlock()
+
+	if c != nil {
+		c.finished(reason)
+	}
+}
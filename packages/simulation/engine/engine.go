@@ -3,7 +3,10 @@ package engine
 import (
 	"context"
 	"encoding/json"
+	"math/rand"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -14,6 +17,7 @@ const (
 	ModeRealtime SimulationMode = iota
 	ModeStepByStep
 	ModePaused
+	ModeCompletion // Ticks back to back until a condition, see RunToCompletion
 )
 
 func (m SimulationMode) String() string {
@@ -24,6 +28,8 @@ func (m SimulationMode) String() string {
 		return "step"
 	case ModePaused:
 		return "paused"
+	case ModeCompletion:
+		return "completion"
 	default:
 		return "unknown"
 	}
@@ -45,11 +51,35 @@ type EventEmitter interface {
 
 // Config holds simulation configuration
 type Config struct {
-	Speed       float64 // Speed multiplier (1.0 = realtime)
-	TickRate    time.Duration
-	StepMode    bool
-	ProjectName string
-	Scenario    string
+	Speed            float64 // Speed multiplier (1.0 = realtime)
+	TickRate         time.Duration
+	StepMode         bool
+	ProjectName      string
+	Scenario         string
+	Seed             int64         // Seed of the run's random numbers (0 = pick one)
+	Paused           bool          // Start paused, e.g. to bring the run to a checkpoint first
+	FuzzWindow       time.Duration // Fuzz the schedule: delay deliveries by up to this and shuffle the ticks of nodes
+	PauseOnViolation bool          // Pause the run when a registered invariant is violated
+
+	// Nodes that tick at their own rate instead of at every TickRate, see
+	// SetNodeTickRate
+	NodeTickRates map[string]time.Duration
+
+	// Wall time between "engine_metrics" events on how fast the engine
+	// keeps up (0 = never)
+	MetricsInterval time.Duration
+
+	// Tick the nodes on this many goroutines at once, for runs of hundreds
+	// of nodes (0 or 1 = one after the other). The nodes must then be safe
+	// to tick concurrently, and the order they draw random numbers and
+	// send messages in varies, so the run no longer replays from its seed.
+	ParallelTicks int
+
+	// Stop the run after this many ticks, or this much virtual time, so
+	// that one nobody watches does not run forever (0 = no limit). See
+	// checkLimits.
+	MaxTicks           int
+	MaxVirtualDuration time.Duration
 }
 
 // DefaultConfig returns default configuration
@@ -69,11 +99,47 @@ type Engine struct {
 	emitter EventEmitter
 	config  Config
 
-	mode        SimulationMode
-	stepCh      chan struct{}
-	speed       float64
-	virtualTime time.Time
-	startTime   time.Time
+	mode      SimulationMode
+	steps     []chan struct{} // Steps asked for, each closed once taken
+	wake      chan struct{}   // Interrupts the loop's wait when the mode, speed or queue changes
+	timer     *time.Timer     // Ends the loop's wait for the next event in realtime mode
+	speed     float64
+	startTime time.Time
+	elapsed   atomic.Int64 // Virtual time since startTime, in nanoseconds
+
+	// Events waiting for their virtual time, run one at a time
+	queue      eventQueue
+	seq        uint64
+	nextTick   time.Duration
+	ticks      int // Ticks run so far
+	processing sync.Mutex
+
+	// In realtime mode, virtual time paceVirtual was reached at wall time
+	// paceWall, and advances from there at speed
+	paceWall    time.Time
+	paceVirtual time.Duration
+
+	rng    *rand.Rand // Source of every random choice in the run
+	source *lockedSource
+	fuzz   *rand.Rand // Source of the schedule's random choices, when fuzzing
+
+	// The time the last delivery on each ordered link is due, which
+	// fuzzing moves none of the next ones ahead of
+	inOrder map[string]time.Duration
+
+	// Events run so far, and the trace of them when recording or
+	// replaying
+	executed atomic.Uint64
+	trace    *trace
+
+	completion *completion // The run to completion under way, if any
+
+	nodeClocks map[string]*nodeClock // Nodes not ticking in lockstep
+	metrics    *metrics              // How fast the run goes, when measured
+
+	invariants  []*invariant                       // Checked after every tick
+	termination func() (outcome string, over bool) // Tells after every tick whether the run is over
+	hooks       []TickHook                         // Wrap every tick
 
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -83,14 +149,114 @@ type Engine struct {
 
 // NewEngine creates a new simulation engine
 func NewEngine(emitter EventEmitter, config Config) *Engine {
-	return &Engine{
-		nodes:   make(map[string]NodeController),
-		emitter: emitter,
-		config:  config,
-		stepCh:  make(chan struct{}, 100),
-		speed:   config.Speed,
-		mode:    ModePaused,
+	if config.Seed == 0 {
+		config.Seed = NewSeed()
+	}
+	source := newLockedSource(config.Seed)
+	var fuzz *rand.Rand
+	if config.FuzzWindow > 0 {
+		fuzz = NewRand(config.Seed ^ fuzzSalt)
+	}
+	e := &Engine{
+		nodes:     make(map[string]NodeController),
+		emitter:   emitter,
+		config:    config,
+		wake:      make(chan struct{}, 1),
+		timer:     time.NewTimer(0),
+		speed:     config.Speed,
+		mode:      ModePaused,
+		startTime: time.Now(),
+		rng:       rand.New(source),
+		source:    source,
+		fuzz:      fuzz,
+		trace:     &trace{},
+	}
+	e.timer.Stop()
+	for nodeID, rate := range config.NodeTickRates {
+		e.SetNodeTickRate(nodeID, rate)
+	}
+	if config.MetricsInterval > 0 {
+		e.metrics = newMetrics()
 	}
+	return e
+}
+
+// lockedSource is a rand.Source safe for concurrent use, like the one
+// behind the math/rand functions
+type lockedSource struct {
+	mu  sync.Mutex
+	src rand.Source64
+
+	// Every number drawn, counted and folded into a digest for traces
+	draws  uint64
+	digest uint64
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v := s.src.Int63()
+	s.tally(uint64(v))
+	return v
+}
+
+func (s *lockedSource) Uint64() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v := s.src.Uint64()
+	s.tally(v)
+	return v
+}
+
+// tally counts a number drawn (must hold s.mu)
+func (s *lockedSource) tally(v uint64) {
+	const prime = 1099511628211 // FNV-1a
+	s.draws++
+	s.digest = (s.digest ^ v) * prime
+}
+
+// drawn returns how many numbers were drawn and their digest
+func (s *lockedSource) drawn() (uint64, uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.draws, s.digest
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.src.Seed(seed)
+}
+
+// maxSeed bounds the seeds NewSeed picks: the largest integer a JavaScript
+// number holds exactly
+const maxSeed = 1<<53 - 1
+
+// NewSeed picks a seed for a run from the clock, small enough for the
+// browser to show as is and send back to start the same run again
+func NewSeed() int64 {
+	return time.Now().UnixNano()%maxSeed + 1
+}
+
+// NewRand returns a generator seeded with seed that nodes, the transport
+// and the simulation can share across goroutines
+func NewRand(seed int64) *rand.Rand {
+	return rand.New(newLockedSource(seed))
+}
+
+func newLockedSource(seed int64) *lockedSource {
+	return &lockedSource{src: rand.NewSource(seed).(rand.Source64)}
+}
+
+// Rand returns the generator every random choice of the run should come
+// from, so that the same seed replays the same choices
+func (e *Engine) Rand() *rand.Rand {
+	return e.rng
+}
+
+// Seed returns the seed of the run
+func (e *Engine) Seed() int64 {
+	return e.config.Seed
 }
 
 // AddNode registers a node with the simulation
@@ -118,16 +284,19 @@ func (e *Engine) GetNode(nodeID string) NodeController {
 func (e *Engine) Start(ctx context.Context) error {
 	e.mu.Lock()
 	e.ctx, e.cancel = context.WithCancel(ctx)
-	e.startTime = time.Now()
-	e.virtualTime = e.startTime
 	e.running = true
 
-	if e.config.StepMode {
+	switch {
+	case e.config.Paused:
+		e.mode = ModePaused
+	case e.config.StepMode:
 		e.mode = ModeStepByStep
-	} else {
+	default:
 		e.mode = ModeRealtime
 	}
+	e.pace()
 	e.mu.Unlock()
+	e.scheduleTick()
 
 	// Start all nodes
 	for _, node := range e.nodes {
@@ -157,7 +326,10 @@ func (e *Engine) Stop() error {
 	if e.cancel != nil {
 		e.cancel()
 	}
+	// Steps no longer taken are over
+	e.releaseSteps()
 	e.mu.Unlock()
+	e.wakeUp()
 
 	// Stop all nodes
 	for _, node := range e.nodes {
@@ -171,76 +343,205 @@ func (e *Engine) Stop() error {
 	return nil
 }
 
-// run is the main simulation loop
+// run is the main simulation loop: it runs the events in the order of
+// their virtual time, pacing them to the wall clock in realtime mode
 func (e *Engine) run() {
-	tickDuration := e.config.TickRate
-
 	for {
-		e.mu.RLock()
+		e.mu.Lock()
 		running := e.running
 		mode := e.mode
-		speed := e.speed
-		e.mu.RUnlock()
+		completing := e.completion != nil
+		if mode != ModeStepByStep {
+			// Resumed or paused meanwhile: the steps asked for are over
+			e.releaseSteps()
+		}
+		e.mu.Unlock()
 
-		if !running {
+		if !running || e.ctx.Err() != nil {
 			return
 		}
+		if completing && mode != ModeCompletion {
+			e.endCompletion("interrupted")
+		}
 
 		switch mode {
 		case ModeRealtime:
-			e.tick()
-			adjustedDuration := time.Duration(float64(tickDuration) / speed)
-			time.Sleep(adjustedDuration)
+			e.runNext()
 
 		case ModeStepByStep:
+			e.mu.Lock()
+			var done chan struct{}
+			if len(e.steps) > 0 {
+				done = e.steps[0]
+				e.steps = e.steps[1:]
+			}
+			until := e.nextTick
+			e.mu.Unlock()
+			if done != nil {
+				e.advance(until)
+				close(done)
+				continue
+			}
 			select {
-			case <-e.stepCh:
-				e.tick()
+			case <-e.wake:
 			case <-e.ctx.Done():
 				return
 			}
 
 		case ModePaused:
-			time.Sleep(50 * time.Millisecond)
+			select {
+			case <-e.wake:
+			case <-e.ctx.Done():
+				return
+			}
+
+		case ModeCompletion:
+			e.runCompletion()
 		}
 	}
 }
 
-// tick performs one simulation step
-func (e *Engine) tick() {
+// runNext waits until the wall clock catches up with the next event, then
+// runs it along with every other event due at the same virtual time
+func (e *Engine) runNext() {
+	at, ok := e.next()
+	e.mu.RLock()
+	due := e.paceWall.Add(time.Duration(float64(at-e.paceVirtual) / e.speed))
+	e.mu.RUnlock()
+
+	if !ok {
+		select {
+		case <-e.wake:
+		case <-e.ctx.Done():
+		}
+		return
+	}
+	if wait := time.Until(due); wait > 0 {
+		// The loop alone uses the timer, and a stopped or reset one
+		// delivers no stale time
+		e.timer.Reset(wait)
+		select {
+		case <-e.timer.C:
+		case <-e.wake:
+			e.timer.Stop()
+			return // Something changed: look again
+		case <-e.ctx.Done():
+			e.timer.Stop()
+			return
+		}
+	}
+	if e.metrics != nil {
+		e.metrics.noteLag(time.Since(due))
+	}
+	e.advance(at)
+}
+
+// pace anchors realtime mode to the current wall and virtual time (must
+// hold e.mu)
+func (e *Engine) pace() {
+	e.paceWall = time.Now()
+	e.paceVirtual = e.Elapsed()
+}
+
+// scheduleTick schedules the next tick of the nodes
+func (e *Engine) scheduleTick() {
 	e.mu.Lock()
-	e.virtualTime = e.virtualTime.Add(e.config.TickRate)
+	e.nextTick = e.Elapsed() + e.config.TickRate
 	e.mu.Unlock()
+	e.AfterLabeled(e.config.TickRate, "tick", e.tick)
+}
+
+// tick performs one simulation step
+func (e *Engine) tick() {
+	began := time.Now()
 
-	// Process each node
+	// Process each node, in the same order every tick so that runs with
+	// the same seed draw the same numbers for the same nodes
 	e.mu.RLock()
 	nodes := make([]NodeController, 0, len(e.nodes))
 	for _, node := range e.nodes {
 		nodes = append(nodes, node)
 	}
 	e.mu.RUnlock()
-
-	for _, node := range nodes {
-		node.Tick()
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID() < nodes[j].ID() })
+	if e.fuzz != nil {
+		e.fuzz.Shuffle(len(nodes), func(i, j int) { nodes[i], nodes[j] = nodes[j], nodes[i] })
 	}
 
+	e.beforeTick(nodes)
+	e.tickNodes(nodes)
+	e.afterTick(nodes)
+	e.checkInvariants()
+	e.checkTermination()
+
 	if e.emitter != nil {
 		e.emitter.Emit("simulation_tick", map[string]interface{}{
-			"virtualTime": e.virtualTime.UnixMilli(),
+			"virtualTime": e.GetVirtualTime().UnixMilli(),
+			"elapsedMs":   e.Elapsed().Milliseconds(),
 		})
 	}
+	e.reportMetrics(began)
+	if e.checkLimits() {
+		return
+	}
+	e.scheduleTick()
 }
 
-// Step advances simulation by one step (for step-by-step mode)
-func (e *Engine) Step() {
-	e.stepCh <- struct{}{}
+// Step advances simulation by one step (for step-by-step mode): every
+// event up to and including the next tick. The channel returned is closed
+// once they have run, or the simulation stopped or left step-by-step
+// mode, at once if it is not stepping.
+func (e *Engine) Step() <-chan struct{} {
+	done := make(chan struct{})
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.running || e.mode != ModeStepByStep {
+		close(done)
+		return done
+	}
+	e.steps = append(e.steps, done)
+	e.wakeUp()
+	return done
 }
 
-// StepN advances simulation by n steps
-func (e *Engine) StepN(n int) {
+// releaseSteps closes the steps asked for and not yet taken (must hold
+// e.mu)
+func (e *Engine) releaseSteps() {
+	for _, done := range e.steps {
+		close(done)
+	}
+	e.steps = nil
+}
+
+// StepN advances simulation by n steps, and returns the channel of the
+// last
+func (e *Engine) StepN(n int) <-chan struct{} {
+	taken := make(chan struct{})
+	close(taken)
+	var done <-chan struct{} = taken
 	for i := 0; i < n; i++ {
-		e.Step()
+		done = e.Step()
 	}
+	return done
+}
+
+// Inspect runs fn between two events, so that what it reads of the
+// nodes and the network is from a single point of virtual time
+func (e *Engine) Inspect(fn func()) {
+	e.processing.Lock()
+	defer e.processing.Unlock()
+	fn()
+}
+
+// FastForward runs the next d of virtual time at once, without waiting
+// for the wall clock, in any mode
+func (e *Engine) FastForward(d time.Duration) {
+	e.advance(e.Elapsed() + d)
+
+	e.mu.Lock()
+	e.pace()
+	e.mu.Unlock()
+	e.wakeUp()
 }
 
 // Pause pauses the simulation
@@ -248,6 +549,7 @@ func (e *Engine) Pause() {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 	e.mode = ModePaused
+	e.wakeUp()
 	if e.emitter != nil {
 		e.emitter.Emit("simulation_paused", map[string]interface{}{})
 	}
@@ -262,6 +564,8 @@ func (e *Engine) Resume() {
 	} else {
 		e.mode = ModeRealtime
 	}
+	e.pace()
+	e.wakeUp()
 	if e.emitter != nil {
 		e.emitter.Emit("simulation_resumed", map[string]interface{}{
 			"mode": e.mode.String(),
@@ -280,6 +584,8 @@ func (e *Engine) SetSpeed(speed float64) {
 		speed = 10.0
 	}
 	e.speed = speed
+	e.pace()
+	e.wakeUp()
 }
 
 // SetMode sets the simulation mode
@@ -287,6 +593,8 @@ func (e *Engine) SetMode(mode SimulationMode) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 	e.mode = mode
+	e.pace()
+	e.wakeUp()
 }
 
 // GetState returns current simulation state for visualization
@@ -302,9 +610,10 @@ func (e *Engine) GetState() SimulationState {
 	return SimulationState{
 		Mode:        e.mode.String(),
 		Speed:       e.speed,
-		VirtualTime: e.virtualTime.UnixMilli(),
+		VirtualTime: e.GetVirtualTime().UnixMilli(),
 		Running:     e.running,
 		Nodes:       nodeStates,
+		Seed:        e.config.Seed,
 	}
 }
 
@@ -315,6 +624,7 @@ type SimulationState struct {
 	VirtualTime int64                  `json:"virtualTime"`
 	Running     bool                   `json:"running"`
 	Nodes       map[string]interface{} `json:"nodes"`
+	Seed        int64                  `json:"seed"`
 }
 
 // ToJSON serializes the state to JSON
@@ -324,9 +634,17 @@ func (s *SimulationState) ToJSON() ([]byte, error) {
 
 // GetVirtualTime returns the current virtual time
 func (e *Engine) GetVirtualTime() time.Time {
-	e.mu.RLock()
-	defer e.mu.RUnlock()
-	return e.virtualTime
+	return e.startTime.Add(e.Elapsed())
+}
+
+// Elapsed returns the virtual time since the start of the run
+func (e *Engine) Elapsed() time.Duration {
+	return time.Duration(e.elapsed.Load())
+}
+
+// setElapsed moves the virtual clock to the time of the event being run
+func (e *Engine) setElapsed(elapsed time.Duration) {
+	e.elapsed.Store(int64(elapsed))
 }
 
 // IsRunning returns true if the simulation is running
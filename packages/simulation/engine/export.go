@@ -0,0 +1,153 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// snapshotVersion is the format of the snapshots the engine exports and
+// imports
+const snapshotVersion = 1
+
+// Snapshot is the state of a run at one point, as JSON: the engine's mode
+// and clock, the state of every node and the events waiting to run, the
+// message deliveries among them
+type Snapshot struct {
+	Version   int                               `json:"version"`
+	Project   string                            `json:"project"`
+	Scenario  string                            `json:"scenario,omitempty"`
+	Seed      int64                             `json:"seed"`
+	Mode      string                            `json:"mode"`
+	Speed     float64                           `json:"speed"`
+	ElapsedNs int64                             `json:"elapsedNs"` // Virtual time since the start of the run
+	Executed  uint64                            `json:"executed"`  // Events run so far
+	Nodes     map[string]map[string]interface{} `json:"nodes"`
+	Pending   []PendingEvent                    `json:"pending"`
+}
+
+// Export returns the snapshot of the run as it is now, between two events
+func (e *Engine) Export() ([]byte, error) {
+	var snapshot Snapshot
+	e.Inspect(func() {
+		e.mu.RLock()
+		snapshot = Snapshot{
+			Version:   snapshotVersion,
+			Project:   e.config.ProjectName,
+			Scenario:  e.config.Scenario,
+			Seed:      e.config.Seed,
+			Mode:      e.mode.String(),
+			Speed:     e.speed,
+			ElapsedNs: int64(e.Elapsed()),
+			Executed:  e.Executed(),
+			Nodes:     make(map[string]map[string]interface{}, len(e.nodes)),
+		}
+		nodes := make([]NodeController, 0, len(e.nodes))
+		for _, node := range e.nodes {
+			nodes = append(nodes, node)
+		}
+		e.mu.RUnlock()
+
+		// Without the lock: nodes may call back
+		for _, node := range nodes {
+			snapshot.Nodes[node.ID()] = node.GetState()
+		}
+		snapshot.Pending = e.Pending()
+	})
+	return json.Marshal(snapshot)
+}
+
+// Import brings the mode and speed of a snapshot back onto the engine,
+// which must be at the same point of the same run: nodes cannot be set to
+// a state, so a run is brought there by replaying it from its seed first.
+// It returns the nodes whose state differs from the snapshot's, sorted.
+// A run exported while running to completion is left paused.
+func (e *Engine) Import(data []byte) ([]string, error) {
+	var snapshot Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, err
+	}
+	if snapshot.Version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", snapshot.Version)
+	}
+	mode, ok := parseMode(snapshot.Mode)
+	if !ok {
+		return nil, fmt.Errorf("unknown mode %q", snapshot.Mode)
+	}
+	if mode == ModeCompletion {
+		mode = ModePaused
+	}
+
+	var differ []string
+	var err error
+	e.Inspect(func() {
+		e.mu.RLock()
+		project, seed := e.config.ProjectName, e.config.Seed
+		nodes := make(map[string]NodeController, len(e.nodes))
+		for id, node := range e.nodes {
+			nodes[id] = node
+		}
+		e.mu.RUnlock()
+
+		switch {
+		case snapshot.Project != project || snapshot.Seed != seed:
+			err = fmt.Errorf("the snapshot is of %s with seed %d, not %s with seed %d", snapshot.Project, snapshot.Seed, project, seed)
+			return
+		case time.Duration(snapshot.ElapsedNs) != e.Elapsed():
+			err = fmt.Errorf("the snapshot is at %s, the run at %s", time.Duration(snapshot.ElapsedNs), e.Elapsed())
+			return
+		}
+
+		for id, node := range nodes {
+			saved, ok := snapshot.Nodes[id]
+			if !ok || !sameJSON(saved, node.GetState()) {
+				differ = append(differ, id)
+			}
+		}
+		for id := range snapshot.Nodes {
+			if _, ok := nodes[id]; !ok {
+				differ = append(differ, id)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(differ)
+
+	e.SetSpeed(snapshot.Speed)
+	e.SetMode(mode)
+	return differ, nil
+}
+
+// parseMode returns the mode of its String()
+func parseMode(s string) (SimulationMode, bool) {
+	for _, mode := range []SimulationMode{ModeRealtime, ModeStepByStep, ModePaused, ModeCompletion} {
+		if mode.String() == s {
+			return mode, true
+		}
+	}
+	return 0, false
+}
+
+// sameJSON tells whether a and b encode to the same JSON, once decoded
+// alike: structs become maps, with their keys sorted
+func sameJSON(a, b interface{}) bool {
+	ja, errA := canonicalJSON(a)
+	jb, errB := canonicalJSON(b)
+	return errA == nil && errB == nil && ja == jb
+}
+
+func canonicalJSON(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return "", err
+	}
+	data, err = json.Marshal(decoded)
+	return string(data), err
+}
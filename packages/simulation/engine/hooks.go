@@ -0,0 +1,123 @@
+package engine
+
+import (
+	"sort"
+	"time"
+)
+
+// TickHook wraps every tick of the run, for what is not any one project's
+// concern: measuring, checking, tracing or annotating the run. Hooks run
+// on the engine's loop, in the order they were added before the tick and
+// in the reverse order after it, so they must not block.
+type TickHook interface {
+	BeforeTick(view TickView)
+	AfterTick(view TickView)
+}
+
+// TickHookFuncs is a TickHook of its functions, either of which may be nil
+type TickHookFuncs struct {
+	Before func(view TickView)
+	After  func(view TickView)
+}
+
+// BeforeTick calls Before
+func (h TickHookFuncs) BeforeTick(view TickView) {
+	if h.Before != nil {
+		h.Before(view)
+	}
+}
+
+// AfterTick calls After
+func (h TickHookFuncs) AfterTick(view TickView) {
+	if h.After != nil {
+		h.After(view)
+	}
+}
+
+// TickView is the run as a tick hook sees it: the virtual time of the
+// tick and the nodes' states, which hooks read but cannot change
+type TickView struct {
+	Elapsed     time.Duration // Virtual time since the start of the run
+	VirtualTime time.Time
+
+	nodes  []NodeController // Sorted by ID
+	states map[string]map[string]interface{}
+}
+
+// NodeIDs returns the IDs of the nodes, sorted
+func (v TickView) NodeIDs() []string {
+	ids := make([]string, len(v.nodes))
+	for i, node := range v.nodes {
+		ids[i] = node.ID()
+	}
+	return ids
+}
+
+// NodeState returns a copy of a node's state, nil for a node not in the
+// run. The state is read once per view, so hooks see the same one.
+func (v TickView) NodeState(nodeID string) map[string]interface{} {
+	state, ok := v.states[nodeID]
+	if !ok {
+		i := sort.Search(len(v.nodes), func(i int) bool { return v.nodes[i].ID() >= nodeID })
+		if i == len(v.nodes) || v.nodes[i].ID() != nodeID {
+			return nil
+		}
+		state = v.nodes[i].GetState()
+		v.states[nodeID] = state
+	}
+	copied := make(map[string]interface{}, len(state))
+	for k, val := range state {
+		copied[k] = val
+	}
+	return copied
+}
+
+// Use wraps every tick from the next one on with hook
+func (e *Engine) Use(hook TickHook) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.hooks = append(e.hooks, hook)
+}
+
+// tickView returns the view of a tick of the nodes
+func (e *Engine) tickView(nodes []NodeController) TickView {
+	sorted := append([]NodeController(nil), nodes...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID() < sorted[j].ID() })
+	return TickView{
+		Elapsed:     e.Elapsed(),
+		VirtualTime: e.GetVirtualTime(),
+		nodes:       sorted,
+		states:      make(map[string]map[string]interface{}, len(sorted)),
+	}
+}
+
+// beforeTick calls the hooks before a tick of the nodes (must hold
+// e.processing)
+func (e *Engine) beforeTick(nodes []NodeController) {
+	e.mu.RLock()
+	hooks := e.hooks
+	e.mu.RUnlock()
+	if len(hooks) == 0 {
+		return
+	}
+	// Without the lock: hooks read the nodes, which may call back
+	view := e.tickView(nodes)
+	for _, hook := range hooks {
+		hook.BeforeTick(view)
+	}
+}
+
+// afterTick calls the hooks after a tick of the nodes, in reverse (must
+// hold e.processing)
+func (e *Engine) afterTick(nodes []NodeController) {
+	e.mu.RLock()
+	hooks := e.hooks
+	e.mu.RUnlock()
+	if len(hooks) == 0 {
+		return
+	}
+	view := e.tickView(nodes)
+	for i := len(hooks) - 1; i >= 0; i-- {
+		hooks[i].AfterTick(view)
+	}
+}
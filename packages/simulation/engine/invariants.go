@@ -0,0 +1,85 @@
+package engine
+
+// invariant is a property of the run checked after every tick
+type invariant struct {
+	name     string
+	check    func() error
+	violated bool // At the last check
+	result   InvariantResult
+}
+
+// InvariantResult is what became of an invariant registered with the
+// engine
+type InvariantResult struct {
+	Name       string
+	Violations int    // Checks it went from holding to violated at
+	Detail     string // The first violation
+	AtNs       int64  // Virtual time of the first violation
+}
+
+// RegisterInvariant checks fn after every tick. When it returns an error
+// where it did not at the check before, an "invariant_violated" event is
+// emitted and, with PauseOnViolation, the run is paused. fn runs on the
+// engine's loop, so it must not block.
+func (e *Engine) RegisterInvariant(name string, fn func() error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.invariants = append(e.invariants, &invariant{
+		name:   name,
+		check:  fn,
+		result: InvariantResult{Name: name},
+	})
+}
+
+// checkInvariants checks the registered invariants after a tick (must
+// hold e.processing)
+func (e *Engine) checkInvariants() {
+	e.mu.RLock()
+	invariants := e.invariants
+	e.mu.RUnlock()
+
+	pause := false
+	for _, inv := range invariants {
+		// Without the lock: checks read the nodes, which may call back
+		err := inv.check()
+
+		e.mu.Lock()
+		newly := err != nil && !inv.violated
+		inv.violated = err != nil
+		if newly {
+			if inv.result.Violations == 0 {
+				inv.result.Detail = err.Error()
+				inv.result.AtNs = int64(e.Elapsed())
+			}
+			inv.result.Violations++
+		}
+		e.mu.Unlock()
+
+		if !newly {
+			continue
+		}
+		pause = pause || e.config.PauseOnViolation
+		if e.emitter != nil {
+			e.emitter.Emit("invariant_violated", map[string]interface{}{
+				"invariant": inv.name,
+				"detail":    err.Error(),
+				"elapsedMs": e.Elapsed().Milliseconds(),
+			})
+		}
+	}
+	if pause {
+		e.Pause()
+	}
+}
+
+// InvariantResults returns what became of the invariants registered so
+// far, in the order they were
+func (e *Engine) InvariantResults() []InvariantResult {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	results := make([]InvariantResult, len(e.invariants))
+	for i, inv := range e.invariants {
+		results[i] = inv.result
+	}
+	return results
+}
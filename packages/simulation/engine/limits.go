@@ -0,0 +1,33 @@
+package engine
+
+// checkLimits counts a tick and, once the run has reached MaxTicks or
+// MaxVirtualDuration, emits a "simulation_timed_out" event and stops it.
+// It tells whether it did (must hold e.processing).
+func (e *Engine) checkLimits() bool {
+	e.mu.Lock()
+	e.ticks++
+	ticks := e.ticks
+	e.mu.Unlock()
+
+	limit := ""
+	switch {
+	case e.config.MaxTicks > 0 && ticks >= e.config.MaxTicks:
+		limit = "max_ticks"
+	case e.config.MaxVirtualDuration > 0 && e.Elapsed() >= e.config.MaxVirtualDuration:
+		limit = "max_virtual_duration"
+	default:
+		return false
+	}
+
+	e.endCompletion("timeout")
+	if e.emitter != nil {
+		e.emitter.Emit("simulation_timed_out", map[string]interface{}{
+			"limit":     limit,
+			"ticks":     ticks,
+			"elapsedMs": e.Elapsed().Milliseconds(),
+			"seed":      e.config.Seed,
+		})
+	}
+	e.Stop()
+	return true
+}
@@ -0,0 +1,187 @@
+package engine
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// metricsSlowestNodes is how many of the nodes slowest to tick the
+// metrics list
+const metricsSlowestNodes = 5
+
+// metrics measures how long the engine takes to run the simulation, over
+// wall time, between two "engine_metrics" events
+type metrics struct {
+	mu sync.Mutex
+
+	since    time.Time
+	ticks    int
+	tickTime time.Duration
+	tickMax  time.Duration
+	nodes    map[string]*nodeTime
+	lag      time.Duration // Of the last event run in realtime mode
+	lagMax   time.Duration
+}
+
+// nodeTime is the wall time a node spent in Tick()
+type nodeTime struct {
+	ticks int
+	total time.Duration
+	max   time.Duration
+}
+
+// NodeTickTime is how long a node took to tick, in the "slowestNodes" of
+// an "engine_metrics" event
+type NodeTickTime struct {
+	NodeID string
+	AvgUs  int64
+	MaxUs  int64
+}
+
+func newMetrics() *metrics {
+	return &metrics{since: time.Now(), nodes: make(map[string]*nodeTime)}
+}
+
+// noteNode adds a call to a node's Tick() that took d
+func (m *metrics) noteNode(nodeID string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, ok := m.nodes[nodeID]
+	if !ok {
+		n = &nodeTime{}
+		m.nodes[nodeID] = n
+	}
+	n.ticks++
+	n.total += d
+	if d > n.max {
+		n.max = d
+	}
+}
+
+// noteTick adds a tick that took d
+func (m *metrics) noteTick(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ticks++
+	m.tickTime += d
+	if d > m.tickMax {
+		m.tickMax = d
+	}
+}
+
+// noteLag records how late after its wall time an event ran
+func (m *metrics) noteLag(lag time.Duration) {
+	if lag < 0 {
+		lag = 0
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lag = lag
+	if lag > m.lagMax {
+		m.lagMax = lag
+	}
+}
+
+// due tells whether interval has passed since the metrics were last
+// reported
+func (m *metrics) due(interval time.Duration) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return time.Since(m.since) >= interval
+}
+
+// report returns the metrics since the last report as the data of an
+// "engine_metrics" event, and starts over
+func (m *metrics) report() map[string]interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ids := make([]string, 0, len(m.nodes))
+	for id := range m.nodes {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := m.nodes[ids[i]], m.nodes[ids[j]]
+		if a.total/time.Duration(a.ticks) != b.total/time.Duration(b.ticks) {
+			return a.total/time.Duration(a.ticks) > b.total/time.Duration(b.ticks)
+		}
+		return ids[i] < ids[j]
+	})
+	if len(ids) > metricsSlowestNodes {
+		ids = ids[:metricsSlowestNodes]
+	}
+	slowest := make([]NodeTickTime, len(ids))
+	for i, id := range ids {
+		n := m.nodes[id]
+		slowest[i] = NodeTickTime{
+			NodeID: id,
+			AvgUs:  (n.total / time.Duration(n.ticks)).Microseconds(),
+			MaxUs:  n.max.Microseconds(),
+		}
+	}
+
+	var tickAvg time.Duration
+	if m.ticks > 0 {
+		tickAvg = m.tickTime / time.Duration(m.ticks)
+	}
+	data := map[string]interface{}{
+		"intervalMs":   time.Since(m.since).Milliseconds(),
+		"ticks":        m.ticks,
+		"tickAvgUs":    tickAvg.Microseconds(),
+		"tickMaxUs":    m.tickMax.Microseconds(),
+		"lagMs":        m.lag.Milliseconds(),
+		"maxLagMs":     m.lagMax.Milliseconds(),
+		"slowestNodes": slowest,
+	}
+
+	m.since = time.Now()
+	m.ticks, m.tickTime, m.tickMax, m.lagMax = 0, 0, 0, 0
+	m.nodes = make(map[string]*nodeTime)
+	return data
+}
+
+// tickNode ticks a node, timing it when the engine measures itself
+func (e *Engine) tickNode(node NodeController) {
+	if e.metrics == nil {
+		node.Tick()
+		return
+	}
+	began := time.Now()
+	node.Tick()
+	e.metrics.noteNode(node.ID(), time.Since(began))
+}
+
+// reportMetrics adds a tick that began at began and, once per
+// MetricsInterval of wall time, emits an "engine_metrics" event: how long
+// ticks and the nodes' Tick() took, how late realtime mode runs events
+// and how many are overdue
+func (e *Engine) reportMetrics(began time.Time) {
+	if e.metrics == nil {
+		return
+	}
+	e.metrics.noteTick(time.Since(began))
+	if !e.metrics.due(e.config.MetricsInterval) {
+		return
+	}
+	data := e.metrics.report()
+
+	e.mu.RLock()
+	backlog := 0
+	if e.mode == ModeRealtime {
+		now := e.paceVirtual + time.Duration(float64(time.Since(e.paceWall))*e.speed)
+		for _, ev := range e.queue {
+			if ev.at <= now {
+				backlog++
+			}
+		}
+	}
+	data["backlog"] = backlog
+	data["speed"] = e.speed
+	data["nodes"] = len(e.nodes)
+	e.mu.RUnlock()
+
+	if e.emitter != nil {
+		e.emitter.Emit("engine_metrics", data)
+	}
+}
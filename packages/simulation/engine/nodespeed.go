@@ -0,0 +1,123 @@
+package engine
+
+import "time"
+
+// maxTicksPerTick bounds how many times a fast node ticks in a row
+const maxTicksPerTick = 10
+
+// MaxTimeDilation is the fastest time passes for a node, see
+// SetTimeDilation
+const MaxTimeDilation = maxTicksPerTick
+
+// nodeClock is the own tick rate of a node that does not tick in lockstep
+// with the others
+type nodeClock struct {
+	rate    time.Duration
+	due     time.Duration // Virtual time of its next tick
+	stopped bool          // Time stands still for the node: it does not tick
+}
+
+// SetNodeTickRate makes a node tick every rate of virtual time instead of
+// at every tick of the run, and returns the rate it had (0 = in lockstep).
+// Its ticks still fall on those of the run: a slower node sits some of
+// them out, a faster one ticks several times in a row. A rate of 0 puts
+// the node back in lockstep.
+func (e *Engine) SetNodeTickRate(nodeID string, rate time.Duration) time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	previous := e.nodeTickRate(nodeID)
+	e.setNodeTickRate(nodeID, rate)
+	return previous
+}
+
+// setNodeTickRate sets the rate a node ticks at (must hold e.mu)
+func (e *Engine) setNodeTickRate(nodeID string, rate time.Duration) {
+	if rate <= 0 || rate == e.config.TickRate {
+		delete(e.nodeClocks, nodeID)
+		return
+	}
+	if fastest := e.config.TickRate / maxTicksPerTick; rate < fastest {
+		rate = fastest
+	}
+	if e.nodeClocks == nil {
+		e.nodeClocks = make(map[string]*nodeClock)
+	}
+	e.nodeClocks[nodeID] = &nodeClock{rate: rate, due: e.Elapsed() + rate}
+}
+
+// SetTimeDilation makes time pass factor times as fast for a node as for
+// the rest of the run, through its ticks, which its timers count: 0.5 runs
+// it at half speed, like an overloaded machine, 2 at twice the speed, and
+// 0 stops it, like a GC pause, until its dilation is set again. Messages
+// sent to a stopped node wait for it. A factor of 1 puts the node back in
+// lockstep. It returns the factor it had.
+func (e *Engine) SetTimeDilation(nodeID string, factor float64) float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	previous := e.timeDilation(nodeID)
+	if factor <= 0 {
+		if e.nodeClocks == nil {
+			e.nodeClocks = make(map[string]*nodeClock)
+		}
+		e.nodeClocks[nodeID] = &nodeClock{stopped: true}
+		return previous
+	}
+	e.setNodeTickRate(nodeID, time.Duration(float64(e.config.TickRate)/factor))
+	return previous
+}
+
+// TimeDilation returns how many times as fast time passes for a node as
+// for the run: 1 in lockstep, 0 when stopped
+func (e *Engine) TimeDilation(nodeID string) float64 {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.timeDilation(nodeID)
+}
+
+// timeDilation returns the time dilation of a node (must hold e.mu)
+func (e *Engine) timeDilation(nodeID string) float64 {
+	clock, ok := e.nodeClocks[nodeID]
+	switch {
+	case !ok:
+		return 1
+	case clock.stopped:
+		return 0
+	default:
+		return float64(e.config.TickRate) / float64(clock.rate)
+	}
+}
+
+// NodeTickRate returns the rate a node ticks at, 0 when in lockstep or
+// stopped
+func (e *Engine) NodeTickRate(nodeID string) time.Duration {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.nodeTickRate(nodeID)
+}
+
+// nodeTickRate returns the rate a node ticks at (must hold e.mu)
+func (e *Engine) nodeTickRate(nodeID string) time.Duration {
+	if clock, ok := e.nodeClocks[nodeID]; ok {
+		return clock.rate
+	}
+	return 0
+}
+
+// ticksDue returns how many times a node ticks at the current tick of the
+// run, and moves its clock past them
+func (e *Engine) ticksDue(nodeID string) int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	clock, ok := e.nodeClocks[nodeID]
+	if !ok {
+		return 1
+	}
+	if clock.stopped {
+		return 0
+	}
+	ticks := 0
+	for now := e.Elapsed(); clock.due <= now; clock.due += clock.rate {
+		ticks++
+	}
+	return ticks
+}
@@ -0,0 +1,43 @@
+package engine
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// tickNodes ticks the nodes for a tick of the run, in order, or at once on
+// ParallelTicks goroutines: the tick is over when every node has ticked
+func (e *Engine) tickNodes(nodes []NodeController) {
+	due := make([]int, len(nodes))
+	for i, node := range nodes {
+		due[i] = e.ticksDue(node.ID())
+	}
+
+	workers := e.config.ParallelTicks
+	if workers > len(nodes) {
+		workers = len(nodes)
+	}
+	if workers <= 1 {
+		for i, node := range nodes {
+			for n := due[i]; n > 0; n-- {
+				e.tickNode(node)
+			}
+		}
+		return
+	}
+
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := int(next.Add(1) - 1); i < len(nodes); i = int(next.Add(1) - 1) {
+				for n := due[i]; n > 0; n-- {
+					e.tickNode(nodes[i])
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,62 @@
+package engine
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// PendingEvent is an event waiting to run
+type PendingEvent struct {
+	Seq   uint64 `json:"seq"`
+	AtNs  int64  `json:"atNs"` // Virtual time it is due at
+	Label string `json:"label"`
+}
+
+// Pending returns the events waiting to run, in the order they would
+func (e *Engine) Pending() []PendingEvent {
+	e.mu.RLock()
+	queue := append(eventQueue(nil), e.queue...)
+	e.mu.RUnlock()
+	sort.Sort(queue)
+
+	pending := make([]PendingEvent, len(queue))
+	for i, ev := range queue {
+		pending[i] = PendingEvent{Seq: ev.seq, AtNs: int64(ev.at), Label: ev.label}
+	}
+	return pending
+}
+
+// RunPending runs the waiting event scheduled as seq, in any mode: the
+// next one at its virtual time, any other at once, ahead of those due
+// before it, as if it had been scheduled sooner. It returns false when
+// no such event waits.
+func (e *Engine) RunPending(seq uint64) bool {
+	e.processing.Lock()
+	e.mu.Lock()
+	index := -1
+	for i, ev := range e.queue {
+		if ev.seq == seq {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		e.mu.Unlock()
+		e.processing.Unlock()
+		return false
+	}
+	ev := heap.Remove(&e.queue, index).(*event)
+	if index != 0 {
+		ev.at = e.Elapsed()
+	}
+	e.mu.Unlock()
+
+	e.runEvent(ev)
+	e.processing.Unlock()
+
+	e.mu.Lock()
+	e.pace()
+	e.mu.Unlock()
+	e.wakeUp()
+	return true
+}
@@ -0,0 +1,143 @@
+package engine
+
+import (
+	"container/heap"
+	"strings"
+	"time"
+)
+
+// fuzzSalt derives the seed of the schedule's random numbers from the
+// run's, apart from those the nodes draw
+const fuzzSalt = 0x5eed5c4ed
+
+// event is something that happens at a point of virtual time: a tick of
+// the nodes, a message delivery, a timer
+type event struct {
+	at    time.Duration // Virtual time since the start of the run
+	seq   uint64        // Order of scheduling, to break ties
+	label string        // What it is, for traces
+	fn    func()
+}
+
+// eventQueue orders events by virtual time, and events due at the same
+// time in the order they were scheduled
+type eventQueue []*event
+
+func (q eventQueue) Len() int { return len(q) }
+
+func (q eventQueue) Less(i, j int) bool {
+	if q[i].at != q[j].at {
+		return q[i].at < q[j].at
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q eventQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *eventQueue) Push(x interface{}) { *q = append(*q, x.(*event)) }
+
+func (q *eventQueue) Pop() interface{} {
+	old := *q
+	ev := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return ev
+}
+
+// After schedules fn to run once delay of virtual time has passed. Events
+// run one at a time on the engine's loop, so fn must not block.
+func (e *Engine) After(delay time.Duration, fn func()) {
+	e.AfterLabeled(delay, "timer", fn)
+}
+
+// AfterLabeled is After for an event that traces show as label
+func (e *Engine) AfterLabeled(delay time.Duration, label string, fn func()) {
+	e.schedule(delay, label, "", fn)
+}
+
+// AfterInOrder is AfterLabeled for a delivery on a link that delivers
+// messages in the order they were sent: fuzzing never moves it ahead of
+// the one scheduled before it on the same link
+func (e *Engine) AfterInOrder(delay time.Duration, label, link string, fn func()) {
+	e.schedule(delay, label, link, fn)
+}
+
+// schedule queues an event, behind the last one on its ordered link, if
+// any
+func (e *Engine) schedule(delay time.Duration, label, link string, fn func()) {
+	if delay < 0 {
+		delay = 0
+	}
+	if e.fuzz != nil && strings.HasPrefix(label, "deliver ") {
+		delay += time.Duration(e.fuzz.Int63n(int64(e.config.FuzzWindow)))
+	}
+	e.mu.Lock()
+	at := e.Elapsed() + delay
+	if link != "" {
+		if last, ok := e.inOrder[link]; ok && at < last {
+			at = last
+		}
+		if e.inOrder == nil {
+			e.inOrder = make(map[string]time.Duration)
+		}
+		e.inOrder[link] = at
+	}
+	e.seq++
+	heap.Push(&e.queue, &event{at: at, seq: e.seq, label: label, fn: fn})
+	e.mu.Unlock()
+
+	e.wakeUp()
+}
+
+// wakeUp interrupts the loop waiting for the next event, e.g. because an
+// earlier one was scheduled or the mode changed
+func (e *Engine) wakeUp() {
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next returns when the next event is due, if any
+func (e *Engine) next() (time.Duration, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if len(e.queue) == 0 {
+		return 0, false
+	}
+	return e.queue[0].at, true
+}
+
+// runEvent runs an event at its virtual time (must hold e.processing)
+func (e *Engine) runEvent(ev *event) {
+	e.setElapsed(ev.at)
+	ev.fn()
+	index := e.executed.Add(1) - 1
+	e.traceEvent(index, ev)
+}
+
+// advance runs, in order, every event due up to the virtual time until,
+// then sets the clock to it
+func (e *Engine) advance(until time.Duration) {
+	e.processing.Lock()
+	defer e.processing.Unlock()
+
+	for {
+		e.mu.Lock()
+		if e.ctx != nil && e.ctx.Err() != nil {
+			e.mu.Unlock()
+			return // Stopped on the way, e.g. by a limit of the run
+		}
+		if len(e.queue) == 0 || e.queue[0].at > until {
+			e.mu.Unlock()
+			break
+		}
+		ev := heap.Pop(&e.queue).(*event)
+		e.mu.Unlock()
+
+		e.runEvent(ev)
+	}
+	if until > e.Elapsed() {
+		e.setElapsed(until)
+	}
+}
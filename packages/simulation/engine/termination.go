@@ -0,0 +1,44 @@
+package engine
+
+// SetTermination checks done after every tick until the run is over: the
+// run is then paused and a "simulation_completed" event emitted with the
+// outcome done described. A run to completion under way ends with reason
+// "done". done runs on the engine's loop, so it must not block.
+func (e *Engine) SetTermination(done func() (outcome string, over bool)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.termination = done
+}
+
+// checkTermination ends the run if it is over, once (must hold
+// e.processing)
+func (e *Engine) checkTermination() {
+	e.mu.RLock()
+	done := e.termination
+	e.mu.RUnlock()
+	if done == nil {
+		return
+	}
+	// Without the lock: the check reads the nodes, which may call back
+	outcome, over := done()
+	if !over {
+		return
+	}
+
+	e.mu.Lock()
+	e.termination = nil
+	if e.completion != nil {
+		e.completion.reason = "done"
+	}
+	e.mu.Unlock()
+
+	e.Pause()
+	if e.emitter != nil {
+		e.emitter.Emit("simulation_completed", map[string]interface{}{
+			"outcome":   outcome,
+			"elapsedMs": e.Elapsed().Milliseconds(),
+			"rounds":    int(e.Elapsed() / e.config.TickRate),
+			"seed":      e.config.Seed,
+		})
+	}
+}
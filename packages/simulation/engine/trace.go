@@ -0,0 +1,118 @@
+package engine
+
+import (
+	"container/heap"
+	"sync"
+)
+
+// maxTraceEntries bounds a trace: a longer run keeps its first events
+const maxTraceEntries = 200000
+
+// TraceEntry is an event the engine ran, with the random numbers drawn
+// up to it. Runs with the same seed and the same inputs at the same
+// events have the same entries.
+type TraceEntry struct {
+	Index  uint64 `json:"index"` // Events run before it
+	Seq    uint64 `json:"seq"`   // Order it was scheduled in, which picks it among those pending
+	AtNs   int64  `json:"atNs"`  // Virtual time since the start of the run
+	Label  string `json:"label"` // "tick", "timer" or what scheduled it
+	Draws  uint64 `json:"draws"`
+	Digest uint64 `json:"digest"`
+}
+
+// Divergence is the first event of a replay that differs from its trace
+type Divergence struct {
+	Expected TraceEntry `json:"expected"`
+	Actual   TraceEntry `json:"actual"`
+}
+
+// trace records the events run, and checks them against those of another
+// run when replaying it
+type trace struct {
+	mu sync.Mutex
+
+	entries    []TraceEntry
+	truncated  bool
+	expected   []TraceEntry
+	divergence *Divergence
+}
+
+// traceEvent records an event that just ran (must hold e.processing)
+func (e *Engine) traceEvent(index uint64, ev *event) {
+	draws, digest := e.source.drawn()
+	entry := TraceEntry{
+		Index:  index,
+		Seq:    ev.seq,
+		AtNs:   int64(ev.at),
+		Label:  ev.label,
+		Draws:  draws,
+		Digest: digest,
+	}
+
+	t := e.trace
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.entries) < maxTraceEntries {
+		t.entries = append(t.entries, entry)
+	} else {
+		t.truncated = true
+	}
+	if t.divergence == nil && index < uint64(len(t.expected)) && t.expected[index] != entry {
+		t.divergence = &Divergence{Expected: t.expected[index], Actual: entry}
+	}
+}
+
+// Trace returns the events run so far, and whether the trace left some
+// out
+func (e *Engine) Trace() ([]TraceEntry, bool) {
+	e.trace.mu.Lock()
+	defer e.trace.mu.Unlock()
+	return append([]TraceEntry(nil), e.trace.entries...), e.trace.truncated
+}
+
+// ReplayTrace checks every event run from now on against the trace of
+// the run being replayed
+func (e *Engine) ReplayTrace(expected []TraceEntry) {
+	e.trace.mu.Lock()
+	defer e.trace.mu.Unlock()
+	e.trace.expected = expected
+	e.trace.divergence = nil
+}
+
+// Divergence returns the first event of a replay that differed from its
+// trace, if any
+func (e *Engine) Divergence() *Divergence {
+	e.trace.mu.Lock()
+	defer e.trace.mu.Unlock()
+	return e.trace.divergence
+}
+
+// Executed returns how many events ran so far
+func (e *Engine) Executed() uint64 {
+	return e.executed.Load()
+}
+
+// RunEvents runs the next n events, whatever their virtual time, in any
+// mode, and returns how many there were
+func (e *Engine) RunEvents(n uint64) uint64 {
+	e.processing.Lock()
+	ran := uint64(0)
+	for ; ran < n; ran++ {
+		e.mu.Lock()
+		if len(e.queue) == 0 {
+			e.mu.Unlock()
+			break
+		}
+		ev := heap.Pop(&e.queue).(*event)
+		e.mu.Unlock()
+
+		e.runEvent(ev)
+	}
+	e.processing.Unlock()
+
+	e.mu.Lock()
+	e.pace()
+	e.mu.Unlock()
+	e.wakeUp()
+	return ran
+}